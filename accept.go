@@ -0,0 +1,87 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+)
+
+// acceptBoth is the Accept header of requests by default
+const acceptBoth = "application/json, text/event-stream"
+
+// AcceptMode chooses the Accept header of paid retries
+type AcceptMode int
+
+const (
+	AcceptBoth   AcceptMode = iota // "application/json, text/event-stream" (the default)
+	AcceptJSON                     // "application/json" only
+	AcceptSSE                      // "text/event-stream" only
+	AcceptCaller                   // The Accept of the unpaid request, see WithAcceptHeader
+)
+
+// String returns "both", "json", "sse" or "caller"
+func (m AcceptMode) String() string {
+	switch m {
+	case AcceptBoth:
+		return "both"
+	case AcceptJSON:
+		return "json"
+	case AcceptSSE:
+		return "sse"
+	case AcceptCaller:
+		return "caller"
+	}
+	return fmt.Sprintf("AcceptMode(%d)", int(m))
+}
+
+// valid reports whether m is a known mode
+func (m AcceptMode) valid() bool {
+	return m >= AcceptBoth && m <= AcceptCaller
+}
+
+type acceptHeaderKey struct{}
+
+// WithAcceptHeader sets the Accept header of requests sent with ctx, instead of
+// "application/json, text/event-stream". Paid retries keep it with AcceptCaller.
+func WithAcceptHeader(ctx context.Context, accept string) context.Context {
+	return context.WithValue(ctx, acceptHeaderKey{}, accept)
+}
+
+// AcceptHeaderFromContext returns the Accept header set with WithAcceptHeader
+func AcceptHeaderFromContext(ctx context.Context) string {
+	accept, _ := ctx.Value(acceptHeaderKey{}).(string)
+	return accept
+}
+
+type paidAcceptKey struct{}
+
+// WithPaidAccept overrides Config.PaidAccept for the paid retries of requests
+// sent with ctx
+func WithPaidAccept(ctx context.Context, mode AcceptMode) context.Context {
+	return context.WithValue(ctx, paidAcceptKey{}, mode)
+}
+
+// requestAccept returns the Accept header of the unpaid request
+func requestAccept(ctx context.Context) string {
+	if accept := AcceptHeaderFromContext(ctx); accept != "" {
+		return accept
+	}
+	return acceptBoth
+}
+
+// paidAccept returns the Accept header of a paid retry
+func (t *X402Transport) paidAccept(ctx context.Context) string {
+	mode := t.paidAcceptMode
+	if override, ok := ctx.Value(paidAcceptKey{}).(AcceptMode); ok && override.valid() {
+		mode = override
+	}
+	switch mode {
+	case AcceptJSON:
+		return "application/json"
+	case AcceptSSE:
+		return "text/event-stream"
+	case AcceptCaller:
+		return requestAccept(ctx)
+	default:
+		return acceptBoth
+	}
+}
@@ -0,0 +1,44 @@
+package x402
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HeaderAccessPass carries a server-issued access pass: in the response to a paid
+// session access fee, and in later requests so that new sessions (e.g. after a
+// client restart) skip paying the fee again
+const HeaderAccessPass = "X-ACCESS-PASS"
+
+// AccessPassClaims are the claims of an access pass. A pass is the base64url
+// encoded JSON claims and the server's base64url encoded MAC, joined by a dot.
+type AccessPassClaims struct {
+	Payer   string `json:"payer"`
+	Expires int64  `json:"exp"`
+}
+
+// ExpiresAt returns the expiry as a time
+func (c *AccessPassClaims) ExpiresAt() time.Time {
+	return time.Unix(c.Expires, 0)
+}
+
+// ParseAccessPassClaims reads the claims of an access pass without verifying its
+// MAC, which only the issuing server can do
+func ParseAccessPassClaims(pass string) (*AccessPassClaims, error) {
+	payload, _, ok := strings.Cut(pass, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed access pass")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed access pass: %w", err)
+	}
+	var claims AccessPassClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, fmt.Errorf("malformed access pass: %w", err)
+	}
+	return &claims, nil
+}
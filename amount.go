@@ -0,0 +1,112 @@
+package x402
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// RoundingMode is how AmountPolicy.Round moves amounts onto the granularity
+type RoundingMode string
+
+// Rounding modes
+const (
+	RoundUp      RoundingMode = "up"
+	RoundDown    RoundingMode = "down"
+	RoundNearest RoundingMode = "nearest"
+)
+
+// AmountPolicy constrains the amounts of a payment option, e.g. to avoid dust
+// amounts some facilitators reject. Amounts are in the asset's base units.
+type AmountPolicy struct {
+	// Granularity, if set, requires amounts to be multiples of it (e.g. "1000")
+	Granularity string
+
+	// Minimum, if set, is the smallest valid amount
+	Minimum string
+
+	// Rounding is how Round moves amounts onto the granularity (RoundUp when empty)
+	Rounding RoundingMode
+}
+
+// Validate checks that amount is a positive multiple of the granularity and at
+// least the minimum
+func (p *AmountPolicy) Validate(amount string) error {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok || value.Sign() <= 0 {
+		return fmt.Errorf("%w: %s is not a positive integer", ErrInvalidAmount, amount)
+	}
+	granularity, minimum, err := p.parse()
+	if err != nil {
+		return err
+	}
+
+	if granularity != nil && new(big.Int).Mod(value, granularity).Sign() != 0 {
+		return fmt.Errorf("%w: %s is not a multiple of %s", ErrInvalidAmount, amount, granularity)
+	}
+	if minimum != nil && value.Cmp(minimum) < 0 {
+		return fmt.Errorf("%w: %s is below the minimum of %s", ErrInvalidAmount, amount, minimum)
+	}
+	return nil
+}
+
+// Round returns amount moved onto the granularity with the rounding mode and
+// raised to the minimum. Amounts never round down to zero.
+func (p *AmountPolicy) Round(amount string) (string, error) {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok || value.Sign() <= 0 {
+		return "", fmt.Errorf("%w: %s is not a positive integer", ErrInvalidAmount, amount)
+	}
+	granularity, minimum, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+
+	if granularity != nil {
+		quotient, remainder := new(big.Int).QuoRem(value, granularity, new(big.Int))
+		if remainder.Sign() != 0 {
+			switch p.Rounding {
+			case RoundDown:
+			case RoundNearest:
+				if new(big.Int).Lsh(remainder, 1).Cmp(granularity) >= 0 {
+					quotient.Add(quotient, big.NewInt(1))
+				}
+			default:
+				quotient.Add(quotient, big.NewInt(1))
+			}
+		}
+		if quotient.Sign() == 0 {
+			quotient.SetInt64(1)
+		}
+		value = quotient.Mul(quotient, granularity)
+	}
+
+	if minimum != nil && value.Cmp(minimum) < 0 {
+		value = new(big.Int).Set(minimum)
+		if granularity != nil {
+			// Round the minimum itself up onto the granularity
+			if remainder := new(big.Int).Mod(value, granularity); remainder.Sign() != 0 {
+				value.Add(value, new(big.Int).Sub(granularity, remainder))
+			}
+		}
+	}
+	return value.String(), nil
+}
+
+// parse returns the granularity and minimum, nil when unset
+func (p *AmountPolicy) parse() (granularity, minimum *big.Int, err error) {
+	if p.Granularity != "" {
+		g, ok := new(big.Int).SetString(p.Granularity, 10)
+		if !ok || g.Sign() <= 0 {
+			return nil, nil, fmt.Errorf("invalid amount granularity: %s", p.Granularity)
+		}
+		granularity = g
+	}
+	if p.Minimum != "" {
+		m, ok := new(big.Int).SetString(p.Minimum, 10)
+		if !ok || m.Sign() < 0 {
+			return nil, nil, fmt.Errorf("invalid minimum amount: %s", p.Minimum)
+		}
+		minimum = m
+	}
+	return granularity, minimum, nil
+}
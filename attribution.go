@@ -0,0 +1,145 @@
+package x402
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type attributionKey struct{}
+
+// WithAttribution tags payments made with ctx with caller-provided dimensions
+// (e.g. {"agent": "planner", "run": "123"}). The tags appear in PaymentEvent.Attribution
+// so multi-tenant hosts can split costs across users and runs. Nested calls merge
+// their dimensions, with inner values taking precedence.
+func WithAttribution(ctx context.Context, dimensions map[string]string) context.Context {
+	merged := make(map[string]string, len(dimensions))
+	for k, v := range AttributionFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range dimensions {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, attributionKey{}, merged)
+}
+
+// AttributionFromContext returns a copy of the dimensions set by WithAttribution
+func AttributionFromContext(ctx context.Context) map[string]string {
+	dimensions, _ := ctx.Value(attributionKey{}).(map[string]string)
+	if dimensions == nil {
+		return nil
+	}
+	out := make(map[string]string, len(dimensions))
+	for k, v := range dimensions {
+		out[k] = v
+	}
+	return out
+}
+
+// PaymentMetrics totals the successful payments of a transport and its sessions
+type PaymentMetrics struct {
+	AttributedTotals
+
+	// ByDimension splits the totals by attribution dimension and value, e.g.
+	// ByDimension["agent"]["planner"]. Payments without a dimension count under "".
+	ByDimension map[string]map[string]AttributedTotals
+}
+
+// AttributedTotals is the number and amounts of payments, per asset in its base units
+type AttributedTotals struct {
+	Payments int
+	Amounts  map[AssetKey]*big.Int
+}
+
+// add counts a payment of amount in asset
+func (a *AttributedTotals) add(key AssetKey, amount *big.Int) {
+	a.Payments++
+	a.addAmount(key, amount)
+}
+
+// merge adds other's payments
+func (a *AttributedTotals) merge(other AttributedTotals) {
+	a.Payments += other.Payments
+	for key, amount := range other.Amounts {
+		a.addAmount(key, amount)
+	}
+}
+
+func (a *AttributedTotals) addAmount(key AssetKey, amount *big.Int) {
+	if a.Amounts == nil {
+		a.Amounts = make(map[AssetKey]*big.Int)
+	}
+	if a.Amounts[key] == nil {
+		a.Amounts[key] = new(big.Int)
+	}
+	a.Amounts[key].Add(a.Amounts[key], amount)
+}
+
+// paymentMetrics totals successful payments by their attribution
+type paymentMetrics struct {
+	mu     sync.Mutex
+	groups map[string]*attributedGroup // By canonical attribution
+}
+
+// attributedGroup totals the payments sharing one attribution
+type attributedGroup struct {
+	attribution map[string]string
+	totals      AttributedTotals
+}
+
+func newPaymentMetrics() *paymentMetrics {
+	return &paymentMetrics{groups: make(map[string]*attributedGroup)}
+}
+
+// record counts a successful payment
+func (m *paymentMetrics) record(event PaymentEvent) {
+	if event.Amount == nil {
+		return
+	}
+	dimensions := make([]string, 0, len(event.Attribution))
+	for dimension, value := range event.Attribution {
+		dimensions = append(dimensions, dimension+"="+value)
+	}
+	sort.Strings(dimensions)
+	key := strings.Join(dimensions, "\x00")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	group := m.groups[key]
+	if group == nil {
+		group = &attributedGroup{attribution: event.Attribution}
+		m.groups[key] = group
+	}
+	group.totals.add(newAssetKey(event.Network, event.Asset), event.Amount)
+}
+
+// snapshot returns the totals overall and per dimension
+func (m *paymentMetrics) snapshot() PaymentMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	metrics := PaymentMetrics{ByDimension: make(map[string]map[string]AttributedTotals)}
+	for _, group := range m.groups {
+		for dimension := range group.attribution {
+			metrics.ByDimension[dimension] = make(map[string]AttributedTotals)
+		}
+	}
+	for _, group := range m.groups {
+		metrics.merge(group.totals)
+		for dimension, values := range metrics.ByDimension {
+			value := group.attribution[dimension]
+			totals := values[value]
+			totals.merge(group.totals)
+			values[value] = totals
+		}
+	}
+	return metrics
+}
+
+// GetMetrics returns the totals of the successful payments made by the
+// transport and its sessions, split by the dimensions set with WithAttribution
+func (t *X402Transport) GetMetrics() PaymentMetrics {
+	return t.metrics.snapshot()
+}
@@ -0,0 +1,89 @@
+package x402
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MetaKeyBatch is the _meta key announcing the tool names of a batch to the server
+const MetaKeyBatch = "x402/batch"
+
+// MetaKeyBatchToken is the _meta key carrying the token that redeems a paid batch
+const MetaKeyBatchToken = "x402/batch-token"
+
+// ToolCaller calls MCP tools; *client.Client implements it
+type ToolCaller interface {
+	CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error)
+}
+
+// BatchResult is the outcome of one call in a batch
+type BatchResult struct {
+	Result *mcp.CallToolResult
+	Err    error
+}
+
+// CallToolsBatch calls several tools, paying once for the whole batch when the
+// server supports batch pricing. The first call announces the batch; if the server
+// answers with a batch price, the payment made for it returns a token that covers
+// the remaining calls, which then run concurrently. Servers without batch pricing
+// are paid per call as usual. Results are returned in the order of calls.
+func CallToolsBatch(ctx context.Context, caller ToolCaller, calls []mcp.CallToolRequest) []BatchResult {
+	results := make([]BatchResult, len(calls))
+	if len(calls) == 0 {
+		return results
+	}
+
+	names := make([]any, len(calls))
+	for i, call := range calls {
+		names[i] = call.Params.Name
+	}
+
+	first := withToolCallMeta(calls[0], MetaKeyBatch, names)
+	results[0].Result, results[0].Err = caller.CallTool(ctx, first)
+
+	token := batchToken(results[0].Result)
+
+	var wg sync.WaitGroup
+	for i := 1; i < len(calls); i++ {
+		call := calls[i]
+		if token != "" {
+			call = withToolCallMeta(call, MetaKeyBatchToken, token)
+		}
+
+		wg.Add(1)
+		go func(i int, call mcp.CallToolRequest) {
+			defer wg.Done()
+			results[i].Result, results[i].Err = caller.CallTool(ctx, call)
+		}(i, call)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// withToolCallMeta returns a copy of call with a _meta field set
+func withToolCallMeta(call mcp.CallToolRequest, key string, value any) mcp.CallToolRequest {
+	fields := make(map[string]any)
+	meta := &mcp.Meta{}
+	if call.Params.Meta != nil {
+		*meta = *call.Params.Meta
+		for k, v := range call.Params.Meta.AdditionalFields {
+			fields[k] = v
+		}
+	}
+	fields[key] = value
+	meta.AdditionalFields = fields
+	call.Params.Meta = meta
+	return call
+}
+
+// batchToken extracts the batch token the server returned with a paid batch call
+func batchToken(result *mcp.CallToolResult) string {
+	if result == nil || result.Meta == nil {
+		return ""
+	}
+	token, _ := result.Meta.AdditionalFields[MetaKeyBatchToken].(string)
+	return token
+}
@@ -0,0 +1,157 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MetaKeyBinding is the _meta key carrying the request binding salt
+const MetaKeyBinding = "x402/binding"
+
+// HeaderPaymentBinding carries the request binding salt for HTTP 402 transports
+const HeaderPaymentBinding = "X-PAYMENT-BINDING"
+
+// RequestBindingDigest computes a digest over a JSON-RPC method and its params.
+// The params "_meta" field is excluded so the payment itself does not affect the digest.
+func RequestBindingDigest(method string, params any) ([]byte, error) {
+	canonical, err := canonicalParams(params)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256([]byte(method), []byte{'\n'}, canonical), nil
+}
+
+// RequestBindingNonce derives the EIP-3009 nonce that commits to a request digest and salt
+func RequestBindingNonce(digest, salt []byte) string {
+	return "0x" + hex.EncodeToString(crypto.Keccak256(digest, salt))
+}
+
+// VerifyRequestBinding checks that nonce commits to the given method, params and hex-encoded salt
+func VerifyRequestBinding(method string, params any, saltHex, nonce string) error {
+	digest, err := RequestBindingDigest(method, params)
+	if err != nil {
+		return err
+	}
+	if err := VerifyPaymentBinding(digest, saltHex, nonce); err != nil {
+		return fmt.Errorf("%w to this request", err)
+	}
+	return nil
+}
+
+// ExtraResourceBinding is the requirement Extra key servers set to "required"
+// to ask clients to bind payment nonces to the server origin and resource
+const ExtraResourceBinding = "resourceBinding"
+
+// ResourceBindingDigest computes a digest over the origin of the server paid
+// (scheme://host[:port], compared case-insensitively) and the resource paid for.
+// A nonce committing to it can't be redeemed for another server or resource.
+func ResourceBindingDigest(origin, resource string) []byte {
+	origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+	return crypto.Keccak256([]byte("x402/resource"), []byte{'\n'}, []byte(origin), []byte{'\n'}, []byte(resource))
+}
+
+// VerifyResourceBinding checks that nonce commits to the given server origin,
+// resource and hex-encoded salt
+func VerifyResourceBinding(origin, resource, saltHex, nonce string) error {
+	if err := VerifyPaymentBinding(ResourceBindingDigest(origin, resource), saltHex, nonce); err != nil {
+		return fmt.Errorf("%w to %s on %s", err, resource, origin)
+	}
+	return nil
+}
+
+// PaymentBindingDigest combines the digests a payment nonce commits to: a
+// request digest, a resource digest, or both. Either may be nil.
+func PaymentBindingDigest(request, resource []byte) []byte {
+	switch {
+	case resource == nil:
+		return request
+	case request == nil:
+		return resource
+	}
+	return crypto.Keccak256(request, resource)
+}
+
+// VerifyPaymentBinding checks that nonce commits to digest and the hex-encoded salt
+func VerifyPaymentBinding(digest []byte, saltHex, nonce string) error {
+	salt, err := hex.DecodeString(strings.TrimPrefix(saltHex, "0x"))
+	if err != nil || len(salt) == 0 {
+		return fmt.Errorf("invalid binding salt")
+	}
+	if !strings.EqualFold(RequestBindingNonce(digest, salt), nonce) {
+		return fmt.Errorf("payment nonce is not bound")
+	}
+	return nil
+}
+
+// canonicalParams re-encodes params with sorted keys and without _meta
+func canonicalParams(params any) ([]byte, error) {
+	if params == nil {
+		return []byte("null"), nil
+	}
+
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal params: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, fmt.Errorf("failed to decode params: %w", err)
+	}
+
+	if m, ok := value.(map[string]any); ok {
+		delete(m, "_meta")
+	}
+
+	return json.Marshal(value)
+}
+
+// newPaymentBinding creates a random salt and the nonce binding it to digest
+func newPaymentBinding(digest []byte) (saltHex, nonce string, err error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return "", "", fmt.Errorf("failed to generate binding salt: %w", err)
+	}
+
+	return "0x" + hex.EncodeToString(salt), RequestBindingNonce(digest, salt), nil
+}
+
+type bindingNonceKey struct{}
+
+// WithBindingNonce returns a context instructing EVM signers to use the given
+// nonce instead of a generated one. Used for request-bound payments.
+func WithBindingNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, bindingNonceKey{}, nonce)
+}
+
+// BindingNonceFromContext returns the binding nonce set by WithBindingNonce, if any
+func BindingNonceFromContext(ctx context.Context) (string, bool) {
+	nonce, ok := ctx.Value(bindingNonceKey{}).(string)
+	return nonce, ok && nonce != ""
+}
+
+// requiresResourceBinding reports whether a server asks for payments to be bound
+// to its origin and the resource
+func requiresResourceBinding(accepts []PaymentRequirement) bool {
+	for _, req := range accepts {
+		if req.Extra[ExtraResourceBinding] == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// serverOrigin returns the scheme://host origin of a server URL
+func serverOrigin(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
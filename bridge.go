@@ -0,0 +1,195 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// BridgeRequest asks a bridge to deliver Amount of ToAsset on ToNetwork to Address,
+// paid from the same wallet's FromAsset on FromNetwork. Amounts are in the assets'
+// base units; bridged assets are expected to share decimals (e.g. USDC).
+type BridgeRequest struct {
+	FromNetwork string
+	FromAsset   string
+	ToNetwork   string
+	ToAsset     string
+	Address     string
+	Amount      *big.Int
+}
+
+// BridgeQuote is the cost of a bridge transfer
+type BridgeQuote struct {
+	// Fee is charged on top of the amount, in FromAsset base units
+	Fee *big.Int
+}
+
+// BridgeReceipt describes a completed bridge transfer
+type BridgeReceipt struct {
+	Transaction string
+	Delivered   *big.Int
+}
+
+// BridgeProvider moves funds between networks, e.g. over Circle's CCTP
+type BridgeProvider interface {
+	// Quote prices a bridge transfer without executing it
+	Quote(ctx context.Context, req BridgeRequest) (*BridgeQuote, error)
+
+	// Bridge executes the transfer and returns once the funds are spendable on ToNetwork
+	Bridge(ctx context.Context, req BridgeRequest) (*BridgeReceipt, error)
+}
+
+// BalanceFunc returns the balance of asset held by address on network, in base units
+type BalanceFunc func(ctx context.Context, network, asset, address string) (*big.Int, error)
+
+// BridgingConfig opts a payment handler into bridging funds across networks.
+// Before signing, the handler checks the payer's balance on the requirement's network;
+// on a shortfall it bridges the missing amount from the first source holding enough,
+// within the caps below. Signers must still have a payment option for the destination.
+type BridgingConfig struct {
+	// Provider executes bridge transfers
+	Provider BridgeProvider
+
+	// Balance looks up wallet balances; bridging is skipped when it fails
+	Balance BalanceFunc
+
+	// Sources lists the networks and assets funds may be bridged from, in order of preference
+	Sources []ClientPaymentOption
+
+	// MaxFee caps the fee of a single bridge transfer. Nil allows any fee.
+	MaxFee *big.Int
+
+	// Budget caps the total bridged (amounts plus fees) over the handler's lifetime.
+	// Nil means unlimited.
+	Budget *big.Int
+
+	// Approve, if set, must approve each bridge transfer
+	Approve func(req BridgeRequest, quote BridgeQuote) bool
+
+	// OnBridge, if set, is called after each completed bridge transfer
+	OnBridge func(req BridgeRequest, quote BridgeQuote, receipt *BridgeReceipt)
+}
+
+// bridger funds payments by bridging within a BridgingConfig's caps
+type bridger struct {
+	config *BridgingConfig
+
+	mu    sync.Mutex
+	spent *big.Int
+}
+
+func newBridger(config *BridgingConfig) *bridger {
+	if config == nil || config.Provider == nil || config.Balance == nil {
+		return nil
+	}
+	return &bridger{config: config, spent: new(big.Int)}
+}
+
+// ensureFunds bridges to the signer's wallet on the requirement's network whatever
+// it lacks to pay req. Without a bridger, or when balances are unknown, it does nothing.
+func (b *bridger) ensureFunds(ctx context.Context, signer PaymentSigner, req PaymentRequirement) error {
+	if b == nil {
+		return nil
+	}
+
+	amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	if !ok {
+		return fmt.Errorf("invalid payment amount: %s", req.MaxAmountRequired)
+	}
+
+	address := signer.GetAddress()
+	balance, err := b.config.Balance(ctx, req.Network, req.Asset, address)
+	if err != nil || balance.Cmp(amount) >= 0 {
+		return nil
+	}
+	shortfall := new(big.Int).Sub(amount, balance)
+
+	var lastErr error
+	for _, source := range b.config.Sources {
+		if source.Network == req.Network || !signer.HasAsset(source.Asset, source.Network) {
+			continue
+		}
+
+		bridgeReq := BridgeRequest{
+			FromNetwork: source.Network,
+			FromAsset:   source.Asset,
+			ToNetwork:   req.Network,
+			ToAsset:     req.Asset,
+			Address:     address,
+			Amount:      new(big.Int).Set(shortfall),
+		}
+		if err := b.bridge(ctx, bridgeReq); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no bridge source configured for this wallet")
+	}
+	return fmt.Errorf("%w: short %s on %s: %w", ErrInsufficientFunds, shortfall, req.Network, lastErr)
+}
+
+// bridge quotes, checks and executes one bridge transfer
+func (b *bridger) bridge(ctx context.Context, req BridgeRequest) error {
+	quote, err := b.config.Provider.Quote(ctx, req)
+	if err != nil {
+		return fmt.Errorf("quoting bridge from %s: %w", req.FromNetwork, err)
+	}
+	fee := quote.Fee
+	if fee == nil {
+		fee = new(big.Int)
+	}
+	if b.config.MaxFee != nil && fee.Cmp(b.config.MaxFee) > 0 {
+		return fmt.Errorf("%w: fee %s from %s exceeds %s", ErrBridgeBudgetExceeded, fee, req.FromNetwork, b.config.MaxFee)
+	}
+
+	cost := new(big.Int).Add(req.Amount, fee)
+	balance, err := b.config.Balance(ctx, req.FromNetwork, req.FromAsset, req.Address)
+	if err != nil {
+		return fmt.Errorf("checking balance on %s: %w", req.FromNetwork, err)
+	}
+	if balance.Cmp(cost) < 0 {
+		return fmt.Errorf("balance %s on %s does not cover %s", balance, req.FromNetwork, cost)
+	}
+
+	if b.config.Approve != nil && !b.config.Approve(req, *quote) {
+		return fmt.Errorf("bridge from %s declined", req.FromNetwork)
+	}
+
+	if err := b.reserve(cost); err != nil {
+		return err
+	}
+	receipt, err := b.config.Provider.Bridge(ctx, req)
+	if err != nil {
+		b.release(cost)
+		return fmt.Errorf("bridging from %s: %w", req.FromNetwork, err)
+	}
+
+	if b.config.OnBridge != nil {
+		b.config.OnBridge(req, *quote, receipt)
+	}
+	return nil
+}
+
+// reserve charges cost against the budget
+func (b *bridger) reserve(cost *big.Int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	spent := new(big.Int).Add(b.spent, cost)
+	if b.config.Budget != nil && spent.Cmp(b.config.Budget) > 0 {
+		return fmt.Errorf("%w: %s would exceed the budget of %s (%s spent)", ErrBridgeBudgetExceeded, cost, b.config.Budget, b.spent)
+	}
+	b.spent = spent
+	return nil
+}
+
+// release refunds a reservation whose transfer failed
+func (b *bridger) release(cost *big.Int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.spent.Sub(b.spent, cost)
+}
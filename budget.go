@@ -0,0 +1,434 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultBudgetWindow is the rolling period budget limits apply to
+const defaultBudgetWindow = time.Hour
+
+// AssetKey identifies an asset on a network
+type AssetKey struct {
+	Network string
+	Asset   string
+}
+
+// newAssetKey normalizes EVM asset addresses, which are case-insensitive
+func newAssetKey(network, asset string) AssetKey {
+	if strings.HasPrefix(asset, "0x") {
+		asset = strings.ToLower(asset)
+	}
+	return AssetKey{Network: network, Asset: asset}
+}
+
+// PriceOracle values asset amounts in a reference currency, so that one limit can
+// span assets with different decimals and networks
+type PriceOracle interface {
+	// Value returns what amount base units of asset on network are worth, in the
+	// reference currency's smallest unit (e.g. cents)
+	Value(ctx context.Context, network, asset string, amount *big.Int) (*big.Int, error)
+}
+
+// PriceOracleFunc adapts a function to the PriceOracle interface
+type PriceOracleFunc func(ctx context.Context, network, asset string, amount *big.Int) (*big.Int, error)
+
+// Value calls f
+func (f PriceOracleFunc) Value(ctx context.Context, network, asset string, amount *big.Int) (*big.Int, error) {
+	return f(ctx, network, asset, amount)
+}
+
+// BudgetLimits caps spending over a rolling window or a calendar period
+type BudgetLimits struct {
+	// PerAsset caps the amount spent per network and asset, in the asset's base units.
+	// Assets without a limit are only capped by Reference.
+	PerAsset map[AssetKey]*big.Int
+
+	// Reference caps the value spent across all assets, in the Oracle's reference unit
+	Reference *big.Int
+
+	// Oracle values spending for the Reference limit
+	Oracle PriceOracle
+
+	// Window is the rolling period limits apply to (one hour when zero)
+	Window time.Duration
+
+	// Period, if set, resets limits at the start of each calendar day, week or
+	// month in Location (UTC when nil) instead of applying them over Window
+	Period   BudgetPeriod
+	Location *time.Location
+
+	// OnPeriodEnd, if set, receives a report of the spending of each calendar
+	// period once it ends, on its own goroutine. Run the manager to have
+	// periods end on time; see Run.
+	OnPeriodEnd func(BudgetReport)
+
+	// Clock, if set, is the clock windows and periods go by (the system clock when nil)
+	Clock Clock
+
+	// PerMethod adds limits for payments made for requests of an MCP method
+	// ("tools/call", "resources/read", "prompts/get"), e.g. generous budgets for
+	// data reads but strict caps on tool calls. The method is read from the
+	// context of the payment, see WithRequestMethod.
+	PerMethod map[string]MethodLimits
+}
+
+// MethodLimits caps spending on requests of one MCP method
+type MethodLimits struct {
+	// PerRequest caps each payment, in the base units of the asset paid
+	PerRequest map[AssetKey]*big.Int
+
+	// PerAsset caps the amount spent on the method per network and asset
+	// over the budget window
+	PerAsset map[AssetKey]*big.Int
+}
+
+// normalized returns the limits with their asset keys normalized
+func (l MethodLimits) normalized() MethodLimits {
+	normalize := func(limits map[AssetKey]*big.Int) map[AssetKey]*big.Int {
+		out := make(map[AssetKey]*big.Int, len(limits))
+		for key, limit := range limits {
+			out[newAssetKey(key.Network, key.Asset)] = limit
+		}
+		return out
+	}
+	return MethodLimits{PerRequest: normalize(l.PerRequest), PerAsset: normalize(l.PerAsset)}
+}
+
+type requestMethodKey struct{}
+
+// WithRequestMethod records in ctx the MCP method of the request paid for
+// within it, which PerMethod budget limits apply to. X402Transport sets it for
+// the payments it makes; set it yourself when calling CreatePayment in manual
+// payment mode.
+func WithRequestMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, requestMethodKey{}, method)
+}
+
+// RequestMethodFromContext returns the method set with WithRequestMethod
+func RequestMethodFromContext(ctx context.Context) string {
+	method, _ := ctx.Value(requestMethodKey{}).(string)
+	return method
+}
+
+// budgetSpend is one recorded payment
+type budgetSpend struct {
+	at     time.Time
+	key    AssetKey
+	method string
+	tool   string
+	amount *big.Int
+	value  *big.Int // In the reference unit; nil without an oracle
+}
+
+// BudgetManager enforces BudgetLimits, tracking spending per network and asset
+// so that amounts of different assets are never added together
+type BudgetManager struct {
+	limits BudgetLimits
+	now    func() time.Time
+
+	mu       sync.Mutex
+	spends   []budgetSpend
+	reserved map[*BudgetReservation]budgetSpend
+
+	// Bounds of the current calendar period, zero until the first check
+	periodStart time.Time
+	periodEnd   time.Time
+}
+
+// BudgetReservation is budget held for a payment in progress. Commit records
+// it as spent once the payment went through; Release returns it otherwise.
+type BudgetReservation struct {
+	budget *BudgetManager
+}
+
+// NewBudgetManager creates a budget manager enforcing limits
+func NewBudgetManager(limits BudgetLimits) (*BudgetManager, error) {
+	if limits.Reference != nil && limits.Oracle == nil {
+		return nil, fmt.Errorf("a reference currency limit requires a price oracle")
+	}
+	perAsset := make(map[AssetKey]*big.Int, len(limits.PerAsset))
+	for key, limit := range limits.PerAsset {
+		perAsset[newAssetKey(key.Network, key.Asset)] = limit
+	}
+	limits.PerAsset = perAsset
+	perMethod := make(map[string]MethodLimits, len(limits.PerMethod))
+	for method, methodLimits := range limits.PerMethod {
+		perMethod[method] = methodLimits.normalized()
+	}
+	limits.PerMethod = perMethod
+	if limits.Window <= 0 {
+		limits.Window = defaultBudgetWindow
+	}
+	if limits.Period < BudgetRolling || limits.Period > BudgetMonthly {
+		return nil, fmt.Errorf("unknown budget period %s", limits.Period)
+	}
+	if limits.Location == nil {
+		limits.Location = time.UTC
+	}
+	return &BudgetManager{limits: limits, now: clockOrSystem(limits.Clock).Now, reserved: make(map[*BudgetReservation]budgetSpend)}, nil
+}
+
+// CanSpend checks that spending amount of asset on network stays within
+// limits, counting reserved budget as spent
+func (b *BudgetManager) CanSpend(ctx context.Context, network, asset string, amount *big.Int) error {
+	key := newAssetKey(network, asset)
+	value, err := b.value(ctx, key, amount)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.checkLocked(key, RequestMethodFromContext(ctx), amount, value)
+}
+
+// Reserve atomically checks that spending amount of asset on network stays
+// within limits and holds it until the reservation is committed or released,
+// so concurrent payments can't jointly exceed a limit
+func (b *BudgetManager) Reserve(ctx context.Context, network, asset string, amount *big.Int) (*BudgetReservation, error) {
+	key := newAssetKey(network, asset)
+	value, err := b.value(ctx, key, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	method := RequestMethodFromContext(ctx)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.checkLocked(key, method, amount, value); err != nil {
+		return nil, err
+	}
+	r := &BudgetReservation{budget: b}
+	b.reserved[r] = budgetSpend{key: key, method: method, tool: RequestToolFromContext(ctx), amount: new(big.Int).Set(amount), value: value}
+	return r, nil
+}
+
+// Commit records the reserved amount as spent now. Committing a released or
+// committed reservation, or a nil one, does nothing.
+func (r *BudgetReservation) Commit() {
+	if r == nil {
+		return
+	}
+	b := r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	spend, ok := b.reserved[r]
+	if !ok {
+		return
+	}
+	delete(b.reserved, r)
+	b.expire()
+	spend.at = b.now()
+	b.spends = append(b.spends, spend)
+}
+
+// Release returns the reserved amount to the budget. Releasing a committed or
+// released reservation, or a nil one, does nothing.
+func (r *BudgetReservation) Release() {
+	if r == nil {
+		return
+	}
+	b := r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.reserved, r)
+}
+
+// checkLocked checks that spending amount (worth value) of key on a request of
+// method stays within limits
+func (b *BudgetManager) checkLocked(key AssetKey, method string, amount, value *big.Int) error {
+	b.expire()
+
+	if err := b.checkMethodLocked(key, method, amount); err != nil {
+		return err
+	}
+	if limit, ok := b.limits.PerAsset[key]; ok {
+		spent := new(big.Int).Add(b.spentLocked(key), amount)
+		spent.Add(spent, b.reservedLocked(key))
+		if spent.Cmp(limit) > 0 {
+			return fmt.Errorf("%w: %s of %s on %s would exceed the limit of %s", ErrBudgetExceeded, spent, key.Asset, key.Network, limit)
+		}
+	}
+	if b.limits.Reference != nil {
+		spent := new(big.Int).Add(b.spentValueLocked(), value)
+		for _, r := range b.reserved {
+			if r.value != nil {
+				spent.Add(spent, r.value)
+			}
+		}
+		if spent.Cmp(b.limits.Reference) > 0 {
+			return fmt.Errorf("%w: value %s would exceed the limit of %s", ErrBudgetExceeded, spent, b.limits.Reference)
+		}
+	}
+	return nil
+}
+
+// checkMethodLocked checks that spending amount of key on a request of method
+// stays within the method's limits
+func (b *BudgetManager) checkMethodLocked(key AssetKey, method string, amount *big.Int) error {
+	limits, ok := b.limits.PerMethod[method]
+	if !ok {
+		return nil
+	}
+	if limit, ok := limits.PerRequest[key]; ok && amount.Cmp(limit) > 0 {
+		return fmt.Errorf("%w: %s of %s on %s exceeds the %s limit of %s per request", ErrBudgetExceeded, amount, key.Asset, key.Network, method, limit)
+	}
+	if limit, ok := limits.PerAsset[key]; ok {
+		spent := new(big.Int).Set(amount)
+		for _, s := range b.spends {
+			if s.key == key && s.method == method {
+				spent.Add(spent, s.amount)
+			}
+		}
+		for _, r := range b.reserved {
+			if r.key == key && r.method == method {
+				spent.Add(spent, r.amount)
+			}
+		}
+		if spent.Cmp(limit) > 0 {
+			return fmt.Errorf("%w: %s of %s on %s for %s would exceed the limit of %s", ErrBudgetExceeded, spent, key.Asset, key.Network, method, limit)
+		}
+	}
+	return nil
+}
+
+// methodAccepts filters requirements down to those within the limits of the
+// method of the request in ctx, before a payment option is selected. Fees are
+// checked when the selected option is reserved.
+func (b *BudgetManager) methodAccepts(ctx context.Context, accepts []PaymentRequirement) ([]PaymentRequirement, error) {
+	method := RequestMethodFromContext(ctx)
+	if _, ok := b.limits.PerMethod[method]; !ok {
+		return accepts, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+
+	var within []PaymentRequirement
+	var firstErr error
+	for _, req := range accepts {
+		amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10)
+		if !ok {
+			within = append(within, req) // Rejected when selecting
+			continue
+		}
+		if err := b.checkMethodLocked(newAssetKey(req.Network, req.Asset), method, amount); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		within = append(within, req)
+	}
+	if len(within) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return within, nil
+}
+
+// RecordSpend records a payment of amount of asset on network
+func (b *BudgetManager) RecordSpend(ctx context.Context, network, asset string, amount *big.Int) error {
+	key := newAssetKey(network, asset)
+	value, err := b.value(ctx, key, amount)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	b.spends = append(b.spends, budgetSpend{
+		at:     b.now(),
+		key:    key,
+		method: RequestMethodFromContext(ctx),
+		tool:   RequestToolFromContext(ctx),
+		amount: new(big.Int).Set(amount),
+		value:  value,
+	})
+	return nil
+}
+
+// Spent returns the amount of asset on network spent within the window or period
+func (b *BudgetManager) Spent(network, asset string) *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	return b.spentLocked(newAssetKey(network, asset))
+}
+
+// Reserved returns the amount of asset on network held by reservations
+func (b *BudgetManager) Reserved(network, asset string) *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.reservedLocked(newAssetKey(network, asset))
+}
+
+// SpentValue returns the reference value spent within the window or period across all assets
+func (b *BudgetManager) SpentValue() *big.Int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire()
+	return b.spentValueLocked()
+}
+
+// value prices an amount with the oracle, if configured
+func (b *BudgetManager) value(ctx context.Context, key AssetKey, amount *big.Int) (*big.Int, error) {
+	if b.limits.Oracle == nil {
+		return nil, nil
+	}
+	value, err := b.limits.Oracle.Value(ctx, key.Network, key.Asset, amount)
+	if err != nil {
+		return nil, fmt.Errorf("pricing %s on %s: %w", key.Asset, key.Network, err)
+	}
+	return value, nil
+}
+
+// expire drops spends older than the window, or made in a calendar period that ended
+func (b *BudgetManager) expire() {
+	if b.limits.Period != BudgetRolling {
+		b.rollover(b.now())
+		return
+	}
+	cutoff := b.now().Add(-b.limits.Window)
+	i := 0
+	for i < len(b.spends) && !b.spends[i].at.After(cutoff) {
+		i++
+	}
+	b.spends = b.spends[i:]
+}
+
+func (b *BudgetManager) spentLocked(key AssetKey) *big.Int {
+	total := new(big.Int)
+	for _, s := range b.spends {
+		if s.key == key {
+			total.Add(total, s.amount)
+		}
+	}
+	return total
+}
+
+func (b *BudgetManager) spentValueLocked() *big.Int {
+	total := new(big.Int)
+	for _, s := range b.spends {
+		if s.value != nil {
+			total.Add(total, s.value)
+		}
+	}
+	return total
+}
+
+func (b *BudgetManager) reservedLocked(key AssetKey) *big.Int {
+	total := new(big.Int)
+	for _, r := range b.reserved {
+		if r.key == key {
+			total.Add(total, r.amount)
+		}
+	}
+	return total
+}
@@ -0,0 +1,174 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// BudgetPeriod is how often budget limits reset
+type BudgetPeriod int
+
+const (
+	BudgetRolling BudgetPeriod = iota // Limits apply over a rolling Window (the default)
+	BudgetDaily                       // Limits reset at midnight
+	BudgetWeekly                      // Limits reset at midnight between Sunday and Monday
+	BudgetMonthly                     // Limits reset at midnight on the first of the month
+)
+
+// String returns "rolling", "daily", "weekly" or "monthly"
+func (p BudgetPeriod) String() string {
+	switch p {
+	case BudgetRolling:
+		return "rolling"
+	case BudgetDaily:
+		return "daily"
+	case BudgetWeekly:
+		return "weekly"
+	case BudgetMonthly:
+		return "monthly"
+	}
+	return fmt.Sprintf("BudgetPeriod(%d)", int(p))
+}
+
+// start returns the start of the period containing t, in loc
+func (p BudgetPeriod) start(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	switch p {
+	case BudgetWeekly:
+		midnight := time.Date(year, month, day, 0, 0, 0, 0, loc)
+		sinceMonday := (int(midnight.Weekday()) + 6) % 7
+		return midnight.AddDate(0, 0, -sinceMonday)
+	case BudgetMonthly:
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(year, month, day, 0, 0, 0, 0, loc)
+	}
+}
+
+// next returns the start of the period after the one starting at start
+func (p BudgetPeriod) next(start time.Time) time.Time {
+	switch p {
+	case BudgetWeekly:
+		return start.AddDate(0, 0, 7)
+	case BudgetMonthly:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// BudgetReport summarizes the spending of a budget period that ended
+type BudgetReport struct {
+	Period BudgetPeriod
+	Start  time.Time
+	End    time.Time
+
+	// Lines hold the spending per tool, network and asset, sorted
+	Lines []BudgetReportLine
+
+	// Value is the reference value spent across all assets; nil without an Oracle
+	Value *big.Int
+}
+
+// BudgetReportLine is the spending on one tool in one asset
+type BudgetReportLine struct {
+	Tool     string // Tool called; empty for payments made for other requests
+	Network  string
+	Asset    string
+	Amount   *big.Int
+	Payments int
+}
+
+type requestToolKey struct{}
+
+// WithRequestTool records in ctx the tool called by the request paid for
+// within it, which budget reports break spending down by. X402Transport sets
+// it for the tool calls it pays for.
+func WithRequestTool(ctx context.Context, tool string) context.Context {
+	return context.WithValue(ctx, requestToolKey{}, tool)
+}
+
+// RequestToolFromContext returns the tool set with WithRequestTool
+func RequestToolFromContext(ctx context.Context) string {
+	tool, _ := ctx.Value(requestToolKey{}).(string)
+	return tool
+}
+
+// Run rolls a calendar budget over at the end of each period until ctx is
+// done, so OnPeriodEnd reports arrive on time even when nothing is paid near
+// the end of a period. Without it, a period rolls over at the next budget
+// check. It returns at once for rolling windows.
+func (b *BudgetManager) Run(ctx context.Context) {
+	if b.limits.Period == BudgetRolling {
+		return
+	}
+	for {
+		b.mu.Lock()
+		b.expire()
+		wait := b.periodEnd.Sub(b.now())
+		b.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// rollover starts the period containing now once the current one has ended,
+// reporting the spending of the ended period to OnPeriodEnd
+func (b *BudgetManager) rollover(now time.Time) {
+	if !b.periodEnd.IsZero() && now.Before(b.periodEnd) {
+		return
+	}
+	if !b.periodEnd.IsZero() && b.limits.OnPeriodEnd != nil {
+		report := b.reportLocked()
+		go b.limits.OnPeriodEnd(report)
+	}
+	b.spends = nil
+	b.periodStart = b.limits.Period.start(now, b.limits.Location)
+	b.periodEnd = b.limits.Period.next(b.periodStart)
+}
+
+// reportLocked summarizes the spends of the current period
+func (b *BudgetManager) reportLocked() BudgetReport {
+	type lineKey struct {
+		tool string
+		key  AssetKey
+	}
+	lines := make(map[lineKey]*BudgetReportLine)
+	report := BudgetReport{Period: b.limits.Period, Start: b.periodStart, End: b.periodEnd}
+	if b.limits.Oracle != nil {
+		report.Value = b.spentValueLocked()
+	}
+	for _, s := range b.spends {
+		k := lineKey{tool: s.tool, key: s.key}
+		line, ok := lines[k]
+		if !ok {
+			line = &BudgetReportLine{Tool: s.tool, Network: s.key.Network, Asset: s.key.Asset, Amount: new(big.Int)}
+			lines[k] = line
+		}
+		line.Amount.Add(line.Amount, s.amount)
+		line.Payments++
+	}
+	for _, line := range lines {
+		report.Lines = append(report.Lines, *line)
+	}
+	sort.Slice(report.Lines, func(i, j int) bool {
+		a, b := report.Lines[i], report.Lines[j]
+		if a.Tool != b.Tool {
+			return a.Tool < b.Tool
+		}
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		return a.Asset < b.Asset
+	})
+	return report
+}
@@ -0,0 +1,83 @@
+package x402
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// CapabilityKey is the experimental server capability under which servers
+// describe their x402 support in the initialize result
+const CapabilityKey = "x402"
+
+// Payment flows a server can accept
+const (
+	// FlowMeta carries payments in params._meta[MetaKeyPayment] after a JSON-RPC 402 error
+	FlowMeta = "meta"
+	// FlowHeader carries payments in the X-PAYMENT header after an HTTP 402 response
+	FlowHeader = "header"
+)
+
+// Capability is a server's x402 capability, advertised in
+// capabilities.experimental["x402"] of its initialize result
+type Capability struct {
+	// Versions lists the supported x402 protocol versions
+	Versions []int `json:"versions"`
+
+	// Flows lists the accepted payment flows (FlowMeta, FlowHeader)
+	Flows []string `json:"flows"`
+
+	// Currencies lists the assets the server accepts payments in
+	Currencies []CapabilityCurrency `json:"currencies,omitempty"`
+
+	// PaymentRequiredCode is the JSON-RPC error code of the server's payment required errors
+	PaymentRequiredCode int `json:"paymentRequiredCode,omitempty"`
+}
+
+// CapabilityCurrency is an asset a server accepts on a network
+type CapabilityCurrency struct {
+	Scheme  string `json:"scheme"`
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+}
+
+// SupportsFlow reports whether the server accepts the payment flow
+func (c *Capability) SupportsFlow(flow string) bool {
+	for _, f := range c.Flows {
+		if f == flow {
+			return true
+		}
+	}
+	return false
+}
+
+// Accepts reports whether the server accepts payments in asset on network
+func (c *Capability) Accepts(network, asset string) bool {
+	for _, currency := range c.Currencies {
+		if currency.Network == network && strings.EqualFold(currency.Asset, asset) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCapability extracts the x402 capability from an initialize result
+func ParseCapability(initializeResult json.RawMessage) (*Capability, bool) {
+	var result struct {
+		Capabilities struct {
+			Experimental map[string]json.RawMessage `json:"experimental"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(initializeResult, &result); err != nil {
+		return nil, false
+	}
+
+	raw, ok := result.Capabilities.Experimental[CapabilityKey]
+	if !ok {
+		return nil, false
+	}
+	var capability Capability
+	if err := json.Unmarshal(raw, &capability); err != nil {
+		return nil, false
+	}
+	return &capability, true
+}
@@ -0,0 +1,152 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ChaosConfig configures the payment infrastructure failures injected by
+// ChaosTransport and the server's ChaosFacilitator, to test how agents and
+// servers cope with them. Rates are probabilities between 0 and 1.
+type ChaosConfig struct {
+	// Delay is added to every call, plus a random Jitter of up to Jitter
+	Delay  time.Duration
+	Jitter time.Duration
+
+	// DropRate is the share of calls processed but whose response is lost,
+	// failing with ErrChaosDropped (e.g. a payment settled but never confirmed)
+	DropRate float64
+
+	// DuplicateRate is the share of calls sent twice, such as duplicate
+	// settlements or replayed payment retries
+	DuplicateRate float64
+
+	// MalformedRate is the share of responses corrupted or missing fields
+	MalformedRate float64
+
+	// Seed makes the sequence of faults reproducible; zero seeds from the clock
+	Seed int64
+}
+
+// Chaos draws the faults of a ChaosConfig. It is safe for concurrent use.
+type Chaos struct {
+	config ChaosConfig
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewChaos creates a fault source for config
+func NewChaos(config ChaosConfig) *Chaos {
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Chaos{config: config, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Wait sleeps for the configured delay, returning early with ctx's error
+func (c *Chaos) Wait(ctx context.Context) error {
+	delay := c.config.Delay
+	if c.config.Jitter > 0 {
+		c.mu.Lock()
+		delay += time.Duration(c.rand.Int63n(int64(c.config.Jitter)))
+		c.mu.Unlock()
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drop reports whether to drop the response of the current call
+func (c *Chaos) Drop() bool { return c.roll(c.config.DropRate) }
+
+// Duplicate reports whether to send the current call twice
+func (c *Chaos) Duplicate() bool { return c.roll(c.config.DuplicateRate) }
+
+// Malform reports whether to corrupt the response of the current call
+func (c *Chaos) Malform() bool { return c.roll(c.config.MalformedRate) }
+
+func (c *Chaos) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rand.Float64() < rate
+}
+
+// ChaosTransport is an http.RoundTripper injecting failures between a client
+// and an x402 server. Use it as the Transport of Config.HTTPClient:
+//
+//	httpClient := &http.Client{Transport: x402.NewChaosTransport(nil, x402.ChaosConfig{DropRate: 0.1})}
+type ChaosTransport struct {
+	base  http.RoundTripper
+	chaos *Chaos
+}
+
+// NewChaosTransport wraps base (http.DefaultTransport when nil)
+func NewChaosTransport(base http.RoundTripper, config ChaosConfig) *ChaosTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &ChaosTransport{base: base, chaos: NewChaos(config)}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ChaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.chaos.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil && req.Body != http.NoBody && t.chaos.Duplicate() {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		first := req.Clone(req.Context())
+		first.Body = io.NopCloser(bytes.NewReader(body))
+		if resp, err := t.base.RoundTrip(first); err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if t.chaos.Drop() {
+		resp.Body.Close()
+		return nil, ErrChaosDropped
+	}
+	if t.chaos.Malform() {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Truncating mid-document breaks JSON and SSE framing alike
+		body = body[:len(body)/2]
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+	}
+	return resp, nil
+}
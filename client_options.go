@@ -14,9 +14,16 @@ const (
 	USDCAddressPolygonAmoy   = "0x41e94eb019c0762f9bfcf9fb1e58725bfb0e7582" // Polygon Amoy
 	USDCAddressAvalancheFuji = "0x5425890298aed601595a70ab815c96711a31bc65" // Avalanche Fuji
 
+	// USDCAddressSandbox is the fake USDC of the developer sandbox network
+	USDCAddressSandbox = "0x5a4d000000000000000000000000000000000402"
+
 	// Solana USDC mint addresses
 	USDCMintSolana       = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v" // Solana mainnet
 	USDCMintSolanaDevnet = "4zMMC9srt5Ri5X14GAgXhaHii3GnPAEERYPJgZJDncDU" // Solana devnet
+
+	// SOLNative is the asset of native SOL payments, paid in lamports with a
+	// SystemProgram transfer. It is the System Program ID, as SOL has no mint.
+	SOLNative = "11111111111111111111111111111111"
 )
 
 // Helper functions for common client payment options
@@ -38,6 +45,24 @@ func AcceptUSDCBase() ClientPaymentOption {
 	}
 }
 
+// AcceptUSDCSandbox creates a client payment option for fake USDC on the
+// developer sandbox network, settled by a sandbox facilitator
+func AcceptUSDCSandbox() ClientPaymentOption {
+	return ClientPaymentOption{
+		PaymentRequirement: PaymentRequirement{
+			Scheme:  "exact",
+			Network: SandboxNetwork,
+			Asset:   USDCAddressSandbox,
+			Extra: map[string]string{
+				"name":    "USDC",
+				"version": "2",
+			},
+		},
+		Priority: 1,
+		ChainID:  big.NewInt(SandboxChainID),
+	}
+}
+
 // AcceptUSDCBaseSepolia creates a client payment option for USDC on Base Sepolia testnet
 func AcceptUSDCBaseSepolia() ClientPaymentOption {
 	return ClientPaymentOption{
@@ -75,6 +100,12 @@ func (opt ClientPaymentOption) WithMinBalance(amount string) ClientPaymentOption
 	return opt
 }
 
+// WithAmountPolicy only pays amounts valid under the policy, e.g. to refuse dust amounts
+func (opt ClientPaymentOption) WithAmountPolicy(policy AmountPolicy) ClientPaymentOption {
+	opt.AmountPolicy = &policy
+	return opt
+}
+
 // AcceptUSDCPolygon creates a client payment option for USDC on Polygon mainnet
 func AcceptUSDCPolygon() ClientPaymentOption {
 	return ClientPaymentOption{
@@ -176,3 +207,39 @@ func AcceptUSDCSolanaDevnet() ClientPaymentOption {
 		NetworkID: "devnet",
 	}
 }
+
+// AcceptSOL creates a client payment option for native SOL on Solana mainnet,
+// with amounts in lamports
+func AcceptSOL() ClientPaymentOption {
+	return ClientPaymentOption{
+		PaymentRequirement: PaymentRequirement{
+			Scheme:  "exact",
+			Network: "solana",
+			Asset:   SOLNative,
+			Extra: map[string]string{
+				"name":     "SOL",
+				"decimals": "9",
+			},
+		},
+		Priority:  2,
+		NetworkID: "mainnet-beta",
+	}
+}
+
+// AcceptSOLDevnet creates a client payment option for native SOL on Solana devnet,
+// with amounts in lamports
+func AcceptSOLDevnet() ClientPaymentOption {
+	return ClientPaymentOption{
+		PaymentRequirement: PaymentRequirement{
+			Scheme:  "exact",
+			Network: "solana-devnet",
+			Asset:   SOLNative,
+			Extra: map[string]string{
+				"name":     "SOL (Devnet)",
+				"decimals": "9",
+			},
+		},
+		Priority:  2,
+		NetworkID: "devnet",
+	}
+}
@@ -2,8 +2,10 @@ package x402
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -309,3 +311,397 @@ func TestMockSigner(t *testing.T) {
 		assert.Equal(t, "base", payment.Network)
 	})
 }
+
+func TestSelectionStrategies(t *testing.T) {
+	baseSigner := NewMockSigner("0xBase", AcceptUSDCBase()).WithPriority(1)
+	polygonSigner := NewMockSigner("0xPolygon", AcceptUSDCPolygon()).WithPriority(2)
+	solanaSigner := NewMockSolanaSigner("SoLaNa", AcceptUSDCSolana()).WithPriority(3)
+	signers := []PaymentSigner{baseSigner, polygonSigner, solanaSigner}
+
+	accepts := []PaymentRequirement{
+		{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "3000"},
+		{Scheme: "exact", Network: "polygon", Asset: USDCAddressPolygon, MaxAmountRequired: "1000"},
+		{Scheme: "exact", Network: "solana", Asset: USDCMintSolana, MaxAmountRequired: "2000"},
+	}
+
+	tests := []struct {
+		name        string
+		strategy    SelectionStrategy
+		wantNetwork string
+		wantSigner  PaymentSigner
+	}{
+		{name: "PriorityFirst", strategy: PriorityFirst(), wantNetwork: "base", wantSigner: baseSigner},
+		{name: "CheapestFirst", strategy: CheapestFirst(), wantNetwork: "polygon", wantSigner: polygonSigner},
+		{name: "FastestNetworkFirst", strategy: FastestNetworkFirst(), wantNetwork: "solana", wantSigner: solanaSigner},
+		{name: "PreferNetwork", strategy: PreferNetwork("polygon", "base"), wantNetwork: "polygon", wantSigner: polygonSigner},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, selected, err := tt.strategy.SelectPayment(signers, accepts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantNetwork, selected.Network)
+			assert.Same(t, tt.wantSigner, signer)
+		})
+	}
+
+	t.Run("FallsBackWhenSigningFails", func(t *testing.T) {
+		handler, err := NewPaymentHandlerMulti(signers, &HandlerConfig{
+			Strategy: CheapestFirst(),
+			PaymentCallback: func(amount *big.Int, resource string) bool {
+				// Decline anything under 1500, forcing fallback past polygon
+				return amount.Cmp(big.NewInt(1500)) >= 0
+			},
+		})
+		require.NoError(t, err)
+
+		payload, err := handler.CreatePayment(context.Background(), PaymentRequirementsResponse{Accepts: accepts})
+		require.NoError(t, err)
+		assert.Equal(t, "solana", payload.Network)
+	})
+
+	t.Run("NoCandidates", func(t *testing.T) {
+		_, _, err := CheapestFirst().SelectPayment(signers, []PaymentRequirement{
+			{Scheme: "exact", Network: "unknown", Asset: "0x0", MaxAmountRequired: "1"},
+		})
+		assert.ErrorIs(t, err, ErrNoAcceptablePayment)
+	})
+
+	t.Run("SignerNotOffered", func(t *testing.T) {
+		// A strategy insisting on a signer that already failed must not loop forever
+		handler, err := NewPaymentHandlerMulti(signers, &HandlerConfig{
+			Strategy: SelectionStrategyFunc(func([]PaymentSigner, []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+				return polygonSigner, &accepts[1], nil
+			}),
+			PaymentCallback: func(*big.Int, string) bool { return false },
+		})
+		require.NoError(t, err)
+
+		_, err = handler.CreatePayment(context.Background(), PaymentRequirementsResponse{Accepts: accepts})
+		var multiErr *MultiSignerError
+		require.ErrorAs(t, err, &multiErr)
+		assert.Len(t, multiErr.SignerFailures, 1)
+	})
+}
+
+func TestTieBreakingStrategies(t *testing.T) {
+	// Base, Polygon and Solana share option priority 1, Avalanche has 2
+	polygon, solana := AcceptUSDCPolygon(), AcceptUSDCSolana()
+	polygon.Priority, solana.Priority = 1, 1
+	evmSigner := NewMockSigner("0xEVM", AcceptUSDCBase(), polygon, AcceptUSDCAvalanche())
+	solanaSigner := NewMockSolanaSigner("SoLaNa", solana)
+	signers := []PaymentSigner{evmSigner, solanaSigner}
+
+	accepts := []PaymentRequirement{
+		{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "1000"},
+		{Scheme: "exact", Network: "polygon", Asset: USDCAddressPolygon, MaxAmountRequired: "1000"},
+		{Scheme: "exact", Network: "avalanche", Asset: USDCAddressAvalanche, MaxAmountRequired: "1000"},
+		{Scheme: "exact", Network: "solana", Asset: USDCMintSolana, MaxAmountRequired: "1000"},
+	}
+	count := func(strategy SelectionStrategy, n int) map[string]int {
+		networks := make(map[string]int)
+		for i := 0; i < n; i++ {
+			_, selected, err := strategy.SelectPayment(signers, accepts)
+			require.NoError(t, err)
+			networks[selected.Network]++
+		}
+		return networks
+	}
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		assert.Equal(t, map[string]int{"base": 3, "polygon": 3, "solana": 3}, count(RoundRobin(), 9))
+	})
+
+	t.Run("WeightedRandom", func(t *testing.T) {
+		networks := count(WeightedRandom(map[string]int{"base": 3, "solana": 0}), 400)
+		assert.Zero(t, networks["solana"])
+		assert.Zero(t, networks["avalanche"])
+		assert.Greater(t, networks["base"], networks["polygon"])
+		assert.Positive(t, networks["polygon"])
+	})
+
+	t.Run("CheaperOptionWins", func(t *testing.T) {
+		cheaper := append([]PaymentRequirement(nil), accepts...)
+		cheaper[1].MaxAmountRequired = "900"
+		for i := 0; i < 5; i++ {
+			_, selected, err := RoundRobin().SelectPayment(signers, cheaper)
+			require.NoError(t, err)
+			assert.Equal(t, "polygon", selected.Network)
+		}
+	})
+}
+
+func TestExplainSelection(t *testing.T) {
+	baseSigner := NewMockSigner("0xBase", AcceptUSDCBase().WithMaxAmount("2000")).WithPriority(1)
+	polygonSigner := NewMockSigner("0xPolygon", AcceptUSDCPolygon()).WithPriority(2)
+	signers := []PaymentSigner{baseSigner, polygonSigner}
+
+	accepts := []PaymentRequirement{
+		{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "3000"},
+		{Scheme: "exact", Network: "polygon", Asset: USDCAddressPolygon, MaxAmountRequired: "1000"},
+	}
+
+	handler, err := NewPaymentHandlerMulti(signers, &HandlerConfig{})
+	require.NoError(t, err)
+	explanation := handler.ExplainSelection(accepts)
+
+	reasons := make([]SelectionReason, len(explanation.Candidates))
+	for i, c := range explanation.Candidates {
+		reasons[i] = c.Reason
+	}
+	assert.Equal(t, []SelectionReason{
+		ReasonExceedsMaxAmount,   // 0xBase on base: 3000 > 2000
+		ReasonUnsupportedNetwork, // 0xBase on polygon
+		ReasonUnsupportedNetwork, // 0xPolygon on base
+		ReasonSelected,           // 0xPolygon on polygon
+	}, reasons)
+	require.NotNil(t, explanation.Selected())
+	assert.Equal(t, "polygon", explanation.Selected().Requirement.Network)
+	assert.Contains(t, explanation.String(), "signer 0 (0xBase): 3000")
+
+	// The explanation matches what CreatePayment pays
+	payload, err := handler.CreatePayment(context.Background(), PaymentRequirementsResponse{Accepts: accepts})
+	require.NoError(t, err)
+	assert.Equal(t, explanation.Selected().Requirement.Network, payload.Network)
+
+	t.Run("Strategy", func(t *testing.T) {
+		cheap := NewMockSigner("0xBoth", AcceptUSDCBase(), AcceptUSDCPolygon())
+		handler, err := NewPaymentHandlerMulti([]PaymentSigner{cheap}, &HandlerConfig{Strategy: CheapestFirst()})
+		require.NoError(t, err)
+		explanation := handler.ExplainSelection(accepts)
+		assert.Equal(t, ReasonLowerPriority, explanation.Candidates[0].Reason)
+		assert.Equal(t, "polygon", explanation.Selected().Requirement.Network)
+	})
+
+	t.Run("RoundRobin", func(t *testing.T) {
+		polygon := AcceptUSDCPolygon()
+		polygon.Priority = 1
+		both := NewMockSigner("0xBoth", AcceptUSDCBase(), polygon)
+		tiedAccepts := []PaymentRequirement{
+			{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "1000"},
+			{Scheme: "exact", Network: "polygon", Asset: USDCAddressPolygon, MaxAmountRequired: "1000"},
+		}
+		handler, err := NewPaymentHandlerMulti([]PaymentSigner{both}, &HandlerConfig{Strategy: RoundRobin()})
+		require.NoError(t, err)
+
+		// Explaining doesn't advance the rotation
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, "base", handler.ExplainSelection(tiedAccepts).Selected().Requirement.Network)
+		}
+		ctx := withSelection(context.Background())
+		payload, err := handler.CreatePayment(ctx, PaymentRequirementsResponse{Accepts: tiedAccepts})
+		require.NoError(t, err)
+		assert.Equal(t, "base", payload.Network)
+		assert.Equal(t, "base", handler.explainPaid(ctx, tiedAccepts).Selected().Requirement.Network)
+		assert.Equal(t, "polygon", handler.ExplainSelection(tiedAccepts).Selected().Requirement.Network)
+	})
+
+	t.Run("TrustedRecipients", func(t *testing.T) {
+		handler, err := NewPaymentHandlerMulti(signers, &HandlerConfig{TrustedRecipients: map[string][]string{"base": {"0xtrusted"}}})
+		require.NoError(t, err)
+		explanation := handler.ExplainSelection(accepts)
+		assert.Equal(t, ReasonUntrustedRecipient, explanation.Candidates[3].Reason)
+		assert.Nil(t, explanation.Selected())
+	})
+}
+
+func TestHealthAwareSelection(t *testing.T) {
+	baseSigner := NewMockSigner("0xBase", AcceptUSDCBase()).WithPriority(1)
+	polygonSigner := NewMockSigner("0xPolygon", AcceptUSDCPolygon()).WithPriority(2)
+	signers := []PaymentSigner{baseSigner, polygonSigner}
+
+	accepts := []PaymentRequirement{
+		{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "1000"},
+		{Scheme: "exact", Network: "polygon", Asset: USDCAddressPolygon, MaxAmountRequired: "1000"},
+	}
+
+	health := NewNetworkHealth()
+	strategy := HealthAware(health)
+
+	// No data: default priority order
+	_, selected, err := strategy.SelectPayment(signers, accepts)
+	require.NoError(t, err)
+	assert.Equal(t, "base", selected.Network)
+
+	// Base starts failing settlements
+	for i := 0; i < 3; i++ {
+		health.RecordFailure("base")
+	}
+	health.RecordSuccess("polygon", time.Second)
+
+	signer, selected, err := strategy.SelectPayment(signers, accepts)
+	require.NoError(t, err)
+	assert.Equal(t, "polygon", selected.Network)
+	assert.Same(t, polygonSigner, signer)
+
+	// Congested polygon still beats failing base
+	for i := 0; i < 5; i++ {
+		health.RecordSuccess("polygon", time.Minute)
+	}
+	stats, ok := health.Stats("polygon")
+	require.True(t, ok)
+	assert.GreaterOrEqual(t, stats.Latency, health.SlowThreshold)
+
+	_, selected, err = strategy.SelectPayment(signers, accepts)
+	require.NoError(t, err)
+	assert.Equal(t, "polygon", selected.Network)
+
+	// Stale stats age out so base recovers
+	health.now = func() time.Time { return time.Now().Add(health.Window + time.Second) }
+	_, selected, err = strategy.SelectPayment(signers, accepts)
+	require.NoError(t, err)
+	assert.Equal(t, "base", selected.Network)
+}
+
+func TestSignerHealth(t *testing.T) {
+	primary := NewMockSigner("0xPrimary", AcceptUSDCBase().WithMinBalance("5000")).WithPriority(1)
+	backup := NewMockSigner("0xBackup", AcceptUSDCBase()).WithPriority(2)
+	signers := []PaymentSigner{primary, backup}
+
+	balances := map[string]int64{primary.GetAddress(): 1000, backup.GetAddress(): 1000}
+	var events []PaymentEvent
+	health := NewSignerHealth(signers, SignerHealthConfig{
+		Balance: func(ctx context.Context, network, asset, address string) (*big.Int, error) {
+			return big.NewInt(balances[address]), nil
+		},
+		OnChange: func(event PaymentEvent) { events = append(events, event) },
+	})
+	handler, err := NewPaymentHandlerMulti(signers, &HandlerConfig{SignerHealth: health})
+	require.NoError(t, err)
+
+	reqs := PaymentRequirementsResponse{Accepts: []PaymentRequirement{
+		{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "1000", PayTo: "0xRecipient"},
+	}}
+	payer := func() string {
+		payload, err := handler.CreatePayment(context.Background(), reqs)
+		require.NoError(t, err)
+		return payload.Payload.(PaymentPayloadData).Authorization.From
+	}
+
+	// The primary's balance is below its MinBalance
+	health.CheckNow(context.Background())
+	assert.Error(t, health.Failure(primary))
+	assert.NoError(t, health.Failure(backup))
+	require.Len(t, events, 1)
+	assert.Equal(t, PaymentEventSignerDisabled, events[0].Type)
+	assert.Equal(t, primary.GetAddress(), events[0].SignerAddress)
+	assert.Equal(t, backup.GetAddress(), payer())
+
+	// Topped up, the primary is used again
+	balances[primary.GetAddress()] = 10000
+	health.CheckNow(context.Background())
+	require.Len(t, events, 2)
+	assert.Equal(t, PaymentEventSignerEnabled, events[1].Type)
+	assert.Equal(t, primary.GetAddress(), payer())
+
+	// Keys are checked by signing
+	signer, err := NewPrivateKeySigner("0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef", AcceptUSDCBase())
+	require.NoError(t, err)
+	assert.NoError(t, signer.CheckHealth(context.Background()))
+}
+
+func TestAmountPolicy(t *testing.T) {
+	t.Run("Round", func(t *testing.T) {
+		tests := []struct {
+			policy AmountPolicy
+			amount string
+			want   string
+		}{
+			{AmountPolicy{Granularity: "1000"}, "1001", "2000"},
+			{AmountPolicy{Granularity: "1000"}, "3000", "3000"},
+			{AmountPolicy{Granularity: "1000", Rounding: RoundDown}, "1999", "1000"},
+			{AmountPolicy{Granularity: "1000", Rounding: RoundDown}, "999", "1000"}, // never zero
+			{AmountPolicy{Granularity: "1000", Rounding: RoundNearest}, "1499", "1000"},
+			{AmountPolicy{Granularity: "1000", Rounding: RoundNearest}, "1500", "2000"},
+			{AmountPolicy{Minimum: "5000"}, "1", "5000"},
+			{AmountPolicy{Granularity: "1000", Minimum: "2500"}, "10", "3000"},
+		}
+		for _, tt := range tests {
+			got, err := tt.policy.Round(tt.amount)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got, "%+v rounding %s", tt.policy, tt.amount)
+		}
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		policy := AmountPolicy{Granularity: "1000", Minimum: "5000"}
+		assert.NoError(t, policy.Validate("6000"))
+		assert.ErrorIs(t, policy.Validate("6500"), ErrInvalidAmount)
+		assert.ErrorIs(t, policy.Validate("4000"), ErrInvalidAmount)
+		assert.ErrorIs(t, policy.Validate("0"), ErrInvalidAmount)
+
+		bad := AmountPolicy{Granularity: "-1"}
+		assert.Error(t, bad.Validate("1000"))
+	})
+
+	t.Run("SkipsInvalidAmounts", func(t *testing.T) {
+		signer := NewMockSigner("0xTestWallet",
+			AcceptUSDCBase().WithAmountPolicy(AmountPolicy{Minimum: "1000"}),
+			AcceptUSDCPolygon(),
+		)
+		accepts := []PaymentRequirement{
+			{Scheme: "exact", Network: "base", Asset: USDCAddressBase, MaxAmountRequired: "1"},
+			{Scheme: "exact", Network: "polygon", Asset: USDCAddressPolygon, MaxAmountRequired: "1"},
+		}
+		candidates := PaymentCandidates([]PaymentSigner{signer}, accepts)
+		require.Len(t, candidates, 1)
+		assert.Equal(t, "polygon", candidates[0].Requirement.Network)
+	})
+}
+
+func TestConfigValidate(t *testing.T) {
+	signer := NewMockSigner("0xTestWallet", AcceptUSDCBase())
+
+	t.Run("Valid", func(t *testing.T) {
+		config := Config{ServerURL: "https://server.example.com", Signers: []PaymentSigner{signer}}
+		assert.Empty(t, config.Validate())
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := New(Config{ServerURL: "https://server.example.com"})
+		assert.ErrorIs(t, err, ErrNoSignerConfigured)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+
+		_, err = New(Config{ServerURL: "ftp://server.example.com", Signers: []PaymentSigner{signer}, MaxPaymentsPerRequest: -1})
+		var validationErr *ConfigValidationError
+		require.ErrorAs(t, err, &validationErr)
+		require.Len(t, validationErr.Issues, 2)
+		assert.Equal(t, "ServerURL", validationErr.Issues[0].Field)
+		assert.Equal(t, "MaxPaymentsPerRequest", validationErr.Issues[1].Field)
+	})
+
+	t.Run("Warnings", func(t *testing.T) {
+		config := Config{
+			ServerURL:             "https://server.example.com",
+			Signer:                signer,
+			Signers:               []PaymentSigner{signer},
+			ResultIntegritySigner: "0xServer",
+			ForceNetwork:          "solana",
+		}
+		var fields []string
+		for _, issue := range config.Validate() {
+			assert.Equal(t, ConfigWarning, issue.Severity)
+			fields = append(fields, issue.Field)
+		}
+		assert.Equal(t, []string{"Signer", "ResultIntegritySigner", "ForceNetwork"}, fields)
+
+		logger := &recordingLogger{}
+		config.Logger = logger
+		_, err := New(config)
+		require.NoError(t, err)
+		assert.Len(t, logger.lines, 3, "warnings are logged")
+
+		config.StrictConfig = true
+		_, err = New(config)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	})
+}
+
+// recordingLogger records the lines logged to it
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...any) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
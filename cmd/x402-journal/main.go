@@ -0,0 +1,71 @@
+// Command x402-journal lists the unreconciled payments of a server's settlement
+// journal and optionally settles those a crash left verified.
+//
+//	x402-journal -journal settlements.jsonl
+//	x402-journal -journal settlements.jsonl -recover -facilitator https://facilitator.x402.rs
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	x402server "github.com/mark3labs/mcp-go-x402/server"
+)
+
+func main() {
+	var (
+		journalFlag     = flag.String("journal", "", "Settlement journal file")
+		recoverFlag     = flag.Bool("recover", false, "Settle payments a crash left verified")
+		facilitatorFlag = flag.String("facilitator", "https://facilitator.x402.rs", "Facilitator URL used with -recover")
+		timeoutFlag     = flag.Duration("timeout", 2*time.Minute, "Timeout for -recover")
+	)
+	flag.Parse()
+
+	if *journalFlag == "" {
+		log.Fatal("Journal file required: use -journal")
+	}
+	if _, err := os.Stat(*journalFlag); err != nil {
+		log.Fatalf("Failed to open journal: %v", err)
+	}
+
+	journal, err := x402server.NewFileSettlementJournal(*journalFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer journal.Close()
+
+	var entries []x402server.JournalEntry
+	if *recoverFlag {
+		ctx, cancel := context.WithTimeout(context.Background(), *timeoutFlag)
+		defer cancel()
+		entries, err = x402server.RecoverSettlements(ctx, journal, x402server.NewHTTPFacilitator(*facilitatorFlag))
+	} else {
+		entries, err = journal.Unreconciled()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("All payments reconciled")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tSTATE\tNETWORK\tAMOUNT\tPAYER\tRESOURCE\tATTEMPTS\tERROR")
+	for _, entry := range entries {
+		var network, amount, resource string
+		if entry.Requirement != nil {
+			network, amount, resource = entry.Requirement.Network, entry.Requirement.MaxAmountRequired, entry.Requirement.Resource
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+			entry.ID, entry.Time.Format(time.RFC3339), entry.State, network, amount,
+			entry.Payer, resource, entry.Attempts, entry.Error)
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,108 @@
+// Command x402-proxy adds x402 payments to any streamable HTTP MCP server (Node,
+// Python, ...) by running in front of it as a reverse proxy.
+//
+//	x402-proxy -upstream http://localhost:3000/mcp -pay-to 0xYourWallet -price search=1000
+//	x402-proxy -upstream http://localhost:3000/mcp -pay-to 0xYourWallet -network base,polygon -default-price 1000 -free list_files
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	x402server "github.com/mark3labs/mcp-go-x402/server"
+)
+
+// prices collects repeated -price tool=amount flags
+type prices map[string]string
+
+func (p prices) String() string {
+	pairs := make([]string, 0, len(p))
+	for tool, amount := range p {
+		pairs = append(pairs, tool+"="+amount)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p prices) Set(value string) error {
+	tool, amount, ok := strings.Cut(value, "=")
+	if !ok || tool == "" || amount == "" {
+		return fmt.Errorf("expected tool=amount, got %q", value)
+	}
+	p[tool] = amount
+	return nil
+}
+
+func main() {
+	toolPrices := prices{}
+	var (
+		upstreamFlag     = flag.String("upstream", "", "URL of the upstream streamable HTTP MCP endpoint")
+		listenFlag       = flag.String("listen", ":8080", "Address to listen on")
+		pathFlag         = flag.String("path", "/mcp", "Path of the proxied MCP endpoint")
+		facilitatorFlag  = flag.String("facilitator", "https://facilitator.x402.rs", "Facilitator URL")
+		payToFlag        = flag.String("pay-to", "", "Address receiving payments")
+		networkFlag      = flag.String("network", "base-sepolia", "Comma-separated networks to accept payment on")
+		assetFlag        = flag.String("asset", "usdc", "Asset to accept: usdc, or sol on Solana networks")
+		defaultPriceFlag = flag.String("default-price", "", "Price of tools without a -price, in the asset's base units")
+		freeFlag         = flag.String("free", "", "Comma-separated tools exempt from -default-price")
+		verifyOnlyFlag   = flag.Bool("verify-only", false, "Verify payments without settling them")
+		verboseFlag      = flag.Bool("verbose", false, "Log payment handling")
+	)
+	flag.Var(toolPrices, "price", "Price of a tool as tool=amount, in the asset's base units (repeatable)")
+	flag.Parse()
+
+	if *upstreamFlag == "" {
+		log.Fatal("Upstream MCP server required: use -upstream")
+	}
+	if *payToFlag == "" {
+		log.Fatal("Payment recipient required: use -pay-to")
+	}
+	if len(toolPrices) == 0 && *defaultPriceFlag == "" {
+		log.Fatal("No paid tools: use -price or -default-price")
+	}
+
+	config := &x402server.Config{
+		FacilitatorURL: *facilitatorFlag,
+		PaymentTools:   make(map[string][]x402server.PaymentRequirement),
+		VerifyOnly:     *verifyOnlyFlag,
+		Verbose:        *verboseFlag,
+	}
+	if *freeFlag != "" {
+		config.AllowFree = strings.Split(*freeFlag, ",")
+	}
+
+	// Solana requirements need the facilitator's fee payer
+	if err := x402server.FetchSupportedPayments(config); err != nil {
+		log.Printf("Warning: failed to fetch supported payments from facilitator: %v", err)
+	}
+
+	requirements := func(amount, description string) []x402server.PaymentRequirement {
+		var reqs []x402server.PaymentRequirement
+		for _, network := range strings.Split(*networkFlag, ",") {
+			req, err := x402server.RequireAsset(strings.TrimSpace(network), *assetFlag, *payToFlag, amount, description)
+			if err != nil {
+				log.Fatal(err)
+			}
+			reqs = append(reqs, req)
+		}
+		return reqs
+	}
+	for tool, amount := range toolPrices {
+		config.PaymentTools[tool] = requirements(amount, tool)
+	}
+	if *defaultPriceFlag != "" {
+		config.DefaultPaymentRequirements = requirements(*defaultPriceFlag, "MCP tool call")
+	}
+
+	handler, err := x402server.NewX402Proxy(*upstreamFlag, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(*pathFlag, handler)
+	log.Printf("Proxying %s%s to %s with x402 payments", *listenFlag, *pathFlag, *upstreamFlag)
+	log.Fatal(http.ListenAndServe(*listenFlag, mux))
+}
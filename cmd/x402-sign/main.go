@@ -0,0 +1,125 @@
+// Command x402-sign signs payment requests exported with
+// X402Transport.ExportPaymentRequest, for keys kept on an isolated machine.
+//
+//	x402-sign -in request.json -out signed.json
+//
+// The EVM private key is read from -key or WALLET_PRIVATE_KEY; Solana keys from
+// -solana-keyfile. The request is shown for confirmation unless -yes is given.
+// EVM payments are signed fully offline; Solana payments need RPC access for a
+// recent blockhash.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	x402 "github.com/mark3labs/mcp-go-x402"
+)
+
+func main() {
+	var (
+		inFlag        = flag.String("in", "-", "Exported payment request (- for stdin)")
+		outFlag       = flag.String("out", "-", "Where to write the signed payment (- for stdout)")
+		keyFlag       = flag.String("key", "", "EVM private key hex (or set WALLET_PRIVATE_KEY env var)")
+		solanaKeyFlag = flag.String("solana-keyfile", "", "Solana keypair file")
+		yesFlag       = flag.Bool("yes", false, "Sign without asking for confirmation")
+	)
+	flag.Parse()
+
+	if *inFlag == "-" && !*yesFlag {
+		log.Fatal("Confirmation is read from stdin: pass the request with -in, or use -yes")
+	}
+
+	exported, err := readInput(*inFlag)
+	if err != nil {
+		log.Fatalf("Failed to read payment request: %v", err)
+	}
+
+	var request x402.OfflinePaymentRequest
+	if err := json.Unmarshal(exported, &request); err != nil {
+		log.Fatalf("Invalid payment request: %v", err)
+	}
+
+	signer, err := newSigner(*keyFlag, *solanaKeyFlag, request.Requirement.Network)
+	if err != nil {
+		log.Fatalf("Failed to create signer: %v", err)
+	}
+
+	req := request.Requirement
+	fmt.Fprintf(os.Stderr, "Server:    %s\n", request.Server)
+	fmt.Fprintf(os.Stderr, "Request:   %s (id %s)\n", request.Method, request.RequestID.String())
+	fmt.Fprintf(os.Stderr, "Resource:  %s\n", req.Resource)
+	fmt.Fprintf(os.Stderr, "Amount:    %s of %s on %s\n", req.MaxAmountRequired, req.Asset, req.Network)
+	fmt.Fprintf(os.Stderr, "Pay to:    %s\n", req.PayTo)
+	fmt.Fprintf(os.Stderr, "Payer:     %s\n", signer.GetAddress())
+
+	if !*yesFlag && !confirm("Sign this payment? [y/N] ") {
+		log.Fatal("Payment not signed")
+	}
+
+	signed, err := x402.SignOfflinePaymentRequest(context.Background(), signer, exported)
+	if err != nil {
+		log.Fatalf("Failed to sign payment: %v", err)
+	}
+
+	if err := writeOutput(*outFlag, signed); err != nil {
+		log.Fatalf("Failed to write signed payment: %v", err)
+	}
+}
+
+// newSigner creates a signer accepting USDC on every network of its key type
+func newSigner(evmKey, solanaKeyfile, network string) (x402.PaymentSigner, error) {
+	if strings.HasPrefix(network, "solana") {
+		if solanaKeyfile == "" {
+			return nil, fmt.Errorf("a Solana payment needs -solana-keyfile")
+		}
+		return x402.NewSolanaPrivateKeySignerFromFile(solanaKeyfile,
+			x402.AcceptUSDCSolana(),
+			x402.AcceptUSDCSolanaDevnet(),
+		)
+	}
+
+	if evmKey == "" {
+		evmKey = os.Getenv("WALLET_PRIVATE_KEY")
+	}
+	if evmKey == "" {
+		return nil, fmt.Errorf("private key required: use -key flag or set WALLET_PRIVATE_KEY environment variable")
+	}
+	return x402.NewPrivateKeySigner(evmKey,
+		x402.AcceptUSDCBase(),
+		x402.AcceptUSDCBaseSepolia(),
+		x402.AcceptUSDCPolygon(),
+		x402.AcceptUSDCPolygonAmoy(),
+		x402.AcceptUSDCAvalanche(),
+		x402.AcceptUSDCAvalancheFuji(),
+	)
+}
+
+func confirm(prompt string) bool {
+	fmt.Fprint(os.Stderr, prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func writeOutput(path string, data []byte) error {
+	if path == "-" {
+		_, err := os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
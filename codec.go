@@ -0,0 +1,65 @@
+package x402
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// HeaderPaymentEncoding names the Codec content type of an X-PAYMENT header
+// that is not JSON encoded
+const HeaderPaymentEncoding = "X-PAYMENT-ENCODING"
+
+// Codec serializes x402 messages on the wire: payments in the X-PAYMENT header
+// and facilitator request and response bodies. JSONCodec is the default; plug in
+// a CBOR or protobuf codec for facilitators expecting those. Payments sent in
+// JSON-RPC _meta are always JSON.
+type Codec interface {
+	// ContentType is the media type of the encoding, e.g. "application/cbor"
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec encodes messages as JSON
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                { return "application/json" }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// CodecOrJSON returns codec, or JSONCodec when nil
+func CodecOrJSON(codec Codec) Codec {
+	if codec == nil {
+		return JSONCodec
+	}
+	return codec
+}
+
+// IsJSONCodec reports whether codec encodes as JSON, so that its payments need
+// no HeaderPaymentEncoding
+func IsJSONCodec(codec Codec) bool {
+	return CodecOrJSON(codec).ContentType() == JSONCodec.ContentType()
+}
+
+// EncodeWith encodes the payment payload with codec (JSON when nil) as base64
+// for the X-PAYMENT header
+func (p *PaymentPayload) EncodeWith(codec Codec) (string, error) {
+	data, err := CodecOrJSON(codec).Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// DecodePaymentHeader decodes an X-PAYMENT header encoded with codec (JSON when
+// nil) into payment
+func DecodePaymentHeader(header string, codec Codec, payment any) error {
+	data, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return fmt.Errorf("invalid base64: %w", err)
+	}
+	return CodecOrJSON(codec).Unmarshal(data, payment)
+}
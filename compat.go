@@ -0,0 +1,148 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// CompatibilityReport describes which of a server's payable tools a wallet
+// configuration can pay, as found by CheckCompatibility
+type CompatibilityReport struct {
+	// Capability is the server's advertised x402 support, nil when it advertises none
+	Capability *Capability
+
+	// Currencies are the advertised currencies and whether a signer pays in them
+	Currencies []CurrencyCompatibility
+
+	// AccessFee is set when the server charges a session access fee before
+	// listing its tools. Tools is then empty, as listing them requires paying.
+	AccessFee *ToolCompatibility
+
+	Tools []ToolCompatibility
+}
+
+// CurrencyCompatibility is an advertised currency and whether a signer pays in it
+type CurrencyCompatibility struct {
+	CapabilityCurrency
+	Payable bool
+}
+
+// ToolCompatibility is whether the signers can pay a tool
+type ToolCompatibility struct {
+	Name string
+
+	// Quoted is true when the server quoted the tool's price. Free tools and
+	// tools of servers without quotes are unquoted; QuoteError tells which.
+	Quoted     bool
+	QuoteError string
+
+	// Accepts are the quoted payment options, and Selection how the signers
+	// would pay them
+	Accepts   []PaymentRequirement
+	Selection *SelectionExplanation
+
+	// Payable is true when a signer can pay one of Accepts
+	Payable bool
+}
+
+// PayableTools returns the names of the quoted tools the signers can pay
+func (r *CompatibilityReport) PayableTools() []string {
+	var names []string
+	for _, tool := range r.Tools {
+		if tool.Payable {
+			names = append(names, tool.Name)
+		}
+	}
+	return names
+}
+
+// CheckCompatibility connects to an MCP server without paying anything and
+// reports which of its payable tools the signers can afford and pay on, so an
+// agent can vet a server before relying on it. Tool prices come from price
+// quotes, so only servers with quotes enabled report per-tool results;
+// otherwise the advertised currencies are checked. Affordability is judged by
+// each option's MaxAmount and AmountPolicy, not wallet balances.
+func CheckCompatibility(ctx context.Context, serverURL string, signers ...PaymentSigner) (*CompatibilityReport, error) {
+	t, err := New(Config{ServerURL: serverURL, Signers: signers, ManualPaymentMode: true})
+	if err != nil {
+		return nil, err
+	}
+	c := client.NewClient(t)
+	defer c.Close()
+
+	if err := c.Start(ctx); err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", serverURL, err)
+	}
+	initRequest := mcp.InitializeRequest{}
+	initRequest.Params.ProtocolVersion = mcp.LATEST_PROTOCOL_VERSION
+	initRequest.Params.ClientInfo = mcp.Implementation{Name: "x402-compatibility-check", Version: "1.0.0"}
+	if _, err := c.Initialize(ctx, initRequest); err != nil {
+		return nil, fmt.Errorf("initializing: %w", err)
+	}
+
+	report := &CompatibilityReport{}
+	if capability, ok := t.ServerCapability(); ok {
+		report.Capability = capability
+		for _, currency := range capability.Currencies {
+			report.Currencies = append(report.Currencies, CurrencyCompatibility{
+				CapabilityCurrency: currency,
+				Payable:            paysIn(t.handler.signers, currency),
+			})
+		}
+	}
+
+	var tools []mcp.Tool
+	listRequest := mcp.ListToolsRequest{}
+	for {
+		result, err := c.ListTools(ctx, listRequest)
+		var paymentRequired *PaymentRequiredError
+		if errors.As(err, &paymentRequired) {
+			report.AccessFee = t.handler.toolCompatibility("access", paymentRequired.Requirements.Accepts)
+			return report, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("listing tools: %w", err)
+		}
+		tools = append(tools, result.Tools...)
+		if result.NextCursor == "" {
+			break
+		}
+		listRequest.Params.Cursor = result.NextCursor
+	}
+
+	for _, tool := range tools {
+		quote, err := t.RequestQuote(ctx, tool.Name)
+		if err != nil {
+			report.Tools = append(report.Tools, ToolCompatibility{Name: tool.Name, QuoteError: err.Error()})
+			continue
+		}
+		report.Tools = append(report.Tools, *t.handler.toolCompatibility(tool.Name, quote.Accepts))
+	}
+	return report, nil
+}
+
+// toolCompatibility explains how the handler would pay accepts
+func (h *PaymentHandler) toolCompatibility(name string, accepts []PaymentRequirement) *ToolCompatibility {
+	selection := h.ExplainSelection(accepts)
+	return &ToolCompatibility{
+		Name:      name,
+		Quoted:    true,
+		Accepts:   accepts,
+		Selection: selection,
+		Payable:   selection.Selected() != nil,
+	}
+}
+
+// paysIn reports whether a signer has an option for currency
+func paysIn(signers []PaymentSigner, currency CapabilityCurrency) bool {
+	for _, signer := range signers {
+		if option := signer.GetPaymentOption(currency.Network, currency.Asset); option != nil && SchemePays(option.Scheme, currency.Scheme) {
+			return true
+		}
+	}
+	return false
+}
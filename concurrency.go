@@ -0,0 +1,70 @@
+package x402
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// signingSlots bounds the number of concurrent SignPayment calls on one wallet.
+// A nil signingSlots is unlimited.
+type signingSlots chan struct{}
+
+// newSigningSlots returns slots for n concurrent signings; n <= 0 means unlimited
+func newSigningSlots(n int) signingSlots {
+	if n <= 0 {
+		return nil
+	}
+	return make(signingSlots, n)
+}
+
+// acquire waits for a free slot and returns the function releasing it
+func (s signingSlots) acquire(ctx context.Context) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	select {
+	case s <- struct{}{}:
+		return func() { <-s }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// recentMessageTTL is how long a signed Solana message is remembered; it
+// outlives the ~60-90s during which a blockhash is accepted by the cluster
+const recentMessageTTL = 2 * time.Minute
+
+// recentMessages remembers the transaction messages a wallet recently signed so
+// that two concurrent payments never produce the same transaction: identical
+// transfers built on the same blockhash would share a signature and the second
+// would be rejected as a duplicate once the fee payer submits it.
+type recentMessages struct {
+	mu    sync.Mutex
+	seen  map[[sha256.Size]byte]time.Time
+	clock Clock // The signer's clock, the system clock when nil
+}
+
+// claim records message and reports whether it was not signed recently
+func (r *recentMessages) claim(message []byte, now time.Time) bool {
+	key := sha256.Sum256(message)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.seen == nil {
+		r.seen = make(map[[sha256.Size]byte]time.Time)
+	}
+	for k, at := range r.seen {
+		if now.Sub(at) > recentMessageTTL {
+			delete(r.seen, k)
+		}
+	}
+
+	if _, ok := r.seen[key]; ok {
+		return false
+	}
+	r.seen[key] = now
+	return true
+}
@@ -0,0 +1,203 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MetaKeyCost is the result _meta key CostAwareClient uses to annotate tool results
+const MetaKeyCost = "x402/cost"
+
+// Tool _meta keys servers mark payable tools with, so hosts rendering tool
+// catalogs can show payment badges without calling the tools first
+const (
+	MetaKeyPaid     = "x402/paid"
+	MetaKeyCostHint = "x402/cost-hint"
+)
+
+// CostHint is a payment option a server advertises for a tool. The price
+// charged may differ with pricing experiments or payer identity.
+type CostHint struct {
+	Scheme  string `json:"scheme"`
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+	Amount  string `json:"amount"` // Atomic units of Asset
+}
+
+// ToolCostHints returns the payment options advertised for a listed tool, and
+// whether the server marked it paid
+func ToolCostHints(tool mcp.Tool) ([]CostHint, bool) {
+	if tool.Meta == nil {
+		return nil, false
+	}
+	paid, _ := tool.Meta.AdditionalFields[MetaKeyPaid].(bool)
+	raw, err := json.Marshal(tool.Meta.AdditionalFields[MetaKeyCostHint])
+	if err != nil {
+		return nil, paid
+	}
+	var hints []CostHint
+	_ = json.Unmarshal(raw, &hints)
+	return hints, paid
+}
+
+// PaymentCost is one payment made while serving a request
+type PaymentCost struct {
+	Amount   string `json:"amount"` // Atomic units of Asset
+	Asset    string `json:"asset"`
+	Network  string `json:"network"`
+	Resource string `json:"resource"`
+}
+
+// ToolCost is what a tool call actually cost
+type ToolCost struct {
+	Payments    []PaymentCost `json:"payments,omitempty"`
+	Transaction string        `json:"transaction,omitempty"` // Settlement of the tool payment
+}
+
+// Paid reports whether any payment was made
+func (c *ToolCost) Paid() bool {
+	return c != nil && len(c.Payments) > 0
+}
+
+// Total sums the amounts paid in the given asset
+func (c *ToolCost) Total(asset string) *big.Int {
+	total := new(big.Int)
+	if c == nil {
+		return total
+	}
+	for _, p := range c.Payments {
+		if amount, ok := new(big.Int).SetString(p.Amount, 10); ok && p.Asset == asset {
+			total.Add(total, amount)
+		}
+	}
+	return total
+}
+
+// costCollector gathers payments made by the transport for one call
+type costCollector struct {
+	mu       sync.Mutex
+	payments []PaymentCost
+}
+
+type costCollectorKey struct{}
+
+// recordCost adds the payment made to the collector in ctx, if any, at the
+// amount the payment authorizes when readable
+func recordCost(ctx context.Context, reqs PaymentRequirementsResponse, payment *PaymentPayload) {
+	collector, ok := ctx.Value(costCollectorKey{}).(*costCollector)
+	if !ok || payment == nil {
+		return
+	}
+	req, ok := paidRequirement(ctx, reqs, payment)
+	if !ok {
+		return
+	}
+
+	collector.mu.Lock()
+	collector.payments = append(collector.payments, PaymentCost{
+		Amount:   paidAmount(req, payment),
+		Asset:    req.Asset,
+		Network:  req.Network,
+		Resource: req.Resource,
+	})
+	collector.mu.Unlock()
+}
+
+// paidRequirement returns the requirement payment pays: the one signed within
+// ctx (see withSelection), or else the first matching its scheme, network and,
+// for EVM payments, recipient
+func paidRequirement(ctx context.Context, reqs PaymentRequirementsResponse, payment *PaymentPayload) (PaymentRequirement, bool) {
+	if paid := selectionFromContext(ctx); paid != nil {
+		return paid.requirement, true
+	}
+	data, evm := payment.Payload.(PaymentPayloadData)
+	for _, req := range reqs.Accepts {
+		if req.Network != payment.Network || req.Scheme != payment.Scheme {
+			continue
+		}
+		if evm && !strings.EqualFold(req.PayTo, data.Authorization.To) {
+			continue
+		}
+		return req, true
+	}
+	return PaymentRequirement{}, false
+}
+
+// paidAmount returns the amount payment authorizes, or req's if unreadable
+func paidAmount(req PaymentRequirement, payment *PaymentPayload) string {
+	if data, ok := payment.Payload.(PaymentPayloadData); ok && data.Authorization.Value != "" {
+		return data.Authorization.Value
+	}
+	return req.MaxAmountRequired
+}
+
+// paidRequirements narrows reqs to the requirement payment pays, at the amount
+// it authorizes, so success events report what was paid rather than the first
+// option offered
+func paidRequirements(ctx context.Context, reqs PaymentRequirementsResponse, payment *PaymentPayload) PaymentRequirementsResponse {
+	if payment == nil {
+		return reqs
+	}
+	req, ok := paidRequirement(ctx, reqs, payment)
+	if !ok {
+		return reqs
+	}
+	req.MaxAmountRequired = paidAmount(req, payment)
+	reqs.Accepts = []PaymentRequirement{req}
+	return reqs
+}
+
+// CostAwareClient wraps an MCP client using X402Transport and reports what each
+// tool call cost, so agents can weigh the cost-effectiveness of tools
+type CostAwareClient struct {
+	*client.Client
+}
+
+// NewCostAwareClient wraps c
+func NewCostAwareClient(c *client.Client) *CostAwareClient {
+	return &CostAwareClient{Client: c}
+}
+
+// CallTool calls a tool and annotates the result's _meta["x402/cost"] with its cost
+func (c *CostAwareClient) CallTool(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	result, cost, err := c.CallToolWithCost(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Meta == nil {
+		result.Meta = &mcp.Meta{}
+	}
+	if result.Meta.AdditionalFields == nil {
+		result.Meta.AdditionalFields = make(map[string]any)
+	}
+	result.Meta.AdditionalFields[MetaKeyCost] = cost
+	return result, nil
+}
+
+// CallToolWithCost calls a tool and returns the result with the payments it required
+func (c *CostAwareClient) CallToolWithCost(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, *ToolCost, error) {
+	collector := &costCollector{}
+	result, err := c.Client.CallTool(context.WithValue(ctx, costCollectorKey{}, collector), request)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	collector.mu.Lock()
+	cost := &ToolCost{Payments: collector.payments}
+	collector.mu.Unlock()
+
+	if result.Meta != nil {
+		response, _ := MetaNamespace{}.LookupPaymentResponse(result.Meta.AdditionalFields)
+		if settlement, ok := response.(map[string]any); ok {
+			cost.Transaction, _ = settlement["transaction"].(string)
+		}
+	}
+	return result, cost, nil
+}
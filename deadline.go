@@ -0,0 +1,106 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MetaKeySettlementPending is the _meta key of progress notifications servers
+// send while a slow settlement is pending. Its value is a SettlementPending.
+const MetaKeySettlementPending = "x402/settlement-pending"
+
+// SettlementPending asks the client to wait longer for a paid response whose
+// settlement is still running
+type SettlementPending struct {
+	// ExtendSeconds is how much longer the client should wait
+	ExtendSeconds int `json:"extendSeconds"`
+}
+
+// paymentDeadline is the MaxPaymentOverhead sub-deadline of a payment, which
+// server hints can push back by up to MaxDeadlineExtension in total
+type paymentDeadline struct {
+	context.Context
+	cancel context.CancelCauseFunc
+
+	mu        sync.Mutex
+	timer     *time.Timer
+	deadline  time.Time
+	remaining time.Duration // Extension budget left
+}
+
+type paymentDeadlineKey struct{}
+
+// newPaymentDeadline returns a context ending after overhead unless extended
+func newPaymentDeadline(ctx context.Context, overhead, maxExtension time.Duration) (*paymentDeadline, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	d := &paymentDeadline{
+		cancel:    cancel,
+		deadline:  time.Now().Add(overhead),
+		remaining: maxExtension,
+	}
+	d.Context = context.WithValue(ctx, paymentDeadlineKey{}, d)
+	d.timer = time.AfterFunc(overhead, func() { cancel(context.DeadlineExceeded) })
+	return d, func() {
+		d.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Deadline returns the extended deadline, or the parent's if earlier
+func (d *paymentDeadline) Deadline() (time.Time, bool) {
+	d.mu.Lock()
+	deadline := d.deadline
+	d.mu.Unlock()
+	if parent, ok := d.Context.Deadline(); ok && parent.Before(deadline) {
+		return parent, true
+	}
+	return deadline, true
+}
+
+// extend pushes the deadline back by up to by, within the extension budget,
+// returning the extension granted. Expired deadlines are not extended.
+func (d *paymentDeadline) extend(by time.Duration) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	by = min(by, d.remaining)
+	if by <= 0 || !d.timer.Stop() {
+		return 0
+	}
+	d.deadline = d.deadline.Add(by)
+	d.remaining -= by
+	d.timer.Reset(time.Until(d.deadline))
+	return by
+}
+
+// extendPaymentDeadline extends the payment deadline of ctx, if it has an
+// extensible one, by a server's hint
+func (t *X402Transport) extendPaymentDeadline(ctx context.Context, seconds int, reason string) {
+	d, ok := ctx.Value(paymentDeadlineKey{}).(*paymentDeadline)
+	if !ok || seconds <= 0 {
+		return
+	}
+	if granted := d.extend(time.Duration(seconds) * time.Second); granted > 0 && t.verbose {
+		t.logger.Printf("[X402] Extended payment deadline by %s: %s", granted, reason)
+	}
+}
+
+// settlementPendingHint returns the extension a progress notification asks for
+func settlementPendingHint(notification mcp.JSONRPCNotification) (int, bool) {
+	raw, ok := notification.Params.Meta[MetaKeySettlementPending]
+	if !ok {
+		return 0, false
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return 0, false
+	}
+	var pending SettlementPending
+	if json.Unmarshal(data, &pending) != nil || pending.ExtendSeconds <= 0 {
+		return 0, false
+	}
+	return pending.ExtendSeconds, true
+}
@@ -0,0 +1,206 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Rules that decline payments, as reported in DeclinedPayment.Rule
+const (
+	DeclineRulePaymentCallback   = "payment_callback"
+	DeclineRuleTrustedRecipients = "trusted_recipients"
+	DeclineRuleForcedPayment     = "forced_payment"
+	DeclineRuleCatalogPrice      = "catalog_price"
+	DeclineRulePaymentLimit      = "payments_per_request"
+	DeclineRuleResourceBackoff   = "resource_backoff"
+	DeclineRuleQuarantine        = "server_quarantine"
+)
+
+// DeclinedPayment is an audit record of a payment the client's policy refused.
+// When an Attester is configured the record is signed, so operators can prove
+// what their agents were asked to pay and why it was refused.
+type DeclinedPayment struct {
+	Timestamp    int64                `json:"timestamp"`
+	Server       string               `json:"server"`
+	Method       string               `json:"method"`
+	Payers       []string             `json:"payers"` // Addresses of the configured signers
+	Rule         string               `json:"rule"`
+	Reason       string               `json:"reason"`
+	Requirements []PaymentRequirement `json:"requirements"`
+	Attribution  map[string]string    `json:"attribution,omitempty"`
+	OnBehalfOf   string               `json:"onBehalfOf,omitempty"` // End user set with WithOnBehalfOf
+	Attester     string               `json:"attester,omitempty"`
+	Signature    string               `json:"signature,omitempty"` // Attester's signature over SigningMessage
+}
+
+// SigningMessage returns the text signed by the attester: the record without its signature
+func (d DeclinedPayment) SigningMessage() (string, error) {
+	d.Signature = ""
+	body, err := json.Marshal(d)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode declined payment: %w", err)
+	}
+	return "x402 declined payment\n" + string(body), nil
+}
+
+// Attester signs audit records on behalf of the client
+type Attester interface {
+	// Subject identifies the attester in signed records
+	Subject() string
+	// Attest signs message
+	Attest(message string) (signature string, err error)
+}
+
+// Attest implements Attester with EIP-191 personal_sign
+func (i *EVMIdentity) Attest(message string) (string, error) {
+	signature, err := personalSign(i.privateKey, message)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign attestation: %w", err)
+	}
+	return signature, nil
+}
+
+// VerifyDeclinedPayment checks that a record was signed by its EVM attester
+func VerifyDeclinedPayment(record DeclinedPayment) error {
+	attester, err := identityAddress(record.Attester)
+	if err != nil {
+		return err
+	}
+	message, err := record.SigningMessage()
+	if err != nil {
+		return err
+	}
+	signer, err := recoverPersonalSigner(message, record.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid attestation: %w", err)
+	}
+	if signer != attester {
+		return fmt.Errorf("invalid attestation: signed by %s, not %s", signer.Hex(), attester.Hex())
+	}
+	return nil
+}
+
+// DeclineLedger persists declined payment records
+type DeclineLedger interface {
+	RecordDecline(record DeclinedPayment) error
+}
+
+// FileDeclineLedger appends declined payments to a file as JSON lines
+type FileDeclineLedger struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileDeclineLedger opens (or creates) a ledger file for appending
+func NewFileDeclineLedger(path string) (*FileDeclineLedger, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open decline ledger: %w", err)
+	}
+	return &FileDeclineLedger{file: file}, nil
+}
+
+// RecordDecline implements DeclineLedger
+func (l *FileDeclineLedger) RecordDecline(record DeclinedPayment) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode declined payment: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Flush implements Flusher, committing recorded declines to stable storage
+func (l *FileDeclineLedger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Sync()
+}
+
+// Close closes the ledger file
+func (l *FileDeclineLedger) Close() error {
+	return l.file.Close()
+}
+
+// declineRule names the policy rule behind a payment error, or "" if the
+// payment failed for another reason
+func declineRule(err error) string {
+	switch {
+	case errors.Is(err, ErrPaymentLimitExceeded):
+		return DeclineRulePaymentLimit
+	case errors.Is(err, ErrPaymentBackoff):
+		return DeclineRuleResourceBackoff
+	case errors.Is(err, ErrServerQuarantined):
+		return DeclineRuleQuarantine
+	case errors.Is(err, ErrUntrustedRecipient):
+		return DeclineRuleTrustedRecipients
+	case errors.Is(err, ErrForcedPaymentOption):
+		return DeclineRuleForcedPayment
+	case errors.Is(err, ErrCatalogPriceChanged):
+		return DeclineRuleCatalogPrice
+	case errors.Is(err, ErrPaymentDeclined):
+		return DeclineRulePaymentCallback
+	}
+
+	var multi *MultiSignerError
+	if errors.As(err, &multi) {
+		for _, failure := range multi.SignerFailures {
+			if rule := declineRule(failure.WrappedError); rule != "" {
+				return rule
+			}
+		}
+	}
+	return ""
+}
+
+// recordDecline audits a payment refused by the client's policy
+func (t *X402Transport) recordDecline(ctx context.Context, method string, reqs PaymentRequirementsResponse, err error) {
+	if t.declineLedger == nil && t.onPaymentDeclined == nil {
+		return
+	}
+	rule := declineRule(err)
+	if rule == "" {
+		return
+	}
+
+	record := DeclinedPayment{
+		Timestamp:    t.clock.Now().Unix(),
+		Server:       t.serverURL.String(),
+		Method:       method,
+		Rule:         rule,
+		Reason:       err.Error(),
+		Requirements: reqs.Accepts,
+		Attribution:  AttributionFromContext(ctx),
+		OnBehalfOf:   OnBehalfOfFromContext(ctx),
+	}
+	for _, signer := range t.handler.signers {
+		record.Payers = append(record.Payers, signer.GetAddress())
+	}
+
+	if t.attester != nil {
+		record.Attester = t.attester.Subject()
+		message, err := record.SigningMessage()
+		if err == nil {
+			record.Signature, err = t.attester.Attest(message)
+		}
+		if err != nil {
+			t.logger.Printf("[X402] Failed to sign declined payment record: %v", err)
+		}
+	}
+
+	if t.declineLedger != nil {
+		if err := t.declineLedger.RecordDecline(record); err != nil {
+			t.logger.Printf("[X402] Failed to record declined payment: %v", err)
+		}
+	}
+	if t.onPaymentDeclined != nil {
+		t.onPaymentDeclined(record)
+	}
+}
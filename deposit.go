@@ -0,0 +1,161 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// JSON-RPC methods of prepaid deposits. Each takes the deposit token in
+// params.token; MethodDeposit without one opens a new deposit.
+const (
+	MethodDeposit         = "x402/deposit"          // Pay a deposit, or refill one
+	MethodDepositBalance  = "x402/deposit/balance"  // Look up a deposit's balance
+	MethodDepositWithdraw = "x402/deposit/withdraw" // Close a deposit, refunding its balance
+)
+
+// DepositResource is the resource URI servers use for deposit payments
+const DepositResource = "mcp://server/deposit"
+
+// MetaKeyDeposit is the _meta key carrying the deposit token in tool calls, so
+// the server draws their price from the deposit instead of asking for payment
+const MetaKeyDeposit = "x402/deposit"
+
+// MetaKeyDepositBalance is the result _meta key carrying the deposit balance
+// left after a call drawn from it
+const MetaKeyDepositBalance = "x402/deposit-balance"
+
+// DepositFunds is the balance of a deposit in one asset, in its base units
+type DepositFunds struct {
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+	Amount  string `json:"amount"`
+}
+
+// Deposit is a server's answer to the deposit methods: the deposit's token and
+// balances. For a withdrawal Balances are the refunded amounts and Transaction
+// the refund.
+type Deposit struct {
+	Token       string         `json:"token,omitempty"`
+	Balances    []DepositFunds `json:"balances"`
+	Transaction string         `json:"transaction,omitempty"`
+}
+
+// ParseDepositBalance reads the deposit balance left after a tool call drawn
+// from a deposit
+func ParseDepositBalance(result json.RawMessage) (*Deposit, bool) {
+	var decoded struct {
+		Meta struct {
+			Balance *Deposit `json:"x402/deposit-balance"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(result, &decoded) != nil || decoded.Meta.Balance == nil {
+		return nil, false
+	}
+	return decoded.Meta.Balance, true
+}
+
+// depositToken holds the token of the transport's deposit
+type depositToken struct {
+	mu    sync.RWMutex
+	token string
+}
+
+func (d *depositToken) get() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.token
+}
+
+func (d *depositToken) set(token string) {
+	d.mu.Lock()
+	d.token = token
+	d.mu.Unlock()
+}
+
+// DepositToken returns the token of the deposit tool calls draw from, or "" when
+// there is none. Save it to keep using the deposit after a restart.
+func (t *X402Transport) DepositToken() string {
+	return t.deposit.get()
+}
+
+// SetDepositToken makes tool calls draw from the deposit with token, e.g. one
+// saved from DepositToken. An empty token stops drawing from a deposit.
+func (t *X402Transport) SetDepositToken(token string) {
+	t.deposit.set(token)
+}
+
+// Deposit pays the server's deposit, or refills the transport's deposit when it
+// has one. The payment goes through the usual 402 flow, so budgets, approval
+// and payment events apply. Later tool calls draw their price from the deposit
+// until it runs out, when they are paid per call again.
+func (t *X402Transport) Deposit(ctx context.Context) (*Deposit, error) {
+	deposit, err := t.callDeposit(ctx, MethodDeposit)
+	if err != nil {
+		return nil, err
+	}
+	t.deposit.set(deposit.Token)
+	return deposit, nil
+}
+
+// DepositBalance returns the balance of the transport's deposit
+func (t *X402Transport) DepositBalance(ctx context.Context) (*Deposit, error) {
+	if t.deposit.get() == "" {
+		return nil, ErrNoDeposit
+	}
+	return t.callDeposit(ctx, MethodDepositBalance)
+}
+
+// WithdrawDeposit closes the transport's deposit, asking the server to refund
+// its balance. It returns the refunded amounts and the refund transaction.
+func (t *X402Transport) WithdrawDeposit(ctx context.Context) (*Deposit, error) {
+	if t.deposit.get() == "" {
+		return nil, ErrNoDeposit
+	}
+	deposit, err := t.callDeposit(ctx, MethodDepositWithdraw)
+	if err != nil {
+		return nil, err
+	}
+	t.deposit.set("")
+	return deposit, nil
+}
+
+// callDeposit sends a deposit method with the transport's deposit token
+func (t *X402Transport) callDeposit(ctx context.Context, method string) (*Deposit, error) {
+	params := map[string]any{}
+	if token := t.deposit.get(); token != "" {
+		params["token"] = token
+	}
+	resp, err := t.SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      mcp.NewRequestId(fmt.Sprintf("x402-deposit-%d", time.Now().UnixNano())),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("%s failed: %s", method, resp.Error.Message)
+	}
+
+	var deposit Deposit
+	if err := json.Unmarshal(resp.Result, &deposit); err != nil {
+		return nil, fmt.Errorf("invalid %s response: %w", method, err)
+	}
+	return &deposit, nil
+}
+
+// attachDeposit adds the deposit token to tool calls, when the transport has a deposit
+func (t *X402Transport) attachDeposit(request transport.JSONRPCRequest) (transport.JSONRPCRequest, error) {
+	token := t.deposit.get()
+	if token == "" || request.Method != string(mcp.MethodToolsCall) {
+		return request, nil
+	}
+	return setRequestMeta(request, map[string]any{MetaKeyDeposit: token})
+}
@@ -11,10 +11,65 @@ var (
 	ErrNoAcceptablePayment = errors.New("no acceptable payment method found")
 	ErrSigningFailed       = errors.New("failed to sign payment")
 	ErrInvalidPaymentReqs  = errors.New("invalid payment requirements")
+	ErrUntrustedRecipient  = errors.New("payment recipient is not trusted")
+	ErrForcedPaymentOption = errors.New("server does not accept the forced network and asset")
+	ErrPaymentDeclined     = errors.New("payment declined by policy")
+	ErrSponsorshipFailed   = errors.New("paymaster declined to sponsor user operation")
+	ErrInsufficientFunds   = errors.New("insufficient funds")
+
+	// ErrInvalidScheme is returned by RegisterScheme for a scheme it can't register
+	ErrInvalidScheme = errors.New("invalid payment scheme")
+
+	// ErrBridgeBudgetExceeded is returned when a bridge transfer would exceed BridgingConfig caps
+	ErrBridgeBudgetExceeded = errors.New("bridge transfer exceeds budget")
+
+	// ErrPaymentRejected is returned when the server answers a paid request with another 402
+	ErrPaymentRejected = errors.New("payment rejected by server")
+
+	// Payment safeguard errors
+	ErrPaymentLimitExceeded = errors.New("payment limit per request exceeded")
+	ErrPaymentBackoff       = errors.New("resource is backing off after rejected payments")
+	ErrServerQuarantined    = errors.New("server quarantined after rejected payments")
+
+	// ErrBudgetExceeded is returned when a payment would exceed the BudgetManager's limits
+	ErrBudgetExceeded = errors.New("payment exceeds budget")
+
+	// ErrQuoteExceeded is returned when a server asks more than the quote attached to a request
+	ErrQuoteExceeded = errors.New("payment requirements exceed the quoted price")
+
+	// ErrCatalogPriceChanged is returned with PriceChangeRefuse when a tool's 402
+	// asks more than the price advertised in tools/list
+	ErrCatalogPriceChanged = errors.New("payment requirements exceed the catalog price")
+
+	// ErrDeadlineTooShort is returned when no payment option's MaxTimeoutSeconds fits the request deadline
+	ErrDeadlineTooShort = errors.New("request deadline is shorter than the payment timeout")
+
+	// ErrPaymentOverheadExceeded is returned when paying takes longer than MaxPaymentOverhead
+	ErrPaymentOverheadExceeded = errors.New("payment exceeded its time budget")
+
+	// ErrNoDeposit is returned by DepositBalance and WithdrawDeposit when the
+	// transport has no deposit
+	ErrNoDeposit = errors.New("no deposit")
+
+	// ErrChaosDropped is returned for calls whose response a ChaosConfig dropped
+	ErrChaosDropped = errors.New("chaos: response dropped")
+
+	// ErrResultIntegrity is returned when a paid result fails VerifyResultIntegrity
+	ErrResultIntegrity = errors.New("paid result failed integrity check")
+
+	// ErrOutputSchemaViolation is returned with OutputSchemaFail when a paid result
+	// does not match its outputSchema
+	ErrOutputSchemaViolation = errors.New("paid result does not match its output schema")
+
+	// Settlement finality errors, reported to FinalityConfig.OnFailure
+	ErrSettlementFailed  = errors.New("settlement transaction failed on chain")
+	ErrSettlementReorged = errors.New("settlement transaction left the chain")
+	ErrFinalityTimeout   = errors.New("settlement did not become final in time")
 
 	// Network errors
 	ErrUnsupportedNetwork = errors.New("unsupported network")
 	ErrUnsupportedAsset   = errors.New("unsupported asset")
+	ErrInvalidAmount      = errors.New("invalid payment amount")
 
 	// Signer errors
 	ErrInvalidPrivateKey     = errors.New("invalid private key")
@@ -89,10 +144,29 @@ func (e *MultiSignerError) Error() string {
 	return result
 }
 
-// Unwrap returns the first signer's wrapped error if available
-func (e *MultiSignerError) Unwrap() error {
-	if len(e.SignerFailures) > 0 && e.SignerFailures[0].WrappedError != nil {
-		return e.SignerFailures[0].WrappedError
+// Unwrap returns every signer's wrapped error, so errors.Is and errors.As
+// match any signer's failure (e.g. ErrPaymentDeclined from a later signer)
+func (e *MultiSignerError) Unwrap() []error {
+	var errs []error
+	for _, failure := range e.SignerFailures {
+		if failure.WrappedError != nil {
+			errs = append(errs, failure.WrappedError)
+		}
+	}
+	return errs
+}
+
+// Is reports that all signers failed as ErrNoViablePaymentOption
+func (e *MultiSignerError) Is(target error) bool {
+	return target == ErrNoViablePaymentOption
+}
+
+// SignerFailures returns the per-signer failures carried by err, or nil if err
+// does not wrap a MultiSignerError
+func SignerFailures(err error) []SignerFailure {
+	var multi *MultiSignerError
+	if !errors.As(err, &multi) {
+		return nil
 	}
-	return nil
+	return multi.SignerFailures
 }
@@ -0,0 +1,319 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultFailureAlertAfter is how many consecutive failed payments raise a
+// WalletEventPaymentsFailing
+const defaultFailureAlertAfter = 3
+
+// WalletEventType is the kind of a WalletEvent
+type WalletEventType string
+
+const (
+	// WalletEventSpendThreshold is raised once when the transport's total spend
+	// of an asset crosses one of its SpendThresholds
+	WalletEventSpendThreshold WalletEventType = "spend_threshold"
+
+	// WalletEventPaymentsFailing is raised when FailureAlertAfter payments in a
+	// row have failed
+	WalletEventPaymentsFailing WalletEventType = "payments_failing"
+
+	// WalletEventPaymentsRecovered is raised by the first successful payment
+	// after a WalletEventPaymentsFailing
+	WalletEventPaymentsRecovered WalletEventType = "payments_recovered"
+)
+
+// WalletEvent is a change in an agent's payment activity operators should hear about
+type WalletEvent struct {
+	Type      WalletEventType `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	Server    string          `json:"server"`
+	Payers    []string        `json:"payers,omitempty"`
+	Network   string          `json:"network,omitempty"`
+	Asset     string          `json:"asset,omitempty"`
+
+	// Spent and Threshold are set on WalletEventSpendThreshold, in the asset's base units
+	Spent     string `json:"spent,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+
+	// Failures and Error are set on WalletEventPaymentsFailing: the failures in a
+	// row and the last failure
+	Failures int    `json:"failures,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// String describes the event in a sentence
+func (e WalletEvent) String() string {
+	switch e.Type {
+	case WalletEventSpendThreshold:
+		return fmt.Sprintf("x402: spent %s of %s on %s with %s, crossing %s", e.Spent, e.Asset, e.Network, e.Server, e.Threshold)
+	case WalletEventPaymentsFailing:
+		return fmt.Sprintf("x402: %d payments to %s failed in a row, last: %s", e.Failures, e.Server, e.Error)
+	case WalletEventPaymentsRecovered:
+		return fmt.Sprintf("x402: payments to %s are succeeding again", e.Server)
+	default:
+		return fmt.Sprintf("x402: %s at %s", e.Type, e.Server)
+	}
+}
+
+// EventSink receives WalletEvents, alongside the payment callbacks. Sinks are
+// notified asynchronously; Flush and Close wait for notifications in flight.
+type EventSink interface {
+	Notify(ctx context.Context, event WalletEvent) error
+}
+
+// EventSinkFunc adapts a function to the EventSink interface
+type EventSinkFunc func(ctx context.Context, event WalletEvent) error
+
+// Notify calls f
+func (f EventSinkFunc) Notify(ctx context.Context, event WalletEvent) error {
+	return f(ctx, event)
+}
+
+// WebhookSink POSTs events as JSON to a URL
+type WebhookSink struct {
+	url     string
+	client  *http.Client
+	headers map[string]string
+}
+
+// NewWebhookSink creates a sink posting to url, with optional headers (e.g. for
+// authentication)
+func NewWebhookSink(url string, headers map[string]string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: 10 * time.Second}, headers: headers}
+}
+
+// Notify implements EventSink
+func (s *WebhookSink) Notify(ctx context.Context, event WalletEvent) error {
+	return s.post(ctx, event)
+}
+
+func (s *WebhookSink) post(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range s.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// SlackSink posts events as messages to a Slack incoming webhook
+type SlackSink struct {
+	webhook *WebhookSink
+}
+
+// NewSlackSink creates a sink posting to a Slack incoming webhook URL
+func NewSlackSink(webhookURL string) *SlackSink {
+	return &SlackSink{webhook: NewWebhookSink(webhookURL, nil)}
+}
+
+// Notify implements EventSink
+func (s *SlackSink) Notify(ctx context.Context, event WalletEvent) error {
+	return s.webhook.post(ctx, map[string]string{"text": event.String()})
+}
+
+// ChannelSink delivers events on a channel, for in-process consumers
+type ChannelSink struct {
+	events chan WalletEvent
+}
+
+// NewChannelSink creates a sink buffering up to size events
+func NewChannelSink(size int) *ChannelSink {
+	return &ChannelSink{events: make(chan WalletEvent, size)}
+}
+
+// Events returns the channel events are delivered on
+func (s *ChannelSink) Events() <-chan WalletEvent {
+	return s.events
+}
+
+// Notify implements EventSink. Events are dropped when the buffer is full.
+func (s *ChannelSink) Notify(ctx context.Context, event WalletEvent) error {
+	select {
+	case s.events <- event:
+		return nil
+	default:
+		return fmt.Errorf("event channel full, dropping %s event", event.Type)
+	}
+}
+
+// walletMonitor raises WalletEvents from the payments of a transport and its sessions
+type walletMonitor struct {
+	sinks        []EventSink
+	thresholds   map[AssetKey][]*big.Int
+	failureAfter int
+
+	mu       sync.Mutex
+	spent    map[AssetKey]*big.Int
+	failures int
+	failing  bool
+
+	// Events awaiting delivery, delivered in order by one goroutine at a time
+	queue      []queuedEvent
+	delivering bool
+}
+
+// queuedEvent is a wallet event awaiting delivery. done ends its tracking by
+// the payment tracker of the session raising it.
+type queuedEvent struct {
+	event  WalletEvent
+	logger Logger
+	done   func()
+}
+
+// newWalletMonitor returns nil when config has no event sinks
+func newWalletMonitor(config Config) *walletMonitor {
+	if len(config.EventSinks) == 0 {
+		return nil
+	}
+	thresholds := make(map[AssetKey][]*big.Int, len(config.SpendThresholds))
+	for key, values := range config.SpendThresholds {
+		sorted := append([]*big.Int(nil), values...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Cmp(sorted[j]) < 0 })
+		key = newAssetKey(key.Network, key.Asset)
+		thresholds[key] = append(thresholds[key], sorted...)
+	}
+	failureAfter := config.FailureAlertAfter
+	if failureAfter <= 0 {
+		failureAfter = defaultFailureAlertAfter
+	}
+	return &walletMonitor{
+		sinks:        config.EventSinks,
+		thresholds:   thresholds,
+		failureAfter: failureAfter,
+		spent:        make(map[AssetKey]*big.Int),
+	}
+}
+
+// paid records a successful payment, returning the events it raises
+func (m *walletMonitor) paid(event PaymentEvent) []WalletEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var events []WalletEvent
+	m.failures = 0
+	if m.failing {
+		m.failing = false
+		events = append(events, WalletEvent{Type: WalletEventPaymentsRecovered})
+	}
+
+	if event.Amount == nil {
+		return events
+	}
+	amount := event.Amount
+	if event.TotalCost != nil {
+		amount = event.TotalCost
+	}
+	key := newAssetKey(event.Network, event.Asset)
+	before := m.spent[key]
+	if before == nil {
+		before = new(big.Int)
+	}
+	after := new(big.Int).Add(before, amount)
+	m.spent[key] = after
+
+	for _, threshold := range m.thresholds[key] {
+		if before.Cmp(threshold) < 0 && after.Cmp(threshold) >= 0 {
+			events = append(events, WalletEvent{
+				Type:      WalletEventSpendThreshold,
+				Network:   event.Network,
+				Asset:     event.Asset,
+				Spent:     after.String(),
+				Threshold: threshold.String(),
+			})
+		}
+	}
+	return events
+}
+
+// failed records a failed payment, returning the events it raises
+func (m *walletMonitor) failed(err error) []WalletEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.failures++
+	if m.failing || m.failures < m.failureAfter {
+		return nil
+	}
+	m.failing = true
+	return []WalletEvent{{Type: WalletEventPaymentsFailing, Failures: m.failures, Error: err.Error()}}
+}
+
+// notifySinks queues wallet events for delivery to the event sinks in the
+// background. Flush waits for their delivery.
+func (t *X402Transport) notifySinks(events []WalletEvent) {
+	if len(events) == 0 {
+		return
+	}
+	var payers []string
+	for _, signer := range t.handler.signers {
+		payers = append(payers, signer.GetAddress())
+	}
+
+	m := t.monitor
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, event := range events {
+		event.Timestamp = t.clock.Now().Unix()
+		event.Server = t.serverURL.String()
+		event.Payers = payers
+		t.payments.begin()
+		m.queue = append(m.queue, queuedEvent{event: event, logger: t.logger, done: t.payments.end})
+	}
+	if !m.delivering {
+		m.delivering = true
+		go m.deliver()
+	}
+}
+
+// deliver notifies the sinks of queued events until the queue is empty
+func (m *walletMonitor) deliver() {
+	for {
+		m.mu.Lock()
+		if len(m.queue) == 0 {
+			m.delivering = false
+			m.mu.Unlock()
+			return
+		}
+		queued := m.queue[0]
+		m.queue = m.queue[1:]
+		m.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), sinkNotifyTimeout)
+		for _, sink := range m.sinks {
+			if err := sink.Notify(ctx, queued.event); err != nil {
+				queued.logger.Printf("[X402] Failed to deliver %s event: %v", queued.event.Type, err)
+			}
+		}
+		cancel()
+		queued.done()
+	}
+}
+
+// sinkNotifyTimeout bounds the delivery of a wallet event to the sinks
+const sinkNotifyTimeout = 30 * time.Second
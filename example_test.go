@@ -0,0 +1,116 @@
+package x402_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http/httptest"
+
+	"github.com/mark3labs/mcp-go-x402"
+	x402server "github.com/mark3labs/mcp-go-x402/server"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const examplePayTo = "0x209693Bc6afc0C5328bA36FaF03C514EF312287C"
+
+// startSandboxServer serves a "search" tool costing 0.01 fake USDC on the
+// sandbox network, settled by the in-memory sandbox facilitator
+func startSandboxServer() *httptest.Server {
+	srv := x402server.NewX402Server("search-server", "1.0.0", &x402server.Config{
+		Facilitator: x402server.NewSandboxFacilitator(),
+	})
+	srv.AddPayableTool(
+		mcp.NewTool("search", mcp.WithString("query", mcp.Required())),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText("results for " + req.GetString("query", "")), nil
+		},
+		x402server.RequireUSDCSandbox(examplePayTo, "10000", "Search"),
+	)
+	return httptest.NewServer(srv.Handler())
+}
+
+func ExampleNew() {
+	httpServer := startSandboxServer()
+	defer httpServer.Close()
+
+	signer, err := x402.NewSandboxSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err := x402.New(x402.Config{
+		ServerURL: httpServer.URL,
+		Signers:   []x402.PaymentSigner{signer},
+		OnPaymentSuccess: func(event x402.PaymentEvent) {
+			fmt.Printf("Paid %s on %s\n", event.Amount, event.Network)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	mcpClient := client.NewClient(transport)
+	defer mcpClient.Close()
+	if err := mcpClient.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := mcpClient.Initialize(ctx, mcp.InitializeRequest{}); err != nil {
+		log.Fatal(err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	request.Params.Arguments = map[string]any{"query": "x402"}
+	result, err := mcpClient.CallTool(ctx, request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(result.Content[0].(mcp.TextContent).Text)
+	// Output:
+	// Paid 10000 on sandbox
+	// results for x402
+}
+
+func ExamplePaymentHandler_multiSigner() {
+	// Pay on the sandbox when the server accepts it, else on Solana devnet
+	sandboxSigner, err := x402.NewSandboxSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	solanaSigner := x402.NewMockSolanaSigner("DemoSolanaWallet", x402.AcceptUSDCSolanaDevnet()).WithPriority(1)
+
+	handler, err := x402.NewPaymentHandlerMulti([]x402.PaymentSigner{sandboxSigner, solanaSigner}, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	requirements := x402.PaymentRequirementsResponse{
+		X402Version: 1,
+		Accepts: []x402.PaymentRequirement{
+			{Scheme: "exact", Network: "solana-devnet", Asset: x402.USDCMintSolanaDevnet, PayTo: examplePayTo, MaxAmountRequired: "10000"},
+			{Scheme: "exact", Network: x402.SandboxNetwork, Asset: x402.USDCAddressSandbox, PayTo: examplePayTo, MaxAmountRequired: "10000",
+				Extra: map[string]string{"name": "USDC", "version": "2"}},
+		},
+	}
+	payment, err := handler.CreatePayment(context.Background(), requirements)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Signed payment on", payment.Network)
+
+	// The sandbox facilitator verifies it like a real facilitator would
+	var serverPayment x402server.PaymentPayload
+	raw, _ := json.Marshal(payment)
+	_ = json.Unmarshal(raw, &serverPayment)
+	requirement := x402server.RequireUSDCSandbox(examplePayTo, "10000", "Search")
+	verified, err := x402server.NewSandboxFacilitator().Verify(context.Background(), &serverPayment, &requirement)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Valid:", verified.IsValid, "payer matches:", verified.Payer == sandboxSigner.GetAddress())
+	// Output:
+	// Signed payment on sandbox
+	// Valid: true payer matches: true
+}
@@ -16,16 +16,16 @@ func main() {
 	var (
 		privateKeyFlag = flag.String("key", "", "Private key hex (or set WALLET_PRIVATE_KEY env var)")
 		serverURL      = flag.String("server", "http://localhost:8080", "MCP server URL")
-		network        = flag.String("network", "testnet", "Network to use: testnet or mainnet")
+		network        = flag.String("network", "testnet", "Network to use: testnet, mainnet or sandbox")
 		verbose        = flag.Bool("v", false, "Verbose output")
 	)
 	flag.Parse()
 
-	// Get private key from flag or environment
+	// Get private key from flag or environment (the sandbox needs none)
 	privateKey := *privateKeyFlag
 	if privateKey == "" {
 		privateKey = os.Getenv("WALLET_PRIVATE_KEY")
-		if privateKey == "" {
+		if privateKey == "" && *network != "sandbox" {
 			log.Fatal("Private key required: use -key flag or set WALLET_PRIVATE_KEY environment variable")
 		}
 	}
@@ -34,7 +34,10 @@ func main() {
 	var signer x402.PaymentSigner
 	var err error
 
-	if *network == "mainnet" {
+	if *network == "sandbox" {
+		log.Println("Configuring for the sandbox (fake USDC, no keys needed)...")
+		signer, err = x402.NewSandboxSigner()
+	} else if *network == "mainnet" {
 		log.Println("Configuring for mainnet...")
 		signer, err = x402.NewPrivateKeySigner(
 			privateKey,
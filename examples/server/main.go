@@ -18,11 +18,15 @@ func main() {
 		payTo          = flag.String("pay-to", "", "Payment recipient wallet address (required)")
 		verifyOnly     = flag.Bool("verify-only", false, "Only verify payments, don't settle on-chain")
 		testnet        = flag.Bool("testnet", false, "Enable testnet payment options")
+		sandbox        = flag.Bool("sandbox", false, "Charge fake USDC on the sandbox network, settled in memory")
 		verbose        = flag.Bool("v", false, "Verbose output (show requests and payment processing)")
 	)
 	flag.Parse()
 
 	// Check required flags
+	if *payTo == "" && *sandbox {
+		*payTo = "0x000000000000000000000000000000000000dEaD"
+	}
 	if *payTo == "" {
 		log.Fatal("Error: -pay-to flag is required. Please provide a wallet address to receive payments.")
 	}
@@ -33,6 +37,13 @@ func main() {
 		Verbose:        *verbose,
 	}
 
+	// The sandbox settles fake payments in memory, without a facilitator
+	searchPrice := x402server.RequireUSDCBase(*payTo, "10000", "Premium search service - 0.01 USDC")
+	if *sandbox {
+		config.Facilitator = x402server.NewSandboxFacilitator()
+		searchPrice = x402server.RequireUSDCSandbox(*payTo, "10000", "Premium search service - 0.01 fake USDC")
+	}
+
 	// Create x402 server
 	srv := x402server.NewX402Server("x402-search-server", "1.0.0", config)
 
@@ -44,7 +55,7 @@ func main() {
 			mcp.WithNumber("max_results", mcp.Description("Maximum number of results to return")),
 		),
 		searchHandler,
-		x402server.RequireUSDCBase(*payTo, "10000", "Premium search service - 0.01 USDC"),
+		searchPrice,
 	)
 
 	// Add a free echo tool to demonstrate non-paid tools
@@ -71,21 +82,33 @@ func main() {
 	// Start server
 	log.Printf("Starting x402 MCP server on :%s", *port)
 	log.Printf("Server URL: http://localhost:%s", *port)
-	log.Printf("Facilitator URL: %s", *facilitatorURL)
+	if *sandbox {
+		log.Println("Facilitator: in-memory sandbox")
+	} else {
+		log.Printf("Facilitator URL: %s", *facilitatorURL)
+	}
 	log.Printf("Payment recipient: %s", *payTo)
 	log.Printf("Verify Only Mode: %v", *verifyOnly)
 	if *verbose {
 		log.Printf("Verbose Mode: ENABLED")
 	}
 	log.Println("Tools:")
-	log.Println("  - search (paid): 0.01 USDC on Base")
+	if *sandbox {
+		log.Println("  - search (paid): 0.01 fake USDC on the sandbox network")
+	} else {
+		log.Println("  - search (paid): 0.01 USDC on Base")
+	}
 	log.Println("  - echo (free)")
 	if *testnet {
 		log.Println("  - test-feature (testnet): 0.001 USDC on Base Sepolia")
 	}
 	log.Println("")
 	log.Println("Connect with client using:")
-	log.Printf("  ./client -server http://localhost:%s", *port)
+	if *sandbox {
+		log.Printf("  ./client -server http://localhost:%s -network sandbox", *port)
+	} else {
+		log.Printf("  ./client -server http://localhost:%s", *port)
+	}
 	if *verifyOnly {
 		log.Println("  (Running in verify-only mode - payments will be verified but not settled)")
 	}
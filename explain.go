@@ -0,0 +1,228 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// SelectionReason tells why a payment candidate was or wasn't selected
+type SelectionReason string
+
+const (
+	ReasonSelected           SelectionReason = "selected"
+	ReasonLowerPriority      SelectionReason = "lower priority"
+	ReasonUntrustedRecipient SelectionReason = "untrusted recipient"
+	ReasonNotForced          SelectionReason = "not the forced network or asset"
+	ReasonUnsupportedNetwork SelectionReason = "unsupported network or asset"
+	ReasonUnsupportedScheme  SelectionReason = "unsupported scheme"
+	ReasonInvalidAmount      SelectionReason = "invalid amount"
+	ReasonExceedsMaxAmount   SelectionReason = "exceeds max amount"
+	ReasonAmountPolicy       SelectionReason = "rejected by amount policy"
+)
+
+// CandidateExplanation is the outcome of one signer paying one requirement
+type CandidateExplanation struct {
+	SignerIndex   int
+	SignerAddress string
+	Requirement   PaymentRequirement
+	Reason        SelectionReason
+	Detail        string
+}
+
+// SelectionExplanation traces how a PaymentHandler picks a payment for a set of
+// requirements: every signer and requirement pair, with why it was accepted or
+// rejected
+type SelectionExplanation struct {
+	Candidates []CandidateExplanation
+}
+
+// Selected returns the selected candidate, or nil when nothing can be paid
+func (e *SelectionExplanation) Selected() *CandidateExplanation {
+	for i := range e.Candidates {
+		if e.Candidates[i].Reason == ReasonSelected {
+			return &e.Candidates[i]
+		}
+	}
+	return nil
+}
+
+// String formats the trace one candidate per line
+func (e *SelectionExplanation) String() string {
+	var b strings.Builder
+	for _, c := range e.Candidates {
+		fmt.Fprintf(&b, "signer %d (%s): %s %s on %s to %s: %s",
+			c.SignerIndex, c.SignerAddress, c.Requirement.MaxAmountRequired, c.Requirement.Asset,
+			c.Requirement.Network, c.Requirement.PayTo, c.Reason)
+		if c.Detail != "" {
+			fmt.Fprintf(&b, " (%s)", c.Detail)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// redacted returns a copy of the trace with addresses masked at level
+func (e *SelectionExplanation) redacted(level RedactionLevel) *SelectionExplanation {
+	masked := &SelectionExplanation{Candidates: make([]CandidateExplanation, len(e.Candidates))}
+	for i, c := range e.Candidates {
+		c.SignerAddress = level.Redact(c.SignerAddress)
+		c.Requirement.PayTo = level.Redact(c.Requirement.PayTo)
+		if c.Reason == ReasonUntrustedRecipient && level != RedactNone {
+			c.Detail = ""
+		}
+		masked.Candidates[i] = c
+	}
+	return masked
+}
+
+// ExplainSelection explains which signer and requirement CreatePayment would
+// select from accepts, and why every other pair was passed over. Checks made
+// when paying (deadline, budget, PaymentCallback, signing) are not evaluated.
+// Strategies implementing SelectionPeeker are consulted through PeekPayment, so
+// explaining doesn't advance them.
+func (h *PaymentHandler) ExplainSelection(accepts []PaymentRequirement) *SelectionExplanation {
+	if h.config.Strategy == nil {
+		return h.explain(accepts, nil)
+	}
+	selectPayment := h.config.Strategy.SelectPayment
+	if peeker, ok := h.config.Strategy.(SelectionPeeker); ok {
+		selectPayment = peeker.PeekPayment
+	}
+	return h.explain(accepts, func(eligible []PaymentRequirement) (PaymentSigner, *PaymentRequirement) {
+		signer, selected, err := selectPayment(h.signers, eligible)
+		if err != nil {
+			return nil, nil
+		}
+		return signer, selected
+	})
+}
+
+// explainPaid explains the payment CreatePayment signed within ctx (see
+// withSelection) rather than asking the strategy again. Without one, no
+// candidate is selected.
+func (h *PaymentHandler) explainPaid(ctx context.Context, accepts []PaymentRequirement) *SelectionExplanation {
+	paid := selectionFromContext(ctx)
+	return h.explain(accepts, func([]PaymentRequirement) (PaymentSigner, *PaymentRequirement) {
+		if paid == nil {
+			return nil, nil
+		}
+		return paid.signer, &paid.requirement
+	})
+}
+
+// explain traces every signer and requirement pair of accepts. pick returns the
+// selected pair among the eligible requirements; when nil, candidates are
+// ranked the way the handler selects payments without a strategy.
+func (h *PaymentHandler) explain(accepts []PaymentRequirement, pick func(eligible []PaymentRequirement) (PaymentSigner, *PaymentRequirement)) *SelectionExplanation {
+	explanation := &SelectionExplanation{}
+	var candidates []PaymentCandidate
+	var eligible []PaymentRequirement
+
+	for _, req := range accepts {
+		if reason, _ := h.policyExcludes(req); reason == "" {
+			eligible = append(eligible, req)
+		}
+	}
+
+	for idx, signer := range h.signers {
+		for _, req := range accepts {
+			entry := CandidateExplanation{SignerIndex: idx, SignerAddress: signer.GetAddress(), Requirement: req}
+			if reason, detail := h.policyExcludes(req); reason != "" {
+				entry.Reason, entry.Detail = reason, detail
+			} else if candidate, reason, detail := checkCandidate(idx, signer, req); reason != "" {
+				entry.Reason, entry.Detail = reason, detail
+			} else {
+				entry.Reason = ReasonLowerPriority
+				candidates = append(candidates, candidate)
+			}
+			explanation.Candidates = append(explanation.Candidates, entry)
+		}
+	}
+	if len(candidates) == 0 {
+		return explanation
+	}
+
+	var best *PaymentCandidate
+	if pick != nil {
+		signer, selected := pick(eligible)
+		if signer == nil || selected == nil {
+			return explanation
+		}
+		for i := range candidates {
+			if candidates[i].Signer == signer && sameRequirement(candidates[i].Requirement, *selected) {
+				best = &candidates[i]
+				break
+			}
+		}
+	} else {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return defaultLess(candidates[i], candidates[j])
+		})
+		best = &candidates[0]
+	}
+	if best == nil {
+		return explanation
+	}
+
+	for i := range explanation.Candidates {
+		entry := &explanation.Candidates[i]
+		if entry.Reason != ReasonLowerPriority {
+			continue
+		}
+		if entry.SignerIndex == best.SignerIndex && sameRequirement(entry.Requirement, best.Requirement) {
+			entry.Reason = ReasonSelected
+		} else {
+			entry.Detail = fmt.Sprintf("signer %d paying %s %s on %s ranks first",
+				best.SignerIndex, best.Requirement.MaxAmountRequired, best.Requirement.Asset, best.Requirement.Network)
+		}
+	}
+	return explanation
+}
+
+// selectedPayment is the signer and requirement a payment was signed for
+type selectedPayment struct {
+	signer      PaymentSigner
+	requirement PaymentRequirement
+}
+
+type selectionKey struct{}
+
+// withSelection prepares ctx to record the payment signed within it
+func withSelection(ctx context.Context) context.Context {
+	return context.WithValue(ctx, selectionKey{}, new(atomic.Pointer[selectedPayment]))
+}
+
+// recordSelection records the payment signed within ctx, if prepared by withSelection
+func recordSelection(ctx context.Context, signer PaymentSigner, requirement PaymentRequirement) {
+	if slot, ok := ctx.Value(selectionKey{}).(*atomic.Pointer[selectedPayment]); ok {
+		slot.Store(&selectedPayment{signer: signer, requirement: requirement})
+	}
+}
+
+// selectionFromContext returns the payment signed within ctx, if any
+func selectionFromContext(ctx context.Context) *selectedPayment {
+	if slot, ok := ctx.Value(selectionKey{}).(*atomic.Pointer[selectedPayment]); ok {
+		return slot.Load()
+	}
+	return nil
+}
+
+// sameRequirement reports whether a and b ask for the same payment
+func sameRequirement(a, b PaymentRequirement) bool {
+	return a.Scheme == b.Scheme && a.Network == b.Network && a.Asset == b.Asset &&
+		a.PayTo == b.PayTo && a.MaxAmountRequired == b.MaxAmountRequired && a.Resource == b.Resource
+}
+
+// policyExcludes returns why the handler's policy never pays req, if it doesn't
+func (h *PaymentHandler) policyExcludes(req PaymentRequirement) (SelectionReason, string) {
+	if (h.config.ForceNetwork != "" || h.config.ForceAsset != "") && !h.isForced(req) {
+		return ReasonNotForced, "payments are forced to " + h.forcedOption()
+	}
+	if h.config.TrustedRecipients != nil && !isTrustedRecipient(h.config.TrustedRecipients[req.Network], req.PayTo) {
+		return ReasonUntrustedRecipient, fmt.Sprintf("%s is not trusted on %s", req.PayTo, req.Network)
+	}
+	return "", ""
+}
@@ -0,0 +1,151 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync/atomic"
+)
+
+// FeeEstimator estimates the network fee a payer bears to pay a requirement, for
+// schemes where the payer pays gas (native payments, self-broadcast transactions).
+// Fees are in the requirement's asset base units, converted by the estimator, and
+// zero when a facilitator or paymaster covers gas.
+type FeeEstimator interface {
+	EstimateFee(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*big.Int, error)
+}
+
+// FeeEstimatorFunc adapts a function to the FeeEstimator interface
+type FeeEstimatorFunc func(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*big.Int, error)
+
+// EstimateFee calls f
+func (f FeeEstimatorFunc) EstimateFee(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*big.Int, error) {
+	return f(ctx, signer, req)
+}
+
+// FeeEstimate is the expected total cost of a payment, including network fees
+type FeeEstimate struct {
+	Amount     *big.Int // Token amount paid to the recipient
+	NetworkFee *big.Int // Estimated network fee borne by the payer
+	Total      *big.Int // Amount plus NetworkFee
+}
+
+// estimateCost returns the expected cost of signer paying req
+func (h *PaymentHandler) estimateCost(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*FeeEstimate, error) {
+	amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid payment amount: %s", req.MaxAmountRequired)
+	}
+	if amount.Sign() <= 0 {
+		return nil, fmt.Errorf("payment amount must be positive: %s", req.MaxAmountRequired)
+	}
+
+	fee := new(big.Int)
+	if h.config.FeeEstimator != nil {
+		estimated, err := h.config.FeeEstimator.EstimateFee(ctx, signer, req)
+		if err != nil {
+			return nil, fmt.Errorf("estimating network fee: %w", err)
+		}
+		if estimated != nil {
+			fee.Set(estimated)
+		}
+	}
+
+	return &FeeEstimate{Amount: amount, NetworkFee: fee, Total: new(big.Int).Add(amount, fee)}, nil
+}
+
+// approve applies the payment policy to signer paying req, checking the option's
+// MaxAmount, the request's WithMaxPayment cap, the budget and the PaymentCallback
+// against the total cost including fees. The cost is reserved in the budget until
+// the payment is signed (see holdSpend) or fails to be, when the reservation must
+// be released.
+func (h *PaymentHandler) approve(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*BudgetReservation, error) {
+	cost, err := h.estimateCost(ctx, signer, req)
+	if err != nil {
+		return nil, err
+	}
+	setFeeEstimate(ctx, cost)
+
+	if option := signer.GetPaymentOption(req.Network, req.Asset); option != nil && option.MaxAmount != "" {
+		if maxAmount, ok := new(big.Int).SetString(option.MaxAmount, 10); ok && cost.Total.Cmp(maxAmount) > 0 {
+			return nil, fmt.Errorf("%w: total cost %s (fee %s) exceeds max amount %s", ErrPaymentDeclined, cost.Total, cost.NetworkFee, maxAmount)
+		}
+	}
+	if maxPayment, ok := MaxPaymentFromContext(ctx); ok && cost.Total.Cmp(maxPayment) > 0 {
+		return nil, fmt.Errorf("%w: total cost %s (fee %s) exceeds the request's max payment %s", ErrPaymentDeclined, cost.Total, cost.NetworkFee, maxPayment)
+	}
+	var reservation *BudgetReservation
+	if h.config.Budget != nil {
+		if reservation, err = h.config.Budget.Reserve(ctx, req.Network, req.Asset, cost.Total); err != nil {
+			return nil, err
+		}
+	}
+	if h.config.PaymentCallback != nil && !h.config.PaymentCallback(new(big.Int).Set(cost.Total), req.Resource) {
+		reservation.Release()
+		return nil, ErrPaymentDeclined
+	}
+	return reservation, nil
+}
+
+// holdSpend keeps the budget reserved for a signed payment until the transport
+// learns whether the server took it (see withBudgetReservation). Payments
+// signed outside a transport request are charged right away.
+func holdSpend(ctx context.Context, reservation *BudgetReservation) {
+	if slot, ok := ctx.Value(budgetReservationKey{}).(*atomic.Pointer[BudgetReservation]); ok {
+		slot.Swap(reservation).Commit()
+		return
+	}
+	reservation.Commit()
+}
+
+type budgetReservationKey struct{}
+
+// withBudgetReservation prepares ctx to hold the budget reservation of the
+// payment signed within it
+func withBudgetReservation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, budgetReservationKey{}, new(atomic.Pointer[BudgetReservation]))
+}
+
+// settleBudgetReservation commits the budget reserved for the payment signed
+// within ctx, or releases it when paid is false
+func settleBudgetReservation(ctx context.Context, paid bool) {
+	slot, ok := ctx.Value(budgetReservationKey{}).(*atomic.Pointer[BudgetReservation])
+	if !ok {
+		return
+	}
+	reservation := slot.Swap(nil)
+	if paid {
+		reservation.Commit()
+	} else {
+		reservation.Release()
+	}
+}
+
+type feeEstimateKey struct{}
+
+// withFeeEstimate prepares ctx to carry the estimated cost of the payment made within it
+func withFeeEstimate(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(feeEstimateKey{}).(*atomic.Pointer[FeeEstimate]); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, feeEstimateKey{}, new(atomic.Pointer[FeeEstimate]))
+}
+
+// setFeeEstimate records the estimated cost of the payment being made within ctx
+func setFeeEstimate(ctx context.Context, cost *FeeEstimate) {
+	if slot, ok := ctx.Value(feeEstimateKey{}).(*atomic.Pointer[FeeEstimate]); ok {
+		slot.Store(cost)
+	}
+}
+
+// addFeeEstimate fills an event's fee fields from the estimate recorded in ctx
+func addFeeEstimate(ctx context.Context, event *PaymentEvent) {
+	slot, ok := ctx.Value(feeEstimateKey{}).(*atomic.Pointer[FeeEstimate])
+	if !ok {
+		return
+	}
+	if cost := slot.Load(); cost != nil {
+		event.NetworkFee = new(big.Int).Set(cost.NetworkFee)
+		event.TotalCost = new(big.Int).Set(cost.Total)
+	}
+}
@@ -0,0 +1,348 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Defaults of FinalityConfig
+const (
+	defaultFinalityPollInterval = 5 * time.Second
+	defaultFinalityTimeout      = 10 * time.Minute
+)
+
+// Confirmation is how far a settlement transaction is in its chain
+type Confirmation struct {
+	Included      bool   // The transaction is on chain
+	Failed        bool   // The transaction was included but failed
+	Confirmations uint64 // Blocks (EVM) or Solana confirmations since inclusion
+	Finalized     bool   // The chain finalized the transaction
+}
+
+// SettlementVerifier looks up settlement transactions on chain
+type SettlementVerifier interface {
+	Confirmation(ctx context.Context, network, transaction string) (Confirmation, error)
+}
+
+// FinalityRequirement is when a settlement on a network counts as final: after
+// Confirmations, or once the chain finalized it when Finalized is set
+type FinalityRequirement struct {
+	Confirmations uint64
+	Finalized     bool
+}
+
+// met reports whether a confirmation satisfies the requirement
+func (r FinalityRequirement) met(c Confirmation) bool {
+	if !c.Included || c.Failed {
+		return false
+	}
+	return c.Finalized || (!r.Finalized && c.Confirmations >= r.Confirmations)
+}
+
+// FinalityConfig follows payment settlements on chain until they are final,
+// so high-value payments can be told apart once settled and once final
+type FinalityConfig struct {
+	// Verifier looks settlement transactions up on chain
+	Verifier SettlementVerifier
+
+	// Requirements are the finality requirements per network. Settlements on
+	// other networks aren't followed.
+	Requirements map[string]FinalityRequirement
+
+	// PollInterval is how often settlements are looked up (default 5s), and
+	// Timeout how long a settlement may take to be final (default 10m)
+	PollInterval time.Duration
+	Timeout      time.Duration
+
+	// OnFinalized is called with a PaymentEventFinalized once a settlement meets
+	// its requirement. OnFailure is called with a PaymentEventFinalityFailure and
+	// ErrSettlementFailed, ErrSettlementReorged or ErrFinalityTimeout otherwise.
+	OnFinalized func(PaymentEvent)
+	OnFailure   func(PaymentEvent, error)
+}
+
+// watchFinality follows a successful settlement on chain in the background
+// until it meets its network's finality requirement, fails or the transport is
+// closed
+func (t *X402Transport) watchFinality(ctx context.Context, method string, reqs PaymentRequirementsResponse, settlement SettlementResponse) {
+	config := t.finality
+	if config == nil || config.Verifier == nil || settlement.Transaction == "" {
+		return
+	}
+	network := settlement.Network
+	if network == "" && len(reqs.Accepts) > 0 {
+		network = reqs.Accepts[0].Network
+	}
+	requirement, ok := config.Requirements[network]
+	if !ok {
+		return
+	}
+
+	event := t.settlementEvent(ctx, method, reqs, settlement)
+	event.Network = network
+	pollInterval := config.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultFinalityPollInterval
+	}
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultFinalityTimeout
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		var seen bool
+		for {
+			confirmation, err := config.Verifier.Confirmation(ctx, network, settlement.Transaction)
+			switch {
+			case err != nil:
+				t.logger.Printf("[X402] Failed to look up settlement %s on %s: %v",
+					t.logRedaction.Redact(settlement.Transaction), network, err)
+			case confirmation.Failed:
+				t.finalityFailed(event, fmt.Errorf("%w: %s", ErrSettlementFailed, settlement.Transaction))
+				return
+			case requirement.met(confirmation):
+				t.finalized(event)
+				return
+			case confirmation.Included:
+				seen = true
+			case seen:
+				t.finalityFailed(event, fmt.Errorf("%w: %s", ErrSettlementReorged, settlement.Transaction))
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-t.closed:
+				return
+			case <-ctx.Done():
+				t.finalityFailed(event, fmt.Errorf("%w: %s after %s", ErrFinalityTimeout, settlement.Transaction, timeout))
+				return
+			}
+		}
+	}()
+}
+
+// settlementEvent describes the payment a settlement settled
+func (t *X402Transport) settlementEvent(ctx context.Context, method string, reqs PaymentRequirementsResponse, settlement SettlementResponse) PaymentEvent {
+	event := PaymentEvent{
+		Method:      method,
+		Network:     settlement.Network,
+		Transaction: settlement.Transaction,
+		Attribution: AttributionFromContext(ctx),
+		OnBehalfOf:  OnBehalfOfFromContext(ctx),
+	}
+	for _, req := range reqs.Accepts {
+		if settlement.Network != "" && req.Network != settlement.Network {
+			continue
+		}
+		event.Resource = req.Resource
+		event.Asset = req.Asset
+		event.Recipient = req.PayTo
+		if amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10); ok {
+			event.Amount = amount
+		}
+		break
+	}
+	return event
+}
+
+func (t *X402Transport) finalized(event PaymentEvent) {
+	event.Type = PaymentEventFinalized
+	event.Timestamp = t.clock.Now().Unix()
+	if t.verbose {
+		t.logger.Printf("[X402] Settlement %s on %s is final", t.logRedaction.Redact(event.Transaction), event.Network)
+	}
+	if t.finality.OnFinalized != nil {
+		t.finality.OnFinalized(event)
+	}
+	if t.paymentRecorder != nil {
+		t.paymentRecorder.Record(event)
+	}
+}
+
+func (t *X402Transport) finalityFailed(event PaymentEvent, err error) {
+	event.Type = PaymentEventFinalityFailure
+	event.Timestamp = t.clock.Now().Unix()
+	event.Error = err
+	t.logger.Printf("[X402] Settlement %s on %s did not become final: %v",
+		t.logRedaction.Redact(event.Transaction), event.Network, err)
+	if t.finality.OnFailure != nil {
+		t.finality.OnFailure(event, err)
+	}
+	if t.paymentRecorder != nil {
+		t.paymentRecorder.Record(event)
+	}
+}
+
+// EVMSettlementVerifier looks settlement transactions up with the JSON-RPC API
+// of EVM nodes. Transactions are final once the node's "finalized" block
+// includes them.
+type EVMSettlementVerifier struct {
+	rpcURLs map[string]string
+	client  *http.Client
+}
+
+// NewEVMSettlementVerifier creates a verifier querying the node RPC URL of each network
+func NewEVMSettlementVerifier(rpcURLs map[string]string) *EVMSettlementVerifier {
+	return &EVMSettlementVerifier{rpcURLs: rpcURLs, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Confirmation implements SettlementVerifier
+func (v *EVMSettlementVerifier) Confirmation(ctx context.Context, network, transaction string) (Confirmation, error) {
+	rpcURL, ok := v.rpcURLs[network]
+	if !ok {
+		return Confirmation{}, fmt.Errorf("%w: no RPC URL for %s", ErrUnsupportedNetwork, network)
+	}
+
+	var receipt *struct {
+		BlockNumber string `json:"blockNumber"`
+		Status      string `json:"status"`
+	}
+	if err := v.call(ctx, rpcURL, "eth_getTransactionReceipt", []any{transaction}, &receipt); err != nil {
+		return Confirmation{}, err
+	}
+	if receipt == nil || receipt.BlockNumber == "" {
+		return Confirmation{}, nil
+	}
+	block, err := parseHexUint(receipt.BlockNumber)
+	if err != nil {
+		return Confirmation{}, fmt.Errorf("invalid receipt block number: %w", err)
+	}
+	confirmation := Confirmation{Included: true, Failed: receipt.Status == "0x0"}
+
+	var latest string
+	if err := v.call(ctx, rpcURL, "eth_blockNumber", []any{}, &latest); err != nil {
+		return Confirmation{}, err
+	}
+	head, err := parseHexUint(latest)
+	if err != nil {
+		return Confirmation{}, fmt.Errorf("invalid block number: %w", err)
+	}
+	if head >= block {
+		confirmation.Confirmations = head - block + 1
+	}
+
+	// Nodes without the "finalized" tag only report confirmations
+	var finalized *struct {
+		Number string `json:"number"`
+	}
+	if err := v.call(ctx, rpcURL, "eth_getBlockByNumber", []any{"finalized", false}, &finalized); err == nil && finalized != nil {
+		if number, err := parseHexUint(finalized.Number); err == nil && number >= block {
+			confirmation.Finalized = true
+		}
+	}
+	return confirmation, nil
+}
+
+func (v *EVMSettlementVerifier) call(ctx context.Context, rpcURL, method string, params []any, result any) error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rpcURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s", method, response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+func parseHexUint(s string) (uint64, error) {
+	return strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+}
+
+// SolanaSettlementVerifier looks settlement transactions up with Solana RPC
+// signature statuses. Transactions are final at the finalized commitment.
+type SolanaSettlementVerifier struct {
+	clients map[string]*rpc.Client
+}
+
+// NewSolanaSettlementVerifier creates a verifier querying the RPC URL of each
+// network, defaulting to the public endpoints of "solana" and "solana-devnet"
+func NewSolanaSettlementVerifier(rpcURLs map[string]string) *SolanaSettlementVerifier {
+	urls := map[string]string{"solana": rpc.MainNetBeta_RPC, "solana-devnet": rpc.DevNet_RPC}
+	for network, url := range rpcURLs {
+		urls[network] = url
+	}
+	clients := make(map[string]*rpc.Client, len(urls))
+	for network, url := range urls {
+		clients[network] = rpc.New(url)
+	}
+	return &SolanaSettlementVerifier{clients: clients}
+}
+
+// Confirmation implements SettlementVerifier
+func (v *SolanaSettlementVerifier) Confirmation(ctx context.Context, network, transaction string) (Confirmation, error) {
+	client, ok := v.clients[network]
+	if !ok {
+		return Confirmation{}, fmt.Errorf("%w: no RPC URL for %s", ErrUnsupportedNetwork, network)
+	}
+	signature, err := solana.SignatureFromBase58(transaction)
+	if err != nil {
+		return Confirmation{}, fmt.Errorf("invalid transaction signature: %w", err)
+	}
+	statuses, err := client.GetSignatureStatuses(ctx, true, signature)
+	if err != nil {
+		return Confirmation{}, fmt.Errorf("getSignatureStatuses: %w", err)
+	}
+	if len(statuses.Value) == 0 || statuses.Value[0] == nil {
+		return Confirmation{}, nil
+	}
+	status := statuses.Value[0]
+	confirmation := Confirmation{
+		Included:  true,
+		Failed:    status.Err != nil,
+		Finalized: status.ConfirmationStatus == rpc.ConfirmationStatusFinalized,
+	}
+	if status.Confirmations != nil {
+		confirmation.Confirmations = *status.Confirmations
+	}
+	return confirmation, nil
+}
+
+// MultiSettlementVerifier dispatches each network to one of several verifiers
+type MultiSettlementVerifier map[string]SettlementVerifier
+
+// Confirmation implements SettlementVerifier
+func (m MultiSettlementVerifier) Confirmation(ctx context.Context, network, transaction string) (Confirmation, error) {
+	verifier, ok := m[network]
+	if !ok {
+		return Confirmation{}, fmt.Errorf("%w: no settlement verifier for %s", ErrUnsupportedNetwork, network)
+	}
+	return verifier.Confirmation(ctx, network, transaction)
+}
@@ -0,0 +1,84 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// closeFlushTimeout bounds how long Close waits for payments in progress
+const closeFlushTimeout = 5 * time.Second
+
+// Flusher is implemented by ledgers and stores that buffer writes. X402Transport
+// flushes its DeclineLedger and TokenStore when they implement it.
+type Flusher interface {
+	Flush() error
+}
+
+// paymentTracker counts the payments a transport has in progress
+type paymentTracker struct {
+	mu     sync.Mutex
+	active int
+	idle   chan struct{} // Closed while no payment is in progress
+}
+
+func newPaymentTracker() *paymentTracker {
+	idle := make(chan struct{})
+	close(idle)
+	return &paymentTracker{idle: idle}
+}
+
+func (p *paymentTracker) begin() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.active == 0 {
+		p.idle = make(chan struct{})
+	}
+	p.active++
+}
+
+func (p *paymentTracker) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+	if p.active == 0 {
+		close(p.idle)
+	}
+}
+
+// wait blocks until the payments in progress have finished
+func (p *paymentTracker) wait(ctx context.Context) error {
+	p.mu.Lock()
+	idle := p.idle
+	p.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Flush waits for the payments in progress to finish, delivering their payment
+// events, callbacks and decline records, then flushes the DeclineLedger and
+// TokenStore if they implement Flusher. Short-lived programs should call Flush
+// (or Close, which flushes for up to 5s) before exiting, so the record of their
+// last payment isn't lost.
+func (t *X402Transport) Flush(ctx context.Context) error {
+	if err := t.payments.wait(ctx); err != nil {
+		return fmt.Errorf("waiting for payments in progress: %w", err)
+	}
+
+	var errs []error
+	for _, sink := range []any{t.declineLedger, t.tokenStore} {
+		if flusher, ok := sink.(Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,162 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxPaymentsPerRequest allows an access fee plus the request's own price
+	DefaultMaxPaymentsPerRequest = 2
+
+	// Defaults for rejected-payment backoff and server quarantine
+	defaultFailureBackoff     = time.Second
+	defaultMaxFailureBackoff  = 5 * time.Minute
+	defaultQuarantineAfter    = 5
+	defaultQuarantineDuration = time.Hour
+)
+
+// paymentGuard limits how much a misbehaving server can make the transport pay.
+// A payment the server rejects with another 402 backs off its resource
+// exponentially, and repeated rejections quarantine the server entirely.
+type paymentGuard struct {
+	maxPerRequest      int
+	backoff            time.Duration
+	maxBackoff         time.Duration
+	quarantineAfter    int
+	quarantineDuration time.Duration
+
+	mu               sync.Mutex
+	resources        map[string]resourceBackoff
+	rejections       int // Consecutive rejected payments across resources
+	quarantinedUntil time.Time
+	now              func() time.Time
+}
+
+// resourceBackoff tracks consecutive rejected payments for one resource
+type resourceBackoff struct {
+	failures int
+	until    time.Time
+}
+
+func newPaymentGuard(config Config) *paymentGuard {
+	g := &paymentGuard{
+		maxPerRequest:      config.MaxPaymentsPerRequest,
+		backoff:            config.FailureBackoff,
+		maxBackoff:         config.MaxFailureBackoff,
+		quarantineAfter:    config.QuarantineAfter,
+		quarantineDuration: config.QuarantineDuration,
+		resources:          make(map[string]resourceBackoff),
+		now:                clockOrSystem(config.Clock).Now,
+	}
+	if g.maxPerRequest <= 0 {
+		g.maxPerRequest = DefaultMaxPaymentsPerRequest
+	}
+	if g.backoff <= 0 {
+		g.backoff = defaultFailureBackoff
+	}
+	if g.maxBackoff <= 0 {
+		g.maxBackoff = defaultMaxFailureBackoff
+	}
+	if g.quarantineAfter == 0 {
+		g.quarantineAfter = defaultQuarantineAfter
+	}
+	if g.quarantineDuration <= 0 {
+		g.quarantineDuration = defaultQuarantineDuration
+	}
+	return g
+}
+
+type paymentCountKey struct{}
+
+// withPaymentCount returns a context counting the payments made for one request
+func withPaymentCount(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(paymentCountKey{}).(*atomic.Int32); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, paymentCountKey{}, new(atomic.Int32))
+}
+
+// admit checks whether another payment for resource may be made within ctx's request
+func (g *paymentGuard) admit(ctx context.Context, resource string) error {
+	if count, ok := ctx.Value(paymentCountKey{}).(*atomic.Int32); ok {
+		if int(count.Add(1)) > g.maxPerRequest {
+			return fmt.Errorf("%w: more than %d payments for one request", ErrPaymentLimitExceeded, g.maxPerRequest)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	if now.Before(g.quarantinedUntil) {
+		return fmt.Errorf("%w until %s", ErrServerQuarantined, g.quarantinedUntil.Format(time.RFC3339))
+	}
+	if b, ok := g.resources[resource]; ok && now.Before(b.until) {
+		return fmt.Errorf("%w: %s rejected %d payments, retry after %s",
+			ErrPaymentBackoff, resource, b.failures, b.until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// rejected records a payment the server answered with another 402
+func (g *paymentGuard) rejected(resource string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	b := g.resources[resource]
+	b.failures++
+	delay := g.backoff << (b.failures - 1)
+	if delay <= 0 || delay > g.maxBackoff {
+		delay = g.maxBackoff
+	}
+	b.until = now.Add(delay)
+	g.resources[resource] = b
+
+	g.rejections++
+	if g.quarantineAfter > 0 && g.rejections >= g.quarantineAfter {
+		g.quarantinedUntil = now.Add(g.quarantineDuration)
+		g.rejections = 0
+	}
+}
+
+// accepted clears the backoff of a resource after a successful payment
+func (g *paymentGuard) accepted(resource string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.resources, resource)
+	g.rejections = 0
+}
+
+// Quarantined reports whether the server is quarantined for rejecting payments,
+// and until when
+func (t *X402Transport) Quarantined() (bool, time.Time) {
+	t.guard.mu.Lock()
+	defer t.guard.mu.Unlock()
+
+	until := t.guard.quarantinedUntil
+	return t.guard.now().Before(until), until
+}
+
+// ResetQuarantine lifts the server quarantine and all resource backoffs
+func (t *X402Transport) ResetQuarantine() {
+	t.guard.mu.Lock()
+	defer t.guard.mu.Unlock()
+
+	t.guard.quarantinedUntil = time.Time{}
+	t.guard.rejections = 0
+	t.guard.resources = make(map[string]resourceBackoff)
+}
+
+// paidResource names the resource a set of requirements charges for
+func paidResource(reqs PaymentRequirementsResponse) string {
+	if len(reqs.Accepts) == 0 {
+		return ""
+	}
+	return reqs.Accepts[0].Resource
+}
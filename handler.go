@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -12,12 +14,55 @@ import (
 type PaymentHandler struct {
 	signers []PaymentSigner
 	config  *HandlerConfig
+	bridger *bridger
 }
 
 // HandlerConfig configures the payment handler
 type HandlerConfig struct {
+	// PaymentCallback approves payments; amount includes the estimated network fee
+	// when a FeeEstimator is set
 	PaymentCallback func(amount *big.Int, resource string) bool
 	OnSignerAttempt func(PaymentEvent)
+
+	// Strategy chooses which signer pays which requirement.
+	// When nil, signers are tried in priority order.
+	Strategy SelectionStrategy
+
+	// TrustedRecipients, if set, restricts payments to these payTo addresses per network.
+	// Requirements for other recipients or networks are never signed.
+	TrustedRecipients map[string][]string
+
+	// ForceNetwork and ForceAsset, if set, restrict payments to this network
+	// and asset. Requirements on any other are never signed.
+	ForceNetwork string
+	ForceAsset   string
+
+	// Bridging, if set, bridges funds from other networks when the payer's wallet
+	// lacks the required amount on the selected network
+	Bridging *BridgingConfig
+
+	// CheckDeadline if true, only pays requirements whose MaxTimeoutSeconds fits
+	// within the time left before the context's deadline
+	CheckDeadline bool
+
+	// FeeEstimator, if set, estimates the network fee the payer bears, so that
+	// MaxAmount and PaymentCallback checks apply to the total cost
+	FeeEstimator FeeEstimator
+
+	// Budget, if set, caps spending per network and asset (and optionally in a
+	// reference currency) over a rolling window
+	Budget *BudgetManager
+
+	// SignerHealth, if set, skips signers failing their health checks while
+	// others are healthy
+	SignerHealth *SignerHealth
+
+	// Logger receives the handler's log output (the standard logger when nil)
+	Logger Logger
+
+	// Clock, if set, is the clock CheckDeadline compares deadlines with and
+	// signer event timestamps go by (the system clock when nil)
+	Clock Clock
 }
 
 // NewPaymentHandler creates a new payment handler (backward compatibility)
@@ -33,6 +78,7 @@ func NewPaymentHandler(signer PaymentSigner, config *HandlerConfig) (*PaymentHan
 	return &PaymentHandler{
 		signers: []PaymentSigner{signer},
 		config:  config,
+		bridger: newBridger(config.Bridging),
 	}, nil
 }
 
@@ -49,6 +95,7 @@ func NewPaymentHandlerMulti(signers []PaymentSigner, config *HandlerConfig) (*Pa
 	return &PaymentHandler{
 		signers: signers,
 		config:  config,
+		bridger: newBridger(config.Bridging),
 	}, nil
 }
 
@@ -75,6 +122,42 @@ func (h *PaymentHandler) ShouldPay(req PaymentRequirement) (bool, error) {
 
 // CreatePayment creates a signed payment for the given requirements
 func (h *PaymentHandler) CreatePayment(ctx context.Context, reqs PaymentRequirementsResponse) (*PaymentPayload, error) {
+	if h.config.ForceNetwork != "" || h.config.ForceAsset != "" {
+		accepts, err := h.forcedAccepts(reqs.Accepts)
+		if err != nil {
+			return nil, err
+		}
+		reqs.Accepts = accepts
+	}
+
+	if h.config.TrustedRecipients != nil {
+		accepts, err := h.trustedAccepts(reqs.Accepts)
+		if err != nil {
+			return nil, err
+		}
+		reqs.Accepts = accepts
+	}
+
+	if h.config.Budget != nil {
+		accepts, err := h.config.Budget.methodAccepts(ctx, reqs.Accepts)
+		if err != nil {
+			return nil, err
+		}
+		reqs.Accepts = accepts
+	}
+
+	if h.config.CheckDeadline {
+		accepts, err := deadlineAccepts(ctx, reqs.Accepts, clockOrSystem(h.config.Clock).Now())
+		if err != nil {
+			return nil, err
+		}
+		reqs.Accepts = accepts
+	}
+
+	if h.config.Strategy != nil {
+		return h.selectPaymentWithStrategy(ctx, reqs.Accepts)
+	}
+
 	// For backward compatibility, check if we have single or multiple signers
 	if len(h.signers) == 1 {
 		// Single signer - use existing logic for backward compatibility
@@ -83,19 +166,22 @@ func (h *PaymentHandler) CreatePayment(ctx context.Context, reqs PaymentRequirem
 			return nil, err
 		}
 
-		shouldPay, err := h.ShouldPay(*selected)
+		reservation, err := h.approve(ctx, h.signers[0], *selected)
 		if err != nil {
 			return nil, err
 		}
 
-		if !shouldPay {
-			return nil, fmt.Errorf("payment declined by policy")
+		if err := h.bridger.ensureFunds(ctx, h.signers[0], *selected); err != nil {
+			reservation.Release()
+			return nil, err
 		}
 
-		payload, err := h.signers[0].SignPayment(ctx, *selected)
+		payload, err := h.sign(ctx, h.signers[0], *selected)
 		if err != nil {
+			reservation.Release()
 			return nil, fmt.Errorf("signing payment: %w", err)
 		}
+		holdSpend(ctx, reservation)
 
 		return payload, nil
 	}
@@ -104,69 +190,113 @@ func (h *PaymentHandler) CreatePayment(ctx context.Context, reqs PaymentRequirem
 	return h.selectPaymentWithFallback(ctx, reqs.Accepts)
 }
 
-// selectPaymentMethod selects the best payment method from available options (legacy)
-func (h *PaymentHandler) selectPaymentMethod(accepts []PaymentRequirement) (*PaymentRequirement, error) {
-	if len(h.signers) == 0 {
-		return nil, ErrNoAcceptablePayment
+// trustedAccepts filters requirements down to those paying a trusted recipient
+func (h *PaymentHandler) trustedAccepts(accepts []PaymentRequirement) ([]PaymentRequirement, error) {
+	var trusted []PaymentRequirement
+	for _, req := range accepts {
+		if isTrustedRecipient(h.config.TrustedRecipients[req.Network], req.PayTo) {
+			trusted = append(trusted, req)
+		}
 	}
-	return h.selectPaymentMethodForSigner(h.signers[0], accepts)
+
+	if len(trusted) == 0 && len(accepts) > 0 {
+		return nil, fmt.Errorf("%w: %s on %s", ErrUntrustedRecipient, accepts[0].PayTo, accepts[0].Network)
+	}
+	return trusted, nil
 }
 
-// selectPaymentMethodForSigner selects payment method for a specific signer
-func (h *PaymentHandler) selectPaymentMethodForSigner(signer PaymentSigner, accepts []PaymentRequirement) (*PaymentRequirement, error) {
-	if len(accepts) == 0 {
-		return nil, ErrNoAcceptablePayment
+// forcedAccepts filters requirements down to those on ForceNetwork and ForceAsset
+func (h *PaymentHandler) forcedAccepts(accepts []PaymentRequirement) ([]PaymentRequirement, error) {
+	var forced []PaymentRequirement
+	for _, req := range accepts {
+		if h.isForced(req) {
+			forced = append(forced, req)
+		}
 	}
 
-	type candidate struct {
-		req      PaymentRequirement
-		priority int
-		amount   *big.Int
+	if len(forced) == 0 && len(accepts) > 0 {
+		offered := make([]string, len(accepts))
+		for i, req := range accepts {
+			offered[i] = req.Asset + " on " + req.Network
+		}
+		return nil, fmt.Errorf("%w: %s, server accepts %s", ErrForcedPaymentOption, h.forcedOption(), strings.Join(offered, ", "))
 	}
+	return forced, nil
+}
 
-	var candidates []candidate
+// isForced reports whether req is on the forced network and asset, if any
+func (h *PaymentHandler) isForced(req PaymentRequirement) bool {
+	if h.config.ForceNetwork != "" && req.Network != h.config.ForceNetwork {
+		return false
+	}
+	if h.config.ForceAsset != "" && newAssetKey("", req.Asset) != newAssetKey("", h.config.ForceAsset) {
+		return false
+	}
+	return true
+}
 
-	for _, req := range accepts {
-		// Check if we support this network and asset
-		option := signer.GetPaymentOption(req.Network, req.Asset)
-		if option == nil {
-			continue
-		}
+// forcedOption describes the forced network and asset
+func (h *PaymentHandler) forcedOption() string {
+	switch {
+	case h.config.ForceAsset == "":
+		return "any asset on " + h.config.ForceNetwork
+	case h.config.ForceNetwork == "":
+		return h.config.ForceAsset + " on any network"
+	default:
+		return h.config.ForceAsset + " on " + h.config.ForceNetwork
+	}
+}
 
-		// Check scheme matches
-		if option.Scheme != req.Scheme {
-			continue
-		}
+// deadlineAccepts filters requirements down to those the server promises to
+// serve (MaxTimeoutSeconds) before the context's deadline, so the caller doesn't
+// pay for a response it won't wait for
+func deadlineAccepts(ctx context.Context, accepts []PaymentRequirement, now time.Time) ([]PaymentRequirement, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return accepts, nil
+	}
+	remaining := deadline.Sub(now)
 
-		amount := new(big.Int)
-		if _, ok := amount.SetString(req.MaxAmountRequired, 10); !ok {
-			// Skip invalid amounts
-			continue
+	var fitting []PaymentRequirement
+	for _, req := range accepts {
+		if time.Duration(req.MaxTimeoutSeconds)*time.Second <= remaining {
+			fitting = append(fitting, req)
 		}
+	}
 
-		// Skip zero or negative amounts
-		if amount.Sign() <= 0 {
-			continue
-		}
+	if len(fitting) == 0 && len(accepts) > 0 {
+		return nil, fmt.Errorf("%w: server may take %ds, %s left", ErrDeadlineTooShort,
+			accepts[0].MaxTimeoutSeconds, remaining.Round(time.Second))
+	}
+	return fitting, nil
+}
 
-		// Check if within client's max amount for this option
-		if option.MaxAmount != "" {
-			maxAmount := new(big.Int)
-			if _, ok := maxAmount.SetString(option.MaxAmount, 10); ok {
-				if amount.Cmp(maxAmount) > 0 {
-					// Required amount exceeds client's max for this option
-					continue
-				}
-			}
+// isTrustedRecipient reports whether payTo is in the allowlist.
+// EVM addresses are compared case-insensitively.
+func isTrustedRecipient(allowed []string, payTo string) bool {
+	for _, addr := range allowed {
+		if addr == payTo || (strings.HasPrefix(addr, "0x") && strings.EqualFold(addr, payTo)) {
+			return true
 		}
+	}
+	return false
+}
 
-		candidates = append(candidates, candidate{
-			req:      req,
-			priority: option.Priority,
-			amount:   amount,
-		})
+// selectPaymentMethod selects the best payment method from available options (legacy)
+func (h *PaymentHandler) selectPaymentMethod(accepts []PaymentRequirement) (*PaymentRequirement, error) {
+	if len(h.signers) == 0 {
+		return nil, ErrNoAcceptablePayment
 	}
+	return h.selectPaymentMethodForSigner(h.signers[0], accepts)
+}
 
+// selectPaymentMethodForSigner selects payment method for a specific signer
+func (h *PaymentHandler) selectPaymentMethodForSigner(signer PaymentSigner, accepts []PaymentRequirement) (*PaymentRequirement, error) {
+	if len(accepts) == 0 {
+		return nil, ErrNoAcceptablePayment
+	}
+
+	candidates := candidatesForSigner(0, signer, accepts)
 	if len(candidates) == 0 {
 		return nil, fmt.Errorf("no payment option for network=%s asset=%s",
 			accepts[0].Network, accepts[0].Asset)
@@ -174,13 +304,13 @@ func (h *PaymentHandler) selectPaymentMethodForSigner(signer PaymentSigner, acce
 
 	// Sort by priority first, then by amount
 	sort.Slice(candidates, func(i, j int) bool {
-		if candidates[i].priority != candidates[j].priority {
-			return candidates[i].priority < candidates[j].priority
+		if candidates[i].Option.Priority != candidates[j].Option.Priority {
+			return candidates[i].Option.Priority < candidates[j].Option.Priority
 		}
-		return candidates[i].amount.Cmp(candidates[j].amount) < 0
+		return candidates[i].Amount.Cmp(candidates[j].Amount) < 0
 	})
 
-	return &candidates[0].req, nil
+	return &candidates[0].Requirement, nil
 }
 
 // selectPaymentWithFallback tries each signer in priority order until one succeeds
@@ -192,7 +322,11 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 	var failures []SignerFailure
 	attemptNumber := 0
 
+	active := h.config.SignerHealth.active(h.signers)
 	for idx, signer := range h.signers {
+		if len(active) < len(h.signers) && h.config.SignerHealth.Failure(signer) != nil {
+			continue // Disabled by its health checks
+		}
 		attemptNumber++
 
 		// Emit signer attempt event
@@ -203,7 +337,8 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 				SignerPriority: signer.GetPriority(),
 				SignerAddress:  signer.GetAddress(),
 				AttemptNumber:  attemptNumber,
-				Timestamp:      time.Now().Unix(),
+				Timestamp:      clockOrSystem(h.config.Clock).Now().Unix(),
+				Attribution:    AttributionFromContext(ctx),
 			}
 			h.config.OnSignerAttempt(event)
 		}
@@ -229,19 +364,29 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 					SignerAddress:  signer.GetAddress(),
 					AttemptNumber:  attemptNumber,
 					Error:          err,
-					Timestamp:      time.Now().Unix(),
+					Timestamp:      clockOrSystem(h.config.Clock).Now().Unix(),
+					Attribution:    AttributionFromContext(ctx),
 				}
 				h.config.OnSignerAttempt(event)
 			}
 			continue
 		}
 
-		// Check payment callback
-		shouldPay, err := h.ShouldPay(*selected)
-		if err != nil || !shouldPay {
-			if err == nil {
-				err = fmt.Errorf("payment declined by policy")
-			}
+		// Check payment policy
+		reservation, err := h.approve(ctx, signer, *selected)
+		if err != nil {
+			failures = append(failures, SignerFailure{
+				SignerIndex:    idx,
+				SignerPriority: signer.GetPriority(),
+				SignerAddress:  signer.GetAddress(),
+				Reason:         err.Error(),
+				WrappedError:   err,
+			})
+			continue
+		}
+
+		if err := h.bridger.ensureFunds(ctx, signer, *selected); err != nil {
+			reservation.Release()
 			failures = append(failures, SignerFailure{
 				SignerIndex:    idx,
 				SignerPriority: signer.GetPriority(),
@@ -253,8 +398,9 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 		}
 
 		// Try to sign the payment
-		payload, err := signer.SignPayment(ctx, *selected)
+		payload, err := h.sign(ctx, signer, *selected)
 		if err != nil {
+			reservation.Release()
 			failures = append(failures, SignerFailure{
 				SignerIndex:    idx,
 				SignerPriority: signer.GetPriority(),
@@ -264,6 +410,7 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 			})
 			continue
 		}
+		holdSpend(ctx, reservation)
 
 		// Success - emit event and return
 		if h.config.OnSignerAttempt != nil {
@@ -279,8 +426,11 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 				Network:        selected.Network,
 				Asset:          selected.Asset,
 				Recipient:      selected.PayTo,
-				Timestamp:      time.Now().Unix(),
+				Timestamp:      clockOrSystem(h.config.Clock).Now().Unix(),
+				Attribution:    AttributionFromContext(ctx),
+				FiatEstimate:   selected.Extra[ExtraKeyFiatEstimate],
 			}
+			addFeeEstimate(ctx, &event)
 			h.config.OnSignerAttempt(event)
 		}
 
@@ -293,3 +443,132 @@ func (h *PaymentHandler) selectPaymentWithFallback(ctx context.Context, requirem
 		SignerFailures: failures,
 	}
 }
+
+// selectPaymentWithStrategy lets the configured strategy pick a signer and requirement,
+// retrying without a signer whenever it declines or fails to sign
+func (h *PaymentHandler) selectPaymentWithStrategy(ctx context.Context, requirements []PaymentRequirement) (*PaymentPayload, error) {
+	if len(requirements) == 0 {
+		return nil, ErrNoAcceptablePayment
+	}
+
+	active := h.config.SignerHealth.active(h.signers)
+	remaining := make([]PaymentSigner, len(active))
+	copy(remaining, active)
+
+	var failures []SignerFailure
+	attemptNumber := 0
+
+	for len(remaining) > 0 {
+		signer, selected, err := h.config.Strategy.SelectPayment(remaining, requirements)
+		if err == nil && signer != nil && !slices.Contains(remaining, signer) {
+			// Retrying would pick it again forever, and it may be unhealthy
+			err = fmt.Errorf("%w: strategy selected a signer it was not offered", ErrNoAcceptablePayment)
+		}
+		if err != nil || signer == nil || selected == nil {
+			if err == nil {
+				err = ErrNoAcceptablePayment
+			}
+			if len(failures) == 0 {
+				return nil, err
+			}
+			break
+		}
+
+		attemptNumber++
+		idx := h.signerIndex(signer)
+		h.emitSignerEvent(ctx, PaymentEventSignerAttempt, idx, signer, attemptNumber, nil, nil)
+
+		payload, err := h.signSelected(ctx, signer, *selected)
+		if err != nil {
+			failures = append(failures, SignerFailure{
+				SignerIndex:    idx,
+				SignerPriority: signer.GetPriority(),
+				SignerAddress:  signer.GetAddress(),
+				Reason:         err.Error(),
+				WrappedError:   err,
+			})
+			h.emitSignerEvent(ctx, PaymentEventSignerFailure, idx, signer, attemptNumber, nil, err)
+			remaining = removeSigner(remaining, signer)
+			continue
+		}
+
+		h.emitSignerEvent(ctx, PaymentEventSignerSuccess, idx, signer, attemptNumber, selected, nil)
+		return payload, nil
+	}
+
+	return nil, &MultiSignerError{
+		Message:        "no viable payment option found",
+		SignerFailures: failures,
+	}
+}
+
+// signSelected applies the payment policy and signs the selected requirement
+func (h *PaymentHandler) signSelected(ctx context.Context, signer PaymentSigner, selected PaymentRequirement) (*PaymentPayload, error) {
+	reservation, err := h.approve(ctx, signer, selected)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.bridger.ensureFunds(ctx, signer, selected); err != nil {
+		reservation.Release()
+		return nil, err
+	}
+
+	payload, err := h.sign(ctx, signer, selected)
+	if err != nil {
+		reservation.Release()
+		return nil, fmt.Errorf("signing failed: %w", err)
+	}
+	holdSpend(ctx, reservation)
+	return payload, nil
+}
+
+// signerIndex returns the signer's position in the handler's signer list
+func (h *PaymentHandler) signerIndex(signer PaymentSigner) int {
+	for i, s := range h.signers {
+		if s == signer {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeSigner returns signers without the given signer
+func removeSigner(signers []PaymentSigner, signer PaymentSigner) []PaymentSigner {
+	out := signers[:0]
+	for _, s := range signers {
+		if s != signer {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// emitSignerEvent reports a per-signer event to OnSignerAttempt
+func (h *PaymentHandler) emitSignerEvent(ctx context.Context, eventType PaymentEventType, idx int, signer PaymentSigner, attemptNumber int, selected *PaymentRequirement, err error) {
+	if h.config.OnSignerAttempt == nil {
+		return
+	}
+
+	event := PaymentEvent{
+		Type:           eventType,
+		SignerIndex:    idx,
+		SignerPriority: signer.GetPriority(),
+		SignerAddress:  signer.GetAddress(),
+		AttemptNumber:  attemptNumber,
+		Error:          err,
+		Timestamp:      clockOrSystem(h.config.Clock).Now().Unix(),
+		Attribution:    AttributionFromContext(ctx),
+	}
+	if selected != nil {
+		amount := new(big.Int)
+		amount.SetString(selected.MaxAmountRequired, 10)
+		event.Amount = amount
+		event.Network = selected.Network
+		event.Asset = selected.Asset
+		event.Recipient = selected.PayTo
+		event.FiatEstimate = selected.Extra[ExtraKeyFiatEstimate]
+		addFeeEstimate(ctx, &event)
+	}
+	h.config.OnSignerAttempt(event)
+}
@@ -0,0 +1,81 @@
+package x402
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+type requestHeadersKey struct{}
+
+// WithHeaders adds headers to the requests sent with ctx, including paid
+// retries, e.g. an X-Request-ID or traceparent correlating paid traffic in
+// gateways. They override Config.ExtraHeaders of the same name.
+func WithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	merged := make(map[string]string, len(headers))
+	for k, v := range HeadersFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, requestHeadersKey{}, merged)
+}
+
+// HeadersFromContext returns the headers set with WithHeaders
+func HeadersFromContext(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(requestHeadersKey{}).(map[string]string)
+	return headers
+}
+
+// reservedHeaders are set by the transport and can't be overridden
+var reservedHeaders = []string{
+	"Content-Type",
+	"Accept",
+	transport.HeaderKeySessionID,
+	transport.HeaderKeyProtocolVersion,
+	"X-PAYMENT",
+	HeaderPaymentEncoding,
+	HeaderPaymentBinding,
+	HeaderPaymentReference,
+	HeaderAccessPass,
+}
+
+// isReservedHeader reports whether the transport sets the header itself
+func isReservedHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, reserved := range reservedHeaders {
+		if name == http.CanonicalHeaderKey(reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// unreservedHeaders returns a copy of headers without the reserved ones
+func unreservedHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	kept := make(map[string]string, len(headers))
+	for k, v := range headers {
+		if !isReservedHeader(k) {
+			kept[k] = v
+		}
+	}
+	return kept
+}
+
+// setExtraHeaders sets Config.ExtraHeaders and the headers in ctx on req.
+// Call it before setting the transport's own headers, which take precedence.
+func (t *X402Transport) setExtraHeaders(ctx context.Context, req *http.Request) {
+	for k, v := range t.extraHeaders {
+		req.Header.Set(k, v)
+	}
+	for k, v := range HeadersFromContext(ctx) {
+		if !isReservedHeader(k) {
+			req.Header.Set(k, v)
+		}
+	}
+}
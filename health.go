@@ -0,0 +1,138 @@
+package x402
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Defaults for NetworkHealth thresholds
+	defaultHealthFailureThreshold = 0.25
+	defaultHealthSlowThreshold    = 10 * time.Second
+	defaultHealthWindow           = 5 * time.Minute
+
+	// healthSmoothing is the weight of the newest sample in moving averages
+	healthSmoothing = 0.2
+)
+
+// NetworkStats summarizes recent settlement behavior on a network
+type NetworkStats struct {
+	Latency     time.Duration // Moving average of paid request latency
+	FailureRate float64       // Moving average of failures, 0..1
+	Samples     int
+	LastUpdated time.Time
+}
+
+// NetworkHealth tracks per-network settlement latency and failure rates.
+// It is safe for concurrent use.
+type NetworkHealth struct {
+	// FailureThreshold marks a network as failing when its failure rate reaches it
+	FailureThreshold float64
+	// SlowThreshold marks a network as congested when its latency reaches it
+	SlowThreshold time.Duration
+	// Window is how long stats stay relevant without new samples
+	Window time.Duration
+
+	mu    sync.RWMutex
+	stats map[string]NetworkStats
+	now   func() time.Time
+}
+
+// NewNetworkHealth creates a tracker with default thresholds
+func NewNetworkHealth() *NetworkHealth {
+	return &NetworkHealth{
+		FailureThreshold: defaultHealthFailureThreshold,
+		SlowThreshold:    defaultHealthSlowThreshold,
+		Window:           defaultHealthWindow,
+		stats:            make(map[string]NetworkStats),
+		now:              time.Now,
+	}
+}
+
+// RecordSuccess records a settled payment and its latency
+func (h *NetworkHealth) RecordSuccess(network string, latency time.Duration) {
+	h.record(network, latency, false)
+}
+
+// RecordFailure records a payment that failed to settle
+func (h *NetworkHealth) RecordFailure(network string) {
+	h.record(network, 0, true)
+}
+
+// record updates the moving averages for a network
+func (h *NetworkHealth) record(network string, latency time.Duration, failed bool) {
+	if h == nil || network == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	failure := 0.0
+	if failed {
+		failure = 1.0
+	}
+
+	st, ok := h.stats[network]
+	if !ok || h.stale(st) {
+		st = NetworkStats{FailureRate: failure, Latency: latency}
+	} else {
+		st.FailureRate = st.FailureRate*(1-healthSmoothing) + failure*healthSmoothing
+		if !failed {
+			st.Latency = time.Duration(float64(st.Latency)*(1-healthSmoothing) + float64(latency)*healthSmoothing)
+		}
+	}
+	st.Samples++
+	st.LastUpdated = h.now()
+	h.stats[network] = st
+}
+
+// Stats returns the current stats for a network; ok is false when there is no recent data
+func (h *NetworkHealth) Stats(network string) (NetworkStats, bool) {
+	if h == nil {
+		return NetworkStats{}, false
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	st, ok := h.stats[network]
+	if !ok || h.stale(st) {
+		return NetworkStats{}, false
+	}
+	return st, true
+}
+
+// stale reports whether stats fell out of the window
+func (h *NetworkHealth) stale(st NetworkStats) bool {
+	return h.Window > 0 && h.now().Sub(st.LastUpdated) > h.Window
+}
+
+// rank buckets a network: 0 healthy or unknown, 1 congested, 2 failing
+func (h *NetworkHealth) rank(network string) int {
+	st, ok := h.Stats(network)
+	if !ok {
+		return 0
+	}
+	if st.FailureRate >= h.FailureThreshold {
+		return 2
+	}
+	if h.SlowThreshold > 0 && st.Latency >= h.SlowThreshold {
+		return 1
+	}
+	return 0
+}
+
+// HealthAware selects options on healthy networks before congested ones, and
+// congested before failing ones, using the default ordering within each group.
+// Networks recover once their stats age out of the tracker's window.
+func HealthAware(health *NetworkHealth) SelectionStrategy {
+	return SelectionStrategyFunc(func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+		return selectBest(PaymentCandidates(signers, accepts), accepts, func(a, b PaymentCandidate) bool {
+			if ra, rb := health.rank(a.Requirement.Network), health.rank(b.Requirement.Network); ra != rb {
+				return ra < rb
+			}
+			return defaultLess(a, b)
+		})
+	})
+}
@@ -0,0 +1,151 @@
+package x402
+
+import (
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// PaymentLogger is the logger name of the notifications/message x402 servers
+// and transports send about payments
+const PaymentLogger = "x402"
+
+// PaymentLog is the data of a payment notifications/message, so MCP hosts can
+// show payments in their standard log views
+type PaymentLog struct {
+	// Source is "server" for payments a server settled, "client" for the
+	// transport's own payment events
+	Source string `json:"source"`
+
+	// Event is the PaymentEventType, e.g. "success" or "failure"
+	Event string `json:"event"`
+
+	Resource     string `json:"resource,omitempty"`
+	Network      string `json:"network,omitempty"`
+	Asset        string `json:"asset,omitempty"`
+	Amount       string `json:"amount,omitempty"`
+	Recipient    string `json:"recipient,omitempty"`
+	Payer        string `json:"payer,omitempty"`
+	Transaction  string `json:"transaction,omitempty"`
+	FiatEstimate string `json:"fiatEstimate,omitempty"`
+	Error        string `json:"error,omitempty"`
+
+	// Payments and Totals aggregate the session's payments so far, including
+	// this one. Totals are in base units, keyed by "network:asset".
+	Payments int               `json:"payments"`
+	Totals   map[string]string `json:"totals,omitempty"`
+}
+
+// NewPaymentLogNotification returns the notifications/message reporting log
+func NewPaymentLogNotification(log PaymentLog) mcp.LoggingMessageNotification {
+	level := mcp.LoggingLevelInfo
+	if log.Event == string(PaymentEventFailure) {
+		level = mcp.LoggingLevelWarning
+	}
+	return mcp.NewLoggingMessageNotification(level, PaymentLogger, log)
+}
+
+// ParsePaymentLog returns the payment a notifications/message with logger
+// "x402" reports
+func ParsePaymentLog(notification mcp.JSONRPCNotification) (PaymentLog, bool) {
+	if notification.Method != "notifications/message" {
+		return PaymentLog{}, false
+	}
+	if logger, _ := notification.Params.AdditionalFields["logger"].(string); logger != PaymentLogger {
+		return PaymentLog{}, false
+	}
+	data, err := json.Marshal(notification.Params.AdditionalFields["data"])
+	if err != nil {
+		return PaymentLog{}, false
+	}
+	var log PaymentLog
+	if json.Unmarshal(data, &log) != nil || log.Event == "" {
+		return PaymentLog{}, false
+	}
+	return log, true
+}
+
+// SpendTally aggregates the payments of a session. It is safe for concurrent use.
+type SpendTally struct {
+	mu       sync.Mutex
+	payments int
+	totals   map[AssetKey]*big.Int
+}
+
+// Add counts a payment of amount, returning the session's payments and totals
+// so far for a PaymentLog
+func (s *SpendTally) Add(network, asset string, amount *big.Int) (int, map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.totals == nil {
+		s.totals = make(map[AssetKey]*big.Int)
+	}
+	s.payments++
+	key := AssetKey{Network: network, Asset: strings.ToLower(asset)}
+	if s.totals[key] == nil {
+		s.totals[key] = new(big.Int)
+	}
+	if amount != nil {
+		s.totals[key].Add(s.totals[key], amount)
+	}
+
+	totals := make(map[string]string, len(s.totals))
+	for k, total := range s.totals {
+		totals[k.Network+":"+k.Asset] = total.String()
+	}
+	return s.payments, totals
+}
+
+// Payments returns the number of payments counted
+func (s *SpendTally) Payments() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.payments
+}
+
+// logPaymentToHost delivers a payment event to the MCP client's notification
+// handler as a notifications/message with logger "x402", as if the server sent it
+func (t *X402Transport) logPaymentToHost(event PaymentEvent) {
+	log := PaymentLog{
+		Source:       "client",
+		Event:        string(event.Type),
+		Resource:     event.Resource,
+		Network:      event.Network,
+		Asset:        event.Asset,
+		Recipient:    event.Recipient,
+		Payer:        event.SignerAddress,
+		Transaction:  event.Transaction,
+		FiatEstimate: event.FiatEstimate,
+	}
+	if event.Amount != nil {
+		log.Amount = event.Amount.String()
+	}
+	if event.Error != nil {
+		log.Error = event.Error.Error()
+		log.Payments = t.spend.Payments()
+	} else {
+		log.Payments, log.Totals = t.spend.Add(event.Network, event.Asset, event.Amount)
+	}
+
+	message := NewPaymentLogNotification(log)
+	notification := mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: message.Method,
+			Params: mcp.NotificationParams{AdditionalFields: map[string]any{
+				"level":  message.Params.Level,
+				"logger": message.Params.Logger,
+				"data":   log,
+			}},
+		},
+	}
+
+	t.notifyMu.RLock()
+	defer t.notifyMu.RUnlock()
+	if t.notificationHandler != nil {
+		t.notificationHandler(notification)
+	}
+}
@@ -0,0 +1,200 @@
+package x402
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MetaKeyIdentity is the _meta key carrying the payer identity claim
+const MetaKeyIdentity = "x402/identity"
+
+// DefaultIdentityTTL is how long identity claims issued by EVMIdentity remain valid
+const DefaultIdentityTTL = 10 * time.Minute
+
+// identityClockSkew tolerates small clock differences between client and server
+const identityClockSkew = time.Minute
+
+// ErrInvalidIdentity is returned when an identity claim fails verification
+var ErrInvalidIdentity = errors.New("invalid identity claim")
+
+// IdentityClaim is a signed statement binding a stable payer identity to an MCP client.
+// Servers use it for reputation, discounts and abuse controls across sessions.
+type IdentityClaim struct {
+	Subject       string `json:"sub"`           // did:pkh:eip155:1:<address> or a plain address
+	Audience      string `json:"aud,omitempty"` // Server URL the claim is intended for
+	ClientName    string `json:"clientName,omitempty"`
+	ClientVersion string `json:"clientVersion,omitempty"`
+	IssuedAt      int64  `json:"iat"`
+	ExpiresAt     int64  `json:"exp"`
+	Signature     string `json:"signature"` // EIP-191 personal_sign over SigningMessage
+}
+
+// SigningMessage returns the text signed by the subject's key
+func (c *IdentityClaim) SigningMessage() string {
+	return fmt.Sprintf("x402 identity\nsub: %s\naud: %s\nclient: %s/%s\niat: %d\nexp: %d",
+		c.Subject, c.Audience, c.ClientName, c.ClientVersion, c.IssuedAt, c.ExpiresAt)
+}
+
+// IdentityProvider issues identity claims for a transport
+type IdentityProvider interface {
+	// IdentityClaim returns a signed claim for the given server and client info
+	IdentityClaim(ctx context.Context, audience string, client mcp.Implementation) (*IdentityClaim, error)
+}
+
+// EVMIdentity issues identity claims signed by an Ethereum key, with a did:pkh subject.
+// Claims are cached and reissued shortly before they expire.
+type EVMIdentity struct {
+	privateKey *ecdsa.PrivateKey
+	address    common.Address
+
+	// TTL is the lifetime of issued claims (DefaultIdentityTTL when zero)
+	TTL time.Duration
+
+	mu     sync.Mutex
+	cached *IdentityClaim
+}
+
+// NewEVMIdentity creates an identity provider from a hex-encoded private key
+func NewEVMIdentity(privateKeyHex string) (*EVMIdentity, error) {
+	privateKey, err := crypto.HexToECDSA(strings.TrimPrefix(privateKeyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+	return newEVMIdentity(privateKey), nil
+}
+
+// Identity returns an identity provider backed by the signer's key, so the payer
+// identity matches the paying address
+func (s *PrivateKeySigner) Identity() *EVMIdentity {
+	return newEVMIdentity(s.privateKey)
+}
+
+func newEVMIdentity(privateKey *ecdsa.PrivateKey) *EVMIdentity {
+	return &EVMIdentity{
+		privateKey: privateKey,
+		address:    crypto.PubkeyToAddress(privateKey.PublicKey),
+	}
+}
+
+// Subject returns the DID this provider signs as
+func (i *EVMIdentity) Subject() string {
+	return "did:pkh:eip155:1:" + i.address.Hex()
+}
+
+// IdentityClaim implements IdentityProvider
+func (i *EVMIdentity) IdentityClaim(ctx context.Context, audience string, client mcp.Implementation) (*IdentityClaim, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := time.Now()
+	if c := i.cached; c != nil && c.Audience == audience && c.ClientName == client.Name &&
+		c.ClientVersion == client.Version && now.Add(identityClockSkew).Unix() < c.ExpiresAt {
+		return c, nil
+	}
+
+	ttl := i.TTL
+	if ttl <= 0 {
+		ttl = DefaultIdentityTTL
+	}
+
+	claim := &IdentityClaim{
+		Subject:       i.Subject(),
+		Audience:      audience,
+		ClientName:    client.Name,
+		ClientVersion: client.Version,
+		IssuedAt:      now.Unix(),
+		ExpiresAt:     now.Add(ttl).Unix(),
+	}
+
+	signature, err := personalSign(i.privateKey, claim.SigningMessage())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign identity claim: %w", err)
+	}
+	claim.Signature = signature
+
+	i.cached = claim
+	return claim, nil
+}
+
+// VerifyIdentityClaim checks the claim's validity window and signature at the given time
+// and returns the address that signed it
+func VerifyIdentityClaim(claim *IdentityClaim, now time.Time) (string, error) {
+	if claim == nil {
+		return "", fmt.Errorf("%w: missing claim", ErrInvalidIdentity)
+	}
+	if now.Add(identityClockSkew).Unix() < claim.IssuedAt {
+		return "", fmt.Errorf("%w: issued in the future", ErrInvalidIdentity)
+	}
+	if now.Unix() >= claim.ExpiresAt {
+		return "", fmt.Errorf("%w: expired", ErrInvalidIdentity)
+	}
+
+	subject, err := identityAddress(claim.Subject)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := recoverPersonalSigner(claim.SigningMessage(), claim.Signature)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidIdentity, err)
+	}
+	if signer != subject {
+		return "", fmt.Errorf("%w: signed by %s, not %s", ErrInvalidIdentity, signer.Hex(), subject.Hex())
+	}
+	return subject.Hex(), nil
+}
+
+// personalSign signs message with EIP-191 personal_sign and returns the hex signature
+func personalSign(privateKey *ecdsa.PrivateKey, message string) (string, error) {
+	signature, err := crypto.Sign(accounts.TextHash([]byte(message)), privateKey)
+	if err != nil {
+		return "", err
+	}
+	// Use Ethereum's 27/28 recovery id convention
+	signature[64] += 27
+	return "0x" + hex.EncodeToString(signature), nil
+}
+
+// recoverPersonalSigner returns the address that personal_signed message
+func recoverPersonalSigner(message, signatureHex string) (common.Address, error) {
+	signature, err := hex.DecodeString(strings.TrimPrefix(signatureHex, "0x"))
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("malformed signature")
+	}
+	if signature[64] >= 27 {
+		signature[64] -= 27
+	}
+
+	pubKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), signature)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubKey), nil
+}
+
+// identityAddress extracts the Ethereum address from a did:pkh:eip155 DID or plain address
+func identityAddress(subject string) (common.Address, error) {
+	addr := subject
+	if strings.HasPrefix(subject, "did:") {
+		parts := strings.Split(subject, ":")
+		if len(parts) != 5 || parts[1] != "pkh" || parts[2] != "eip155" {
+			return common.Address{}, fmt.Errorf("%w: unsupported subject %s", ErrInvalidIdentity, subject)
+		}
+		addr = parts[4]
+	}
+	if !common.IsHexAddress(addr) {
+		return common.Address{}, fmt.Errorf("%w: invalid subject %s", ErrInvalidIdentity, subject)
+	}
+	return common.HexToAddress(addr), nil
+}
@@ -0,0 +1,1572 @@
+// Package integration exercises the x402 server and client together in one
+// process: an X402Server with paid and free tools, a mock HTTP facilitator and
+// an mcp-go client on an X402Transport. The tests double as executable
+// documentation of the full payment flow.
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mark3labs/mcp-go-x402"
+	x402server "github.com/mark3labs/mcp-go-x402/server"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const payTo = "0x209693Bc6afc0C5328bA36FaF03C514EF312287C"
+
+// mockFacilitator serves the facilitator HTTP API, approving every payment
+type mockFacilitator struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	verified []x402server.PaymentRequirement
+	settled  []x402server.PaymentRequirement
+	hold     chan struct{} // If set, settlements wait until it is closed
+}
+
+func newMockFacilitator(t *testing.T) *mockFacilitator {
+	f := &mockFacilitator{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/supported", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"kinds": []map[string]any{
+				{"x402Version": 1, "scheme": "exact", "network": "base-sepolia"},
+				{"x402Version": 1, "scheme": "permit2", "network": "base-sepolia"},
+				{"x402Version": 1, "scheme": "exact", "network": "polygon-amoy"},
+			},
+		})
+	})
+	mux.HandleFunc("/verify", func(w http.ResponseWriter, r *http.Request) {
+		var req x402server.VerifyRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.verified = append(f.verified, *req.PaymentRequirements)
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(x402server.VerifyResponse{IsValid: true, Payer: payerOf(req.PaymentPayload)})
+	})
+	mux.HandleFunc("/settle", func(w http.ResponseWriter, r *http.Request) {
+		var req x402server.SettleRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		hold := f.hold
+		f.mu.Unlock()
+		if hold != nil {
+			<-hold
+		}
+		f.mu.Lock()
+		f.settled = append(f.settled, *req.PaymentRequirements)
+		f.mu.Unlock()
+		_ = json.NewEncoder(w).Encode(x402server.SettleResponse{
+			Success:     true,
+			Transaction: "0xsettled",
+			Network:     req.PaymentRequirements.Network,
+			Payer:       payerOf(req.PaymentPayload),
+		})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// payerOf returns the EVM authorization's payer, like a real facilitator would
+func payerOf(payment *x402server.PaymentPayload) string {
+	payload, _ := payment.Payload.(map[string]any)
+	authorization, _ := payload["authorization"].(map[string]any)
+	if from, _ := authorization["from"].(string); from != "" {
+		return from
+	}
+	return "0xpayer"
+}
+
+func (f *mockFacilitator) counts() (verified, settled int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.verified), len(f.settled)
+}
+
+// harness is a running server, its facilitator and a connected client
+type harness struct {
+	facilitator *mockFacilitator
+	server      *x402server.X402Server
+	url         string
+	transport   *x402.X402Transport
+	client      *client.Client
+}
+
+// newHarness starts an X402Server with a free "echo" tool and a paid "search" tool
+// (0.001 USDC on Base Sepolia or 0.0005 USDC on Polygon Amoy) and connects a client
+// paying with the given signers
+func newHarness(t *testing.T, configure func(*x402server.Config), signers ...x402.PaymentSigner) *harness {
+	t.Helper()
+
+	facilitator := newMockFacilitator(t)
+	config := &x402server.Config{FacilitatorURL: facilitator.URL}
+	if configure != nil {
+		configure(config)
+	}
+
+	srv := x402server.NewX402Server("integration", "1.0.0", config, server.WithToolCapabilities(false))
+	srv.AddTool(mcp.NewTool("echo"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo"), nil
+	})
+	srv.AddPayableTool(mcp.NewTool("search"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		info, ok := x402server.PaymentFromContext(ctx)
+		if !ok {
+			return mcp.NewToolResultError("no payment in context"), nil
+		}
+		return mcp.NewToolResultText("paid on " + info.Requirement.Network), nil
+	},
+		x402server.RequireUSDCBaseSepolia(payTo, "1000", "Search"),
+		x402server.RequireUSDCPolygonAmoy(payTo, "500", "Search"),
+	)
+
+	httpServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(httpServer.Close)
+
+	h := &harness{facilitator: facilitator, server: srv, url: httpServer.URL}
+	h.transport, h.client = h.connect(t, x402.Config{Signers: signers})
+	return h
+}
+
+// connect starts and initializes another client of the harness server
+func (h *harness) connect(t *testing.T, config x402.Config) (*x402.X402Transport, *client.Client) {
+	t.Helper()
+
+	config.ServerURL = h.url
+	trans, err := x402.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcpClient := client.NewClient(trans)
+	if err := mcpClient.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mcpClient.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = mcpClient.Close() })
+
+	return trans, mcpClient
+}
+
+func (h *harness) call(t *testing.T, tool string) *mcp.CallToolResult {
+	t.Helper()
+	request := mcp.CallToolRequest{}
+	request.Params.Name = tool
+	result, err := h.client.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("CallTool(%s): %v", tool, err)
+	}
+	if result.IsError {
+		t.Fatalf("CallTool(%s) returned an error result: %v", tool, result.Content)
+	}
+	return result
+}
+
+func resultText(result *mcp.CallToolResult) string {
+	if len(result.Content) == 0 {
+		return ""
+	}
+	text, _ := mcp.AsTextContent(result.Content[0])
+	if text == nil {
+		return ""
+	}
+	return text.Text
+}
+
+func settlement(result *mcp.CallToolResult) map[string]any {
+	if result.Meta == nil {
+		return nil
+	}
+	s, _ := result.Meta.AdditionalFields["x402/payment-response"].(map[string]any)
+	return s
+}
+
+func TestFreeTool(t *testing.T) {
+	h := newHarness(t, nil, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	result := h.call(t, "echo")
+	if got := resultText(result); got != "echo" {
+		t.Errorf("Expected echo, got %q", got)
+	}
+	if settlement(result) != nil {
+		t.Error("Free tool should not carry a settlement")
+	}
+	if verified, settled := h.facilitator.counts(); verified != 0 || settled != 0 {
+		t.Errorf("Free tool should not reach the facilitator, got %d verified, %d settled", verified, settled)
+	}
+}
+
+func TestPaidTool(t *testing.T) {
+	h := newHarness(t, nil, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	result := h.call(t, "search")
+	if got := resultText(result); got != "paid on base-sepolia" {
+		t.Errorf("Unexpected result %q", got)
+	}
+
+	s := settlement(result)
+	if s == nil {
+		t.Fatal("Paid tool result should carry x402/payment-response")
+	}
+	if s["success"] != true || s["transaction"] != "0xsettled" || s["network"] != "base-sepolia" {
+		t.Errorf("Unexpected settlement %v", s)
+	}
+
+	if verified, settled := h.facilitator.counts(); verified != 1 || settled != 1 {
+		t.Errorf("Expected 1 verify and 1 settle, got %d and %d", verified, settled)
+	}
+	if amount := h.facilitator.settled[0].MaxAmountRequired; amount != "1000" {
+		t.Errorf("Expected 1000 settled, got %s", amount)
+	}
+}
+
+func TestMultiOptionSelection(t *testing.T) {
+	// The client prefers Polygon Amoy, which the server also accepts
+	h := newHarness(t, nil,
+		x402.NewMockSigner("0xPolygonWallet", x402.AcceptUSDCPolygonAmoy()).WithPriority(1),
+		x402.NewMockSigner("0xBaseWallet", x402.AcceptUSDCBaseSepolia()).WithPriority(2),
+	)
+
+	result := h.call(t, "search")
+	if got := resultText(result); got != "paid on polygon-amoy" {
+		t.Errorf("Unexpected result %q", got)
+	}
+	if amount := h.facilitator.settled[0].MaxAmountRequired; amount != "500" {
+		t.Errorf("Expected the Polygon Amoy price of 500, got %s", amount)
+	}
+}
+
+func TestVerifyOnly(t *testing.T) {
+	h := newHarness(t, func(c *x402server.Config) { c.VerifyOnly = true },
+		x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	result := h.call(t, "search")
+	if s := settlement(result); s == nil || s["transaction"] != "verify-only-mode" {
+		t.Errorf("Expected verify-only settlement, got %v", s)
+	}
+	if verified, settled := h.facilitator.counts(); verified != 1 || settled != 0 {
+		t.Errorf("Expected 1 verify and no settle, got %d and %d", verified, settled)
+	}
+}
+
+func TestToolCost(t *testing.T) {
+	h := newHarness(t, nil, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	_, cost, err := x402.NewCostAwareClient(h.client).CallToolWithCost(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cost.Total(x402.USDCAddressBaseSepolia).String(); got != "1000" {
+		t.Errorf("Expected a cost of 1000, got %s", got)
+	}
+	if cost.Transaction != "0xsettled" {
+		t.Errorf("Expected transaction 0xsettled, got %s", cost.Transaction)
+	}
+}
+
+func TestPaymentProgress(t *testing.T) {
+	h := newHarness(t, func(c *x402server.Config) { c.PaymentProgress = true },
+		x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	var mu sync.Mutex
+	var messages []string
+	h.client.OnNotification(func(n mcp.JSONRPCNotification) {
+		if n.Method != "notifications/progress" {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		message, _ := n.Params.AdditionalFields["message"].(string)
+		messages = append(messages, message)
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	request.Params.Meta = &mcp.Meta{ProgressToken: "search-1"}
+	result, err := h.client.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := settlement(result); s == nil || s["transaction"] != "0xsettled" {
+		t.Errorf("Expected settlement in streamed result, got %v", s)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"Verifying payment", "Settling payment on base-sepolia", "Payment settled, tx=0xsettled"}
+	if len(messages) != len(want) {
+		t.Fatalf("Expected progress %v, got %v", want, messages)
+	}
+	for i := range want {
+		if messages[i] != want[i] {
+			t.Errorf("Progress %d: expected %q, got %q", i, want[i], messages[i])
+		}
+	}
+}
+
+func TestSettlementPendingHint(t *testing.T) {
+	h := newHarness(t, func(c *x402server.Config) {
+		c.PaymentProgress = true
+		c.ExpectedSettlementTime = 1500 * time.Millisecond
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	var mu sync.Mutex
+	var pending []any
+	h.client.OnNotification(func(n mcp.JSONRPCNotification) {
+		mu.Lock()
+		defer mu.Unlock()
+		if hint, ok := n.Params.Meta[x402.MetaKeySettlementPending]; ok {
+			pending = append(pending, hint)
+		}
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	request.Params.Meta = &mcp.Meta{ProgressToken: "search-1"}
+	if _, err := h.client.CallTool(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pending) != 1 {
+		t.Fatalf("Expected one settlement-pending hint, got %v", pending)
+	}
+	if hint, _ := pending[0].(map[string]any); hint["extendSeconds"] != float64(2) {
+		t.Errorf("Expected the hint to round up to 2 seconds, got %v", pending[0])
+	}
+}
+
+func TestServerCapability(t *testing.T) {
+	// The client knows no -32402 code; it learns it from the advertised capability
+	h := newHarness(t, func(c *x402server.Config) {
+		c.AdvertiseCapability = true
+		c.PaymentRequiredCode = -32402
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	capability, ok := h.transport.ServerCapability()
+	if !ok {
+		t.Fatal("Expected the server to advertise an x402 capability")
+	}
+	if capability.PaymentRequiredCode != -32402 {
+		t.Errorf("Expected payment required code -32402, got %d", capability.PaymentRequiredCode)
+	}
+	if !capability.SupportsFlow(x402.FlowMeta) || !capability.SupportsFlow(x402.FlowHeader) {
+		t.Errorf("Expected both payment flows, got %v", capability.Flows)
+	}
+	if !capability.Accepts("base-sepolia", x402.USDCAddressBaseSepolia) || !capability.Accepts("polygon-amoy", x402.USDCAddressPolygonAmoy) {
+		t.Errorf("Expected the search tool's currencies, got %v", capability.Currencies)
+	}
+
+	result := h.call(t, "search")
+	if got := resultText(result); got != "paid on base-sepolia" {
+		t.Errorf("Unexpected result %q", got)
+	}
+}
+
+func TestNoServerCapability(t *testing.T) {
+	h := newHarness(t, nil, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	if _, ok := h.transport.ServerCapability(); ok {
+		t.Error("Server should not advertise a capability unless configured")
+	}
+}
+
+func TestAccessPassSurvivesRestart(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) {
+		c.AccessRequirements = []x402server.PaymentRequirement{x402server.RequireUSDCBaseSepolia(payTo, "5000", "Access")}
+		c.AccessPassTTL = time.Hour
+	}, signer)
+
+	store := x402.NewFileTokenStore(filepath.Join(t.TempDir(), "tokens.json"))
+	_, first := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}, TokenStore: store})
+	if _, err := first.ListTools(context.Background(), mcp.ListToolsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, settled := h.facilitator.counts(); settled != 1 {
+		t.Fatalf("Expected the access fee to be paid once, got %d settlements", settled)
+	}
+	_ = first.Close()
+
+	// A restarted client restores the pass and gets a new session without paying
+	_, restarted := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}, TokenStore: store})
+	if _, err := restarted.ListTools(context.Background(), mcp.ListToolsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, settled := h.facilitator.counts(); settled != 1 {
+		t.Errorf("Restarted client should not pay the access fee again, got %d settlements", settled)
+	}
+
+	// A client without the stored pass pays
+	_, other := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}})
+	if _, err := other.ListTools(context.Background(), mcp.ListToolsRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, settled := h.facilitator.counts(); settled != 2 {
+		t.Errorf("Expected a new client without a pass to pay, got %d settlements", settled)
+	}
+}
+
+func TestReceiptResources(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) {
+		c.Receipts = x402server.NewReceiptStore(0)
+	}, signer)
+	h.call(t, "search")
+
+	readReceipts := func(c *client.Client, uri string) string {
+		t.Helper()
+		request := mcp.ReadResourceRequest{}
+		request.Params.URI = uri
+		result, err := c.ReadResource(context.Background(), request)
+		if err != nil {
+			t.Fatalf("ReadResource(%s): %v", uri, err)
+		}
+		text, ok := result.Contents[0].(mcp.TextResourceContents)
+		if !ok {
+			t.Fatalf("Expected text contents, got %T", result.Contents[0])
+		}
+		return text.Text
+	}
+
+	var receipts []x402server.Receipt
+	if err := json.Unmarshal([]byte(readReceipts(h.client, x402server.ReceiptsURI)), &receipts); err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 1 {
+		t.Fatalf("Expected 1 receipt, got %d", len(receipts))
+	}
+	got := receipts[0]
+	if got.Transaction != "0xsettled" || got.Tool != "search" || got.Amount != "1000" || got.Payer != signer.GetAddress() {
+		t.Errorf("Unexpected receipt: %+v", got)
+	}
+
+	var receipt x402server.Receipt
+	if err := json.Unmarshal([]byte(readReceipts(h.client, x402server.ReceiptsURI+"/0xsettled")), &receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Transaction != "0xsettled" || receipt.Tool != "search" {
+		t.Errorf("Unexpected receipt: %+v", receipt)
+	}
+
+	// Other sessions don't see the receipt in their listing
+	_, other := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}})
+	if listing := readReceipts(other, x402server.ReceiptsURI); listing != "[]" {
+		t.Errorf("Expected no receipts for another session, got %s", listing)
+	}
+}
+
+func TestPayOnBehalf(t *testing.T) {
+	// An organization wallet pays for its end users
+	org := x402.NewMockSigner("0xOrgWallet", x402.AcceptUSDCBaseSepolia())
+	var beneficiary string
+	store := x402server.NewReceiptStore(0)
+	h := newHarness(t, func(c *x402server.Config) {
+		c.Receipts = store
+	}, org)
+	h.server.AddPayableTool(mcp.NewTool("report"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if info, ok := x402server.PaymentFromContext(ctx); ok {
+			beneficiary = info.OnBehalfOf
+		}
+		return mcp.NewToolResultText("report"), nil
+	}, x402server.RequireUSDCBaseSepolia(payTo, "1000", "Report"))
+
+	var payments []x402.PaymentEvent
+	_, mcpClient := h.connect(t, x402.Config{
+		Signers:          []x402.PaymentSigner{org},
+		OnPaymentSuccess: func(event x402.PaymentEvent) { payments = append(payments, event) },
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "report"
+	result, err := mcpClient.CallTool(x402.WithOnBehalfOf(context.Background(), "user-42"), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if beneficiary != "user-42" {
+		t.Errorf("Expected the tool to see user-42 as beneficiary, got %q", beneficiary)
+	}
+	if s := settlement(result); s["onBehalfOf"] != "user-42" || s["payer"] != org.GetAddress() {
+		t.Errorf("Expected the settlement to name the org payer and user-42, got %v", s)
+	}
+	if len(payments) != 1 || payments[0].OnBehalfOf != "user-42" {
+		t.Errorf("Expected a payment event on behalf of user-42, got %+v", payments)
+	}
+
+	receipts := store.All()
+	if len(receipts) != 1 || receipts[0].Payer != org.GetAddress() || receipts[0].OnBehalfOf != "user-42" {
+		t.Errorf("Expected a receipt with the org payer and user-42, got %+v", receipts)
+	}
+}
+
+func TestPendingSettlement(t *testing.T) {
+	store := x402server.NewReceiptStore(0)
+	clock := x402.NewFakeClock(time.Now().Truncate(time.Second))
+	h := newHarness(t, func(c *x402server.Config) {
+		c.SettlementTimeout = 50 * time.Millisecond
+		c.Receipts = store
+		c.Clock = clock
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	// The facilitator is slow to settle
+	hold := make(chan struct{})
+	h.facilitator.mu.Lock()
+	h.facilitator.hold = hold
+	h.facilitator.mu.Unlock()
+
+	result := h.call(t, "search")
+	if got := resultText(result); got != "paid on base-sepolia" {
+		t.Errorf("Expected the verified payment to be served, got %q", got)
+	}
+	s := settlement(result)
+	paymentID, _ := s["paymentId"].(string)
+	if s["success"] != true || s["status"] != x402.SettlementStatusPending || paymentID == "" {
+		t.Fatalf("Expected a pending settlement with a payment ID, got %v", s)
+	}
+	if receipts := store.All(); len(receipts) != 0 {
+		t.Errorf("Expected no receipt before settlement, got %+v", receipts)
+	}
+
+	status := func() x402.SettlementStatus {
+		t.Helper()
+		resource := mcp.ReadResourceRequest{}
+		resource.Params.URI = x402.SettlementStatusURI(paymentID)
+		contents, err := h.client.ReadResource(context.Background(), resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, _ := contents.Contents[0].(mcp.TextResourceContents)
+		var status x402.SettlementStatus
+		if err := json.Unmarshal([]byte(text.Text), &status); err != nil {
+			t.Fatal(err)
+		}
+		return status
+	}
+	if got := status(); got.Status != x402.SettlementStatusPending || !got.UpdatedAt.Equal(clock.Now()) {
+		t.Errorf("Expected the status to be pending as of the server's clock, got %+v", got)
+	}
+
+	close(hold)
+	deadline := time.Now().Add(5 * time.Second)
+	for (status().Status == x402.SettlementStatusPending || len(store.All()) == 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := status(); got.Status != x402.SettlementStatusSettled || got.Transaction != "0xsettled" {
+		t.Errorf("Expected the payment to settle in 0xsettled, got %+v", got)
+	}
+	if receipts := store.All(); len(receipts) != 1 || receipts[0].Transaction != "0xsettled" {
+		t.Errorf("Expected a receipt once settled, got %+v", receipts)
+	}
+}
+
+func TestPendingSettlementDeposit(t *testing.T) {
+	h := newHarness(t, func(c *x402server.Config) {
+		c.SettlementTimeout = 50 * time.Millisecond
+		c.DepositRequirements = []x402server.PaymentRequirement{
+			x402server.RequireUSDCBaseSepolia(payTo, "2500", "Deposit"),
+		}
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	hold := make(chan struct{})
+	h.facilitator.mu.Lock()
+	h.facilitator.hold = hold
+	h.facilitator.mu.Unlock()
+
+	// A deposit is credited only once its payment settled, however long that takes
+	done := make(chan *x402.Deposit, 1)
+	go func() {
+		deposit, err := h.transport.Deposit(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		done <- deposit
+	}()
+	select {
+	case deposit := <-done:
+		close(hold)
+		t.Fatalf("Expected the deposit to wait for settlement, got %+v", deposit)
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	close(hold)
+	select {
+	case deposit := <-done:
+		if deposit == nil || len(deposit.Balances) != 1 || deposit.Balances[0].Amount != "2500" {
+			t.Errorf("Expected a deposit of 2500 once settled, got %+v", deposit)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Deposit never completed")
+	}
+}
+
+func TestRequestIDHeader(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	store := x402server.NewReceiptStore(0)
+	var seen string
+	h := newHarness(t, func(c *x402server.Config) {
+		c.Receipts = store
+	}, signer)
+	h.server.AddPayableTool(mcp.NewTool("audit"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if info, ok := x402server.PaymentFromContext(ctx); ok {
+			seen = info.RequestID
+		}
+		return mcp.NewToolResultText("audited"), nil
+	}, x402server.RequireUSDCBaseSepolia(payTo, "1000", "Audit"))
+
+	_, mcpClient := h.connect(t, x402.Config{
+		Signers:      []x402.PaymentSigner{signer},
+		ExtraHeaders: map[string]string{"X-Request-ID": "gateway-default"},
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "audit"
+	ctx := x402.WithHeaders(context.Background(), map[string]string{"X-Request-ID": "req-42"})
+	if _, err := mcpClient.CallTool(ctx, request); err != nil {
+		t.Fatal(err)
+	}
+	if seen != "req-42" {
+		t.Errorf("Expected the tool to see request ID req-42, got %q", seen)
+	}
+	if receipts := store.All(); len(receipts) != 1 || receipts[0].RequestID != "req-42" {
+		t.Errorf("Expected a receipt for request req-42, got %+v", receipts)
+	}
+
+	// Without a per-request ID the configured one is sent
+	if _, err := mcpClient.CallTool(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if seen != "gateway-default" {
+		t.Errorf("Expected the configured request ID, got %q", seen)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	// Client and server share a clock years ahead of the system's
+	clock := x402.NewFakeClock(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC))
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()).WithClock(clock)
+	h := newHarness(t, func(c *x402server.Config) {
+		c.Clock = clock
+		c.StrictAuthorizationWindow = true
+		c.QuoteTTL = time.Minute
+	}, signer)
+
+	// The authorization window is checked against the shared clock
+	h.call(t, "search")
+
+	quote, err := h.transport.RequestQuote(context.Background(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !quote.ExpiresAt().Equal(clock.Now().Add(time.Minute)) {
+		t.Errorf("Expected the quote to expire a minute after %s, got %s", clock.Now(), quote.ExpiresAt())
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	clock.Advance(59 * time.Second)
+	if result, err := h.client.CallTool(context.Background(), x402.WithQuote(request, quote)); err != nil || result.IsError {
+		t.Fatalf("Expected the quote to be honored before it expires, got %v %v", result, err)
+	}
+
+	clock.Advance(2 * time.Second)
+	result, err := h.client.CallTool(context.Background(), x402.WithQuote(request, quote))
+	if err == nil && (result == nil || !result.IsError) {
+		t.Error("Expected the quote to be rejected once expired")
+	}
+}
+
+func TestSettlementFinalized(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) {
+		c.SettlementTimeout = 50 * time.Millisecond
+	}, signer)
+
+	hold := make(chan struct{})
+	h.facilitator.mu.Lock()
+	h.facilitator.hold = hold
+	h.facilitator.mu.Unlock()
+
+	finalized := make(chan x402.SettlementStatus, 1)
+	trans, mcpClient := h.connect(t, x402.Config{
+		Signers:                []x402.PaymentSigner{signer},
+		OnSettlementFinalized:  func(status x402.SettlementStatus) { finalized <- status },
+		SettlementPollInterval: 20 * time.Millisecond,
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	result, err := mcpClient.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentID, _ := settlement(result)["paymentId"].(string)
+	if paymentID == "" {
+		t.Fatalf("Expected a pending settlement, got %v", settlement(result))
+	}
+
+	status, err := trans.GetSettlementStatus(context.Background(), paymentID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != x402.SettlementStatusPending || status.Network != "base-sepolia" {
+		t.Errorf("Expected a pending settlement on base-sepolia, got %+v", status)
+	}
+	if _, err := trans.GetSettlementStatus(context.Background(), "unknown"); err == nil {
+		t.Error("Expected an error for an unknown payment")
+	}
+
+	select {
+	case status := <-finalized:
+		t.Fatalf("Settlement reported final while still pending: %+v", status)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(hold)
+	select {
+	case status := <-finalized:
+		if status.PaymentID != paymentID || status.Status != x402.SettlementStatusSettled || status.Transaction != "0xsettled" {
+			t.Errorf("Expected payment %s settled in 0xsettled, got %+v", paymentID, status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnSettlementFinalized was not called")
+	}
+}
+
+func TestQuoteLocksPrice(t *testing.T) {
+	var config *x402server.Config
+	h := newHarness(t, func(c *x402server.Config) {
+		c.QuoteTTL = time.Minute
+		config = c
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	quote, err := h.transport.RequestQuote(context.Background(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote.Tool != "search" || len(quote.Accepts) != 2 || quote.Accepts[0].MaxAmountRequired != "1000" {
+		t.Fatalf("Unexpected quote: %+v", quote)
+	}
+
+	// The live price rises after quoting
+	config.PaymentTools["search"][0].MaxAmountRequired = "5000"
+
+	settledAmount := func() string {
+		h.facilitator.mu.Lock()
+		defer h.facilitator.mu.Unlock()
+		return h.facilitator.settled[len(h.facilitator.settled)-1].MaxAmountRequired
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	if _, err := h.client.CallTool(context.Background(), x402.WithQuote(request, quote)); err != nil {
+		t.Fatal(err)
+	}
+	if got := settledAmount(); got != "1000" {
+		t.Errorf("Expected the quoted price 1000 to be charged, got %s", got)
+	}
+
+	h.call(t, "search")
+	if got := settledAmount(); got != "5000" {
+		t.Errorf("Expected the live price 5000 without a quote, got %s", got)
+	}
+
+	// A tampered quote is rejected
+	tampered := *quote
+	tampered.Token = strings.Replace(quote.Token, ".", ".x", 1)
+	result, err := h.client.CallTool(context.Background(), x402.WithQuote(request, &tampered))
+	if err == nil && (result == nil || !result.IsError) {
+		t.Error("Expected a tampered quote to be rejected")
+	}
+}
+
+func TestOnPaidEnrichesResult(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	var payers []string
+	h := newHarness(t, func(c *x402server.Config) {
+		c.OnPaid = func(ctx context.Context, payer string, settlement *x402server.SettleResponse, result *mcp.CallToolResult) (*mcp.CallToolResult, error) {
+			if _, ok := x402server.PaymentFromContext(ctx); !ok {
+				return nil, errors.New("no payment in context")
+			}
+			payers = append(payers, payer)
+			result.Content = append(result.Content, mcp.NewTextContent("premium: "+settlement.Transaction))
+			return result, nil
+		}
+	}, signer)
+
+	result := h.call(t, "search")
+	if len(result.Content) != 2 {
+		t.Fatalf("Expected 2 content items, got %d", len(result.Content))
+	}
+	if text, _ := mcp.AsTextContent(result.Content[1]); text == nil || text.Text != "premium: 0xsettled" {
+		t.Errorf("Unexpected enriched content: %v", result.Content[1])
+	}
+	if resultText(result) != "paid on base-sepolia" {
+		t.Errorf("Expected the tool's content first, got %q", resultText(result))
+	}
+	if result.Meta == nil || result.Meta.AdditionalFields["x402/payment-response"] == nil {
+		t.Error("Expected the settlement in _meta of the enriched result")
+	}
+
+	// Free tools are not passed through the hook
+	h.call(t, "echo")
+	if len(payers) != 1 || payers[0] != signer.GetAddress() {
+		t.Errorf("Expected one OnPaid call for %s, got %v", signer.GetAddress(), payers)
+	}
+}
+
+func TestReverseProxy(t *testing.T) {
+	// A plain MCP server without payment support, standing in for a Node or Python server
+	upstreamServer := server.NewMCPServer("upstream", "1.0.0")
+	upstreamServer.AddTool(mcp.NewTool("echo"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("echo"), nil
+	})
+	upstreamServer.AddTool(mcp.NewTool("search"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// A notification during the call makes the upstream answer with an event stream
+		_ = server.ServerFromContext(ctx).SendNotificationToClient(ctx, "notifications/message",
+			map[string]any{"level": "info", "data": "searching"})
+		return mcp.NewToolResultText("results"), nil
+	})
+	upstream := httptest.NewServer(server.NewStreamableHTTPServer(upstreamServer))
+	t.Cleanup(upstream.Close)
+
+	facilitator := newMockFacilitator(t)
+	proxy, err := x402server.NewX402Proxy(upstream.URL+"/mcp", &x402server.Config{
+		FacilitatorURL: facilitator.URL,
+		PaymentTools: map[string][]x402server.PaymentRequirement{
+			"search": {x402server.RequireUSDCBaseSepolia(payTo, "1000", "Search")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyServer := httptest.NewServer(proxy)
+	t.Cleanup(proxyServer.Close)
+
+	var payments []x402.PaymentEvent
+	h := &harness{facilitator: facilitator, url: proxyServer.URL + "/some/path"}
+	h.transport, h.client = h.connect(t, x402.Config{
+		Signers:          []x402.PaymentSigner{x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())},
+		OnPaymentSuccess: func(event x402.PaymentEvent) { payments = append(payments, event) },
+	})
+
+	if got := resultText(h.call(t, "echo")); got != "echo" {
+		t.Errorf("Expected free tool to pass through, got %q", got)
+	}
+	result := h.call(t, "search")
+	if got := resultText(result); got != "results" {
+		t.Errorf("Expected upstream result, got %q", got)
+	}
+	if _, settled := facilitator.counts(); settled != 1 {
+		t.Errorf("Expected 1 settlement, got %d", settled)
+	}
+	if result.Meta == nil || result.Meta.AdditionalFields["x402/payment-response"] == nil {
+		t.Error("Expected the settlement in _meta of the upstream's event stream response")
+	}
+	if len(payments) != 1 {
+		t.Errorf("Expected one successful payment, got %d", len(payments))
+	}
+}
+
+func TestPricingExperimentReceipts(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) {
+		c.Receipts = x402server.NewReceiptStore(0)
+		c.PricingExperiments = []x402server.PricingExperiment{{
+			Name:     "higher-search",
+			Percent:  100,
+			Variants: map[string][]x402server.PaymentRequirement{"search": {x402server.RequireUSDCBaseSepolia(payTo, "2000", "Search")}},
+		}}
+	}, signer)
+	h.call(t, "search")
+
+	h.facilitator.mu.Lock()
+	settled := h.facilitator.settled
+	h.facilitator.mu.Unlock()
+	if len(settled) != 1 || settled[0].MaxAmountRequired != "2000" {
+		t.Fatalf("Expected the variant price to be charged, got %+v", settled)
+	}
+
+	request := mcp.ReadResourceRequest{}
+	request.Params.URI = x402server.ReceiptsURI
+	result, err := h.client.ReadResource(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var receipts []x402server.Receipt
+	if err := json.Unmarshal([]byte(result.Contents[0].(mcp.TextResourceContents).Text), &receipts); err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 1 || receipts[0].Experiment != "higher-search/variant" {
+		t.Errorf("Expected a receipt labeled with the variant, got %+v", receipts)
+	}
+}
+
+func TestFiatEstimate(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	var payments []x402.PaymentEvent
+	h := newHarness(t, func(c *x402server.Config) {
+		// USDC has 6 decimals; values are in micro-dollars
+		c.FiatOracle = x402.PriceOracleFunc(func(ctx context.Context, network, asset string, amount *big.Int) (*big.Int, error) {
+			return amount, nil
+		})
+		c.FiatDecimals = 6
+	}, signer)
+	_, mcpClient := h.connect(t, x402.Config{
+		Signers:          []x402.PaymentSigner{signer},
+		OnPaymentSuccess: func(event x402.PaymentEvent) { payments = append(payments, event) },
+	})
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	if _, err := mcpClient.CallTool(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 || payments[0].FiatEstimate != "~$0.001" {
+		t.Fatalf("Expected a fiat estimate of ~$0.001, got %+v", payments)
+	}
+
+	// The estimate is display metadata only, not part of what is settled
+	h.facilitator.mu.Lock()
+	defer h.facilitator.mu.Unlock()
+	if _, ok := h.facilitator.settled[0].Extra[x402.ExtraKeyFiatEstimate]; ok {
+		t.Error("Expected the settled requirement without the fiat estimate")
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	h := newHarness(t, func(c *x402server.Config) {
+		c.QuoteTTL = time.Minute
+		c.AdvertiseCapability = true
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+	ctx := context.Background()
+
+	report, err := x402.CheckCompatibility(ctx, h.url, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Capability == nil || len(report.Currencies) != 2 {
+		t.Fatalf("Expected two advertised currencies, got %+v", report.Currencies)
+	}
+	for _, currency := range report.Currencies {
+		if currency.Payable != (currency.Network == "base-sepolia") {
+			t.Errorf("Unexpected payability of %s: %v", currency.Network, currency.Payable)
+		}
+	}
+	if payable := report.PayableTools(); len(payable) != 1 || payable[0] != "search" {
+		t.Errorf("Expected search to be payable, got %v", payable)
+	}
+	for _, tool := range report.Tools {
+		if tool.Name == "echo" && (tool.Quoted || tool.QuoteError == "") {
+			t.Errorf("Expected the free echo tool to be unquoted, got %+v", tool)
+		}
+	}
+	if verified, settled := h.facilitator.counts(); verified != 0 || settled != 0 {
+		t.Errorf("Expected no payments, got %d verified and %d settled", verified, settled)
+	}
+
+	// A wallet capped below the price can't afford the tool
+	report, err = x402.CheckCompatibility(ctx, h.url, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia().WithMaxAmount("100")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payable := report.PayableTools(); len(payable) != 0 {
+		t.Errorf("Expected no payable tools, got %v", payable)
+	}
+}
+
+func TestTransportSessions(t *testing.T) {
+	budget, err := x402.NewBudgetManager(x402.BudgetLimits{Window: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, nil, signer)
+	trans, original := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}, Budget: budget})
+
+	session := trans.NewSession()
+	sessionClient := client.NewClient(session)
+	if err := sessionClient.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sessionClient.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if session.GetSessionId() == "" || session.GetSessionId() == trans.GetSessionId() {
+		t.Fatalf("Expected a new session, got %q and %q", session.GetSessionId(), trans.GetSessionId())
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	if _, err := sessionClient.CallTool(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessionClient.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The original session stays usable and shares the budget
+	if _, err := original.CallTool(context.Background(), request); err != nil {
+		t.Fatal(err)
+	}
+	if spent := budget.Spent("base-sepolia", x402.USDCAddressBaseSepolia); spent.Cmp(big.NewInt(2000)) != 0 {
+		t.Errorf("Expected both sessions to spend from the shared budget, got %s", spent)
+	}
+}
+
+func TestMetaNamespaces(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) {
+		c.MetaNamespace = x402.MetaNamespaceXPayment
+	}, signer)
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	for _, namespace := range []x402.MetaNamespace{{}, x402.MetaNamespacePlain, x402.MetaNamespaceXPayment} {
+		var succeeded int
+		_, c := h.connect(t, x402.Config{
+			Signers:          []x402.PaymentSigner{signer},
+			MetaNamespace:    namespace,
+			OnPaymentSuccess: func(x402.PaymentEvent) { succeeded++ },
+		})
+		result, err := c.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatalf("%+v: %v", namespace, err)
+		}
+		if result.Meta == nil || result.Meta.AdditionalFields["x-payment-response"] == nil {
+			t.Errorf("%+v: expected the settlement in _meta[\"x-payment-response\"], got %+v", namespace, result.Meta)
+		}
+		if succeeded != 1 {
+			t.Errorf("%+v: expected the client to read the settlement, got %d successes", namespace, succeeded)
+		}
+	}
+	if _, settled := h.facilitator.counts(); settled != 3 {
+		t.Errorf("Expected 3 settlements, got %d", settled)
+	}
+}
+
+func TestResultIntegrity(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverAddress := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) {
+		c.ResultIntegrity = true
+		c.ResultIntegrityKey = key
+	}, signer)
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	callVia := func(serverURL, integritySigner string) error {
+		trans, err := x402.New(x402.Config{
+			ServerURL:             serverURL,
+			Signers:               []x402.PaymentSigner{signer},
+			VerifyResultIntegrity: true,
+			ResultIntegritySigner: integritySigner,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := client.NewClient(trans)
+		t.Cleanup(func() { _ = c.Close() })
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		_, err = c.CallTool(context.Background(), request)
+		return err
+	}
+
+	if err := callVia(h.url, serverAddress); err != nil {
+		t.Fatalf("Expected the signed result to verify, got %v", err)
+	}
+	if err := callVia(h.url, payTo); !errors.Is(err, x402.ErrResultIntegrity) {
+		t.Errorf("Expected ErrResultIntegrity for another signer, got %v", err)
+	}
+
+	// A proxy substituting the result after payment is detected
+	target, _ := url.Parse(h.url)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = bytes.ReplaceAll(body, []byte("paid on base-sepolia"), []byte("cached result"))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resp.ContentLength = int64(len(body))
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+	if err := callVia(proxyServer.URL, ""); !errors.Is(err, x402.ErrResultIntegrity) {
+		t.Errorf("Expected ErrResultIntegrity for a substituted result, got %v", err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	facilitator := x402server.NewSandboxFacilitator()
+	srv := x402server.NewX402Server("sandbox", "1.0.0", &x402server.Config{Facilitator: facilitator})
+	srv.AddPayableTool(mcp.NewTool("search"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("results"), nil
+	}, x402server.RequireUSDCSandbox(payTo, "10000", "Search"))
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+
+	signer, err := x402.NewSandboxSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	trans, err := x402.New(x402.Config{ServerURL: httpServer.URL, Signers: []x402.PaymentSigner{signer}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.NewClient(trans)
+	defer c.Close()
+	if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	result, err := c.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text := resultText(result); text != "results" {
+		t.Errorf("Expected the paid result, got %q", text)
+	}
+	settled := facilitator.Settled()
+	if len(settled) != 1 || !strings.EqualFold(settled[0].Payer, signer.GetAddress()) || len(settled[0].Transaction) != 66 {
+		t.Errorf("Expected one sandbox settlement by %s, got %+v", signer.GetAddress(), settled)
+	}
+}
+
+func TestToolCostHints(t *testing.T) {
+	h := newHarness(t, nil, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+
+	result, err := h.client.ListTools(context.Background(), mcp.ListToolsRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tool := range result.Tools {
+		hints, paid := x402.ToolCostHints(tool)
+		switch tool.Name {
+		case "echo":
+			if paid || len(hints) != 0 {
+				t.Errorf("Expected echo to be unmarked, got paid=%v %+v", paid, hints)
+			}
+		case "search":
+			if !paid || len(hints) != 2 {
+				t.Fatalf("Expected search to be marked paid with 2 hints, got paid=%v %+v", paid, hints)
+			}
+			if hints[0] != (x402.CostHint{Scheme: "exact", Network: "base-sepolia", Asset: x402.USDCAddressBaseSepolia, Amount: "1000"}) {
+				t.Errorf("Unexpected hint %+v", hints[0])
+			}
+		}
+	}
+}
+
+type refundRecorder struct {
+	mu         sync.Mutex
+	violations []x402.OutputSchemaViolation
+}
+
+func (r *refundRecorder) RequestRefund(ctx context.Context, violation x402.OutputSchemaViolation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.violations = append(r.violations, violation)
+	return nil
+}
+
+func TestOutputSchemaEnforcement(t *testing.T) {
+	facilitator := newMockFacilitator(t)
+	srv := x402server.NewX402Server("schema", "1.0.0", &x402server.Config{FacilitatorURL: facilitator.URL})
+	requirement := x402server.RequireUSDCBaseSepolia(payTo, "1000", "Forecast")
+	requirement.OutputSchema = map[string]any{
+		"type":     "object",
+		"required": []string{"temperature"},
+	}
+	srv.AddPayableTool(mcp.NewTool("forecast"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText(`{"summary": "sunny"}`), nil
+	}, requirement)
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	call := func(config x402.Config) error {
+		config.ServerURL = httpServer.URL
+		config.Signers = []x402.PaymentSigner{signer}
+		trans, err := x402.New(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := client.NewClient(trans)
+		t.Cleanup(func() { _ = c.Close() })
+		if _, err := c.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+			t.Fatal(err)
+		}
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "forecast"
+		_, err = c.CallTool(context.Background(), request)
+		return err
+	}
+
+	if err := call(x402.Config{}); err != nil {
+		t.Errorf("Expected results to go unchecked by default, got %v", err)
+	}
+
+	var warned []x402.OutputSchemaViolation
+	err := call(x402.Config{
+		OutputSchemaAction:      x402.OutputSchemaWarn,
+		OnOutputSchemaViolation: func(v x402.OutputSchemaViolation) { warned = append(warned, v) },
+	})
+	if err != nil {
+		t.Errorf("Expected a warning only, got %v", err)
+	}
+	if len(warned) != 1 || warned[0].Tool != "forecast" || warned[0].Errors[0] != `$: missing required property "temperature"` {
+		t.Errorf("Unexpected violations %+v", warned)
+	}
+
+	if err := call(x402.Config{OutputSchemaAction: x402.OutputSchemaFail}); !errors.Is(err, x402.ErrOutputSchemaViolation) {
+		t.Errorf("Expected ErrOutputSchemaViolation, got %v", err)
+	}
+
+	refunds := &refundRecorder{}
+	if err := call(x402.Config{OutputSchemaAction: x402.OutputSchemaRequestRefund, RefundRequester: refunds}); err != nil {
+		t.Errorf("Expected the result with a refund request, got %v", err)
+	}
+	if len(refunds.violations) != 1 || refunds.violations[0].Transaction == "" {
+		t.Errorf("Expected a refund request for the settled payment, got %+v", refunds.violations)
+	}
+}
+
+// countingTransport counts the HTTP requests a client sends
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func (c *countingTransport) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests
+}
+
+func TestRequirementsCache(t *testing.T) {
+	h := newHarness(t, nil, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+	counter := &countingTransport{}
+	_, h.client = h.connect(t, x402.Config{
+		Signers: []x402.PaymentSigner{
+			x402.NewMockSigner("0xBaseWallet", x402.AcceptUSDCBaseSepolia()).WithPriority(1),
+			x402.NewMockSigner("0xPolygonWallet", x402.AcceptUSDCPolygonAmoy()).WithPriority(2),
+		},
+		HTTPClient:           &http.Client{Transport: counter},
+		RequirementsCacheTTL: time.Minute,
+	})
+
+	calls := func(tool string) int {
+		before := counter.count()
+		h.call(t, tool)
+		return counter.count() - before
+	}
+	if n := calls("search"); n != 2 {
+		t.Errorf("Expected the first paid call to probe and retry, got %d requests", n)
+	}
+	if n := calls("search"); n != 1 {
+		t.Errorf("Expected a cached paid call to pay on its first request, got %d requests", n)
+	}
+	if n := calls("echo"); n != 1 {
+		t.Errorf("Expected a free call to take one request, got %d", n)
+	}
+
+	// Once the server stops accepting Base Sepolia, the cached requirements are stale
+	h.server.AddPayableTool(mcp.NewTool("search"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		info, _ := x402server.PaymentFromContext(ctx)
+		return mcp.NewToolResultText("paid on " + info.Requirement.Network), nil
+	}, x402server.RequireUSDCPolygonAmoy(payTo, "500", "Search"))
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	before := counter.count()
+	result, err := h.client.CallTool(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Expected stale requirements to fall back to the new ones: %v", err)
+	}
+	if got := resultText(result); got != "paid on polygon-amoy" {
+		t.Errorf("Unexpected result %q", got)
+	}
+	if n := counter.count() - before; n != 3 {
+		t.Errorf("Expected the rejected cached payment, a probe and a retry, got %d requests", n)
+	}
+	if n := calls("search"); n != 1 {
+		t.Errorf("Expected the new requirements to be cached, got %d requests", n)
+	}
+	if _, settled := h.facilitator.counts(); settled != 4 {
+		t.Errorf("Expected 4 settlements, got %d", settled)
+	}
+}
+
+func TestFreeTrial(t *testing.T) {
+	h := newHarness(t, func(config *x402server.Config) {
+		config.Trials = map[string]x402server.Trial{"lookup": {FreeCalls: 2}}
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+	h.server.AddPayableTool(mcp.NewTool("lookup"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if trial, ok := x402server.TrialFromContext(ctx); ok {
+			return mcp.NewToolResultText(fmt.Sprintf("trial, %d left", trial.CallsRemaining)), nil
+		}
+		return mcp.NewToolResultText("paid"), nil
+	}, x402server.RequireUSDCBaseSepolia(payTo, "1000", "Lookup"))
+
+	identity, err := x402.NewEVMIdentity("0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	lookup := func(mcpClient *client.Client) string {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = "lookup"
+		result, err := mcpClient.CallTool(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resultText(result)
+	}
+
+	_, first := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}, Identity: identity})
+	for _, want := range []string{"trial, 1 left", "trial, 0 left", "paid"} {
+		if got := lookup(first); got != want {
+			t.Errorf("Expected %q, got %q", want, got)
+		}
+	}
+
+	// A new session of the same payer doesn't restart the trial
+	_, second := h.connect(t, x402.Config{Signers: []x402.PaymentSigner{signer}, Identity: identity})
+	if got := lookup(second); got != "paid" {
+		t.Errorf("Expected a new session to pay, got %q", got)
+	}
+
+	// Anonymous callers get no trial
+	if got := resultText(h.call(t, "lookup")); got != "paid" {
+		t.Errorf("Expected an anonymous call to pay, got %q", got)
+	}
+	if _, settled := h.facilitator.counts(); settled != 3 {
+		t.Errorf("Expected only the calls after the trial to be paid, got %d settlements", settled)
+	}
+}
+
+func TestPaymentLogNotifications(t *testing.T) {
+	signer := x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())
+	h := newHarness(t, func(c *x402server.Config) { c.PaymentLogNotifications = true }, signer)
+	_, h.client = h.connect(t, x402.Config{
+		Signers:           []x402.PaymentSigner{signer},
+		LogPaymentsToHost: true,
+	})
+
+	var mu sync.Mutex
+	logs := map[string][]x402.PaymentLog{}
+	h.client.OnNotification(func(n mcp.JSONRPCNotification) {
+		if log, ok := x402.ParsePaymentLog(n); ok {
+			mu.Lock()
+			defer mu.Unlock()
+			logs[log.Source] = append(logs[log.Source], log)
+		}
+	})
+	if err := h.client.SetLevel(context.Background(), mcp.SetLevelRequest{Params: mcp.SetLevelParams{Level: mcp.LoggingLevelInfo}}); err != nil {
+		t.Fatal(err)
+	}
+
+	h.call(t, "search")
+	h.call(t, "search")
+
+	mu.Lock()
+	defer mu.Unlock()
+	key := "base-sepolia:" + strings.ToLower(x402.USDCAddressBaseSepolia)
+	for _, source := range []string{"server", "client"} {
+		if len(logs[source]) != 2 {
+			t.Fatalf("Expected 2 %s payment logs, got %v", source, logs[source])
+		}
+		last := logs[source][1]
+		if last.Event != "success" || last.Payments != 2 || last.Totals[key] != "2000" {
+			t.Errorf("Expected the %s log to aggregate 2 payments of 1000, got %+v", source, last)
+		}
+	}
+	if tx := logs["server"][0].Transaction; tx != "0xsettled" {
+		t.Errorf("Expected the settled transaction in the server log, got %q", tx)
+	}
+}
+
+func TestPrepaidDeposit(t *testing.T) {
+	var refunded x402server.DepositAccount
+	h := newHarness(t, func(config *x402server.Config) {
+		config.DepositRequirements = []x402server.PaymentRequirement{
+			x402server.RequireUSDCBaseSepolia(payTo, "2500", "Deposit"),
+		}
+		config.OnDepositWithdraw = func(ctx context.Context, account x402server.DepositAccount) (string, error) {
+			refunded = account
+			return "0xrefund", nil
+		}
+	}, x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia()))
+	ctx := context.Background()
+
+	deposit, err := h.transport.Deposit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deposit.Token == "" || len(deposit.Balances) != 1 || deposit.Balances[0].Amount != "2500" {
+		t.Fatalf("Expected a deposit of 2500, got %+v", deposit)
+	}
+
+	// Calls draw from the deposit until it can't cover the price
+	for _, want := range []string{"1500", "500"} {
+		result := h.call(t, "search")
+		balance, ok := result.Meta.AdditionalFields[x402.MetaKeyDepositBalance].(map[string]any)
+		if !ok {
+			t.Fatalf("Expected the deposit balance in _meta, got %v", result.Meta.AdditionalFields)
+		}
+		if amount := balance["balances"].([]any)[0].(map[string]any)["amount"]; amount != want {
+			t.Errorf("Expected %s left, got %v", want, amount)
+		}
+		if tx := settlement(result)["transaction"]; tx != x402server.DepositTransaction {
+			t.Errorf("Expected a deposit settlement, got %v", tx)
+		}
+	}
+	if tx := settlement(h.call(t, "search"))["transaction"]; tx != "0xsettled" {
+		t.Errorf("Expected the call to be paid once the deposit ran low, got %v", tx)
+	}
+	if _, settled := h.facilitator.counts(); settled != 2 {
+		t.Errorf("Expected the deposit and one call to settle, got %d settlements", settled)
+	}
+
+	balance, err := h.transport.DepositBalance(ctx)
+	if err != nil || balance.Balances[0].Amount != "500" {
+		t.Fatalf("Expected 500 left, got %+v, %v", balance, err)
+	}
+
+	withdrawn, err := h.transport.WithdrawDeposit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withdrawn.Transaction != "0xrefund" || withdrawn.Balances[0].Amount != "500" || refunded.Balances[0].Amount != "500" {
+		t.Errorf("Expected 500 refunded, got %+v", withdrawn)
+	}
+	if h.transport.DepositToken() != "" {
+		t.Error("Withdrawing should forget the deposit")
+	}
+	if _, err := h.transport.DepositBalance(ctx); !errors.Is(err, x402.ErrNoDeposit) {
+		t.Errorf("Expected ErrNoDeposit, got %v", err)
+	}
+}
+
+// registerPermit2 registers a "permit2" scheme whose payload carries the
+// binding nonce in a "permit" object rather than an EIP-3009 authorization
+func registerPermit2(t *testing.T) {
+	t.Helper()
+	if _, ok := x402.LookupScheme("permit2"); ok {
+		return
+	}
+	err := x402.RegisterScheme(x402.Scheme{
+		Name: "permit2",
+		Sign: func(ctx context.Context, signer x402.PaymentSigner, req x402.PaymentRequirement) (*x402.PaymentPayload, error) {
+			nonce, _ := x402.BindingNonceFromContext(ctx)
+			return &x402.PaymentPayload{
+				X402Version: 1,
+				Scheme:      req.Scheme,
+				Network:     req.Network,
+				Payload: map[string]any{
+					"signature": "0xpermit",
+					"permit": map[string]any{
+						"owner":  signer.GetAddress(),
+						"amount": req.MaxAmountRequired,
+						"nonce":  nonce,
+					},
+				},
+			}, nil
+		},
+		Nonce: func(payload map[string]any) (string, error) {
+			permit, _ := payload["permit"].(map[string]any)
+			nonce, _ := permit["nonce"].(string)
+			if nonce == "" {
+				return "", fmt.Errorf("missing permit nonce")
+			}
+			return nonce, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCustomScheme(t *testing.T) {
+	registerPermit2(t)
+
+	option := x402.AcceptUSDCBaseSepolia()
+	option.Scheme = "permit2"
+	h := newHarness(t, func(config *x402server.Config) {
+		config.RequireResourceBinding = true
+	}, x402.NewMockSigner("0xTestWallet", option))
+
+	requirement := x402server.RequireUSDCBaseSepolia(payTo, "1000", "Permit")
+	requirement.Scheme = "permit2"
+	h.server.AddPayableTool(mcp.NewTool("permit"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		paid, ok := x402server.RequirementFromContext(ctx)
+		if !ok {
+			return mcp.NewToolResultError("no payment in context"), nil
+		}
+		return mcp.NewToolResultText("paid with " + paid.Scheme), nil
+	}, requirement)
+
+	// The exact-only search tool is not payable by a permit2 option
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	if _, err := h.client.CallTool(context.Background(), request); err == nil {
+		t.Error("Expected the exact-only tool to be unpayable")
+	}
+
+	result := h.call(t, "permit")
+	if got := resultText(result); got != "paid with permit2" {
+		t.Errorf("Unexpected result %q", got)
+	}
+	if verified, _ := h.facilitator.counts(); verified != 1 {
+		t.Fatalf("Expected 1 verify, got %d", verified)
+	}
+	if scheme := h.facilitator.verified[0].Scheme; scheme != "permit2" {
+		t.Errorf("Expected the permit2 requirement to be verified, got %s", scheme)
+	}
+
+	if err := x402.RegisterScheme(x402.Scheme{Name: "permit2"}); !errors.Is(err, x402.ErrInvalidScheme) {
+		t.Errorf("Expected registering permit2 twice to fail, got %v", err)
+	}
+}
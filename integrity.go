@@ -0,0 +1,91 @@
+package x402
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// ResultIntegrityDigest computes the digest binding a paid result to its
+// settlement transaction and JSON-RPC request ID. The result's "_meta" field is
+// excluded, as the settlement carrying the digest is added to it.
+func ResultIntegrityDigest(transaction string, requestID any, result json.RawMessage) ([]byte, error) {
+	id, err := json.Marshal(requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request ID: %w", err)
+	}
+	canonical, err := canonicalParams(result)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256([]byte(transaction), []byte{'\n'}, id, []byte{'\n'}, crypto.Keccak256(canonical)), nil
+}
+
+// SignResultIntegrity signs a result integrity digest, returning the hex signature
+func SignResultIntegrity(digest []byte, key *ecdsa.PrivateKey) (string, error) {
+	signature, err := crypto.Sign(digest, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign result digest: %w", err)
+	}
+	return "0x" + hex.EncodeToString(signature), nil
+}
+
+// VerifyResultIntegrity checks that the settlement of a paid result carries the
+// integrity digest of its request ID and result. When signer is set, the digest
+// must also be signed by that address.
+func VerifyResultIntegrity(settlement SettlementResponse, requestID any, result json.RawMessage, signer string) error {
+	if settlement.Integrity == "" {
+		return fmt.Errorf("%w: settlement carries no integrity digest", ErrResultIntegrity)
+	}
+	digest, err := ResultIntegrityDigest(settlement.Transaction, requestID, result)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrResultIntegrity, err)
+	}
+	if !strings.EqualFold(settlement.Integrity, "0x"+hex.EncodeToString(digest)) {
+		return fmt.Errorf("%w: result does not match the paid request", ErrResultIntegrity)
+	}
+	if signer == "" {
+		return nil
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(settlement.IntegritySignature, "0x"))
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return fmt.Errorf("%w: missing or invalid integrity signature", ErrResultIntegrity)
+	}
+	pub, err := crypto.SigToPub(digest, signature)
+	if err != nil {
+		return fmt.Errorf("%w: invalid integrity signature: %v", ErrResultIntegrity, err)
+	}
+	if !strings.EqualFold(crypto.PubkeyToAddress(*pub).Hex(), signer) {
+		return fmt.Errorf("%w: result not signed by %s", ErrResultIntegrity, signer)
+	}
+	return nil
+}
+
+// verifyResultIntegrity checks the integrity of a paid JSON-RPC response's result
+func (t *X402Transport) verifyResultIntegrity(response *transport.JSONRPCResponse) error {
+	var result struct {
+		Meta map[string]any `json:"_meta"`
+	}
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return fmt.Errorf("%w: %v", ErrResultIntegrity, err)
+	}
+	field, ok := t.metaNamespace.LookupPaymentResponse(result.Meta)
+	if !ok {
+		return fmt.Errorf("%w: result carries no settlement", ErrResultIntegrity)
+	}
+	raw, err := json.Marshal(field)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrResultIntegrity, err)
+	}
+	var settlement SettlementResponse
+	if err := json.Unmarshal(raw, &settlement); err != nil {
+		return fmt.Errorf("%w: invalid settlement: %v", ErrResultIntegrity, err)
+	}
+	return VerifyResultIntegrity(settlement, response.ID, response.Result, t.resultIntegritySigner)
+}
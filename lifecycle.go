@@ -0,0 +1,67 @@
+package x402
+
+import "errors"
+
+// ErrTransportClosed is returned by Start, SendRequest and SendNotification
+// once the transport is closed
+var ErrTransportClosed = errors.New("x402 transport is closed")
+
+// TransportState is a stage of the transport lifecycle. Transports move from
+// TransportNotStarted to TransportStarted, and from either to TransportClosed;
+// they never move back.
+type TransportState int32
+
+const (
+	TransportNotStarted TransportState = iota // Created; handlers can be registered
+	TransportStarted                          // Started explicitly or by the first request
+	TransportClosed                           // Closed; every request fails with ErrTransportClosed
+)
+
+// String returns "not started", "started" or "closed"
+func (s TransportState) String() string {
+	switch s {
+	case TransportStarted:
+		return "started"
+	case TransportClosed:
+		return "closed"
+	default:
+		return "not started"
+	}
+}
+
+// State returns the lifecycle stage of the transport
+func (t *X402Transport) State() TransportState {
+	return TransportState(t.state.Load())
+}
+
+// ensureStarted starts the transport if it is not started yet. Requests sent
+// before Start start it, so any number of goroutines may race to send the
+// first request; exactly one of them moves the transport to TransportStarted.
+func (t *X402Transport) ensureStarted() error {
+	for {
+		switch state := t.State(); state {
+		case TransportStarted:
+			return nil
+		case TransportClosed:
+			return ErrTransportClosed
+		default:
+			if t.state.CompareAndSwap(int32(state), int32(TransportStarted)) {
+				return nil
+			}
+		}
+	}
+}
+
+// markClosed moves the transport to TransportClosed, reporting whether this
+// call closed it
+func (t *X402Transport) markClosed() bool {
+	for {
+		state := t.State()
+		if state == TransportClosed {
+			return false
+		}
+		if t.state.CompareAndSwap(int32(state), int32(TransportClosed)) {
+			return true
+		}
+	}
+}
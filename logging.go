@@ -0,0 +1,88 @@
+package x402
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+)
+
+// Logger receives log output of clients and servers. *log.Logger satisfies it;
+// structured loggers can be adapted with a Printf method.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// defaultLogger returns logger, or the standard logger when nil
+func defaultLogger(logger Logger) Logger {
+	if logger == nil {
+		return log.Default()
+	}
+	return logger
+}
+
+// RedactionLevel controls how much of the addresses, transaction hashes and
+// payment payloads identifying a payer appear in logs
+type RedactionLevel int
+
+const (
+	RedactNone    RedactionLevel = iota // Log values as is
+	RedactPartial                       // Keep the first and last 6 characters (0x1234…5678)
+	RedactFull                          // Replace values with "[redacted]"
+)
+
+// Redact applies the level to a payer-identifying value
+func (l RedactionLevel) Redact(value string) string {
+	switch {
+	case value == "" || l == RedactNone:
+		return value
+	case l == RedactPartial && len(value) > 12:
+		return value[:6] + "…" + value[len(value)-6:]
+	default:
+		return "[redacted]"
+	}
+}
+
+// LogSampler selects one in every N payments to log, so verbose logging stays
+// usable at production request rates. Failures should be logged regardless.
+type LogSampler struct {
+	every uint64
+	count atomic.Uint64
+}
+
+// NewLogSampler creates a sampler logging one in every payments (all of them
+// when every is 0 or 1)
+func NewLogSampler(every int) *LogSampler {
+	if every < 1 {
+		every = 1
+	}
+	return &LogSampler{every: uint64(every)}
+}
+
+// Sample reports whether the next payment is logged. The first is always logged.
+func (s *LogSampler) Sample() bool {
+	if s == nil || s.every == 1 {
+		return true
+	}
+	return (s.count.Add(1)-1)%s.every == 0
+}
+
+type logSampledKey struct{}
+
+// withLogSample decides whether the payment made within ctx is logged
+func (t *X402Transport) withLogSample(ctx context.Context) context.Context {
+	if !t.verbose {
+		return ctx
+	}
+	return context.WithValue(ctx, logSampledKey{}, t.logSampler.Sample())
+}
+
+// logSampled reports whether to log the payment made within ctx
+func (t *X402Transport) logSampled(ctx context.Context) bool {
+	if !t.verbose {
+		return false
+	}
+	if sampled, ok := ctx.Value(logSampledKey{}).(bool); ok {
+		return sampled
+	}
+	return t.logSampler.Sample()
+}
@@ -0,0 +1,127 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// PaymentRequiredError is returned by SendRequest in manual payment mode when the
+// server requires payment. It matches ErrPaymentRequired with errors.Is.
+type PaymentRequiredError struct {
+	RequestID    mcp.RequestId
+	Method       string
+	Requirements PaymentRequirementsResponse
+
+	// BindingNonce is set when payments are bound to the request or resource.
+	// Sign the payment with a context from WithBindingNonce so the server accepts it.
+	BindingNonce string
+}
+
+// Error returns the formatted error message
+func (e *PaymentRequiredError) Error() string {
+	return fmt.Sprintf("payment required for %s: %d payment options", e.Method, len(e.Requirements.Accepts))
+}
+
+// Is reports whether target is ErrPaymentRequired
+func (e *PaymentRequiredError) Is(target error) bool {
+	return target == ErrPaymentRequired
+}
+
+// defaultManualPaymentTTL is how long a request waits for its payment when
+// Config.ManualPaymentTTL is zero
+const defaultManualPaymentTTL = time.Hour
+
+// pendingPayment is a request waiting for an externally approved payment
+type pendingPayment struct {
+	request        transport.JSONRPCRequest
+	requirements   PaymentRequirementsResponse
+	bindingSalt    string
+	bindingNonce   string
+	useHTTPHeaders bool
+	deferredAt     time.Time
+}
+
+// deferPayment stores the request for RetryWithPayment and returns the error surfaced to the caller
+func (t *X402Transport) deferPayment(request transport.JSONRPCRequest, requirements PaymentRequirementsResponse, bindingSalt, bindingNonce string, useHTTPHeaders bool) error {
+	now := t.clock.Now()
+	t.pendingMu.Lock()
+	// Requests the caller abandoned are dropped as others are deferred
+	for id, pending := range t.pending {
+		if t.pendingExpired(pending, now) {
+			delete(t.pending, id)
+		}
+	}
+	t.pending[request.ID.String()] = pendingPayment{
+		request:        request,
+		requirements:   requirements,
+		bindingSalt:    bindingSalt,
+		bindingNonce:   bindingNonce,
+		useHTTPHeaders: useHTTPHeaders,
+		deferredAt:     now,
+	}
+	t.pendingMu.Unlock()
+
+	return &PaymentRequiredError{
+		RequestID:    request.ID,
+		Method:       request.Method,
+		Requirements: requirements,
+		BindingNonce: bindingNonce,
+	}
+}
+
+// CreatePayment signs a payment for the given requirements using the configured
+// signers and payment callback. In manual payment mode, use it after the user approves.
+func (t *X402Transport) CreatePayment(ctx context.Context, requirements PaymentRequirementsResponse) (*PaymentPayload, error) {
+	return t.handler.CreatePayment(ctx, requirements)
+}
+
+// RetryWithPayment completes a request that failed with PaymentRequiredError by
+// resending it with the given signed payment
+func (t *X402Transport) RetryWithPayment(ctx context.Context, requestID mcp.RequestId, payment *PaymentPayload) (*transport.JSONRPCResponse, error) {
+	if payment == nil {
+		return nil, fmt.Errorf("payment cannot be nil")
+	}
+	t.payments.begin()
+	defer t.payments.end()
+
+	pending, ok := t.pendingPayment(requestID, true)
+	if !ok {
+		return nil, fmt.Errorf("no pending payment for request %s", requestID.String())
+	}
+
+	ctx, cancel := t.contextAwareOfClientClose(ctx)
+	defer cancel()
+
+	return t.sendWithPayment(ctx, pending.request, pending.requirements, payment, pending.bindingSalt, pending.useHTTPHeaders)
+}
+
+// pendingPayment returns the unexpired request awaiting payment under id,
+// removing it when take is set
+func (t *X402Transport) pendingPayment(id mcp.RequestId, take bool) (pendingPayment, bool) {
+	t.pendingMu.Lock()
+	defer t.pendingMu.Unlock()
+	pending, ok := t.pending[id.String()]
+	if !ok {
+		return pendingPayment{}, false
+	}
+	if expired := t.pendingExpired(pending, t.clock.Now()); take || expired {
+		delete(t.pending, id.String())
+		if expired {
+			return pendingPayment{}, false
+		}
+	}
+	return pending, true
+}
+
+// pendingExpired reports whether a request waited for its payment past ManualPaymentTTL
+func (t *X402Transport) pendingExpired(pending pendingPayment, now time.Time) bool {
+	ttl := t.manualPaymentTTL
+	if ttl <= 0 {
+		ttl = defaultManualPaymentTTL
+	}
+	return now.Sub(pending.deferredAt) > ttl
+}
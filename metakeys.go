@@ -0,0 +1,65 @@
+package x402
+
+// Meta keys of the x402 MCP transport: clients send payments in
+// params._meta[MetaKeyPayment] and servers return settlements in
+// result._meta[MetaKeyPaymentResponse]
+const (
+	MetaKeyPayment         = "x402/payment"
+	MetaKeyPaymentResponse = "x402/payment-response"
+)
+
+// MetaNamespace names the _meta keys payments and settlement responses travel
+// under. Some gateways use other names than the x402 ones.
+type MetaNamespace struct {
+	Payment         string
+	PaymentResponse string
+}
+
+// Known meta namespaces
+var (
+	// MetaNamespaceX402 is the default x402/payment and x402/payment-response
+	MetaNamespaceX402 = MetaNamespace{Payment: MetaKeyPayment, PaymentResponse: MetaKeyPaymentResponse}
+	// MetaNamespacePlain is payment and payment-response
+	MetaNamespacePlain = MetaNamespace{Payment: "payment", PaymentResponse: "payment-response"}
+	// MetaNamespaceXPayment is x-payment and x-payment-response
+	MetaNamespaceXPayment = MetaNamespace{Payment: "x-payment", PaymentResponse: "x-payment-response"}
+)
+
+// KnownMetaNamespaces are the namespaces payments and settlement responses are
+// also looked up in when absent from the configured one
+var KnownMetaNamespaces = []MetaNamespace{MetaNamespaceX402, MetaNamespacePlain, MetaNamespaceXPayment}
+
+// OrDefault returns n, or MetaNamespaceX402 for its unset keys
+func (n MetaNamespace) OrDefault() MetaNamespace {
+	if n.Payment == "" {
+		n.Payment = MetaKeyPayment
+	}
+	if n.PaymentResponse == "" {
+		n.PaymentResponse = MetaKeyPaymentResponse
+	}
+	return n
+}
+
+// LookupPayment returns the payment in meta under n's payment key, falling back
+// to the keys of KnownMetaNamespaces
+func (n MetaNamespace) LookupPayment(meta map[string]any) (any, bool) {
+	return lookupMeta(meta, n.OrDefault().Payment, func(k MetaNamespace) string { return k.Payment })
+}
+
+// LookupPaymentResponse returns the settlement response in meta under n's
+// payment response key, falling back to the keys of KnownMetaNamespaces
+func (n MetaNamespace) LookupPaymentResponse(meta map[string]any) (any, bool) {
+	return lookupMeta(meta, n.OrDefault().PaymentResponse, func(k MetaNamespace) string { return k.PaymentResponse })
+}
+
+func lookupMeta(meta map[string]any, key string, known func(MetaNamespace) string) (any, bool) {
+	if value, ok := meta[key]; ok && value != nil {
+		return value, true
+	}
+	for _, namespace := range KnownMetaNamespaces {
+		if value, ok := meta[known(namespace)]; ok && value != nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
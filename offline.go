@@ -0,0 +1,115 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// offlineFormatVersion is the version of exported and signed payment requests
+const offlineFormatVersion = 1
+
+// OfflinePaymentRequest is a pending payment exported for signing on another
+// machine, e.g. an air-gapped one holding the keys
+type OfflinePaymentRequest struct {
+	Version   int           `json:"version"`
+	Server    string        `json:"server"`
+	RequestID mcp.RequestId `json:"requestId"`
+	Method    string        `json:"method"`
+	CreatedAt time.Time     `json:"createdAt"`
+
+	// Requirement is the payment option chosen for signing
+	Requirement PaymentRequirement `json:"requirement"`
+
+	// Requirements are all the options the server offered, for review
+	Requirements PaymentRequirementsResponse `json:"requirements"`
+
+	// BindingNonce, if set, must be the payment nonce (see BindPaymentToRequest)
+	BindingNonce string `json:"bindingNonce,omitempty"`
+}
+
+// OfflineSignedPayment is the signed answer to an OfflinePaymentRequest
+type OfflineSignedPayment struct {
+	Version   int             `json:"version"`
+	RequestID mcp.RequestId   `json:"requestId"`
+	Payment   *PaymentPayload `json:"payment"`
+}
+
+// ExportPaymentRequest exports the pending payment of a request that failed with
+// PaymentRequiredError in manual payment mode, choosing the payment option at index
+// option of its requirements. Sign the returned JSON with SignOfflinePaymentRequest
+// and complete the request with ImportSignedPayment.
+func (t *X402Transport) ExportPaymentRequest(requestID mcp.RequestId, option int) ([]byte, error) {
+	pending, ok := t.pendingPayment(requestID, false)
+	if !ok {
+		return nil, fmt.Errorf("no pending payment for request %s", requestID.String())
+	}
+
+	accepts := pending.requirements.Accepts
+	if option < 0 || option >= len(accepts) {
+		return nil, fmt.Errorf("payment option %d out of range (%d options)", option, len(accepts))
+	}
+
+	return json.MarshalIndent(OfflinePaymentRequest{
+		Version:      offlineFormatVersion,
+		Server:       t.serverURL.String(),
+		RequestID:    pending.request.ID,
+		Method:       pending.request.Method,
+		CreatedAt:    time.Now().UTC(),
+		Requirement:  accepts[option],
+		Requirements: pending.requirements,
+		BindingNonce: pending.bindingNonce,
+	}, "", "  ")
+}
+
+// SignOfflinePaymentRequest signs an exported payment request with signer and
+// returns the JSON to import with ImportSignedPayment. It needs no transport or
+// network access beyond what the signer itself uses.
+func SignOfflinePaymentRequest(ctx context.Context, signer PaymentSigner, exported []byte) ([]byte, error) {
+	var request OfflinePaymentRequest
+	if err := json.Unmarshal(exported, &request); err != nil {
+		return nil, fmt.Errorf("invalid payment request: %w", err)
+	}
+	if request.Version != offlineFormatVersion {
+		return nil, fmt.Errorf("unsupported payment request version %d", request.Version)
+	}
+
+	requirement := request.Requirement
+	if !signer.SupportsNetwork(requirement.Network) || !signer.HasAsset(requirement.Asset, requirement.Network) {
+		return nil, fmt.Errorf("%w: signer cannot pay %s on %s", ErrNoAcceptablePayment, requirement.Asset, requirement.Network)
+	}
+
+	if request.BindingNonce != "" {
+		ctx = WithBindingNonce(ctx, request.BindingNonce)
+	}
+	payment, err := signPayment(ctx, signer, requirement)
+	if err != nil {
+		return nil, fmt.Errorf("signing payment: %w", err)
+	}
+	if err := checkSignedAmount(payment, requirement); err != nil {
+		return nil, err
+	}
+
+	return json.MarshalIndent(OfflineSignedPayment{
+		Version:   offlineFormatVersion,
+		RequestID: request.RequestID,
+		Payment:   payment,
+	}, "", "  ")
+}
+
+// ImportSignedPayment completes the pending request an offline signed payment
+// was made for
+func (t *X402Transport) ImportSignedPayment(ctx context.Context, signed []byte) (*transport.JSONRPCResponse, error) {
+	var answer OfflineSignedPayment
+	if err := json.Unmarshal(signed, &answer); err != nil {
+		return nil, fmt.Errorf("invalid signed payment: %w", err)
+	}
+	if answer.Version != offlineFormatVersion {
+		return nil, fmt.Errorf("unsupported signed payment version %d", answer.Version)
+	}
+	return t.RetryWithPayment(ctx, answer.RequestID, answer.Payment)
+}
@@ -0,0 +1,38 @@
+package x402
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// MetaKeyOnBehalfOf is the _meta key naming the end user a payment is made for
+const MetaKeyOnBehalfOf = "x402/on-behalf-of"
+
+// MaxOnBehalfOfLength is the longest on-behalf-of identity servers accept
+const MaxOnBehalfOfLength = 256
+
+type onBehalfOfKey struct{}
+
+// WithOnBehalfOf marks requests sent with ctx as made for an end user (a user
+// ID, email or DID), for organizations paying from one wallet for many users.
+// The transport sends it in the request's _meta, payment events carry it in
+// OnBehalfOf, and servers record it as the beneficiary next to the payer.
+func WithOnBehalfOf(ctx context.Context, beneficiary string) context.Context {
+	return context.WithValue(ctx, onBehalfOfKey{}, beneficiary)
+}
+
+// OnBehalfOfFromContext returns the end user set by WithOnBehalfOf
+func OnBehalfOfFromContext(ctx context.Context) string {
+	beneficiary, _ := ctx.Value(onBehalfOfKey{}).(string)
+	return beneficiary
+}
+
+// attachOnBehalfOf adds the end user set by WithOnBehalfOf to request params._meta
+func attachOnBehalfOf(ctx context.Context, request transport.JSONRPCRequest) (transport.JSONRPCRequest, error) {
+	beneficiary := OnBehalfOfFromContext(ctx)
+	if beneficiary == "" {
+		return request, nil
+	}
+	return setRequestMeta(request, map[string]any{MetaKeyOnBehalfOf: beneficiary})
+}
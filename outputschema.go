@@ -0,0 +1,306 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// OutputSchemaAction is what the transport does when a paid tool result does
+// not match the OutputSchema of the requirement it paid
+type OutputSchemaAction int
+
+const (
+	OutputSchemaIgnore        OutputSchemaAction = iota // Don't validate paid results
+	OutputSchemaWarn                                    // Log and report violations to OnOutputSchemaViolation
+	OutputSchemaFail                                    // Also fail the call with ErrOutputSchemaViolation
+	OutputSchemaRequestRefund                           // Also ask the RefundRequester to claim the payment back
+)
+
+// OutputSchemaViolation is a paid tool result that does not match its advertised outputSchema
+type OutputSchemaViolation struct {
+	Timestamp   int64              `json:"timestamp"`
+	Server      string             `json:"server"`
+	Tool        string             `json:"tool"`
+	Requirement PaymentRequirement `json:"requirement"`
+	Transaction string             `json:"transaction,omitempty"` // Settlement of the payment
+	Errors      []string           `json:"errors"`
+}
+
+// RefundRequester files refund requests for paid results violating their
+// outputSchema, e.g. with the server operator's support API. x402 has no
+// on-chain refunds; the violation is the evidence.
+type RefundRequester interface {
+	RequestRefund(ctx context.Context, violation OutputSchemaViolation) error
+}
+
+// ValidateOutputSchema validates a value decoded from JSON against a JSON
+// Schema, returning the violations. It supports the keywords servers use to
+// describe results: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum and maximum. Other keywords are ignored.
+func ValidateOutputSchema(schema any, value any) []string {
+	var errs []string
+	validateSchema(schema, value, "$", &errs)
+	return errs
+}
+
+func validateSchema(schema any, value any, path string, errs *[]string) {
+	s, ok := schema.(map[string]any)
+	if !ok {
+		return
+	}
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := s["type"]; ok && !matchesType(t, value) {
+		fail("expected %v, got %s", t, jsonType(value))
+		return
+	}
+	if enum, ok := s["enum"].([]any); ok && !containsJSON(enum, value) {
+		fail("value not in enum")
+	}
+	if c, ok := s["const"]; ok && !equalJSON(c, value) {
+		fail("value does not match const")
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, _ := s["properties"].(map[string]any)
+		if required, ok := s["required"].([]any); ok {
+			for _, name := range required {
+				if key, _ := name.(string); key != "" {
+					if _, present := v[key]; !present {
+						fail("missing required property %q", key)
+					}
+				}
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if propertySchema, ok := properties[key]; ok {
+				validateSchema(propertySchema, v[key], path+"."+key, errs)
+				continue
+			}
+			switch additional := s["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					fail("unexpected property %q", key)
+				}
+			case map[string]any:
+				validateSchema(additional, v[key], path+"."+key, errs)
+			}
+		}
+	case []any:
+		if n, ok := number(s["minItems"]); ok && float64(len(v)) < n {
+			fail("expected at least %v items, got %d", n, len(v))
+		}
+		if n, ok := number(s["maxItems"]); ok && float64(len(v)) > n {
+			fail("expected at most %v items, got %d", n, len(v))
+		}
+		if items, ok := s["items"]; ok {
+			for i, item := range v {
+				validateSchema(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if n, ok := number(s["minLength"]); ok && length < n {
+			fail("expected at least %v characters", n)
+		}
+		if n, ok := number(s["maxLength"]); ok && length > n {
+			fail("expected at most %v characters", n)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(v) {
+				fail("does not match pattern %q", pattern)
+			}
+		}
+	case float64:
+		if n, ok := number(s["minimum"]); ok && v < n {
+			fail("expected at least %v, got %v", n, v)
+		}
+		if n, ok := number(s["maximum"]); ok && v > n {
+			fail("expected at most %v, got %v", n, v)
+		}
+	}
+}
+
+// matchesType reports whether value has the JSON Schema type t (a name or a list of names)
+func matchesType(t any, value any) bool {
+	switch t := t.(type) {
+	case string:
+		actual := jsonType(value)
+		return actual == t || (t == "number" && actual == "integer")
+	case []any:
+		for _, name := range t {
+			if matchesType(name, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// jsonType returns the JSON Schema type name of a decoded JSON value
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func number(v any) (float64, bool) {
+	n, ok := v.(float64)
+	return n, ok
+}
+
+func containsJSON(values []any, value any) bool {
+	for _, v := range values {
+		if equalJSON(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func equalJSON(a, b any) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+// checkOutputSchema validates a paid tool result against the outputSchema of the
+// requirement paid, acting on violations according to OutputSchemaAction
+func (t *X402Transport) checkOutputSchema(ctx context.Context, request transport.JSONRPCRequest, response *transport.JSONRPCResponse, requirements PaymentRequirementsResponse, payment *PaymentPayload) error {
+	if t.outputSchemaAction == OutputSchemaIgnore || request.Method != string(mcp.MethodToolsCall) || payment == nil {
+		return nil
+	}
+	var paid *PaymentRequirement
+	for i, req := range requirements.Accepts {
+		if req.Network == payment.Network && req.Scheme == payment.Scheme {
+			paid = &requirements.Accepts[i]
+			break
+		}
+	}
+	if paid == nil || paid.OutputSchema == nil {
+		return nil
+	}
+
+	// Schemas are compared with results as decoded from JSON
+	schemaJSON, err := json.Marshal(paid.OutputSchema)
+	if err != nil {
+		return nil
+	}
+	var schema any
+	if err := json.Unmarshal(schemaJSON, &schema); err != nil {
+		return nil
+	}
+
+	var result struct {
+		Content           []map[string]any `json:"content"`
+		StructuredContent any              `json:"structuredContent"`
+		Meta              map[string]any   `json:"_meta"`
+	}
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return nil
+	}
+	var errs []string
+	if value, ok := paidContent(result.StructuredContent, result.Content); ok {
+		errs = ValidateOutputSchema(schema, value)
+	} else {
+		errs = []string{"$: result carries no structured or JSON content"}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	violation := OutputSchemaViolation{
+		Timestamp:   t.clock.Now().Unix(),
+		Server:      t.serverURL.String(),
+		Tool:        toolName(request),
+		Requirement: *paid,
+		Errors:      errs,
+	}
+	if field, ok := t.metaNamespace.LookupPaymentResponse(result.Meta); ok {
+		if settlement, ok := field.(map[string]any); ok {
+			violation.Transaction, _ = settlement["transaction"].(string)
+		}
+	}
+
+	t.logger.Printf("[X402] Paid result of %s does not match its output schema: %s",
+		violation.Tool, strings.Join(errs, "; "))
+	if t.onOutputSchemaViolation != nil {
+		t.onOutputSchemaViolation(violation)
+	}
+
+	switch t.outputSchemaAction {
+	case OutputSchemaFail:
+		return fmt.Errorf("%w: %s", ErrOutputSchemaViolation, strings.Join(errs, "; "))
+	case OutputSchemaRequestRefund:
+		if t.refundRequester == nil {
+			t.logger.Printf("[X402] No RefundRequester configured, not requesting a refund for %s", violation.Tool)
+		} else if err := t.refundRequester.RequestRefund(ctx, violation); err != nil {
+			t.logger.Printf("[X402] Refund request for %s failed: %v", violation.Tool, err)
+		}
+	}
+	return nil
+}
+
+// paidContent returns the content to validate: the structured content, else the
+// first text content holding JSON
+func paidContent(structured any, content []map[string]any) (any, bool) {
+	if structured != nil {
+		return structured, true
+	}
+	for _, c := range content {
+		text, _ := c["text"].(string)
+		if c["type"] != "text" || text == "" {
+			continue
+		}
+		var value any
+		if json.Unmarshal([]byte(text), &value) == nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// toolName returns the tool a tools/call request calls
+func toolName(request transport.JSONRPCRequest) string {
+	raw, _ := json.Marshal(request.Params)
+	var params struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(raw, &params)
+	return params.Name
+}
@@ -0,0 +1,140 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// PriceChangeAction is what the transport does when a tool's live 402 asks more
+// than the price the server advertised for it in tools/list
+type PriceChangeAction int
+
+const (
+	PriceChangeIgnore PriceChangeAction = iota // Pay the live price
+	PriceChangeWarn                            // Log the increase and pay
+	PriceChangeRefuse                          // Refuse options priced above the catalog with ErrCatalogPriceChanged
+)
+
+type maxPaymentKey struct{}
+
+// WithMaxPayment caps the payment made for requests sent within ctx, in base
+// units of the asset paid, on top of each option's MaxAmount
+func WithMaxPayment(ctx context.Context, amount *big.Int) context.Context {
+	return context.WithValue(ctx, maxPaymentKey{}, new(big.Int).Set(amount))
+}
+
+// MaxPaymentFromContext returns the cap set with WithMaxPayment
+func MaxPaymentFromContext(ctx context.Context) (*big.Int, bool) {
+	amount, ok := ctx.Value(maxPaymentKey{}).(*big.Int)
+	return amount, ok
+}
+
+// checkSignedAmount checks that a signer authorized exactly the amount required.
+// Only EVM authorizations carry a readable value; other payloads are trusted.
+func checkSignedAmount(payload *PaymentPayload, req PaymentRequirement) error {
+	data, ok := payload.Payload.(PaymentPayloadData)
+	if !ok {
+		return nil
+	}
+	signed, signedOK := new(big.Int).SetString(data.Authorization.Value, 10)
+	required, requiredOK := new(big.Int).SetString(req.MaxAmountRequired, 10)
+	if !signedOK || !requiredOK || signed.Cmp(required) != 0 {
+		return fmt.Errorf("%w: signed value %s differs from the required %s", ErrSigningFailed, data.Authorization.Value, req.MaxAmountRequired)
+	}
+	return nil
+}
+
+// sign signs selected with signer and checks the amount authorized
+func (h *PaymentHandler) sign(ctx context.Context, signer PaymentSigner, selected PaymentRequirement) (*PaymentPayload, error) {
+	payload, err := signPayment(ctx, signer, selected)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSignedAmount(payload, selected); err != nil {
+		return nil, err
+	}
+	recordSelection(ctx, signer, selected)
+	return payload, nil
+}
+
+// catalogPrices remembers the cost hints of the tools the server listed
+type catalogPrices struct {
+	mu    sync.Mutex
+	tools map[string][]CostHint
+}
+
+func newCatalogPrices() *catalogPrices {
+	return &catalogPrices{tools: make(map[string][]CostHint)}
+}
+
+// record remembers the cost hints of the tools in a tools/list result
+func (c *catalogPrices) record(result json.RawMessage) {
+	var list mcp.ListToolsResult
+	if err := json.Unmarshal(result, &list); err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tool := range list.Tools {
+		if hints, _ := ToolCostHints(tool); len(hints) > 0 {
+			c.tools[tool.Name] = hints
+		} else {
+			delete(c.tools, tool.Name)
+		}
+	}
+}
+
+// price returns the catalog amount of a tool's option, if it was advertised
+func (c *catalogPrices) price(tool string, req PaymentRequirement) (*big.Int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, hint := range c.tools[tool] {
+		if hint.Scheme != req.Scheme || hint.Network != req.Network || !strings.EqualFold(hint.Asset, req.Asset) {
+			continue
+		}
+		amount, ok := new(big.Int).SetString(hint.Amount, 10)
+		return amount, ok
+	}
+	return nil, false
+}
+
+// checkCatalogPrices compares the requirements of a tool call with the prices
+// the catalog advertised, acting on increases according to CatalogPriceChange.
+// Options without a catalog price are left alone.
+func (t *X402Transport) checkCatalogPrices(request transport.JSONRPCRequest, accepts []PaymentRequirement) ([]PaymentRequirement, error) {
+	tool := cacheableTool(request)
+	if t.catalogPriceChange == PriceChangeIgnore || tool == "" {
+		return accepts, nil
+	}
+
+	var kept []PaymentRequirement
+	var changes []string
+	for _, req := range accepts {
+		catalog, ok := t.catalogPrices.price(tool, req)
+		amount, amountOK := new(big.Int).SetString(req.MaxAmountRequired, 10)
+		if !ok || !amountOK || amount.Cmp(catalog) <= 0 {
+			kept = append(kept, req)
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s on %s from %s to %s", req.Asset, req.Network, catalog, amount))
+		if t.catalogPriceChange != PriceChangeRefuse {
+			kept = append(kept, req)
+		}
+	}
+	if len(changes) == 0 {
+		return accepts, nil
+	}
+
+	t.logger.Printf("[X402] Price of %s rose since tools/list: %s", tool, strings.Join(changes, ", "))
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrCatalogPriceChanged, tool, strings.Join(changes, ", "))
+	}
+	return kept, nil
+}
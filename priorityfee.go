@@ -0,0 +1,176 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// DefaultComputeUnitPrice is the priority fee Solana payments carry, in
+// micro-lamports per compute unit
+const DefaultComputeUnitPrice uint64 = 10_000
+
+// Defaults of PriorityFeeEscalation
+const (
+	defaultPriorityFeeRetries    = 2
+	defaultPriorityFeeMultiplier = 2
+	defaultMaxComputeUnitPrice   = 1_000_000
+)
+
+// settlementRetryWindowFraction is the share of the server's retry window a
+// retry may start in, leaving the rest for its settlement
+const settlementRetryWindowFraction = 0.8
+
+// PriorityFeeEscalation re-signs Solana payments the server failed to settle,
+// e.g. because the network was congested, with a fresh blockhash and a higher
+// priority fee
+type PriorityFeeEscalation struct {
+	// MaxRetries bounds the re-signed payments per request (default 2)
+	MaxRetries int
+
+	// Multiplier scales the compute unit price on each retry (default 2)
+	Multiplier float64
+
+	// MaxComputeUnitPrice caps the escalated price, in micro-lamports per
+	// compute unit (default 1,000,000)
+	MaxComputeUnitPrice uint64
+}
+
+func (e *PriorityFeeEscalation) maxRetries() int {
+	if e.MaxRetries <= 0 {
+		return defaultPriorityFeeRetries
+	}
+	return e.MaxRetries
+}
+
+// escalate returns the compute unit price following price, capped at MaxComputeUnitPrice
+func (e *PriorityFeeEscalation) escalate(price uint64) uint64 {
+	multiplier := e.Multiplier
+	if multiplier <= 1 {
+		multiplier = defaultPriorityFeeMultiplier
+	}
+	limit := e.MaxComputeUnitPrice
+	if limit == 0 {
+		limit = defaultMaxComputeUnitPrice
+	}
+	next := uint64(float64(price) * multiplier)
+	if next > limit {
+		next = limit
+	}
+	if next < price {
+		return price
+	}
+	return next
+}
+
+type computeUnitPriceKey struct{}
+
+// WithComputeUnitPrice sets the priority fee Solana signers put on payments
+// signed within ctx, in micro-lamports per compute unit
+func WithComputeUnitPrice(ctx context.Context, microLamports uint64) context.Context {
+	return context.WithValue(ctx, computeUnitPriceKey{}, microLamports)
+}
+
+// ComputeUnitPriceFromContext returns the priority fee set with
+// WithComputeUnitPrice, or DefaultComputeUnitPrice
+func ComputeUnitPriceFromContext(ctx context.Context) uint64 {
+	if price, ok := ctx.Value(computeUnitPriceKey{}).(uint64); ok && price > 0 {
+		return price
+	}
+	return DefaultComputeUnitPrice
+}
+
+// settlementFailure is the error data servers attach when a payment failed to settle
+type settlementFailure struct {
+	SettlementFailed   bool   `json:"settlementFailed"`
+	Network            string `json:"network"`
+	Reason             string `json:"reason"`
+	RetryWithinSeconds int    `json:"retryWithinSeconds"`
+}
+
+// parseSettlementFailure reports whether a JSON-RPC error is a failed settlement
+func parseSettlementFailure(rpcError *mcp.JSONRPCErrorDetails) (settlementFailure, bool) {
+	var failure settlementFailure
+	if rpcError == nil || rpcError.Data == nil {
+		return failure, false
+	}
+	raw, err := json.Marshal(rpcError.Data)
+	if err != nil || json.Unmarshal(raw, &failure) != nil {
+		return failure, false
+	}
+	return failure, failure.SettlementFailed
+}
+
+func isSolanaNetwork(network string) bool {
+	return strings.HasPrefix(network, "solana")
+}
+
+// sendEscalatingPriorityFee sends a request with its payment and, when a Solana
+// payment fails to settle, re-signs it with an escalated priority fee while the
+// server's retry window lasts and the payment guard admits another payment.
+// ctx must count the request's payments (see withPaymentCount).
+func (t *X402Transport) sendEscalatingPriorityFee(ctx context.Context, originalRequest transport.JSONRPCRequest, requirements PaymentRequirementsResponse, payment *PaymentPayload, bindingSalt string, useHTTPHeaders bool) (*transport.JSONRPCResponse, error) {
+	start := time.Now()
+	price := ComputeUnitPriceFromContext(ctx)
+	for retry := 1; ; retry++ {
+		resp, err := t.sendWithPayment(ctx, originalRequest, requirements, payment, bindingSalt, useHTTPHeaders)
+		settleBudgetReservation(ctx, paymentTaken(resp, err))
+		if err != nil || t.priorityFeeEscalation == nil || retry > t.priorityFeeEscalation.maxRetries() || !isSolanaNetwork(payment.Network) {
+			return resp, err
+		}
+		failure, ok := parseSettlementFailure(resp.Error)
+		if !ok {
+			return resp, err
+		}
+
+		// Leave the server time to settle the retry within its window
+		if failure.RetryWithinSeconds > 0 {
+			window := time.Duration(float64(failure.RetryWithinSeconds) * settlementRetryWindowFraction * float64(time.Second))
+			if time.Since(start)+blockhashRetryDelay >= window {
+				return resp, err
+			}
+		}
+		next := t.priorityFeeEscalation.escalate(price)
+		if next == price {
+			return resp, err
+		}
+		price = next
+
+		// Re-signed payments count against the request's payment limit and
+		// the resource's backoff, as a server may claim a failed settlement
+		// after taking the payment. Stopping returns the failed settlement.
+		if err := t.guard.admit(ctx, paidResource(requirements)); err != nil {
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+			t.recordDecline(ctx, originalRequest.Method, requirements, err)
+			return resp, nil
+		}
+
+		t.logger.Printf("[X402] Settlement on %s failed (%s), retrying with priority fee %d micro-lamports (retry %d)",
+			payment.Network, failure.Reason, price, retry)
+
+		// Wait a slot for a fresh blockhash
+		select {
+		case <-time.After(blockhashRetryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		paid := requirements
+		paid.Accepts = nil
+		for _, req := range requirements.Accepts {
+			if req.Network == payment.Network && req.Scheme == payment.Scheme {
+				paid.Accepts = append(paid.Accepts, req)
+			}
+		}
+		payment, err = t.handler.CreatePayment(WithComputeUnitPrice(ctx, price), paid)
+		if err != nil {
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+			return nil, fmt.Errorf("failed to re-sign payment: %w", err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package x402
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MethodQuote is the JSON-RPC method asking a server to quote the price of a tool
+const MethodQuote = "x402/quote"
+
+// MetaKeyQuote is the _meta key carrying a signed quote in a tool call, locking
+// the price of the call to the quoted requirements
+const MetaKeyQuote = "x402/quote"
+
+// Quote is a server's signed price for a tool, valid until it expires. Its token
+// is the base64url encoded JSON quote and the server's base64url encoded MAC,
+// joined by a dot.
+type Quote struct {
+	ID      string               `json:"id"`
+	Tool    string               `json:"tool"`
+	Accepts []PaymentRequirement `json:"accepts"`
+	Expires int64                `json:"exp"`
+
+	// Token is the signed quote to attach to tool calls (see WithQuote)
+	Token string `json:"-"`
+}
+
+// ExpiresAt returns the expiry as a time
+func (q *Quote) ExpiresAt() time.Time {
+	return time.Unix(q.Expires, 0)
+}
+
+// ParseQuote reads a quote token without verifying its MAC, which only the
+// issuing server can do
+func ParseQuote(token string) (*Quote, error) {
+	payload, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed quote")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed quote: %w", err)
+	}
+	var quote Quote
+	if err := json.Unmarshal(raw, &quote); err != nil {
+		return nil, fmt.Errorf("malformed quote: %w", err)
+	}
+	quote.Token = token
+	return &quote, nil
+}
+
+// RequestQuote asks the server for a signed quote of a tool's price. Attach the
+// quote to calls of the tool with WithQuote before it expires.
+func (t *X402Transport) RequestQuote(ctx context.Context, tool string) (*Quote, error) {
+	resp, err := t.SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      mcp.NewRequestId(fmt.Sprintf("x402-quote-%d", time.Now().UnixNano())),
+		Method:  MethodQuote,
+		Params:  map[string]any{"name": tool},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("quote request failed: %s", resp.Error.Message)
+	}
+
+	var result struct {
+		Quote string `json:"quote"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("invalid quote response: %w", err)
+	}
+	return ParseQuote(result.Quote)
+}
+
+// WithQuote returns a copy of call carrying quote, so the server charges the
+// quoted price and the transport refuses to pay more
+func WithQuote(call mcp.CallToolRequest, quote *Quote) mcp.CallToolRequest {
+	return withToolCallMeta(call, MetaKeyQuote, quote.Token)
+}
+
+// requestQuote returns the quote attached to a request, if any
+func requestQuote(request transport.JSONRPCRequest) *Quote {
+	raw, err := json.Marshal(request.Params)
+	if err != nil {
+		return nil
+	}
+	var params struct {
+		Meta map[string]any `json:"_meta"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil
+	}
+	token, _ := params.Meta[MetaKeyQuote].(string)
+	if token == "" {
+		return nil
+	}
+	quote, err := ParseQuote(token)
+	if err != nil {
+		return nil
+	}
+	return quote
+}
+
+// capAccepts filters requirements down to those costing no more than the quoted
+// option for the same scheme, network, asset and recipient
+func (q *Quote) capAccepts(accepts []PaymentRequirement) ([]PaymentRequirement, error) {
+	var capped []PaymentRequirement
+	for _, req := range accepts {
+		for _, quoted := range q.Accepts {
+			if quoted.Scheme != req.Scheme || quoted.Network != req.Network ||
+				!strings.EqualFold(quoted.Asset, req.Asset) || !strings.EqualFold(quoted.PayTo, req.PayTo) {
+				continue
+			}
+			amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10)
+			limit, limitOK := new(big.Int).SetString(quoted.MaxAmountRequired, 10)
+			if ok && limitOK && amount.Cmp(limit) <= 0 {
+				capped = append(capped, req)
+			}
+			break
+		}
+	}
+
+	if len(capped) == 0 && len(accepts) > 0 {
+		return nil, fmt.Errorf("%w: quote %s", ErrQuoteExceeded, q.ID)
+	}
+	return capped, nil
+}
@@ -0,0 +1,27 @@
+package x402
+
+import "context"
+
+// MetaKeyReference is the _meta key carrying a client-supplied payment reference
+const MetaKeyReference = "x402/reference"
+
+// HeaderPaymentReference carries the payment reference for HTTP 402 transports
+const HeaderPaymentReference = "X-PAYMENT-REFERENCE"
+
+// MaxReferenceLength is the longest payment reference servers accept
+const MaxReferenceLength = 256
+
+type referenceKey struct{}
+
+// WithPaymentReference attaches an application reference (order ID, run ID) to
+// payments made with ctx. The transport sends it with the payment, Solana signers
+// add it as a memo instruction, and servers echo it in the settlement response.
+func WithPaymentReference(ctx context.Context, reference string) context.Context {
+	return context.WithValue(ctx, referenceKey{}, reference)
+}
+
+// PaymentReferenceFromContext returns the payment reference set by WithPaymentReference
+func PaymentReferenceFromContext(ctx context.Context) string {
+	reference, _ := ctx.Value(referenceKey{}).(string)
+	return reference
+}
@@ -0,0 +1,149 @@
+package x402
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// requirementsCache remembers the payment requirements last quoted for each
+// tool, so calls within the TTL pay on the first request instead of probing
+// for the 402
+type requirementsCache struct {
+	ttl   time.Duration
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[string]cachedRequirements
+}
+
+// cachedRequirements are a tool's requirements and the flow they were asked in
+type cachedRequirements struct {
+	requirements   PaymentRequirementsResponse
+	useHTTPHeaders bool
+	expires        time.Time
+}
+
+// newRequirementsCache returns nil when ttl is not positive
+func newRequirementsCache(ttl time.Duration, clock Clock) *requirementsCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &requirementsCache{ttl: ttl, clock: clock, entries: make(map[string]cachedRequirements)}
+}
+
+// get returns the unexpired requirements of tool
+func (c *requirementsCache) get(tool string) (cachedRequirements, bool) {
+	if c == nil || tool == "" {
+		return cachedRequirements{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[tool]
+	if !ok {
+		return cachedRequirements{}, false
+	}
+	if c.clock.Now().After(entry.expires) {
+		delete(c.entries, tool)
+		return cachedRequirements{}, false
+	}
+	return entry, true
+}
+
+// put remembers the requirements a 402 for tool asked for
+func (c *requirementsCache) put(tool string, requirements PaymentRequirementsResponse, useHTTPHeaders bool) {
+	if c == nil || tool == "" || isAccessFee(requirements) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[tool] = cachedRequirements{
+		requirements:   requirements,
+		useHTTPHeaders: useHTTPHeaders,
+		expires:        c.clock.Now().Add(c.ttl),
+	}
+}
+
+// invalidate forgets the requirements of tool
+func (c *requirementsCache) invalidate(tool string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, tool)
+}
+
+// cacheableTool returns the tool a request calls when its requirements are
+// cached by tool name, or "" for other requests
+func cacheableTool(request transport.JSONRPCRequest) string {
+	if request.Method != string(mcp.MethodToolsCall) {
+		return ""
+	}
+	return toolName(request)
+}
+
+type proactivePaymentKey struct{}
+
+// withProactivePayment marks ctx as paying cached requirements without a probe
+func withProactivePayment(ctx context.Context) context.Context {
+	return context.WithValue(ctx, proactivePaymentKey{}, true)
+}
+
+func isProactivePayment(ctx context.Context) bool {
+	proactive, _ := ctx.Value(proactivePaymentKey{}).(bool)
+	return proactive
+}
+
+// staleRequirementsError is returned when the server rejects a proactive
+// payment: the cached requirements no longer match its prices. rpcError is the
+// server's new 402, if it answered with one.
+type staleRequirementsError struct {
+	rpcError       *mcp.JSONRPCErrorDetails
+	useHTTPHeaders bool
+}
+
+func (e *staleRequirementsError) Error() string {
+	return "cached payment requirements are stale"
+}
+
+// sendWithCachedRequirements pays a request with its tool's cached requirements
+// on the first request. ok is false when nothing is cached for it, a free
+// trial covers the call or a deposit may pay it, and the request is sent the usual way. When the server rejects the payment, the
+// cache entry is dropped and its new 402 is paid; lacking one, ok is false
+// and the request is probed as usual.
+func (t *X402Transport) sendWithCachedRequirements(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, bool, error) {
+	tool := cacheableTool(request)
+	cached, ok := t.requirementsCache.get(tool)
+	if !ok || t.manualPayments || t.trials.covers(tool) || t.deposit.get() != "" {
+		return nil, false, nil
+	}
+
+	paymentCtx, cancel := t.paymentContext(ctx)
+	defer cancel()
+
+	resp, err := t.payRequirements(withProactivePayment(paymentCtx), cached.requirements, request, cached.useHTTPHeaders)
+	stale, isStale := err.(*staleRequirementsError)
+	if !isStale {
+		if err != nil {
+			return nil, true, paymentOverheadError(ctx, paymentCtx, err)
+		}
+		return resp, true, nil
+	}
+
+	t.requirementsCache.invalidate(tool)
+	if t.verbose {
+		t.logger.Printf("[X402] Cached requirements of %s are stale", tool)
+	}
+	if stale.rpcError == nil {
+		return nil, false, nil
+	}
+	resp, err = t.handlePaymentRequired(paymentCtx, stale.rpcError, request, t.useHeaderFlow(stale.useHTTPHeaders))
+	if err != nil {
+		return nil, true, paymentOverheadError(ctx, paymentCtx, err)
+	}
+	return resp, true, nil
+}
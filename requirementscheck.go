@@ -0,0 +1,118 @@
+package x402
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// RequirementsIssue is a problem with a payment option of a 402 response
+type RequirementsIssue struct {
+	Field   string // e.g. "accepts[0].payTo"
+	Message string
+}
+
+// String returns the issue as "Field: message"
+func (i RequirementsIssue) String() string {
+	return i.Field + ": " + i.Message
+}
+
+// RequirementsValidationError is returned when no payment option of a 402
+// response is valid. It matches ErrInvalidPaymentReqs with errors.Is.
+type RequirementsValidationError struct {
+	Issues []RequirementsIssue
+}
+
+// Error lists the issues
+func (e *RequirementsValidationError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.String()
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidPaymentReqs, strings.Join(messages, "; "))
+}
+
+// Unwrap returns ErrInvalidPaymentReqs
+func (e *RequirementsValidationError) Unwrap() error {
+	return ErrInvalidPaymentReqs
+}
+
+// Validate checks a 402 response's payment options before anything is signed:
+// each needs a registered scheme, a network, an asset, a payTo address and a
+// positive integer amount. It returns the issues found, naming the field of
+// each, e.g. "accepts[1].maxAmountRequired".
+func (r PaymentRequirementsResponse) Validate() []RequirementsIssue {
+	if len(r.Accepts) == 0 {
+		return []RequirementsIssue{{Field: "accepts", Message: "no payment options"}}
+	}
+	var issues []RequirementsIssue
+	for i, req := range r.Accepts {
+		issues = append(issues, validateRequirement(i, req)...)
+	}
+	return issues
+}
+
+// validateRequirement returns the issues of the payment option at index i
+func validateRequirement(i int, req PaymentRequirement) []RequirementsIssue {
+	var issues []RequirementsIssue
+	add := func(field, format string, args ...any) {
+		issues = append(issues, RequirementsIssue{
+			Field:   fmt.Sprintf("accepts[%d].%s", i, field),
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	switch {
+	case req.Scheme == "":
+		add("scheme", "missing")
+	case !isRegisteredScheme(req.Scheme):
+		add("scheme", "unknown scheme %q", req.Scheme)
+	}
+	if req.Network == "" {
+		add("network", "missing")
+	}
+	if req.Asset == "" {
+		add("asset", "missing")
+	}
+	if req.PayTo == "" {
+		add("payTo", "missing")
+	}
+	if req.MaxAmountRequired == "" {
+		add("maxAmountRequired", "missing")
+	} else if amount, ok := new(big.Int).SetString(req.MaxAmountRequired, 10); !ok {
+		add("maxAmountRequired", "%q is not an integer amount in base units", req.MaxAmountRequired)
+	} else if amount.Sign() <= 0 {
+		add("maxAmountRequired", "%s is not positive", req.MaxAmountRequired)
+	}
+	if req.MaxTimeoutSeconds < 0 {
+		add("maxTimeoutSeconds", "must not be negative")
+	}
+	return issues
+}
+
+// validRequirements drops the invalid payment options of a 402 response,
+// logging their issues. It fails with a *RequirementsValidationError when no
+// option is valid.
+func (t *X402Transport) validRequirements(requirements PaymentRequirementsResponse) (PaymentRequirementsResponse, error) {
+	if len(requirements.Accepts) == 0 {
+		return requirements, &RequirementsValidationError{Issues: requirements.Validate()}
+	}
+
+	var valid []PaymentRequirement
+	var issues []RequirementsIssue
+	for i, req := range requirements.Accepts {
+		if reqIssues := validateRequirement(i, req); len(reqIssues) > 0 {
+			issues = append(issues, reqIssues...)
+			continue
+		}
+		valid = append(valid, req)
+	}
+	if len(valid) == 0 {
+		return requirements, &RequirementsValidationError{Issues: issues}
+	}
+	for _, issue := range issues {
+		t.logger.Printf("[X402] Ignoring invalid payment option: %s", issue)
+	}
+	requirements.Accepts = valid
+	return requirements, nil
+}
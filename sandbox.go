@@ -0,0 +1,27 @@
+package x402
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SandboxNetwork is the developer sandbox network. Payments on it are signed
+// like EVM payments but settled on a fake chain by a sandbox facilitator
+// (server.NewSandboxFacilitator), so examples and tests run without keys,
+// faucets or internet access. Sandbox payments have no value.
+const SandboxNetwork = "sandbox"
+
+// SandboxChainID is the chain ID sandbox payments are signed for
+const SandboxChainID = 31337
+
+// NewSandboxSigner creates a signer with a throwaway key paying in fake USDC on
+// the sandbox network
+func NewSandboxSigner() (*PrivateKeySigner, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sandbox key: %w", err)
+	}
+	return NewPrivateKeySigner(hex.EncodeToString(crypto.FromECDSA(key)), AcceptUSDCSandbox())
+}
@@ -0,0 +1,103 @@
+package x402
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// Scheme describes a payment scheme. Requirements, client options and payloads
+// name their scheme; registering one lets selection, signing and servers
+// handle it by that name without changes to this package.
+type Scheme struct {
+	// Name is the scheme name used in requirements and payloads, e.g. "permit2"
+	Name string
+
+	// PaidBy lists the schemes of client options that can also pay this one.
+	// An option of the scheme itself always can; "upto" is paid by "exact".
+	PaidBy []string
+
+	// Sign, if set, signs requirements of the scheme in place of the signer's
+	// SignPayment, e.g. formatting a Permit2 or Token-2022 payload with a key
+	// the signer exposes. The payload it returns is sent as is.
+	Sign func(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*PaymentPayload, error)
+
+	// Nonce, if set, returns the nonce of a decoded payload, which servers
+	// check payment bindings against. Payloads of schemes without it are read
+	// as EIP-3009 authorizations or Solana transactions.
+	Nonce func(payload map[string]any) (string, error)
+}
+
+var schemes = struct {
+	sync.RWMutex
+	byName map[string]Scheme
+}{
+	byName: map[string]Scheme{
+		SchemeExact:   {Name: SchemeExact},
+		SchemeUpto:    {Name: SchemeUpto, PaidBy: []string{SchemeExact}},
+		SchemeERC4337: {Name: SchemeERC4337, Nonce: userOperationNonce},
+	},
+}
+
+// RegisterScheme registers a payment scheme for clients and servers in this
+// process. It fails for an empty name or one already registered, including
+// "exact" and "upto".
+func RegisterScheme(scheme Scheme) error {
+	if scheme.Name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidScheme)
+	}
+	schemes.Lock()
+	defer schemes.Unlock()
+	if _, exists := schemes.byName[scheme.Name]; exists {
+		return fmt.Errorf("%w: %q is already registered", ErrInvalidScheme, scheme.Name)
+	}
+	scheme.PaidBy = slices.Clone(scheme.PaidBy)
+	schemes.byName[scheme.Name] = scheme
+	return nil
+}
+
+// LookupScheme returns the registered scheme with the given name
+func LookupScheme(name string) (Scheme, bool) {
+	schemes.RLock()
+	defer schemes.RUnlock()
+	scheme, ok := schemes.byName[name]
+	return scheme, ok
+}
+
+// RegisteredSchemes returns the names of the registered schemes, sorted
+func RegisteredSchemes() []string {
+	schemes.RLock()
+	defer schemes.RUnlock()
+	names := make([]string, 0, len(schemes.byName))
+	for name := range schemes.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SchemePays reports whether a client option of scheme option can pay a
+// requirement of scheme required
+func SchemePays(option, required string) bool {
+	if option == required {
+		return true
+	}
+	scheme, ok := LookupScheme(required)
+	return ok && slices.Contains(scheme.PaidBy, option)
+}
+
+// isRegisteredScheme reports whether name is a registered scheme
+func isRegisteredScheme(name string) bool {
+	_, ok := LookupScheme(name)
+	return ok
+}
+
+// signPayment signs req with signer, through the scheme's Sign when it has one
+func signPayment(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*PaymentPayload, error) {
+	if scheme, ok := LookupScheme(req.Scheme); ok && scheme.Sign != nil {
+		return scheme.Sign(ctx, signer, req)
+	}
+	return signer.SignPayment(ctx, req)
+}
@@ -0,0 +1,316 @@
+package x402
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PaymentCandidate pairs a signer with a server requirement it is able to pay
+type PaymentCandidate struct {
+	Signer      PaymentSigner
+	SignerIndex int                 // Position in the handler's signer list (priority order)
+	Option      ClientPaymentOption // Client option matching the requirement
+	Requirement PaymentRequirement
+	Amount      *big.Int
+}
+
+// SelectionStrategy chooses which signer pays which requirement.
+// SelectPayment receives the signers still eligible for this payment in priority
+// order; if signing with the returned signer fails, it is called again without it.
+type SelectionStrategy interface {
+	SelectPayment(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error)
+}
+
+// SelectionPeeker is implemented by strategies that keep state between calls,
+// such as RoundRobin. PeekPayment returns the payment SelectPayment would select
+// next without advancing that state; ExplainSelection calls it instead of
+// SelectPayment.
+type SelectionPeeker interface {
+	PeekPayment(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error)
+}
+
+// SelectionStrategyFunc adapts a function to the SelectionStrategy interface
+type SelectionStrategyFunc func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error)
+
+// SelectPayment calls f
+func (f SelectionStrategyFunc) SelectPayment(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+	return f(signers, accepts)
+}
+
+// PaymentCandidates returns every (signer, requirement) pair the signers can pay,
+// applying scheme, amount and per-option MaxAmount and AmountPolicy checks
+func PaymentCandidates(signers []PaymentSigner, accepts []PaymentRequirement) []PaymentCandidate {
+	var candidates []PaymentCandidate
+	for idx, signer := range signers {
+		candidates = append(candidates, candidatesForSigner(idx, signer, accepts)...)
+	}
+	return candidates
+}
+
+// candidatesForSigner returns the requirements a single signer can pay
+func candidatesForSigner(idx int, signer PaymentSigner, accepts []PaymentRequirement) []PaymentCandidate {
+	var candidates []PaymentCandidate
+	for _, req := range accepts {
+		if candidate, reason, _ := checkCandidate(idx, signer, req); reason == "" {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
+
+// checkCandidate checks whether signer can pay req, returning the reason and
+// details when it can't
+func checkCandidate(idx int, signer PaymentSigner, req PaymentRequirement) (PaymentCandidate, SelectionReason, string) {
+	// Check if we support this network and asset
+	option := signer.GetPaymentOption(req.Network, req.Asset)
+	if option == nil {
+		return PaymentCandidate{}, ReasonUnsupportedNetwork, fmt.Sprintf("no option for %s on %s", req.Asset, req.Network)
+	}
+
+	// Check scheme matches
+	if !SchemePays(option.Scheme, req.Scheme) {
+		return PaymentCandidate{}, ReasonUnsupportedScheme, fmt.Sprintf("option uses scheme %s, server asks %s", option.Scheme, req.Scheme)
+	}
+
+	amount := new(big.Int)
+	if _, ok := amount.SetString(req.MaxAmountRequired, 10); !ok || amount.Sign() <= 0 {
+		// Skip invalid, zero or negative amounts
+		return PaymentCandidate{}, ReasonInvalidAmount, fmt.Sprintf("amount %q", req.MaxAmountRequired)
+	}
+
+	// Check if within client's max amount for this option
+	if option.MaxAmount != "" {
+		maxAmount := new(big.Int)
+		if _, ok := maxAmount.SetString(option.MaxAmount, 10); ok && amount.Cmp(maxAmount) > 0 {
+			return PaymentCandidate{}, ReasonExceedsMaxAmount, fmt.Sprintf("%s > %s", amount, maxAmount)
+		}
+	}
+
+	// Check the amount is valid under the option's policy
+	if option.AmountPolicy != nil {
+		if err := option.AmountPolicy.Validate(req.MaxAmountRequired); err != nil {
+			return PaymentCandidate{}, ReasonAmountPolicy, err.Error()
+		}
+	}
+
+	return PaymentCandidate{
+		Signer:      signer,
+		SignerIndex: idx,
+		Option:      *option,
+		Requirement: req,
+		Amount:      amount,
+	}, "", ""
+}
+
+// selectBest sorts candidates with less and returns the first
+func selectBest(candidates []PaymentCandidate, accepts []PaymentRequirement, less func(a, b PaymentCandidate) bool) (PaymentSigner, *PaymentRequirement, error) {
+	if len(candidates) == 0 {
+		if len(accepts) == 0 {
+			return nil, nil, ErrNoAcceptablePayment
+		}
+		return nil, nil, fmt.Errorf("%w: no signer supports network=%s asset=%s",
+			ErrNoAcceptablePayment, accepts[0].Network, accepts[0].Asset)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return less(candidates[i], candidates[j])
+	})
+
+	best := candidates[0]
+	return best.Signer, &best.Requirement, nil
+}
+
+// defaultLess orders by signer priority, then option priority, then amount
+func defaultLess(a, b PaymentCandidate) bool {
+	if a.SignerIndex != b.SignerIndex {
+		return a.SignerIndex < b.SignerIndex
+	}
+	if a.Option.Priority != b.Option.Priority {
+		return a.Option.Priority < b.Option.Priority
+	}
+	return a.Amount.Cmp(b.Amount) < 0
+}
+
+// PriorityFirst selects the highest-priority signer that can pay, then its
+// highest-priority option, then the cheapest. This is the default behavior.
+func PriorityFirst() SelectionStrategy {
+	return SelectionStrategyFunc(func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+		return selectBest(PaymentCandidates(signers, accepts), accepts, defaultLess)
+	})
+}
+
+// CheapestFirst selects the lowest amount across all signers, breaking ties by priority
+func CheapestFirst() SelectionStrategy {
+	return SelectionStrategyFunc(func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+		return selectBest(PaymentCandidates(signers, accepts), accepts, func(a, b PaymentCandidate) bool {
+			if c := a.Amount.Cmp(b.Amount); c != 0 {
+				return c < 0
+			}
+			return defaultLess(a, b)
+		})
+	})
+}
+
+// networkSpeedRank orders known networks by typical settlement speed (lower is faster)
+var networkSpeedRank = map[string]int{
+	"solana":         1,
+	"solana-devnet":  1,
+	"base":           2,
+	"base-sepolia":   2,
+	"avalanche":      3,
+	"avalanche-fuji": 3,
+	"polygon":        4,
+	"polygon-amoy":   4,
+}
+
+// networkRank returns the speed rank of a network, with unknown networks last
+func networkRank(network string) int {
+	if rank, ok := networkSpeedRank[network]; ok {
+		return rank
+	}
+	return len(networkSpeedRank) + 1
+}
+
+// FastestNetworkFirst selects the network with the fastest typical settlement,
+// breaking ties by priority and amount
+func FastestNetworkFirst() SelectionStrategy {
+	return SelectionStrategyFunc(func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+		return selectBest(PaymentCandidates(signers, accepts), accepts, func(a, b PaymentCandidate) bool {
+			if ra, rb := networkRank(a.Requirement.Network), networkRank(b.Requirement.Network); ra != rb {
+				return ra < rb
+			}
+			return defaultLess(a, b)
+		})
+	})
+}
+
+// PreferNetwork selects options on the given networks first, in the order listed,
+// falling back to the default ordering for everything else
+func PreferNetwork(networks ...string) SelectionStrategy {
+	preference := make(map[string]int, len(networks))
+	for i, network := range networks {
+		if _, exists := preference[network]; !exists {
+			preference[network] = i
+		}
+	}
+	rank := func(network string) int {
+		if r, ok := preference[network]; ok {
+			return r
+		}
+		return len(networks)
+	}
+
+	return SelectionStrategyFunc(func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+		return selectBest(PaymentCandidates(signers, accepts), accepts, func(a, b PaymentCandidate) bool {
+			if ra, rb := rank(a.Requirement.Network), rank(b.Requirement.Network); ra != rb {
+				return ra < rb
+			}
+			return defaultLess(a, b)
+		})
+	})
+}
+
+// tied reports whether two candidates are equally good under the default
+// ordering: signers and options of the same priority asking the same amount
+func tied(a, b PaymentCandidate) bool {
+	return a.Signer.GetPriority() == b.Signer.GetPriority() &&
+		a.Option.Priority == b.Option.Priority &&
+		a.Amount.Cmp(b.Amount) == 0
+}
+
+// selectTied orders candidates by priority and amount and lets pick choose
+// among those tied with the best
+func selectTied(candidates []PaymentCandidate, accepts []PaymentRequirement, pick func(tied []PaymentCandidate) PaymentCandidate) (PaymentSigner, *PaymentRequirement, error) {
+	_, _, err := selectBest(candidates, accepts, func(a, b PaymentCandidate) bool {
+		if pa, pb := a.Signer.GetPriority(), b.Signer.GetPriority(); pa != pb {
+			return pa < pb
+		}
+		if a.Option.Priority != b.Option.Priority {
+			return a.Option.Priority < b.Option.Priority
+		}
+		if c := a.Amount.Cmp(b.Amount); c != 0 {
+			return c < 0
+		}
+		return a.SignerIndex < b.SignerIndex
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	n := 1
+	for n < len(candidates) && tied(candidates[0], candidates[n]) {
+		n++
+	}
+	chosen := pick(candidates[:n])
+	return chosen.Signer, &chosen.Requirement, nil
+}
+
+// WeightedRandom selects like PriorityFirst, but picks randomly among options
+// tied on priority and amount, so payments spread across chains instead of
+// always going to the first. weights bias the pick per network; networks
+// without a weight count 1 and networks weighted 0 are only picked when all
+// tied options are.
+func WeightedRandom(weights map[string]int) SelectionStrategy {
+	var mu sync.Mutex
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	weight := func(network string) int {
+		if w, ok := weights[network]; ok {
+			return max(w, 0)
+		}
+		return 1
+	}
+
+	return SelectionStrategyFunc(func(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+		return selectTied(PaymentCandidates(signers, accepts), accepts, func(tied []PaymentCandidate) PaymentCandidate {
+			total := 0
+			for _, c := range tied {
+				total += weight(c.Requirement.Network)
+			}
+			if total == 0 {
+				return tied[0]
+			}
+
+			mu.Lock()
+			n := random.Intn(total)
+			mu.Unlock()
+			for _, c := range tied {
+				if n -= weight(c.Requirement.Network); n < 0 {
+					return c
+				}
+			}
+			return tied[0]
+		})
+	})
+}
+
+// RoundRobin selects like PriorityFirst, but rotates through options tied on
+// priority and amount, spreading payments evenly across chains
+func RoundRobin() SelectionStrategy {
+	return &roundRobin{}
+}
+
+// roundRobin is the strategy of RoundRobin; next counts the payments selected
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// SelectPayment implements SelectionStrategy
+func (r *roundRobin) SelectPayment(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+	return r.selectTurn(signers, accepts, func() uint64 { return r.next.Add(1) - 1 })
+}
+
+// PeekPayment implements SelectionPeeker
+func (r *roundRobin) PeekPayment(signers []PaymentSigner, accepts []PaymentRequirement) (PaymentSigner, *PaymentRequirement, error) {
+	return r.selectTurn(signers, accepts, r.next.Load)
+}
+
+// selectTurn picks the tied option of the turn returned by turn
+func (r *roundRobin) selectTurn(signers []PaymentSigner, accepts []PaymentRequirement, turn func() uint64) (PaymentSigner, *PaymentRequirement, error) {
+	return selectTied(PaymentCandidates(signers, accepts), accepts, func(tied []PaymentCandidate) PaymentCandidate {
+		return tied[turn()%uint64(len(tied))]
+	})
+}
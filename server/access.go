@@ -0,0 +1,241 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// AccessResource is the resource URI advertised for the per-session access fee
+const AccessResource = "mcp://server/access"
+
+// accessSessions tracks MCP sessions that have paid the access fee
+type accessSessions struct {
+	store  Store
+	logger x402.Logger
+}
+
+func newAccessSessions(store Store, logger x402.Logger) *accessSessions {
+	return &accessSessions{store: NewNamespacedStore(store, StoreNamespaceAccess), logger: logger}
+}
+
+// has reports whether the session paid; store errors deny access
+func (a *accessSessions) has(ctx context.Context, sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	value, err := a.store.Get(ctx, sessionID)
+	if err != nil {
+		a.logger.Printf("[X402] Failed to look up session access: %v", err)
+		return false
+	}
+	return value != nil
+}
+
+func (a *accessSessions) grant(ctx context.Context, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	if err := a.store.Set(ctx, sessionID, []byte("1"), 0); err != nil {
+		a.logger.Printf("[X402] Failed to record session access: %v", err)
+	}
+}
+
+func (a *accessSessions) revoke(ctx context.Context, sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	if err := a.store.Delete(ctx, sessionID); err != nil {
+		a.logger.Printf("[X402] Failed to revoke session access: %v", err)
+	}
+}
+
+// accessGateApplies reports whether the request must pay the session access fee first
+func (h *X402Handler) accessGateApplies(r *http.Request, method string) bool {
+	if len(h.config.AccessRequirements) == 0 {
+		return false
+	}
+
+	// Responses to server requests, notifications and pings are never gated
+	if method == "" || method == "ping" || strings.HasPrefix(method, "notifications/") {
+		return false
+	}
+
+	if method == "initialize" {
+		return h.config.GateInitialize
+	}
+
+	sessionID := r.Header.Get(server.HeaderKeySessionID)
+	if h.access.has(r.Context(), sessionID) {
+		return false
+	}
+
+	// A valid access pass from an earlier payment covers new sessions
+	if pass := r.Header.Get(x402.HeaderAccessPass); pass != "" && h.accessPassKey != nil {
+		if err := verifyAccessPass(h.accessPassKey, pass, h.config.now()); err == nil {
+			h.access.grant(r.Context(), sessionID)
+			return false
+		} else {
+			h.debugf(r.Context(), "[X402] Ignoring access pass: %v", err)
+		}
+	}
+	return true
+}
+
+// newAccessPassKey returns the key access passes are signed with, or nil when
+// AccessPassTTL is not set
+func newAccessPassKey(config *Config) []byte {
+	return newSigningKey(config.AccessPassTTL, config.AccessPassSecret)
+}
+
+// newSigningKey returns secret, or a random key when it is empty, for tokens
+// valid for ttl; it returns nil when ttl is not set
+func newSigningKey(ttl time.Duration, secret []byte) []byte {
+	if ttl <= 0 {
+		return nil
+	}
+	if len(secret) > 0 {
+		return secret
+	}
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}
+
+// issueAccessPass returns an access pass for payer valid until expires
+func issueAccessPass(key []byte, payer string, expires time.Time) (string, error) {
+	claims, err := json.Marshal(x402.AccessPassClaims{Payer: payer, Expires: expires.Unix()})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(payloadMAC(key, payload)), nil
+}
+
+// verifyAccessPass checks an access pass's MAC and expiry
+func verifyAccessPass(key []byte, pass string, now time.Time) error {
+	payload, mac, ok := strings.Cut(pass, ".")
+	if !ok {
+		return fmt.Errorf("malformed access pass")
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(sum, payloadMAC(key, payload)) {
+		return fmt.Errorf("invalid access pass signature")
+	}
+	claims, err := x402.ParseAccessPassClaims(pass)
+	if err != nil {
+		return err
+	}
+	if now.After(claims.ExpiresAt()) {
+		return fmt.Errorf("access pass expired")
+	}
+	return nil
+}
+
+// payloadMAC signs the payload of a token
+func payloadMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// accessRequirements returns the access fee requirements with the access resource set
+func (h *X402Handler) accessRequirements() []PaymentRequirement {
+	requirements := make([]PaymentRequirement, len(h.config.AccessRequirements))
+	copy(requirements, h.config.AccessRequirements)
+	for i := range requirements {
+		requirements[i].Resource = AccessResource
+		if requirements[i].MimeType == "" {
+			requirements[i].MimeType = "application/json"
+		}
+	}
+	return requirements
+}
+
+// handleAccessPayment charges the one-time access fee for the request's session
+func (h *X402Handler) handleAccessPayment(w http.ResponseWriter, r *http.Request, jsonrpcReq transport.JSONRPCRequest) {
+	requirements := h.accessRequirements()
+	meta := h.withPayment(r, requestMeta(jsonrpcReq.Params))
+
+	if meta[x402.MetaKeyPayment] == nil {
+		h.debugf(r.Context(), "[X402] Session has not paid access fee, sending 402 for %s", jsonrpcReq.Method)
+		h.sendPaymentRequiredError(r.Context(), w, jsonrpcReq.ID, requirements)
+		return
+	}
+
+	// Access is only granted once the fee settled
+	r = r.WithContext(withSettlementWait(r.Context()))
+	info, ok := h.processPayment(w, r, jsonrpcReq, meta, requirements)
+	if !ok {
+		return
+	}
+
+	// Let the payer skip the fee in new sessions while the pass is valid
+	if h.accessPassKey != nil {
+		pass, err := issueAccessPass(h.accessPassKey, info.Settlement.Payer, h.config.now().Add(h.config.AccessPassTTL))
+		if err == nil {
+			w.Header().Set(x402.HeaderAccessPass, pass)
+		} else {
+			h.verbosef("[X402] Failed to issue access pass: %v", err)
+		}
+	}
+
+	// Existing sessions are granted before forwarding so follow-up requests aren't gated
+	sessionID := r.Header.Get(server.HeaderKeySessionID)
+	h.access.grant(r.Context(), sessionID)
+
+	// The access payment doesn't cover a paid tool; ask for the tool's price next
+	if jsonrpcReq.Method == "tools/call" {
+		var params mcp.CallToolParams
+		paramsBytes, _ := json.Marshal(jsonrpcReq.Params)
+		if err := json.Unmarshal(paramsBytes, &params); err == nil {
+			toolRequirements, paid, err := h.config.requirementsFor(r.Context(), params.Name)
+			if err != nil {
+				h.sendIdentityRejectedError(w, jsonrpcReq.ID, err)
+				return
+			}
+			if paid {
+				h.sendPaymentRequiredError(r.Context(), w, jsonrpcReq.ID, toolRequirements)
+				return
+			}
+		}
+	}
+
+	h.forwardWithSettlementResponse(w, r, jsonrpcReq.ID, info)
+
+	// initialize assigns the session ID in its response
+	if sessionID == "" {
+		h.access.grant(r.Context(), w.Header().Get(server.HeaderKeySessionID))
+	}
+
+	h.debugf(r.Context(), "[X402] Access fee paid for session via %s", jsonrpcReq.Method)
+}
+
+// requestMeta extracts params._meta from an arbitrary JSON-RPC params value
+func requestMeta(params any) map[string]any {
+	paramsBytes, err := json.Marshal(params)
+	if err != nil {
+		return nil
+	}
+
+	var withMeta struct {
+		Meta map[string]any `json:"_meta"`
+	}
+	if err := json.Unmarshal(paramsBytes, &withMeta); err != nil {
+		return nil
+	}
+	return withMeta.Meta
+}
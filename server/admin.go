@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// HeaderAdminToken carries the operator token authorizing calls to the admin tools
+const HeaderAdminToken = "X-X402-Admin-Token"
+
+// Admin tool names
+const (
+	AdminToolRevenueReport      = "x402.revenue_report"
+	AdminToolPendingSettlements = "x402.pending_settlements"
+	AdminToolSetPrice           = "x402.set_price"
+)
+
+// isAdminTool reports whether toolName is one of the built-in admin tools
+func isAdminTool(toolName string) bool {
+	switch toolName {
+	case AdminToolRevenueReport, AdminToolPendingSettlements, AdminToolSetPrice:
+		return true
+	}
+	return false
+}
+
+type adminTokenKey struct{}
+
+// withAdminToken attaches the operator token presented with a request to ctx
+func withAdminToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, adminTokenKey{}, token)
+}
+
+// authorizeAdmin checks the operator token an X402Handler attached to ctx
+func authorizeAdmin(ctx context.Context, config *Config) error {
+	token, _ := ctx.Value(adminTokenKey{}).(string)
+	if config.AdminToken == "" || token == "" ||
+		subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		return fmt.Errorf("operator token required in the %s header", HeaderAdminToken)
+	}
+	return nil
+}
+
+// priceOverrides holds tool prices set at runtime with x402.set_price, keyed by
+// tool and network ("" for all networks)
+type priceOverrides struct {
+	mu     sync.RWMutex
+	prices map[string]map[string]string
+}
+
+// set overrides the price of a tool's options on network, or all when empty
+func (o *priceOverrides) set(toolName, network, amount string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.prices == nil {
+		o.prices = make(map[string]map[string]string)
+	}
+	if network == "" {
+		o.prices[toolName] = map[string]string{"": amount}
+		return
+	}
+	if o.prices[toolName] == nil {
+		o.prices[toolName] = make(map[string]string)
+	}
+	o.prices[toolName][network] = amount
+}
+
+// apply sets the overridden prices of a tool on its requirements
+func (o *priceOverrides) apply(toolName string, requirements []PaymentRequirement) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	prices := o.prices[toolName]
+	if prices == nil {
+		return
+	}
+	for i := range requirements {
+		if amount, ok := prices[requirements[i].Network]; ok {
+			requirements[i].MaxAmountRequired = amount
+		} else if amount, ok := prices[""]; ok {
+			requirements[i].MaxAmountRequired = amount
+		}
+	}
+}
+
+// RevenueTotal sums the receipts of one tool, network and asset
+type RevenueTotal struct {
+	Tool     string `json:"tool,omitempty"`
+	Network  string `json:"network"`
+	Asset    string `json:"asset"`
+	Amount   string `json:"amount"`
+	Payments int    `json:"payments"`
+}
+
+// revenueReport sums receipts per tool, network and asset
+func revenueReport(receipts []Receipt) []RevenueTotal {
+	type key struct{ tool, network, asset string }
+	sums := make(map[key]*big.Int)
+	counts := make(map[key]int)
+	for _, receipt := range receipts {
+		amount, ok := new(big.Int).SetString(receipt.Amount, 10)
+		if !ok {
+			continue
+		}
+		k := key{receipt.Tool, receipt.Network, receipt.Asset}
+		if sums[k] == nil {
+			sums[k] = new(big.Int)
+		}
+		sums[k].Add(sums[k], amount)
+		counts[k]++
+	}
+
+	totals := make([]RevenueTotal, 0, len(sums))
+	for k, sum := range sums {
+		totals = append(totals, RevenueTotal{Tool: k.tool, Network: k.network, Asset: k.asset, Amount: sum.String(), Payments: counts[k]})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		a, b := totals[i], totals[j]
+		if a.Tool != b.Tool {
+			return a.Tool < b.Tool
+		}
+		if a.Network != b.Network {
+			return a.Network < b.Network
+		}
+		return a.Asset < b.Asset
+	})
+	return totals
+}
+
+// AddAdminTools registers the operator tools on mcpServer: x402.revenue_report
+// sums the receipts in config.Receipts, x402.pending_settlements lists the
+// unreconciled entries of config.SettlementJournal and x402.set_price changes a
+// tool's price at runtime. Calls must carry config.AdminToken in the
+// HeaderAdminToken header and be served by an X402Handler. The tools are free.
+// NewX402Server registers them when Config.AdminToken is set.
+func AddAdminTools(mcpServer *server.MCPServer, config *Config) {
+	mcpServer.AddTool(
+		mcp.NewTool(AdminToolRevenueReport,
+			mcp.WithDescription("Operator only: revenue per tool, network and asset from the payment receipts kept"),
+		),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if err := authorizeAdmin(ctx, config); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if config.Receipts == nil {
+				return mcp.NewToolResultError("no receipt store configured"), nil
+			}
+			return adminResult(revenueReport(config.Receipts.All()))
+		},
+	)
+
+	mcpServer.AddTool(
+		mcp.NewTool(AdminToolPendingSettlements,
+			mcp.WithDescription("Operator only: verified payments whose settlement has not completed"),
+		),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if err := authorizeAdmin(ctx, config); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			if config.SettlementJournal == nil {
+				return mcp.NewToolResultError("no settlement journal configured"), nil
+			}
+			entries, err := config.SettlementJournal.Unreconciled()
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("reading settlement journal: %v", err)), nil
+			}
+			if entries == nil {
+				entries = []JournalEntry{}
+			}
+			return adminResult(entries)
+		},
+	)
+
+	mcpServer.AddTool(
+		mcp.NewTool(AdminToolSetPrice,
+			mcp.WithDescription("Operator only: set the price of a paid tool in base units of its asset"),
+			mcp.WithString("tool", mcp.Required(), mcp.Description("Name of the paid tool")),
+			mcp.WithString("amount", mcp.Required(), mcp.Description("New price in base units")),
+			mcp.WithString("network", mcp.Description("Network of the payment option to change (all when omitted)")),
+		),
+		func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			if err := authorizeAdmin(ctx, config); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			toolName := request.GetString("tool", "")
+			amount := request.GetString("amount", "")
+			network := request.GetString("network", "")
+			if value, ok := new(big.Int).SetString(amount, 10); !ok || value.Sign() <= 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid amount %q", amount)), nil
+			}
+			if _, paid := config.toolRequirements(toolName); !paid {
+				return mcp.NewToolResultError(fmt.Sprintf("tool %s is not paid", toolName)), nil
+			}
+
+			config.prices.set(toolName, network, amount)
+			requirements, _ := config.toolRequirements(toolName)
+			return adminResult(requirements)
+		},
+	)
+}
+
+// adminResult returns v as the JSON text of a tool result
+func adminResult(v any) (*mcp.CallToolResult, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return mcp.NewToolResultText(string(data)), nil
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+// roundAmounts rounds each requirement's amount with its AmountPolicy
+func roundAmounts(requirements []PaymentRequirement, logger x402.Logger) {
+	for i := range requirements {
+		roundAmount(&requirements[i], logger)
+	}
+}
+
+// roundAmount rounds the requirement's amount with its AmountPolicy, keeping the
+// amount unchanged, and logging why, when it cannot be rounded
+func roundAmount(req *PaymentRequirement, logger x402.Logger) {
+	if req.AmountPolicy == nil {
+		return
+	}
+	rounded, err := req.AmountPolicy.Round(req.MaxAmountRequired)
+	if err != nil {
+		logger.Printf("[X402] Cannot round amount %s on %s: %v", req.MaxAmountRequired, req.Network, err)
+		return
+	}
+	req.MaxAmountRequired = rounded
+}
+
+// checkAmounts warns about registered amounts their AmountPolicy deems invalid;
+// they are rounded when charged
+func checkAmounts(toolName string, requirements []PaymentRequirement, logger x402.Logger) {
+	for _, req := range requirements {
+		if req.AmountPolicy == nil {
+			continue
+		}
+		if err := req.AmountPolicy.Validate(req.MaxAmountRequired); err != nil {
+			rounded, _ := req.AmountPolicy.Round(req.MaxAmountRequired)
+			logger.Printf("WARNING: Tool %s payment option on %s: %v; charging %s instead",
+				toolName, req.Network, err, rounded)
+		}
+	}
+}
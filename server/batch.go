@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+const (
+	// defaultBatchTTL is how long a prepaid batch can be redeemed
+	defaultBatchTTL = 10 * time.Minute
+
+	// maxBatchCalls bounds the number of calls one batch payment may cover
+	maxBatchCalls = 100
+)
+
+// batchPasses stores prepaid batches by token: the batch payment under the token
+// and the remaining calls of each tool under "token:tool"
+type batchPasses struct {
+	store  Store
+	logger x402.Logger
+}
+
+// batchRecord is a stored batch payment, carrying the PaymentInfo fields JSON
+// leaves out
+type batchRecord struct {
+	*PaymentInfo
+	Payer          string        `json:"payer,omitempty"`
+	DepositBalance *x402.Deposit `json:"depositBalance,omitempty"`
+}
+
+func newBatchPasses(store Store, logger x402.Logger) *batchPasses {
+	return &batchPasses{store: NewNamespacedStore(store, StoreNamespaceBatch), logger: logger}
+}
+
+// issue stores a batch covering the given tool calls and returns its token
+func (b *batchPasses) issue(ctx context.Context, calls map[string]int, info *PaymentInfo, ttl time.Duration) (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", fmt.Errorf("generate batch token: %w", err)
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	infoJSON, err := json.Marshal(batchRecord{PaymentInfo: info, Payer: info.payer, DepositBalance: info.depositBalance})
+	if err != nil {
+		return "", fmt.Errorf("encode batch payment: %w", err)
+	}
+	for toolName, count := range calls {
+		if err := b.store.Set(ctx, token+":"+toolName, []byte(strconv.Itoa(count)), ttl); err != nil {
+			return "", fmt.Errorf("store batch: %w", err)
+		}
+	}
+	// Stored last, so the batch is never redeemable with its counts missing
+	if err := b.store.Set(ctx, token, infoJSON, ttl); err != nil {
+		return "", fmt.Errorf("store batch: %w", err)
+	}
+	return token, nil
+}
+
+// redeem consumes one call of toolName from the batch, returning the batch payment
+func (b *batchPasses) redeem(ctx context.Context, token, toolName string) (*PaymentInfo, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	infoJSON, err := b.store.Get(ctx, token)
+	if err != nil {
+		b.logger.Printf("[X402] Failed to look up batch: %v", err)
+		return nil, false
+	}
+	if infoJSON == nil {
+		return nil, false
+	}
+	record := batchRecord{PaymentInfo: &PaymentInfo{}}
+	if err := json.Unmarshal(infoJSON, &record); err != nil {
+		return nil, false
+	}
+	info := record.PaymentInfo
+	info.payer, info.depositBalance = record.Payer, record.DepositBalance
+
+	// The counter of a tool outside the batch is created at -1 and expires with the batch
+	remaining, err := b.store.IncrBy(ctx, token+":"+toolName, -1, defaultBatchTTL)
+	if err != nil {
+		b.logger.Printf("[X402] Failed to redeem batch call: %v", err)
+		return nil, false
+	}
+	if remaining < 0 {
+		return nil, false
+	}
+	return info, true
+}
+
+// batchCalls parses the tool names announced in _meta["x402/batch"]
+func batchCalls(meta map[string]any) []string {
+	raw, ok := meta[x402.MetaKeyBatch].([]any)
+	if !ok || len(raw) < 2 || len(raw) > maxBatchCalls {
+		return nil
+	}
+	names := make([]string, 0, len(raw))
+	for _, v := range raw {
+		name, ok := v.(string)
+		if !ok {
+			return nil
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// batchRequirements prices a batch of tool calls as one payment. Options are offered
+// for each scheme/network/asset/recipient that every paid tool in the batch accepts,
+// summed and discounted by BatchDiscountPercent. remaining holds the prepaid calls
+// left after the current one.
+func (h *X402Handler) batchRequirements(ctx context.Context, current string, calls []string) (requirements []PaymentRequirement, remaining map[string]int, ok bool) {
+	seenCurrent := false
+	remaining = make(map[string]int)
+	var perCall [][]PaymentRequirement
+	for _, name := range calls {
+		reqs, paid, err := h.config.requirementsFor(ctx, name)
+		if err != nil {
+			return nil, nil, false
+		}
+		if name == current && !seenCurrent {
+			seenCurrent = true
+		} else if paid {
+			remaining[name]++
+		}
+		if paid {
+			perCall = append(perCall, reqs)
+		}
+	}
+	if !seenCurrent || len(perCall) < 2 {
+		return nil, nil, false
+	}
+
+	timeout := 0
+	for _, option := range perCall[0] {
+		total := new(big.Int)
+		matched := true
+		for _, reqs := range perCall {
+			match := findBatchOption(reqs, option)
+			if match == nil {
+				matched = false
+				break
+			}
+			amount, ok := new(big.Int).SetString(match.MaxAmountRequired, 10)
+			if !ok {
+				matched = false
+				break
+			}
+			total.Add(total, amount)
+			if match.MaxTimeoutSeconds > timeout {
+				timeout = match.MaxTimeoutSeconds
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		if discount := h.config.BatchDiscountPercent; discount > 0 && discount < 100 {
+			total.Mul(total, big.NewInt(int64(100-discount)))
+			total.Div(total, big.NewInt(100))
+		}
+
+		batch := option
+		batch.MaxAmountRequired = total.String()
+		batch.Resource = x402.BatchResource
+		batch.Description = fmt.Sprintf("Batch of %d tool calls", len(calls))
+		batch.MaxTimeoutSeconds = timeout
+		batch.PerItem = nil // Batches are charged in full up front
+		roundAmount(&batch, h.logger)
+		requirements = append(requirements, batch)
+	}
+
+	return requirements, remaining, len(requirements) > 0
+}
+
+// findBatchOption finds the option in reqs paying the same asset to the same recipient
+func findBatchOption(reqs []PaymentRequirement, option PaymentRequirement) *PaymentRequirement {
+	for i := range reqs {
+		r := &reqs[i]
+		if r.Scheme == option.Scheme && r.Network == option.Network && r.Asset == option.Asset && r.PayTo == option.PayTo {
+			return r
+		}
+	}
+	return nil
+}
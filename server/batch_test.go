@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// countingFacilitator approves every payment and records settled amounts
+type countingFacilitator struct {
+	mu      sync.Mutex
+	settled []string
+}
+
+func (f *countingFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	return &VerifyResponse{IsValid: true, Payer: "0xpayer"}, nil
+}
+
+func (f *countingFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.settled = append(f.settled, requirement.MaxAmountRequired)
+	return &SettleResponse{Success: true, Transaction: "0xtx", Network: requirement.Network, Payer: "0xpayer"}, nil
+}
+
+func (f *countingFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	return nil, nil
+}
+
+func TestCallToolsBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		batch       bool
+		wantSettled []string
+	}{
+		{name: "BatchPricing", batch: true, wantSettled: []string{"2700"}},
+		{name: "PerCallFallback", batch: false, wantSettled: []string{"1000", "1000", "1000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{
+				FacilitatorURL:       "http://mock",
+				BatchPayments:        tt.batch,
+				BatchDiscountPercent: 10,
+			}
+			srv := NewX402Server("batch", "1.0.0", config, server.WithToolCapabilities(false))
+			srv.AddPayableTool(mcp.NewTool("search"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				return mcp.NewToolResultText("ok"), nil
+			}, RequireUSDCBaseSepolia("0xrecipient", "1000", "search"))
+
+			handler := NewStreamableHTTPHandler(srv.MCPServer(), config, server.WithStateLess(true))
+			facilitator := &countingFacilitator{}
+			handler.facilitator = facilitator
+			httpServer := httptest.NewServer(handler)
+			defer httpServer.Close()
+
+			trans, err := x402.New(x402.Config{
+				ServerURL: httpServer.URL,
+				Signers:   []x402.PaymentSigner{x402.NewMockSigner("0xTestWallet", x402.AcceptUSDCBaseSepolia())},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mcpClient := client.NewClient(trans)
+			if _, err := mcpClient.Initialize(context.Background(), mcp.InitializeRequest{}); err != nil {
+				t.Fatal(err)
+			}
+
+			calls := make([]mcp.CallToolRequest, 3)
+			for i := range calls {
+				calls[i].Params.Name = "search"
+			}
+
+			for i, res := range x402.CallToolsBatch(context.Background(), mcpClient, calls) {
+				if res.Err != nil || res.Result == nil || res.Result.IsError {
+					t.Errorf("Call %d failed: %v %+v", i, res.Err, res.Result)
+				}
+			}
+
+			if len(facilitator.settled) != len(tt.wantSettled) {
+				t.Fatalf("Expected settlements %v, got %v", tt.wantSettled, facilitator.settled)
+			}
+			for i, amount := range tt.wantSettled {
+				if facilitator.settled[i] != amount {
+					t.Errorf("Settlement %d: expected %s, got %s", i, amount, facilitator.settled[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+type serverOriginKey struct{}
+
+// withServerOrigin attaches the origin a request reached the server at to ctx
+func withServerOrigin(ctx context.Context, origin string) context.Context {
+	return context.WithValue(ctx, serverOriginKey{}, origin)
+}
+
+// serverOriginFromContext returns config.ServerOrigin, or else the origin of the
+// request in ctx
+func serverOriginFromContext(ctx context.Context, config *Config) string {
+	if config.ServerOrigin != "" {
+		return config.ServerOrigin
+	}
+	origin, _ := ctx.Value(serverOriginKey{}).(string)
+	return origin
+}
+
+// requestOrigin returns the scheme://host origin a request was sent to
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// withResourceBindingRequired returns copies of requirements asking clients to
+// bind payments to the server origin and resource
+func withResourceBindingRequired(requirements []PaymentRequirement) []PaymentRequirement {
+	out := make([]PaymentRequirement, len(requirements))
+	for i, req := range requirements {
+		extra := cloneStringMap(req.Extra)
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[x402.ExtraResourceBinding] = "required"
+		req.Extra = extra
+		out[i] = req
+	}
+	return out
+}
+
+// VerifyResourceBinding checks that an EVM payment's nonce commits to the server
+// origin and resource with the binding salt the client sent in
+// _meta["x402/binding"] (or the X-PAYMENT-BINDING header). Use it to check
+// payments taken outside an X402Handler. Solana payments carry no nonce and
+// always pass.
+func VerifyResourceBinding(payment *PaymentPayload, saltHex, origin, resource string) error {
+	nonce, err := paymentNonce(payment)
+	if err != nil || nonce == "" {
+		return err
+	}
+	return x402.VerifyResourceBinding(origin, resource, saltHex, nonce)
+}
+
+// paymentNonce returns the authorization nonce of an EVM payment, or "" for
+// Solana payments, which carry a transaction instead. Registered schemes with
+// a Nonce function read it themselves.
+func paymentNonce(payment *PaymentPayload) (string, error) {
+	payloadMap, ok := payment.Payload.(map[string]any)
+	if !ok {
+		return "", fmt.Errorf("unrecognized payment payload")
+	}
+	if scheme, ok := x402.LookupScheme(payment.Scheme); ok && scheme.Nonce != nil {
+		return scheme.Nonce(payloadMap)
+	}
+	authData, ok := payloadMap["authorization"].(map[string]any)
+	if !ok {
+		if _, isSVM := payloadMap["transaction"]; isSVM {
+			return "", nil
+		}
+		return "", fmt.Errorf("missing authorization")
+	}
+	nonce, _ := authData["nonce"].(string)
+	if nonce == "" {
+		return "", fmt.Errorf("missing authorization nonce")
+	}
+	return nonce, nil
+}
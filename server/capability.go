@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// capability describes the server's x402 support for the initialize result
+func (c *Config) capability() x402.Capability {
+	capability := x402.Capability{
+		Versions:            []int{1},
+		Flows:               []string{x402.FlowMeta, x402.FlowHeader},
+		PaymentRequiredCode: c.paymentRequiredCode(),
+	}
+
+	seen := make(map[x402.CapabilityCurrency]bool)
+	add := func(requirements []PaymentRequirement) {
+		for _, req := range requirements {
+			currency := x402.CapabilityCurrency{Scheme: req.Scheme, Network: req.Network, Asset: req.Asset}
+			if !seen[currency] {
+				seen[currency] = true
+				capability.Currencies = append(capability.Currencies, currency)
+			}
+		}
+	}
+	add(c.AccessRequirements)
+	add(c.DefaultPaymentRequirements)
+	tools := make([]string, 0, len(c.PaymentTools))
+	for name := range c.PaymentTools {
+		tools = append(tools, name)
+	}
+	sort.Strings(tools)
+	for _, name := range tools {
+		add(c.PaymentTools[name])
+	}
+	return capability
+}
+
+// forwardWithCapability forwards an initialize request and adds the x402
+// capability to capabilities.experimental of its result
+func (h *X402Handler) forwardWithCapability(w http.ResponseWriter, r *http.Request) {
+	recorder := &responseRecorder{
+		ResponseWriter: w,
+		body:           &bytes.Buffer{},
+		statusCode:     http.StatusOK,
+	}
+	h.mcpHandler.ServeHTTP(recorder, r)
+
+	if recorder.statusCode == http.StatusOK && recorder.Header().Get("Content-Type") == "application/json" {
+		var jsonrpcResp transport.JSONRPCResponse
+		if err := json.Unmarshal(recorder.body.Bytes(), &jsonrpcResp); err == nil && jsonrpcResp.Error == nil {
+			var result map[string]any
+			if err := json.Unmarshal(jsonrpcResp.Result, &result); err == nil {
+				capabilities, _ := result["capabilities"].(map[string]any)
+				if capabilities == nil {
+					capabilities = make(map[string]any)
+				}
+				experimental, _ := capabilities["experimental"].(map[string]any)
+				if experimental == nil {
+					experimental = make(map[string]any)
+				}
+				experimental[x402.CapabilityKey] = h.config.capability()
+				capabilities["experimental"] = experimental
+				result["capabilities"] = capabilities
+
+				jsonrpcResp.Result, _ = json.Marshal(result)
+				recorder.body = &bytes.Buffer{}
+				_ = json.NewEncoder(recorder.body).Encode(jsonrpcResp)
+			}
+		}
+	}
+
+	for k, v := range recorder.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(recorder.statusCode)
+	_, _ = w.Write(recorder.body.Bytes())
+}
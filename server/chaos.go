@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+// ChaosFacilitator wraps a Facilitator, injecting the delays, lost responses,
+// duplicate settlements and malformed responses of a ChaosConfig. Set it as
+// Config.Facilitator to test a server's handling of facilitator failures.
+type ChaosFacilitator struct {
+	facilitator Facilitator
+	chaos       *x402.Chaos
+}
+
+var _ Facilitator = (*ChaosFacilitator)(nil)
+
+// NewChaosFacilitator wraps facilitator
+func NewChaosFacilitator(facilitator Facilitator, config x402.ChaosConfig) *ChaosFacilitator {
+	return &ChaosFacilitator{facilitator: facilitator, chaos: x402.NewChaos(config)}
+}
+
+// Verify implements Facilitator. Malformed responses lose their payer.
+func (c *ChaosFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	if err := c.chaos.Wait(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := c.facilitator.Verify(ctx, payment, requirement)
+	if err != nil {
+		return nil, err
+	}
+	if c.chaos.Drop() {
+		return nil, x402.ErrChaosDropped
+	}
+	if c.chaos.Malform() {
+		return &VerifyResponse{IsValid: resp.IsValid, InvalidReason: resp.InvalidReason}, nil
+	}
+	return resp, nil
+}
+
+// Settle implements Facilitator. Duplicated settlements return the second
+// result; malformed responses lose their transaction and payer.
+func (c *ChaosFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	if err := c.chaos.Wait(ctx); err != nil {
+		return nil, err
+	}
+	if c.chaos.Duplicate() {
+		c.facilitator.Settle(ctx, payment, requirement)
+	}
+	resp, err := c.facilitator.Settle(ctx, payment, requirement)
+	if err != nil {
+		return nil, err
+	}
+	if c.chaos.Drop() {
+		return nil, x402.ErrChaosDropped
+	}
+	if c.chaos.Malform() {
+		return &SettleResponse{Success: resp.Success, ErrorReason: resp.ErrorReason, Network: resp.Network}, nil
+	}
+	return resp, nil
+}
+
+// GetSupported implements Facilitator. Malformed responses lose their extra
+// fields, such as the Solana fee payer.
+func (c *ChaosFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	if err := c.chaos.Wait(ctx); err != nil {
+		return nil, err
+	}
+	kinds, err := c.facilitator.GetSupported(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if c.chaos.Drop() {
+		return nil, x402.ErrChaosDropped
+	}
+	if c.chaos.Malform() {
+		stripped := make([]SupportedKind, len(kinds))
+		for i, kind := range kinds {
+			stripped[i] = SupportedKind{X402Version: kind.X402Version, Scheme: kind.Scheme, Network: kind.Network}
+		}
+		return stripped, nil
+	}
+	return kinds, nil
+}
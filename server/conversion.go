@@ -0,0 +1,79 @@
+package server
+
+import (
+	"strings"
+)
+
+const (
+	// ExtraKeySettlementAsset is the requirement Extra key naming the asset the
+	// facilitator converts the payment into before paying the recipient
+	ExtraKeySettlementAsset = "settlementAsset"
+
+	// ExtraKeySettlementConversion is the /supported Extra key ("true") with which
+	// a facilitator signals it can settle payments into another asset
+	ExtraKeySettlementConversion = "settlementConversion"
+
+	// ErrorReasonUnsupportedSettlementAsset is the settlement error reason of a
+	// facilitator that can't convert into the requested asset
+	ErrorReasonUnsupportedSettlementAsset = "unsupported_settlement_asset"
+)
+
+// SettlementAsset is an asset a tool's recipient wants to be paid in on a network,
+// whatever asset the client pays with there
+type SettlementAsset struct {
+	Network string
+	Asset   string
+}
+
+// supportsConversion reports whether the facilitator may settle into another
+// asset on network. Until /supported has been fetched conversion is assumed,
+// as settlement falls back to the paid asset.
+func supportsConversion(scheme, network string) bool {
+	supportedPaymentsCacheMutex.RLock()
+	defer supportedPaymentsCacheMutex.RUnlock()
+
+	if len(supportedPaymentsCache) == 0 {
+		return true
+	}
+	kind, ok := cachedKind(scheme, network)
+	return ok && kind.Extra[ExtraKeySettlementConversion] == "true"
+}
+
+// withSettlementAssets asks the facilitator to convert payments for a tool into
+// its SettlementAssets, advertising the target asset in the requirements' Extra.
+// Options already paid in the target asset, or on networks whose facilitator
+// can't convert, are left unchanged.
+func (c *Config) withSettlementAssets(toolName string, requirements []PaymentRequirement) {
+	targets := c.SettlementAssets[toolName]
+	if len(targets) == 0 {
+		return
+	}
+	for i, req := range requirements {
+		for _, target := range targets {
+			if target.Network != req.Network || target.Asset == "" || strings.EqualFold(target.Asset, req.Asset) {
+				continue
+			}
+			if !supportsConversion(req.Scheme, req.Network) {
+				continue
+			}
+			requirements[i].Extra = cloneStringMap(req.Extra)
+			if requirements[i].Extra == nil {
+				requirements[i].Extra = make(map[string]string)
+			}
+			requirements[i].Extra[ExtraKeySettlementAsset] = target.Asset
+			break
+		}
+	}
+}
+
+// sameAssetRequirement returns a copy of a converting requirement settling in
+// the paid asset, and whether the requirement asked for a conversion
+func sameAssetRequirement(requirement *PaymentRequirement) (*PaymentRequirement, bool) {
+	if _, ok := requirement.Extra[ExtraKeySettlementAsset]; !ok {
+		return requirement, false
+	}
+	fallback := *requirement
+	fallback.Extra = cloneStringMap(requirement.Extra)
+	delete(fallback.Extra, ExtraKeySettlementAsset)
+	return &fallback, true
+}
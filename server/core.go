@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// PaymentCore runs the x402 payment flow on JSON-RPC messages instead of HTTP
+// requests, for MCP servers embedded in other transports (WebSocket, NATS, gRPC
+// bridges). It shares X402Handler's verification and settlement code. Access
+// fees, batch payments, quotes and payment progress notifications are features
+// of the HTTP handler and aren't applied.
+type PaymentCore struct {
+	h *X402Handler
+}
+
+// NewPaymentCore creates a payment core for config
+func NewPaymentCore(config *Config) *PaymentCore {
+	return &PaymentCore{h: NewX402Handler(nil, config)}
+}
+
+// Handle runs the payment flow for a request before it is served. When it
+// returns a response, send it instead of serving the request: a 402 or payment
+// error. Otherwise serve the request with the returned context and, when info is
+// not nil, pass the response through Settle before sending it.
+func (c *PaymentCore) Handle(ctx context.Context, req transport.JSONRPCRequest) (context.Context, *PaymentInfo, *transport.JSONRPCResponse) {
+	if req.Method != "tools/call" {
+		return ctx, nil, nil
+	}
+
+	identity, err := c.h.requestIdentity(req)
+	if err != nil {
+		return ctx, nil, errorResponse(req.ID, invalidParamsError(err.Error()))
+	}
+	if identity != nil {
+		ctx = withIdentity(ctx, identity)
+	} else if c.h.config.RequireIdentity {
+		return ctx, nil, errorResponse(req.ID, invalidParamsError("Payer identity required in _meta[\"x402/identity\"]"))
+	}
+
+	requirements, paid, err := c.Requirements(ctx, req)
+	if err != nil {
+		return ctx, nil, errorResponse(req.ID, identityRejectedError(err))
+	}
+	if !paid {
+		return ctx, nil, nil
+	}
+	return c.Verify(ctx, req, requirements)
+}
+
+// Requirements returns the payment requirements of a request and whether it
+// needs payment, as tools/call requests for paid tools do. It returns an error
+// when the identity policy refuses the call.
+func (c *PaymentCore) Requirements(ctx context.Context, req transport.JSONRPCRequest) ([]PaymentRequirement, bool, error) {
+	if req.Method != "tools/call" {
+		return nil, false, nil
+	}
+	var params mcp.CallToolParams
+	paramsBytes, _ := json.Marshal(req.Params)
+	if err := json.Unmarshal(paramsBytes, &params); err != nil {
+		return nil, false, nil
+	}
+	return c.h.config.requirementsFor(ctx, params.Name)
+}
+
+// PaymentRequired returns the 402 error response offering requirements
+func (c *PaymentCore) PaymentRequired(ctx context.Context, id mcp.RequestId, requirements []PaymentRequirement) transport.JSONRPCResponse {
+	return *errorResponse(id, c.h.paymentRequiredError(ctx, requirements))
+}
+
+// ExtractPayment returns the payment in a request's _meta, whichever namespace
+// the client sent it in, or nil when the request carries none
+func (c *PaymentCore) ExtractPayment(req transport.JSONRPCRequest) (*PaymentPayload, error) {
+	meta, ok := c.h.withMetaPayment(requestMeta(req.Params))
+	if !ok {
+		return nil, nil
+	}
+	paymentBytes, err := json.Marshal(meta[x402.MetaKeyPayment])
+	if err != nil {
+		return nil, fmt.Errorf("invalid payment format: %w", err)
+	}
+	var payment PaymentPayload
+	if err := json.Unmarshal(paymentBytes, &payment); err != nil {
+		return nil, fmt.Errorf("failed to parse payment: %w", err)
+	}
+	return &payment, nil
+}
+
+// Verify verifies the payment of a request against requirements and settles it,
+// answering requests without a payment with a 402. Per-item payments are
+// settled by Settle instead. On failure it returns the error response to send.
+// The returned context carries the payment for PaymentFromContext.
+func (c *PaymentCore) Verify(ctx context.Context, req transport.JSONRPCRequest, requirements []PaymentRequirement) (context.Context, *PaymentInfo, *transport.JSONRPCResponse) {
+	meta, ok := c.h.withMetaPayment(requestMeta(req.Params))
+	if !ok {
+		resp := c.PaymentRequired(ctx, req.ID, requirements)
+		return ctx, nil, &resp
+	}
+
+	info, rpcError := c.h.verifyPayment(ctx, req, meta, requirements, func(string, map[string]any) {})
+	if rpcError != nil {
+		return ctx, nil, errorResponse(req.ID, rpcError)
+	}
+	c.h.recordReceipt(info, sessionID(ctx))
+	return withPaymentInfo(ctx, info), info, nil
+}
+
+// Settle completes a paid request once it has been served. Per-item payments
+// are settled for the items of resp; if that fails the result is withheld and a
+// settlement error returned instead. The settlement is then added to the _meta
+// of successful results, as X402Handler does.
+func (c *PaymentCore) Settle(ctx context.Context, info *PaymentInfo, resp transport.JSONRPCResponse) transport.JSONRPCResponse {
+	if info.Settlement == nil {
+		body, _ := json.Marshal(resp)
+		if err := c.h.settlePerItem(ctx, info, body, "application/json"); err != nil {
+			c.h.verbosef("[X402] Per-item settlement failed: %v", err)
+			return *errorResponse(resp.ID, settlementFailedError(info.Requirement, fmt.Sprintf("Payment settlement failed: %v", err)))
+		}
+		c.h.recordReceipt(info, sessionID(ctx))
+	}
+	resp, _ = c.h.addSettlementToResponse(ctx, resp, info, info.settlementResponse())
+	return resp
+}
+
+// errorResponse returns a JSON-RPC error response for the request id
+func errorResponse(id mcp.RequestId, rpcError *mcp.JSONRPCErrorDetails) *transport.JSONRPCResponse {
+	return &transport.JSONRPCResponse{JSONRPC: "2.0", ID: id, Error: rpcError}
+}
+
+// sessionID returns the ID of the MCP session in ctx, if any
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestPaymentCore(t *testing.T) {
+	facilitator := &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test"},
+	}
+	core := NewPaymentCore(&Config{
+		Facilitator: facilitator,
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{
+				Scheme:            "exact",
+				Network:           "test",
+				MaxAmountRequired: "1000",
+				Asset:             "0xusdc",
+				PayTo:             "0xrecipient",
+				Resource:          "mcp://tools/paid-tool",
+				MaxTimeoutSeconds: 60,
+			}},
+		},
+	})
+	ctx := context.Background()
+	call := func(meta map[string]any) transport.JSONRPCRequest {
+		params := map[string]any{"name": "paid-tool"}
+		if meta != nil {
+			params["_meta"] = meta
+		}
+		return transport.JSONRPCRequest{JSONRPC: "2.0", ID: mcp.NewRequestId(int64(1)), Method: "tools/call", Params: params}
+	}
+
+	// Free methods pass through
+	if _, info, resp := core.Handle(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(int64(1)), Method: "tools/list"}); info != nil || resp != nil {
+		t.Fatalf("tools/list should pass through, got %+v", resp)
+	}
+
+	// Unpaid calls are answered with a 402
+	_, _, resp := core.Handle(ctx, call(nil))
+	if resp == nil || resp.Error == nil || resp.Error.Code != DefaultPaymentRequiredCode {
+		t.Fatalf("Expected a 402 response, got %+v", resp)
+	}
+
+	payment := map[string]any{
+		"x402Version": 1,
+		"scheme":      "exact",
+		"network":     "test",
+		"payload":     map[string]any{"signature": "0xsig"},
+	}
+	req := call(map[string]any{"x402/payment": payment})
+	extracted, err := core.ExtractPayment(req)
+	if err != nil || extracted == nil || extracted.Network != "test" {
+		t.Fatalf("ExtractPayment() = %+v, %v", extracted, err)
+	}
+
+	paidCtx, info, resp := core.Handle(ctx, req)
+	if resp != nil {
+		t.Fatalf("Expected the payment to be accepted, got %+v", resp.Error)
+	}
+	if !facilitator.verifyCalled || !facilitator.settleCalled {
+		t.Error("Payment should have been verified and settled")
+	}
+	if fromCtx, ok := PaymentFromContext(paidCtx); !ok || fromCtx != info {
+		t.Error("Payment should be attached to the returned context")
+	}
+	if requirement, ok := RequirementFromContext(paidCtx); !ok || requirement.MaxAmountRequired != "1000" || requirement.Asset != "0xusdc" {
+		t.Errorf("Expected the paid requirement in the context, got %+v", requirement)
+	}
+	if verification, ok := VerificationFromContext(paidCtx); !ok || verification.Payer != "0xpayer" {
+		t.Errorf("Expected the verification in the context, got %+v", verification)
+	}
+	if timing, ok := PaymentTimingFromContext(paidCtx); !ok || timing.SettledAt.IsZero() || timing.Settle < 0 {
+		t.Errorf("Expected settlement timing in the context, got %+v", timing)
+	}
+	if _, ok := RequirementFromContext(ctx); ok {
+		t.Error("Unpaid context should carry no requirement")
+	}
+
+	result, _ := json.Marshal(mcp.NewToolResultText("success"))
+	settled := core.Settle(paidCtx, info, transport.JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+	var decoded struct {
+		Meta map[string]SettlementResponse `json:"_meta"`
+	}
+	if err := json.Unmarshal(settled.Result, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Meta["x402/payment-response"]; !got.Success || got.Transaction != "0xtx" {
+		t.Errorf("Expected the settlement in _meta, got %+v", decoded.Meta)
+	}
+}
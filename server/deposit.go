@@ -0,0 +1,371 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// DepositTransaction is the settlement transaction reported for tool calls
+// drawn from a deposit, which settle nothing on chain
+const DepositTransaction = "deposit"
+
+// DepositAccount is a prepaid deposit being withdrawn
+type DepositAccount struct {
+	Token    string
+	Payer    string
+	Balances []x402.DepositFunds
+}
+
+// depositFund is an asset a deposit holds a balance in
+type depositFund struct {
+	Network string `json:"network"`
+	Asset   string `json:"asset"`
+}
+
+// depositRecord is the stored account of a deposit; balances are counters
+// under "token:network:asset"
+type depositRecord struct {
+	Payer string        `json:"payer"`
+	Funds []depositFund `json:"funds"`
+}
+
+// deposits stores prepaid deposits by token. Deposits never expire.
+type deposits struct {
+	store Store
+}
+
+func newDeposits(store Store) *deposits {
+	return &deposits{store: NewNamespacedStore(store, StoreNamespaceDeposit)}
+}
+
+// account returns the record of a deposit, or nil when there is none
+func (d *deposits) account(ctx context.Context, token string) (*depositRecord, error) {
+	if token == "" {
+		return nil, nil
+	}
+	raw, err := d.store.Get(ctx, token)
+	if err != nil || raw == nil {
+		return nil, err
+	}
+	var record depositRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, fmt.Errorf("decode deposit: %w", err)
+	}
+	return &record, nil
+}
+
+// credit adds amount of an asset to the deposit with token, opening a new
+// deposit when token is empty or unknown, and returns the deposit's token
+func (d *deposits) credit(ctx context.Context, token, payer string, fund depositFund, amount int64) (string, error) {
+	record, err := d.account(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	if record == nil {
+		tokenBytes := make([]byte, 16)
+		if _, err := rand.Read(tokenBytes); err != nil {
+			return "", fmt.Errorf("generate deposit token: %w", err)
+		}
+		token = hex.EncodeToString(tokenBytes)
+		record = &depositRecord{Payer: payer}
+	}
+
+	if _, err := d.store.IncrBy(ctx, fundKey(token, fund), amount, 0); err != nil {
+		return "", fmt.Errorf("credit deposit: %w", err)
+	}
+	if !record.holds(fund) {
+		record.Funds = append(record.Funds, fund)
+	}
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("encode deposit: %w", err)
+	}
+	if err := d.store.Set(ctx, token, raw, 0); err != nil {
+		return "", fmt.Errorf("store deposit: %w", err)
+	}
+	return token, nil
+}
+
+// draw takes the price of the first of requirements the deposit can cover from
+// it, returning the requirement charged and the deposit's payer
+func (d *deposits) draw(ctx context.Context, token string, requirements []PaymentRequirement) (*PaymentRequirement, string, error) {
+	record, err := d.account(ctx, token)
+	if err != nil || record == nil {
+		return nil, "", err
+	}
+	for i := range requirements {
+		req := &requirements[i]
+		fund, ok := record.fundFor(req.Network, req.Asset)
+		if !ok || req.PerItem != nil {
+			continue
+		}
+		price, err := strconv.ParseInt(req.MaxAmountRequired, 10, 64)
+		if err != nil || price < 0 {
+			continue
+		}
+		left, err := d.store.IncrBy(ctx, fundKey(token, fund), -price, 0)
+		if err != nil {
+			return nil, "", fmt.Errorf("draw from deposit: %w", err)
+		}
+		if left >= 0 {
+			return req, record.Payer, nil
+		}
+		// Not enough left; put the price back
+		if _, err := d.store.IncrBy(ctx, fundKey(token, fund), price, 0); err != nil {
+			return nil, "", fmt.Errorf("restore deposit: %w", err)
+		}
+	}
+	return nil, "", nil
+}
+
+// balances returns the balances of a deposit
+func (d *deposits) balances(ctx context.Context, token string, record *depositRecord) ([]x402.DepositFunds, error) {
+	balances := make([]x402.DepositFunds, 0, len(record.Funds))
+	for _, fund := range record.Funds {
+		amount, err := d.store.IncrBy(ctx, fundKey(token, fund), 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("read deposit: %w", err)
+		}
+		balances = append(balances, x402.DepositFunds{Network: fund.Network, Asset: fund.Asset, Amount: strconv.FormatInt(amount, 10)})
+	}
+	return balances, nil
+}
+
+// withdraw closes a deposit and empties its balances, calling refund with what
+// they held. When refund fails the deposit is restored.
+func (d *deposits) withdraw(ctx context.Context, token string, refund func(DepositAccount) (string, error)) (*x402.Deposit, error) {
+	record, err := d.account(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, fmt.Errorf("unknown deposit")
+	}
+	raw, _ := json.Marshal(record)
+
+	// Closed first, so no new draws start
+	if err := d.store.Delete(ctx, token); err != nil {
+		return nil, fmt.Errorf("close deposit: %w", err)
+	}
+	account := DepositAccount{Token: token, Payer: record.Payer}
+	amounts := make([]int64, len(record.Funds))
+	for i, fund := range record.Funds {
+		key := fundKey(token, fund)
+		balance, err := d.store.IncrBy(ctx, key, 0, 0)
+		if err == nil {
+			var left int64
+			if left, err = d.store.IncrBy(ctx, key, -balance, 0); err == nil && left < 0 {
+				// A draw started before the deposit closed took part of the balance
+				_, err = d.store.IncrBy(ctx, key, -left, 0)
+				balance += left
+			}
+		}
+		if err != nil {
+			d.restore(ctx, token, raw, record.Funds[:i], amounts)
+			return nil, fmt.Errorf("empty deposit: %w", err)
+		}
+		amounts[i] = balance
+		account.Balances = append(account.Balances, x402.DepositFunds{Network: fund.Network, Asset: fund.Asset, Amount: strconv.FormatInt(balance, 10)})
+	}
+
+	tx, err := refund(account)
+	if err != nil {
+		d.restore(ctx, token, raw, record.Funds, amounts)
+		return nil, err
+	}
+	for _, fund := range record.Funds {
+		_ = d.store.Delete(ctx, fundKey(token, fund))
+	}
+	return &x402.Deposit{Balances: account.Balances, Transaction: tx}, nil
+}
+
+// restore puts back the balances taken from a deposit being withdrawn and reopens it
+func (d *deposits) restore(ctx context.Context, token string, raw []byte, funds []depositFund, amounts []int64) {
+	for i, fund := range funds {
+		_, _ = d.store.IncrBy(ctx, fundKey(token, fund), amounts[i], 0)
+	}
+	_ = d.store.Set(ctx, token, raw, 0)
+}
+
+// holds reports whether the deposit has a balance in fund
+func (r *depositRecord) holds(fund depositFund) bool {
+	_, ok := r.fundFor(fund.Network, fund.Asset)
+	return ok
+}
+
+// fundFor returns the fund of the deposit in asset on network
+func (r *depositRecord) fundFor(network, asset string) (depositFund, bool) {
+	for _, fund := range r.Funds {
+		if fund.Network == network && strings.EqualFold(fund.Asset, asset) {
+			return fund, true
+		}
+	}
+	return depositFund{}, false
+}
+
+// fundKey is the store key of a deposit's balance in fund
+func fundKey(token string, fund depositFund) string {
+	return token + ":" + fund.Network + ":" + fund.Asset
+}
+
+// isDepositMethod reports whether method is one of the deposit methods
+func isDepositMethod(method string) bool {
+	return method == x402.MethodDeposit || method == x402.MethodDepositBalance || method == x402.MethodDepositWithdraw
+}
+
+// depositRequirements returns the deposit requirements with the deposit
+// resource set. Options whose amount can't be tracked as a balance are left out.
+func (h *X402Handler) depositRequirements() []PaymentRequirement {
+	requirements := make([]PaymentRequirement, 0, len(h.config.DepositRequirements))
+	for _, req := range h.config.DepositRequirements {
+		if amount, err := strconv.ParseInt(req.MaxAmountRequired, 10, 64); err != nil || amount <= 0 {
+			h.logf("[X402] Ignoring deposit option on %s: amount %q is not a positive int64", req.Network, req.MaxAmountRequired)
+			continue
+		}
+		req.Resource = x402.DepositResource
+		if req.MimeType == "" {
+			req.MimeType = "application/json"
+		}
+		req.PerItem = nil // Deposits are paid in full
+		requirements = append(requirements, req)
+	}
+	return requirements
+}
+
+// handleDeposit answers the deposit methods
+func (h *X402Handler) handleDeposit(w http.ResponseWriter, r *http.Request, jsonrpcReq transport.JSONRPCRequest) {
+	var params struct {
+		Token string `json:"token"`
+	}
+	paramsBytes, _ := json.Marshal(jsonrpcReq.Params)
+	_ = json.Unmarshal(paramsBytes, &params)
+	ctx := r.Context()
+
+	switch jsonrpcReq.Method {
+	case x402.MethodDeposit:
+		h.handleDepositPayment(w, r, jsonrpcReq, params.Token)
+
+	case x402.MethodDepositBalance:
+		record, err := h.deposits.account(ctx, params.Token)
+		if err != nil || record == nil {
+			h.sendInvalidParamsError(w, jsonrpcReq.ID, "Unknown deposit")
+			return
+		}
+		balances, err := h.deposits.balances(ctx, params.Token, record)
+		if err != nil {
+			h.verbosef("[X402] Failed to read deposit: %v", err)
+			h.sendInternalError(w, jsonrpcReq.ID, "Failed to read deposit")
+			return
+		}
+		writeJSONRPCResult(w, jsonrpcReq.ID, x402.Deposit{Token: params.Token, Balances: balances})
+
+	case x402.MethodDepositWithdraw:
+		if h.config.OnDepositWithdraw == nil {
+			h.sendInvalidParamsError(w, jsonrpcReq.ID, "Deposit withdrawals are not supported")
+			return
+		}
+		withdrawn, err := h.deposits.withdraw(ctx, params.Token, func(account DepositAccount) (string, error) {
+			return h.config.OnDepositWithdraw(ctx, account)
+		})
+		if err != nil {
+			h.verbosef("[X402] Deposit withdrawal failed: %v", err)
+			h.sendInvalidParamsError(w, jsonrpcReq.ID, fmt.Sprintf("Withdrawal failed: %v", err))
+			return
+		}
+		h.debugf(ctx, "[X402] Deposit withdrawn, refund tx: %s", h.redact(withdrawn.Transaction))
+		writeJSONRPCResult(w, jsonrpcReq.ID, withdrawn)
+	}
+}
+
+// handleDepositPayment takes a deposit payment and credits it to the deposit
+// with token, or to a new deposit
+func (h *X402Handler) handleDepositPayment(w http.ResponseWriter, r *http.Request, jsonrpcReq transport.JSONRPCRequest, token string) {
+	requirements := h.depositRequirements()
+	if len(requirements) == 0 {
+		h.sendInternalError(w, jsonrpcReq.ID, "No deposit options configured")
+		return
+	}
+	meta := h.withPayment(r, requestMeta(jsonrpcReq.Params))
+	if meta[x402.MetaKeyPayment] == nil {
+		h.sendPaymentRequiredError(r.Context(), w, jsonrpcReq.ID, requirements)
+		return
+	}
+
+	// Deposits are only credited once settled
+	info, ok := h.processPayment(w, r.WithContext(withSettlementWait(r.Context())), jsonrpcReq, meta, requirements)
+	if !ok {
+		return
+	}
+
+	// Parsed when the option was offered
+	amount, _ := strconv.ParseInt(info.Requirement.MaxAmountRequired, 10, 64)
+	fund := depositFund{Network: info.Requirement.Network, Asset: info.Requirement.Asset}
+	token, err := h.deposits.credit(r.Context(), token, info.payer, fund, amount)
+	if err != nil {
+		// The payment has settled; log it loudly for the operator to credit by hand
+		h.logf("[X402] Failed to credit deposit of %d %s on %s from %s (tx %s): %v",
+			amount, fund.Asset, fund.Network, info.payer, info.Settlement.Transaction, err)
+		h.sendInternalError(w, jsonrpcReq.ID, "Failed to credit deposit")
+		return
+	}
+	record, err := h.deposits.account(r.Context(), token)
+	if err != nil || record == nil {
+		h.sendInternalError(w, jsonrpcReq.ID, "Failed to read deposit")
+		return
+	}
+	balances, err := h.deposits.balances(r.Context(), token, record)
+	if err != nil {
+		h.sendInternalError(w, jsonrpcReq.ID, "Failed to read deposit")
+		return
+	}
+	h.debugf(r.Context(), "[X402] Deposit of %d %s on %s credited", amount, fund.Asset, fund.Network)
+
+	writeJSONRPCResult(w, jsonrpcReq.ID, map[string]any{
+		"token":    token,
+		"balances": balances,
+		"_meta": map[string]any{
+			h.config.MetaNamespace.OrDefault().PaymentResponse: info.settlementResponse(),
+		},
+	})
+}
+
+// drawFromDeposit pays a tool call from the deposit whose token it carries,
+// returning the payment to forward it with
+func (h *X402Handler) drawFromDeposit(ctx context.Context, token string, requirements []PaymentRequirement, onBehalfOf string) (*PaymentInfo, bool) {
+	requirement, payer, err := h.deposits.draw(ctx, token, requirements)
+	if err != nil {
+		h.verbosef("[X402] Failed to draw from deposit: %v", err)
+		return nil, false
+	}
+	if requirement == nil {
+		return nil, false
+	}
+
+	info := &PaymentInfo{
+		Requirement: requirement,
+		Settlement: &SettleResponse{
+			Success:     true,
+			Transaction: DepositTransaction,
+			Network:     requirement.Network,
+			Payer:       payer,
+		},
+		OnBehalfOf: onBehalfOf,
+		RequestID:  requestIDFromContext(ctx),
+		payer:      payer,
+	}
+	if record, err := h.deposits.account(ctx, token); err == nil && record != nil {
+		if balances, err := h.deposits.balances(ctx, token, record); err == nil {
+			info.depositBalance = &x402.Deposit{Balances: balances}
+		}
+	}
+	return info, true
+}
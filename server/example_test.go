@@ -0,0 +1,61 @@
+package server_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http/httptest"
+
+	"github.com/mark3labs/mcp-go-x402"
+	x402server "github.com/mark3labs/mcp-go-x402/server"
+	"github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func ExampleX402Server_AddPayableTool() {
+	facilitator := x402server.NewSandboxFacilitator()
+	srv := x402server.NewX402Server("weather", "1.0.0", &x402server.Config{Facilitator: facilitator})
+
+	// Forecasts cost 0.005 fake USDC; the handler only runs once the payment is verified
+	srv.AddPayableTool(
+		mcp.NewTool("forecast", mcp.WithDescription("Tomorrow's forecast")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			payment, _ := x402server.PaymentFromContext(ctx)
+			return mcp.NewToolResultText("sunny, paid on " + payment.Requirement.Network), nil
+		},
+		x402server.RequireUSDCSandbox("0x209693Bc6afc0C5328bA36FaF03C514EF312287C", "5000", "Forecast"),
+	)
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+
+	// A client paying with a throwaway sandbox wallet
+	signer, err := x402.NewSandboxSigner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	transport, err := x402.New(x402.Config{ServerURL: httpServer.URL, Signers: []x402.PaymentSigner{signer}})
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+	mcpClient := client.NewClient(transport)
+	defer mcpClient.Close()
+	if err := mcpClient.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := mcpClient.Initialize(ctx, mcp.InitializeRequest{}); err != nil {
+		log.Fatal(err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "forecast"
+	result, err := mcpClient.CallTool(ctx, request)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(result.Content[0].(mcp.TextContent).Text)
+	fmt.Println("Settlements:", len(facilitator.Settled()))
+	// Output:
+	// sunny, paid on sandbox
+	// Settlements: 1
+}
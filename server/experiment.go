@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// PricingExperiment offers alternative requirements for some tools to a share of
+// callers, to measure how price affects demand. Assignment is stable per payer
+// identity, or per session for anonymous callers; callers with neither get the
+// control prices. Payments are labeled "name/control" or "name/variant" in receipts.
+type PricingExperiment struct {
+	// Name identifies the experiment in labels; it also seeds assignment
+	Name string
+
+	// Percent of callers (0-100) offered the variant prices
+	Percent int
+
+	// Variants maps tool names to their alternative requirements
+	Variants map[string][]PaymentRequirement
+}
+
+// Experiment arms
+const (
+	ExperimentControl = "control"
+	ExperimentVariant = "variant"
+)
+
+// inVariant assigns a subject to the variant, stably for the experiment's name
+func (e *PricingExperiment) inVariant(subject string) bool {
+	if subject == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(e.Name + "\x00" + subject))
+	return int(binary.BigEndian.Uint64(sum[:8])%100) < e.Percent
+}
+
+type experimentSubjectKey struct{}
+
+// withExperimentSubject attaches the caller experiments are assigned by
+func withExperimentSubject(ctx context.Context, r *http.Request) context.Context {
+	subject := r.Header.Get(server.HeaderKeySessionID)
+	if identity, ok := IdentityFromContext(ctx); ok {
+		subject = strings.ToLower(identity.Address)
+	}
+	return context.WithValue(ctx, experimentSubjectKey{}, subject)
+}
+
+// applyExperiments returns the requirements of a tool under the first experiment
+// with a variant for it, labeled with the caller's arm
+func (c *Config) applyExperiments(ctx context.Context, toolName string, requirements []PaymentRequirement) []PaymentRequirement {
+	for i := range c.PricingExperiments {
+		experiment := &c.PricingExperiments[i]
+		variant, ok := experiment.Variants[toolName]
+		if !ok || len(variant) == 0 {
+			continue
+		}
+
+		arm := ExperimentControl
+		subject, _ := ctx.Value(experimentSubjectKey{}).(string)
+		if experiment.inVariant(subject) {
+			arm = ExperimentVariant
+			requirements = c.prepareRequirements(toolName, variant)
+		}
+		for j := range requirements {
+			requirements[j].Experiment = experiment.Name + "/" + arm
+		}
+		return requirements
+	}
+	return requirements
+}
@@ -8,7 +8,10 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"sync"
 	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
 )
 
 // Facilitator interface for payment verification and settlement
@@ -26,11 +29,51 @@ type SupportedKind struct {
 	Extra       map[string]string `json:"extra,omitempty"`
 }
 
+// UnmarshalJSON decodes a supported kind, tolerating non-string extra values:
+// numbers and booleans are stringified, nested values skipped
+func (k *SupportedKind) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		X402Version int            `json:"x402Version"`
+		Scheme      string         `json:"scheme"`
+		Network     string         `json:"network"`
+		Extra       map[string]any `json:"extra,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	k.X402Version = raw.X402Version
+	k.Scheme = raw.Scheme
+	k.Network = raw.Network
+	k.Extra = nil
+	if raw.Extra != nil {
+		k.Extra = make(map[string]string, len(raw.Extra))
+		for key, value := range raw.Extra {
+			switch v := value.(type) {
+			case string:
+				k.Extra[key] = v
+			case float64, bool:
+				k.Extra[key] = fmt.Sprint(v)
+			}
+		}
+	}
+	return nil
+}
+
 // HTTPFacilitator implements Facilitator using HTTP API
 type HTTPFacilitator struct {
 	baseURL string
 	client  *http.Client
+	auth    *FacilitatorAuth
+	codec   x402.Codec
 	verbose bool
+
+	// Cached /supported response, revalidated with If-None-Match
+	supportedTTL     time.Duration
+	supportedMu      sync.Mutex
+	supportedKinds   []SupportedKind
+	supportedETag    string
+	supportedFetched time.Time
 }
 
 // NewHTTPFacilitator creates a new HTTP-based facilitator client
@@ -48,6 +91,57 @@ func (f *HTTPFacilitator) SetVerbose(verbose bool) {
 	f.verbose = verbose
 }
 
+// SetAuth sets the credentials sent with every request
+func (f *HTTPFacilitator) SetAuth(auth *FacilitatorAuth) {
+	f.auth = auth
+}
+
+// SetCodec sets the encoding of request and response bodies, for facilitators
+// expecting CBOR or protobuf instead of JSON (the default when nil)
+func (f *HTTPFacilitator) SetCodec(codec x402.Codec) {
+	f.codec = codec
+}
+
+// encode marshals a request body with the facilitator's codec
+func (f *HTTPFacilitator) encode(v any) ([]byte, error) {
+	return x402.CodecOrJSON(f.codec).Marshal(v)
+}
+
+// decode unmarshals a response body with the facilitator's codec
+func (f *HTTPFacilitator) decode(body io.Reader, v any) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return x402.CodecOrJSON(f.codec).Unmarshal(data, v)
+}
+
+// setContentHeaders declares the codec's content type on a request
+func (f *HTTPFacilitator) setContentHeaders(req *http.Request, hasBody bool) {
+	contentType := x402.CodecOrJSON(f.codec).ContentType()
+	if hasBody {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if !x402.IsJSONCodec(f.codec) {
+		req.Header.Set("Accept", contentType)
+	}
+}
+
+// SetSupportedCacheTTL sets how long GetSupported results are served from cache
+// before revalidating with the facilitator. Zero revalidates on every call.
+func (f *HTTPFacilitator) SetSupportedCacheTTL(ttl time.Duration) {
+	f.supportedMu.Lock()
+	defer f.supportedMu.Unlock()
+	f.supportedTTL = ttl
+}
+
+// setAuthHeaders adds the configured credentials to a request
+func (f *HTTPFacilitator) setAuthHeaders(req *http.Request, body []byte) {
+	for name, value := range f.auth.headers(req.Method, req.URL.Path, body) {
+		req.Header.Set(name, value)
+	}
+}
+
 // Verify validates a payment against the given requirement
 func (f *HTTPFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
 	req := &VerifyRequest{
@@ -70,7 +164,7 @@ func (f *HTTPFacilitator) Verify(ctx context.Context, payment *PaymentPayload, r
 		}
 	}
 
-	body, err := json.Marshal(req)
+	body, err := f.encode(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal verify request: %w", err)
 	}
@@ -79,7 +173,8 @@ func (f *HTTPFacilitator) Verify(ctx context.Context, payment *PaymentPayload, r
 	if err != nil {
 		return nil, fmt.Errorf("create verify request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	f.setContentHeaders(httpReq, true)
+	f.setAuthHeaders(httpReq, body)
 
 	resp, err := f.client.Do(httpReq)
 	if err != nil {
@@ -110,7 +205,7 @@ func (f *HTTPFacilitator) Verify(ctx context.Context, payment *PaymentPayload, r
 	}
 
 	var verifyResp VerifyResponse
-	if err := json.NewDecoder(resp.Body).Decode(&verifyResp); err != nil {
+	if err := f.decode(resp.Body, &verifyResp); err != nil {
 		return nil, fmt.Errorf("decode verify response: %w", err)
 	}
 
@@ -133,7 +228,7 @@ func (f *HTTPFacilitator) Settle(ctx context.Context, payment *PaymentPayload, r
 		PaymentRequirements: requirement,
 	}
 
-	body, err := json.Marshal(req)
+	body, err := f.encode(req)
 	if err != nil {
 		return nil, fmt.Errorf("marshal settle request: %w", err)
 	}
@@ -142,7 +237,8 @@ func (f *HTTPFacilitator) Settle(ctx context.Context, payment *PaymentPayload, r
 	if err != nil {
 		return nil, fmt.Errorf("create settle request: %w", err)
 	}
-	httpReq.Header.Set("Content-Type", "application/json")
+	f.setContentHeaders(httpReq, true)
+	f.setAuthHeaders(httpReq, body)
 
 	resp, err := f.client.Do(httpReq)
 	if err != nil {
@@ -157,19 +253,32 @@ func (f *HTTPFacilitator) Settle(ctx context.Context, payment *PaymentPayload, r
 	}
 
 	var settleResp SettleResponse
-	if err := json.NewDecoder(resp.Body).Decode(&settleResp); err != nil {
+	if err := f.decode(resp.Body, &settleResp); err != nil {
 		return nil, fmt.Errorf("decode settle response: %w", err)
 	}
 
 	return &settleResp, nil
 }
 
-// GetSupported retrieves the list of supported payment schemes and networks
+// GetSupported retrieves the list of supported payment schemes and networks.
+// Responses are cached; stale entries are revalidated using the ETag.
 func (f *HTTPFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	f.supportedMu.Lock()
+	defer f.supportedMu.Unlock()
+
+	if f.supportedKinds != nil && time.Since(f.supportedFetched) < f.supportedTTL {
+		return cloneSupportedKinds(f.supportedKinds), nil
+	}
+
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", f.baseURL+"/supported", nil)
 	if err != nil {
 		return nil, fmt.Errorf("create supported request: %w", err)
 	}
+	f.setAuthHeaders(httpReq, nil)
+	f.setContentHeaders(httpReq, false)
+	if f.supportedETag != "" && f.supportedKinds != nil {
+		httpReq.Header.Set("If-None-Match", f.supportedETag)
+	}
 
 	resp, err := f.client.Do(httpReq)
 	if err != nil {
@@ -177,6 +286,14 @@ func (f *HTTPFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, er
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && f.supportedKinds != nil {
+		if f.verbose {
+			log.Printf("[Facilitator] Supported kinds not modified, using cache")
+		}
+		f.supportedFetched = time.Now()
+		return cloneSupportedKinds(f.supportedKinds), nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("supported failed with status %d", resp.StatusCode)
 	}
@@ -184,9 +301,26 @@ func (f *HTTPFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, er
 	var result struct {
 		Kinds []SupportedKind `json:"kinds"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := f.decode(resp.Body, &result); err != nil {
 		return nil, fmt.Errorf("decode supported response: %w", err)
 	}
 
-	return result.Kinds, nil
+	if result.Kinds == nil {
+		result.Kinds = []SupportedKind{}
+	}
+	f.supportedKinds = result.Kinds
+	f.supportedETag = resp.Header.Get("ETag")
+	f.supportedFetched = time.Now()
+
+	return cloneSupportedKinds(result.Kinds), nil
+}
+
+// cloneSupportedKinds copies kinds so callers can't mutate the cache
+func cloneSupportedKinds(kinds []SupportedKind) []SupportedKind {
+	out := make([]SupportedKind, len(kinds))
+	for i, kind := range kinds {
+		out[i] = kind
+		out[i].Extra = cloneStringMap(kind.Extra)
+	}
+	return out
 }
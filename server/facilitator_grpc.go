@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// FacilitatorSchemeHTTP selects the HTTP facilitator (default)
+	FacilitatorSchemeHTTP = "http"
+	// FacilitatorSchemeGRPC selects the gRPC facilitator
+	FacilitatorSchemeGRPC = "grpc"
+
+	// grpcFacilitatorService is the gRPC service exposed by facilitators
+	grpcFacilitatorService = "/x402.facilitator.v1.Facilitator/"
+
+	// defaultFacilitatorTimeout bounds facilitator calls whose context has no deadline
+	defaultFacilitatorTimeout = 30 * time.Second
+)
+
+// GRPCFacilitatorConfig configures a GRPCFacilitator
+type GRPCFacilitatorConfig struct {
+	// Target is the facilitator address, e.g. "facilitator.example.com:443"
+	Target string
+
+	// TLSConfig is used for the connection; nil uses the system roots
+	TLSConfig *tls.Config
+
+	// Insecure disables TLS (local development only)
+	Insecure bool
+
+	// Timeout applies to calls whose context has no deadline (30s when zero)
+	Timeout time.Duration
+
+	// Auth holds credentials sent as request metadata
+	Auth *FacilitatorAuth
+}
+
+// GRPCFacilitator implements Facilitator over gRPC. Messages use the same JSON
+// bodies as the HTTP API (content subtype application/grpc+json). A single
+// connection is reused for all calls and context deadlines are propagated.
+type GRPCFacilitator struct {
+	conn    *grpc.ClientConn
+	target  string
+	timeout time.Duration
+	auth    *FacilitatorAuth
+	verbose bool
+}
+
+// NewGRPCFacilitator creates a gRPC facilitator client. The connection is
+// established lazily on the first call.
+func NewGRPCFacilitator(config GRPCFacilitatorConfig) (*GRPCFacilitator, error) {
+	target := config.Target
+	for _, prefix := range []string{"grpcs://", "grpc://"} {
+		target = strings.TrimPrefix(target, prefix)
+	}
+	if target == "" {
+		return nil, fmt.Errorf("grpc facilitator target is required")
+	}
+
+	creds := credentials.NewTLS(config.TLSConfig)
+	if config.Insecure {
+		creds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create grpc facilitator client: %w", err)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultFacilitatorTimeout
+	}
+
+	return &GRPCFacilitator{
+		conn:    conn,
+		target:  target,
+		timeout: timeout,
+		auth:    config.Auth,
+	}, nil
+}
+
+// SetVerbose enables verbose logging
+func (f *GRPCFacilitator) SetVerbose(verbose bool) {
+	f.verbose = verbose
+}
+
+// Close closes the underlying connection
+func (f *GRPCFacilitator) Close() error {
+	return f.conn.Close()
+}
+
+// Verify validates a payment against the given requirement
+func (f *GRPCFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	req := &VerifyRequest{
+		X402Version:         1,
+		PaymentPayload:      payment,
+		PaymentRequirements: requirement,
+	}
+
+	var verifyResp VerifyResponse
+	if err := f.invoke(ctx, "Verify", req, &verifyResp); err != nil {
+		return nil, fmt.Errorf("verify request failed: %w", err)
+	}
+
+	if f.verbose {
+		log.Printf("[Facilitator] gRPC verify: valid=%v, payer=%s, reason=%s",
+			verifyResp.IsValid, verifyResp.Payer, verifyResp.InvalidReason)
+	}
+
+	return &verifyResp, nil
+}
+
+// Settle processes a payment settlement for the given requirement
+func (f *GRPCFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	req := &SettleRequest{
+		X402Version:         1,
+		PaymentPayload:      payment,
+		PaymentRequirements: requirement,
+	}
+
+	var settleResp SettleResponse
+	if err := f.invoke(ctx, "Settle", req, &settleResp); err != nil {
+		return nil, fmt.Errorf("settle request failed: %w", err)
+	}
+
+	return &settleResp, nil
+}
+
+// GetSupported retrieves the list of supported payment schemes and networks
+func (f *GRPCFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	var result struct {
+		Kinds []SupportedKind `json:"kinds"`
+	}
+	if err := f.invoke(ctx, "Supported", struct{}{}, &result); err != nil {
+		return nil, fmt.Errorf("supported request failed: %w", err)
+	}
+
+	return result.Kinds, nil
+}
+
+// invoke calls a facilitator method, applying the default timeout when ctx has no deadline
+func (f *GRPCFacilitator) invoke(ctx context.Context, method string, req, resp any) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	if f.verbose {
+		log.Printf("[Facilitator] Sending gRPC %s request to %s", method, f.target)
+	}
+
+	fullMethod := grpcFacilitatorService + method
+	if f.auth != nil {
+		body, err := jsonCodec{}.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("marshal %s request: %w", method, err)
+		}
+		for name, value := range f.auth.headers("POST", fullMethod, body) {
+			ctx = metadata.AppendToOutgoingContext(ctx, name, value)
+		}
+	}
+
+	return f.conn.Invoke(ctx, fullMethod, req, resp)
+}
+
+// jsonCodec encodes gRPC messages as JSON
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// failingFacilitator reports a facilitator configuration error on every call
+type failingFacilitator struct {
+	err error
+}
+
+func (f failingFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	return nil, f.err
+}
+
+func (f failingFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	return nil, f.err
+}
+
+func (f failingFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	return nil, f.err
+}
+
+// sharedFacilitator returns the facilitator client of config, created on first
+// use, so FetchSupportedPayments and handlers share its /supported cache
+func (c *Config) sharedFacilitator() Facilitator {
+	c.facilitatorOnce.Do(func() {
+		c.facilitator = newFacilitator(c)
+	})
+	return c.facilitator
+}
+
+// newFacilitator creates the facilitator client selected by config.FacilitatorScheme,
+// unless config.Facilitator is set, with config.SelfSettlers settling their networks
+func newFacilitator(config *Config) Facilitator {
+	return withSelfSettlers(newFacilitatorClient(config), config)
+}
+
+func newFacilitatorClient(config *Config) Facilitator {
+	if config.Facilitator != nil {
+		return config.Facilitator
+	}
+	switch config.FacilitatorScheme {
+	case "", FacilitatorSchemeHTTP:
+		facilitator := NewHTTPFacilitator(config.FacilitatorURL)
+		facilitator.SetAuth(config.FacilitatorAuth)
+		facilitator.SetSupportedCacheTTL(config.SupportedCacheTTL)
+		facilitator.SetCodec(config.FacilitatorCodec)
+		facilitator.SetVerbose(config.facilitatorVerbose())
+		return facilitator
+	case FacilitatorSchemeGRPC:
+		facilitator, err := NewGRPCFacilitator(GRPCFacilitatorConfig{
+			Target:    config.FacilitatorURL,
+			TLSConfig: config.FacilitatorTLSConfig,
+			Insecure:  config.FacilitatorInsecure,
+			Auth:      config.FacilitatorAuth,
+		})
+		if err != nil {
+			log.Printf("[X402] Invalid gRPC facilitator configuration: %v", err)
+			return failingFacilitator{err: err}
+		}
+		facilitator.SetVerbose(config.facilitatorVerbose())
+		return facilitator
+	default:
+		err := fmt.Errorf("unknown facilitator scheme %q", config.FacilitatorScheme)
+		log.Printf("[X402] %v", err)
+		return failingFacilitator{err: err}
+	}
+}
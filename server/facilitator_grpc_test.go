@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+// grpcTestFacilitator serves a Facilitator over gRPC for tests
+type grpcTestFacilitator struct {
+	facilitator Facilitator
+	deadlines   chan bool
+}
+
+func (s *grpcTestFacilitator) serviceDesc() *grpc.ServiceDesc {
+	return &grpc.ServiceDesc{
+		ServiceName: "x402.facilitator.v1.Facilitator",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{
+				MethodName: "Verify",
+				Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+					var req VerifyRequest
+					if err := dec(&req); err != nil {
+						return nil, err
+					}
+					_, hasDeadline := ctx.Deadline()
+					s.deadlines <- hasDeadline
+					return s.facilitator.Verify(ctx, req.PaymentPayload, req.PaymentRequirements)
+				},
+			},
+			{
+				MethodName: "Settle",
+				Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+					var req SettleRequest
+					if err := dec(&req); err != nil {
+						return nil, err
+					}
+					return s.facilitator.Settle(ctx, req.PaymentPayload, req.PaymentRequirements)
+				},
+			},
+			{
+				MethodName: "Supported",
+				Handler: func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+					kinds, err := s.facilitator.GetSupported(ctx)
+					if err != nil {
+						return nil, err
+					}
+					return map[string]any{"kinds": kinds}, nil
+				},
+			},
+		},
+	}
+}
+
+func TestGRPCFacilitator(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend := &grpcTestFacilitator{
+		facilitator: &MockFacilitator{
+			verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+			settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "base"},
+		},
+		deadlines: make(chan bool, 1),
+	}
+	grpcServer := grpc.NewServer(grpc.ForceServerCodec(jsonCodec{}))
+	grpcServer.RegisterService(backend.serviceDesc(), nil)
+	go func() { _ = grpcServer.Serve(lis) }()
+	defer grpcServer.Stop()
+
+	config := &Config{
+		FacilitatorURL:      "grpc://" + lis.Addr().String(),
+		FacilitatorScheme:   FacilitatorSchemeGRPC,
+		FacilitatorInsecure: true,
+	}
+	facilitator, ok := newFacilitator(config).(*GRPCFacilitator)
+	if !ok {
+		t.Fatalf("Expected GRPCFacilitator for scheme %q", config.FacilitatorScheme)
+	}
+	defer facilitator.Close()
+
+	payment := &PaymentPayload{X402Version: 1, Scheme: "exact", Network: "base"}
+	requirement := &PaymentRequirement{Scheme: "exact", Network: "base", MaxAmountRequired: "1000"}
+
+	verifyResp, err := facilitator.Verify(context.Background(), payment, requirement)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if !verifyResp.IsValid || verifyResp.Payer != "0xpayer" {
+		t.Errorf("Unexpected verify response: %+v", verifyResp)
+	}
+	if !<-backend.deadlines {
+		t.Error("Expected a deadline to be propagated to the facilitator")
+	}
+
+	settleResp, err := facilitator.Settle(context.Background(), payment, requirement)
+	if err != nil {
+		t.Fatalf("Settle failed: %v", err)
+	}
+	if !settleResp.Success || settleResp.Transaction != "0xtx" {
+		t.Errorf("Unexpected settle response: %+v", settleResp)
+	}
+
+	if _, err := facilitator.GetSupported(context.Background()); err != nil {
+		t.Errorf("GetSupported failed: %v", err)
+	}
+
+	// Unknown schemes fail every call instead of silently using HTTP
+	bad := newFacilitator(&Config{FacilitatorScheme: "carrier-pigeon"})
+	if _, err := bad.Verify(context.Background(), payment, requirement); err == nil {
+		t.Error("Expected error for unknown facilitator scheme")
+	}
+}
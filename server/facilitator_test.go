@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPFacilitator_Auth(t *testing.T) {
+	secret := []byte("shared-secret")
+
+	var got http.Header
+	var gotBody []byte
+	facilitatorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(VerifyResponse{IsValid: true, Payer: "0xpayer"})
+	}))
+	defer facilitatorServer.Close()
+
+	facilitator := newFacilitator(&Config{
+		FacilitatorURL: facilitatorServer.URL,
+		FacilitatorAuth: &FacilitatorAuth{
+			APIKey:       "key-123",
+			APIKeyHeader: "X-Facilitator-Key",
+			BearerToken:  "token-456",
+			HMACSecret:   secret,
+			HMACKeyID:    "merchant-1",
+			Headers:      map[string]string{"X-Merchant": "acme"},
+		},
+	})
+
+	_, err := facilitator.Verify(context.Background(),
+		&PaymentPayload{X402Version: 1, Scheme: "exact", Network: "base"},
+		&PaymentRequirement{Scheme: "exact", Network: "base", MaxAmountRequired: "1000"})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if got.Get("X-Facilitator-Key") != "key-123" {
+		t.Errorf("Expected API key header, got %q", got.Get("X-Facilitator-Key"))
+	}
+	if got.Get("Authorization") != "Bearer token-456" {
+		t.Errorf("Expected bearer token, got %q", got.Get("Authorization"))
+	}
+	if got.Get("X-Merchant") != "acme" {
+		t.Errorf("Expected static header, got %q", got.Get("X-Merchant"))
+	}
+	if got.Get(DefaultKeyIDHeader) != "merchant-1" {
+		t.Errorf("Expected key ID header, got %q", got.Get(DefaultKeyIDHeader))
+	}
+
+	// Recompute the signature the way a facilitator would
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(got.Get(DefaultTimestampHeader) + "\nPOST\n/verify\n"))
+	mac.Write(gotBody)
+	if want := hex.EncodeToString(mac.Sum(nil)); got.Get(DefaultSignatureHeader) != want {
+		t.Errorf("Signature mismatch: got %q, want %q", got.Get(DefaultSignatureHeader), want)
+	}
+}
+
+func TestHTTPFacilitator_SupportedCache(t *testing.T) {
+	var requests, notModified int
+	facilitatorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"kinds":[{"x402Version":1,"scheme":"exact","network":"solana",
+			"extra":{"feePayer":"FeePayer111","limits":{"max":5},"decimals":6}}]}`)
+	}))
+	defer facilitatorServer.Close()
+
+	facilitator := NewHTTPFacilitator(facilitatorServer.URL)
+
+	kinds, err := facilitator.GetSupported(context.Background())
+	if err != nil {
+		t.Fatalf("GetSupported failed: %v", err)
+	}
+	if len(kinds) != 1 || kinds[0].Extra["feePayer"] != "FeePayer111" || kinds[0].Extra["decimals"] != "6" {
+		t.Fatalf("Unexpected kinds: %+v", kinds)
+	}
+	if _, ok := kinds[0].Extra["limits"]; ok {
+		t.Errorf("Expected nested extra to be skipped, got %v", kinds[0].Extra)
+	}
+
+	// Zero TTL revalidates with the ETag
+	kinds, err = facilitator.GetSupported(context.Background())
+	if err != nil || len(kinds) != 1 {
+		t.Fatalf("Revalidation failed: %v %+v", err, kinds)
+	}
+	if requests != 2 || notModified != 1 {
+		t.Errorf("Expected conditional revalidation, got %d requests, %d not modified", requests, notModified)
+	}
+
+	// Within the TTL the cache is used without a request
+	facilitator.SetSupportedCacheTTL(time.Hour)
+	if _, err := facilitator.GetSupported(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected cached response, got %d requests", requests)
+	}
+}
+
+// gobCodec encodes messages with encoding/gob, standing in for CBOR or protobuf
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return "application/x-gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func TestHTTPFacilitator_Codec(t *testing.T) {
+	var contentType string
+	var verified VerifyRequest
+	facilitatorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		if err := gob.NewDecoder(r.Body).Decode(&verified); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		_ = gob.NewEncoder(w).Encode(VerifyResponse{IsValid: true, Payer: "0xpayer"})
+	}))
+	defer facilitatorServer.Close()
+
+	facilitator := newFacilitator(&Config{FacilitatorURL: facilitatorServer.URL, FacilitatorCodec: gobCodec{}})
+	resp, err := facilitator.Verify(context.Background(),
+		&PaymentPayload{X402Version: 1, Scheme: "exact", Network: "base"},
+		&PaymentRequirement{Scheme: "exact", Network: "base", MaxAmountRequired: "1000"})
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	if contentType != "application/x-gob" {
+		t.Errorf("Expected the codec's content type, got %q", contentType)
+	}
+	if verified.PaymentRequirements == nil || verified.PaymentRequirements.MaxAmountRequired != "1000" {
+		t.Errorf("Facilitator did not decode the request: %+v", verified)
+	}
+	if !resp.IsValid || resp.Payer != "0xpayer" {
+		t.Errorf("Unexpected verify response: %+v", resp)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// ExtraKeyFeePayer is the requirement Extra key carrying the Solana address that
+// pays transaction fees, which SVM clients build their payment transaction for
+const ExtraKeyFeePayer = "feePayer"
+
+// ErrNoFeePayer is returned for Solana requirements without a fee payer from any source
+var ErrNoFeePayer = errors.New("no fee payer configured")
+
+// WithFeePayer returns a copy of the requirement paying Solana transaction fees
+// from address, overriding Config.FeePayers and the facilitator's /supported
+func (r PaymentRequirement) WithFeePayer(address string) PaymentRequirement {
+	r.FeePayer = address
+	return r
+}
+
+// isSolanaNetwork reports whether payments on network are Solana (SVM) payments
+func isSolanaNetwork(network string) bool {
+	return network == "solana" || network == "solana-devnet"
+}
+
+// resolveFeePayer returns the fee payer of a Solana requirement: its explicit
+// FeePayer, else Config.FeePayers for its network, else the one cached from the
+// facilitator's /supported (when the requirement was built or since). The
+// address must be a valid Solana public key.
+func (c *Config) resolveFeePayer(req PaymentRequirement) (string, error) {
+	feePayer := req.FeePayer
+	if feePayer == "" {
+		feePayer = c.FeePayers[req.Network]
+	}
+	if feePayer == "" {
+		feePayer = req.Extra[ExtraKeyFeePayer]
+	}
+	if feePayer == "" {
+		feePayer = getExtraForNetwork(req.Network)[ExtraKeyFeePayer]
+	}
+	if feePayer == "" {
+		return "", fmt.Errorf("%w for %s", ErrNoFeePayer, req.Network)
+	}
+	if _, err := solana.PublicKeyFromBase58(feePayer); err != nil {
+		return "", fmt.Errorf("invalid fee payer %q for %s: %w", feePayer, req.Network, err)
+	}
+	return feePayer, nil
+}
+
+// withFeePayers sets the resolved fee payer on Solana requirements. Requirements
+// whose fee payer can't be resolved keep the one they were built with.
+func (c *Config) withFeePayers(requirements []PaymentRequirement) {
+	for i, req := range requirements {
+		if !isSolanaNetwork(req.Network) {
+			continue
+		}
+		feePayer, err := c.resolveFeePayer(req)
+		if err != nil || feePayer == req.Extra[ExtraKeyFeePayer] {
+			continue
+		}
+		requirements[i].Extra = cloneStringMap(req.Extra)
+		if requirements[i].Extra == nil {
+			requirements[i].Extra = make(map[string]string)
+		}
+		requirements[i].Extra[ExtraKeyFeePayer] = feePayer
+	}
+}
+
+// checkFeePayers drops the Solana options of a tool whose fee payer is missing or
+// invalid, as clients can't pay them. A tool is never left without options.
+func (s *X402Server) checkFeePayers(toolName string, requirements []PaymentRequirement) []PaymentRequirement {
+	valid := make([]PaymentRequirement, 0, len(requirements))
+	for _, req := range requirements {
+		if isSolanaNetwork(req.Network) {
+			if _, err := s.config.resolveFeePayer(req); err != nil {
+				log.Printf("ERROR: Dropping %s payment option on %s for tool %s: %v", req.Scheme, req.Network, toolName, err)
+				continue
+			}
+		}
+		valid = append(valid, req)
+	}
+
+	if len(valid) == 0 {
+		// Never turn a paid tool into a free one
+		log.Printf("ERROR: No payment option for tool %s has a valid fee payer. Keeping all options.", toolName)
+		return requirements
+	}
+	return valid
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+// fiatSymbols are the prefixes of common currencies; others are suffixed with their code
+var fiatSymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+}
+
+// withFiatEstimates returns copies of requirements whose Extra carries their
+// approximate fiat value, when a FiatOracle is configured
+func (h *X402Handler) withFiatEstimates(ctx context.Context, requirements []PaymentRequirement) []PaymentRequirement {
+	if h.config.FiatOracle == nil {
+		return requirements
+	}
+	currency := strings.ToUpper(h.config.FiatCurrency)
+	if currency == "" {
+		currency = "USD"
+	}
+	decimals := h.config.FiatDecimals
+	if decimals <= 0 {
+		decimals = 2
+	}
+
+	estimated := make([]PaymentRequirement, len(requirements))
+	copy(estimated, requirements)
+	for i := range estimated {
+		amount, ok := new(big.Int).SetString(estimated[i].MaxAmountRequired, 10)
+		if !ok {
+			continue
+		}
+		value, err := h.config.FiatOracle.Value(ctx, estimated[i].Network, estimated[i].Asset, amount)
+		if err != nil || value == nil {
+			h.debugf(ctx, "[X402] No fiat estimate for %s on %s: %v", estimated[i].Asset, estimated[i].Network, err)
+			continue
+		}
+
+		extra := cloneStringMap(estimated[i].Extra)
+		if extra == nil {
+			extra = make(map[string]string)
+		}
+		extra[x402.ExtraKeyFiatEstimate] = formatFiat(value, decimals, currency)
+		estimated[i].Extra = extra
+	}
+	return estimated
+}
+
+// formatFiat renders value (in units of 10^-decimals) as an approximate amount,
+// with two decimals or down to the first significant digit for smaller amounts
+func formatFiat(value *big.Int, decimals int, currency string) string {
+	unit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	amount := new(big.Rat).SetFrac(value, unit)
+
+	var text string
+	switch {
+	case value.Sign() <= 0:
+		// Less than the oracle's smallest unit
+		text = "<" + new(big.Rat).SetFrac(big.NewInt(1), unit).FloatString(decimals)
+	case amount.Cmp(big.NewRat(1, 100)) >= 0:
+		text = "~" + amount.FloatString(2)
+	default:
+		precision := 2
+		for threshold := big.NewRat(1, 100); amount.Cmp(threshold) < 0 && precision < decimals; precision++ {
+			threshold.Quo(threshold, big.NewRat(10, 1))
+		}
+		text = "~" + amount.FloatString(precision)
+	}
+
+	if symbol, ok := fiatSymbols[currency]; ok {
+		return text[:1] + symbol + text[1:]
+	}
+	return fmt.Sprintf("%s %s", text, currency)
+}
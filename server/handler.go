@@ -2,14 +2,29 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math"
 	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
 
+	"github.com/mark3labs/mcp-go-x402"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// x402 standard HTTP headers
+const (
+	HeaderPayment         = "X-PAYMENT"
+	HeaderPaymentResponse = "X-PAYMENT-RESPONSE"
 )
 
 // X402Handler wraps an MCP HTTP handler with x402 payment support using JSON-RPC errors
@@ -17,30 +32,90 @@ type X402Handler struct {
 	mcpHandler  http.Handler
 	config      *Config
 	facilitator Facilitator
+	settlements *settlementLimiter
+	access      *accessSessions
+	batches     *batchPasses
+	trials      *trials
+	deposits    *deposits
+	spend       *sessionSpend
+
+	// accessPassKey signs access passes; nil when they are disabled
+	accessPassKey []byte
+
+	// quoteKey signs price quotes; nil when they are disabled
+	quoteKey []byte
+
+	logger     x402.Logger
+	logSampler *x402.LogSampler
 }
 
 // NewX402Handler creates a new x402 handler wrapper
 func NewX402Handler(mcpHandler http.Handler, config *Config) *X402Handler {
-	facilitator := NewHTTPFacilitator(config.FacilitatorURL)
-	facilitator.SetVerbose(config.Verbose)
-	return &X402Handler{
+	store := config.Store
+	if store == nil {
+		memory := NewMemoryStore()
+		memory.now = config.now
+		store = memory
+	}
+	h := &X402Handler{
 		mcpHandler:  mcpHandler,
 		config:      config,
-		facilitator: facilitator,
+		facilitator: config.sharedFacilitator(),
+		settlements: newSettlementLimiter(config.MaxConcurrentSettlements, config.MaxSettlementQueue),
+		access:      newAccessSessions(store, config.logger()),
+		batches:     newBatchPasses(store, config.logger()),
+		trials:      newTrials(store),
+		deposits:    newDeposits(store),
+		spend:       newSessionSpend(),
+
+		accessPassKey: newAccessPassKey(config),
+		quoteKey:      newSigningKey(config.QuoteTTL, config.QuoteSecret),
+
+		logger:     config.logger(),
+		logSampler: x402.NewLogSampler(config.LogSampleRate),
+	}
+	if code := config.PaymentRequiredCode; code != 0 && code != config.paymentRequiredCode() {
+		h.logf("[X402] PaymentRequiredCode %d is used by other errors, using %d instead", code, DefaultPaymentRequiredCode)
+	}
+	if config.SettlementJournal != nil && config.RecoverSettlementsOnStart {
+		h.recoverOnStart()
 	}
+	return h
+}
+
+// SettlementStats returns the current facilitator call load for monitoring
+func (h *X402Handler) SettlementStats() SettlementStats {
+	return h.settlements.stats()
 }
 
 // ServeHTTP implements http.Handler and intercepts requests to handle x402 payment flow
 func (h *X402Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Forget paid access when a session is closed
+	if r.Method == http.MethodDelete {
+		h.access.revoke(r.Context(), r.Header.Get(server.HeaderKeySessionID))
+		h.spend.forget(r.Header.Get(server.HeaderKeySessionID))
+	}
+
 	// Only intercept POST requests (MCP tool calls)
 	if r.Method != http.MethodPost {
 		h.mcpHandler.ServeHTTP(w, r)
 		return
 	}
 
-	if h.config.Verbose {
-		log.Printf("[X402] Incoming %s request from %s", r.Method, r.RemoteAddr)
-	}
+	r = h.withLogSample(r)
+	h.debugf(r.Context(), "[X402] Incoming %s request from %s", r.Method, r.RemoteAddr)
+
+	// Convert panics in payment handling or tools into INTERNAL_ERROR responses
+	var requestID mcp.RequestId
+	defer func() {
+		if rec := recover(); rec != nil {
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			h.logf("[X402] Panic serving %s: %v\n%s", r.URL.Path, rec, debug.Stack())
+			h.sendInternalError(w, requestID, "Internal server error")
+		}
+	}()
 
 	// Read and buffer the request body
 	body, err := io.ReadAll(r.Body)
@@ -57,11 +132,68 @@ func (h *X402Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.mcpHandler.ServeHTTP(w, r)
 		return
 	}
+	requestID = jsonrpcReq.ID
+
+	// Verify the payer identity claim, if present
+	if !jsonrpcReq.ID.IsNil() {
+		identity, err := h.requestIdentity(jsonrpcReq)
+		if err != nil {
+			h.sendInvalidParamsError(w, jsonrpcReq.ID, err.Error())
+			return
+		}
+		if identity != nil {
+			r = r.WithContext(withIdentity(r.Context(), identity))
+		}
+	}
+
+	// Remember the origin clients bind payments to
+	if h.config.RequireResourceBinding {
+		r = r.WithContext(withServerOrigin(r.Context(), requestOrigin(r)))
+	}
+
+	// Pass the operator token on to the admin tools
+	if token := r.Header.Get(HeaderAdminToken); token != "" && h.config.AdminToken != "" {
+		r = r.WithContext(withAdminToken(r.Context(), token))
+	}
+
+	// Keep pricing experiment assignments stable per payer or session
+	if len(h.config.PricingExperiments) > 0 {
+		r = r.WithContext(withExperimentSubject(r.Context(), r))
+	}
+
+	// Collect the per-session access fee before anything else
+	if h.accessGateApplies(r, jsonrpcReq.Method) {
+		h.handleAccessPayment(w, r, jsonrpcReq)
+		return
+	}
+
+	// Advertise x402 support in the initialize result
+	if jsonrpcReq.Method == string(mcp.MethodInitialize) && h.config.AdvertiseCapability {
+		h.forwardWithCapability(w, r)
+		return
+	}
+
+	// Take, report and refund prepaid deposits
+	if isDepositMethod(jsonrpcReq.Method) && len(h.config.DepositRequirements) > 0 {
+		h.handleDeposit(w, r, jsonrpcReq)
+		return
+	}
+
+	// Quote a tool's price when quotes are enabled
+	if jsonrpcReq.Method == x402.MethodQuote && h.quoteKey != nil {
+		h.handleQuote(w, r, jsonrpcReq)
+		return
+	}
+
+	// Remember which sessions want payment log messages
+	if jsonrpcReq.Method == string(mcp.MethodSetLogLevel) {
+		h.recordLogLevel(r, jsonrpcReq)
+	}
 
 	// Check if this is a tool call (JSON-RPC method)
 	if jsonrpcReq.Method != "tools/call" {
-		if h.config.Verbose && jsonrpcReq.Method != "" {
-			log.Printf("[X402] Non-tool call method: %s, passing through", jsonrpcReq.Method)
+		if jsonrpcReq.Method != "" {
+			h.debugf(r.Context(), "[X402] Non-tool call method: %s, passing through", jsonrpcReq.Method)
 		}
 		h.mcpHandler.ServeHTTP(w, r)
 		return
@@ -76,101 +208,290 @@ func (h *X402Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	toolName := params.Name
-	requirements, needsPayment := h.config.PaymentTools[toolName]
-	if !needsPayment {
-		if h.config.Verbose {
-			log.Printf("[X402] Tool '%s' is free, passing through", toolName)
+	if h.config.RequireIdentity {
+		if _, ok := IdentityFromContext(r.Context()); !ok {
+			h.sendInvalidParamsError(w, jsonrpcReq.ID, "Payer identity required in _meta[\"x402/identity\"]")
+			return
 		}
+	}
+
+	requirements, needsPayment, err := h.config.requirementsFor(r.Context(), toolName)
+	if err != nil {
+		h.sendIdentityRejectedError(w, jsonrpcReq.ID, err)
+		return
+	}
+	if !needsPayment {
+		h.debugf(r.Context(), "[X402] Tool '%s' is free, passing through", toolName)
 		h.mcpHandler.ServeHTTP(w, r)
 		return
 	}
 
-	if h.config.Verbose {
-		log.Printf("[X402] Tool '%s' requires payment, checking for payment in _meta", toolName)
+	h.debugf(r.Context(), "[X402] Tool '%s' requires payment, checking for payment in _meta", toolName)
+
+	// Check for payment in _meta, falling back to the X-PAYMENT header
+	var meta map[string]any
+	if params.Meta != nil {
+		meta = params.Meta.AdditionalFields
 	}
+	meta = h.withPayment(r, meta)
 
-	// Ensure all requirements have proper fields set
-	for i := range requirements {
-		requirements[i].Resource = fmt.Sprintf("mcp://tools/%s", toolName)
-		if requirements[i].MimeType == "" {
-			requirements[i].MimeType = "application/json"
+	// Calls prepaid by a batch payment skip payment
+	if token, _ := meta[x402.MetaKeyBatchToken].(string); token != "" {
+		if info, ok := h.batches.redeem(r.Context(), token, toolName); ok {
+			h.debugf(r.Context(), "[X402] Tool '%s' covered by batch payment", toolName)
+			h.forwardWithSettlementResponse(w, r, jsonrpcReq.ID, info)
+			return
+		}
+	}
+
+	// Offer one payment for the whole batch when the client announces one
+	var batchRemaining map[string]int
+	if h.config.BatchPayments {
+		if calls := batchCalls(meta); calls != nil {
+			if batchReqs, remaining, ok := h.batchRequirements(r.Context(), toolName, calls); ok {
+				requirements, batchRemaining = batchReqs, remaining
+			}
 		}
 	}
 
-	// Check for payment in _meta
-	var paymentData any
-	if params.Meta != nil && params.Meta.AdditionalFields != nil {
-		paymentData = params.Meta.AdditionalFields["x402/payment"]
+	// Charge the quoted price for calls carrying a quote
+	if token, _ := meta[x402.MetaKeyQuote].(string); token != "" && h.quoteKey != nil {
+		quoted, err := verifyQuote(h.quoteKey, token, toolName, h.config.now())
+		if err != nil {
+			h.sendInvalidParamsError(w, jsonrpcReq.ID, fmt.Sprintf("Quote invalid: %v", err))
+			return
+		}
+		requirements, batchRemaining = quoted, nil
 	}
 
-	if paymentData == nil {
-		if h.config.Verbose {
-			log.Printf("[X402] No payment found in _meta, sending 402 JSON-RPC error")
-			log.Printf("[X402] Payment requirements: %d options for tool '%s'", len(requirements), toolName)
-			for i, req := range requirements {
-				log.Printf("[X402]   Option %d: %s %s on %s, pay to %s",
-					i+1, req.MaxAmountRequired, req.Asset, req.Network, req.PayTo)
+	if meta[x402.MetaKeyPayment] == nil {
+		// Calls covered by the payer's free trial skip payment
+		status, covered, ctx := h.useTrial(r, toolName)
+		if covered {
+			h.debugf(ctx, "[X402] Tool '%s' covered by free trial", toolName)
+			h.forwardWithTrial(w, r, status)
+			return
+		}
+		r = r.WithContext(ctx)
+
+		// Calls drawn from a prepaid deposit skip payment
+		if token, _ := meta[x402.MetaKeyDeposit].(string); token != "" && batchRemaining == nil {
+			onBehalfOf, ok := requestOnBehalfOf(meta)
+			if !ok {
+				h.sendInvalidParamsError(w, jsonrpcReq.ID, "On-behalf-of identity too long")
+				return
 			}
+			if info, ok := h.drawFromDeposit(withRequestID(r.Context(), h.config.requestID(r)), token, requirements, onBehalfOf); ok {
+				h.debugf(r.Context(), "[X402] Tool '%s' drawn from deposit", toolName)
+				h.forwardWithSettlementResponse(w, r, jsonrpcReq.ID, info)
+				return
+			}
+		}
+
+		h.debugf(r.Context(), "[X402] No payment found in _meta, sending 402 JSON-RPC error")
+		h.debugf(r.Context(), "[X402] Payment requirements: %d options for tool '%s'", len(requirements), toolName)
+		for i, req := range requirements {
+			h.debugf(r.Context(), "[X402]   Option %d: %s %s on %s, pay to %s",
+				i+1, req.MaxAmountRequired, req.Asset, req.Network, h.redact(req.PayTo))
 		}
-		h.sendPaymentRequiredError(w, jsonrpcReq.ID, requirements)
+		h.sendPaymentRequiredError(r.Context(), w, jsonrpcReq.ID, requirements)
+		return
+	}
+
+	// Stream payment progress to clients that asked for it
+	if pw := h.newProgressWriter(w, r, params.Meta); pw != nil {
+		defer pw.finish()
+		w = pw
+	}
+
+	// A batch is only redeemable once its payment settled
+	if batchRemaining != nil {
+		r = r.WithContext(withSettlementWait(r.Context()))
+	}
+
+	info, ok := h.processPayment(w, r, jsonrpcReq, meta, requirements)
+	if !ok {
 		return
 	}
 
-	if h.config.Verbose {
-		log.Printf("[X402] Payment found in _meta, verifying...")
+	// Issue a token redeeming the rest of a paid batch
+	if batchRemaining != nil {
+		ttl := h.config.BatchTTL
+		if ttl <= 0 {
+			ttl = defaultBatchTTL
+		}
+		batchInfo := *info
+		if token, err := h.batches.issue(r.Context(), batchRemaining, &batchInfo, ttl); err == nil {
+			info.batchToken = token
+		} else {
+			h.verbosef("[X402] Failed to issue batch token: %v", err)
+		}
+	}
+
+	// Forward request to MCP handler and intercept response
+	r = h.withPaymentLog(r, info)
+	h.forwardWithSettlementResponse(w, r, jsonrpcReq.ID, info)
+}
+
+// withPayment returns meta with the payment under x402.MetaKeyPayment, whichever
+// namespace the client sent it in, falling back to the payment (and binding salt)
+// in the X-PAYMENT headers. meta itself is not modified.
+func (h *X402Handler) withPayment(r *http.Request, meta map[string]any) map[string]any {
+	if meta, ok := h.withMetaPayment(meta); ok {
+		return meta
+	}
+
+	header := r.Header.Get(HeaderPayment)
+	if header == "" {
+		return meta
+	}
+	codec, ok := h.config.paymentCodec(r.Header.Get(x402.HeaderPaymentEncoding))
+	if !ok {
+		h.debugf(r.Context(), "[X402] Unsupported payment encoding %q", r.Header.Get(x402.HeaderPaymentEncoding))
+		return meta
+	}
+	var payment PaymentPayload
+	if err := x402.DecodePaymentHeader(header, codec, &payment); err != nil {
+		return meta
+	}
+
+	fields := map[string]any{x402.MetaKeyPayment: payment}
+	if salt := r.Header.Get(x402.HeaderPaymentBinding); salt != "" {
+		fields[x402.MetaKeyBinding] = salt
+	}
+	if reference := r.Header.Get(x402.HeaderPaymentReference); reference != "" {
+		fields[x402.MetaKeyReference] = reference
+	}
+	return withMeta(meta, fields)
+}
+
+// withMetaPayment returns meta with the payment under x402.MetaKeyPayment,
+// whichever namespace the client sent it in, and whether meta holds a payment
+func (h *X402Handler) withMetaPayment(meta map[string]any) (map[string]any, bool) {
+	payment, ok := h.config.MetaNamespace.LookupPayment(meta)
+	if !ok {
+		return meta, false
+	}
+	if meta[x402.MetaKeyPayment] != nil {
+		return meta, true
+	}
+	return withMeta(meta, map[string]any{x402.MetaKeyPayment: payment}), true
+}
+
+// withMeta returns a copy of meta with fields added
+func withMeta(meta map[string]any, fields map[string]any) map[string]any {
+	merged := make(map[string]any, len(meta)+len(fields))
+	for k, v := range meta {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return merged
+}
+
+// processPayment parses, verifies and settles the payment carried in meta against requirements.
+// On failure it writes the JSON-RPC error response and returns false.
+func (h *X402Handler) processPayment(w http.ResponseWriter, r *http.Request, jsonrpcReq transport.JSONRPCRequest, meta map[string]any, requirements []PaymentRequirement) (*PaymentInfo, bool) {
+	info, rpcError := h.verifyPayment(withRequestID(r.Context(), h.config.requestID(r)), jsonrpcReq, meta, requirements, func(message string, meta map[string]any) {
+		reportProgress(w, message, meta)
+	})
+	if rpcError != nil {
+		writeJSONRPCError(w, jsonrpcReq.ID, rpcError)
+		return nil, false
 	}
+	h.recordReceipt(info, r.Header.Get(server.HeaderKeySessionID))
+	return info, true
+}
+
+// verifyPayment parses, verifies and settles the payment carried in meta against
+// requirements, reporting progress messages to progress. On failure it returns
+// the JSON-RPC error to answer the request with.
+func (h *X402Handler) verifyPayment(ctx context.Context, jsonrpcReq transport.JSONRPCRequest, meta map[string]any, requirements []PaymentRequirement, progress func(message string, meta map[string]any)) (*PaymentInfo, *mcp.JSONRPCErrorDetails) {
+	h.debugf(ctx, "[X402] Payment found in _meta, verifying...")
 
 	// Parse payment payload
-	paymentBytes, err := json.Marshal(paymentData)
+	paymentBytes, err := json.Marshal(meta[x402.MetaKeyPayment])
 	if err != nil {
-		h.sendInvalidParamsError(w, jsonrpcReq.ID, "Invalid payment format in _meta")
-		return
+		return nil, invalidParamsError("Invalid payment format in _meta")
 	}
 
 	var payment PaymentPayload
 	if err := json.Unmarshal(paymentBytes, &payment); err != nil {
-		h.sendInvalidParamsError(w, jsonrpcReq.ID, "Failed to parse payment data")
-		return
+		return nil, invalidParamsError("Failed to parse payment data")
 	}
 
-	if h.config.Verbose {
-		if payment.Network == "solana" || payment.Network == "solana-devnet" {
-			log.Printf("[X402] Payment parsed: network=%s, scheme=%s, type=SVM",
-				payment.Network, payment.Scheme)
-		} else {
-			if payloadMap, ok := payment.Payload.(map[string]any); ok {
-				if authData, ok := payloadMap["authorization"].(map[string]any); ok {
-					log.Printf("[X402] Payment parsed: network=%s, scheme=%s, from=%v, to=%v, value=%v",
-						payment.Network, payment.Scheme,
-						authData["from"], authData["to"], authData["value"])
-				} else {
-					log.Printf("[X402] Payment parsed: network=%s, scheme=%s", payment.Network, payment.Scheme)
-				}
+	reference, _ := meta[x402.MetaKeyReference].(string)
+	if len(reference) > x402.MaxReferenceLength {
+		return nil, invalidParamsError("Payment reference too long")
+	}
+	onBehalfOf, ok := requestOnBehalfOf(meta)
+	if !ok {
+		return nil, invalidParamsError("On-behalf-of identity too long")
+	}
+
+	if payment.Network == "solana" || payment.Network == "solana-devnet" {
+		h.debugf(ctx, "[X402] Payment parsed: network=%s, scheme=%s, type=SVM",
+			payment.Network, payment.Scheme)
+	} else {
+		if payloadMap, ok := payment.Payload.(map[string]any); ok {
+			if authData, ok := payloadMap["authorization"].(map[string]any); ok {
+				from, _ := authData["from"].(string)
+				to, _ := authData["to"].(string)
+				h.debugf(ctx, "[X402] Payment parsed: network=%s, scheme=%s, from=%s, to=%s, value=%v",
+					payment.Network, payment.Scheme,
+					h.redact(from), h.redact(to), authData["value"])
 			} else {
-				log.Printf("[X402] Payment parsed: network=%s, scheme=%s", payment.Network, payment.Scheme)
+				h.debugf(ctx, "[X402] Payment parsed: network=%s, scheme=%s", payment.Network, payment.Scheme)
 			}
+		} else {
+			h.debugf(ctx, "[X402] Payment parsed: network=%s, scheme=%s", payment.Network, payment.Scheme)
 		}
 	}
 
 	// Find matching requirement
 	requirement, err := h.findMatchingRequirement(&payment, requirements)
 	if err != nil {
-		if h.config.Verbose {
-			log.Printf("[X402] Payment matching failed: %v", err)
+		h.verbosef("[X402] Payment matching failed: %v", err)
+		return nil, invalidParamsError(fmt.Sprintf("Payment does not match requirements: %v", err))
+	}
+
+	// Check the payment is bound to this exact request and resource
+	if h.config.RequireRequestBinding || h.config.RequireResourceBinding {
+		if err := h.verifyPaymentBinding(ctx, jsonrpcReq, meta, &payment, requirement); err != nil {
+			h.verbosef("[X402] Request binding check failed: %v", err)
+			return nil, invalidParamsError(fmt.Sprintf("Payment binding invalid: %v", err))
 		}
-		h.sendInvalidParamsError(w, jsonrpcReq.ID, fmt.Sprintf("Payment does not match requirements: %v", err))
-		return
 	}
 
+	// Reject authorizations expiring too soon or valid for too long
+	if h.config.StrictAuthorizationWindow {
+		if err := h.checkAuthorizationWindow(&payment, requirement, h.config.now()); err != nil {
+			h.verbosef("[X402] Authorization window check failed: %v", err)
+			return nil, invalidParamsError(fmt.Sprintf("Payment authorization window invalid: %v", err))
+		}
+	}
+
+	// Reserve a facilitator slot, shedding load when saturated
+	release, err := h.settlements.acquire(ctx)
+	if err != nil {
+		h.verbosef("[X402] Facilitator capacity unavailable: %v", err)
+		if errors.Is(err, errSettlementSaturated) {
+			return nil, serverBusyError()
+		}
+		return nil, internalError("Payment verification cancelled")
+	}
+	defer release()
+
 	// Verify payment with facilitator
-	ctx := r.Context()
+	progress("Verifying payment", nil)
+	var timing PaymentTiming
+	verifyStart := time.Now()
 	verifyResp, err := h.facilitator.Verify(ctx, &payment, requirement)
+	timing.Verify = time.Since(verifyStart)
 	if err != nil {
-		if h.config.Verbose {
-			log.Printf("[X402] Facilitator verification error: %v", err)
-		}
-		h.sendInternalError(w, jsonrpcReq.ID, "Payment verification failed")
-		return
+		h.verbosef("[X402] Facilitator verification error: %v", err)
+		return nil, internalError("Payment verification failed")
 	}
 
 	if !verifyResp.IsValid {
@@ -178,109 +499,263 @@ func (h *X402Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if verifyResp.InvalidReason != "" {
 			errorMsg = verifyResp.InvalidReason
 		}
-		if h.config.Verbose {
-			log.Printf("[X402] Facilitator rejected payment: %s", errorMsg)
-		}
-		h.sendInvalidParamsError(w, jsonrpcReq.ID, errorMsg)
-		return
+		h.verbosef("[X402] Facilitator rejected payment: %s", errorMsg)
+		return nil, invalidParamsError(errorMsg)
 	}
 
-	if h.config.Verbose {
-		log.Printf("[X402] Payment verified successfully, payer: %s", verifyResp.Payer)
-	}
+	h.debugf(ctx, "[X402] Payment verified successfully, payer: %s", h.redact(verifyResp.Payer))
 
 	// Settle payment if not in verify-only mode
 	var settleResp *SettleResponse
-	if !h.config.VerifyOnly {
-		if h.config.Verbose {
-			log.Printf("[X402] Settling payment on-chain...")
-		}
-		settleResp, err = h.facilitator.Settle(ctx, &payment, requirement)
+	var pendingID string
+	switch {
+	case requirement.PerItem != nil && !h.config.VerifyOnly && strings.HasPrefix(requirement.Resource, "mcp://tools/"):
+		// Settled once the tool's result is counted
+		h.debugf(ctx, "[X402] Per-item payment, settling after the tool returns")
+		progress("Payment verified", nil)
+	case !h.config.VerifyOnly:
+		h.debugf(ctx, "[X402] Settling payment on-chain...")
+		progress(fmt.Sprintf("Settling payment on %s", requirement.Network), h.config.settlementPendingMeta())
+		settleStart := time.Now()
+		settleResp, pendingID, err = h.settleWithin(ctx, &payment, requirement, verifyResp.Payer, onBehalfOf)
+		timing.settled(settleStart)
 		if err != nil || !settleResp.Success {
 			errorMsg := "Payment settlement failed"
 			if settleResp != nil && settleResp.ErrorReason != "" {
 				errorMsg = settleResp.ErrorReason
 			}
-			if h.config.Verbose {
-				log.Printf("[X402] Settlement failed: %s", errorMsg)
-			}
-			h.sendInternalError(w, jsonrpcReq.ID, errorMsg)
-			return
-		}
-		if h.config.Verbose {
-			log.Printf("[X402] Payment settled successfully, tx: %s", settleResp.Transaction)
+			h.verbosef("[X402] Settlement failed: %s", errorMsg)
+			return nil, settlementFailedError(requirement, errorMsg)
 		}
-	} else {
-		if h.config.Verbose {
-			log.Printf("[X402] Verify-only mode, skipping settlement")
+		if pendingID != "" {
+			progress(fmt.Sprintf("Payment settlement pending, payment=%s", pendingID), nil)
+			break
 		}
+		h.debugf(ctx, "[X402] Payment settled successfully, tx: %s", h.redact(settleResp.Transaction))
+		progress(fmt.Sprintf("Payment settled, tx=%s", settleResp.Transaction), nil)
+	default:
+		h.debugf(ctx, "[X402] Verify-only mode, skipping settlement")
 		settleResp = &SettleResponse{
 			Success:     true,
 			Transaction: "verify-only-mode",
 			Network:     payment.Network,
 			Payer:       verifyResp.Payer,
 		}
+		progress("Payment verified", nil)
 	}
 
-	// Forward request to MCP handler and intercept response
-	h.forwardWithSettlementResponse(w, r, jsonrpcReq.ID, settleResp)
+	// Free the facilitator slot before running the tool
+	release()
+
+	info := &PaymentInfo{
+		Payment:      &payment,
+		Requirement:  requirement,
+		Verification: verifyResp,
+		Settlement:   settleResp,
+		Timing:       timing,
+		Reference:    reference,
+		OnBehalfOf:   onBehalfOf,
+		RequestID:    requestIDFromContext(ctx),
+		payer:        verifyResp.Payer,
+
+		PendingSettlement: pendingID,
+	}
+	return info, nil
+}
+
+// settle settles a verified payment with the facilitator, recording it in the
+// settlement journal when one is configured. A payment the facilitator can't
+// convert into the requirement's settlement asset is settled in the paid asset.
+func (h *X402Handler) settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement, payer, onBehalfOf string) (*SettleResponse, error) {
+	var entry JournalEntry
+	if h.config.SettlementJournal != nil {
+		entry = newJournalEntry(payment, requirement, payer)
+		entry.OnBehalfOf = onBehalfOf
+		entry.RequestID = requestIDFromContext(ctx)
+		h.journal(entry)
+	}
+	settleResp, err := h.facilitator.Settle(ctx, payment, requirement)
+	if err == nil && !settleResp.Success && settleResp.ErrorReason == ErrorReasonUnsupportedSettlementAsset {
+		if fallback, converting := sameAssetRequirement(requirement); converting {
+			// Nothing was settled, so settle in the paid asset instead
+			h.verbosef("[X402] Facilitator can't convert into %s, settling in %s", requirement.Extra[ExtraKeySettlementAsset], requirement.Asset)
+			settleResp, err = h.facilitator.Settle(ctx, payment, fallback)
+		}
+	}
+	if h.config.SettlementJournal != nil {
+		h.journal(entry.settled(settleResp, err))
+	}
+	return settleResp, err
 }
 
+// DefaultPaymentRequiredCode is the JSON-RPC error code of payment required errors
+const DefaultPaymentRequiredCode = 402
+
 // sendPaymentRequiredError sends a JSON-RPC 402 error per spec
-func (h *X402Handler) sendPaymentRequiredError(w http.ResponseWriter, id any, requirements []PaymentRequirement) {
-	response := transport.JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id.(mcp.RequestId),
-		Error: &mcp.JSONRPCErrorDetails{
-			Code:    402,
-			Message: "Payment required",
-			Data: PaymentRequirements402Response{
-				X402Version: 1,
-				Error:       "Payment required to access this resource",
-				Accepts:     requirements,
-			},
-		},
+func (h *X402Handler) sendPaymentRequiredError(ctx context.Context, w http.ResponseWriter, id any, requirements []PaymentRequirement) {
+	writeJSONRPCError(w, id, h.paymentRequiredError(ctx, requirements))
+}
+
+// paymentRequiredError returns the JSON-RPC 402 error offering requirements
+func (h *X402Handler) paymentRequiredError(ctx context.Context, requirements []PaymentRequirement) *mcp.JSONRPCErrorDetails {
+	requirements = h.withFiatEstimates(ctx, requirements)
+	if h.config.RequireResourceBinding {
+		requirements = withResourceBindingRequired(requirements)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK) // HTTP is 200, error is in JSON-RPC
-	_ = json.NewEncoder(w).Encode(response)
+	return &mcp.JSONRPCErrorDetails{
+		Code:    h.config.paymentRequiredCode(),
+		Message: "Payment required",
+		Data: PaymentRequirements402Response{
+			X402Version: 1,
+			Error:       "Payment required to access this resource",
+			Accepts:     requirements,
+			Trial:       trialStatusFromContext(ctx),
+
+			SettlementSeconds: h.config.settlementSeconds(),
+		},
+	}
 }
 
 // sendInvalidParamsError sends a JSON-RPC INVALID_PARAMS error per spec
 func (h *X402Handler) sendInvalidParamsError(w http.ResponseWriter, id any, message string) {
-	response := transport.JSONRPCResponse{
-		JSONRPC: "2.0",
-		ID:      id.(mcp.RequestId),
-		Error: &mcp.JSONRPCErrorDetails{
-			Code:    mcp.INVALID_PARAMS,
-			Message: message,
-		},
-	}
+	writeJSONRPCError(w, id, invalidParamsError(message))
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(response)
+// invalidParamsError returns a JSON-RPC INVALID_PARAMS error
+func invalidParamsError(message string) *mcp.JSONRPCErrorDetails {
+	return &mcp.JSONRPCErrorDetails{
+		Code:    mcp.INVALID_PARAMS,
+		Message: message,
+	}
 }
 
 // sendInternalError sends a JSON-RPC INTERNAL_ERROR per spec
 func (h *X402Handler) sendInternalError(w http.ResponseWriter, id any, message string) {
+	writeJSONRPCError(w, id, internalError(message))
+}
+
+// internalError returns a JSON-RPC INTERNAL_ERROR
+func internalError(message string) *mcp.JSONRPCErrorDetails {
+	return &mcp.JSONRPCErrorDetails{
+		Code:    mcp.INTERNAL_ERROR,
+		Message: message,
+	}
+}
+
+// sendSettlementFailedError sends a JSON-RPC INTERNAL_ERROR for a payment that
+// failed to settle
+func (h *X402Handler) sendSettlementFailedError(w http.ResponseWriter, id any, requirement *PaymentRequirement, message string) {
+	writeJSONRPCError(w, id, settlementFailedError(requirement, message))
+}
+
+// settlementFailedError returns a JSON-RPC INTERNAL_ERROR for a payment that
+// failed to settle. Its data tells clients how long a re-signed payment for the
+// requirement is still accepted, e.g. with a higher Solana priority fee.
+func settlementFailedError(requirement *PaymentRequirement, message string) *mcp.JSONRPCErrorDetails {
+	return &mcp.JSONRPCErrorDetails{
+		Code:    mcp.INTERNAL_ERROR,
+		Message: message,
+		Data: map[string]any{
+			"settlementFailed":   true,
+			"network":            requirement.Network,
+			"reason":             message,
+			"retryWithinSeconds": requirement.MaxTimeoutSeconds,
+		},
+	}
+}
+
+// sendServerBusyError sends a retryable JSON-RPC error when facilitator capacity is exhausted
+func (h *X402Handler) sendServerBusyError(w http.ResponseWriter, id any) {
+	writeJSONRPCError(w, id, serverBusyError())
+}
+
+// serverBusyError returns a retryable JSON-RPC error for exhausted facilitator capacity
+func serverBusyError() *mcp.JSONRPCErrorDetails {
+	return &mcp.JSONRPCErrorDetails{
+		Code:    ErrorCodeServerBusy,
+		Message: "Server busy processing payments, retry later",
+		Data: map[string]any{
+			"retryable": true,
+		},
+	}
+}
+
+// sendIdentityRejectedError sends a JSON-RPC error when the identity policy refuses a call
+func (h *X402Handler) sendIdentityRejectedError(w http.ResponseWriter, id any, reason error) {
+	writeJSONRPCError(w, id, identityRejectedError(reason))
+}
+
+// identityRejectedError returns the JSON-RPC error for a call the identity policy refuses
+func identityRejectedError(reason error) *mcp.JSONRPCErrorDetails {
+	return &mcp.JSONRPCErrorDetails{
+		Code:    ErrorCodeIdentityRejected,
+		Message: fmt.Sprintf("Request rejected: %v", reason),
+	}
+}
+
+// writeJSONRPCError writes a JSON-RPC error response for the request id
+func writeJSONRPCError(w http.ResponseWriter, id any, rpcError *mcp.JSONRPCErrorDetails) {
 	response := transport.JSONRPCResponse{
 		JSONRPC: "2.0",
-		ID:      id.(mcp.RequestId),
-		Error: &mcp.JSONRPCErrorDetails{
-			Code:    mcp.INTERNAL_ERROR,
-			Message: message,
-		},
+		ID:      normalizeRequestID(id),
+		Error:   rpcError,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusOK) // HTTP is 200, error is in JSON-RPC
 	_ = json.NewEncoder(w).Encode(response)
 }
 
-// forwardWithSettlementResponse forwards to MCP handler and adds settlement response
-func (h *X402Handler) forwardWithSettlementResponse(w http.ResponseWriter, r *http.Request, reqID any, settleResp *SettleResponse) {
+// writeJSONRPCResult sends a JSON-RPC result
+func writeJSONRPCResult(w http.ResponseWriter, id any, result any) {
+	raw, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(transport.JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      normalizeRequestID(id),
+		Result:  raw,
+	})
+}
+
+// normalizeRequestID converts a JSON-RPC id in any decoded form (mcp.RequestId,
+// float64 or string from raw JSON, json.Number, ints) into an mcp.RequestId.
+// Integral numbers become int64 so they round-trip without a decimal point.
+func normalizeRequestID(id any) mcp.RequestId {
+	switch v := id.(type) {
+	case mcp.RequestId:
+		return v
+	case *mcp.RequestId:
+		if v != nil {
+			return *v
+		}
+		return mcp.RequestId{}
+	case nil:
+		return mcp.RequestId{}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return mcp.NewRequestId(int64(v))
+		}
+		return mcp.NewRequestId(v)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return mcp.NewRequestId(n)
+		}
+		return mcp.NewRequestId(v.String())
+	case int:
+		return mcp.NewRequestId(int64(v))
+	case int32:
+		return mcp.NewRequestId(int64(v))
+	default:
+		return mcp.NewRequestId(v)
+	}
+}
+
+// forwardWithSettlementResponse forwards to MCP handler and adds settlement response.
+// The payment is attached to the request context for tool handlers and middleware.
+func (h *X402Handler) forwardWithSettlementResponse(w http.ResponseWriter, r *http.Request, reqID any, info *PaymentInfo) {
+	r = r.WithContext(withPaymentInfo(r.Context(), info))
+
 	// Capture the response
 	recorder := &responseRecorder{
 		ResponseWriter: w,
@@ -291,44 +766,217 @@ func (h *X402Handler) forwardWithSettlementResponse(w http.ResponseWriter, r *ht
 	// Forward to MCP handler
 	h.mcpHandler.ServeHTTP(recorder, r)
 
+	// Settle per-item payments for the items returned, withholding the result
+	// when the payment fails to settle
+	if info.Settlement == nil {
+		if err := h.settlePerItem(r.Context(), info, recorder.body.Bytes(), recorder.Header().Get("Content-Type")); err != nil {
+			h.verbosef("[X402] Per-item settlement failed: %v", err)
+			h.sendSettlementFailedError(w, reqID, info.Requirement, fmt.Sprintf("Payment settlement failed: %v", err))
+			return
+		}
+		h.recordReceipt(info, r.Header.Get(server.HeaderKeySessionID))
+	}
+
+	settlement := info.settlementResponse()
+
 	// Parse response to add settlement data
-	if recorder.statusCode == http.StatusOK && recorder.Header().Get("Content-Type") == "application/json" {
-		var jsonrpcResp transport.JSONRPCResponse
-		if err := json.Unmarshal(recorder.body.Bytes(), &jsonrpcResp); err == nil && jsonrpcResp.Error == nil {
-			// Parse result to add _meta
-			var result map[string]any
-			if err := json.Unmarshal(jsonrpcResp.Result, &result); err == nil {
-				// Get or create _meta
-				meta, _ := result["_meta"].(map[string]any)
-				if meta == nil {
-					meta = make(map[string]any)
-				}
-
-				// Add settlement response
-				meta["x402/payment-response"] = SettlementResponse{
-					Success:     settleResp.Success,
-					Transaction: settleResp.Transaction,
-					Network:     settleResp.Network,
-					Payer:       settleResp.Payer,
-				}
-				result["_meta"] = meta
-
-				// Re-marshal
-				jsonrpcResp.Result, _ = json.Marshal(result)
-				recorder.body = &bytes.Buffer{}
-				_ = json.NewEncoder(recorder.body).Encode(jsonrpcResp)
-			}
+	if recorder.statusCode == http.StatusOK {
+		contentType := recorder.Header().Get("Content-Type")
+		switch {
+		case contentType == "application/json":
+			recorder.body = bytes.NewBuffer(h.addSettlement(r.Context(), recorder.body.Bytes(), info, settlement))
+		case strings.HasPrefix(contentType, "text/event-stream"):
+			recorder.body = bytes.NewBuffer(h.addSettlementToEvents(r.Context(), recorder.body.Bytes(), info, settlement))
+		}
+
+		// Tell the paying session about the payment
+		body, bodyType := addPaymentLog(r.Context(), recorder.body.Bytes(), contentType)
+		recorder.body = bytes.NewBuffer(body)
+		if bodyType != contentType {
+			recorder.Header().Set("Content-Type", bodyType)
 		}
 	}
 
-	// Write the captured response
+	// Write the captured response, with the settlement also in the x402 standard header
 	for k, v := range recorder.Header() {
 		w.Header()[k] = v
 	}
+	w.Header().Del("Content-Length") // The body may have grown
+	if settlementJSON, err := json.Marshal(settlement); err == nil {
+		w.Header().Set(HeaderPaymentResponse, base64.StdEncoding.EncodeToString(settlementJSON))
+	}
 	w.WriteHeader(recorder.statusCode)
 	_, _ = w.Write(recorder.body.Bytes())
 }
 
+// addSettlement adds the settlement to the _meta of a JSON-RPC tool call response,
+// returning body unchanged if it is not a successful response
+func (h *X402Handler) addSettlement(ctx context.Context, body []byte, info *PaymentInfo, settlement SettlementResponse) []byte {
+	var jsonrpcResp transport.JSONRPCResponse
+	if err := json.Unmarshal(body, &jsonrpcResp); err != nil || jsonrpcResp.Error != nil {
+		return body
+	}
+	jsonrpcResp, ok := h.addSettlementToResponse(ctx, jsonrpcResp, info, settlement)
+	if !ok {
+		return body
+	}
+	var out bytes.Buffer
+	_ = json.NewEncoder(&out).Encode(jsonrpcResp)
+	return out.Bytes()
+}
+
+// addSettlementToResponse adds the settlement to the _meta of a successful tool
+// call response. It returns false, and resp unchanged, for other responses.
+func (h *X402Handler) addSettlementToResponse(ctx context.Context, jsonrpcResp transport.JSONRPCResponse, info *PaymentInfo, settlement SettlementResponse) (transport.JSONRPCResponse, bool) {
+	if jsonrpcResp.Error != nil {
+		return jsonrpcResp, false
+	}
+	if h.config.OnPaid != nil {
+		jsonrpcResp.Result = h.enrichResult(ctx, info, jsonrpcResp.Result)
+	}
+
+	// Parse result to add _meta
+	var result map[string]any
+	if err := json.Unmarshal(jsonrpcResp.Result, &result); err != nil || result == nil {
+		return jsonrpcResp, false
+	}
+	// Get or create _meta
+	meta, _ := result["_meta"].(map[string]any)
+	if meta == nil {
+		meta = make(map[string]any)
+	}
+
+	// Bind the settlement to the request and result
+	if h.config.ResultIntegrity {
+		settlement = h.withResultIntegrity(ctx, settlement, jsonrpcResp.ID, result)
+	}
+
+	// Add settlement response
+	meta[h.config.MetaNamespace.OrDefault().PaymentResponse] = settlement
+	if info.batchToken != "" {
+		meta[x402.MetaKeyBatchToken] = info.batchToken
+	}
+	if info.depositBalance != nil {
+		meta[x402.MetaKeyDepositBalance] = info.depositBalance
+	}
+	result["_meta"] = meta
+
+	// Re-marshal
+	jsonrpcResp.Result, _ = json.Marshal(result)
+	return jsonrpcResp, true
+}
+
+// withResultIntegrity adds the integrity digest (and signature) of a result to its settlement
+func (h *X402Handler) withResultIntegrity(ctx context.Context, settlement SettlementResponse, requestID any, result map[string]any) SettlementResponse {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		h.logf("[X402] Failed to encode result for integrity digest: %v", err)
+		return settlement
+	}
+	digest, err := x402.ResultIntegrityDigest(settlement.Transaction, requestID, resultJSON)
+	if err != nil {
+		h.logf("[X402] Failed to compute result integrity digest: %v", err)
+		return settlement
+	}
+	settlement.Integrity = "0x" + hex.EncodeToString(digest)
+	if h.config.ResultIntegrityKey != nil {
+		signature, err := x402.SignResultIntegrity(digest, h.config.ResultIntegrityKey)
+		if err != nil {
+			h.logf("[X402] %v", err)
+			return settlement
+		}
+		settlement.IntegritySignature = signature
+	}
+	h.debugf(ctx, "[X402] Result integrity digest %s", settlement.Integrity)
+	return settlement
+}
+
+// addSettlementToEvents adds the settlement to the JSON-RPC response in an SSE
+// stream, as sent by streamable HTTP servers answering with an event stream.
+// Notifications and other events pass through unchanged.
+func (h *X402Handler) addSettlementToEvents(ctx context.Context, body []byte, info *PaymentInfo, settlement SettlementResponse) []byte {
+	return mapResultEvents(body, func(data []byte) []byte {
+		return h.addSettlement(ctx, data, info, settlement)
+	})
+}
+
+// mapResultEvents rewrites the JSON-RPC responses with a result in an SSE stream
+// with fn. Notifications and other events pass through unchanged.
+func mapResultEvents(body []byte, fn func(data []byte) []byte) []byte {
+	lines := bytes.Split(body, []byte("\n"))
+	for i, line := range lines {
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		var message struct {
+			Method string          `json:"method"`
+			Result json.RawMessage `json:"result"`
+		}
+		if json.Unmarshal(data, &message) != nil || message.Method != "" || message.Result == nil {
+			continue
+		}
+		decorated := bytes.TrimSpace(fn(data))
+		lines[i] = append([]byte("data: "), decorated...)
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// enrichResult passes a paid tool call's result through the OnPaid hook
+func (h *X402Handler) enrichResult(ctx context.Context, info *PaymentInfo, raw json.RawMessage) json.RawMessage {
+	result, err := mcp.ParseCallToolResult(&raw)
+	if err != nil {
+		h.debugf(ctx, "[X402] Not a tool result, skipping OnPaid: %v", err)
+		return raw
+	}
+
+	enriched, err := h.config.OnPaid(ctx, info.Settlement.Payer, info.Settlement, result)
+	if err != nil {
+		h.logf("[X402] OnPaid failed for tx %s: %v", h.redact(info.Settlement.Transaction), err)
+		return raw
+	}
+	if enriched == nil {
+		return raw
+	}
+
+	enrichedJSON, err := json.Marshal(enriched)
+	if err != nil {
+		h.logf("[X402] Failed to encode OnPaid result: %v", err)
+		return raw
+	}
+	return enrichedJSON
+}
+
+// verifyPaymentBinding checks that an EVM payment nonce commits to the request
+// method and params, and to the server origin and resource, as configured
+func (h *X402Handler) verifyPaymentBinding(ctx context.Context, req transport.JSONRPCRequest, meta map[string]any, payment *PaymentPayload, requirement *PaymentRequirement) error {
+	nonce, err := paymentNonce(payment)
+	if err != nil || nonce == "" {
+		return err // SVM payloads carry no nonce and can't be bound
+	}
+
+	salt, _ := meta[x402.MetaKeyBinding].(string)
+	if salt == "" {
+		return fmt.Errorf("missing %s in _meta", x402.MetaKeyBinding)
+	}
+
+	var requestDigest, resourceDigest []byte
+	if h.config.RequireRequestBinding {
+		if requestDigest, err = x402.RequestBindingDigest(req.Method, req.Params); err != nil {
+			return err
+		}
+	}
+	if h.config.RequireResourceBinding {
+		origin := serverOriginFromContext(ctx, h.config)
+		if origin == "" {
+			return fmt.Errorf("server origin unknown, set Config.ServerOrigin")
+		}
+		resourceDigest = x402.ResourceBindingDigest(origin, requirement.Resource)
+	}
+	return x402.VerifyPaymentBinding(x402.PaymentBindingDigest(requestDigest, resourceDigest), salt, nonce)
+}
+
 // findMatchingRequirement finds the payment requirement that matches the provided payment
 func (h *X402Handler) findMatchingRequirement(payment *PaymentPayload, requirements []PaymentRequirement) (*PaymentRequirement, error) {
 	for i := range requirements {
@@ -3,13 +3,22 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/mcp"
 )
 
 // mockMCPHandler simulates an MCP handler
@@ -73,6 +82,7 @@ func TestX402Handler_PaymentRequired(t *testing.T) {
 				},
 			},
 		},
+		ExpectedSettlementTime: 1500 * time.Millisecond,
 	}
 
 	handler := NewX402Handler(mockHandler, config)
@@ -119,6 +129,10 @@ func TestX402Handler_PaymentRequired(t *testing.T) {
 		t.Errorf("Wrong amount: %s", jsonrpcResp.Error.Data.Accepts[0].MaxAmountRequired)
 	}
 
+	if jsonrpcResp.Error.Data.SettlementSeconds != 2 {
+		t.Errorf("Expected settlementSeconds 2, got %d", jsonrpcResp.Error.Data.SettlementSeconds)
+	}
+
 	if !mockHandler.called {
 		// Correct - handler should NOT be called without payment
 	} else {
@@ -436,3 +450,1162 @@ func TestX402Handler_PaymentMatching(t *testing.T) {
 		t.Error("Facilitator verify should have been called")
 	}
 }
+
+// blockingFacilitator blocks Verify until released
+type blockingFacilitator struct {
+	MockFacilitator
+	entered chan struct{}
+	unblock chan struct{}
+}
+
+func (b *blockingFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	b.entered <- struct{}{}
+	<-b.unblock
+	return b.MockFacilitator.Verify(ctx, payment, requirement)
+}
+
+func paidToolRequest(t *testing.T, toolName string) *http.Request {
+	t.Helper()
+	reqJSON := map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "tools/call",
+		"params": map[string]any{
+			"name": toolName,
+			"_meta": map[string]any{
+				"x402/payment": &PaymentPayload{
+					X402Version: 1,
+					Scheme:      "exact",
+					Network:     "test",
+					Payload:     map[string]any{"signature": "0xsig"},
+				},
+			},
+		},
+		"id": 1,
+	}
+	reqBody, _ := json.Marshal(reqJSON)
+	req := httptest.NewRequest("POST", "/mcp", bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestX402Handler_SettlementLoadShedding(t *testing.T) {
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"success"}]},"id":1}`,
+	}
+
+	facilitator := &blockingFacilitator{
+		MockFacilitator: MockFacilitator{
+			verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+			settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test"},
+		},
+		entered: make(chan struct{}, 1),
+		unblock: make(chan struct{}),
+	}
+
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+		MaxConcurrentSettlements: 1,
+	}
+
+	handler := NewX402Handler(mockHandler, config)
+	handler.facilitator = facilitator
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), paidToolRequest(t, "paid-tool"))
+	}()
+	<-facilitator.entered
+
+	if stats := handler.SettlementStats(); stats.InFlight != 1 {
+		t.Errorf("Expected 1 in-flight settlement, got %d", stats.InFlight)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, paidToolRequest(t, "paid-tool"))
+
+	var jsonrpcResp struct {
+		Error *struct {
+			Code int            `json:"code"`
+			Data map[string]any `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&jsonrpcResp); err != nil {
+		t.Fatal(err)
+	}
+	if jsonrpcResp.Error == nil || jsonrpcResp.Error.Code != ErrorCodeServerBusy {
+		t.Fatalf("Expected server busy error, got %+v", jsonrpcResp.Error)
+	}
+	if jsonrpcResp.Error.Data["retryable"] != true {
+		t.Error("Expected server busy error to be retryable")
+	}
+
+	close(facilitator.unblock)
+	<-done
+
+	stats := handler.SettlementStats()
+	if stats.InFlight != 0 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats after completion: %+v", stats)
+	}
+}
+
+func TestX402Handler_RequireRequestBinding(t *testing.T) {
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"success"}]},"id":1}`,
+	}
+
+	mockFacilitator := &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test"},
+	}
+
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+		RequireRequestBinding: true,
+	}
+
+	handler := NewX402Handler(mockHandler, config)
+	handler.facilitator = mockFacilitator
+
+	boundParams := map[string]any{"name": "paid-tool", "arguments": map[string]any{"q": "a"}}
+	digest, err := x402.RequestBindingDigest("tools/call", boundParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	nonce := x402.RequestBindingNonce(digest, salt)
+
+	send := func(args map[string]any) *httptest.ResponseRecorder {
+		reqJSON := map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "tools/call",
+			"params": map[string]any{
+				"name":      "paid-tool",
+				"arguments": args,
+				"_meta": map[string]any{
+					"x402/payment": &PaymentPayload{
+						X402Version: 1,
+						Scheme:      "exact",
+						Network:     "test",
+						Payload: map[string]any{
+							"signature":     "0xsig",
+							"authorization": map[string]any{"nonce": nonce},
+						},
+					},
+					x402.MetaKeyBinding: "0x" + hex.EncodeToString(salt),
+				},
+			},
+			"id": 1,
+		}
+		reqBody, _ := json.Marshal(reqJSON)
+		req := httptest.NewRequest("POST", "/mcp", bytes.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Replaying the payment against different arguments must fail
+	rr := send(map[string]any{"q": "b"})
+	if !strings.Contains(rr.Body.String(), "Payment binding invalid") {
+		t.Errorf("Expected binding failure, got %s", rr.Body.String())
+	}
+	if mockHandler.called || mockFacilitator.verifyCalled {
+		t.Error("Unbound payment should not reach facilitator or tool")
+	}
+
+	// The original request is accepted
+	rr = send(map[string]any{"q": "a"})
+	if !mockHandler.called {
+		t.Errorf("Expected bound payment to be accepted, got %s", rr.Body.String())
+	}
+}
+
+func TestX402Handler_RequireResourceBinding(t *testing.T) {
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"success"}]},"id":1}`,
+	}
+	config := &Config{
+		Facilitator: &MockFacilitator{
+			verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+			settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test"},
+		},
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient", Resource: "mcp://tools/paid-tool"}},
+		},
+		RequireResourceBinding: true,
+	}
+	handler := NewX402Handler(mockHandler, config)
+
+	salt := []byte("0123456789abcdef0123456789abcdef")
+	send := func(origin string) *httptest.ResponseRecorder {
+		meta := map[string]any{}
+		if origin != "" {
+			nonce := x402.RequestBindingNonce(x402.ResourceBindingDigest(origin, "mcp://tools/paid-tool"), salt)
+			meta["x402/payment"] = &PaymentPayload{
+				X402Version: 1,
+				Scheme:      "exact",
+				Network:     "test",
+				Payload:     map[string]any{"signature": "0xsig", "authorization": map[string]any{"nonce": nonce}},
+			}
+			meta[x402.MetaKeyBinding] = "0x" + hex.EncodeToString(salt)
+		}
+		reqBody, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "tools/call",
+			"params":  map[string]any{"name": "paid-tool", "_meta": meta},
+			"id":      1,
+		})
+		req := httptest.NewRequest("POST", "http://mcp.example.com/mcp", bytes.NewReader(reqBody))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// The 402 asks clients to bind payments
+	if rr := send(""); !strings.Contains(rr.Body.String(), `"resourceBinding":"required"`) {
+		t.Errorf("Expected resource binding in the requirements, got %s", rr.Body.String())
+	}
+
+	// A payment bound to another server is refused
+	if rr := send("https://attacker.example.com"); !strings.Contains(rr.Body.String(), "Payment binding invalid") || mockHandler.called {
+		t.Errorf("Expected binding failure, got %s", rr.Body.String())
+	}
+
+	if rr := send("http://mcp.example.com"); !mockHandler.called {
+		t.Errorf("Expected bound payment to be accepted, got %s", rr.Body.String())
+	}
+
+	// Behind a proxy, the configured origin is used
+	config.ServerOrigin = "https://api.example.com"
+	mockHandler.called = false
+	if rr := send("https://api.example.com"); !mockHandler.called {
+		t.Errorf("Expected payment bound to ServerOrigin to be accepted, got %s", rr.Body.String())
+	}
+
+	payment := &PaymentPayload{Payload: map[string]any{"authorization": map[string]any{
+		"nonce": x402.RequestBindingNonce(x402.ResourceBindingDigest("https://api.example.com", "mcp://tools/paid-tool"), salt),
+	}}}
+	if err := VerifyResourceBinding(payment, hex.EncodeToString(salt), "https://api.example.com", "mcp://tools/other"); err == nil {
+		t.Error("VerifyResourceBinding should reject another resource")
+	}
+}
+
+func TestX402Handler_FreeTrial(t *testing.T) {
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"success"}]},"id":1}`,
+	}
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+		Trials: map[string]Trial{"paid-tool": {FreeCalls: 2, Period: time.Hour}},
+	}
+	handler := NewX402Handler(mockHandler, config)
+
+	newIdentity := func(key string) *x402.EVMIdentity {
+		identity, err := x402.NewEVMIdentity(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return identity
+	}
+	payerA := newIdentity("0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	payerB := newIdentity("0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d")
+
+	call := func(identity *x402.EVMIdentity, sessionID string) (*httptest.ResponseRecorder, map[string]any) {
+		params := map[string]any{"name": "paid-tool"}
+		if identity != nil {
+			claim, err := identity.IdentityClaim(context.Background(), "", mcp.Implementation{Name: "test"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			params["_meta"] = map[string]any{x402.MetaKeyIdentity: claim}
+		}
+		reqBody, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "tools/call",
+			"params":  params,
+			"id":      1,
+		})
+		req := httptest.NewRequest("POST", "/mcp", bytes.NewReader(reqBody))
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var resp map[string]any
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		return rr, resp
+	}
+
+	for remaining := 1; remaining >= 0; remaining-- {
+		mockHandler.called = false
+		rr, resp := call(payerA, "session-a")
+		if !mockHandler.called {
+			t.Fatalf("Expected the trial to cover the call, got %s", rr.Body.String())
+		}
+		result, _ := resp["result"].(map[string]any)
+		meta, _ := result["_meta"].(map[string]any)
+		trial, _ := meta[x402.MetaKeyTrial].(map[string]any)
+		if trial["active"] != true || trial["callsRemaining"] != float64(remaining) {
+			t.Errorf("Expected an active trial with %d calls remaining, got %v", remaining, trial)
+		}
+	}
+
+	// The third call must be paid, and the 402 says why
+	mockHandler.called = false
+	rr, resp := call(payerA, "session-a")
+	if mockHandler.called {
+		t.Fatal("Expected the ended trial to require payment")
+	}
+	errObj, _ := resp["error"].(map[string]any)
+	data, _ := errObj["data"].(map[string]any)
+	trial, _ := data["trial"].(map[string]any)
+	if trial["active"] != false || trial["tool"] != "paid-tool" || trial["expiresAt"] == nil {
+		t.Errorf("Expected the ended trial in the 402 data, got %s", rr.Body.String())
+	}
+
+	// A new session doesn't restart the payer's trial
+	mockHandler.called = false
+	if call(payerA, "session-b"); mockHandler.called {
+		t.Error("Expected a new session of the same payer to pay")
+	}
+
+	// Other payers have their own trial; anonymous callers have none
+	mockHandler.called = false
+	if call(payerB, "session-c"); !mockHandler.called {
+		t.Error("Expected another payer to get its own trial")
+	}
+	mockHandler.called = false
+	if rr, _ := call(nil, "session-d"); mockHandler.called || strings.Contains(rr.Body.String(), `"trial"`) {
+		t.Errorf("Expected an anonymous call to pay, got %s", rr.Body.String())
+	}
+}
+
+func TestTrials_ResetAfter(t *testing.T) {
+	clock := x402.NewFakeClock(time.Unix(1000, 0))
+	store := NewMemoryStore()
+	store.now = clock.Now
+	trials := newTrials(store)
+	ctx := context.Background()
+	trial := Trial{FreeCalls: 1, ResetAfter: time.Hour}
+
+	if status, _ := trials.consume(ctx, "tool", "payer", trial, clock.Now()); !status.Active {
+		t.Fatal("Expected the first call to be free")
+	}
+	if status, _ := trials.consume(ctx, "tool", "payer", trial, clock.Now()); status.Active {
+		t.Error("Expected the trial to be used up")
+	}
+	clock.Advance(time.Hour)
+	if status, _ := trials.consume(ctx, "tool", "payer", trial, clock.Now()); !status.Active {
+		t.Error("Expected a new trial once the old one expired from the store")
+	}
+}
+
+func TestTrials_Period(t *testing.T) {
+	trials := newTrials(NewMemoryStore())
+	ctx := context.Background()
+	start := time.Unix(1000, 0)
+	trial := Trial{Period: time.Minute}
+
+	status, err := trials.consume(ctx, "tool", "payer", trial, start)
+	if err != nil || !status.Active || status.ExpiresAt != 1060 {
+		t.Fatalf("Expected an active trial ending at 1060, got %+v (%v)", status, err)
+	}
+	if status, _ := trials.consume(ctx, "tool", "payer", trial, start.Add(59*time.Second)); !status.Active {
+		t.Error("Expected the trial to cover calls within its period")
+	}
+	if status, _ := trials.consume(ctx, "tool", "payer", trial, start.Add(time.Minute)); status.Active {
+		t.Error("Expected the trial to end after its period")
+	}
+}
+
+func TestX402Handler_AccessGate(t *testing.T) {
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"tools":[]},"id":1}`,
+	}
+
+	mockFacilitator := &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test"},
+	}
+
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		AccessRequirements: []PaymentRequirement{
+			{Scheme: "exact", Network: "test", MaxAmountRequired: "5000", Asset: "0xusdc", PayTo: "0xrecipient"},
+		},
+	}
+
+	handler := NewX402Handler(mockHandler, config)
+	handler.facilitator = mockFacilitator
+
+	send := func(method string, meta map[string]any) *httptest.ResponseRecorder {
+		params := map[string]any{}
+		if meta != nil {
+			params["_meta"] = meta
+		}
+		reqBody, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+		req := httptest.NewRequest("POST", "/mcp", bytes.NewReader(reqBody))
+		req.Header.Set("Mcp-Session-Id", "session-1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Pings are never gated
+	send("ping", nil)
+	if !mockHandler.called {
+		t.Fatal("ping should pass through the access gate")
+	}
+	mockHandler.called = false
+
+	// tools/list without payment gets a 402 for the access resource
+	var jsonrpcResp struct {
+		Error *struct {
+			Code int                            `json:"code"`
+			Data PaymentRequirements402Response `json:"data"`
+		} `json:"error"`
+	}
+	rr := send("tools/list", nil)
+	if err := json.NewDecoder(rr.Body).Decode(&jsonrpcResp); err != nil {
+		t.Fatal(err)
+	}
+	if jsonrpcResp.Error == nil || jsonrpcResp.Error.Code != 402 {
+		t.Fatalf("Expected 402, got %+v", jsonrpcResp.Error)
+	}
+	if jsonrpcResp.Error.Data.Accepts[0].Resource != AccessResource {
+		t.Errorf("Expected access resource, got %s", jsonrpcResp.Error.Data.Accepts[0].Resource)
+	}
+	if mockHandler.called {
+		t.Fatal("MCP handler should not be called before access is paid")
+	}
+
+	// Paying unlocks the session
+	send("tools/list", map[string]any{
+		"x402/payment": &PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test", Payload: map[string]any{}},
+	})
+	if !mockHandler.called || !mockFacilitator.settleCalled {
+		t.Fatal("Access payment should be settled and request forwarded")
+	}
+	mockHandler.called = false
+
+	send("tools/list", nil)
+	if !mockHandler.called {
+		t.Error("Paid session should not be gated again")
+	}
+}
+
+func TestX402Handler_DefaultPaymentRequirements(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"premium": {{Scheme: "exact", Network: "test", MaxAmountRequired: "9000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+		DefaultPaymentRequirements: []PaymentRequirement{
+			{Scheme: "exact", Network: "test", MaxAmountRequired: "100", Asset: "0xusdc", PayTo: "0xrecipient"},
+		},
+		AllowFree: []string{"help"},
+	}
+
+	tests := []struct {
+		tool       string
+		wantPaid   bool
+		wantAmount string
+	}{
+		{tool: "premium", wantPaid: true, wantAmount: "9000"},
+		{tool: "anything", wantPaid: true, wantAmount: "100"},
+		{tool: "help", wantPaid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tool, func(t *testing.T) {
+			mockHandler := &mockMCPHandler{
+				response: `{"jsonrpc":"2.0","result":{"content":[]},"id":1}`,
+			}
+			handler := NewX402Handler(mockHandler, config)
+
+			reqBody := `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"` + tt.tool + `"},"id":1}`
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("POST", "/mcp", strings.NewReader(reqBody)))
+
+			if mockHandler.called == tt.wantPaid {
+				t.Fatalf("tool %s: handler called=%v, want paid=%v", tt.tool, mockHandler.called, tt.wantPaid)
+			}
+			if !tt.wantPaid {
+				return
+			}
+
+			var jsonrpcResp struct {
+				Error *struct {
+					Data PaymentRequirements402Response `json:"data"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(rr.Body).Decode(&jsonrpcResp); err != nil {
+				t.Fatal(err)
+			}
+			accepts := jsonrpcResp.Error.Data.Accepts
+			if accepts[0].MaxAmountRequired != tt.wantAmount {
+				t.Errorf("Expected amount %s, got %s", tt.wantAmount, accepts[0].MaxAmountRequired)
+			}
+			if accepts[0].Resource != "mcp://tools/"+tt.tool {
+				t.Errorf("Unexpected resource %s", accepts[0].Resource)
+			}
+		})
+	}
+
+	if config.DefaultPaymentRequirements[0].Resource != "" {
+		t.Error("Default requirements should not be mutated")
+	}
+}
+
+func TestX402Handler_IdentityPolicy(t *testing.T) {
+	identity, err := x402.NewEVMIdentity("0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim, err := identity.IdentityClaim(context.Background(), "", mcp.Implementation{Name: "agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"search": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+		IdentityPolicy: func(ctx context.Context, id *Identity, toolName string, reqs []PaymentRequirement) ([]PaymentRequirement, error) {
+			if id == nil {
+				return reqs, nil
+			}
+			if id.ClientName == "banned" {
+				return nil, fmt.Errorf("client banned")
+			}
+			// Known payers get half price
+			for i := range reqs {
+				reqs[i].MaxAmountRequired = "500"
+			}
+			return reqs, nil
+		},
+	}
+
+	call := func(meta map[string]any) (*mockMCPHandler, map[string]any) {
+		mockHandler := &mockMCPHandler{
+			response: `{"jsonrpc":"2.0","result":{"content":[]},"id":1}`,
+		}
+		handler := NewX402Handler(mockHandler, config)
+
+		params := map[string]any{"name": "search"}
+		if meta != nil {
+			params["_meta"] = meta
+		}
+		reqBody, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": "tools/call", "params": params, "id": 1})
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("POST", "/mcp", bytes.NewReader(reqBody)))
+
+		var resp map[string]any
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return mockHandler, resp
+	}
+	errorOf := func(resp map[string]any) map[string]any {
+		errObj, _ := resp["error"].(map[string]any)
+		if errObj == nil {
+			t.Fatalf("Expected JSON-RPC error, got %v", resp)
+		}
+		return errObj
+	}
+	price := func(resp map[string]any) string {
+		data := errorOf(resp)["data"].(map[string]any)
+		return data["accepts"].([]any)[0].(map[string]any)["maxAmountRequired"].(string)
+	}
+
+	// Anonymous callers pay full price
+	_, resp := call(nil)
+	if got := price(resp); got != "1000" {
+		t.Errorf("Expected anonymous price 1000, got %s", got)
+	}
+
+	// A verified identity gets the discount
+	_, resp = call(map[string]any{x402.MetaKeyIdentity: claim})
+	if got := price(resp); got != "500" {
+		t.Errorf("Expected discounted price 500, got %s", got)
+	}
+	if config.PaymentTools["search"][0].MaxAmountRequired != "1000" {
+		t.Error("Policy should not mutate configured requirements")
+	}
+
+	// A tampered claim is rejected
+	tampered := *claim
+	tampered.ClientName = "other"
+	mockHandler, resp := call(map[string]any{x402.MetaKeyIdentity: &tampered})
+	if mockHandler.called || errorOf(resp)["code"].(float64) != float64(mcp.INVALID_PARAMS) {
+		t.Errorf("Expected invalid params for tampered claim, got %v", resp)
+	}
+
+	// The policy can refuse a payer
+	banned, _ := identity.IdentityClaim(context.Background(), "", mcp.Implementation{Name: "banned"})
+	_, resp = call(map[string]any{x402.MetaKeyIdentity: banned})
+	if errorOf(resp)["code"].(float64) != ErrorCodeIdentityRejected {
+		t.Errorf("Expected identity rejected error, got %v", resp)
+	}
+
+	// RequireIdentity refuses anonymous calls
+	config.RequireIdentity = true
+	mockHandler, resp = call(nil)
+	if mockHandler.called || errorOf(resp)["code"].(float64) != float64(mcp.INVALID_PARAMS) {
+		t.Errorf("Expected anonymous call to be rejected, got %v", resp)
+	}
+}
+
+func TestX402Handler_SettlementInHeaderAndMeta(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+		PaymentCodecs: []x402.Codec{gobCodec{}},
+	}
+	newHandler := func() (*X402Handler, *mockMCPHandler) {
+		mockHandler := &mockMCPHandler{
+			response: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"success"}]},"id":1}`,
+		}
+		handler := NewX402Handler(mockHandler, config)
+		handler.facilitator = &MockFacilitator{
+			verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+			settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test", Payer: "0xpayer"},
+		}
+		return handler, mockHandler
+	}
+
+	// headerSettlement decodes X-PAYMENT-RESPONSE like a header-reading client
+	headerSettlement := func(t *testing.T, rr *httptest.ResponseRecorder) SettlementResponse {
+		t.Helper()
+		var settlement SettlementResponse
+		decoded, err := base64.StdEncoding.DecodeString(rr.Header().Get(HeaderPaymentResponse))
+		if err != nil || json.Unmarshal(decoded, &settlement) != nil {
+			t.Fatalf("Invalid %s header: %q", HeaderPaymentResponse, rr.Header().Get(HeaderPaymentResponse))
+		}
+		return settlement
+	}
+
+	// metaSettlement reads result._meta like a meta-reading client
+	metaSettlement := func(t *testing.T, rr *httptest.ResponseRecorder) SettlementResponse {
+		t.Helper()
+		var resp struct {
+			Result struct {
+				Meta struct {
+					Settlement SettlementResponse `json:"x402/payment-response"`
+				} `json:"_meta"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp.Result.Meta.Settlement
+	}
+
+	t.Run("HeaderPaymentClient", func(t *testing.T) {
+		handler, mockHandler := newHandler()
+		payment, _ := json.Marshal(PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test", Payload: map[string]any{"signature": "0xsig"}})
+
+		req := httptest.NewRequest("POST", "/mcp", strings.NewReader(`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"paid-tool"},"id":1}`))
+		req.Header.Set(HeaderPayment, base64.StdEncoding.EncodeToString(payment))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if !mockHandler.called {
+			t.Fatalf("Expected paid call to be forwarded, got %s", rr.Body.String())
+		}
+		if s := headerSettlement(t, rr); !s.Success || s.Transaction != "0xtx" {
+			t.Errorf("Unexpected header settlement: %+v", s)
+		}
+		if s := metaSettlement(t, rr); s.Transaction != "0xtx" {
+			t.Errorf("Expected settlement in _meta for header client too, got %+v", s)
+		}
+	})
+
+	t.Run("EncodedHeaderPayment", func(t *testing.T) {
+		handler, mockHandler := newHandler()
+		payment, err := (&x402.PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test"}).EncodeWith(gobCodec{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("POST", "/mcp", strings.NewReader(`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"paid-tool"},"id":1}`))
+		req.Header.Set(HeaderPayment, payment)
+		req.Header.Set(x402.HeaderPaymentEncoding, gobCodec{}.ContentType())
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if !mockHandler.called {
+			t.Fatalf("Expected gob encoded payment to be accepted, got %s", rr.Body.String())
+		}
+
+		handler, mockHandler = newHandler()
+		req = httptest.NewRequest("POST", "/mcp", strings.NewReader(`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"paid-tool"},"id":1}`))
+		req.Header.Set(HeaderPayment, payment)
+		req.Header.Set(x402.HeaderPaymentEncoding, "application/cbor")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if mockHandler.called {
+			t.Error("Payments in unconfigured encodings should be refused")
+		}
+	})
+
+	t.Run("MetaPaymentClient", func(t *testing.T) {
+		handler, mockHandler := newHandler()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, paidToolRequest(t, "paid-tool"))
+
+		if !mockHandler.called {
+			t.Fatalf("Expected paid call to be forwarded, got %s", rr.Body.String())
+		}
+		if s := metaSettlement(t, rr); !s.Success || s.Transaction != "0xtx" {
+			t.Errorf("Unexpected _meta settlement: %+v", s)
+		}
+		if s := headerSettlement(t, rr); s.Transaction != "0xtx" {
+			t.Errorf("Expected settlement header for meta client too, got %+v", s)
+		}
+	})
+}
+
+func TestX402Handler_PaymentReferenceEcho(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+	}
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"content":[]},"id":1}`,
+	}
+	handler := NewX402Handler(mockHandler, config)
+	handler.facilitator = &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test"},
+	}
+
+	reqBody, _ := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"method":  "tools/call",
+		"params": map[string]any{
+			"name": "paid-tool",
+			"_meta": map[string]any{
+				"x402/payment":        PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test", Payload: map[string]any{"signature": "0xsig"}},
+				x402.MetaKeyReference: "order-42",
+			},
+		},
+		"id": 1,
+	})
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/mcp", bytes.NewReader(reqBody)))
+
+	var resp struct {
+		Result struct {
+			Meta struct {
+				Settlement SettlementResponse `json:"x402/payment-response"`
+			} `json:"_meta"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result.Meta.Settlement.Reference != "order-42" {
+		t.Errorf("Expected reference order-42 in settlement, got %+v", resp.Result.Meta.Settlement)
+	}
+}
+
+func TestX402Handler_PaymentRequiredCode(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{
+				Scheme:            "exact",
+				Network:           "test",
+				MaxAmountRequired: "1000",
+				Asset:             "0xusdc",
+				PayTo:             "0xrecipient",
+				MaxTimeoutSeconds: 60,
+			}},
+		},
+		PaymentRequiredCode: -32402,
+	}
+
+	handler := NewX402Handler(&mockMCPHandler{}, config)
+
+	reqBody := `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"paid-tool"},"id":1}`
+	req := httptest.NewRequest("POST", "/mcp", bytes.NewReader([]byte(reqBody)))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var jsonrpcResp struct {
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&jsonrpcResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if jsonrpcResp.Error.Code != -32402 {
+		t.Errorf("Expected error code -32402, got %d", jsonrpcResp.Error.Code)
+	}
+
+	// Codes of other errors are refused
+	for _, code := range []int{ErrorCodeServerBusy, ErrorCodeIdentityRejected} {
+		config.PaymentRequiredCode = code
+		if got := config.paymentRequiredCode(); got != DefaultPaymentRequiredCode {
+			t.Errorf("Expected code %d to be refused for %d, got %d", code, DefaultPaymentRequiredCode, got)
+		}
+		if got := config.capability().PaymentRequiredCode; got != DefaultPaymentRequiredCode {
+			t.Errorf("Expected capability to advertise %d instead of %d, got %d", DefaultPaymentRequiredCode, code, got)
+		}
+	}
+}
+
+func TestX402Handler_PanicRecovery(t *testing.T) {
+	panicking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("tool exploded")
+	})
+	handler := NewX402Handler(panicking, &Config{FacilitatorURL: "http://mock"})
+
+	reqBody := `{"jsonrpc":"2.0","method":"tools/call","params":{"name":"free-tool"},"id":"req-7"}`
+	req := httptest.NewRequest("POST", "/mcp", bytes.NewReader([]byte(reqBody)))
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var jsonrpcResp struct {
+		ID    any `json:"id"`
+		Error struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&jsonrpcResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if jsonrpcResp.Error.Code != mcp.INTERNAL_ERROR {
+		t.Errorf("Expected INTERNAL_ERROR, got %d", jsonrpcResp.Error.Code)
+	}
+	if jsonrpcResp.ID != "req-7" {
+		t.Errorf("Expected id req-7, got %v", jsonrpcResp.ID)
+	}
+}
+
+func TestNormalizeRequestID(t *testing.T) {
+	tests := []struct {
+		id   any
+		want string
+	}{
+		{id: mcp.NewRequestId(int64(1)), want: `1`},
+		{id: float64(42), want: `42`},
+		{id: 1.5, want: `1.5`},
+		{id: "abc", want: `"abc"`},
+		{id: json.Number("9"), want: `9`},
+		{id: 3, want: `3`},
+		{id: nil, want: `null`},
+	}
+
+	for _, tt := range tests {
+		got, err := json.Marshal(normalizeRequestID(tt.id))
+		if err != nil {
+			t.Fatalf("Marshal(%v): %v", tt.id, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("normalizeRequestID(%#v) = %s, want %s", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestAccessPass(t *testing.T) {
+	key := []byte("secret")
+	now := time.Now()
+	pass, err := issueAccessPass(key, "0xpayer", now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyAccessPass(key, pass, now); err != nil {
+		t.Errorf("Expected a valid pass, got %v", err)
+	}
+	if err := verifyAccessPass(key, pass, now.Add(2*time.Hour)); err == nil {
+		t.Error("Expected an expired pass to be rejected")
+	}
+	if err := verifyAccessPass([]byte("other"), pass, now); err == nil {
+		t.Error("Expected a pass signed with another key to be rejected")
+	}
+
+	// Claims can't be changed without invalidating the MAC
+	forged, _ := issueAccessPass([]byte("other"), "0xpayer", now.Add(24*time.Hour))
+	payload, _, _ := strings.Cut(forged, ".")
+	_, mac, _ := strings.Cut(pass, ".")
+	if err := verifyAccessPass(key, payload+"."+mac, now); err == nil {
+		t.Error("Expected a pass with altered claims to be rejected")
+	}
+}
+
+func TestFormatFiat(t *testing.T) {
+	tests := []struct {
+		value    int64
+		decimals int
+		currency string
+		want     string
+	}{
+		{value: 1, decimals: 2, currency: "USD", want: "~$0.01"},
+		{value: 1250, decimals: 2, currency: "USD", want: "~$12.50"},
+		{value: 0, decimals: 2, currency: "USD", want: "<$0.01"},
+		{value: 1000, decimals: 6, currency: "USD", want: "~$0.001"},
+		{value: 45, decimals: 6, currency: "EUR", want: "~€0.00005"},
+		{value: 900, decimals: 2, currency: "CHF", want: "~9.00 CHF"},
+	}
+	for _, tt := range tests {
+		if got := formatFiat(big.NewInt(tt.value), tt.decimals, tt.currency); got != tt.want {
+			t.Errorf("formatFiat(%d, %d, %s) = %q, want %q", tt.value, tt.decimals, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestX402Handler_LogSampling(t *testing.T) {
+	var logs bytes.Buffer
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{
+				Scheme:            "exact",
+				Network:           "test",
+				MaxAmountRequired: "1000",
+				Asset:             "0xusdc",
+				PayTo:             "0x1234567890abcdef1234567890abcdef12345678",
+				MaxTimeoutSeconds: 60,
+			}},
+		},
+		Verbose:       true,
+		Logger:        log.New(&logs, "", 0),
+		LogSampleRate: 3,
+		LogRedaction:  x402.RedactPartial,
+	}
+	handler := NewX402Handler(&mockMCPHandler{}, config)
+
+	send := func(body string) {
+		req := httptest.NewRequest("POST", "/mcp", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for range 4 {
+		send(`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"paid-tool"},"id":1}`)
+	}
+	if n := strings.Count(logs.String(), "Incoming POST"); n != 2 {
+		t.Errorf("Expected 2 of 4 requests logged, got %d:\n%s", n, logs.String())
+	}
+	if strings.Contains(logs.String(), config.PaymentTools["paid-tool"][0].PayTo) {
+		t.Errorf("Expected payTo to be redacted:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "pay to 0x1234…345678") {
+		t.Errorf("Expected partially redacted payTo:\n%s", logs.String())
+	}
+
+	// Failed payments are logged even when the request isn't sampled
+	logs.Reset()
+	send(`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"paid-tool","_meta":{"x402/payment":{"x402Version":1,"scheme":"exact","network":"other","payload":{}}}},"id":2}`)
+	if strings.Contains(logs.String(), "Incoming POST") {
+		t.Errorf("Expected the 5th request not to be sampled:\n%s", logs.String())
+	}
+	if !strings.Contains(logs.String(), "Payment matching failed") {
+		t.Errorf("Expected the failed payment to be logged:\n%s", logs.String())
+	}
+}
+
+func TestChaosFacilitator(t *testing.T) {
+	inner := &countingFacilitator{}
+	ctx := context.Background()
+
+	chaos := NewChaosFacilitator(inner, x402.ChaosConfig{DuplicateRate: 1})
+	if _, err := chaos.Settle(ctx, &PaymentPayload{}, &PaymentRequirement{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(inner.settled) != 2 {
+		t.Errorf("Expected a duplicate settlement, got %d", len(inner.settled))
+	}
+
+	chaos = NewChaosFacilitator(inner, x402.ChaosConfig{MalformedRate: 1})
+	settled, err := chaos.Settle(ctx, &PaymentPayload{}, &PaymentRequirement{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settled.Success || settled.Transaction != "" {
+		t.Errorf("Expected a successful settlement without transaction, got %+v", settled)
+	}
+
+	chaos = NewChaosFacilitator(inner, x402.ChaosConfig{DropRate: 1})
+	if _, err := chaos.Verify(ctx, &PaymentPayload{}, &PaymentRequirement{}); !errors.Is(err, x402.ErrChaosDropped) {
+		t.Errorf("Expected ErrChaosDropped, got %v", err)
+	}
+
+	handler := NewX402Handler(&mockMCPHandler{}, &Config{Facilitator: chaos})
+	if handler.facilitator != chaos {
+		t.Error("Expected Config.Facilitator to be used")
+	}
+}
+
+func TestX402Handler_SettlementFailedError(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient", MaxTimeoutSeconds: 30}},
+		},
+	}
+	handler := NewX402Handler(&mockMCPHandler{}, config)
+	handler.facilitator = &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: false, ErrorReason: "blockhash expired"},
+	}
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, paidToolRequest(t, "paid-tool"))
+
+	var response struct {
+		Error struct {
+			Code    int            `json:"code"`
+			Message string         `json:"message"`
+			Data    map[string]any `json:"data"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Error.Code != mcp.INTERNAL_ERROR || response.Error.Message != "blockhash expired" {
+		t.Errorf("Unexpected error %+v", response.Error)
+	}
+	data := response.Error.Data
+	if data["settlementFailed"] != true || data["network"] != "test" || data["retryWithinSeconds"] != float64(30) {
+		t.Errorf("Expected settlement failure data, got %v", data)
+	}
+}
+
+// conversionFacilitator settles only in the paid asset, recording the
+// settlement asset asked for in each Settle
+type conversionFacilitator struct {
+	MockFacilitator
+	requested []string
+}
+
+func (f *conversionFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	asset := requirement.Extra[ExtraKeySettlementAsset]
+	f.requested = append(f.requested, asset)
+	if asset != "" {
+		return &SettleResponse{Success: false, ErrorReason: ErrorReasonUnsupportedSettlementAsset}, nil
+	}
+	return &SettleResponse{Success: true, Transaction: "0xtx", Network: payment.Network}, nil
+}
+
+func TestX402Handler_SettlementAssets(t *testing.T) {
+	withSupportedPayments(t, []SupportedKind{
+		{X402Version: 1, Scheme: "exact", Network: "test", Extra: map[string]string{ExtraKeySettlementConversion: "true"}},
+		{X402Version: 1, Scheme: "exact", Network: "other"},
+	})
+
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {
+				{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xdai", PayTo: "0xrecipient"},
+				{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xUSDC", PayTo: "0xrecipient"},
+				{Scheme: "exact", Network: "other", MaxAmountRequired: "1000", Asset: "0xdai", PayTo: "0xrecipient"},
+			},
+		},
+		SettlementAssets: map[string][]SettlementAsset{
+			"paid-tool": {{Network: "test", Asset: "0xusdc"}, {Network: "other", Asset: "0xusdc"}},
+		},
+	}
+	handler := NewX402Handler(&mockMCPHandler{response: `{"jsonrpc":"2.0","result":{"content":[]},"id":1}`}, config)
+	facilitator := &conversionFacilitator{MockFacilitator: MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+	}}
+	handler.facilitator = facilitator
+
+	// Only the option paid in another asset, on a converting network, asks for conversion
+	requirements, _ := config.toolRequirements("paid-tool")
+	want := []string{"0xusdc", "", ""}
+	for i, req := range requirements {
+		if got := req.Extra[ExtraKeySettlementAsset]; got != want[i] {
+			t.Errorf("Option %d: expected settlement asset %q, got %q", i, want[i], got)
+		}
+	}
+	if config.PaymentTools["paid-tool"][0].Extra != nil {
+		t.Error("Configured requirement should not be modified")
+	}
+
+	// A facilitator that can't convert settles in the paid asset
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, paidToolRequest(t, "paid-tool"))
+	if !strings.Contains(recorder.Body.String(), "0xtx") {
+		t.Fatalf("Expected settled result, got %s", recorder.Body.String())
+	}
+	if len(facilitator.requested) != 2 || facilitator.requested[0] != "0xusdc" || facilitator.requested[1] != "" {
+		t.Errorf("Expected conversion then same-asset settlement, got %q", facilitator.requested)
+	}
+}
+
+func TestX402Handler_PerItemPricing(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"search": {PaymentRequirement{Network: "test", Asset: "0xusdc", PayTo: "0xrecipient"}.WithPerItemPricing("100", 10, nil)},
+		},
+	}
+	if req := config.PaymentTools["search"][0]; req.Scheme != x402.SchemeUpto || req.MaxAmountRequired != "1000" || req.Extra["unitAmount"] != "100" {
+		t.Fatalf("Unexpected per-item requirement %+v", req)
+	}
+
+	call := func(t *testing.T, response string) (*httptest.ResponseRecorder, *countingFacilitator) {
+		t.Helper()
+		facilitator := &countingFacilitator{}
+		handler := NewX402Handler(&mockMCPHandler{response: response}, config)
+		handler.facilitator = facilitator
+
+		payment, _ := json.Marshal(PaymentPayload{X402Version: 1, Scheme: x402.SchemeUpto, Network: "test", Payload: map[string]any{"signature": "0xsig"}})
+		req := httptest.NewRequest("POST", "/mcp", strings.NewReader(`{"jsonrpc":"2.0","method":"tools/call","params":{"name":"search"},"id":1}`))
+		req.Header.Set(HeaderPayment, base64.StdEncoding.EncodeToString(payment))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr, facilitator
+	}
+	settlementOf := func(t *testing.T, rr *httptest.ResponseRecorder) SettlementResponse {
+		t.Helper()
+		var settlement SettlementResponse
+		decoded, _ := base64.StdEncoding.DecodeString(rr.Header().Get(HeaderPaymentResponse))
+		if err := json.Unmarshal(decoded, &settlement); err != nil {
+			t.Fatalf("Invalid %s header: %q", HeaderPaymentResponse, rr.Header().Get(HeaderPaymentResponse))
+		}
+		return settlement
+	}
+
+	t.Run("ChargesItemsReturned", func(t *testing.T) {
+		rr, facilitator := call(t, `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"a"},{"type":"text","text":"b"},{"type":"text","text":"c"}]},"id":1}`)
+		if len(facilitator.settled) != 1 || facilitator.settled[0] != "300" {
+			t.Fatalf("Expected a settlement of 300, got %v", facilitator.settled)
+		}
+		if s := settlementOf(t, rr); !s.Success || s.Items != 3 || s.Amount != "300" {
+			t.Errorf("Unexpected settlement %+v", s)
+		}
+	})
+
+	t.Run("NoItemsNoSettlement", func(t *testing.T) {
+		rr, facilitator := call(t, `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"failed"}],"isError":true},"id":1}`)
+		if len(facilitator.settled) != 0 {
+			t.Errorf("Expected no settlement, got %v", facilitator.settled)
+		}
+		if s := settlementOf(t, rr); !s.Success || s.Amount != "0" {
+			t.Errorf("Unexpected settlement %+v", s)
+		}
+	})
+}
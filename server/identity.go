@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// ErrorCodeIdentityRejected is the JSON-RPC error code returned when IdentityPolicy refuses a call
+const ErrorCodeIdentityRejected = -32001
+
+// Identity is a verified payer identity, stable across sessions
+type Identity struct {
+	Subject       string // DID or address from the claim
+	Address       string // Address that signed the claim
+	ClientName    string
+	ClientVersion string
+	ExpiresAt     time.Time
+}
+
+type identityKey struct{}
+
+// withIdentity attaches a verified identity to a context
+func withIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the verified payer identity for the current request, if any.
+// Tool handlers behind an X402Handler can use it for reputation and abuse controls.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok && identity != nil
+}
+
+// requestOnBehalfOf returns the end user the payer pays for, from
+// _meta["x402/on-behalf-of"]. ok is false when it is too long.
+func requestOnBehalfOf(meta map[string]any) (beneficiary string, ok bool) {
+	beneficiary, _ = meta[x402.MetaKeyOnBehalfOf].(string)
+	return beneficiary, len(beneficiary) <= x402.MaxOnBehalfOfLength
+}
+
+// requestIdentity verifies the identity claim in the request's _meta.
+// It returns nil without error when no claim is present.
+func (h *X402Handler) requestIdentity(req transport.JSONRPCRequest) (*Identity, error) {
+	raw, ok := requestMeta(req.Params)[x402.MetaKeyIdentity]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	claimBytes, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity claim format")
+	}
+	var claim x402.IdentityClaim
+	if err := json.Unmarshal(claimBytes, &claim); err != nil {
+		return nil, fmt.Errorf("failed to parse identity claim")
+	}
+
+	if h.config.IdentityAudience != "" && claim.Audience != h.config.IdentityAudience {
+		return nil, fmt.Errorf("identity claim audience mismatch")
+	}
+
+	address, err := x402.VerifyIdentityClaim(&claim, h.config.now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Subject:       claim.Subject,
+		Address:       address,
+		ClientName:    claim.ClientName,
+		ClientVersion: claim.ClientVersion,
+		ExpiresAt:     time.Unix(claim.ExpiresAt, 0),
+	}, nil
+}
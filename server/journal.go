@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Settlement journal entry states
+const (
+	// JournalVerified marks a verified payment whose settlement has not completed
+	JournalVerified = "verified"
+	// JournalSettled marks a settled payment
+	JournalSettled = "settled"
+	// JournalFailed marks a payment whose settlement failed
+	JournalFailed = "failed"
+	// JournalRecovering marks a verified payment RecoverSettlements started
+	// settling; it is not retried again, as it may have settled
+	JournalRecovering = "recovering"
+)
+
+// JournalEntry records the settlement state of a verified payment
+type JournalEntry struct {
+	ID          string              `json:"id"`
+	Time        time.Time           `json:"time"`
+	State       string              `json:"state"`
+	Payer       string              `json:"payer,omitempty"`
+	OnBehalfOf  string              `json:"onBehalfOf,omitempty"` // End user the payer paid for
+	RequestID   string              `json:"requestId,omitempty"`  // Client's request ID
+	Payment     *PaymentPayload     `json:"payment"`
+	Requirement *PaymentRequirement `json:"requirement"`
+	Transaction string              `json:"transaction,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	Attempts    int                 `json:"attempts"`
+}
+
+// SettlementJournal persists the settlement state of verified payments, so that
+// payments left unsettled by facilitator outages or crashes can be reconciled
+type SettlementJournal interface {
+	// Record stores the latest state of an entry
+	Record(entry JournalEntry) error
+
+	// Unreconciled returns the entries that are not settled
+	Unreconciled() ([]JournalEntry, error)
+}
+
+// FileSettlementJournal appends journal entries to a file as JSON lines; the
+// last line of an entry's ID holds its current state
+type FileSettlementJournal struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileSettlementJournal opens (or creates) a journal file for appending
+func NewFileSettlementJournal(path string) (*FileSettlementJournal, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open settlement journal: %w", err)
+	}
+	return &FileSettlementJournal{path: path, file: file}, nil
+}
+
+// Record implements SettlementJournal
+func (j *FileSettlementJournal) Record(entry JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Unreconciled implements SettlementJournal
+func (j *FileSettlementJournal) Unreconciled() ([]JournalEntry, error) {
+	j.mu.Lock()
+	data, err := os.ReadFile(j.path)
+	j.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settlement journal: %w", err)
+	}
+
+	latest := make(map[string]JournalEntry)
+	var order []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A crash can leave a partial last line
+			continue
+		}
+		if _, seen := latest[entry.ID]; !seen {
+			order = append(order, entry.ID)
+		}
+		latest[entry.ID] = entry
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read settlement journal: %w", err)
+	}
+
+	var unreconciled []JournalEntry
+	for _, id := range order {
+		if entry := latest[id]; entry.State != JournalSettled {
+			unreconciled = append(unreconciled, entry)
+		}
+	}
+	return unreconciled, nil
+}
+
+// Close closes the journal file
+func (j *FileSettlementJournal) Close() error {
+	return j.file.Close()
+}
+
+// newJournalEntry starts the journal entry of a verified payment
+func newJournalEntry(payment *PaymentPayload, requirement *PaymentRequirement, payer string) JournalEntry {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return JournalEntry{
+		ID:          hex.EncodeToString(id),
+		Time:        time.Now().UTC(),
+		State:       JournalVerified,
+		Payer:       payer,
+		Payment:     payment,
+		Requirement: requirement,
+	}
+}
+
+// settled updates entry with the outcome of a settlement attempt
+func (entry JournalEntry) settled(resp *SettleResponse, err error) JournalEntry {
+	entry.Time = time.Now().UTC()
+	entry.Attempts++
+	switch {
+	case err != nil:
+		entry.State, entry.Error = JournalFailed, err.Error()
+	case resp == nil:
+		entry.State, entry.Error = JournalFailed, "empty settlement response"
+	case !resp.Success:
+		entry.State, entry.Error = JournalFailed, resp.ErrorReason
+	default:
+		entry.State, entry.Error, entry.Transaction = JournalSettled, "", resp.Transaction
+	}
+	return entry
+}
+
+// RecoverSettlements settles the payments in journal left verified by a crash
+// between verification and settlement, and returns the entries that remain
+// unreconciled. Failed settlements were reported to the client as failed, so
+// they are left for the operator. Each entry is marked JournalRecovering before
+// it is settled, so it is never replayed twice. Note that the tool calls these
+// payments were made for were not necessarily served.
+func RecoverSettlements(ctx context.Context, journal SettlementJournal, facilitator Facilitator) ([]JournalEntry, error) {
+	entries, err := journal.Unreconciled()
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []JournalEntry
+	for _, entry := range entries {
+		if entry.State != JournalVerified {
+			remaining = append(remaining, entry)
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		entry.State, entry.Time = JournalRecovering, time.Now().UTC()
+		if err := journal.Record(entry); err != nil {
+			return nil, fmt.Errorf("failed to record recovery of %s: %w", entry.ID, err)
+		}
+		resp, err := facilitator.Settle(ctx, entry.Payment, entry.Requirement)
+		entry = entry.settled(resp, err)
+		if err := journal.Record(entry); err != nil {
+			return nil, fmt.Errorf("failed to record settlement of %s: %w", entry.ID, err)
+		}
+		if entry.State != JournalSettled {
+			remaining = append(remaining, entry)
+		}
+	}
+	return remaining, nil
+}
+
+// RecoverSettlements settles the payments left verified in the configured
+// SettlementJournal, see RecoverSettlements
+func (h *X402Handler) RecoverSettlements(ctx context.Context) ([]JournalEntry, error) {
+	if h.config.SettlementJournal == nil {
+		return nil, fmt.Errorf("no settlement journal configured")
+	}
+	return RecoverSettlements(ctx, h.config.SettlementJournal, h.facilitator)
+}
+
+// recoverOnStart runs RecoverSettlements in the background
+func (h *X402Handler) recoverOnStart() {
+	go func() {
+		remaining, err := h.RecoverSettlements(context.Background())
+		if err != nil {
+			h.logf("[X402] Settlement recovery failed: %v", err)
+			return
+		}
+		if len(remaining) > 0 {
+			h.logf("[X402] Settlement recovery: %d payments remain unreconciled", len(remaining))
+		} else {
+			h.verbosef("[X402] Settlement recovery: all payments reconciled")
+		}
+	}()
+}
+
+// journal records entry in the configured journal, logging failures
+func (h *X402Handler) journal(entry JournalEntry) {
+	if err := h.config.SettlementJournal.Record(entry); err != nil {
+		h.logf("[X402] Failed to journal payment %s (%s): %v", entry.ID, entry.State, err)
+	}
+}
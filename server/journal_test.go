@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestSettlementJournal(t *testing.T) {
+	journal, err := NewFileSettlementJournal(filepath.Join(t.TempDir(), "settlements.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	config := &Config{
+		FacilitatorURL:    "http://mock",
+		SettlementJournal: journal,
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+	}
+	mockHandler := &mockMCPHandler{
+		response: `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"success"}]},"id":1}`,
+	}
+	handler := NewX402Handler(mockHandler, config)
+
+	// The facilitator is down when settling the first payment
+	facilitator := &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: false, ErrorReason: "facilitator unavailable"},
+	}
+	handler.facilitator = facilitator
+	handler.ServeHTTP(httptest.NewRecorder(), paidToolRequest(t, "paid-tool"))
+
+	unreconciled, err := journal.Unreconciled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreconciled) != 1 {
+		t.Fatalf("Expected 1 unreconciled payment, got %d", len(unreconciled))
+	}
+	entry := unreconciled[0]
+	if entry.State != JournalFailed || entry.Error != "facilitator unavailable" || entry.Payer != "0xpayer" || entry.Attempts != 1 {
+		t.Errorf("Unexpected journal entry %+v", entry)
+	}
+	if entry.Requirement == nil || entry.Requirement.MaxAmountRequired != "1000" {
+		t.Errorf("Journal entry should keep the requirement, got %+v", entry.Requirement)
+	}
+
+	// A settled payment is journaled but reconciled
+	facilitator.settleResponse = &SettleResponse{Success: true, Transaction: "0xtx", Network: "test", Payer: "0xpayer"}
+	handler.ServeHTTP(httptest.NewRecorder(), paidToolRequest(t, "paid-tool"))
+	if unreconciled, _ = journal.Unreconciled(); len(unreconciled) != 1 {
+		t.Fatalf("Expected the settled payment to be reconciled, got %d unreconciled", len(unreconciled))
+	}
+
+	// A crash right after verification leaves an entry verified
+	crashed := newJournalEntry(&PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test"},
+		&PaymentRequirement{Scheme: "exact", Network: "test", MaxAmountRequired: "1000"}, "0xpayer")
+	if err := journal.Record(crashed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovery settles the crashed payment, but not the one reported as failed
+	facilitator.settleResponse = &SettleResponse{Success: true, Transaction: "0xrecovered", Network: "test", Payer: "0xpayer"}
+	facilitator.settleCalled = false
+	remaining, err := handler.RecoverSettlements(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].ID != entry.ID || remaining[0].State != JournalFailed || remaining[0].Attempts != 1 {
+		t.Errorf("Expected only the failed payment to remain, got %+v", remaining)
+	}
+	if !facilitator.settleCalled {
+		t.Error("Recovery should settle the crashed payment")
+	}
+
+	// Reopening the journal (after a restart) sees the same state
+	reopened, err := NewFileSettlementJournal(journal.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if unreconciled, _ = reopened.Unreconciled(); len(unreconciled) != 1 || unreconciled[0].ID != entry.ID {
+		t.Errorf("Expected the failed payment unreconciled after reopening, got %+v", unreconciled)
+	}
+}
+
+func TestRecoverSettlements_StillFailing(t *testing.T) {
+	journal, err := NewFileSettlementJournal(filepath.Join(t.TempDir(), "settlements.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	// A crash right after verification leaves the entry verified
+	entry := newJournalEntry(&PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test"},
+		&PaymentRequirement{Scheme: "exact", Network: "test", MaxAmountRequired: "1000"}, "0xpayer")
+	if err := journal.Record(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	facilitator := &MockFacilitator{settleResponse: &SettleResponse{Success: false, ErrorReason: "authorization expired"}}
+	remaining, err := RecoverSettlements(context.Background(), journal, facilitator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].State != JournalFailed || remaining[0].Error != "authorization expired" {
+		t.Errorf("Expected the payment to remain unreconciled, got %+v", remaining)
+	}
+	if !facilitator.settleCalled {
+		t.Error("Recovery should retry the settlement")
+	}
+
+	// The failure is definitive; a later recovery leaves it alone
+	facilitator.settleCalled = false
+	if _, err := RecoverSettlements(context.Background(), journal, facilitator); err != nil {
+		t.Fatal(err)
+	}
+	if facilitator.settleCalled {
+		t.Error("Recovery should not retry a failed settlement")
+	}
+}
+
+func TestRecoverSettlements_NotReplayed(t *testing.T) {
+	journal, err := NewFileSettlementJournal(filepath.Join(t.TempDir(), "settlements.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	// A crash during recovery leaves the entry recovering
+	entry := newJournalEntry(&PaymentPayload{X402Version: 1, Scheme: "exact", Network: "test"},
+		&PaymentRequirement{Scheme: "exact", Network: "test", MaxAmountRequired: "1000"}, "0xpayer")
+	entry.State = JournalRecovering
+	if err := journal.Record(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	facilitator := &MockFacilitator{settleResponse: &SettleResponse{Success: true, Transaction: "0xtx"}}
+	remaining, err := RecoverSettlements(context.Background(), journal, facilitator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facilitator.settleCalled {
+		t.Error("Recovery should not settle a payment it already started settling")
+	}
+	if len(remaining) != 1 || remaining[0].State != JournalRecovering {
+		t.Errorf("Expected the payment to remain for the operator, got %+v", remaining)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrorCodeServerBusy is the JSON-RPC error code returned when the server sheds
+// a paid request because all facilitator slots and queue positions are taken.
+// Clients should treat it as retryable.
+const ErrorCodeServerBusy = -32000
+
+// errSettlementSaturated is returned by the settlement limiter when the queue is full
+var errSettlementSaturated = errors.New("settlement capacity exhausted")
+
+// SettlementStats reports the current load on facilitator calls
+type SettlementStats struct {
+	InFlight int   // Facilitator verify/settle calls currently running
+	Queued   int64 // Requests waiting for a free slot
+	Rejected int64 // Requests shed since the handler was created
+}
+
+// settlementLimiter bounds concurrent facilitator calls with a semaphore
+// and a bounded wait queue. A nil limiter imposes no limits.
+type settlementLimiter struct {
+	slots    chan struct{}
+	maxQueue int64
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+// newSettlementLimiter creates a limiter, or returns nil if maxConcurrent is not positive
+func newSettlementLimiter(maxConcurrent, maxQueue int) *settlementLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &settlementLimiter{
+		slots:    make(chan struct{}, maxConcurrent),
+		maxQueue: int64(maxQueue),
+	}
+}
+
+// acquire reserves a slot, waiting in the queue if one is available.
+// The returned release func is safe to call more than once.
+func (l *settlementLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaseFunc(), nil
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		l.rejected.Add(1)
+		return nil, errSettlementSaturated
+	}
+	defer l.queued.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return l.releaseFunc(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releaseFunc returns an idempotent func that frees one slot
+func (l *settlementLimiter) releaseFunc() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-l.slots })
+	}
+}
+
+// stats returns a snapshot of the limiter state
+func (l *settlementLimiter) stats() SettlementStats {
+	if l == nil {
+		return SettlementStats{}
+	}
+	return SettlementStats{
+		InFlight: len(l.slots),
+		Queued:   l.queued.Load(),
+		Rejected: l.rejected.Load(),
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+type logSampledKey struct{}
+
+// withLogSample decides whether the verbose logs of r are sampled
+func (h *X402Handler) withLogSample(r *http.Request) *http.Request {
+	if !h.config.Verbose {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), logSampledKey{}, h.logSampler.Sample()))
+}
+
+// debugf logs request detail in verbose mode when the request in ctx is sampled
+func (h *X402Handler) debugf(ctx context.Context, format string, args ...any) {
+	if !h.config.Verbose {
+		return
+	}
+	if sampled, ok := ctx.Value(logSampledKey{}).(bool); ok && !sampled {
+		return
+	}
+	h.logger.Printf(format, args...)
+}
+
+// verbosef logs in verbose mode whether or not the request is sampled, for failed
+// payments and events outside requests
+func (h *X402Handler) verbosef(format string, args ...any) {
+	if h.config.Verbose {
+		h.logger.Printf(format, args...)
+	}
+}
+
+// logf logs an error regardless of verbosity
+func (h *X402Handler) logf(format string, args ...any) {
+	h.logger.Printf(format, args...)
+}
+
+// redact masks a payer-identifying value according to LogRedaction
+func (h *X402Handler) redact(value string) string {
+	return h.config.LogRedaction.Redact(value)
+}
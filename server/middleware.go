@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// PaymentInfo describes the payment that was verified and settled for a request
+type PaymentInfo struct {
+	Payment      *PaymentPayload
+	Requirement  *PaymentRequirement // The option the client chose to pay
+	Verification *VerifyResponse     // The facilitator's verification of the payment
+	Settlement   *SettleResponse
+	Timing       PaymentTiming
+	Reference    string // Client-supplied payment reference, if any
+	OnBehalfOf   string // End user an organization's payer paid for, if the client named one
+	RequestID    string // Client's request ID, from Config.RequestIDHeader
+	Items        int    // Items charged for under per-item pricing
+
+	// PendingSettlement is the payment ID of a settlement that outlived
+	// Config.SettlementTimeout and continues in the background
+	PendingSettlement string
+
+	batchToken     string        // Token redeeming the rest of a batch paid by this request
+	depositBalance *x402.Deposit // Deposit balance left after a call drawn from it
+	payer          string        // Payer reported by verification
+}
+
+// settlementResponse returns the settlement reported to the client for the payment
+func (info *PaymentInfo) settlementResponse() SettlementResponse {
+	settleResp := info.Settlement
+	settlement := SettlementResponse{
+		Success:     settleResp.Success,
+		Transaction: settleResp.Transaction,
+		Network:     settleResp.Network,
+		Payer:       settleResp.Payer,
+		Reference:   info.Reference,
+		OnBehalfOf:  info.OnBehalfOf,
+	}
+	if info.Requirement != nil && info.Requirement.PerItem != nil {
+		settlement.Items, settlement.Amount = info.Items, info.Requirement.MaxAmountRequired
+	}
+	if info.PendingSettlement != "" {
+		settlement.Status, settlement.PaymentID = x402.SettlementStatusPending, info.PendingSettlement
+	} else if settleResp.Status == x402.SettlementStatusPending {
+		settlement.Status = x402.SettlementStatusPending
+	}
+	return settlement
+}
+
+type paymentInfoKey struct{}
+
+// withPaymentInfo attaches payment info to a context
+func withPaymentInfo(ctx context.Context, info *PaymentInfo) context.Context {
+	return context.WithValue(ctx, paymentInfoKey{}, info)
+}
+
+// PaymentFromContext returns the payment settled for the current request, if any.
+// Tool handlers behind an X402Handler can use it to inspect the payer and transaction.
+// Per-item payments are settled after the tool returns; their Settlement is nil
+// while the tool runs.
+func PaymentFromContext(ctx context.Context) (*PaymentInfo, bool) {
+	info, ok := ctx.Value(paymentInfoKey{}).(*PaymentInfo)
+	return info, ok && info != nil
+}
+
+// ToolPaymentMiddleware returns a server.ToolHandlerMiddleware that refuses to run
+// paid tools unless their payment was settled by an X402Handler for this request,
+// or a free trial covered it.
+// It guards against the MCPServer being reachable without the payment layer.
+func ToolPaymentMiddleware(config *Config) server.ToolHandlerMiddleware {
+	return func(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+		return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			toolName := request.Params.Name
+			if _, paid, err := config.requirementsFor(ctx, toolName); err != nil {
+				return nil, err
+			} else if !paid {
+				return next(ctx, request)
+			}
+
+			if trial, ok := TrialFromContext(ctx); ok && trial.Tool == toolName {
+				return next(ctx, request)
+			}
+
+			info, ok := PaymentFromContext(ctx)
+			if !ok || info.Requirement == nil || (info.Requirement.Resource != fmt.Sprintf("mcp://tools/%s", toolName) &&
+				info.Requirement.Resource != x402.BatchResource) {
+				return nil, fmt.Errorf("payment required for tool %s", toolName)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// WithPayments returns a server.ServerOption installing ToolPaymentMiddleware,
+// for use when constructing your own *server.MCPServer
+func WithPayments(config *Config) server.ServerOption {
+	return server.WithToolHandlerMiddleware(ToolPaymentMiddleware(config))
+}
+
+// NewStreamableHTTPHandler serves an existing *server.MCPServer over streamable HTTP
+// with the x402 payment layer in front of it. Hooks, capabilities and session tools
+// configured on mcpServer are preserved.
+func NewStreamableHTTPHandler(mcpServer *server.MCPServer, config *Config, opts ...server.StreamableHTTPOption) *X402Handler {
+	if config.notify == nil {
+		config.notify = mcpServer.SendNotificationToSpecificClient
+	}
+	return NewX402Handler(server.NewStreamableHTTPServer(mcpServer, opts...), config)
+}
+
+// Middleware returns the x402 payment layer as standard net/http middleware, for
+// mounting in front of an MCP endpoint in routers that own the mux and listener
+// (chi's With/Use, echo.WrapMiddleware, gin.WrapH). Create the MCPServer behind it
+// with WithPayments(config) so paid tools can't run unpaid.
+func Middleware(config *Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return NewX402Handler(next, config)
+	}
+}
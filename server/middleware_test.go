@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func TestNewStreamableHTTPHandler_ExistingServer(t *testing.T) {
+	config := &Config{
+		FacilitatorURL: "http://mock",
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+	}
+
+	var seenPayer string
+	mcpServer := server.NewMCPServer("existing", "1.0", WithPayments(config))
+	mcpServer.AddTool(mcp.NewTool("paid-tool"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		if info, ok := PaymentFromContext(ctx); ok {
+			seenPayer = info.Settlement.Payer
+		}
+		return mcp.NewToolResultText("paid result"), nil
+	})
+
+	handler := NewStreamableHTTPHandler(mcpServer, config, server.WithStateLess(true))
+	handler.facilitator = &MockFacilitator{
+		verifyResponse: &VerifyResponse{IsValid: true, Payer: "0xpayer"},
+		settleResponse: &SettleResponse{Success: true, Transaction: "0xtx", Network: "test", Payer: "0xpayer"},
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, paidToolRequest(t, "paid-tool"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "paid result") {
+		t.Fatalf("Expected tool result, got %s", rr.Body.String())
+	}
+	if seenPayer != "0xpayer" {
+		t.Errorf("Tool handler should see payment in context, got payer %q", seenPayer)
+	}
+}
+
+func TestToolPaymentMiddleware_RejectsUnpaidCalls(t *testing.T) {
+	config := &Config{
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000"}},
+		},
+	}
+
+	called := false
+	next := func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		called = true
+		return mcp.NewToolResultText("ok"), nil
+	}
+	wrapped := ToolPaymentMiddleware(config)(next)
+
+	var req mcp.CallToolRequest
+	req.Params.Name = "paid-tool"
+	if _, err := wrapped(context.Background(), req); err == nil || called {
+		t.Fatal("Paid tool should not run without a settled payment")
+	}
+
+	req.Params.Name = "free-tool"
+	if _, err := wrapped(context.Background(), req); err != nil || !called {
+		t.Fatal("Free tool should run without payment")
+	}
+
+}
+
+func TestMiddleware_RouterMount(t *testing.T) {
+	facilitator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/verify":
+			_ = json.NewEncoder(w).Encode(VerifyResponse{IsValid: true, Payer: "0xpayer"})
+		case "/settle":
+			_ = json.NewEncoder(w).Encode(SettleResponse{Success: true, Transaction: "0xtx", Network: "test", Payer: "0xpayer"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer facilitator.Close()
+
+	config := &Config{
+		FacilitatorURL: facilitator.URL,
+		PaymentTools: map[string][]PaymentRequirement{
+			"paid-tool": {{Scheme: "exact", Network: "test", MaxAmountRequired: "1000", Asset: "0xusdc", PayTo: "0xrecipient"}},
+		},
+	}
+	mcpServer := server.NewMCPServer("routed", "1.0", WithPayments(config))
+	mcpServer.AddTool(mcp.NewTool("paid-tool"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("paid result"), nil
+	})
+
+	// The application owns the mux; only the MCP route is wrapped
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", Middleware(config)(server.NewStreamableHTTPServer(mcpServer, server.WithStateLess(true))))
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/health", nil))
+	if rr.Body.String() != "ok" {
+		t.Errorf("Expected other routes to be untouched, got %s", rr.Body.String())
+	}
+
+	unpaid := paidToolRequest(t, "paid-tool")
+	unpaid.Body = io.NopCloser(strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"paid-tool"}}`))
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, unpaid)
+	if !strings.Contains(rr.Body.String(), `"code":402`) {
+		t.Errorf("Expected a 402 error for an unpaid call, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, paidToolRequest(t, "paid-tool"))
+	if !strings.Contains(rr.Body.String(), "paid result") || rr.Header().Get(HeaderPaymentResponse) == "" {
+		t.Errorf("Expected a settled tool result, got %s", rr.Body.String())
+	}
+}
+
+func TestX402Server_MCPServerAccess(t *testing.T) {
+	srv := NewX402Server("test", "1.0", &Config{}, server.WithInstructions("pay first"))
+	if srv.MCPServer() == nil {
+		t.Fatal("Expected underlying MCP server")
+	}
+
+	srv.AddResource(mcp.NewResource("test://receipt", "receipt"), func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		return []mcp.ResourceContents{mcp.TextResourceContents{URI: "test://receipt", Text: "ok"}}, nil
+	})
+
+	srv.AddPayableTool(mcp.NewTool("paid-tool"), func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		return mcp.NewToolResultText("ok"), nil
+	}, PaymentRequirement{Scheme: "exact", Network: "test", MaxAmountRequired: "1000"})
+
+	if _, ok := srv.config.PaymentTools["paid-tool"]; !ok {
+		t.Error("Payment registration should be kept")
+	}
+	if srv.MCPServer().GetTool("paid-tool") == nil {
+		t.Error("Paid tool should be registered on the MCP server")
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// PaymentTiming records how long the facilitator took with a payment
+type PaymentTiming struct {
+	Verify    time.Duration // Facilitator verification
+	Settle    time.Duration // Settlement, zero until settled and in verify-only mode
+	SettledAt time.Time     // When settlement completed, zero until settled
+}
+
+// settled records a settlement that started at start
+func (t *PaymentTiming) settled(start time.Time) {
+	t.SettledAt = time.Now()
+	t.Settle = t.SettledAt.Sub(start)
+}
+
+// RequirementFromContext returns the payment option the client paid for the
+// current request, e.g. to return better results when a pricier option was
+// chosen. The requirement is the one offered to the client, with the prices
+// of pricing experiments and IdentityPolicy applied.
+func RequirementFromContext(ctx context.Context) (*PaymentRequirement, bool) {
+	info, ok := PaymentFromContext(ctx)
+	if !ok || info.Requirement == nil {
+		return nil, false
+	}
+	return info.Requirement, true
+}
+
+// VerificationFromContext returns the facilitator's verification of the payment
+// for the current request
+func VerificationFromContext(ctx context.Context) (*VerifyResponse, bool) {
+	info, ok := PaymentFromContext(ctx)
+	if !ok || info.Verification == nil {
+		return nil, false
+	}
+	return info.Verification, true
+}
+
+// PaymentTimingFromContext returns how long verification and settlement of the
+// payment for the current request took. Per-item payments settle after the tool
+// returns, so their settlement timing is zero while it runs.
+func PaymentTimingFromContext(ctx context.Context) (PaymentTiming, bool) {
+	info, ok := PaymentFromContext(ctx)
+	if !ok {
+		return PaymentTiming{}, false
+	}
+	return info.Timing, true
+}
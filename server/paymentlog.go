@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go-x402"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// sessionSpend aggregates the payments of each MCP session for payment logs,
+// and remembers the log level each session set with logging/setLevel
+type sessionSpend struct {
+	mu       sync.Mutex
+	sessions map[string]*x402.SpendTally
+	levels   map[string]mcp.LoggingLevel
+}
+
+func newSessionSpend() *sessionSpend {
+	return &sessionSpend{
+		sessions: make(map[string]*x402.SpendTally),
+		levels:   make(map[string]mcp.LoggingLevel),
+	}
+}
+
+// tally returns the tally of a session, creating it if needed
+func (s *sessionSpend) tally(sessionID string) *x402.SpendTally {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tally, ok := s.sessions[sessionID]
+	if !ok {
+		tally = &x402.SpendTally{}
+		s.sessions[sessionID] = tally
+	}
+	return tally
+}
+
+// setLevel records the log level a session set
+func (s *sessionSpend) setLevel(sessionID string, level mcp.LoggingLevel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.levels[sessionID] = level
+}
+
+// wants reports whether a session receives messages of level. Like MCPServer,
+// sessions that never set a level only receive errors.
+func (s *sessionSpend) wants(sessionID string, level mcp.LoggingLevel) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sessionLevel, ok := s.levels[sessionID]
+	if !ok {
+		sessionLevel = mcp.LoggingLevelError
+	}
+	return level.ShouldSendTo(sessionLevel)
+}
+
+// forget drops the state of a closed session
+func (s *sessionSpend) forget(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sessionID)
+	delete(s.levels, sessionID)
+}
+
+// recordLogLevel remembers the level of a logging/setLevel request passing
+// through to the MCP handler
+func (h *X402Handler) recordLogLevel(r *http.Request, req transport.JSONRPCRequest) {
+	sessionID := r.Header.Get(server.HeaderKeySessionID)
+	if !h.config.PaymentLogNotifications || sessionID == "" {
+		return
+	}
+	var params mcp.SetLevelParams
+	paramsBytes, _ := json.Marshal(req.Params)
+	if json.Unmarshal(paramsBytes, &params) == nil && params.Level != "" {
+		h.spend.setLevel(sessionID, params.Level)
+	}
+}
+
+type paymentLogKey struct{}
+
+// withPaymentLog counts a settled payment in its session's tally and attaches
+// its log message to r, when the session receives info messages
+func (h *X402Handler) withPaymentLog(r *http.Request, info *PaymentInfo) *http.Request {
+	sessionID := r.Header.Get(server.HeaderKeySessionID)
+	if !h.config.PaymentLogNotifications || sessionID == "" || info.Settlement == nil || info.Requirement == nil {
+		return r
+	}
+
+	amount, _ := new(big.Int).SetString(info.Requirement.MaxAmountRequired, 10)
+	log := x402.PaymentLog{
+		Source:       "server",
+		Event:        string(x402.PaymentEventSuccess),
+		Resource:     info.Requirement.Resource,
+		Network:      info.Requirement.Network,
+		Asset:        info.Requirement.Asset,
+		Amount:       info.Requirement.MaxAmountRequired,
+		Recipient:    info.Requirement.PayTo,
+		Payer:        info.Settlement.Payer,
+		Transaction:  info.Settlement.Transaction,
+		FiatEstimate: info.Requirement.Extra[x402.ExtraKeyFiatEstimate],
+	}
+	log.Payments, log.Totals = h.spend.tally(sessionID).Add(log.Network, log.Asset, amount)
+
+	if !h.spend.wants(sessionID, mcp.LoggingLevelInfo) || !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), paymentLogKey{}, log))
+}
+
+// addPaymentLog streams the payment log attached to ctx ahead of a tool call
+// response, switching a JSON response to an SSE stream. It returns the body and
+// content type unchanged when no log is attached.
+func addPaymentLog(ctx context.Context, body []byte, contentType string) ([]byte, string) {
+	log, ok := ctx.Value(paymentLogKey{}).(x402.PaymentLog)
+	if !ok {
+		return body, contentType
+	}
+	notification, err := json.Marshal(x402.NewPaymentLogNotification(log))
+	if err != nil {
+		return body, contentType
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "event: message\ndata: %s\n\n", notification)
+	switch {
+	case strings.HasPrefix(contentType, "text/event-stream"):
+		out.Write(body)
+	case contentType == "application/json":
+		fmt.Fprintf(&out, "event: message\ndata: %s\n\n", bytes.TrimSpace(body))
+	default:
+		return body, contentType
+	}
+	return out.Bytes(), "text/event-stream"
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// maxPendingSettlement bounds a settlement continued in the background
+	maxPendingSettlement = 10 * time.Minute
+
+	// maxPendingSettlements is the number of pending settlement statuses kept
+	maxPendingSettlements = 1000
+)
+
+// pendingSettlement is a settlement that outlived Config.SettlementTimeout
+type pendingSettlement struct {
+	status x402.SettlementStatus
+
+	// The paid request's payment, set once it was served
+	info      *PaymentInfo
+	sessionID string
+}
+
+// pendingSettlements tracks settlements continued in the background
+type pendingSettlements struct {
+	mu    sync.Mutex
+	order []string
+	byID  map[string]*pendingSettlement
+}
+
+// add tracks a new pending settlement, evicting the oldest when full
+func (p *pendingSettlements) add(status x402.SettlementStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.byID == nil {
+		p.byID = make(map[string]*pendingSettlement)
+	}
+	p.byID[status.PaymentID] = &pendingSettlement{status: status}
+	p.order = append(p.order, status.PaymentID)
+	for len(p.order) > maxPendingSettlements {
+		delete(p.byID, p.order[0])
+		p.order = p.order[1:]
+	}
+}
+
+// get returns the status of a pending settlement
+func (p *pendingSettlements) get(paymentID string) (x402.SettlementStatus, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.byID[paymentID]
+	if !ok {
+		return x402.SettlementStatus{}, false
+	}
+	return entry.status, true
+}
+
+// complete records the outcome of a pending settlement. It returns the entry
+// when its request was already served, so the outcome can be reported.
+func (p *pendingSettlements) complete(status x402.SettlementStatus) (*pendingSettlement, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.byID[status.PaymentID]
+	if !ok {
+		return nil, false
+	}
+	entry.status = status
+	return entry, entry.info != nil
+}
+
+// served records the request a pending settlement paid for. It returns the
+// entry when the settlement already completed, so the outcome can be reported.
+func (p *pendingSettlements) served(info *PaymentInfo, sessionID string) (*pendingSettlement, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.byID[info.PendingSettlement]
+	if !ok {
+		return nil, false
+	}
+	entry.info, entry.sessionID = info, sessionID
+	return entry, entry.status.Status != x402.SettlementStatusPending
+}
+
+type settlementWaitKey struct{}
+
+// withSettlementWait marks the payment in ctx as granting credit beyond its
+// request (a deposit, access fee or batch), so it is only honored once settled
+func withSettlementWait(ctx context.Context) context.Context {
+	return context.WithValue(ctx, settlementWaitKey{}, true)
+}
+
+// settleWithin settles a verified payment, giving up waiting after
+// Config.SettlementTimeout. Settlement then continues in the background and
+// the returned payment ID tracks it; the response reports success so the
+// verified payment is served. Payments marked by withSettlementWait always
+// wait for settlement.
+func (h *X402Handler) settleWithin(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement, payer, onBehalfOf string) (*SettleResponse, string, error) {
+	if wait, _ := ctx.Value(settlementWaitKey{}).(bool); h.config.SettlementTimeout <= 0 || wait {
+		resp, err := h.settle(ctx, payment, requirement, payer, onBehalfOf)
+		return resp, "", err
+	}
+
+	type result struct {
+		resp *SettleResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	settleCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), maxPendingSettlement)
+	go func() {
+		defer cancel()
+		resp, err := h.settle(settleCtx, payment, requirement, payer, onBehalfOf)
+		done <- result{resp, err}
+	}()
+
+	timer := time.NewTimer(h.config.SettlementTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.resp, "", r.err
+	case <-timer.C:
+	case <-ctx.Done():
+		// The client left; settle anyway, as the payment was verified
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	paymentID := hex.EncodeToString(id)
+	h.config.pending.add(x402.SettlementStatus{
+		PaymentID: paymentID,
+		Status:    x402.SettlementStatusPending,
+		Network:   requirement.Network,
+		Payer:     payer,
+		UpdatedAt: h.config.now().UTC(),
+	})
+	h.verbosef("[X402] Settlement exceeded %s, continuing in the background as payment %s", h.config.SettlementTimeout, paymentID)
+
+	go func() {
+		r := <-done
+		status := x402.SettlementStatus{
+			PaymentID: paymentID,
+			Status:    x402.SettlementStatusSettled,
+			Network:   requirement.Network,
+			Payer:     payer,
+			UpdatedAt: h.config.now().UTC(),
+		}
+		switch {
+		case r.err != nil:
+			status.Status, status.ErrorReason = x402.SettlementStatusFailed, r.err.Error()
+		case !r.resp.Success:
+			status.Status, status.ErrorReason = x402.SettlementStatusFailed, r.resp.ErrorReason
+		case r.resp.Status == x402.SettlementStatusPending:
+			status.Status, status.Transaction = x402.SettlementStatusPending, r.resp.Transaction
+		default:
+			status.Transaction = r.resp.Transaction
+		}
+		h.verbosef("[X402] Pending payment %s %s", paymentID, status.Status)
+		if entry, ok := h.config.pending.complete(status); ok {
+			h.reportSettlement(entry)
+		}
+	}()
+
+	return &SettleResponse{Success: true, Network: requirement.Network, Payer: payer}, paymentID, nil
+}
+
+// recordPendingReceipt records the request a pending settlement paid for,
+// reporting the outcome if the settlement already completed
+func (h *X402Handler) recordPendingReceipt(info *PaymentInfo, sessionID string) {
+	if entry, ok := h.config.pending.served(info, sessionID); ok {
+		h.reportSettlement(entry)
+	}
+}
+
+// reportSettlement records the receipt of a completed pending settlement and
+// notifies the paying session of its outcome
+func (h *X402Handler) reportSettlement(entry *pendingSettlement) {
+	status := entry.status
+	if status.Status == x402.SettlementStatusSettled && h.config.Receipts != nil {
+		info := *entry.info
+		info.Settlement = &SettleResponse{Success: true, Transaction: status.Transaction, Network: status.Network, Payer: status.Payer}
+		h.config.Receipts.add(newReceipt(&info, entry.sessionID))
+	}
+
+	if h.config.notify == nil || entry.sessionID == "" {
+		return
+	}
+	var params map[string]any
+	data, _ := json.Marshal(status)
+	if err := json.Unmarshal(data, &params); err != nil {
+		return
+	}
+	if err := h.config.notify(entry.sessionID, x402.MethodSettlementStatus, params); err != nil {
+		h.verbosef("[X402] Notifying session of payment %s: %v", status.PaymentID, err)
+	}
+}
+
+// AddSettlementResources exposes the status of settlements that outlived
+// config.SettlementTimeout on mcpServer, under the x402://settlements/{paymentId}
+// resource template. Payment IDs are unguessable, so anyone holding one may read
+// its status. NewX402Server registers it when SettlementTimeout is set.
+func AddSettlementResources(mcpServer *server.MCPServer, config *Config) {
+	mcpServer.AddResourceTemplate(
+		mcp.NewResourceTemplate(x402.SettlementStatusURIPrefix+"{paymentId}", "Settlement status",
+			mcp.WithTemplateDescription("Status of an x402 payment whose settlement was pending when its request was served"),
+			mcp.WithTemplateMIMEType("application/json"),
+		),
+		func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			paymentID := strings.TrimPrefix(request.Params.URI, x402.SettlementStatusURIPrefix)
+			status, ok := config.pending.get(paymentID)
+			if !ok {
+				return nil, fmt.Errorf("no pending settlement %s", paymentID)
+			}
+			return receiptContents(request.Params.URI, status)
+		},
+	)
+}
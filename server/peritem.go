@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// CountFunc counts the items of a tool result that a per-item price applies to.
+// Failed calls, without a result, count as no items.
+type CountFunc func(result *mcp.CallToolResult) int
+
+// CountContent counts the content blocks of a tool result
+func CountContent(result *mcp.CallToolResult) int {
+	if result.IsError {
+		return 0
+	}
+	return len(result.Content)
+}
+
+// PerItemPricing charges a tool call UnitAmount for each item its result holds,
+// as counted by Count, up to MaxItems
+type PerItemPricing struct {
+	UnitAmount string
+	MaxItems   int
+	Count      CountFunc
+}
+
+// charge returns the amount owed for count items, capped at bound
+func (p *PerItemPricing) charge(count int, bound string) string {
+	unit, ok := new(big.Int).SetString(p.UnitAmount, 10)
+	if !ok {
+		return bound
+	}
+	amount := new(big.Int).Mul(unit, big.NewInt(int64(count)))
+	if limit, ok := new(big.Int).SetString(bound, 10); ok && amount.Cmp(limit) > 0 {
+		amount = limit
+	}
+	return amount.String()
+}
+
+// WithPerItemPricing returns a copy of the requirement charging unitAmount per
+// item of the tool's result, as counted by count (CountContent when nil). The
+// client authorizes maxItems items under the x402.SchemeUpto scheme; the
+// payment is settled after the tool returns, for the items it returned. Settling
+// less than the amount authorized requires a facilitator supporting "upto".
+func (r PaymentRequirement) WithPerItemPricing(unitAmount string, maxItems int, count CountFunc) PaymentRequirement {
+	if count == nil {
+		count = CountContent
+	}
+	r.PerItem = &PerItemPricing{UnitAmount: unitAmount, MaxItems: maxItems, Count: count}
+	r.Scheme = x402.SchemeUpto
+	if unit, ok := new(big.Int).SetString(unitAmount, 10); ok {
+		r.MaxAmountRequired = new(big.Int).Mul(unit, big.NewInt(int64(maxItems))).String()
+	}
+
+	extra := cloneStringMap(r.Extra)
+	if extra == nil {
+		extra = make(map[string]string)
+	}
+	extra["unitAmount"] = unitAmount
+	extra["maxItems"] = strconv.Itoa(maxItems)
+	r.Extra = extra
+	return r
+}
+
+// settlePerItem settles a verified per-item payment for the items of the tool
+// result in body, updating info with the settlement and the amount charged
+func (h *X402Handler) settlePerItem(ctx context.Context, info *PaymentInfo, body []byte, contentType string) error {
+	pricing := info.Requirement.PerItem
+	var count int
+	if result := callToolResult(body, contentType); result != nil {
+		count = max(pricing.Count(result), 0)
+	}
+	if pricing.MaxItems > 0 && count > pricing.MaxItems {
+		count = pricing.MaxItems
+	}
+
+	charged := *info.Requirement
+	charged.MaxAmountRequired = pricing.charge(count, info.Requirement.MaxAmountRequired)
+	info.Requirement, info.Items = &charged, count
+	h.debugf(ctx, "[X402] Tool returned %d items, charging %s", count, charged.MaxAmountRequired)
+
+	// Nothing to transfer; the authorization expires unused
+	if charged.MaxAmountRequired == "0" {
+		info.Settlement = &SettleResponse{Success: true, Network: charged.Network, Payer: info.payer}
+		return nil
+	}
+
+	release, err := h.settlements.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	settleStart := time.Now()
+	settleResp, pendingID, err := h.settleWithin(withRequestID(ctx, info.RequestID), info.Payment, &charged, info.payer, info.OnBehalfOf)
+	info.Timing.settled(settleStart)
+	if err != nil {
+		return err
+	}
+	if !settleResp.Success {
+		return fmt.Errorf("%s", settleResp.ErrorReason)
+	}
+	info.Settlement, info.PendingSettlement = settleResp, pendingID
+	return nil
+}
+
+// callToolResult returns the tool result of a JSON-RPC response body, sent as
+// JSON or in an SSE stream, or nil if the call failed
+func callToolResult(body []byte, contentType string) *mcp.CallToolResult {
+	data := body
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		data = nil
+		for _, line := range bytes.Split(body, []byte("\n")) {
+			event, ok := bytes.CutPrefix(line, []byte("data:"))
+			if !ok {
+				continue
+			}
+			var message struct {
+				Method string          `json:"method"`
+				Result json.RawMessage `json:"result"`
+			}
+			if json.Unmarshal(bytes.TrimSpace(event), &message) == nil && message.Method == "" && message.Result != nil {
+				data = bytes.TrimSpace(event)
+			}
+		}
+	}
+
+	var jsonrpcResp transport.JSONRPCResponse
+	if err := json.Unmarshal(data, &jsonrpcResp); err != nil || jsonrpcResp.Error != nil || jsonrpcResp.Result == nil {
+		return nil
+	}
+	result, err := mcp.ParseCallToolResult(&jsonrpcResp.Result)
+	if err != nil {
+		return nil
+	}
+	return result
+}
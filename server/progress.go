@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/mark3labs/mcp-go-x402"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// paymentProgressSteps is the progress total reported while verifying and settling a payment
+const paymentProgressSteps = 3
+
+// progressWriter streams MCP progress notifications while a payment is verified and
+// settled. The first notification switches the response to an SSE stream; the final
+// JSON-RPC response, written later through the writer, is sent as the last event.
+// If no notification is sent the response passes through unchanged.
+type progressWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	token   mcp.ProgressToken
+
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+
+	streaming bool
+	progress  int
+	total     int
+}
+
+// newProgressWriter returns a progressWriter for the request, or nil if payment
+// progress is disabled, the call has no progress token or the client doesn't
+// accept event streams
+func (h *X402Handler) newProgressWriter(w http.ResponseWriter, r *http.Request, meta *mcp.Meta) *progressWriter {
+	if !h.config.PaymentProgress || meta == nil || meta.ProgressToken == nil {
+		return nil
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return nil
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil
+	}
+
+	total := paymentProgressSteps
+	if h.config.VerifyOnly {
+		total--
+	}
+	return &progressWriter{
+		w:          w,
+		flusher:    flusher,
+		token:      meta.ProgressToken,
+		header:     make(http.Header),
+		statusCode: http.StatusOK,
+		total:      total,
+	}
+}
+
+func (p *progressWriter) Header() http.Header {
+	return p.header
+}
+
+func (p *progressWriter) WriteHeader(statusCode int) {
+	p.statusCode = statusCode
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	return p.body.Write(b)
+}
+
+// notify sends a progress notification with meta, starting the event stream if needed
+func (p *progressWriter) notify(message string, meta map[string]any) {
+	if !p.streaming {
+		for k, v := range p.header {
+			p.w.Header()[k] = v
+		}
+		p.w.Header().Set("Content-Type", "text/event-stream")
+		p.w.Header().Set("Cache-Control", "no-cache")
+		p.w.Header().Del("Content-Length")
+		p.w.WriteHeader(http.StatusOK)
+		p.streaming = true
+	}
+
+	p.progress++
+	notification := mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: "notifications/progress",
+			Params: mcp.NotificationParams{
+				Meta: meta,
+				AdditionalFields: map[string]any{
+					"progressToken": p.token,
+					"progress":      p.progress,
+					"total":         p.total,
+					"message":       message,
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(p.w, "event: message\ndata: %s\n\n", data)
+	p.flusher.Flush()
+}
+
+// finish writes the buffered response, as an SSE event once streaming has started
+func (p *progressWriter) finish() {
+	if !p.streaming {
+		for k, v := range p.header {
+			p.w.Header()[k] = v
+		}
+		p.w.WriteHeader(p.statusCode)
+		_, _ = p.w.Write(p.body.Bytes())
+		return
+	}
+
+	// The wrapped handler may already have produced an event stream
+	if strings.HasPrefix(p.header.Get("Content-Type"), "text/event-stream") {
+		_, _ = p.w.Write(p.body.Bytes())
+	} else if body := bytes.TrimSpace(p.body.Bytes()); len(body) > 0 {
+		fmt.Fprintf(p.w, "event: message\ndata: %s\n\n", body)
+	}
+	p.flusher.Flush()
+}
+
+// reportProgress sends a payment progress notification if w streams progress
+func reportProgress(w http.ResponseWriter, message string, meta map[string]any) {
+	if p, ok := w.(*progressWriter); ok {
+		p.notify(message, meta)
+	}
+}
+
+// settlementSeconds returns ExpectedSettlementTime in whole seconds, rounded up
+func (c *Config) settlementSeconds() int {
+	if c.ExpectedSettlementTime <= 0 {
+		return 0
+	}
+	return int(math.Ceil(c.ExpectedSettlementTime.Seconds()))
+}
+
+// settlementPendingMeta returns the _meta of the notification announcing a
+// settlement, asking clients to wait ExpectedSettlementTime longer
+func (c *Config) settlementPendingMeta() map[string]any {
+	seconds := c.settlementSeconds()
+	if seconds == 0 {
+		return nil
+	}
+	return map[string]any{x402.MetaKeySettlementPending: x402.SettlementPending{ExtendSeconds: seconds}}
+}
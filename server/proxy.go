@@ -0,0 +1,48 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// NewX402Proxy returns an X402Handler enforcing payments in front of the streamable
+// HTTP MCP server at target (e.g. "http://localhost:3000/mcp"), which may be written
+// in any language. Paid tools are configured with config.PaymentTools and
+// DefaultPaymentRequirements as for X402Server; requests are forwarded to target
+// once paid, and settlements are added to the upstream's JSON and SSE responses.
+//
+// The upstream server cannot check payments itself, so it must only be reachable
+// through the proxy.
+func NewX402Proxy(target string, config *Config) (*X402Handler, error) {
+	upstream, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url: %w", err)
+	}
+	if upstream.Scheme != "http" && upstream.Scheme != "https" {
+		return nil, fmt.Errorf("upstream url must be http or https: %s", target)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		// The MCP endpoint is a single URL, whatever path the proxy is mounted at
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.Out.URL.Scheme = upstream.Scheme
+			r.Out.URL.Host = upstream.Host
+			r.Out.URL.Path = upstream.Path
+			r.Out.URL.RawPath = upstream.RawPath
+			if upstream.RawQuery != "" {
+				r.Out.URL.RawQuery = upstream.RawQuery
+			}
+			r.Out.Host = upstream.Host
+			r.SetXForwarded()
+		},
+		// Stream server-sent events (GET streams) as they arrive
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			config.logger().Printf("[X402] Upstream request failed: %v", err)
+			http.Error(w, "Upstream MCP server unavailable", http.StatusBadGateway)
+		},
+	}
+	return NewX402Handler(proxy, config), nil
+}
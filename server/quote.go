@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// handleQuote answers an x402/quote request with a signed quote of the tool's
+// current requirements
+func (h *X402Handler) handleQuote(w http.ResponseWriter, r *http.Request, jsonrpcReq transport.JSONRPCRequest) {
+	var params struct {
+		Name string `json:"name"`
+	}
+	paramsBytes, _ := json.Marshal(jsonrpcReq.Params)
+	if err := json.Unmarshal(paramsBytes, &params); err != nil || params.Name == "" {
+		h.sendInvalidParamsError(w, jsonrpcReq.ID, "Quote requires a tool name")
+		return
+	}
+
+	requirements, paid, err := h.config.requirementsFor(r.Context(), params.Name)
+	if err != nil {
+		h.sendIdentityRejectedError(w, jsonrpcReq.ID, err)
+		return
+	}
+	if !paid {
+		h.sendInvalidParamsError(w, jsonrpcReq.ID, fmt.Sprintf("Tool %s is free", params.Name))
+		return
+	}
+
+	token, err := issueQuote(h.quoteKey, params.Name, requirements, h.config.now().Add(h.config.QuoteTTL))
+	if err != nil {
+		h.sendInternalError(w, jsonrpcReq.ID, "Failed to issue quote")
+		return
+	}
+	h.debugf(r.Context(), "[X402] Quoted %d payment options for tool '%s'", len(requirements), params.Name)
+
+	result, _ := json.Marshal(map[string]string{"quote": token})
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(transport.JSONRPCResponse{
+		JSONRPC: "2.0",
+		ID:      normalizeRequestID(jsonrpcReq.ID),
+		Result:  result,
+	})
+}
+
+// issueQuote returns a signed quote of requirements for a tool valid until expires
+func issueQuote(key []byte, tool string, requirements []PaymentRequirement, expires time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	// Client and server requirement types share their JSON encoding
+	accepts := make([]x402.PaymentRequirement, len(requirements))
+	raw, err := json.Marshal(requirements)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(raw, &accepts); err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(x402.Quote{
+		ID:      hex.EncodeToString(id),
+		Tool:    tool,
+		Accepts: accepts,
+		Expires: expires.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(claims)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(payloadMAC(key, payload)), nil
+}
+
+// verifyQuote checks a quote's MAC, expiry and tool and returns its requirements
+func verifyQuote(key []byte, token, tool string, now time.Time) ([]PaymentRequirement, error) {
+	payload, mac, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed quote")
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil || !hmac.Equal(sum, payloadMAC(key, payload)) {
+		return nil, fmt.Errorf("invalid quote signature")
+	}
+	quote, err := x402.ParseQuote(token)
+	if err != nil {
+		return nil, err
+	}
+	if quote.Tool != tool {
+		return nil, fmt.Errorf("quote is for tool %s", quote.Tool)
+	}
+	if now.After(quote.ExpiresAt()) {
+		return nil, fmt.Errorf("quote expired")
+	}
+
+	raw, err := json.Marshal(quote.Accepts)
+	if err != nil {
+		return nil, err
+	}
+	var requirements []PaymentRequirement
+	if err := json.Unmarshal(raw, &requirements); err != nil {
+		return nil, err
+	}
+	return requirements, nil
+}
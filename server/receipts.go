@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Receipt resource URIs
+const (
+	// ReceiptsURI lists the receipts of the reading session
+	ReceiptsURI = "x402://receipts"
+	// ReceiptURITemplate reads a single receipt by settlement transaction
+	ReceiptURITemplate = "x402://receipts/{tx}"
+)
+
+// DefaultMaxReceipts is the number of receipts a ReceiptStore keeps by default
+const DefaultMaxReceipts = 1000
+
+// Receipt describes a settled payment
+type Receipt struct {
+	Transaction string    `json:"transaction"`
+	Network     string    `json:"network"`
+	Payer       string    `json:"payer,omitempty"`
+	OnBehalfOf  string    `json:"onBehalfOf,omitempty"` // End user the payer paid for
+	RequestID   string    `json:"requestId,omitempty"`  // Client's request ID
+	Amount      string    `json:"amount"`
+	Asset       string    `json:"asset"`
+	Tool        string    `json:"tool,omitempty"`
+	Resource    string    `json:"resource"`
+	Reference   string    `json:"reference,omitempty"`
+	Experiment  string    `json:"experiment,omitempty"` // Pricing experiment arm, if any
+	Timestamp   time.Time `json:"timestamp"`
+
+	sessionID string
+}
+
+// ReceiptStore keeps the receipts of the most recent settled payments in memory
+type ReceiptStore struct {
+	mu    sync.RWMutex
+	max   int
+	order []string
+	byTx  map[string]Receipt
+}
+
+// NewReceiptStore creates a store keeping up to max receipts, dropping the
+// oldest first. A max of zero or less uses DefaultMaxReceipts.
+func NewReceiptStore(max int) *ReceiptStore {
+	if max <= 0 {
+		max = DefaultMaxReceipts
+	}
+	return &ReceiptStore{max: max, byTx: make(map[string]Receipt)}
+}
+
+// Get returns the receipt of a settlement transaction
+func (s *ReceiptStore) Get(tx string) (Receipt, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	receipt, ok := s.byTx[tx]
+	return receipt, ok
+}
+
+// All returns every receipt kept, oldest first
+func (s *ReceiptStore) All() []Receipt {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	receipts := make([]Receipt, 0, len(s.order))
+	for _, tx := range s.order {
+		receipts = append(receipts, s.byTx[tx])
+	}
+	return receipts
+}
+
+// Session returns the receipts of payments made in an MCP session, oldest first
+func (s *ReceiptStore) Session(sessionID string) []Receipt {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	receipts := []Receipt{}
+	if sessionID == "" {
+		return receipts
+	}
+	for _, tx := range s.order {
+		if receipt := s.byTx[tx]; receipt.sessionID == sessionID {
+			receipts = append(receipts, receipt)
+		}
+	}
+	return receipts
+}
+
+// add stores a receipt, evicting the oldest when full
+func (s *ReceiptStore) add(receipt Receipt) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.byTx[receipt.Transaction]; !exists {
+		s.order = append(s.order, receipt.Transaction)
+	}
+	s.byTx[receipt.Transaction] = receipt
+	for len(s.order) > s.max {
+		delete(s.byTx, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// newReceipt builds the receipt of a settled payment
+func newReceipt(info *PaymentInfo, sessionID string) Receipt {
+	requirement := info.Requirement
+	tool, isTool := strings.CutPrefix(requirement.Resource, "mcp://tools/")
+	if !isTool {
+		tool = ""
+	}
+	return Receipt{
+		Transaction: info.Settlement.Transaction,
+		Network:     requirement.Network,
+		Payer:       info.Settlement.Payer,
+		OnBehalfOf:  info.OnBehalfOf,
+		RequestID:   info.RequestID,
+		Amount:      requirement.MaxAmountRequired,
+		Asset:       requirement.Asset,
+		Tool:        tool,
+		Resource:    requirement.Resource,
+		Reference:   info.Reference,
+		Experiment:  requirement.Experiment,
+		Timestamp:   time.Now().UTC(),
+		sessionID:   sessionID,
+	}
+}
+
+// recordReceipt stores the receipt of a settled payment in the configured store
+func (h *X402Handler) recordReceipt(info *PaymentInfo, sessionID string) {
+	if h.config.Receipts == nil || h.config.VerifyOnly || info.Settlement == nil {
+		return
+	}
+	if info.PendingSettlement != "" {
+		h.recordPendingReceipt(info, sessionID)
+		return
+	}
+	h.config.Receipts.add(newReceipt(info, sessionID))
+}
+
+// AddReceiptResources exposes the receipts in store as MCP resources on mcpServer:
+// ReceiptsURI lists the reading session's receipts and ReceiptURITemplate reads
+// any receipt by its settlement transaction. NewX402Server registers them when
+// Config.Receipts is set.
+func AddReceiptResources(mcpServer *server.MCPServer, store *ReceiptStore) {
+	mcpServer.AddResource(
+		mcp.NewResource(ReceiptsURI, "Payment receipts",
+			mcp.WithResourceDescription("Receipts of the x402 payments settled in this session"),
+			mcp.WithMIMEType("application/json"),
+		),
+		func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			var sessionID string
+			if session := server.ClientSessionFromContext(ctx); session != nil {
+				sessionID = session.SessionID()
+			}
+			return receiptContents(request.Params.URI, store.Session(sessionID))
+		},
+	)
+
+	mcpServer.AddResourceTemplate(
+		mcp.NewResourceTemplate(ReceiptURITemplate, "Payment receipt",
+			mcp.WithTemplateDescription("Receipt of the x402 payment settled in transaction tx"),
+			mcp.WithTemplateMIMEType("application/json"),
+		),
+		func(ctx context.Context, request mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+			tx := strings.TrimPrefix(request.Params.URI, ReceiptsURI+"/")
+			receipt, ok := store.Get(tx)
+			if !ok {
+				return nil, fmt.Errorf("no receipt for transaction %s", tx)
+			}
+			return receiptContents(request.Params.URI, receipt)
+		},
+	)
+}
+
+// receiptContents encodes receipts as a JSON resource
+func receiptContents(uri string, v any) ([]mcp.ResourceContents, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return []mcp.ResourceContents{
+		mcp.TextResourceContents{URI: uri, MIMEType: "application/json", Text: string(data)},
+	}, nil
+}
@@ -0,0 +1,70 @@
+// Package redisstore implements server.Store on Redis, so x402 servers running as
+// several replicas share paid access sessions and prepaid batches.
+//
+//	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+//	config := &x402server.Config{Store: redisstore.New(client, "x402")}
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	x402server "github.com/mark3labs/mcp-go-x402/server"
+	"github.com/redis/go-redis/v9"
+)
+
+// incrBy adds to a counter, setting its expiry only when it has none yet
+var incrBy = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if tonumber(ARGV[2]) > 0 and redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// Store is a server.Store on Redis
+type Store struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+var _ x402server.Store = (*Store)(nil)
+
+// New creates a store on client. Keys are prefixed with "prefix:" when prefix is
+// set, so several servers can share a Redis database.
+func New(client redis.UniversalClient, prefix string) *Store {
+	if prefix != "" {
+		prefix += ":"
+	}
+	return &Store{client: client, prefix: prefix}
+}
+
+// Get implements server.Store
+func (s *Store) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.client.Get(ctx, s.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	return value, err
+}
+
+// Set implements server.Store
+func (s *Store) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.client.Set(ctx, s.prefix+key, value, ttl).Err()
+}
+
+// SetNX implements server.Store
+func (s *Store) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.client.SetNX(ctx, s.prefix+key, value, ttl).Result()
+}
+
+// Delete implements server.Store
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+// IncrBy implements server.Store
+func (s *Store) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return incrBy.Run(ctx, s.client, []string{s.prefix + key}, delta, ttl.Milliseconds()).Int64()
+}
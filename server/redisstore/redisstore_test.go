@@ -0,0 +1,58 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	store := New(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "x402")
+
+	if value, err := store.Get(ctx, "missing"); value != nil || err != nil {
+		t.Errorf("Expected nil for a missing key, got %q, %v", value, err)
+	}
+
+	if err := store.Set(ctx, "access:session", []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !mr.Exists("x402:access:session") {
+		t.Error("Expected keys to carry the prefix")
+	}
+	if ok, _ := store.SetNX(ctx, "access:session", []byte("2"), 0); ok {
+		t.Error("SetNX should not overwrite an existing key")
+	}
+	if value, _ := store.Get(ctx, "access:session"); string(value) != "1" {
+		t.Errorf("Expected 1, got %q", value)
+	}
+
+	// Counters expire from their creation, not their last update
+	if n, err := store.IncrBy(ctx, "batch:calls", 2, time.Minute); err != nil || n != 2 {
+		t.Fatalf("Expected 2, got %d, %v", n, err)
+	}
+	mr.FastForward(30 * time.Second)
+	if n, _ := store.IncrBy(ctx, "batch:calls", -1, time.Minute); n != 1 {
+		t.Errorf("Expected 1, got %d", n)
+	}
+	if ttl := mr.TTL("x402:batch:calls"); ttl != 30*time.Second {
+		t.Errorf("Expected the remaining 30s TTL, got %s", ttl)
+	}
+
+	mr.FastForward(time.Minute)
+	if value, _ := store.Get(ctx, "access:session"); value != nil {
+		t.Errorf("Expected the session to expire, got %q", value)
+	}
+
+	_ = store.Set(ctx, "access:other", []byte("1"), 0)
+	if err := store.Delete(ctx, "access:other"); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists("x402:access:other") {
+		t.Error("Expected the key to be deleted")
+	}
+}
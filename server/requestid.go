@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"net/http"
+)
+
+// DefaultRequestIDHeader is the default of Config.RequestIDHeader
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the request IDs recorded with payments
+const maxRequestIDLength = 256
+
+// requestID returns the client's request ID, dropping overlong ones
+func (c *Config) requestID(r *http.Request) string {
+	header := c.RequestIDHeader
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+	id := r.Header.Get(header)
+	if len(id) > maxRequestIDLength {
+		return ""
+	}
+	return id
+}
+
+type requestIDKey struct{}
+
+// withRequestID attaches the client's request ID to a context
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the request ID attached with withRequestID
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
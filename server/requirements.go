@@ -1,6 +1,9 @@
 package server
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"sync"
 
 	"github.com/mark3labs/mcp-go-x402"
@@ -9,8 +12,8 @@ import (
 // Helper functions for common payment requirements with USDC on Base network
 
 var (
-	// supportedPaymentsCache stores supported payment info by network
-	supportedPaymentsCache      = make(map[string]SupportedKind)
+	// supportedPaymentsCache stores supported payment kinds by network, one per scheme
+	supportedPaymentsCache      = make(map[string][]SupportedKind)
 	supportedPaymentsCacheMutex sync.RWMutex
 )
 
@@ -21,10 +24,26 @@ func SetSupportedPayments(supported []SupportedKind) {
 	defer supportedPaymentsCacheMutex.Unlock()
 
 	for _, kind := range supported {
-		supportedPaymentsCache[kind.Network] = kind
+		kinds := supportedPaymentsCache[kind.Network]
+		if i := slices.IndexFunc(kinds, func(k SupportedKind) bool { return k.Scheme == kind.Scheme }); i >= 0 {
+			kinds[i] = kind
+			continue
+		}
+		supportedPaymentsCache[kind.Network] = append(kinds, kind)
 	}
 }
 
+// cachedKind returns the cached kind of scheme on network. The caller holds
+// supportedPaymentsCacheMutex.
+func cachedKind(scheme, network string) (SupportedKind, bool) {
+	for _, kind := range supportedPaymentsCache[network] {
+		if kind.Scheme == scheme {
+			return kind, true
+		}
+	}
+	return SupportedKind{}, false
+}
+
 // cloneStringMap creates a deep copy of a string map
 func cloneStringMap(in map[string]string) map[string]string {
 	if in == nil {
@@ -37,17 +56,30 @@ func cloneStringMap(in map[string]string) map[string]string {
 	return out
 }
 
-// getExtraForNetwork retrieves cached extra data for a network
+// getExtraForNetwork retrieves cached extra data for a network, from its first cached kind
 func getExtraForNetwork(network string) map[string]string {
 	supportedPaymentsCacheMutex.RLock()
 	defer supportedPaymentsCacheMutex.RUnlock()
 
-	if kind, ok := supportedPaymentsCache[network]; ok {
-		return cloneStringMap(kind.Extra)
+	if kinds := supportedPaymentsCache[network]; len(kinds) > 0 {
+		return cloneStringMap(kinds[0].Extra)
 	}
 	return nil
 }
 
+// supportsKind reports whether the cached facilitator /supported list includes
+// scheme on network. known is false when nothing has been cached yet.
+func supportsKind(scheme, network string) (supported, known bool) {
+	supportedPaymentsCacheMutex.RLock()
+	defer supportedPaymentsCacheMutex.RUnlock()
+
+	if len(supportedPaymentsCache) == 0 {
+		return false, false
+	}
+	_, ok := cachedKind(scheme, network)
+	return ok, true
+}
+
 // RequireUSDCBase creates a payment requirement for USDC on Base mainnet
 func RequireUSDCBase(payTo, amount, description string) PaymentRequirement {
 	return PaymentRequirement{
@@ -84,6 +116,25 @@ func RequireUSDCBaseSepolia(payTo, amount, description string) PaymentRequiremen
 	}
 }
 
+// RequireUSDCSandbox creates a payment requirement for fake USDC on the developer
+// sandbox network. Serve it with a SandboxFacilitator.
+func RequireUSDCSandbox(payTo, amount, description string) PaymentRequirement {
+	return PaymentRequirement{
+		Scheme:            "exact",
+		Network:           x402.SandboxNetwork,
+		Asset:             x402.USDCAddressSandbox,
+		PayTo:             payTo,
+		MaxAmountRequired: amount,
+		Description:       description,
+		MimeType:          "application/json",
+		MaxTimeoutSeconds: 60,
+		Extra: map[string]string{
+			"name":    "USDC",
+			"version": "2",
+		},
+	}
+}
+
 // RequireUSDCPolygon creates a payment requirement for USDC on Polygon mainnet
 func RequireUSDCPolygon(payTo, amount, description string) PaymentRequirement {
 	return PaymentRequirement{
@@ -211,3 +262,71 @@ func RequireUSDCSolanaDevnet(payTo, amount, description string) PaymentRequireme
 		Extra:             extra,
 	}
 }
+
+// RequireSOL creates a payment requirement for native SOL on Solana mainnet.
+// The amount is in lamports (1 SOL = 1000000000 lamports).
+func RequireSOL(payTo, amount, description string) PaymentRequirement {
+	return requireSOL("solana", "SOL", payTo, amount, description)
+}
+
+// RequireSOLDevnet creates a payment requirement for native SOL on Solana devnet.
+// The amount is in lamports (1 SOL = 1000000000 lamports).
+func RequireSOLDevnet(payTo, amount, description string) PaymentRequirement {
+	return requireSOL("solana-devnet", "SOL (Devnet)", payTo, amount, description)
+}
+
+func requireSOL(network, name, payTo, amount, description string) PaymentRequirement {
+	extra := map[string]string{
+		"decimals": "9",
+		"name":     name,
+	}
+
+	// Merge in any extra fields from facilitator's supported payments (including feePayer)
+	for k, v := range getExtraForNetwork(network) {
+		extra[k] = v
+	}
+
+	return PaymentRequirement{
+		Scheme:            "exact",
+		Network:           network,
+		MaxAmountRequired: amount,
+		Asset:             x402.SOLNative,
+		PayTo:             payTo,
+		Description:       description,
+		MimeType:          "application/json",
+		MaxTimeoutSeconds: 60,
+		Extra:             extra,
+	}
+}
+
+// requirementBuilders maps assets and networks to their requirement helpers
+var requirementBuilders = map[string]map[string]func(payTo, amount, description string) PaymentRequirement{
+	"usdc": {
+		"base":           RequireUSDCBase,
+		"base-sepolia":   RequireUSDCBaseSepolia,
+		"polygon":        RequireUSDCPolygon,
+		"polygon-amoy":   RequireUSDCPolygonAmoy,
+		"avalanche":      RequireUSDCAvalanche,
+		"avalanche-fuji": RequireUSDCAvalancheFuji,
+		"solana":         RequireUSDCSolana,
+		"solana-devnet":  RequireUSDCSolanaDevnet,
+	},
+	"sol": {
+		"solana":        RequireSOL,
+		"solana-devnet": RequireSOLDevnet,
+	},
+}
+
+// RequireAsset creates a payment requirement for an asset ("usdc", the default,
+// or "sol") on a network by name, for requirements read from configuration
+func RequireAsset(network, asset, payTo, amount, description string) (PaymentRequirement, error) {
+	asset = strings.ToLower(asset)
+	if asset == "" {
+		asset = "usdc"
+	}
+	build, ok := requirementBuilders[asset][network]
+	if !ok {
+		return PaymentRequirement{}, fmt.Errorf("unsupported asset %q on network %q", asset, network)
+	}
+	return build(payTo, amount, description), nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+// SandboxFacilitator is an in-memory facilitator for the developer sandbox
+// network. It accepts every sandbox payment and settles it on a fake chain with
+// a synthetic transaction hash, so servers run without a facilitator, keys or
+// internet access. Set it as Config.Facilitator; payments on other networks
+// are rejected.
+type SandboxFacilitator struct {
+	mu      sync.Mutex
+	settled []SettleResponse
+}
+
+var _ Facilitator = (*SandboxFacilitator)(nil)
+
+// NewSandboxFacilitator creates a sandbox facilitator
+func NewSandboxFacilitator() *SandboxFacilitator {
+	return &SandboxFacilitator{}
+}
+
+// Verify implements Facilitator
+func (f *SandboxFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	if requirement.Network != x402.SandboxNetwork || payment.Network != x402.SandboxNetwork {
+		return &VerifyResponse{IsValid: false, InvalidReason: "sandbox facilitator only accepts sandbox payments"}, nil
+	}
+	return &VerifyResponse{IsValid: true, Payer: sandboxPayer(payment)}, nil
+}
+
+// Settle implements Facilitator
+func (f *SandboxFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	if requirement.Network != x402.SandboxNetwork || payment.Network != x402.SandboxNetwork {
+		return &SettleResponse{Success: false, Network: requirement.Network, ErrorReason: "sandbox facilitator only settles sandbox payments"}, nil
+	}
+	hash := make([]byte, 32)
+	if _, err := rand.Read(hash); err != nil {
+		return nil, fmt.Errorf("failed to generate sandbox transaction: %w", err)
+	}
+	resp := SettleResponse{
+		Success:     true,
+		Payer:       sandboxPayer(payment),
+		Transaction: "0x" + hex.EncodeToString(hash),
+		Network:     x402.SandboxNetwork,
+	}
+
+	f.mu.Lock()
+	f.settled = append(f.settled, resp)
+	f.mu.Unlock()
+	return &resp, nil
+}
+
+// GetSupported implements Facilitator
+func (f *SandboxFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	return []SupportedKind{{X402Version: 1, Scheme: "exact", Network: x402.SandboxNetwork}}, nil
+}
+
+// Settled returns the settlements made so far
+func (f *SandboxFacilitator) Settled() []SettleResponse {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]SettleResponse(nil), f.settled...)
+}
+
+// sandboxPayer returns the authorizing address of an EVM payment payload
+func sandboxPayer(payment *PaymentPayload) string {
+	payload, _ := payment.Payload.(map[string]any)
+	authorization, _ := payload["authorization"].(map[string]any)
+	from, _ := authorization["from"].(string)
+	return from
+}
@@ -0,0 +1,602 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+// Defaults of SelfSettlerConfig
+const (
+	defaultSelfSettleReceiptTimeout = 2 * time.Minute
+	defaultSelfSettlePollInterval   = 2 * time.Second
+)
+
+// eip3009ABI holds the EIP-3009 token functions a SelfSettler calls
+const eip3009ABI = `[
+	{"name":"transferWithAuthorization","type":"function","stateMutability":"nonpayable","inputs":[
+		{"name":"from","type":"address"},{"name":"to","type":"address"},{"name":"value","type":"uint256"},
+		{"name":"validAfter","type":"uint256"},{"name":"validBefore","type":"uint256"},{"name":"nonce","type":"bytes32"},
+		{"name":"v","type":"uint8"},{"name":"r","type":"bytes32"},{"name":"s","type":"bytes32"}],"outputs":[]},
+	{"name":"authorizationState","type":"function","stateMutability":"view","inputs":[
+		{"name":"authorizer","type":"address"},{"name":"nonce","type":"bytes32"}],"outputs":[{"name":"","type":"bool"}]},
+	{"name":"balanceOf","type":"function","stateMutability":"view","inputs":[
+		{"name":"account","type":"address"}],"outputs":[{"name":"","type":"uint256"}]}
+]`
+
+var eip3009 = func() abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(eip3009ABI))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// SelfSettlerConfig configures settling EVM payments on one network without a facilitator
+type SelfSettlerConfig struct {
+	// Network is the x402 network name, e.g. "base-sepolia"
+	Network string
+
+	// RPCURL is the JSON-RPC endpoint of a node of the network
+	RPCURL string
+
+	// ChainID of the network, which payment signatures commit to
+	ChainID *big.Int
+
+	// GasPrivateKey is the hex private key of the wallet paying gas for settlements
+	GasPrivateKey string
+
+	// ReceiptTimeout is how long a settlement may take to be mined (default 2m),
+	// polled every PollInterval (default 2s)
+	ReceiptTimeout time.Duration
+	PollInterval   time.Duration
+}
+
+// SelfSettler verifies and settles EIP-3009 payments itself, for networks no
+// hosted facilitator covers: it checks signatures, balances and nonces against
+// a node, then broadcasts transferWithAuthorization from its own gas wallet and
+// reports the transaction hash. Add it to Config.SelfSettlers.
+type SelfSettler struct {
+	config     SelfSettlerConfig
+	gasKey     *ecdsa.PrivateKey
+	gasAddress common.Address
+	client     *http.Client
+	logger     x402.Logger
+	verbose    bool
+	clock      x402.Clock // Config.Clock of the server settling with it
+
+	// mu serializes settlements, keeping the gas wallet's nonces in order
+	mu sync.Mutex
+}
+
+var _ Facilitator = (*SelfSettler)(nil)
+
+// NewSelfSettler creates a settler for one network
+func NewSelfSettler(config SelfSettlerConfig) (*SelfSettler, error) {
+	if config.Network == "" || config.RPCURL == "" {
+		return nil, fmt.Errorf("self settler needs a network and an RPC URL")
+	}
+	if config.ChainID == nil {
+		return nil, fmt.Errorf("self settler for %s needs a chain ID", config.Network)
+	}
+	gasKey, err := crypto.HexToECDSA(strings.TrimPrefix(config.GasPrivateKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid gas wallet key: %w", err)
+	}
+	if config.ReceiptTimeout <= 0 {
+		config.ReceiptTimeout = defaultSelfSettleReceiptTimeout
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultSelfSettlePollInterval
+	}
+	return &SelfSettler{
+		config:     config,
+		gasKey:     gasKey,
+		gasAddress: crypto.PubkeyToAddress(gasKey.PublicKey),
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// SetVerbose enables logging the settlements broadcast
+func (s *SelfSettler) SetVerbose(verbose bool) {
+	s.verbose = verbose
+}
+
+// SetLogger sets where verbose logs go (the standard logger when nil)
+func (s *SelfSettler) SetLogger(logger x402.Logger) {
+	s.logger = logger
+}
+
+// now returns the time authorization windows are checked against
+func (s *SelfSettler) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// Network returns the network the settler settles on
+func (s *SelfSettler) Network() string {
+	return s.config.Network
+}
+
+// GasAddress returns the address of the gas wallet, which must hold native
+// tokens for settlements
+func (s *SelfSettler) GasAddress() string {
+	return s.gasAddress.Hex()
+}
+
+// eip3009Authorization is a decoded EVM payment payload
+type eip3009Authorization struct {
+	from, to                       common.Address
+	value, validAfter, validBefore *big.Int
+	nonce                          [32]byte
+	signature                      []byte
+}
+
+// Verify implements Facilitator
+func (s *SelfSettler) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	auth, reason := s.check(payment, requirement)
+	if reason != "" {
+		return &VerifyResponse{IsValid: false, InvalidReason: reason}, nil
+	}
+	if reason, err := s.checkOnChain(ctx, auth, requirement); err != nil || reason != "" {
+		return &VerifyResponse{IsValid: false, Payer: auth.from.Hex(), InvalidReason: reason}, err
+	}
+	return &VerifyResponse{IsValid: true, Payer: auth.from.Hex()}, nil
+}
+
+// Settle implements Facilitator. It returns once the transfer is mined or
+// ReceiptTimeout passes, even if ctx is cancelled after the broadcast. A
+// transfer still unmined by then may yet take the payer's funds, so it is
+// reported as successful with status pending and its transaction.
+func (s *SelfSettler) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	failed := func(payer, reason string) *SettleResponse {
+		return &SettleResponse{Success: false, Payer: payer, Network: s.config.Network, ErrorReason: reason}
+	}
+	auth, reason := s.check(payment, requirement)
+	if reason != "" {
+		return failed("", reason), nil
+	}
+	payer := auth.from.Hex()
+	if reason, err := s.checkOnChain(ctx, auth, requirement); err != nil || reason != "" {
+		return failed(payer, reason), err
+	}
+
+	r, sig, v := auth.signature[:32], auth.signature[32:64], auth.signature[64]
+	if v < 27 {
+		v += 27
+	}
+	data, err := eip3009.Pack("transferWithAuthorization", auth.from, auth.to, auth.value,
+		auth.validAfter, auth.validBefore, auth.nonce, v, [32]byte(r), [32]byte(sig))
+	if err != nil {
+		return nil, fmt.Errorf("encode transferWithAuthorization: %w", err)
+	}
+
+	hash, err := s.broadcast(ctx, common.HexToAddress(requirement.Asset), data)
+	if err != nil {
+		return nil, err
+	}
+	resp := &SettleResponse{Payer: payer, Transaction: hash, Network: s.config.Network}
+
+	status, err := s.waitForReceipt(context.WithoutCancel(ctx), hash)
+	switch {
+	case err != nil:
+		resp.Success, resp.Status = true, x402.SettlementStatusPending
+		s.verbosef("[X402] Settlement %s on %s still pending: %v", hash, s.config.Network, err)
+	case status != "0x1":
+		resp.ErrorReason = "settlement transaction reverted"
+	default:
+		resp.Success = true
+	}
+	return resp, nil
+}
+
+// GetSupported implements Facilitator
+func (s *SelfSettler) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	return []SupportedKind{{X402Version: 1, Scheme: "exact", Network: s.config.Network}}, nil
+}
+
+// check decodes a payment and checks it against the requirement offline,
+// returning why it is invalid
+func (s *SelfSettler) check(payment *PaymentPayload, requirement *PaymentRequirement) (*eip3009Authorization, string) {
+	if payment.Network != s.config.Network || requirement.Network != s.config.Network {
+		return nil, fmt.Sprintf("self settler only settles payments on %s", s.config.Network)
+	}
+	if payment.Scheme != "exact" {
+		return nil, fmt.Sprintf("unsupported scheme %q", payment.Scheme)
+	}
+	auth, err := decodeEIP3009(payment)
+	if err != nil {
+		return nil, err.Error()
+	}
+
+	required, ok := new(big.Int).SetString(requirement.MaxAmountRequired, 10)
+	if !ok {
+		return nil, "invalid required amount"
+	}
+	switch {
+	case !strings.EqualFold(auth.to.Hex(), requirement.PayTo):
+		return nil, "authorization pays another recipient"
+	case auth.value.Cmp(required) < 0:
+		return nil, "authorization value below the required amount"
+	}
+	now := big.NewInt(s.now().Unix())
+	switch {
+	case auth.validAfter.Cmp(now) > 0:
+		return nil, "authorization not yet valid"
+	case auth.validBefore.Cmp(now) <= 0:
+		return nil, "authorization expired"
+	}
+
+	typedData := apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": []apitypes.Type{
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+				{Name: "verifyingContract", Type: "address"},
+			},
+			"TransferWithAuthorization": []apitypes.Type{
+				{Name: "from", Type: "address"},
+				{Name: "to", Type: "address"},
+				{Name: "value", Type: "uint256"},
+				{Name: "validAfter", Type: "uint256"},
+				{Name: "validBefore", Type: "uint256"},
+				{Name: "nonce", Type: "bytes32"},
+			},
+		},
+		PrimaryType: "TransferWithAuthorization",
+		Domain: apitypes.TypedDataDomain{
+			Name:              requirement.Extra["name"],
+			Version:           requirement.Extra["version"],
+			ChainId:           (*math.HexOrDecimal256)(s.config.ChainID),
+			VerifyingContract: requirement.Asset,
+		},
+		Message: apitypes.TypedDataMessage{
+			"from":        auth.from.Hex(),
+			"to":          auth.to.Hex(),
+			"value":       (*math.HexOrDecimal256)(auth.value),
+			"validAfter":  (*math.HexOrDecimal256)(auth.validAfter),
+			"validBefore": (*math.HexOrDecimal256)(auth.validBefore),
+			"nonce":       hexutil.Encode(auth.nonce[:]),
+		},
+	}
+	digest, _, err := apitypes.TypedDataAndHash(typedData)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid authorization: %v", err)
+	}
+	signature := append([]byte(nil), auth.signature...)
+	if signature[64] >= 27 {
+		signature[64] -= 27
+	}
+	pub, err := crypto.SigToPub(digest, signature)
+	if err != nil || crypto.PubkeyToAddress(*pub) != auth.from {
+		return nil, "invalid signature"
+	}
+	return auth, ""
+}
+
+// checkOnChain checks the authorization's nonce is unused and the payer holds
+// the amount, returning why the payment is invalid
+func (s *SelfSettler) checkOnChain(ctx context.Context, auth *eip3009Authorization, requirement *PaymentRequirement) (string, error) {
+	token := common.HexToAddress(requirement.Asset)
+
+	var used bool
+	if err := s.callView(ctx, token, "authorizationState", &used, auth.from, auth.nonce); err != nil {
+		return "", err
+	}
+	if used {
+		return "authorization nonce already used", nil
+	}
+
+	var balance *big.Int
+	if err := s.callView(ctx, token, "balanceOf", &balance, auth.from); err != nil {
+		return "", err
+	}
+	if balance.Cmp(auth.value) < 0 {
+		return "insufficient funds", nil
+	}
+	return "", nil
+}
+
+// callView calls a view function of the token and unpacks its single result into out
+func (s *SelfSettler) callView(ctx context.Context, token common.Address, method string, out any, args ...any) error {
+	data, err := eip3009.Pack(method, args...)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", method, err)
+	}
+	var result hexutil.Bytes
+	call := map[string]any{"to": token.Hex(), "data": hexutil.Encode(data)}
+	if err := s.call(ctx, "eth_call", []any{call, "latest"}, &result); err != nil {
+		return err
+	}
+	values, err := eip3009.Unpack(method, result)
+	if err != nil || len(values) != 1 {
+		return fmt.Errorf("decode %s: unexpected result %s", method, hexutil.Encode(result))
+	}
+	switch out := out.(type) {
+	case *bool:
+		*out, _ = values[0].(bool)
+	case **big.Int:
+		*out, _ = values[0].(*big.Int)
+		if *out == nil {
+			*out = new(big.Int)
+		}
+	}
+	return nil
+}
+
+// broadcast signs a call of token with the gas wallet and sends it, returning its hash
+func (s *SelfSettler) broadcast(ctx context.Context, token common.Address, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nonce, gasPrice, gas hexutil.Big
+	if err := s.call(ctx, "eth_getTransactionCount", []any{s.gasAddress.Hex(), "pending"}, &nonce); err != nil {
+		return "", err
+	}
+	if err := s.call(ctx, "eth_gasPrice", []any{}, &gasPrice); err != nil {
+		return "", err
+	}
+	call := map[string]any{"from": s.gasAddress.Hex(), "to": token.Hex(), "data": hexutil.Encode(data)}
+	if err := s.call(ctx, "eth_estimateGas", []any{call}, &gas); err != nil {
+		return "", err
+	}
+
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    nonce.ToInt().Uint64(),
+		GasPrice: gasPrice.ToInt(),
+		Gas:      gas.ToInt().Uint64(),
+		To:       &token,
+		Data:     data,
+	})
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(s.config.ChainID), s.gasKey)
+	if err != nil {
+		return "", fmt.Errorf("sign settlement transaction: %w", err)
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return "", fmt.Errorf("encode settlement transaction: %w", err)
+	}
+	// The node may accept the transaction even if the request is cancelled
+	// while sending it, so its hash must not be lost
+	var hash string
+	if err := s.call(context.WithoutCancel(ctx), "eth_sendRawTransaction", []any{hexutil.Encode(raw)}, &hash); err != nil {
+		return "", err
+	}
+	s.verbosef("[X402] Broadcast settlement %s on %s", hash, s.config.Network)
+	return hash, nil
+}
+
+// verbosef logs through the settler's logger when verbose
+func (s *SelfSettler) verbosef(format string, args ...any) {
+	if !s.verbose {
+		return
+	}
+	logger := s.logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf(format, args...)
+}
+
+// waitForReceipt polls for the receipt of a transaction, returning its status
+func (s *SelfSettler) waitForReceipt(ctx context.Context, hash string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.config.ReceiptTimeout)
+	defer cancel()
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		var receipt *struct {
+			Status string `json:"status"`
+		}
+		if err := s.call(ctx, "eth_getTransactionReceipt", []any{hash}, &receipt); err == nil && receipt != nil {
+			return receipt.Status, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", fmt.Errorf("settlement transaction not mined after %s", s.config.ReceiptTimeout)
+		}
+	}
+}
+
+// call makes a JSON-RPC call to the settler's node
+func (s *SelfSettler) call(ctx context.Context, method string, params []any, result any) error {
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.RPCURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s", method, response.Error.Message)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
+// decodeEIP3009 decodes the authorization and signature of an EVM payment payload
+func decodeEIP3009(payment *PaymentPayload) (*eip3009Authorization, error) {
+	payloadMap, ok := payment.Payload.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unrecognized payment payload")
+	}
+	authData, ok := payloadMap["authorization"].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("missing authorization")
+	}
+	field := func(name string) string {
+		value, _ := authData[name].(string)
+		return value
+	}
+	number := func(name string) (*big.Int, error) {
+		value, ok := new(big.Int).SetString(field(name), 10)
+		if !ok {
+			return nil, fmt.Errorf("invalid authorization %s", name)
+		}
+		return value, nil
+	}
+
+	auth := &eip3009Authorization{}
+	for name, addr := range map[string]*common.Address{"from": &auth.from, "to": &auth.to} {
+		if !common.IsHexAddress(field(name)) {
+			return nil, fmt.Errorf("invalid authorization %s", name)
+		}
+		*addr = common.HexToAddress(field(name))
+	}
+	var err error
+	if auth.value, err = number("value"); err != nil {
+		return nil, err
+	}
+	if auth.validAfter, err = number("validAfter"); err != nil {
+		return nil, err
+	}
+	if auth.validBefore, err = number("validBefore"); err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(strings.TrimPrefix(field("nonce"), "0x"))
+	if err != nil || len(nonce) != 32 {
+		return nil, fmt.Errorf("invalid authorization nonce")
+	}
+	copy(auth.nonce[:], nonce)
+
+	signatureHex, _ := payloadMap["signature"].(string)
+	auth.signature, err = hex.DecodeString(strings.TrimPrefix(signatureHex, "0x"))
+	if err != nil || len(auth.signature) != crypto.SignatureLength {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return auth, nil
+}
+
+// selfSettlingFacilitator routes payments on self-settled networks to their
+// SelfSettler and the rest to the facilitator, if there is one
+type selfSettlingFacilitator struct {
+	facilitator Facilitator
+	settlers    map[string]*SelfSettler
+}
+
+func newSelfSettlingFacilitator(facilitator Facilitator, settlers []*SelfSettler) *selfSettlingFacilitator {
+	f := &selfSettlingFacilitator{facilitator: facilitator, settlers: make(map[string]*SelfSettler, len(settlers))}
+	for _, settler := range settlers {
+		f.settlers[settler.Network()] = settler
+	}
+	return f
+}
+
+func (f *selfSettlingFacilitator) route(network string) (Facilitator, error) {
+	if settler, ok := f.settlers[network]; ok {
+		return settler, nil
+	}
+	if f.facilitator == nil {
+		return nil, fmt.Errorf("no facilitator or self settler for network %s", network)
+	}
+	return f.facilitator, nil
+}
+
+// Verify implements Facilitator
+func (f *selfSettlingFacilitator) Verify(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*VerifyResponse, error) {
+	facilitator, err := f.route(requirement.Network)
+	if err != nil {
+		return nil, err
+	}
+	return facilitator.Verify(ctx, payment, requirement)
+}
+
+// Settle implements Facilitator
+func (f *selfSettlingFacilitator) Settle(ctx context.Context, payment *PaymentPayload, requirement *PaymentRequirement) (*SettleResponse, error) {
+	facilitator, err := f.route(requirement.Network)
+	if err != nil {
+		return nil, err
+	}
+	return facilitator.Settle(ctx, payment, requirement)
+}
+
+// GetSupported implements Facilitator, adding the self-settled networks to the
+// facilitator's
+func (f *selfSettlingFacilitator) GetSupported(ctx context.Context) ([]SupportedKind, error) {
+	var supported []SupportedKind
+	if f.facilitator != nil {
+		kinds, err := f.facilitator.GetSupported(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, kind := range kinds {
+			if _, ok := f.settlers[kind.Network]; !ok {
+				supported = append(supported, kind)
+			}
+		}
+	}
+	for _, settler := range f.settlers {
+		kinds, _ := settler.GetSupported(ctx)
+		supported = append(supported, kinds...)
+	}
+	return supported, nil
+}
+
+// Close closes the facilitator client
+func (f *selfSettlingFacilitator) Close() error {
+	if closer, ok := f.facilitator.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// withSelfSettlers wraps the facilitator so config.SelfSettlers settle their
+// networks, logging like the facilitator client and going by config.Clock. Without a facilitator URL only
+// self-settled networks are accepted.
+func withSelfSettlers(facilitator Facilitator, config *Config) Facilitator {
+	if len(config.SelfSettlers) == 0 {
+		return facilitator
+	}
+	for _, settler := range config.SelfSettlers {
+		settler.SetLogger(config.Logger)
+		settler.SetVerbose(config.facilitatorVerbose())
+		settler.clock = config.Clock
+	}
+	if config.Facilitator == nil && config.FacilitatorURL == "" {
+		facilitator = nil
+	}
+	return newSelfSettlingFacilitator(facilitator, config.SelfSettlers)
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/mark3labs/mcp-go-x402"
+)
+
+// fakeEVMNode answers the JSON-RPC calls of a SelfSettler
+type fakeEVMNode struct {
+	mu        sync.Mutex
+	balance   *big.Int
+	usedNonce bool
+	unmined   bool   // Receipts are not found
+	onSend    func() // Called when a transaction is broadcast
+	sent      []*types.Transaction
+}
+
+func (n *fakeEVMNode) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var result any
+	switch req.Method {
+	case "eth_call":
+		var call struct {
+			Data string `json:"data"`
+		}
+		_ = json.Unmarshal(req.Params[0], &call)
+		word := make([]byte, 32)
+		switch {
+		case strings.HasPrefix(call.Data, hexutil.Encode(eip3009.Methods["balanceOf"].ID)):
+			n.balance.FillBytes(word)
+		case n.usedNonce:
+			word[31] = 1
+		}
+		result = hexutil.Encode(word)
+	case "eth_getTransactionCount":
+		result = "0x7"
+	case "eth_gasPrice":
+		result = "0x3b9aca00"
+	case "eth_estimateGas":
+		result = "0x15f90"
+	case "eth_sendRawTransaction":
+		var raw string
+		_ = json.Unmarshal(req.Params[0], &raw)
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+			_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"message": err.Error()}})
+			return
+		}
+		n.sent = append(n.sent, tx)
+		result = tx.Hash().Hex()
+		if n.onSend != nil {
+			n.onSend()
+		}
+	case "eth_getTransactionReceipt":
+		if !n.unmined {
+			result = map[string]any{"status": "0x1"}
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": result})
+}
+
+func (n *fakeEVMNode) setUsedNonce(used bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.usedNonce = used
+}
+
+func (n *fakeEVMNode) setMining(unmined bool, onSend func()) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.unmined, n.onSend = unmined, onSend
+}
+
+func (n *fakeEVMNode) transactions() []*types.Transaction {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]*types.Transaction(nil), n.sent...)
+}
+
+func TestSelfSettler(t *testing.T) {
+	node := &fakeEVMNode{balance: big.NewInt(1_000_000)}
+	rpc := httptest.NewServer(node)
+	defer rpc.Close()
+
+	settler, err := NewSelfSettler(SelfSettlerConfig{
+		Network:       "base-sepolia",
+		RPCURL:        rpc.URL,
+		ChainID:       big.NewInt(84532),
+		GasPrivateKey: "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d",
+	})
+	if err != nil {
+		t.Fatalf("NewSelfSettler failed: %v", err)
+	}
+
+	requirement := RequireUSDCBaseSepolia("0x209693Bc6afc0C5328bA36FaF03C514EF312287C", "10000", "Test")
+	signer, err := x402.NewPrivateKeySigner("0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef", x402.AcceptUSDCBaseSepolia())
+	if err != nil {
+		t.Fatalf("NewPrivateKeySigner failed: %v", err)
+	}
+	signed, err := signer.SignPayment(context.Background(), x402.PaymentRequirement{
+		Scheme:            requirement.Scheme,
+		Network:           requirement.Network,
+		MaxAmountRequired: requirement.MaxAmountRequired,
+		Asset:             requirement.Asset,
+		PayTo:             requirement.PayTo,
+		MaxTimeoutSeconds: requirement.MaxTimeoutSeconds,
+		Extra:             requirement.Extra,
+	})
+	if err != nil {
+		t.Fatalf("SignPayment failed: %v", err)
+	}
+	// Payments reach the server as JSON
+	var payment PaymentPayload
+	raw, _ := json.Marshal(signed)
+	if err := json.Unmarshal(raw, &payment); err != nil {
+		t.Fatalf("Failed to decode payment: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("VerifiesAndSettles", func(t *testing.T) {
+		verified, err := settler.Verify(ctx, &payment, &requirement)
+		if err != nil || !verified.IsValid {
+			t.Fatalf("Expected valid payment, got %+v, %v", verified, err)
+		}
+		if verified.Payer != signer.GetAddress() {
+			t.Errorf("Expected payer %s, got %s", signer.GetAddress(), verified.Payer)
+		}
+
+		settled, err := settler.Settle(ctx, &payment, &requirement)
+		if err != nil || !settled.Success {
+			t.Fatalf("Expected settlement, got %+v, %v", settled, err)
+		}
+		sent := node.transactions()
+		if len(sent) != 1 {
+			t.Fatalf("Expected one broadcast transaction, got %d", len(sent))
+		}
+		tx := sent[0]
+		if settled.Transaction != tx.Hash().Hex() {
+			t.Errorf("Expected transaction %s, got %s", tx.Hash().Hex(), settled.Transaction)
+		}
+		if *tx.To() != common.HexToAddress(requirement.Asset) || tx.Nonce() != 7 {
+			t.Errorf("Expected transaction to the token with nonce 7, got to=%s nonce=%d", tx.To(), tx.Nonce())
+		}
+		sender, err := types.Sender(types.LatestSignerForChainID(big.NewInt(84532)), tx)
+		if err != nil || sender.Hex() != settler.GasAddress() {
+			t.Errorf("Expected transaction from gas wallet %s, got %s (%v)", settler.GasAddress(), sender.Hex(), err)
+		}
+		args, err := eip3009.Methods["transferWithAuthorization"].Inputs.Unpack(tx.Data()[4:])
+		if err != nil {
+			t.Fatalf("Failed to decode transfer: %v", err)
+		}
+		if args[0].(common.Address).Hex() != signer.GetAddress() || args[2].(*big.Int).String() != requirement.MaxAmountRequired {
+			t.Errorf("Unexpected transfer arguments: %v", args)
+		}
+	})
+
+	t.Run("RejectsInvalidPayments", func(t *testing.T) {
+		other := requirement
+		other.PayTo = "0x742d35Cc6634C0532925a3b844Bc9e7595f0bEb6"
+		if verified, _ := settler.Verify(ctx, &payment, &other); verified.IsValid {
+			t.Error("Expected payment to another recipient to be invalid")
+		}
+
+		pricier := requirement
+		pricier.MaxAmountRequired = "20000"
+		if verified, _ := settler.Verify(ctx, &payment, &pricier); verified.IsValid {
+			t.Error("Expected underpaying payment to be invalid")
+		}
+
+		node.setUsedNonce(true)
+		defer node.setUsedNonce(false)
+		settled, err := settler.Settle(ctx, &payment, &requirement)
+		if err != nil || settled.Success || settled.ErrorReason != "authorization nonce already used" {
+			t.Errorf("Expected replay to be rejected, got %+v, %v", settled, err)
+		}
+	})
+
+	t.Run("OutlivesCancelledRequests", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		defer cancel()
+		node.setMining(false, cancel)
+		defer node.setMining(false, nil)
+		settled, err := settler.Settle(cancelled, &payment, &requirement)
+		if err != nil || !settled.Success || settled.Status != "" {
+			t.Errorf("Expected the broadcast transfer to be followed to its receipt, got %+v, %v", settled, err)
+		}
+	})
+
+	t.Run("ReportsUnminedAsPending", func(t *testing.T) {
+		impatient, err := NewSelfSettler(SelfSettlerConfig{
+			Network:        "base-sepolia",
+			RPCURL:         rpc.URL,
+			ChainID:        big.NewInt(84532),
+			GasPrivateKey:  "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d",
+			ReceiptTimeout: 50 * time.Millisecond,
+			PollInterval:   10 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewSelfSettler failed: %v", err)
+		}
+		node.setMining(true, nil)
+		defer node.setMining(false, nil)
+		settled, err := impatient.Settle(ctx, &payment, &requirement)
+		sent := node.transactions()
+		if err != nil || !settled.Success || settled.Status != x402.SettlementStatusPending || settled.Transaction != sent[len(sent)-1].Hash().Hex() {
+			t.Errorf("Expected an unmined transfer to be pending with its transaction, got %+v, %v", settled, err)
+		}
+	})
+
+	t.Run("ChecksValidityWindow", func(t *testing.T) {
+		// withAuthorization copies payment with authorization fields replaced
+		withAuthorization := func(fields map[string]string) *PaymentPayload {
+			var copied PaymentPayload
+			_ = json.Unmarshal(raw, &copied)
+			auth := copied.Payload.(map[string]any)["authorization"].(map[string]any)
+			for name, value := range fields {
+				auth[name] = value
+			}
+			return &copied
+		}
+		now := time.Now().Unix()
+
+		early := withAuthorization(map[string]string{"validAfter": strconv.FormatInt(now+3600, 10)})
+		if verified, _ := settler.Verify(ctx, early, &requirement); verified.InvalidReason != "authorization not yet valid" {
+			t.Errorf("Expected not yet valid authorization to be rejected, got %+v", verified)
+		}
+		sent := len(node.transactions())
+		if settled, err := settler.Settle(ctx, early, &requirement); err != nil || settled.Success || len(node.transactions()) != sent {
+			t.Errorf("Expected not yet valid authorization not to be broadcast, got %+v, %v", settled, err)
+		}
+
+		expired := withAuthorization(map[string]string{"validBefore": strconv.FormatInt(now-1, 10)})
+		if verified, _ := settler.Verify(ctx, expired, &requirement); verified.InvalidReason != "authorization expired" {
+			t.Errorf("Expected expired authorization to be rejected, got %+v", verified)
+		}
+
+		// Beyond int64, so only a big.Int comparison sees it as unexpired
+		distant := withAuthorization(map[string]string{"validBefore": "18446744073709551616"})
+		if verified, _ := settler.Verify(ctx, distant, &requirement); verified.InvalidReason == "authorization expired" {
+			t.Errorf("Expected distant validBefore not to expire, got %+v", verified)
+		}
+
+		// The window is checked against the server's clock
+		clock := x402.NewFakeClock(time.Now().Add(24 * time.Hour))
+		facilitator := newFacilitator(&Config{SelfSettlers: []*SelfSettler{settler}, Clock: clock})
+		if verified, _ := facilitator.Verify(ctx, &payment, &requirement); verified.InvalidReason != "authorization expired" {
+			t.Errorf("Expected the payment to expire on the server's clock, got %+v", verified)
+		}
+		// Back on the system clock for the subtests below
+		newFacilitator(&Config{SelfSettlers: []*SelfSettler{settler}})
+	})
+
+	t.Run("LogsThroughConfig", func(t *testing.T) {
+		var logs bytes.Buffer
+		quiet := newFacilitator(&Config{SelfSettlers: []*SelfSettler{settler}, Logger: log.New(&logs, "", 0)})
+		if settled, err := quiet.Settle(ctx, &payment, &requirement); err != nil || !settled.Success {
+			t.Fatalf("Expected settlement, got %+v, %v", settled, err)
+		}
+		if logs.Len() != 0 {
+			t.Errorf("Expected no logs without Verbose, got %q", logs.String())
+		}
+
+		verbose := newFacilitator(&Config{SelfSettlers: []*SelfSettler{settler}, Verbose: true, Logger: log.New(&logs, "", 0)})
+		if settled, err := verbose.Settle(ctx, &payment, &requirement); err != nil || !settled.Success {
+			t.Fatalf("Expected settlement, got %+v, %v", settled, err)
+		}
+		if !strings.Contains(logs.String(), "Broadcast settlement") {
+			t.Errorf("Expected the broadcast to be logged to Config.Logger, got %q", logs.String())
+		}
+	})
+
+	t.Run("RoutesByNetwork", func(t *testing.T) {
+		facilitator := newFacilitator(&Config{SelfSettlers: []*SelfSettler{settler}})
+		supported, err := facilitator.GetSupported(ctx)
+		if err != nil || len(supported) != 1 || supported[0].Network != "base-sepolia" {
+			t.Errorf("Expected only the self-settled network, got %+v, %v", supported, err)
+		}
+		if _, err := facilitator.Verify(ctx, &PaymentPayload{Network: "base"}, &PaymentRequirement{Network: "base"}); err == nil {
+			t.Error("Expected payments on other networks to fail without a facilitator")
+		}
+		if verified, err := facilitator.Verify(ctx, &payment, &requirement); err != nil || !verified.IsValid {
+			t.Errorf("Expected self-settled payment to verify, got %+v, %v", verified, err)
+		}
+	})
+}
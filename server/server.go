@@ -2,11 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/mark3labs/mcp-go-x402"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
@@ -17,16 +19,37 @@ type X402Server struct {
 	config    *Config
 }
 
-// NewX402Server creates a new x402-enabled MCP server
-func NewX402Server(name, version string, config *Config) *X402Server {
-	// Create base MCP server
-	mcpServer := server.NewMCPServer(name, version)
+// NewX402Server creates a new x402-enabled MCP server.
+// Additional server options (hooks, capabilities, instructions) are passed to the underlying MCPServer.
+func NewX402Server(name, version string, config *Config, opts ...server.ServerOption) *X402Server {
+	// Create base MCP server, guarding paid tools against bypassing the payment layer
+	opts = append([]server.ServerOption{WithPayments(config)}, opts...)
+	if config.PaymentLogNotifications {
+		opts = append(opts, server.WithLogging())
+	}
+	mcpServer := server.NewMCPServer(name, version, opts...)
 
 	srv := &X402Server{
 		mcpServer: mcpServer,
 		config:    config,
 	}
 
+	// Expose settled payments as browsable resources
+	if config.Receipts != nil {
+		AddReceiptResources(mcpServer, config.Receipts)
+	}
+
+	// Report the outcome of settlements still pending when requests were served
+	config.notify = mcpServer.SendNotificationToSpecificClient
+	if config.SettlementTimeout > 0 {
+		AddSettlementResources(mcpServer, config)
+	}
+
+	// Let operators manage the payment layer from their MCP client
+	if config.AdminToken != "" {
+		AddAdminTools(mcpServer, config)
+	}
+
 	// Fetch supported payment methods from facilitator on init
 	if config.FacilitatorURL != "" {
 		srv.fetchSupportedPayments()
@@ -37,56 +60,191 @@ func NewX402Server(name, version string, config *Config) *X402Server {
 
 // fetchSupportedPayments fetches and caches supported payment methods from the facilitator
 func (s *X402Server) fetchSupportedPayments() {
-	facilitator := NewHTTPFacilitator(s.config.FacilitatorURL)
+	if err := FetchSupportedPayments(s.config); err != nil {
+		log.Printf("Warning: failed to fetch supported payments from facilitator: %v", err)
+		log.Printf("  Solana payments may not work correctly without feePayer information")
+	}
+}
+
+// FetchSupportedPayments fetches the facilitator's supported payment methods and
+// caches them (including feePayer for Solana networks) for the Require* helpers.
+// NewX402Server calls it; call it yourself before building requirements for an
+// X402Handler or NewX402Proxy. It goes through the facilitator client the
+// config's handlers use, whose SupportedCacheTTL cache and ETag it shares.
+func FetchSupportedPayments(config *Config) error {
+	facilitator := config.sharedFacilitator()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	supported, err := facilitator.GetSupported(ctx)
 	if err != nil {
-		log.Printf("Warning: failed to fetch supported payments from facilitator: %v", err)
-		log.Printf("  Solana payments may not work correctly without feePayer information")
-		return
+		return err
 	}
 
 	// Cache supported payment info (including feePayer for Solana networks)
 	SetSupportedPayments(supported)
 
-	if s.config.Verbose {
+	if config.Verbose {
 		log.Printf("Fetched supported payment methods from facilitator:")
 		for _, kind := range supported {
 			log.Printf("  - %s on %s", kind.Scheme, kind.Network)
 		}
 	}
+	return nil
+}
+
+// MCPServer returns the underlying MCP server for registering resources, prompts,
+// hooks and other capabilities. Tools added directly to it are free unless covered
+// by Config.DefaultPaymentRequirements; use AddPayableTool for paid tools.
+func (s *X402Server) MCPServer() *server.MCPServer {
+	return s.mcpServer
+}
+
+// AddResource adds a resource to the server
+func (s *X402Server) AddResource(resource mcp.Resource, handler server.ResourceHandlerFunc) {
+	s.mcpServer.AddResource(resource, handler)
+}
+
+// AddResourceTemplate adds a resource template to the server
+func (s *X402Server) AddResourceTemplate(template mcp.ResourceTemplate, handler server.ResourceTemplateHandlerFunc) {
+	s.mcpServer.AddResourceTemplate(template, handler)
+}
+
+// AddPrompt adds a prompt to the server
+func (s *X402Server) AddPrompt(prompt mcp.Prompt, handler server.PromptHandlerFunc) {
+	s.mcpServer.AddPrompt(prompt, handler)
+}
+
+// SendNotificationToClient sends a notification to the client of the session in ctx
+func (s *X402Server) SendNotificationToClient(ctx context.Context, method string, params map[string]any) error {
+	return s.mcpServer.SendNotificationToClient(ctx, method, params)
+}
+
+// SendNotificationToAllClients sends a notification to all connected clients
+func (s *X402Server) SendNotificationToAllClients(method string, params map[string]any) {
+	s.mcpServer.SendNotificationToAllClients(method, params)
 }
 
 // AddTool adds a regular (non-paid) tool to the server
 func (s *X402Server) AddTool(tool mcp.Tool, handler server.ToolHandlerFunc) {
+	s.config.registerDuration(tool)
+	if requirements, paid := s.config.toolRequirements(tool.Name); paid {
+		tool = withCostAnnotations(tool, requirements)
+	}
 	s.mcpServer.AddTool(tool, handler)
 }
 
 // AddPayableTool adds a tool that requires payment with one or more payment options
-// If no requirements are provided, the tool is added as a regular non-paid tool and an error is logged
+// If no requirements are provided, the tool is added as a regular non-paid tool and an error is logged.
+// A tool AddPayableToolE refuses is logged and not added.
 func (s *X402Server) AddPayableTool(
 	tool mcp.Tool,
 	handler server.ToolHandlerFunc,
 	requirements ...PaymentRequirement,
 ) {
+	if err := s.AddPayableToolE(tool, handler, requirements...); err != nil {
+		log.Printf("ERROR: Not adding tool %s: %v", tool.Name, err)
+	}
+}
+
+// AddPayableToolE is AddPayableTool, returning an error instead of adding a tool
+// whose payment options UnsupportedNetworkFail refuses
+func (s *X402Server) AddPayableToolE(
+	tool mcp.Tool,
+	handler server.ToolHandlerFunc,
+	requirements ...PaymentRequirement,
+) error {
 	// Validate we have at least one requirement
 	if len(requirements) == 0 {
 		// Log error and add as regular tool instead of panicking
 		log.Printf("ERROR: AddPayableTool called for tool %s without payment requirements. Adding as regular tool instead.", tool.Name)
 		s.mcpServer.AddTool(tool, handler)
-		return
+		return nil
 	}
 
+	requirements, err := s.checkSupportedRequirements(tool.Name, requirements)
+	if err != nil {
+		return err
+	}
+	requirements = s.checkFeePayers(tool.Name, requirements)
+	checkAmounts(tool.Name, requirements, s.config.logger())
+
 	// Add tool to MCP server
-	s.mcpServer.AddTool(tool, handler)
+	s.config.registerDuration(tool)
+	s.mcpServer.AddTool(withCostAnnotations(tool, requirements), handler)
 
 	// Register payment requirements
 	if s.config.PaymentTools == nil {
 		s.config.PaymentTools = make(map[string][]PaymentRequirement)
 	}
 	s.config.PaymentTools[tool.Name] = requirements
+	return nil
+}
+
+// withCostAnnotations marks a payable tool paid and lists its prices in its _meta,
+// leaving the caller's _meta untouched
+func withCostAnnotations(tool mcp.Tool, requirements []PaymentRequirement) mcp.Tool {
+	meta := &mcp.Meta{AdditionalFields: make(map[string]any)}
+	if tool.Meta != nil {
+		meta.ProgressToken = tool.Meta.ProgressToken
+		for k, v := range tool.Meta.AdditionalFields {
+			meta.AdditionalFields[k] = v
+		}
+	}
+
+	hints := make([]x402.CostHint, 0, len(requirements))
+	for _, req := range requirements {
+		hints = append(hints, x402.CostHint{
+			Scheme:  req.Scheme,
+			Network: req.Network,
+			Asset:   req.Asset,
+			Amount:  req.MaxAmountRequired,
+		})
+	}
+	meta.AdditionalFields[x402.MetaKeyPaid] = true
+	meta.AdditionalFields[x402.MetaKeyCostHint] = hints
+	tool.Meta = meta
+	return tool
+}
+
+// ErrUnsupportedNetwork is returned by AddPayableToolE under UnsupportedNetworkFail
+// for a payment option the facilitator doesn't support
+var ErrUnsupportedNetwork = errors.New("payment network not supported by facilitator")
+
+// checkSupportedRequirements cross-checks requirements against the facilitator's
+// supported scheme/network list and applies the configured UnsupportedNetworkPolicy
+func (s *X402Server) checkSupportedRequirements(toolName string, requirements []PaymentRequirement) ([]PaymentRequirement, error) {
+	var supported []PaymentRequirement
+	for _, req := range requirements {
+		ok, known := supportsKind(req.Scheme, req.Network)
+		if !known {
+			return requirements, nil
+		}
+		if ok {
+			supported = append(supported, req)
+			continue
+		}
+
+		switch s.config.UnsupportedNetworkPolicy {
+		case UnsupportedNetworkFail:
+			return nil, fmt.Errorf("%w: tool %s requires %s payment on %s",
+				ErrUnsupportedNetwork, toolName, req.Scheme, req.Network)
+		case UnsupportedNetworkDrop:
+			log.Printf("WARNING: Dropping %s payment option on %s for tool %s: not supported by facilitator",
+				req.Scheme, req.Network, toolName)
+		default:
+			log.Printf("WARNING: Tool %s accepts %s payment on %s, which the facilitator does not support",
+				toolName, req.Scheme, req.Network)
+			supported = append(supported, req)
+		}
+	}
+
+	if len(supported) == 0 {
+		// Never turn a paid tool into a free one
+		log.Printf("ERROR: No payment option for tool %s is supported by the facilitator. Keeping all options.", toolName)
+		return requirements, nil
+	}
+	return supported, nil
 }
 
 // Handler returns the http.Handler for the x402 server
@@ -0,0 +1,459 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// withSupportedPayments replaces the facilitator /supported cache for a test
+func withSupportedPayments(t *testing.T, kinds []SupportedKind) {
+	t.Helper()
+	supportedPaymentsCacheMutex.Lock()
+	saved := supportedPaymentsCache
+	supportedPaymentsCache = make(map[string][]SupportedKind)
+	supportedPaymentsCacheMutex.Unlock()
+	SetSupportedPayments(kinds)
+
+	t.Cleanup(func() {
+		supportedPaymentsCacheMutex.Lock()
+		supportedPaymentsCache = saved
+		supportedPaymentsCacheMutex.Unlock()
+	})
+}
+
+func TestFetchSupportedPayments_SharedFacilitator(t *testing.T) {
+	withSupportedPayments(t, nil)
+	requests := 0
+	facilitatorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"kinds":[{"x402Version":1,"scheme":"exact","network":"base"}]}`))
+	}))
+	defer facilitatorServer.Close()
+
+	config := &Config{FacilitatorURL: facilitatorServer.URL, SupportedCacheTTL: time.Hour}
+	for i := 0; i < 2; i++ {
+		if err := FetchSupportedPayments(config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := NewX402Handler(&mockMCPHandler{}, config)
+	if _, err := handler.facilitator.GetSupported(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected the cached /supported list to be reused, got %d requests", requests)
+	}
+}
+
+func TestX402Server_UnsupportedNetworkPolicy(t *testing.T) {
+	withSupportedPayments(t, []SupportedKind{{X402Version: 1, Scheme: "exact", Network: "base"}})
+
+	base := RequireUSDCBase("0xrecipient", "1000", "base")
+	polygon := RequireUSDCPolygon("0xrecipient", "1000", "polygon")
+
+	tests := []struct {
+		policy UnsupportedNetworkPolicy
+		want   []string
+	}{
+		{policy: "", want: []string{"base", "polygon"}},
+		{policy: UnsupportedNetworkDrop, want: []string{"base"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			srv := NewX402Server("test", "1.0.0", &Config{UnsupportedNetworkPolicy: tt.policy})
+			srv.AddPayableTool(mcp.NewTool("search"), nil, base, polygon)
+
+			got := srv.config.PaymentTools["search"]
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d options, got %d", len(tt.want), len(got))
+			}
+			for i, network := range tt.want {
+				if got[i].Network != network {
+					t.Errorf("Option %d: expected %s, got %s", i, network, got[i].Network)
+				}
+			}
+		})
+	}
+
+	t.Run("DropNeverMakesToolFree", func(t *testing.T) {
+		srv := NewX402Server("test", "1.0.0", &Config{UnsupportedNetworkPolicy: UnsupportedNetworkDrop})
+		srv.AddPayableTool(mcp.NewTool("search"), nil, polygon)
+		if len(srv.config.PaymentTools["search"]) != 1 {
+			t.Error("Expected unsupported options to be kept when none are supported")
+		}
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		srv := NewX402Server("test", "1.0.0", &Config{UnsupportedNetworkPolicy: UnsupportedNetworkFail})
+		err := srv.AddPayableToolE(mcp.NewTool("search"), nil, base, polygon)
+		if !errors.Is(err, ErrUnsupportedNetwork) {
+			t.Errorf("Expected ErrUnsupportedNetwork, got %v", err)
+		}
+		if _, ok := srv.config.PaymentTools["search"]; ok {
+			t.Error("Expected the refused tool not to be registered")
+		}
+
+		// AddPayableTool logs and skips the tool
+		srv.AddPayableTool(mcp.NewTool("lookup"), nil, base, polygon)
+		if srv.MCPServer().GetTool("lookup") != nil {
+			t.Error("Expected AddPayableTool to skip the refused tool")
+		}
+		if err := srv.AddPayableToolE(mcp.NewTool("search"), nil, base); err != nil {
+			t.Errorf("Expected supported options to register, got %v", err)
+		}
+	})
+}
+
+func TestX402Server_FeePayers(t *testing.T) {
+	const cached = "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"
+	withSupportedPayments(t, []SupportedKind{
+		{X402Version: 1, Scheme: "exact", Network: "base"},
+		{X402Version: 1, Scheme: "exact", Network: "solana-devnet", Extra: map[string]string{"feePayer": cached}},
+	})
+	base := RequireUSDCBase("0xrecipient", "1000", "base")
+	sol := RequireSOLDevnet("SoLRecipient", "5000", "sol")
+
+	tests := []struct {
+		name      string
+		feePayers map[string]string
+		option    PaymentRequirement
+		want      string
+	}{
+		{name: "Cached", option: sol, want: cached},
+		{name: "Config", feePayers: map[string]string{"solana-devnet": x402.USDCMintSolanaDevnet}, option: sol, want: x402.USDCMintSolanaDevnet},
+		{name: "Explicit", feePayers: map[string]string{"solana-devnet": x402.USDCMintSolanaDevnet}, option: sol.WithFeePayer(x402.USDCMintSolana), want: x402.USDCMintSolana},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewX402Server("test", "1.0.0", &Config{FeePayers: tt.feePayers})
+			srv.AddPayableTool(mcp.NewTool("search"), nil, base, tt.option)
+			got, _ := srv.config.toolRequirements("search")
+			if len(got) != 2 || got[1].Extra["feePayer"] != tt.want {
+				t.Errorf("Expected fee payer %s, got %+v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("InvalidDropped", func(t *testing.T) {
+		srv := NewX402Server("test", "1.0.0", &Config{})
+		srv.AddPayableTool(mcp.NewTool("search"), nil, base, sol.WithFeePayer("not-a-solana-address"))
+		if got := srv.config.PaymentTools["search"]; len(got) != 1 || got[0].Network != "base" {
+			t.Errorf("Expected the option with an invalid fee payer to be dropped, got %+v", got)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		withSupportedPayments(t, nil)
+		config := &Config{}
+		if _, err := config.resolveFeePayer(RequireSOLDevnet("SoLRecipient", "5000", "sol")); !errors.Is(err, ErrNoFeePayer) {
+			t.Errorf("Expected ErrNoFeePayer, got %v", err)
+		}
+	})
+}
+
+func TestX402Server_ToolTimeouts(t *testing.T) {
+	srv := NewX402Server("test", "1.0.0", &Config{
+		DefaultPaymentRequirements: []PaymentRequirement{RequireUSDCBase("0xrecipient", "100", "default")},
+		ToolTimeouts:               map[string]int{"report": 900},
+	})
+	srv.AddPayableTool(mcp.NewTool("search"), nil, RequireUSDCBase("0xrecipient", "1000", "search"))
+	srv.AddPayableTool(mcp.NewTool("render", WithExpectedDuration(4*time.Minute+500*time.Millisecond)), nil,
+		RequireUSDCBase("0xrecipient", "1000", "render"))
+	srv.AddTool(mcp.NewTool("report", WithExpectedDuration(time.Minute)), nil)
+
+	tests := []struct {
+		tool string
+		want int
+	}{
+		{tool: "search", want: 60},  // fixed value of the requirement
+		{tool: "render", want: 271}, // 240.5s expected + 30s margin, rounded up
+		{tool: "report", want: 900}, // override wins over the expected duration
+	}
+	for _, tt := range tests {
+		requirements, ok := srv.config.toolRequirements(tt.tool)
+		if !ok {
+			t.Fatalf("Expected %s to be paid", tt.tool)
+		}
+		if got := requirements[0].MaxTimeoutSeconds; got != tt.want {
+			t.Errorf("%s: expected MaxTimeoutSeconds %d, got %d", tt.tool, tt.want, got)
+		}
+	}
+
+	// The registered requirements themselves are left untouched
+	if got := srv.config.PaymentTools["render"][0].MaxTimeoutSeconds; got != 60 {
+		t.Errorf("Expected registered requirement to keep 60, got %d", got)
+	}
+}
+
+func TestX402Server_AmountPolicy(t *testing.T) {
+	policy := x402.AmountPolicy{Granularity: "1000", Minimum: "2000"}
+	srv := NewX402Server("test", "1.0.0", &Config{
+		IdentityPolicy: func(ctx context.Context, identity *Identity, tool string, reqs []PaymentRequirement) ([]PaymentRequirement, error) {
+			for i := range reqs {
+				reqs[i].MaxAmountRequired = "3333" // e.g. a computed discount
+			}
+			return reqs, nil
+		},
+	})
+	srv.AddPayableTool(mcp.NewTool("search"), nil, RequireUSDCBase("0xrecipient", "1500", "search").WithAmountPolicy(policy))
+
+	requirements, _ := srv.config.toolRequirements("search")
+	if got := requirements[0].MaxAmountRequired; got != "2000" {
+		t.Errorf("Expected registered amount raised to the minimum 2000, got %s", got)
+	}
+
+	requirements, _, err := srv.config.requirementsFor(context.Background(), "search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := requirements[0].MaxAmountRequired; got != "4000" {
+		t.Errorf("Expected adjusted amount rounded up to 4000, got %s", got)
+	}
+}
+
+func TestLoadFromYAML(t *testing.T) {
+	withSupportedPayments(t, []SupportedKind{{X402Version: 1, Scheme: "exact", Network: "solana-devnet", Extra: map[string]string{"feePayer": "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM"}}})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var args map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&args)
+		w.Write([]byte("weather in " + args["city"].(string) + " for " + r.Header.Get("X-Backend-Key")))
+	}))
+	defer backend.Close()
+
+	t.Setenv("BACKEND_KEY", "secret")
+	t.Setenv("VERBOSE", "true")
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	definition := `
+name: wrapper
+payTo: "0xrecipient"
+verbose: ${VERBOSE}
+allowFree: [version]
+defaultPrice:
+  - network: base
+    amount: "100"
+tools:
+  - name: greet
+    description: Greets someone for $1 ($${BACKEND_KEY})
+    params:
+      - {name: who, required: true}
+    command: ["echo", "hello {{who}}"]
+    price:
+      - {network: base-sepolia, amount: "1000"}
+      - {network: solana-devnet, asset: sol, amount: "5000", payTo: SoLRecipient}
+  - name: weather
+    params:
+      - {name: city}
+    http:
+      url: ` + backend.URL + `
+      headers: {X-Backend-Key: "${BACKEND_KEY}"}
+    timeout: 2m
+  - name: version
+    command: ["echo", "1.0"]
+  - name: flood
+    command: ["head", "-c", "3000000", "/dev/zero"]
+  - name: noisy
+    command: ["sh", "-c", "yes | head -c 3000000 >&2; exit 1"]
+`
+	if err := os.WriteFile(path, []byte(definition), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := LoadFromYAML(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	greet := srv.config.PaymentTools["greet"]
+	if len(greet) != 2 || greet[0].Network != "base-sepolia" || greet[0].PayTo != "0xrecipient" || greet[0].Description != "Greets someone for $1 (${BACKEND_KEY})" {
+		t.Fatalf("Unexpected greet requirements: %+v", greet)
+	}
+	if greet[1].Asset != x402.SOLNative || greet[1].PayTo != "SoLRecipient" || greet[1].Extra["feePayer"] != "9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM" {
+		t.Errorf("Unexpected SOL requirement: %+v", greet[1])
+	}
+	if weather, ok := srv.config.toolRequirements("weather"); !ok || weather[0].MaxAmountRequired != "100" || weather[0].MaxTimeoutSeconds != 150 {
+		t.Errorf("Expected weather to use the default price with a 150s timeout, got %+v", weather)
+	}
+	if _, ok := srv.config.toolRequirements("version"); ok {
+		t.Error("Expected version to be free")
+	}
+	if !srv.config.Verbose {
+		t.Error("Expected verbose from the environment")
+	}
+
+	call := func(tool string, args map[string]any) string {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Name = tool
+		request.Params.Arguments = args
+		result, err := srv.MCPServer().GetTool(tool).Handler(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text, _ := mcp.AsTextContent(result.Content[0])
+		if result.IsError {
+			t.Fatalf("%s failed: %s", tool, text.Text)
+		}
+		return text.Text
+	}
+	if got := call("greet", map[string]any{"who": "world"}); got != "hello world\n" {
+		t.Errorf("Unexpected command output %q", got)
+	}
+	if got := call("greet", map[string]any{"who": "{{x}}", "x": "!"}); got != "hello {{x}}\n" {
+		t.Errorf("Expected arguments not to be expanded again, got %q", got)
+	}
+	if got := call("weather", map[string]any{"city": "Paris"}); got != "weather in Paris for secret" {
+		t.Errorf("Unexpected backend output %q", got)
+	}
+	if got := call("flood", nil); len(got) != maxBackendOutput {
+		t.Errorf("Expected output capped at %d bytes, got %d", maxBackendOutput, len(got))
+	}
+	noisy := mcp.CallToolRequest{}
+	noisy.Params.Name = "noisy"
+	result, err := srv.MCPServer().GetTool("noisy").Handler(context.Background(), noisy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, _ := mcp.AsTextContent(result.Content[0]); !result.IsError || text.Text == "" || len(text.Text) > maxBackendOutput {
+		t.Errorf("Expected an error with stderr capped at %d bytes, got %d bytes", maxBackendOutput, len(text.Text))
+	}
+
+	t.Run("InvalidDefinitions", func(t *testing.T) {
+		for name, def := range map[string]ServerDefinition{
+			"NoBackend":      {Name: "s", Tools: []ToolDefinition{{Name: "t"}}},
+			"UnknownNetwork": {Name: "s", PayTo: "0x1", Tools: []ToolDefinition{{Name: "t", Command: []string{"true"}, Price: []PriceDefinition{{Network: "mars", Amount: "1"}}}}},
+			"SOLOnEVM":       {Name: "s", PayTo: "0x1", Tools: []ToolDefinition{{Name: "t", Command: []string{"true"}, Price: []PriceDefinition{{Network: "base", Asset: "sol", Amount: "1"}}}}},
+			"NoPayTo":        {Name: "s", Tools: []ToolDefinition{{Name: "t", Command: []string{"true"}, Price: []PriceDefinition{{Network: "base", Amount: "1"}}}}},
+		} {
+			if _, err := NewFromDefinition(def); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
+
+func TestPricingExperiment(t *testing.T) {
+	config := &Config{
+		PaymentTools: map[string][]PaymentRequirement{
+			"search": {RequireUSDCBase("0xrecipient", "1000", "search")},
+			"render": {RequireUSDCBase("0xrecipient", "5000", "render")},
+		},
+		PricingExperiments: []PricingExperiment{{
+			Name:     "search-price",
+			Percent:  30,
+			Variants: map[string][]PaymentRequirement{"search": {RequireUSDCBase("0xrecipient", "1500", "search")}},
+		}},
+	}
+	subjectContext := func(session string) context.Context {
+		r := httptest.NewRequest("POST", "/mcp", nil)
+		r.Header.Set("Mcp-Session-Id", session)
+		return withExperimentSubject(context.Background(), r)
+	}
+
+	variant := 0
+	for i := 0; i < 1000; i++ {
+		ctx := subjectContext(fmt.Sprintf("session-%d", i))
+		requirements, _, _ := config.requirementsFor(ctx, "search")
+		again, _, _ := config.requirementsFor(ctx, "search")
+		if requirements[0].MaxAmountRequired != again[0].MaxAmountRequired {
+			t.Fatal("Expected a stable assignment per session")
+		}
+
+		switch requirements[0].Experiment {
+		case "search-price/variant":
+			variant++
+			if requirements[0].MaxAmountRequired != "1500" || requirements[0].Resource != "mcp://tools/search" {
+				t.Fatalf("Unexpected variant requirement: %+v", requirements[0])
+			}
+		case "search-price/control":
+			if requirements[0].MaxAmountRequired != "1000" {
+				t.Fatalf("Unexpected control requirement: %+v", requirements[0])
+			}
+		default:
+			t.Fatalf("Unexpected label %q", requirements[0].Experiment)
+		}
+	}
+	if variant < 250 || variant > 350 {
+		t.Errorf("Expected about 30%% of sessions in the variant, got %d of 1000", variant)
+	}
+
+	// Tools outside the experiment and callers without a session are unaffected
+	if requirements, _, _ := config.requirementsFor(subjectContext("session-1"), "render"); requirements[0].Experiment != "" {
+		t.Errorf("Expected no label outside the experiment, got %q", requirements[0].Experiment)
+	}
+	if requirements, _, _ := config.requirementsFor(context.Background(), "search"); requirements[0].Experiment != "search-price/control" {
+		t.Errorf("Expected the control arm without a subject, got %q", requirements[0].Experiment)
+	}
+}
+
+func TestAdminTools(t *testing.T) {
+	receipts := NewReceiptStore(0)
+	receipts.add(Receipt{Transaction: "0x1", Network: "base", Asset: "0xusdc", Tool: "search", Amount: "1000"})
+	receipts.add(Receipt{Transaction: "0x2", Network: "base", Asset: "0xusdc", Tool: "search", Amount: "2000"})
+
+	config := &Config{AdminToken: "secret", Receipts: receipts}
+	srv := NewX402Server("test", "1.0.0", config)
+	srv.AddPayableTool(mcp.NewTool("search"), nil, RequireUSDCBase("0xrecipient", "1000", "Search"))
+
+	callTool := func(ctx context.Context, name string, args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		message, _ := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  "tools/call",
+			"params":  map[string]any{"name": name, "arguments": args},
+		})
+		resp, ok := srv.MCPServer().HandleMessage(ctx, message).(mcp.JSONRPCResponse)
+		if !ok {
+			t.Fatalf("Expected a result calling %s", name)
+		}
+		result, ok := resp.Result.(mcp.CallToolResult)
+		if !ok {
+			t.Fatalf("Unexpected result %T", resp.Result)
+		}
+		return &result
+	}
+	text := func(result *mcp.CallToolResult) string {
+		return result.Content[0].(mcp.TextContent).Text
+	}
+	operator := withAdminToken(context.Background(), "secret")
+
+	if _, paid := config.toolRequirements(AdminToolRevenueReport); paid {
+		t.Error("Expected admin tools to be free")
+	}
+	if result := callTool(withAdminToken(context.Background(), "wrong"), AdminToolRevenueReport, nil); !result.IsError {
+		t.Error("Expected a wrong token to be refused")
+	}
+
+	var totals []RevenueTotal
+	if err := json.Unmarshal([]byte(text(callTool(operator, AdminToolRevenueReport, nil))), &totals); err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].Amount != "3000" || totals[0].Payments != 2 {
+		t.Errorf("Unexpected revenue report %+v", totals)
+	}
+
+	if result := callTool(operator, AdminToolSetPrice, map[string]any{"tool": "search", "amount": "5000"}); result.IsError {
+		t.Fatalf("set_price failed: %s", text(result))
+	}
+	if requirements, _ := config.toolRequirements("search"); requirements[0].MaxAmountRequired != "5000" {
+		t.Errorf("Expected the new price, got %s", requirements[0].MaxAmountRequired)
+	}
+
+	if result := callTool(operator, AdminToolPendingSettlements, nil); !result.IsError {
+		t.Error("Expected an error without a settlement journal")
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store namespaces of the handler's state
+const (
+	StoreNamespaceAccess  = "access"  // Sessions that paid the access fee
+	StoreNamespaceBatch   = "batch"   // Prepaid batches and their remaining calls
+	StoreNamespaceTrial   = "trial"   // Free trials of payers and their calls
+	StoreNamespaceDeposit = "deposit" // Prepaid deposits and their balances
+)
+
+// Store keeps the payment state of an X402Handler. The default MemoryStore is
+// per process; a shared store (such as the redisstore package) lets replicas
+// behind a load balancer share paid sessions and prepaid batches. Values are
+// opaque bytes; IncrBy values are decimal integers.
+type Store interface {
+	// Get returns the value of key, or nil if it is missing or expired
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set stores value under key, expiring after ttl (never when zero)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// SetNX stores value under key only if key is missing, reporting whether it did
+	SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+
+	// Delete removes key
+	Delete(ctx context.Context, key string) error
+
+	// IncrBy atomically adds delta to the integer at key (zero when missing) and
+	// returns the result. ttl applies when the key is created.
+	IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+}
+
+// namespacedStore prefixes the keys of a Store
+type namespacedStore struct {
+	store  Store
+	prefix string
+}
+
+// NewNamespacedStore returns a view of store whose keys are prefixed with
+// "namespace:", so features sharing a store don't collide
+func NewNamespacedStore(store Store, namespace string) Store {
+	return &namespacedStore{store: store, prefix: namespace + ":"}
+}
+
+func (n *namespacedStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return n.store.Get(ctx, n.prefix+key)
+}
+
+func (n *namespacedStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return n.store.Set(ctx, n.prefix+key, value, ttl)
+}
+
+func (n *namespacedStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return n.store.SetNX(ctx, n.prefix+key, value, ttl)
+}
+
+func (n *namespacedStore) Delete(ctx context.Context, key string) error {
+	return n.store.Delete(ctx, n.prefix+key)
+}
+
+func (n *namespacedStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return n.store.IncrBy(ctx, n.prefix+key, delta, ttl)
+}
+
+// memorySweepInterval is how often MemoryStore drops expired entries
+const memorySweepInterval = time.Minute
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time // Zero when the entry never expires
+}
+
+// MemoryStore is an in-process Store, the default of X402Handler
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, entries: make(map[string]memoryEntry)}
+}
+
+// Get implements Store
+func (m *MemoryStore) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+// Set implements Store
+func (m *MemoryStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store(key, value, ttl)
+	return nil
+}
+
+// SetNX implements Store
+func (m *MemoryStore) SetNX(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.lookup(key); ok {
+		return false, nil
+	}
+	m.store(key, value, ttl)
+	return true, nil
+}
+
+// Delete implements Store
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+// IncrBy implements Store
+func (m *MemoryStore) IncrBy(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		m.store(key, []byte(strconv.FormatInt(delta, 10)), ttl)
+		return delta, nil
+	}
+	value, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	value += delta
+	entry.value = []byte(strconv.FormatInt(value, 10))
+	m.entries[key] = entry
+	return value, nil
+}
+
+// lookup returns the live entry of key
+func (m *MemoryStore) lookup(key string) (memoryEntry, bool) {
+	entry, ok := m.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if !entry.expires.IsZero() && !m.now().Before(entry.expires) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+// store sets an entry, occasionally sweeping expired ones
+func (m *MemoryStore) store(key string, value []byte, ttl time.Duration) {
+	now := m.now()
+	entry := memoryEntry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	m.entries[key] = entry
+
+	if now.Sub(m.lastSweep) < memorySweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for k, e := range m.entries {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	if value, err := store.Get(ctx, "missing"); value != nil || err != nil {
+		t.Errorf("Expected nil for a missing key, got %q, %v", value, err)
+	}
+
+	_ = store.Set(ctx, "session", []byte("1"), time.Minute)
+	if ok, _ := store.SetNX(ctx, "session", []byte("2"), 0); ok {
+		t.Error("SetNX should not overwrite an existing key")
+	}
+	if value, _ := store.Get(ctx, "session"); string(value) != "1" {
+		t.Errorf("Expected 1, got %q", value)
+	}
+
+	if n, _ := store.IncrBy(ctx, "calls", 3, time.Minute); n != 3 {
+		t.Errorf("Expected 3, got %d", n)
+	}
+	if n, _ := store.IncrBy(ctx, "calls", -1, time.Minute); n != 2 {
+		t.Errorf("Expected 2, got %d", n)
+	}
+
+	now = now.Add(time.Minute)
+	if value, _ := store.Get(ctx, "session"); value != nil {
+		t.Errorf("Expected the session to expire, got %q", value)
+	}
+	if ok, _ := store.SetNX(ctx, "session", []byte("2"), 0); !ok {
+		t.Error("SetNX should store an expired key")
+	}
+
+	// Namespaces share the store without colliding
+	access := NewNamespacedStore(store, StoreNamespaceAccess)
+	_ = access.Set(ctx, "session", []byte("paid"), 0)
+	if value, _ := store.Get(ctx, "access:session"); string(value) != "paid" {
+		t.Errorf("Expected namespaced key, got %q", value)
+	}
+	if value, _ := store.Get(ctx, "session"); string(value) != "2" {
+		t.Errorf("Expected unprefixed key untouched, got %q", value)
+	}
+}
+
+func TestBatchPasses_SharedStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	replicaA, replicaB := newBatchPasses(store, log.Default()), newBatchPasses(store, log.Default())
+
+	info := &PaymentInfo{Settlement: &SettleResponse{Success: true, Transaction: "0xtx", Payer: "0xpayer"}, payer: "0xverified"}
+	token, err := replicaA.issue(ctx, map[string]int{"search": 2}, info, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Calls are redeemable on any replica, and only as often as prepaid
+	for i, replica := range []*batchPasses{replicaB, replicaA} {
+		redeemed, ok := replica.redeem(ctx, token, "search")
+		if !ok || redeemed.Settlement.Transaction != "0xtx" {
+			t.Fatalf("Redeem %d: expected the batch payment, got %v, %v", i, redeemed, ok)
+		}
+		if redeemed.payer != "0xverified" {
+			t.Errorf("Redeem %d: expected the verified payer to be kept, got %q", i, redeemed.payer)
+		}
+	}
+	if _, ok := replicaB.redeem(ctx, token, "search"); ok {
+		t.Error("Expected the batch to be used up")
+	}
+	if _, ok := replicaB.redeem(ctx, token, "other"); ok {
+		t.Error("Expected tools outside the batch to be refused")
+	}
+}
+
+func TestDeposits_WithdrawRestoresOnFailedRefund(t *testing.T) {
+	ctx := context.Background()
+	deposits := newDeposits(NewMemoryStore())
+	fund := depositFund{Network: "base", Asset: "0xUSDC"}
+
+	token, err := deposits.credit(ctx, "", "0xpayer", fund, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := deposits.withdraw(ctx, token, func(DepositAccount) (string, error) {
+		return "", errors.New("refund wallet empty")
+	}); err == nil {
+		t.Fatal("Expected the failed refund to be reported")
+	}
+
+	// The deposit is open again with its balance, matched case-insensitively
+	requirement, payer, err := deposits.draw(ctx, token, []PaymentRequirement{{Network: "base", Asset: "0xusdc", MaxAmountRequired: "1000"}})
+	if err != nil || requirement == nil || payer != "0xpayer" {
+		t.Fatalf("Expected to draw from the restored deposit, got %v, %q, %v", requirement, payer, err)
+	}
+	if requirement, _, _ := deposits.draw(ctx, token, []PaymentRequirement{{Network: "base", Asset: "0xusdc", MaxAmountRequired: "1"}}); requirement != nil {
+		t.Error("Expected the empty deposit to cover nothing")
+	}
+}
+
+// unavailableStore fails every read, like a store whose backend is down
+type unavailableStore struct {
+	*MemoryStore
+}
+
+func (unavailableStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, errors.New("store unavailable")
+}
+
+func TestStoreErrors_LogThroughConfig(t *testing.T) {
+	var logs bytes.Buffer
+	h := NewX402Handler(&mockMCPHandler{}, &Config{
+		FacilitatorURL: "http://mock",
+		Store:          unavailableStore{NewMemoryStore()},
+		Logger:         log.New(&logs, "", 0),
+	})
+
+	if h.access.has(context.Background(), "session") {
+		t.Error("Expected store errors to deny access")
+	}
+	if _, ok := h.batches.redeem(context.Background(), "token", "search"); ok {
+		t.Error("Expected store errors to refuse the batch")
+	}
+	for _, want := range []string{"Failed to look up session access", "Failed to look up batch"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("Expected %q in Config.Logger, got %q", want, logs.String())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"math"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MetaKeyExpectedDuration is the tool _meta key advertising how long a tool is
+// expected to run, in seconds
+const MetaKeyExpectedDuration = "x402/expected-duration"
+
+// TimeoutMargin is added to a tool's expected duration when deriving the
+// MaxTimeoutSeconds of its requirements, covering verification and settlement
+const TimeoutMargin = 30 * time.Second
+
+// WithExpectedDuration advertises how long a tool is expected to run. X402Server
+// derives the MaxTimeoutSeconds of the tool's payment requirements from it.
+func WithExpectedDuration(d time.Duration) mcp.ToolOption {
+	return func(t *mcp.Tool) {
+		if t.Meta == nil {
+			t.Meta = &mcp.Meta{}
+		}
+		if t.Meta.AdditionalFields == nil {
+			t.Meta.AdditionalFields = make(map[string]any)
+		}
+		t.Meta.AdditionalFields[MetaKeyExpectedDuration] = d.Seconds()
+	}
+}
+
+// expectedDuration returns the duration advertised with WithExpectedDuration
+func expectedDuration(tool mcp.Tool) (time.Duration, bool) {
+	if tool.Meta == nil {
+		return 0, false
+	}
+	seconds, ok := tool.Meta.AdditionalFields[MetaKeyExpectedDuration].(float64)
+	if !ok || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+// registerDuration records the expected duration a tool advertises in ToolDurations
+func (c *Config) registerDuration(tool mcp.Tool) {
+	d, ok := expectedDuration(tool)
+	if !ok {
+		return
+	}
+	if c.ToolDurations == nil {
+		c.ToolDurations = make(map[string]time.Duration)
+	}
+	c.ToolDurations[tool.Name] = d
+}
+
+// toolTimeout returns the MaxTimeoutSeconds for a tool's requirements: its
+// ToolTimeouts override, else its expected duration plus TimeoutMargin.
+// Zero keeps the requirements' own values.
+func (c *Config) toolTimeout(toolName string) int {
+	if timeout, ok := c.ToolTimeouts[toolName]; ok && timeout > 0 {
+		return timeout
+	}
+	if d, ok := c.ToolDurations[toolName]; ok && d > 0 {
+		return int(math.Ceil((d + TimeoutMargin).Seconds()))
+	}
+	return 0
+}
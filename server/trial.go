@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// defaultTrialResetAfter is the default of Trial.ResetAfter
+const defaultTrialResetAfter = 30 * 24 * time.Hour
+
+// Trial lets each payer call a paid tool for free before paying. Payers are told
+// apart by their verified identity; callers without one get no trial, as a new
+// MCP session costs nothing. Set at least one of FreeCalls and Period.
+type Trial struct {
+	// FreeCalls is the number of free calls (unlimited within Period when zero)
+	FreeCalls int
+
+	// Period ends the trial this long after the payer's first call (never when zero)
+	Period time.Duration
+
+	// ResetAfter is how long a payer's trial is remembered after its first call,
+	// after which the payer gets a new trial (30 days when zero, at least Period)
+	ResetAfter time.Duration
+}
+
+// resetAfter returns how long the trial's state is kept
+func (t Trial) resetAfter() time.Duration {
+	ttl := t.ResetAfter
+	if ttl <= 0 {
+		ttl = defaultTrialResetAfter
+	}
+	return max(ttl, t.Period)
+}
+
+// trials tracks the free trials of payers: the start of each trial under
+// "tool:payer:start" and its calls under "tool:payer:calls"
+type trials struct {
+	store Store
+}
+
+func newTrials(store Store) *trials {
+	return &trials{store: NewNamespacedStore(store, StoreNamespaceTrial)}
+}
+
+// consume uses one free call of a payer's trial of tool, returning the trial's
+// status after the call. Once the trial ended, Active is false and no call is used.
+func (t *trials) consume(ctx context.Context, tool, payer string, trial Trial, now time.Time) (x402.TrialStatus, error) {
+	status := x402.TrialStatus{Tool: tool, FreeCalls: trial.FreeCalls}
+	key := tool + ":" + payer
+	ttl := trial.resetAfter()
+
+	if trial.Period > 0 {
+		// The first call starts the trial; later calls keep its start
+		if _, err := t.store.SetNX(ctx, key+":start", []byte(strconv.FormatInt(now.Unix(), 10)), ttl); err != nil {
+			return status, err
+		}
+		value, err := t.store.Get(ctx, key+":start")
+		if err != nil {
+			return status, err
+		}
+		start, _ := strconv.ParseInt(string(value), 10, 64)
+		status.ExpiresAt = start + int64(trial.Period/time.Second)
+		if now.Unix() >= status.ExpiresAt {
+			return status, nil
+		}
+	}
+
+	if trial.FreeCalls > 0 {
+		calls, err := t.store.IncrBy(ctx, key+":calls", 1, ttl)
+		if err != nil {
+			return status, err
+		}
+		if calls > int64(trial.FreeCalls) {
+			return status, nil
+		}
+		status.CallsRemaining = trial.FreeCalls - int(calls)
+	}
+
+	status.Active = true
+	return status, nil
+}
+
+// trialPayer returns the payer a trial belongs to: the verified identity. It
+// returns "" for anonymous requests, which get no trial.
+func trialPayer(r *http.Request) string {
+	if identity, ok := IdentityFromContext(r.Context()); ok {
+		return "identity:" + strings.ToLower(identity.Subject)
+	}
+	return ""
+}
+
+// useTrial consumes a free call of tool for the request's payer. ok is true when
+// the trial covers the call. A trial that ended is attached to the returned
+// context, so the 402 tells the client.
+func (h *X402Handler) useTrial(r *http.Request, tool string) (status x402.TrialStatus, ok bool, ctx context.Context) {
+	ctx = r.Context()
+	trial, configured := h.config.Trials[tool]
+	if !configured || (trial.FreeCalls <= 0 && trial.Period <= 0) {
+		return status, false, ctx
+	}
+	payer := trialPayer(r)
+	if payer == "" {
+		return status, false, ctx
+	}
+
+	status, err := h.trials.consume(ctx, tool, payer, trial, h.config.now())
+	if err != nil {
+		h.logf("[X402] Failed to look up free trial of %s: %v", tool, err)
+		return status, false, ctx
+	}
+	if !status.Active {
+		return status, false, withTrialStatus(ctx, &status)
+	}
+	return status, true, ctx
+}
+
+type trialStatusKey struct{}
+
+// withTrialStatus attaches the ended trial a 402 reports
+func withTrialStatus(ctx context.Context, status *x402.TrialStatus) context.Context {
+	return context.WithValue(ctx, trialStatusKey{}, status)
+}
+
+func trialStatusFromContext(ctx context.Context) *x402.TrialStatus {
+	status, _ := ctx.Value(trialStatusKey{}).(*x402.TrialStatus)
+	return status
+}
+
+type trialKey struct{}
+
+// TrialFromContext returns the free trial that covered the current request, if
+// any. Tool handlers behind an X402Handler get it instead of a PaymentInfo.
+func TrialFromContext(ctx context.Context) (x402.TrialStatus, bool) {
+	status, ok := ctx.Value(trialKey{}).(x402.TrialStatus)
+	return status, ok
+}
+
+// forwardWithTrial forwards a tool call covered by a free trial, reporting the
+// trial in the result's _meta["x402/trial"]
+func (h *X402Handler) forwardWithTrial(w http.ResponseWriter, r *http.Request, status x402.TrialStatus) {
+	r = r.WithContext(context.WithValue(r.Context(), trialKey{}, status))
+
+	recorder := &responseRecorder{
+		ResponseWriter: w,
+		body:           &bytes.Buffer{},
+		statusCode:     http.StatusOK,
+	}
+	h.mcpHandler.ServeHTTP(recorder, r)
+
+	if recorder.statusCode == http.StatusOK {
+		contentType := recorder.Header().Get("Content-Type")
+		switch {
+		case contentType == "application/json":
+			recorder.body = bytes.NewBuffer(addTrialStatus(recorder.body.Bytes(), status))
+		case strings.HasPrefix(contentType, "text/event-stream"):
+			recorder.body = bytes.NewBuffer(mapResultEvents(recorder.body.Bytes(), func(data []byte) []byte {
+				return addTrialStatus(data, status)
+			}))
+		}
+	}
+
+	for k, v := range recorder.Header() {
+		w.Header()[k] = v
+	}
+	w.Header().Del("Content-Length") // The body may have grown
+	w.WriteHeader(recorder.statusCode)
+	_, _ = w.Write(recorder.body.Bytes())
+}
+
+// addTrialStatus adds the trial status to the _meta of a JSON-RPC tool call
+// response, returning body unchanged if it is not a successful response
+func addTrialStatus(body []byte, status x402.TrialStatus) []byte {
+	var jsonrpcResp transport.JSONRPCResponse
+	if err := json.Unmarshal(body, &jsonrpcResp); err != nil || jsonrpcResp.Error != nil {
+		return body
+	}
+	var result map[string]any
+	if err := json.Unmarshal(jsonrpcResp.Result, &result); err != nil || result == nil {
+		return body
+	}
+	meta, _ := result["_meta"].(map[string]any)
+	if meta == nil {
+		meta = make(map[string]any)
+	}
+	meta[x402.MetaKeyTrial] = status
+	result["_meta"] = meta
+
+	jsonrpcResp.Result, _ = json.Marshal(result)
+	var out bytes.Buffer
+	_ = json.NewEncoder(&out).Encode(jsonrpcResp)
+	return out.Bytes()
+}
@@ -1,5 +1,18 @@
 package server
 
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go-x402"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
 // PaymentRequirement defines payment requirements for a resource/tool
 // as defined in the x402 specification section 5.1
 type PaymentRequirement struct {
@@ -14,6 +27,28 @@ type PaymentRequirement struct {
 	OutputSchema      any               `json:"outputSchema,omitempty"`
 	MaxTimeoutSeconds int               `json:"maxTimeoutSeconds"`
 	Extra             map[string]string `json:"extra,omitempty"`
+
+	// AmountPolicy, if set, rounds the amounts the server charges with this option
+	// (e.g. after IdentityPolicy or batch discounts) and enforces a minimum
+	AmountPolicy *x402.AmountPolicy `json:"-"`
+
+	// Experiment labels requirements offered under a PricingExperiment
+	// ("name/control" or "name/variant"); it is recorded in receipts
+	Experiment string `json:"-"`
+
+	// FeePayer, if set, is advertised as the Solana fee payer in
+	// Extra["feePayer"], see WithFeePayer
+	FeePayer string `json:"-"`
+
+	// PerItem, if set, charges per item of the tool's result instead of
+	// MaxAmountRequired, see WithPerItemPricing
+	PerItem *PerItemPricing `json:"-"`
+}
+
+// WithAmountPolicy returns a copy of the requirement with an amount policy
+func (r PaymentRequirement) WithAmountPolicy(policy x402.AmountPolicy) PaymentRequirement {
+	r.AmountPolicy = &policy
+	return r
 }
 
 // PaymentRequirements402Response is the HTTP 402 response body
@@ -21,6 +56,12 @@ type PaymentRequirements402Response struct {
 	X402Version int                  `json:"x402Version"`
 	Error       string               `json:"error"`
 	Accepts     []PaymentRequirement `json:"accepts"`
+
+	// Trial is the payer's ended free trial of the tool, if it had one
+	Trial *x402.TrialStatus `json:"trial,omitempty"`
+
+	// SettlementSeconds is Config.ExpectedSettlementTime, in seconds
+	SettlementSeconds int `json:"settlementSeconds,omitempty"`
 }
 
 // PaymentPayload represents the X-PAYMENT header content
@@ -40,6 +81,22 @@ type SettlementResponse struct {
 	Network     string `json:"network"`
 	Payer       string `json:"payer"`
 	ErrorReason string `json:"errorReason,omitempty"`
+	Reference   string `json:"reference,omitempty"`  // Echo of the client's payment reference
+	OnBehalfOf  string `json:"onBehalfOf,omitempty"` // Echo of the end user the payment was made for
+
+	// Status is "pending" and PaymentID set for settlements that outlived
+	// Config.SettlementTimeout, see x402.SettlementStatus. Status alone is
+	// "pending" for a transaction the facilitator broadcast but saw not mined.
+	Status    string `json:"status,omitempty"`
+	PaymentID string `json:"paymentId,omitempty"`
+
+	// Integrity binds the settlement to the request ID and result, see Config.ResultIntegrity
+	Integrity          string `json:"integrity,omitempty"`
+	IntegritySignature string `json:"integritySignature,omitempty"`
+
+	// Items and Amount report what a per-item priced call was charged
+	Items  int    `json:"items,omitempty"`
+	Amount string `json:"amount,omitempty"`
 }
 
 // VerifyRequest sent to facilitator /verify endpoint
@@ -76,6 +133,10 @@ type SettleResponse struct {
 	Transaction string `json:"transaction"`
 	Network     string `json:"network"`
 	ErrorReason string `json:"errorReason,omitempty"`
+
+	// Status is x402.SettlementStatusPending for a successful settlement whose
+	// Transaction was broadcast but not yet mined
+	Status string `json:"status,omitempty"`
 }
 
 // Config for X402Server
@@ -83,13 +144,456 @@ type Config struct {
 	// FacilitatorURL is the base URL of the x402 facilitator service
 	FacilitatorURL string
 
+	// FacilitatorAuth holds the API key, bearer token or HMAC credentials
+	// sent to the facilitator. Nil sends unauthenticated requests.
+	FacilitatorAuth *FacilitatorAuth
+
+	// SupportedCacheTTL is how long the facilitator's /supported response is
+	// cached before being revalidated (with If-None-Match). Zero always revalidates.
+	// The cache is shared by FetchSupportedPayments and the config's handlers.
+	SupportedCacheTTL time.Duration
+
+	// BatchPayments if true, lets clients pay once for a batch of tool calls announced
+	// in _meta["x402/batch"]. The batch price is the sum of the calls' prices for each
+	// option all paid tools share, less BatchDiscountPercent.
+	BatchPayments bool
+
+	// BatchDiscountPercent discounts batch payments (0-99)
+	BatchDiscountPercent int
+
+	// BatchTTL is how long the rest of a paid batch can be redeemed (10 minutes when zero)
+	BatchTTL time.Duration
+
+	// DepositRequirements, if set, lets clients prepay a deposit with the
+	// x402/deposit method, paying one of these options in full. Tool calls
+	// carrying the deposit token in _meta["x402/deposit"] draw their price from
+	// the balance in the asset paid, reported in _meta["x402/deposit-balance"],
+	// and are asked to pay as usual once it runs out. Per-item options are never
+	// drawn from deposits. Balances are kept in Store and never expire.
+	DepositRequirements []PaymentRequirement
+
+	// OnDepositWithdraw, if set, refunds a deposit closed with x402/deposit/withdraw
+	// and returns the refund transaction. The deposit is restored when it fails.
+	// Without it withdrawals are refused.
+	OnDepositWithdraw func(ctx context.Context, account DepositAccount) (string, error)
+
+	// Trials, if set, gives payers free calls of the named tools before they must
+	// pay, tracked in Store. Calls covered report the trial in
+	// _meta["x402/trial"]; once it ends, the 402 data reports it under "trial".
+	Trials map[string]Trial
+
+	// QuoteTTL, if set, lets clients lock the price of a tool for this long: the
+	// x402/quote method returns a signed quote, and tool calls carrying it in
+	// _meta["x402/quote"] are charged the quoted requirements
+	QuoteTTL time.Duration
+
+	// QuoteSecret signs quotes. When empty a random key is used, so quotes don't
+	// survive server restarts or carry across replicas.
+	QuoteSecret []byte
+
+	// UnsupportedNetworkPolicy decides what AddPayableTool does with payment options
+	// the facilitator doesn't support. Defaults to UnsupportedNetworkWarn. Checks are
+	// skipped when the facilitator's /supported list could not be fetched.
+	UnsupportedNetworkPolicy UnsupportedNetworkPolicy
+
+	// FeePayers sets the Solana fee payer advertised per network ("solana",
+	// "solana-devnet"). A requirement's own WithFeePayer takes precedence; the
+	// facilitator's /supported feePayer applies to networks without one.
+	// AddPayableTool drops Solana options without a valid fee payer.
+	FeePayers map[string]string
+
+	// FacilitatorScheme selects the facilitator transport: "http" (default) or "grpc".
+	// For "grpc", FacilitatorURL is the target address (host:port).
+	FacilitatorScheme string
+
+	// FacilitatorTLSConfig configures TLS for the gRPC facilitator; nil uses the system roots
+	FacilitatorTLSConfig *tls.Config
+
+	// FacilitatorInsecure disables TLS for the gRPC facilitator (local development only)
+	FacilitatorInsecure bool
+
+	// FacilitatorCodec encodes HTTP facilitator request and response bodies, for
+	// facilitators expecting CBOR or protobuf (JSON when nil)
+	FacilitatorCodec x402.Codec
+
+	// PaymentCodecs decode X-PAYMENT headers whose X-PAYMENT-ENCODING names
+	// their content type. JSON headers are always accepted.
+	PaymentCodecs []x402.Codec
+
+	// Facilitator, if set, is used instead of the client selected by FacilitatorURL
+	// and FacilitatorScheme, e.g. a ChaosFacilitator in resilience tests
+	Facilitator Facilitator
+
+	// SelfSettlers verify and settle payments on their networks themselves,
+	// broadcasting EIP-3009 transfers from the server's gas wallet, for networks
+	// no facilitator covers. Payments on other networks go to the facilitator;
+	// without FacilitatorURL or Facilitator only self-settled networks are accepted.
+	SelfSettlers []*SelfSettler
+
 	// PaymentTools maps tool names to their payment requirements
 	// Each tool can have multiple payment options
 	PaymentTools map[string][]PaymentRequirement
 
+	// ToolDurations maps tool names to how long the tools are expected to run.
+	// Their requirements get a MaxTimeoutSeconds of the expected duration plus
+	// TimeoutMargin instead of their fixed value. X402Server registers durations
+	// tools advertise with WithExpectedDuration.
+	ToolDurations map[string]time.Duration
+
+	// ToolTimeouts maps tool names to the MaxTimeoutSeconds of their requirements,
+	// overriding ToolDurations
+	ToolTimeouts map[string]int
+
+	// DefaultPaymentRequirements apply to every tool without an entry in PaymentTools.
+	// Tools listed in AllowFree stay free.
+	DefaultPaymentRequirements []PaymentRequirement
+
+	// AllowFree lists tool names exempt from DefaultPaymentRequirements
+	AllowFree []string
+
 	// VerifyOnly if true, only verifies but doesn't settle payments
 	VerifyOnly bool
 
+	// SettlementJournal, if set, records every verified payment before it is settled
+	// and its settlement outcome, so payments left unsettled by facilitator outages
+	// or crashes can be listed (Unreconciled) and retried (RecoverSettlements)
+	SettlementJournal SettlementJournal
+
+	// RecoverSettlementsOnStart if true, settles the journal entries a crash left
+	// verified in the background when the handler is created (see RecoverSettlements)
+	RecoverSettlementsOnStart bool
+
+	// Receipts, if set, keeps a receipt of every settled payment. NewX402Server
+	// exposes them as MCP resources (see AddReceiptResources).
+	Receipts *ReceiptStore
+
+	// OnPaid, if set, runs after a paid tool call has been settled and the tool has
+	// returned, and may return a modified result, e.g. with premium fields for paying
+	// callers. The context carries the PaymentInfo. Returning nil keeps the result;
+	// errors are logged and the result is sent unchanged, as the payment has settled.
+	OnPaid func(ctx context.Context, payer string, settlement *SettleResponse, result *mcp.CallToolResult) (*mcp.CallToolResult, error)
+
+	// Store keeps paid access sessions and prepaid batches. Nil uses a per-process
+	// MemoryStore; set a shared store (e.g. redisstore) when running replicas.
+	Store Store
+
+	// PricingExperiments offer alternative prices to a share of callers. A tool's
+	// requirements come from the first experiment with a variant for it.
+	PricingExperiments []PricingExperiment
+
+	// FiatOracle, if set, values advertised requirements in FiatCurrency for display:
+	// 402 responses carry the approximate value ("~$0.01") in each requirement's
+	// Extra["fiatEstimate"]. The oracle returns values in units of 10^-FiatDecimals
+	// of the currency and should cache its prices, as it is called for every 402.
+	FiatOracle   x402.PriceOracle
+	FiatCurrency string // ISO 4217 code (USD when empty)
+	FiatDecimals int    // Decimals of the oracle's values (2 when zero, i.e. cents)
+
+	// SettlementAssets, keyed by tool name, asks the facilitator to convert
+	// payments on each listed network into the given asset before paying the
+	// recipient, so a treasury accepting many tokens receives one. The target is
+	// advertised in the requirement's Extra["settlementAsset"] when the
+	// facilitator's /supported lists settlementConversion for the network. When
+	// the facilitator rejects the conversion the payment settles in the paid asset.
+	SettlementAssets map[string][]SettlementAsset
+
 	// Verbose if true, logs detailed request and payment information
 	Verbose bool
+
+	// Logger receives the handler's log output (the standard logger when nil)
+	Logger x402.Logger
+
+	// LogSampleRate limits verbose logging to one in every LogSampleRate requests
+	// (all when 0 or 1), so it stays usable at production rates. Failed payments
+	// are always logged.
+	LogSampleRate int
+
+	// LogRedaction masks payer addresses, recipients and transaction hashes in
+	// verbose logs. The facilitator client logs neither sampled nor redacted, so
+	// its verbose logging is off when either control is set.
+	LogRedaction x402.RedactionLevel
+
+	// AccessRequirements, if set, charges a one-time access fee per MCP session
+	// (resource mcp://server/access). Until paid, every request from the session
+	// other than initialize, ping and notifications receives a 402, so the fee is
+	// typically collected on tools/list. Requires a stateful (session-based) server.
+	AccessRequirements []PaymentRequirement
+
+	// GateInitialize if true, collects the access fee on initialize itself
+	GateInitialize bool
+
+	// AccessPassTTL, if set, issues an access pass (X-ACCESS-PASS response header) with
+	// each paid access fee. Requests presenting a valid pass get access for their
+	// session without paying again, e.g. after the client restarts.
+	AccessPassTTL time.Duration
+
+	// AccessPassSecret signs access passes. When empty a random key is used, so
+	// passes don't survive server restarts.
+	AccessPassSecret []byte
+
+	// RequireRequestBinding if true, rejects EVM payments whose authorization nonce
+	// does not commit to the JSON-RPC method and params of the request carrying it.
+	// Solana payments have no nonce and are not checked.
+	RequireRequestBinding bool
+
+	// RequireResourceBinding if true, asks clients to bind EVM payment nonces to
+	// this server's origin and the resource paid for (requirement Extra
+	// "resourceBinding": "required") and rejects payments that aren't, so a
+	// leaked authorization can't be redeemed for another server or resource.
+	RequireResourceBinding bool
+
+	// ServerOrigin is the scheme://host[:port] clients reach the server at,
+	// which resource-bound payments commit to. When empty it is taken from each
+	// request; set it behind proxies and for PaymentCore.
+	ServerOrigin string
+
+	// MaxConcurrentSettlements bounds the number of paid requests talking to the
+	// facilitator (verify + settle) at once. Zero means unlimited.
+	MaxConcurrentSettlements int
+
+	// MaxSettlementQueue is how many paid requests may wait for a free facilitator
+	// slot before new ones are rejected with ErrorCodeServerBusy. Zero disables waiting.
+	MaxSettlementQueue int
+
+	// RequireIdentity if true, rejects tool calls without a valid payer identity
+	// claim in _meta["x402/identity"]
+	RequireIdentity bool
+
+	// IdentityAudience, if set, must match the audience of identity claims
+	// (clients use the server URL)
+	IdentityAudience string
+
+	// IdentityPolicy, if set, adjusts a tool's payment requirements per payer,
+	// e.g. for reputation-based discounts. identity is nil for anonymous callers.
+	// Returning no requirements makes the call free; returning an error rejects it
+	// with ErrorCodeIdentityRejected.
+	IdentityPolicy func(ctx context.Context, identity *Identity, toolName string, requirements []PaymentRequirement) ([]PaymentRequirement, error)
+
+	// PaymentRequiredCode is the JSON-RPC error code of payment required errors.
+	// Defaults to DefaultPaymentRequiredCode (402); some ecosystems expect -32402.
+	// ErrorCodeServerBusy and ErrorCodeIdentityRejected are refused in favor of
+	// the default, as clients would take those errors for rejected payments.
+	PaymentRequiredCode int
+
+	// MetaNamespace names the _meta keys payments are read and settlement
+	// responses sent under (x402.MetaNamespaceX402 when unset). Payments are also
+	// read under the keys of x402.KnownMetaNamespaces.
+	MetaNamespace x402.MetaNamespace
+
+	// ResultIntegrity if true, adds to the settlement of each paid result the
+	// x402.ResultIntegrityDigest binding the settlement transaction, the JSON-RPC
+	// request ID and the result, so clients can detect proxies substituting
+	// cached or cheaper results. With ResultIntegrityKey set, the digest is also
+	// signed, which proxies cannot forge; clients pin its address.
+	ResultIntegrity    bool
+	ResultIntegrityKey *ecdsa.PrivateKey
+
+	// AdvertiseCapability if true, declares the server's x402 support (versions,
+	// payment flows, accepted currencies, payment required code) in
+	// capabilities.experimental["x402"] of the initialize result, so clients can
+	// pick their payment flow before the first 402
+	AdvertiseCapability bool
+
+	// PaymentProgress if true, sends MCP progress notifications ("Verifying payment",
+	// "Settling payment on base", "Payment settled, tx=...") while a paid tool call's
+	// payment is processed. It applies to calls with a progress token from clients
+	// accepting text/event-stream; the response is then streamed as SSE and the
+	// settlement is returned in _meta only, not in the X-PAYMENT-RESPONSE header.
+	PaymentProgress bool
+
+	// ExpectedSettlementTime, if set, tells clients how long settlement usually
+	// takes, so they wait longer for paid responses instead of timing out and
+	// losing the payment. 402 data reports it as settlementSeconds, and the
+	// "Settling payment" progress notification carries it in
+	// _meta["x402/settlement-pending"].
+	ExpectedSettlementTime time.Duration
+
+	// SettlementTimeout, if set, bounds how long a paid request waits for the
+	// facilitator to settle its verified payment. Past it the request is served
+	// anyway, with a settlement of status "pending" and a paymentId, while
+	// settlement continues in the background. The outcome is sent to the paying
+	// session as a notifications/x402/settlement notification and can be read
+	// from the x402://settlements/{paymentId} resource (see
+	// AddSettlementResources). Receipts of pending payments are recorded once
+	// they settle. Only single tool calls are served pending: deposits, access
+	// fees and batch payments always wait for settlement.
+	SettlementTimeout time.Duration
+
+	// PaymentLogNotifications if true, sends the paying MCP session a
+	// notifications/message with logger "x402" for each payment settled before
+	// its tool runs, with the session's payments and spend so far (see
+	// x402.PaymentLog). It is streamed ahead of the tool's result to sessions
+	// that set their log level to info or lower and accept text/event-stream.
+	// NewX402Server declares the logging capability; other MCPServers need
+	// server.WithLogging().
+	PaymentLogNotifications bool
+
+	// StrictAuthorizationWindow if true, rejects EVM payments whose authorization
+	// is not yet valid, expires within MinAuthorizationValidity (15s when zero), or
+	// stays valid longer than the requirement's MaxTimeoutSeconds (at least 60s).
+	// AuthorizationClockSkew (30s when zero) tolerates payer clock differences.
+	StrictAuthorizationWindow bool
+	MinAuthorizationValidity  time.Duration
+	AuthorizationClockSkew    time.Duration
+
+	// RequestIDHeader names the header carrying the client's request ID
+	// (X-Request-ID when empty). It is recorded in PaymentInfo, receipts and
+	// settlement journal entries, so payments can be correlated with the
+	// client's and gateways' logs.
+	RequestIDHeader string
+
+	// Clock, if set, is the clock authorization windows, access passes, quotes,
+	// identity claims, free trials, pending settlement updates and the default
+	// Store's expiries go by (the system clock when nil), so tests can move time
+	// without sleeping
+	Clock x402.Clock
+
+	// AdminToken, if set, enables the operator tools (x402.revenue_report,
+	// x402.pending_settlements, x402.set_price) for calls presenting it in the
+	// HeaderAdminToken header, see AddAdminTools
+	AdminToken string
+
+	// prices holds the tool prices set with x402.set_price
+	prices priceOverrides
+
+	// pending tracks the settlements that outlived SettlementTimeout
+	pending pendingSettlements
+
+	// facilitator is the facilitator client shared by FetchSupportedPayments and
+	// handlers, see sharedFacilitator
+	facilitatorOnce sync.Once
+	facilitator     Facilitator
+
+	// notify sends a notification to an MCP session, set by NewX402Server and
+	// NewStreamableHTTPHandler
+	notify func(sessionID, method string, params map[string]any) error
+}
+
+// UnsupportedNetworkPolicy controls how AddPayableTool treats payment options whose
+// scheme/network is not in the facilitator's /supported list
+type UnsupportedNetworkPolicy string
+
+const (
+	// UnsupportedNetworkWarn logs a warning and keeps the option (default)
+	UnsupportedNetworkWarn UnsupportedNetworkPolicy = "warn"
+	// UnsupportedNetworkDrop removes the option from 402 responses
+	UnsupportedNetworkDrop UnsupportedNetworkPolicy = "drop"
+	// UnsupportedNetworkFail refuses to register the tool: AddPayableToolE returns
+	// ErrUnsupportedNetwork and AddPayableTool logs it
+	UnsupportedNetworkFail UnsupportedNetworkPolicy = "fail"
+)
+
+// now returns the time on the configured Clock
+func (c *Config) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
+
+// isFreeTool reports whether the tool is exempt from default payment requirements
+func (c *Config) isFreeTool(toolName string) bool {
+	if c.AdminToken != "" && isAdminTool(toolName) {
+		return true
+	}
+	for _, name := range c.AllowFree {
+		if name == toolName {
+			return true
+		}
+	}
+	return false
+}
+
+// toolRequirements returns the payment requirements for a tool, if it is paid
+func (c *Config) toolRequirements(toolName string) ([]PaymentRequirement, bool) {
+	configured, needsPayment := c.PaymentTools[toolName]
+	if !needsPayment {
+		if len(c.DefaultPaymentRequirements) == 0 || c.isFreeTool(toolName) {
+			return nil, false
+		}
+		configured = c.DefaultPaymentRequirements
+	}
+	return c.prepareRequirements(toolName, configured), true
+}
+
+// prepareRequirements returns a copy of a tool's configured requirements with the
+// per-tool fields set
+func (c *Config) prepareRequirements(toolName string, configured []PaymentRequirement) []PaymentRequirement {
+	// Copy since the resource is set per tool below and IdentityPolicy may adjust prices
+	requirements := make([]PaymentRequirement, len(configured))
+	copy(requirements, configured)
+
+	// Ensure all requirements have proper fields set
+	timeout := c.toolTimeout(toolName)
+	for i := range requirements {
+		requirements[i].Resource = fmt.Sprintf("mcp://tools/%s", toolName)
+		if timeout > 0 {
+			requirements[i].MaxTimeoutSeconds = timeout
+		}
+		if requirements[i].MimeType == "" {
+			requirements[i].MimeType = "application/json"
+		}
+	}
+	c.prices.apply(toolName, requirements)
+	c.withFeePayers(requirements)
+	c.withSettlementAssets(toolName, requirements)
+	roundAmounts(requirements, c.logger())
+	return requirements
+}
+
+// paymentRequiredCode returns the JSON-RPC error code of payment required
+// errors: PaymentRequiredCode, unless it is unset or reserved for other errors
+func (c *Config) paymentRequiredCode() int {
+	switch c.PaymentRequiredCode {
+	case 0, ErrorCodeServerBusy, ErrorCodeIdentityRejected:
+		return DefaultPaymentRequiredCode
+	}
+	return c.PaymentRequiredCode
+}
+
+// logger returns Logger, or the standard logger when it is nil
+func (c *Config) logger() x402.Logger {
+	if c.Logger == nil {
+		return log.Default()
+	}
+	return c.Logger
+}
+
+// facilitatorVerbose reports whether the facilitator client logs its calls
+func (c *Config) facilitatorVerbose() bool {
+	return c.Verbose && c.LogSampleRate <= 1 && c.LogRedaction == x402.RedactNone
+}
+
+// requirementsFor returns the payment requirements for a tool call after applying
+// IdentityPolicy to the payer identity in ctx
+func (c *Config) requirementsFor(ctx context.Context, toolName string) ([]PaymentRequirement, bool, error) {
+	requirements, paid := c.toolRequirements(toolName)
+	if paid {
+		requirements = c.applyExperiments(ctx, toolName, requirements)
+	}
+	if c.IdentityPolicy == nil {
+		return requirements, paid, nil
+	}
+
+	identity, _ := IdentityFromContext(ctx)
+	requirements, err := c.IdentityPolicy(ctx, identity, toolName, requirements)
+	if err != nil {
+		return nil, false, err
+	}
+	roundAmounts(requirements, c.logger())
+	return requirements, len(requirements) > 0, nil
+}
+
+// paymentCodec returns the codec of an X-PAYMENT header with the given
+// X-PAYMENT-ENCODING, and whether it is accepted
+func (c *Config) paymentCodec(encoding string) (x402.Codec, bool) {
+	if encoding == "" || encoding == x402.JSONCodec.ContentType() {
+		return x402.JSONCodec, true
+	}
+	for _, codec := range c.PaymentCodecs {
+		if codec != nil && codec.ContentType() == encoding {
+			return codec, true
+		}
+	}
+	return nil, false
 }
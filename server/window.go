@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// defaultAuthorizationClockSkew tolerates clock differences with payers in strict mode
+	defaultAuthorizationClockSkew = 30 * time.Second
+
+	// defaultMinAuthorizationValidity is how long an authorization must remain valid
+	// for settlement to plausibly complete
+	defaultMinAuthorizationValidity = 15 * time.Second
+
+	// minAuthorizationTimeout mirrors the minimum window clients sign for
+	minAuthorizationTimeout = 60 * time.Second
+)
+
+// checkAuthorizationWindow validates an EVM authorization's validAfter/validBefore
+// against the requirement's MaxTimeoutSeconds and the server clock. Payments
+// without an authorization (SVM) are not checked.
+func (h *X402Handler) checkAuthorizationWindow(payment *PaymentPayload, requirement *PaymentRequirement, now time.Time) error {
+	payloadMap, ok := payment.Payload.(map[string]any)
+	if !ok {
+		return nil
+	}
+	authData, ok := payloadMap["authorization"].(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	validAfter, err := unixField(authData, "validAfter")
+	if err != nil {
+		return err
+	}
+	validBefore, err := unixField(authData, "validBefore")
+	if err != nil {
+		return err
+	}
+
+	skew := h.config.AuthorizationClockSkew
+	if skew <= 0 {
+		skew = defaultAuthorizationClockSkew
+	}
+	minValidity := h.config.MinAuthorizationValidity
+	if minValidity <= 0 {
+		minValidity = defaultMinAuthorizationValidity
+	}
+	maxValidity := time.Duration(requirement.MaxTimeoutSeconds) * time.Second
+	if maxValidity < minAuthorizationTimeout {
+		maxValidity = minAuthorizationTimeout
+	}
+
+	switch {
+	case validBefore.Before(validAfter):
+		return fmt.Errorf("validBefore precedes validAfter")
+	case validAfter.After(now.Add(skew)):
+		return fmt.Errorf("authorization not valid until %s", validAfter.UTC().Format(time.RFC3339))
+	case validBefore.Sub(now) < minValidity:
+		return fmt.Errorf("authorization expires in %s, before settlement could complete", validBefore.Sub(now).Round(time.Second))
+	case validBefore.Sub(now) > maxValidity+skew:
+		return fmt.Errorf("authorization valid for %s, longer than the %s timeout", validBefore.Sub(now).Round(time.Second), maxValidity)
+	}
+	return nil
+}
+
+// unixField parses a unix timestamp given as a decimal string or JSON number
+func unixField(data map[string]any, name string) (time.Time, error) {
+	var seconds int64
+	switch v := data[name].(type) {
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid %s: %q", name, v)
+		}
+		seconds = n
+	case float64:
+		seconds = int64(v)
+	default:
+		return time.Time{}, fmt.Errorf("missing %s", name)
+	}
+	return time.Unix(seconds, 0), nil
+}
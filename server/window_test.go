@@ -0,0 +1,52 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckAuthorizationWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	handler := &X402Handler{config: &Config{StrictAuthorizationWindow: true}}
+	requirement := &PaymentRequirement{MaxTimeoutSeconds: 120}
+
+	payment := func(after, before time.Duration) *PaymentPayload {
+		return &PaymentPayload{Payload: map[string]any{
+			"authorization": map[string]any{
+				"validAfter":  strconv.FormatInt(now.Add(after).Unix(), 10),
+				"validBefore": strconv.FormatInt(now.Add(before).Unix(), 10),
+			},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		payment *PaymentPayload
+		wantErr string
+	}{
+		{name: "client default window", payment: payment(-30*time.Second, 120*time.Second)},
+		{name: "within skew", payment: payment(20*time.Second, 140*time.Second)},
+		{name: "not yet valid", payment: payment(5*time.Minute, 6*time.Minute), wantErr: "not valid until"},
+		{name: "expires too soon", payment: payment(-time.Minute, 5*time.Second), wantErr: "before settlement"},
+		{name: "valid too long", payment: payment(-30*time.Second, 24*time.Hour), wantErr: "longer than"},
+		{name: "inverted", payment: payment(time.Minute, 30*time.Second), wantErr: "precedes"},
+		{name: "solana payload", payment: &PaymentPayload{Payload: map[string]any{"transaction": "base64"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handler.checkAuthorizationWindow(tt.payment, requirement, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,436 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultBackendTimeout bounds tool backends of YAML definitions without a timeout
+const DefaultBackendTimeout = 30 * time.Second
+
+// maxBackendOutput caps the output of a tool backend returned to the client
+const maxBackendOutput = 1 << 20
+
+// ServerDefinition declares an X402Server: its facilitator, pricing and tools,
+// each proxied to a shell command or HTTP backend (see LoadFromYAML)
+type ServerDefinition struct {
+	Name        string                `yaml:"name"`
+	Version     string                `yaml:"version"`
+	Facilitator FacilitatorDefinition `yaml:"facilitator"`
+
+	// PayTo is the recipient of prices that don't set their own
+	PayTo string `yaml:"payTo"`
+
+	// DefaultPrice applies to every tool without a price, except those in AllowFree
+	DefaultPrice []PriceDefinition `yaml:"defaultPrice"`
+	AllowFree    []string          `yaml:"allowFree"`
+
+	VerifyOnly bool             `yaml:"verifyOnly"`
+	Verbose    bool             `yaml:"verbose"`
+	Tools      []ToolDefinition `yaml:"tools"`
+}
+
+// FacilitatorDefinition configures the facilitator of a ServerDefinition
+type FacilitatorDefinition struct {
+	URL         string `yaml:"url"`
+	Scheme      string `yaml:"scheme"` // "http" (default) or "grpc"
+	Insecure    bool   `yaml:"insecure"`
+	APIKey      string `yaml:"apiKey"`
+	BearerToken string `yaml:"bearerToken"`
+}
+
+// PriceDefinition is one payment option of a tool
+type PriceDefinition struct {
+	// Network is one of base, base-sepolia, polygon, polygon-amoy, avalanche,
+	// avalanche-fuji, solana or solana-devnet
+	Network string `yaml:"network"`
+
+	// Amount is in the asset's base units (USDC has 6 decimals, SOL 9)
+	Amount string `yaml:"amount"`
+
+	// Asset is "usdc" (default) or, on Solana networks, "sol"
+	Asset string `yaml:"asset"`
+
+	// PayTo overrides the definition's recipient
+	PayTo string `yaml:"payTo"`
+
+	// FeePayer sets the Solana fee payer, instead of the facilitator's
+	FeePayer string `yaml:"feePayer"`
+}
+
+// ToolDefinition declares a tool backed by a command or an HTTP endpoint.
+// Exactly one of Command and HTTP must be set.
+type ToolDefinition struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description"`
+	Params      []ParamDefinition `yaml:"params"`
+
+	// Command is run without a shell; "{{param}}" in its arguments is replaced
+	// by the call's argument. The arguments are also passed as JSON on stdin.
+	Command []string `yaml:"command"`
+
+	HTTP *HTTPBackend `yaml:"http"`
+
+	// Price lists the tool's payment options; without one the definition's
+	// DefaultPrice applies
+	Price []PriceDefinition `yaml:"price"`
+
+	// Timeout bounds the backend (DefaultBackendTimeout when zero)
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// ParamDefinition declares a tool parameter
+type ParamDefinition struct {
+	Name        string `yaml:"name"`
+	Type        string `yaml:"type"` // string (default), number or boolean
+	Description string `yaml:"description"`
+	Required    bool   `yaml:"required"`
+}
+
+// HTTPBackend proxies tool calls to an HTTP endpoint. GET requests carry the
+// arguments as query parameters, other methods as a JSON body.
+type HTTPBackend struct {
+	URL     string            `yaml:"url"`
+	Method  string            `yaml:"method"` // POST when empty
+	Headers map[string]string `yaml:"headers"`
+}
+
+// LoadFromYAML builds an X402Server from a YAML ServerDefinition, so paid wrappers
+// around existing commands and APIs can be deployed without code. ${VAR}
+// references in values are expanded from the environment, e.g. for API keys;
+// other uses of $ are kept, and $${VAR} is kept as a literal ${VAR}.
+func LoadFromYAML(path string, opts ...server.ServerOption) (*X402Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Values are expanded once parsed, so the environment can't change the
+	// document's structure
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	expandEnvNode(&doc)
+	expanded, err := yaml.Marshal(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(expanded))
+	decoder.KnownFields(true)
+	var def ServerDefinition
+	if err := decoder.Decode(&def); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return NewFromDefinition(def, opts...)
+}
+
+// envReference matches ${VAR} and its escape $${
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvNode expands ${VAR} references in the scalar values under node
+func expandEnvNode(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		expanded := envReference.ReplaceAllStringFunc(node.Value, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			return os.Getenv(ref[2 : len(ref)-1])
+		})
+		if expanded != node.Value {
+			// Unquoted values are typed by what they expand to, e.g. booleans
+			node.Value, node.Tag = expanded, ""
+		}
+		return
+	}
+	for i, child := range node.Content {
+		// Mapping keys are left alone
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		expandEnvNode(child)
+	}
+}
+
+// NewFromDefinition builds an X402Server from a ServerDefinition
+func NewFromDefinition(def ServerDefinition, opts ...server.ServerOption) (*X402Server, error) {
+	if def.Name == "" {
+		return nil, fmt.Errorf("server name is required")
+	}
+	if def.Version == "" {
+		def.Version = "1.0.0"
+	}
+
+	config := &Config{
+		FacilitatorURL:      def.Facilitator.URL,
+		FacilitatorScheme:   def.Facilitator.Scheme,
+		FacilitatorInsecure: def.Facilitator.Insecure,
+		AllowFree:           def.AllowFree,
+		VerifyOnly:          def.VerifyOnly,
+		Verbose:             def.Verbose,
+	}
+	if def.Facilitator.APIKey != "" || def.Facilitator.BearerToken != "" {
+		config.FacilitatorAuth = &FacilitatorAuth{
+			APIKey:      def.Facilitator.APIKey,
+			BearerToken: def.Facilitator.BearerToken,
+		}
+	}
+	srv := NewX402Server(def.Name, def.Version, config, opts...)
+
+	// Requirements are built after NewX402Server has fetched the facilitator's
+	// /supported list, which carries the Solana fee payer
+	var err error
+	config.DefaultPaymentRequirements, err = def.requirements(def.DefaultPrice, "")
+	if err != nil {
+		return nil, fmt.Errorf("default price: %w", err)
+	}
+
+	for _, tool := range def.Tools {
+		if tool.Name == "" {
+			return nil, fmt.Errorf("tool name is required")
+		}
+		handler, err := tool.handler()
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
+		mcpTool, err := tool.mcpTool()
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
+		requirements, err := def.requirements(tool.Price, tool.Description)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", tool.Name, err)
+		}
+
+		if len(requirements) == 0 {
+			srv.AddTool(mcpTool, handler)
+		} else if err := srv.AddPayableToolE(mcpTool, handler, requirements...); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
+}
+
+// requirements converts prices into payment requirements
+func (def *ServerDefinition) requirements(prices []PriceDefinition, description string) ([]PaymentRequirement, error) {
+	var requirements []PaymentRequirement
+	for _, price := range prices {
+		payTo := price.PayTo
+		if payTo == "" {
+			payTo = def.PayTo
+		}
+		if payTo == "" {
+			return nil, fmt.Errorf("no payTo for price on %s", price.Network)
+		}
+		if price.Amount == "" {
+			return nil, fmt.Errorf("no amount for price on %s", price.Network)
+		}
+		requirement, err := RequireAsset(price.Network, price.Asset, payTo, price.Amount, description)
+		if err != nil {
+			return nil, err
+		}
+		if price.FeePayer != "" {
+			requirement = requirement.WithFeePayer(price.FeePayer)
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+// mcpTool returns the MCP tool declaration
+func (d *ToolDefinition) mcpTool() (mcp.Tool, error) {
+	options := []mcp.ToolOption{mcp.WithDescription(d.Description)}
+	for _, param := range d.Params {
+		propertyOptions := []mcp.PropertyOption{mcp.Description(param.Description)}
+		if param.Required {
+			propertyOptions = append(propertyOptions, mcp.Required())
+		}
+		switch param.Type {
+		case "", "string":
+			options = append(options, mcp.WithString(param.Name, propertyOptions...))
+		case "number":
+			options = append(options, mcp.WithNumber(param.Name, propertyOptions...))
+		case "boolean":
+			options = append(options, mcp.WithBoolean(param.Name, propertyOptions...))
+		default:
+			return mcp.Tool{}, fmt.Errorf("param %s: unsupported type %q", param.Name, param.Type)
+		}
+	}
+	if d.Timeout > 0 {
+		options = append(options, WithExpectedDuration(d.Timeout))
+	}
+	return mcp.NewTool(d.Name, options...), nil
+}
+
+// handler returns the tool handler calling the tool's backend
+func (d *ToolDefinition) handler() (server.ToolHandlerFunc, error) {
+	switch {
+	case len(d.Command) > 0 && d.HTTP != nil:
+		return nil, fmt.Errorf("set either command or http, not both")
+	case len(d.Command) > 0:
+		return d.runCommand, nil
+	case d.HTTP != nil:
+		if d.HTTP.URL == "" {
+			return nil, fmt.Errorf("http backend requires a url")
+		}
+		return d.callHTTP, nil
+	default:
+		return nil, fmt.Errorf("a command or http backend is required")
+	}
+}
+
+func (d *ToolDefinition) timeout() time.Duration {
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return DefaultBackendTimeout
+}
+
+// placeholder matches the {{param}} placeholders of command arguments
+var placeholder = regexp.MustCompile(`\{\{([^{}]+)\}\}`)
+
+// runCommand runs the tool's command, returning its output
+func (d *ToolDefinition) runCommand(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	// Placeholders are replaced in one pass, so arguments containing {{name}}
+	// are passed as is
+	args := req.GetArguments()
+	argv := make([]string, len(d.Command))
+	for i, arg := range d.Command {
+		argv[i] = placeholder.ReplaceAllStringFunc(arg, func(match string) string {
+			if value, ok := args[match[2:len(match)-2]]; ok {
+				return fmt.Sprint(value)
+			}
+			return match
+		})
+	}
+
+	stdin, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	stderr := &cappedBuffer{limit: maxBackendOutput}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = stderr
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	// Output beyond the cap is drained without being kept, so the command can finish
+	stdout, readErr := io.ReadAll(io.LimitReader(pipe, maxBackendOutput+1))
+	_, _ = io.Copy(io.Discard, pipe)
+	err = cmd.Wait()
+	if err == nil {
+		err = readErr
+	}
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return mcp.NewToolResultError(message), nil
+	}
+	return mcp.NewToolResultText(truncateOutput(string(stdout))), nil
+}
+
+// callHTTP forwards the call's arguments to the tool's HTTP backend
+func (d *ToolDefinition) callHTTP(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout())
+	defer cancel()
+
+	method := strings.ToUpper(d.HTTP.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	target := d.HTTP.URL
+	var body io.Reader
+	args := req.GetArguments()
+	if method == http.MethodGet {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backend url: %w", err)
+		}
+		query := u.Query()
+		for name, value := range args {
+			query.Set(name, fmt.Sprint(value))
+		}
+		u.RawQuery = query.Encode()
+		target = u.String()
+	} else {
+		payload, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range d.HTTP.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("backend request failed: %v", err)), nil
+	}
+	defer resp.Body.Close()
+	output, err := io.ReadAll(io.LimitReader(resp.Body, maxBackendOutput+1))
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("reading backend response: %v", err)), nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return mcp.NewToolResultError(fmt.Sprintf("backend returned %s: %s", resp.Status, truncateOutput(string(output)))), nil
+	}
+	return mcp.NewToolResultText(truncateOutput(string(output))), nil
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the rest
+// (not embedding bytes.Buffer, whose ReadFrom would bypass the limit)
+type cappedBuffer struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string { return b.buf.String() }
+
+// truncateOutput caps backend output at maxBackendOutput bytes
+func truncateOutput(output string) string {
+	if len(output) > maxBackendOutput {
+		return output[:maxBackendOutput]
+	}
+	return output
+}
@@ -0,0 +1,176 @@
+package x402
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	// defaultSettlementPollInterval is the default of Config.SettlementPollInterval
+	defaultSettlementPollInterval = 5 * time.Second
+
+	// settlementPollTimeout bounds following a pending settlement; servers give
+	// up on background settlements after as long
+	settlementPollTimeout = 10 * time.Minute
+)
+
+// GetSettlementStatus reads the status of a settlement the server reported as
+// pending (SettlementResponse.PaymentID) from its status resource
+func (t *X402Transport) GetSettlementStatus(ctx context.Context, paymentID string) (*SettlementStatus, error) {
+	resp, err := t.SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      mcp.NewRequestId(fmt.Sprintf("x402-settlement-%d", time.Now().UnixNano())),
+		Method:  string(mcp.MethodResourcesRead),
+		Params:  map[string]any{"uri": SettlementStatusURI(paymentID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != nil {
+		return nil, fmt.Errorf("settlement status request failed: %s", resp.Error.Message)
+	}
+
+	var result struct {
+		Contents []struct {
+			Text string `json:"text"`
+		} `json:"contents"`
+	}
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return nil, fmt.Errorf("invalid settlement status response: %w", err)
+	}
+	if len(result.Contents) == 0 {
+		return nil, errors.New("invalid settlement status response: no contents")
+	}
+	var status SettlementStatus
+	if err := json.Unmarshal([]byte(result.Contents[0].Text), &status); err != nil {
+		return nil, fmt.Errorf("invalid settlement status: %w", err)
+	}
+	return &status, nil
+}
+
+// pendingSettlements delivers settlement status notifications to the
+// goroutines following the settlements
+type pendingSettlements struct {
+	mu      sync.Mutex
+	waiting map[string]chan SettlementStatus
+}
+
+func newPendingSettlements() *pendingSettlements {
+	return &pendingSettlements{waiting: make(map[string]chan SettlementStatus)}
+}
+
+// watch returns the channel receiving the final status of a payment
+func (p *pendingSettlements) watch(paymentID string) <-chan SettlementStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch := make(chan SettlementStatus, 1)
+	p.waiting[paymentID] = ch
+	return ch
+}
+
+// forget stops delivering the status of a payment
+func (p *pendingSettlements) forget(paymentID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.waiting, paymentID)
+}
+
+// resolve delivers the final status of a followed payment
+func (p *pendingSettlements) resolve(status SettlementStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch, ok := p.waiting[status.PaymentID]; ok {
+		select {
+		case ch <- status:
+		default:
+		}
+	}
+}
+
+// settlementStatusNotification returns the status a notifications/x402/settlement carries
+func settlementStatusNotification(notification mcp.JSONRPCNotification) (SettlementStatus, bool) {
+	var status SettlementStatus
+	if notification.Method != MethodSettlementStatus {
+		return status, false
+	}
+	data, err := json.Marshal(notification.Params.AdditionalFields)
+	if err != nil || json.Unmarshal(data, &status) != nil || status.PaymentID == "" {
+		return status, false
+	}
+	return status, status.Status != SettlementStatusPending
+}
+
+// watchSettlement follows a settlement the server reported as pending until
+// it completes, polling its status resource and listening for the server's
+// notification, then reports it to OnSettlementFinalized
+func (t *X402Transport) watchSettlement(ctx context.Context, method string, reqs PaymentRequirementsResponse, settlement SettlementResponse) {
+	if t.onSettlementFinalized == nil || settlement.Status != SettlementStatusPending || settlement.PaymentID == "" {
+		return
+	}
+	paymentID := settlement.PaymentID
+	notified := t.pendingSettlements.watch(paymentID)
+	interval := t.settlementPollInterval
+	if interval <= 0 {
+		interval = defaultSettlementPollInterval
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer t.pendingSettlements.forget(paymentID)
+		pollCtx, cancel := context.WithTimeout(context.Background(), settlementPollTimeout)
+		defer cancel()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case status := <-notified:
+				t.settlementFinalized(ctx, method, reqs, status)
+				return
+			case <-ticker.C:
+			case <-t.closed:
+				return
+			case <-pollCtx.Done():
+				t.logger.Printf("[X402] Settlement of payment %s still pending after %s", paymentID, settlementPollTimeout)
+				return
+			}
+
+			status, err := t.GetSettlementStatus(pollCtx, paymentID)
+			if err != nil {
+				if t.verbose {
+					t.logger.Printf("[X402] Failed to read the settlement status of payment %s: %v", paymentID, err)
+				}
+				continue
+			}
+			if status.Status != SettlementStatusPending {
+				t.settlementFinalized(ctx, method, reqs, *status)
+				return
+			}
+		}
+	}()
+}
+
+// settlementFinalized reports a completed pending settlement, following it on
+// chain when Finality is configured
+func (t *X402Transport) settlementFinalized(ctx context.Context, method string, reqs PaymentRequirementsResponse, status SettlementStatus) {
+	if t.verbose {
+		t.logger.Printf("[X402] Pending payment %s %s", status.PaymentID, status.Status)
+	}
+	t.onSettlementFinalized(status)
+	if status.Status == SettlementStatusSettled {
+		t.watchFinality(ctx, method, reqs, SettlementResponse{
+			Success:     true,
+			Transaction: status.Transaction,
+			Network:     status.Network,
+			Payer:       status.Payer,
+		})
+	}
+}
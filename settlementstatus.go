@@ -0,0 +1,35 @@
+package x402
+
+import "time"
+
+// Settlement statuses, reported in SettlementResponse.Status and SettlementStatus
+const (
+	SettlementStatusPending = "pending" // Verified; the server is still settling it
+	SettlementStatusSettled = "settled"
+	SettlementStatusFailed  = "failed"
+)
+
+// MethodSettlementStatus is the notification a server sends the paying session
+// once a pending settlement completes. Its params are a SettlementStatus.
+const MethodSettlementStatus = "notifications/x402/settlement"
+
+// SettlementStatusURIPrefix prefixes the URI of the resource reporting the status
+// of a pending settlement, x402://settlements/{paymentId}
+const SettlementStatusURIPrefix = "x402://settlements/"
+
+// SettlementStatusURI returns the URI of the status resource of a pending settlement
+func SettlementStatusURI(paymentID string) string {
+	return SettlementStatusURIPrefix + paymentID
+}
+
+// SettlementStatus is the state of a settlement that was still pending when
+// the paid response was sent
+type SettlementStatus struct {
+	PaymentID   string    `json:"paymentId"`
+	Status      string    `json:"status"`
+	Transaction string    `json:"transaction,omitempty"`
+	Network     string    `json:"network"`
+	Payer       string    `json:"payer,omitempty"`
+	ErrorReason string    `json:"errorReason,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
@@ -47,6 +47,8 @@ type PrivateKeySigner struct {
 	address        common.Address
 	paymentOptions []ClientPaymentOption
 	priority       int // Signer priority (lower = higher precedence)
+	slots          signingSlots
+	clock          Clock
 }
 
 // NewPrivateKeySigner creates a signer from a hex-encoded private key with explicit payment options
@@ -100,7 +102,7 @@ func (s *PrivateKeySigner) SupportsNetwork(network string) bool {
 // HasAsset returns true if the signer has the given asset on the network
 func (s *PrivateKeySigner) HasAsset(asset, network string) bool {
 	for _, opt := range s.paymentOptions {
-		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && opt.Scheme == "exact" {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && isRegisteredScheme(opt.Scheme) {
 			return true
 		}
 	}
@@ -118,6 +120,25 @@ func (s *PrivateKeySigner) GetPaymentOption(network, asset string) *ClientPaymen
 	return nil
 }
 
+// PaymentOptions returns the signer's payment options
+func (s *PrivateKeySigner) PaymentOptions() []ClientPaymentOption {
+	return s.paymentOptions
+}
+
+// CheckHealth checks that the signer's key still signs for its address
+func (s *PrivateKeySigner) CheckHealth(ctx context.Context) error {
+	digest := crypto.Keccak256([]byte(healthCheckMessage))
+	signature, err := crypto.Sign(digest, s.privateKey)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSigningFailed, err)
+	}
+	publicKey, err := crypto.SigToPub(digest, signature)
+	if err != nil || crypto.PubkeyToAddress(*publicKey) != s.address {
+		return fmt.Errorf("%w: key does not sign for %s", ErrSigningFailed, s.address.Hex())
+	}
+	return nil
+}
+
 // GetPriority returns the signer's priority (lower value = higher priority)
 func (s *PrivateKeySigner) GetPriority() int {
 	return s.priority
@@ -129,6 +150,23 @@ func (s *PrivateKeySigner) WithPriority(priority int) *PrivateKeySigner {
 	return s
 }
 
+// WithMaxConcurrentSignings bounds how many payments the signer signs at once;
+// further SignPayment calls wait for a free slot. Zero (the default) is unlimited.
+// EVM authorizations use random nonces and need no on-chain nonce, so concurrent
+// payments never conflict; the limit only throttles the wallet.
+// Call it before the signer is used.
+func (s *PrivateKeySigner) WithMaxConcurrentSignings(n int) *PrivateKeySigner {
+	s.slots = newSigningSlots(n)
+	return s
+}
+
+// WithClock sets the clock authorization validity windows are computed from
+// (the system clock by default), so tests can sign at a chosen time
+func (s *PrivateKeySigner) WithClock(clock Clock) *PrivateKeySigner {
+	s.clock = clock
+	return s
+}
+
 // SignPayment signs a payment authorization for the given requirement
 func (s *PrivateKeySigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
 	// Find the matching payment option to get chain ID
@@ -142,16 +180,26 @@ func (s *PrivateKeySigner) SignPayment(ctx context.Context, req PaymentRequireme
 		return nil, fmt.Errorf("chain ID not configured for network %s", req.Network)
 	}
 
-	// Generate nonce
-	nonceBytes := crypto.Keccak256([]byte(fmt.Sprintf("%d-%s-%s",
-		time.Now().UnixNano(), req.Resource, s.address.Hex())))
-	nonce := "0x" + hex.EncodeToString(nonceBytes)
+	release, err := s.slots.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for a signing slot: %w", err)
+	}
+	defer release()
+
+	// Generate nonce, unless the payment must be bound to a specific request
+	nonce, bound := BindingNonceFromContext(ctx)
+	if !bound {
+		nonceBytes := crypto.Keccak256([]byte(fmt.Sprintf("%d-%s-%s",
+			time.Now().UnixNano(), req.Resource, s.address.Hex())))
+		nonce = "0x" + hex.EncodeToString(nonceBytes)
+	}
 
 	// Create time window with configurable buffer for clock skew
 	// Default to 30 seconds in the past to account for larger clock differences
 	// This is more lenient than the original 5 seconds
 	const clockSkewBuffer = 30 * time.Second
-	validAfter := time.Now().Add(-clockSkewBuffer).Unix()
+	now := clockOrSystem(s.clock).Now()
+	validAfter := now.Add(-clockSkewBuffer).Unix()
 
 	// Ensure timeout is reasonable (at least 60 seconds, max 1 hour)
 	timeout := req.MaxTimeoutSeconds
@@ -160,7 +208,7 @@ func (s *PrivateKeySigner) SignPayment(ctx context.Context, req PaymentRequireme
 	} else if timeout > 3600 {
 		timeout = 3600
 	}
-	validBefore := time.Now().Add(time.Duration(timeout) * time.Second).Unix()
+	validBefore := now.Add(time.Duration(timeout) * time.Second).Unix()
 
 	// Create EIP-712 typed data
 
@@ -328,6 +376,12 @@ func (s *MnemonicSigner) WithPriority(priority int) *MnemonicSigner {
 	return s
 }
 
+// WithMaxConcurrentSignings bounds how many payments the signer signs at once
+func (s *MnemonicSigner) WithMaxConcurrentSignings(n int) *MnemonicSigner {
+	s.PrivateKeySigner.WithMaxConcurrentSignings(n)
+	return s
+}
+
 // KeystoreSigner signs with a key from an encrypted keystore file
 type KeystoreSigner struct {
 	*PrivateKeySigner
@@ -372,11 +426,18 @@ func (s *KeystoreSigner) WithPriority(priority int) *KeystoreSigner {
 	return s
 }
 
+// WithMaxConcurrentSignings bounds how many payments the signer signs at once
+func (s *KeystoreSigner) WithMaxConcurrentSignings(n int) *KeystoreSigner {
+	s.PrivateKeySigner.WithMaxConcurrentSignings(n)
+	return s
+}
+
 // MockSigner is a test signer that generates fake signatures
 type MockSigner struct {
 	address        string
 	paymentOptions []ClientPaymentOption
 	priority       int // Signer priority
+	clock          Clock
 }
 
 // NewMockSigner creates a mock signer for testing with explicit payment options
@@ -419,7 +480,7 @@ func (m *MockSigner) SupportsNetwork(network string) bool {
 // HasAsset returns true if the mock signer has the given asset on the network
 func (m *MockSigner) HasAsset(asset, network string) bool {
 	for _, opt := range m.paymentOptions {
-		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && opt.Scheme == "exact" {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && isRegisteredScheme(opt.Scheme) {
 			return true
 		}
 	}
@@ -437,6 +498,11 @@ func (m *MockSigner) GetPaymentOption(network, asset string) *ClientPaymentOptio
 	return nil
 }
 
+// PaymentOptions returns the signer's payment options
+func (m *MockSigner) PaymentOptions() []ClientPaymentOption {
+	return m.paymentOptions
+}
+
 // SignPayment creates a mock payment signature for testing
 func (m *MockSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
 	// Validate amount even in mock signer
@@ -453,14 +519,20 @@ func (m *MockSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*
 
 	// Use same time window logic as real signer
 	const clockSkewBuffer = 30 * time.Second
-	validAfter := time.Now().Add(-clockSkewBuffer).Unix()
+	now := clockOrSystem(m.clock).Now()
+	validAfter := now.Add(-clockSkewBuffer).Unix()
 	timeout := req.MaxTimeoutSeconds
 	if timeout < 60 {
 		timeout = 60
 	} else if timeout > 3600 {
 		timeout = 3600
 	}
-	validBefore := time.Now().Add(time.Duration(timeout) * time.Second).Unix()
+	validBefore := now.Add(time.Duration(timeout) * time.Second).Unix()
+
+	nonce, bound := BindingNonceFromContext(ctx)
+	if !bound {
+		nonce = "0x" + strings.Repeat("11", 32)
+	}
 
 	return &PaymentPayload{
 		X402Version: 1,
@@ -474,7 +546,7 @@ func (m *MockSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*
 				Value:       req.MaxAmountRequired,
 				ValidAfter:  fmt.Sprintf("%d", validAfter),
 				ValidBefore: fmt.Sprintf("%d", validBefore),
-				Nonce:       "0x" + strings.Repeat("11", 32),
+				Nonce:       nonce,
 			},
 		},
 	}, nil
@@ -490,3 +562,9 @@ func (m *MockSigner) WithPriority(priority int) *MockSigner {
 	m.priority = priority
 	return m
 }
+
+// WithClock sets the clock authorization validity windows are computed from
+func (m *MockSigner) WithClock(clock Clock) *MockSigner {
+	m.clock = clock
+	return m
+}
@@ -0,0 +1,485 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// EntryPointV06Address is the canonical ERC-4337 EntryPoint v0.6 deployment
+const EntryPointV06Address = "0x5FF137D4b0FDCD49DcA30c7CF57E578a026d2789"
+
+// SchemeERC4337 is the scheme of payments made as sponsored ERC-4337 user
+// operations by a SmartAccountSigner. Their payload is a UserOperationPayload
+// rather than an EIP-3009 authorization, so servers offer it only with a
+// facilitator able to verify and submit user operations.
+const SchemeERC4337 = "erc4337"
+
+var (
+	// executeSelector is execute(address,uint256,bytes) of SimpleAccount-compatible accounts
+	executeSelector = crypto.Keccak256([]byte("execute(address,uint256,bytes)"))[:4]
+	// transferSelector is the ERC-20 transfer(address,uint256)
+	transferSelector = crypto.Keccak256([]byte("transfer(address,uint256)"))[:4]
+	// getNonceSelector is EntryPoint.getNonce(address,uint192)
+	getNonceSelector = crypto.Keccak256([]byte("getNonce(address,uint192)"))[:4]
+)
+
+// dummyUserOpSignature is a well-formed signature used while the paymaster
+// estimates gas, before the final operation is signed
+var dummyUserOpSignature = "0x" + strings.Repeat("ff", 64) + "1c"
+
+// UserOperation is an ERC-4337 (EntryPoint v0.6) user operation in its JSON-RPC
+// form: numbers and byte strings are 0x-prefixed hex
+type UserOperation struct {
+	Sender               string `json:"sender"`
+	Nonce                string `json:"nonce"`
+	InitCode             string `json:"initCode"`
+	CallData             string `json:"callData"`
+	CallGasLimit         string `json:"callGasLimit"`
+	VerificationGasLimit string `json:"verificationGasLimit"`
+	PreVerificationGas   string `json:"preVerificationGas"`
+	MaxFeePerGas         string `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string `json:"maxPriorityFeePerGas"`
+	PaymasterAndData     string `json:"paymasterAndData"`
+	Signature            string `json:"signature"`
+}
+
+// Hash returns the user operation hash the account owner signs
+func (op *UserOperation) Hash(entryPoint string, chainID *big.Int) ([]byte, error) {
+	var packed []byte
+	words := []struct {
+		value  string
+		hashed bool
+	}{
+		{op.Sender, false},
+		{op.Nonce, false},
+		{op.InitCode, true},
+		{op.CallData, true},
+		{op.CallGasLimit, false},
+		{op.VerificationGasLimit, false},
+		{op.PreVerificationGas, false},
+		{op.MaxFeePerGas, false},
+		{op.MaxPriorityFeePerGas, false},
+		{op.PaymasterAndData, true},
+	}
+	for _, w := range words {
+		raw, err := hexutil.Decode(hexOrZero(w.value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid user operation field %q: %w", w.value, err)
+		}
+		if w.hashed {
+			raw = crypto.Keccak256(raw)
+		}
+		packed = append(packed, common.LeftPadBytes(raw, 32)...)
+	}
+
+	var encoded []byte
+	encoded = append(encoded, crypto.Keccak256(packed)...)
+	encoded = append(encoded, common.LeftPadBytes(common.HexToAddress(entryPoint).Bytes(), 32)...)
+	encoded = append(encoded, common.LeftPadBytes(chainID.Bytes(), 32)...)
+	return crypto.Keccak256(encoded), nil
+}
+
+// hexOrZero maps empty JSON-RPC values to 0x, which hexutil.Decode rejects
+func hexOrZero(value string) string {
+	if value == "" {
+		return "0x"
+	}
+	// Quantities such as 0x0 have an odd number of digits
+	if len(value)%2 == 1 {
+		return "0x0" + strings.TrimPrefix(value, "0x")
+	}
+	return value
+}
+
+// UserOperationPayload is the payment payload of a sponsored user operation.
+// The operation is signed but not submitted: the facilitator submits it to a
+// bundler once the server accepts the payment. UserOpHash is the operation's
+// EntryPoint hash. Nonce is the payment nonce request and resource bindings
+// commit to; the operation's nonce key is derived from it, so the owner's
+// signature covers it.
+type UserOperationPayload struct {
+	UserOpHash    string         `json:"userOpHash"`
+	EntryPoint    string         `json:"entryPoint"`
+	Nonce         string         `json:"nonce"`
+	UserOperation *UserOperation `json:"userOperation"`
+}
+
+// userOperationNonceKey derives the EntryPoint nonce key of an operation from
+// its 32-byte payment nonce
+func userOperationNonceKey(nonce []byte) []byte {
+	return crypto.Keccak256(nonce)[:24]
+}
+
+// userOperationNonce returns the payment nonce of a decoded user operation
+// payload, checking the operation's nonce key commits to it
+func userOperationNonce(payload map[string]any) (string, error) {
+	nonce, _ := payload["nonce"].(string)
+	raw, err := hexutil.Decode(nonce)
+	if err != nil || len(raw) != 32 {
+		return "", fmt.Errorf("missing user operation payment nonce")
+	}
+	op, _ := payload["userOperation"].(map[string]any)
+	opNonce, _ := op["nonce"].(string)
+	value, err := hexutil.DecodeBig(opNonce)
+	if err != nil {
+		return "", fmt.Errorf("invalid user operation nonce %q", opNonce)
+	}
+	if new(big.Int).Rsh(value, 64).Cmp(new(big.Int).SetBytes(userOperationNonceKey(raw))) != 0 {
+		return "", fmt.Errorf("user operation nonce key does not commit to the payment nonce")
+	}
+	return nonce, nil
+}
+
+// SmartAccountConfig configures a SmartAccountSigner
+type SmartAccountConfig struct {
+	// Account is the address of the (deployed) smart account paying; it must
+	// support SimpleAccount's execute(address,uint256,bytes)
+	Account string
+
+	// BundlerURL is the bundler JSON-RPC endpoint; it also serves eth_call and
+	// eth_gasPrice for the network
+	BundlerURL string
+
+	// PaymasterURL is the endpoint serving pm_sponsorUserOperation. Defaults to BundlerURL.
+	PaymasterURL string
+
+	// PaymasterContext is passed to pm_sponsorUserOperation, e.g. a sponsorship policy id
+	PaymasterContext map[string]any
+
+	// EntryPoint defaults to EntryPointV06Address
+	EntryPoint string
+
+	// HTTPClient is used for bundler and paymaster requests (30s timeout when nil)
+	HTTPClient *http.Client
+}
+
+// SmartAccountSigner pays from an ERC-4337 smart account with gas sponsored by a
+// paymaster, so accounts without native gas can still pay in USDC. Each payment
+// is a UserOperation calling token.transfer(payTo, amount) through the account;
+// it is sponsored and signed by the account owner, and sent to the server, whose
+// facilitator verifies and submits it. No funds move until then. Each operation
+// uses a nonce key of its own, so concurrent payments don't collide. The signer
+// only pays requirements of SchemeERC4337.
+type SmartAccountSigner struct {
+	owner          *ecdsa.PrivateKey
+	config         SmartAccountConfig
+	account        common.Address
+	httpClient     *http.Client
+	paymentOptions []ClientPaymentOption
+	priority       int
+	rpcID          atomic.Int64
+}
+
+// NewSmartAccountSigner creates a smart account signer from the hex-encoded owner
+// key with explicit payment options, whose scheme becomes SchemeERC4337
+func NewSmartAccountSigner(ownerKeyHex string, config SmartAccountConfig, options ...ClientPaymentOption) (*SmartAccountSigner, error) {
+	owner, err := crypto.HexToECDSA(strings.TrimPrefix(ownerKeyHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+
+	if !common.IsHexAddress(config.Account) {
+		return nil, fmt.Errorf("invalid smart account address: %q", config.Account)
+	}
+	if config.BundlerURL == "" {
+		return nil, fmt.Errorf("bundler URL must be configured")
+	}
+	if config.PaymasterURL == "" {
+		config.PaymasterURL = config.BundlerURL
+	}
+	if config.EntryPoint == "" {
+		config.EntryPoint = EntryPointV06Address
+	}
+
+	if len(options) == 0 {
+		return nil, fmt.Errorf("at least one payment option must be configured")
+	}
+
+	options = slices.Clone(options)
+	for i := range options {
+		options[i].Scheme = SchemeERC4337
+	}
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Priority < options[j].Priority
+	})
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &SmartAccountSigner{
+		owner:          owner,
+		config:         config,
+		account:        common.HexToAddress(config.Account),
+		httpClient:     httpClient,
+		paymentOptions: options,
+	}, nil
+}
+
+// GetAddress returns the smart account address, which is the payer
+func (s *SmartAccountSigner) GetAddress() string {
+	return s.account.Hex()
+}
+
+// SupportsNetwork returns true if the signer supports the given network
+func (s *SmartAccountSigner) SupportsNetwork(network string) bool {
+	for _, opt := range s.paymentOptions {
+		if opt.Network == network {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAsset returns true if the signer has the given asset on the network
+func (s *SmartAccountSigner) HasAsset(asset, network string) bool {
+	for _, opt := range s.paymentOptions {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPaymentOption returns the client payment option that matches the network and asset
+func (s *SmartAccountSigner) GetPaymentOption(network, asset string) *ClientPaymentOption {
+	for _, opt := range s.paymentOptions {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) {
+			optCopy := opt
+			return &optCopy
+		}
+	}
+	return nil
+}
+
+// PaymentOptions returns the signer's payment options
+func (s *SmartAccountSigner) PaymentOptions() []ClientPaymentOption {
+	return s.paymentOptions
+}
+
+// GetPriority returns the signer's priority (lower = higher precedence)
+func (s *SmartAccountSigner) GetPriority() int {
+	return s.priority
+}
+
+// WithPriority sets the signer's priority for multi-signer configurations
+func (s *SmartAccountSigner) WithPriority(priority int) *SmartAccountSigner {
+	s.priority = priority
+	return s
+}
+
+// SignPayment builds, sponsors and signs a user operation transferring the
+// required amount, and returns it as the payment payload without submitting it
+func (s *SmartAccountSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
+	if req.Scheme != SchemeERC4337 {
+		return nil, fmt.Errorf("smart account signer only pays scheme %s, not %s", SchemeERC4337, req.Scheme)
+	}
+	option := s.GetPaymentOption(req.Network, req.Asset)
+	if option == nil {
+		return nil, fmt.Errorf("no payment option configured for network %s and asset %s", req.Network, req.Asset)
+	}
+	if option.ChainID == nil {
+		return nil, fmt.Errorf("chain ID not configured for network %s", req.Network)
+	}
+
+	value := new(big.Int)
+	if _, ok := value.SetString(req.MaxAmountRequired, 10); !ok {
+		return nil, fmt.Errorf("invalid payment amount: %s", req.MaxAmountRequired)
+	}
+	if value.Sign() <= 0 {
+		return nil, fmt.Errorf("payment amount must be positive: %s", req.MaxAmountRequired)
+	}
+	if !common.IsHexAddress(req.PayTo) {
+		return nil, fmt.Errorf("invalid recipient address: %s", req.PayTo)
+	}
+
+	// The payment nonce is the binding nonce, if any, or random. The nonce key
+	// derived from it keeps this operation's nonce apart from concurrent payments.
+	var paymentNonce []byte
+	if bindingNonce, bound := BindingNonceFromContext(ctx); bound {
+		decoded, err := hexutil.Decode(bindingNonce)
+		if err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("invalid binding nonce %q", bindingNonce)
+		}
+		paymentNonce = decoded
+	} else {
+		paymentNonce = make([]byte, 32)
+		if _, err := rand.Read(paymentNonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+	}
+
+	nonce, err := s.accountNonce(ctx, userOperationNonceKey(paymentNonce))
+	if err != nil {
+		return nil, err
+	}
+
+	var gasPrice string
+	if err := s.call(ctx, s.config.BundlerURL, "eth_gasPrice", []any{}, &gasPrice); err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %w", err)
+	}
+
+	op := &UserOperation{
+		Sender:               s.account.Hex(),
+		Nonce:                nonce,
+		InitCode:             "0x",
+		CallData:             hexutil.Encode(transferCallData(common.HexToAddress(req.Asset), common.HexToAddress(req.PayTo), value)),
+		MaxFeePerGas:         gasPrice,
+		MaxPriorityFeePerGas: gasPrice,
+		PaymasterAndData:     "0x",
+		Signature:            dummyUserOpSignature,
+	}
+
+	if err := s.sponsor(ctx, op); err != nil {
+		return nil, err
+	}
+
+	hash, err := op.Hash(s.config.EntryPoint, option.ChainID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSigningFailed, err)
+	}
+	// SimpleAccount validates an EIP-191 signature of the operation hash
+	signature, err := crypto.Sign(accounts.TextHash(hash), s.owner)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSigningFailed, err)
+	}
+	signature[64] += 27
+	op.Signature = "0x" + hex.EncodeToString(signature)
+
+	return &PaymentPayload{
+		X402Version: 1,
+		Scheme:      req.Scheme,
+		Network:     req.Network,
+		Payload: UserOperationPayload{
+			UserOpHash:    hexutil.Encode(hash),
+			EntryPoint:    s.config.EntryPoint,
+			Nonce:         hexutil.Encode(paymentNonce),
+			UserOperation: op,
+		},
+	}, nil
+}
+
+// accountNonce reads the account's next nonce under key from the EntryPoint.
+// The nonce carries the key in its upper 192 bits.
+func (s *SmartAccountSigner) accountNonce(ctx context.Context, key []byte) (string, error) {
+	data := append([]byte{}, getNonceSelector...)
+	data = append(data, common.LeftPadBytes(s.account.Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(key, 32)...)
+
+	call := map[string]string{
+		"to":   s.config.EntryPoint,
+		"data": hexutil.Encode(data),
+	}
+	var result string
+	if err := s.call(ctx, s.config.BundlerURL, "eth_call", []any{call, "latest"}, &result); err != nil {
+		return "", fmt.Errorf("failed to get account nonce: %w", err)
+	}
+
+	raw, err := hexutil.Decode(hexOrZero(result))
+	if err != nil {
+		return "", fmt.Errorf("invalid account nonce %q: %w", result, err)
+	}
+	return hexutil.EncodeBig(new(big.Int).SetBytes(raw)), nil
+}
+
+// sponsor asks the paymaster to pay for op's gas and fills in its gas limits
+// and paymasterAndData
+func (s *SmartAccountSigner) sponsor(ctx context.Context, op *UserOperation) error {
+	params := []any{op, s.config.EntryPoint}
+	if s.config.PaymasterContext != nil {
+		params = append(params, s.config.PaymasterContext)
+	}
+
+	var sponsored struct {
+		PaymasterAndData     string `json:"paymasterAndData"`
+		CallGasLimit         string `json:"callGasLimit"`
+		VerificationGasLimit string `json:"verificationGasLimit"`
+		PreVerificationGas   string `json:"preVerificationGas"`
+	}
+	if err := s.call(ctx, s.config.PaymasterURL, "pm_sponsorUserOperation", params, &sponsored); err != nil {
+		return fmt.Errorf("%w: %v", ErrSponsorshipFailed, err)
+	}
+	if sponsored.PaymasterAndData == "" || sponsored.PaymasterAndData == "0x" {
+		return fmt.Errorf("%w: paymaster returned no paymasterAndData", ErrSponsorshipFailed)
+	}
+
+	op.PaymasterAndData = sponsored.PaymasterAndData
+	op.CallGasLimit = sponsored.CallGasLimit
+	op.VerificationGasLimit = sponsored.VerificationGasLimit
+	op.PreVerificationGas = sponsored.PreVerificationGas
+	return nil
+}
+
+// call performs a JSON-RPC request against url and decodes its result
+func (s *SmartAccountSigner) call(ctx context.Context, url, method string, params []any, result any) error {
+	body, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      s.rpcID.Add(1),
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return fmt.Errorf("%s: invalid response (HTTP %d): %w", method, resp.StatusCode, err)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s: %s (code %d)", method, rpcResp.Error.Message, rpcResp.Error.Code)
+	}
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// transferCallData encodes account.execute(token, 0, token.transfer(to, value))
+func transferCallData(token, to common.Address, value *big.Int) []byte {
+	transfer := append([]byte{}, transferSelector...)
+	transfer = append(transfer, common.LeftPadBytes(to.Bytes(), 32)...)
+	transfer = append(transfer, common.LeftPadBytes(value.Bytes(), 32)...)
+
+	data := append([]byte{}, executeSelector...)
+	data = append(data, common.LeftPadBytes(token.Bytes(), 32)...)
+	data = append(data, make([]byte, 32)...) // value: 0
+	data = append(data, common.LeftPadBytes(big.NewInt(96).Bytes(), 32)...)
+	data = append(data, common.LeftPadBytes(big.NewInt(int64(len(transfer))).Bytes(), 32)...)
+	padded := make([]byte, (len(transfer)+31)/32*32)
+	copy(padded, transfer)
+	return append(data, padded...)
+}
@@ -3,21 +3,33 @@ package x402
 import (
 	"context"
 	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"math/big"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
 	"github.com/gagliardetto/solana-go/programs/token"
 	"github.com/gagliardetto/solana-go/rpc"
 )
 
+// blockhashRetries bounds how often SignPayment waits for a newer blockhash
+// when the transaction it built was already signed for another payment
+const blockhashRetries = 10
+
+// blockhashRetryDelay is roughly one Solana slot
+const blockhashRetryDelay = 400 * time.Millisecond
+
 type SolanaPrivateKeySigner struct {
 	privateKey     solana.PrivateKey
 	publicKey      solana.PublicKey
 	paymentOptions []ClientPaymentOption
 	priority       int
+	slots          signingSlots
+	signed         recentMessages
 }
 
 // NewSolanaPrivateKeySigner creates a signer from a base58-encoded Solana private key with explicit payment options
@@ -62,7 +74,7 @@ func (s *SolanaPrivateKeySigner) SupportsNetwork(network string) bool {
 // HasAsset returns true if the signer has the given asset on the network
 func (s *SolanaPrivateKeySigner) HasAsset(asset, network string) bool {
 	for _, opt := range s.paymentOptions {
-		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && opt.Scheme == "exact" {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && isRegisteredScheme(opt.Scheme) {
 			return true
 		}
 	}
@@ -80,6 +92,24 @@ func (s *SolanaPrivateKeySigner) GetPaymentOption(network, asset string) *Client
 	return nil
 }
 
+// PaymentOptions returns the signer's payment options
+func (s *SolanaPrivateKeySigner) PaymentOptions() []ClientPaymentOption {
+	return s.paymentOptions
+}
+
+// CheckHealth checks that the signer's key still signs for its address
+func (s *SolanaPrivateKeySigner) CheckHealth(ctx context.Context) error {
+	message := []byte(healthCheckMessage)
+	signature, err := s.privateKey.Sign(message)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrSigningFailed, err)
+	}
+	if !signature.Verify(s.publicKey, message) {
+		return fmt.Errorf("%w: key does not sign for %s", ErrSigningFailed, s.publicKey)
+	}
+	return nil
+}
+
 // GetPriority returns the signer's priority (lower = higher precedence)
 func (s *SolanaPrivateKeySigner) GetPriority() int {
 	return s.priority
@@ -91,6 +121,24 @@ func (s *SolanaPrivateKeySigner) WithPriority(priority int) *SolanaPrivateKeySig
 	return s
 }
 
+// WithClock sets the clock recently signed transactions are remembered by (the
+// system clock by default)
+func (s *SolanaPrivateKeySigner) WithClock(clock Clock) *SolanaPrivateKeySigner {
+	s.signed.clock = clock
+	return s
+}
+
+// WithMaxConcurrentSignings bounds how many payments the signer builds at once;
+// further SignPayment calls wait for a free slot. 1 serializes signing, which keeps
+// heavily parallel agents from fetching blockhashes in bursts. Zero (the default)
+// is unlimited. Regardless of this setting, the signer never signs the same
+// transaction twice: a payment identical to a recent one waits for a newer blockhash.
+// Call it before the signer is used.
+func (s *SolanaPrivateKeySigner) WithMaxConcurrentSignings(n int) *SolanaPrivateKeySigner {
+	s.slots = newSigningSlots(n)
+	return s
+}
+
 // SignPayment signs a payment authorization for the given requirement
 func (s *SolanaPrivateKeySigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
 	option := s.GetPaymentOption(req.Network, req.Asset)
@@ -98,56 +146,69 @@ func (s *SolanaPrivateKeySigner) SignPayment(ctx context.Context, req PaymentReq
 		return nil, fmt.Errorf("no payment option for network=%s asset=%s", req.Network, req.Asset)
 	}
 
-	var rpcURL string
-	switch option.NetworkID {
-	case "mainnet-beta":
-		rpcURL = rpc.MainNetBeta_RPC
-	case "devnet":
-		rpcURL = rpc.DevNet_RPC
-	default:
-		return nil, fmt.Errorf("unsupported network: %s", option.NetworkID)
+	release, err := s.slots.acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for a signing slot: %w", err)
 	}
-	client := rpc.New(rpcURL)
+	defer release()
 
-	recent, err := client.GetLatestBlockhash(ctx, rpc.CommitmentFinalized)
+	rpcURL, err := solanaRPCURL(option.NetworkID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get blockhash from %s: %w", rpcURL, err)
+		return nil, err
 	}
 
-	mintAddr, err := solana.PublicKeyFromBase58(req.Asset)
+	tx, err := buildSolanaPayment(ctx, rpc.New(rpcURL), s.publicKey, req, &s.signed)
 	if err != nil {
-		return nil, fmt.Errorf("invalid mint address: %w", err)
+		return nil, err
 	}
 
-	toAddr, err := solana.PublicKeyFromBase58(req.PayTo)
+	_, err = tx.PartialSign(func(key solana.PublicKey) *solana.PrivateKey {
+		if s.publicKey.Equals(key) {
+			return &s.privateKey
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("invalid recipient address: %w", err)
+		return nil, fmt.Errorf("failed to partially sign transaction: %w", err)
 	}
 
-	feePayerAddr, err := solana.PublicKeyFromBase58(req.Extra["feePayer"])
-	if err != nil {
-		return nil, fmt.Errorf("invalid fee payer address: %w", err)
+	return solanaPaymentPayload(req, tx)
+}
+
+// solanaRPCURL returns the public RPC endpoint of a Solana network ID
+func solanaRPCURL(networkID string) (string, error) {
+	switch networkID {
+	case "mainnet-beta":
+		return rpc.MainNetBeta_RPC, nil
+	case "devnet":
+		return rpc.DevNet_RPC, nil
+	default:
+		return "", fmt.Errorf("unsupported network: %s", networkID)
 	}
+}
 
-	fromATA, _, err := solana.FindAssociatedTokenAddress(s.publicKey, mintAddr)
+// buildSolanaPayment builds the unsigned transaction paying req from owner on a
+// recent blockhash. It waits for a newer blockhash when signed already holds an
+// identical transaction.
+func buildSolanaPayment(ctx context.Context, client *rpc.Client, owner solana.PublicKey, req PaymentRequirement, signed *recentMessages) (*solana.Transaction, error) {
+	toAddr, err := solana.PublicKeyFromBase58(req.PayTo)
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive sender ATA: %w", err)
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
 	}
 
-	toATA, _, err := solana.FindAssociatedTokenAddress(toAddr, mintAddr)
+	feePayerAddr, err := solana.PublicKeyFromBase58(req.Extra["feePayer"])
 	if err != nil {
-		return nil, fmt.Errorf("failed to derive recipient ATA: %w", err)
+		return nil, fmt.Errorf("invalid fee payer address: %w", err)
 	}
 
 	amount := new(big.Int)
-	if _, ok := amount.SetString(req.MaxAmountRequired, 10); !ok {
+	if _, ok := amount.SetString(req.MaxAmountRequired, 10); !ok || !amount.IsUint64() {
 		return nil, fmt.Errorf("invalid amount: %s", req.MaxAmountRequired)
 	}
 
-	// Get decimals from requirement
-	decimals := uint8(6) // Default USDC decimals
-	if decStr, ok := req.Extra["decimals"]; ok {
-		_, _ = fmt.Sscanf(decStr, "%d", &decimals)
+	transferInst, err := solanaTransferInstruction(owner, req, toAddr, amount.Uint64())
+	if err != nil {
+		return nil, err
 	}
 
 	var instructions []solana.Instruction
@@ -161,44 +222,66 @@ func (s *SolanaPrivateKeySigner) SignPayment(ctx context.Context, req PaymentReq
 	)
 	instructions = append(instructions, computeLimitInst)
 
-	// Instruction 1: SetComputeUnitPrice
+	// Instruction 1: SetComputeUnitPrice, 10,000 microlamports unless escalated
+	computePrice := make([]byte, 9)
+	computePrice[0] = 3
+	binary.LittleEndian.PutUint64(computePrice[1:], ComputeUnitPriceFromContext(ctx))
 	computePriceInst := solana.NewInstruction(
 		solana.MustPublicKeyFromBase58("ComputeBudget111111111111111111111111111111"),
 		solana.AccountMetaSlice{},
-		[]byte{3, 0x10, 0x27, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}, // SetComputeUnitPrice: 10,000 microlamports
+		computePrice,
 	)
 	instructions = append(instructions, computePriceInst)
 
-	// Instruction 2: Create TransferChecked instruction - includes mint and decimals for verification
-	transferInst := token.NewTransferCheckedInstructionBuilder().
-		SetAmount(amount.Uint64()).
-		SetDecimals(decimals).
-		SetSourceAccount(fromATA).
-		SetDestinationAccount(toATA).
-		SetMintAccount(mintAddr).
-		SetOwnerAccount(s.publicKey).
-		Build()
+	// Instruction 2: the transfer itself
 	instructions = append(instructions, transferInst)
 
-	tx, err := solana.NewTransaction(
-		instructions,
-		recent.Value.Blockhash,
-		solana.TransactionPayer(feePayerAddr),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build transaction: %w", err)
+	// Optional: memo instruction carrying the application's payment reference
+	if reference := PaymentReferenceFromContext(ctx); reference != "" {
+		instructions = append(instructions, solana.NewInstruction(
+			solana.MemoProgramID,
+			solana.AccountMetaSlice{},
+			[]byte(reference),
+		))
 	}
 
-	_, err = tx.PartialSign(func(key solana.PublicKey) *solana.PrivateKey {
-		if s.publicKey.Equals(key) {
-			return &s.privateKey
+	for attempt := 0; ; attempt++ {
+		recent, err := client.GetLatestBlockhash(ctx, rpc.CommitmentFinalized)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blockhash: %w", err)
+		}
+
+		tx, err := solana.NewTransaction(
+			instructions,
+			recent.Value.Blockhash,
+			solana.TransactionPayer(feePayerAddr),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build transaction: %w", err)
+		}
+
+		message, err := tx.Message.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize transaction message: %w", err)
+		}
+		if signed.claim(message, clockOrSystem(signed.clock).Now()) {
+			return tx, nil
+		}
+
+		// An identical payment was just signed on this blockhash; wait for the next one
+		if attempt == blockhashRetries {
+			return nil, fmt.Errorf("no fresh blockhash for a duplicate payment after %d attempts", attempt+1)
+		}
+		select {
+		case <-time.After(blockhashRetryDelay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
-		return nil
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to partially sign transaction: %w", err)
 	}
+}
 
+// solanaPaymentPayload wraps a signed payment transaction in a payload
+func solanaPaymentPayload(req PaymentRequirement, tx *solana.Transaction) (*PaymentPayload, error) {
 	txBytes, err := tx.MarshalBinary()
 	if err != nil {
 		return nil, fmt.Errorf("failed to serialize transaction: %w", err)
@@ -278,7 +361,7 @@ func (m *MockSolanaSigner) SupportsNetwork(network string) bool {
 // HasAsset returns true if the mock signer has the given asset on the network
 func (m *MockSolanaSigner) HasAsset(asset, network string) bool {
 	for _, opt := range m.paymentOptions {
-		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && opt.Scheme == "exact" {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && isRegisteredScheme(opt.Scheme) {
 			return true
 		}
 	}
@@ -296,6 +379,11 @@ func (m *MockSolanaSigner) GetPaymentOption(network, asset string) *ClientPaymen
 	return nil
 }
 
+// PaymentOptions returns the signer's payment options
+func (m *MockSolanaSigner) PaymentOptions() []ClientPaymentOption {
+	return m.paymentOptions
+}
+
 // SignPayment creates a mock payment signature for testing
 func (m *MockSolanaSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
 	value := new(big.Int)
@@ -328,3 +416,47 @@ func (m *MockSolanaSigner) WithPriority(priority int) *MockSolanaSigner {
 	m.priority = priority
 	return m
 }
+
+// transferInstruction builds the signer's payment transfer
+func (s *SolanaPrivateKeySigner) transferInstruction(req PaymentRequirement, toAddr solana.PublicKey, amount uint64) (solana.Instruction, error) {
+	return solanaTransferInstruction(s.publicKey, req, toAddr, amount)
+}
+
+// solanaTransferInstruction builds the payment transfer: a SystemProgram transfer
+// for native SOL, otherwise an SPL TransferChecked between associated token
+// accounts, which includes the mint and decimals for verification
+func solanaTransferInstruction(owner solana.PublicKey, req PaymentRequirement, toAddr solana.PublicKey, amount uint64) (solana.Instruction, error) {
+	if req.Asset == SOLNative {
+		return system.NewTransferInstruction(amount, owner, toAddr).Build(), nil
+	}
+
+	mintAddr, err := solana.PublicKeyFromBase58(req.Asset)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mint address: %w", err)
+	}
+
+	fromATA, _, err := solana.FindAssociatedTokenAddress(owner, mintAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive sender ATA: %w", err)
+	}
+
+	toATA, _, err := solana.FindAssociatedTokenAddress(toAddr, mintAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive recipient ATA: %w", err)
+	}
+
+	// Get decimals from requirement
+	decimals := uint8(6) // Default USDC decimals
+	if decStr, ok := req.Extra["decimals"]; ok {
+		_, _ = fmt.Sscanf(decStr, "%d", &decimals)
+	}
+
+	return token.NewTransferCheckedInstructionBuilder().
+		SetAmount(amount).
+		SetDecimals(decimals).
+		SetSourceAccount(fromATA).
+		SetDestinationAccount(toATA).
+		SetMintAccount(mintAddr).
+		SetOwnerAccount(owner).
+		Build(), nil
+}
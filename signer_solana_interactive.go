@@ -0,0 +1,202 @@
+package x402
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// SolanaSigningRequest is an unsigned Solana payment awaiting a wallet's approval
+type SolanaSigningRequest struct {
+	Requirement PaymentRequirement `json:"requirement"`
+	Address     string             `json:"address"`     // Wallet expected to sign
+	Transaction string             `json:"transaction"` // Unsigned transaction, base64-encoded
+}
+
+// SolanaApprovalFunc hands an unsigned payment to a wallet, e.g. a web UI
+// calling Phantom's signTransaction, and returns the transaction carrying the
+// wallet's signature, base64-encoded. Returning an error declines the payment.
+type SolanaApprovalFunc func(ctx context.Context, request SolanaSigningRequest) (string, error)
+
+// SolanaInteractiveSigner pays from a wallet it has no key for: each payment is
+// built unsigned and handed to an approval callback, typically forwarded to a
+// browser wallet for a human to approve. The signed transaction must be the one
+// built, signed by the wallet's address.
+type SolanaInteractiveSigner struct {
+	publicKey      solana.PublicKey
+	approve        SolanaApprovalFunc
+	paymentOptions []ClientPaymentOption
+	priority       int
+	rpcURL         string
+	signed         recentMessages
+}
+
+// NewSolanaInteractiveSigner creates a signer paying from the wallet at address,
+// with approve collecting the wallet's signatures, with explicit payment options
+func NewSolanaInteractiveSigner(address string, approve SolanaApprovalFunc, options ...ClientPaymentOption) (*SolanaInteractiveSigner, error) {
+	publicKey, err := solana.PublicKeyFromBase58(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet address: %w", err)
+	}
+	if approve == nil {
+		return nil, fmt.Errorf("an approval callback must be configured")
+	}
+	if len(options) == 0 {
+		return nil, fmt.Errorf("at least one payment option must be configured")
+	}
+
+	sort.Slice(options, func(i, j int) bool {
+		return options[i].Priority < options[j].Priority
+	})
+
+	return &SolanaInteractiveSigner{
+		publicKey:      publicKey,
+		approve:        approve,
+		paymentOptions: options,
+	}, nil
+}
+
+// GetAddress returns the wallet's Solana address
+func (s *SolanaInteractiveSigner) GetAddress() string {
+	return s.publicKey.String()
+}
+
+// SupportsNetwork returns true if the signer supports the given network
+func (s *SolanaInteractiveSigner) SupportsNetwork(network string) bool {
+	for _, opt := range s.paymentOptions {
+		if opt.Network == network {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAsset returns true if the signer has the given asset on the network
+func (s *SolanaInteractiveSigner) HasAsset(asset, network string) bool {
+	for _, opt := range s.paymentOptions {
+		if opt.Network == network && strings.EqualFold(opt.Asset, asset) && isRegisteredScheme(opt.Scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPaymentOption returns the client payment option that matches the network and asset
+func (s *SolanaInteractiveSigner) GetPaymentOption(network, asset string) *ClientPaymentOption {
+	for _, opt := range s.paymentOptions {
+		if opt.Network == network && opt.Asset == asset {
+			optCopy := opt
+			return &optCopy
+		}
+	}
+	return nil
+}
+
+// PaymentOptions returns the signer's payment options
+func (s *SolanaInteractiveSigner) PaymentOptions() []ClientPaymentOption {
+	return s.paymentOptions
+}
+
+// GetPriority returns the signer's priority (lower = higher precedence)
+func (s *SolanaInteractiveSigner) GetPriority() int {
+	return s.priority
+}
+
+// WithPriority sets the signer's priority for multi-signer configurations
+func (s *SolanaInteractiveSigner) WithPriority(priority int) *SolanaInteractiveSigner {
+	s.priority = priority
+	return s
+}
+
+// WithRPCURL sets the RPC endpoint blockhashes are fetched from, instead of the
+// public endpoint of the payment option's network
+func (s *SolanaInteractiveSigner) WithRPCURL(url string) *SolanaInteractiveSigner {
+	s.rpcURL = url
+	return s
+}
+
+// WithClock sets the clock recently signed transactions are remembered by (the
+// system clock by default)
+func (s *SolanaInteractiveSigner) WithClock(clock Clock) *SolanaInteractiveSigner {
+	s.signed.clock = clock
+	return s
+}
+
+// SignPayment builds the payment for the given requirement and waits for the
+// approval callback to return it signed
+func (s *SolanaInteractiveSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
+	option := s.GetPaymentOption(req.Network, req.Asset)
+	if option == nil {
+		return nil, fmt.Errorf("no payment option for network=%s asset=%s", req.Network, req.Asset)
+	}
+
+	rpcURL := s.rpcURL
+	if rpcURL == "" {
+		var err error
+		if rpcURL, err = solanaRPCURL(option.NetworkID); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := buildSolanaPayment(ctx, rpc.New(rpcURL), s.publicKey, req, &s.signed)
+	if err != nil {
+		return nil, err
+	}
+	message, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize transaction message: %w", err)
+	}
+
+	// Leave every signature empty; the fee payer signs on settlement
+	if _, err := tx.PartialSign(func(solana.PublicKey) *solana.PrivateKey { return nil }); err != nil {
+		return nil, fmt.Errorf("failed to prepare transaction: %w", err)
+	}
+	unsigned, err := tx.ToBase64()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+
+	signedBase64, err := s.approve(ctx, SolanaSigningRequest{
+		Requirement: req,
+		Address:     s.publicKey.String(),
+		Transaction: unsigned,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("wallet did not approve payment: %w", err)
+	}
+
+	signed, err := solana.TransactionFromBase64(signedBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signed transaction: %w", err)
+	}
+	if err := s.verifySigned(signed, message); err != nil {
+		return nil, err
+	}
+	return solanaPaymentPayload(req, signed)
+}
+
+// verifySigned checks the wallet returned the transaction built, with its signature
+func (s *SolanaInteractiveSigner) verifySigned(tx *solana.Transaction, message []byte) error {
+	signedMessage, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("invalid signed transaction: %w", err)
+	}
+	if !bytes.Equal(signedMessage, message) {
+		return fmt.Errorf("wallet returned a different transaction than the payment built")
+	}
+	for i, key := range tx.Message.AccountKeys[:tx.Message.Header.NumRequiredSignatures] {
+		if !key.Equals(s.publicKey) {
+			continue
+		}
+		if i >= len(tx.Signatures) || !tx.Signatures[i].Verify(s.publicKey, message) {
+			return fmt.Errorf("transaction is not signed by wallet %s", s.publicKey)
+		}
+		return nil
+	}
+	return fmt.Errorf("wallet %s is not a signer of the transaction", s.publicKey)
+}
@@ -2,9 +2,21 @@ package x402
 
 import (
 	"context"
+	"encoding/json"
 	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -186,3 +198,410 @@ func TestKeystoreSignerChainID(t *testing.T) {
 		assert.Equal(t, big.NewInt(8453), option.ChainID)
 	})
 }
+
+func TestMaxConcurrentSignings(t *testing.T) {
+	t.Run("WaitsForFreeSlot", func(t *testing.T) {
+		signer, err := NewPrivateKeySigner(
+			"0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+			AcceptUSDCBase(),
+		)
+		require.NoError(t, err)
+		signer.WithMaxConcurrentSignings(1)
+
+		req := PaymentRequirement{
+			Scheme:            "exact",
+			Network:           "base",
+			Asset:             USDCAddressBase,
+			PayTo:             "0x742d35Cc6634C0532925a3b844Bc9e7595f0bEb6",
+			MaxAmountRequired: "1000",
+			MaxTimeoutSeconds: 60,
+			Extra: map[string]string{
+				"name":    "USD Coin",
+				"version": "2",
+			},
+		}
+
+		// Hold the only slot, as a signing in progress would
+		release, err := signer.slots.acquire(context.Background())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = signer.SignPayment(ctx, req)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		release()
+		_, err = signer.SignPayment(context.Background(), req)
+		assert.NoError(t, err)
+	})
+
+	t.Run("UnlimitedByDefault", func(t *testing.T) {
+		slots := newSigningSlots(0)
+		for i := 0; i < 100; i++ {
+			_, err := slots.acquire(context.Background())
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("DuplicateSolanaMessagesRejected", func(t *testing.T) {
+		var signed recentMessages
+		now := time.Now()
+
+		assert.True(t, signed.claim([]byte("transfer on blockhash A"), now))
+		assert.False(t, signed.claim([]byte("transfer on blockhash A"), now))
+		assert.True(t, signed.claim([]byte("transfer on blockhash B"), now))
+
+		// Forgotten once the blockhash has long expired
+		assert.True(t, signed.claim([]byte("transfer on blockhash A"), now.Add(recentMessageTTL+time.Second)))
+	})
+}
+
+func TestSolanaTransferInstruction(t *testing.T) {
+	key, err := solana.NewRandomPrivateKey()
+	require.NoError(t, err)
+	signer, err := NewSolanaPrivateKeySigner(key.String(), AcceptSOLDevnet(), AcceptUSDCSolanaDevnet())
+	require.NoError(t, err)
+	recipient := solana.NewWallet().PublicKey()
+
+	t.Run("NativeSOL", func(t *testing.T) {
+		req := AcceptSOLDevnet().PaymentRequirement
+		assert.Equal(t, "9", req.Extra["decimals"])
+
+		inst, err := signer.transferInstruction(req, recipient, 1_500_000)
+		require.NoError(t, err)
+		assert.Equal(t, solana.SystemProgramID, inst.ProgramID())
+
+		accounts := inst.Accounts()
+		require.Len(t, accounts, 2)
+		assert.Equal(t, key.PublicKey(), accounts[0].PublicKey)
+		assert.True(t, accounts[0].IsSigner)
+		assert.Equal(t, recipient, accounts[1].PublicKey)
+
+		// Transfer is instruction 2, followed by the lamports as a little-endian u64
+		data, err := inst.Data()
+		require.NoError(t, err)
+		assert.Equal(t, []byte{2, 0, 0, 0, 0x60, 0xe3, 0x16, 0, 0, 0, 0, 0}, data)
+	})
+
+	t.Run("SPLToken", func(t *testing.T) {
+		inst, err := signer.transferInstruction(AcceptUSDCSolanaDevnet().PaymentRequirement, recipient, 1000)
+		require.NoError(t, err)
+		assert.Equal(t, solana.TokenProgramID, inst.ProgramID())
+	})
+}
+
+func TestSolanaInteractiveSigner(t *testing.T) {
+	rpcServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID any `json:"id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"jsonrpc": "2.0",
+			"id":      req.ID,
+			"result": map[string]any{
+				"context": map[string]any{"slot": 1},
+				"value": map[string]any{
+					"blockhash":            solana.Hash{1, 2, 3}.String(),
+					"lastValidBlockHeight": 100,
+				},
+			},
+		})
+	}))
+	defer rpcServer.Close()
+
+	wallet := solana.NewWallet()
+	req := AcceptUSDCSolanaDevnet().PaymentRequirement
+	req.PayTo = solana.NewWallet().PublicKey().String()
+	req.MaxAmountRequired = "1000"
+	req.Extra = map[string]string{"feePayer": solana.NewWallet().PublicKey().String(), "decimals": "6"}
+
+	// sign decodes the unsigned transaction and signs it as a browser wallet would
+	sign := func(key solana.PrivateKey) SolanaApprovalFunc {
+		return func(ctx context.Context, request SolanaSigningRequest) (string, error) {
+			tx, err := solana.TransactionFromBase64(request.Transaction)
+			if err != nil {
+				return "", err
+			}
+			if _, err := tx.PartialSign(func(pub solana.PublicKey) *solana.PrivateKey {
+				if pub.Equals(key.PublicKey()) {
+					return &key
+				}
+				return nil
+			}); err != nil {
+				return "", err
+			}
+			return tx.ToBase64()
+		}
+	}
+
+	t.Run("Approved", func(t *testing.T) {
+		var seen SolanaSigningRequest
+		approve := sign(wallet.PrivateKey)
+		signer, err := NewSolanaInteractiveSigner(wallet.PublicKey().String(), func(ctx context.Context, request SolanaSigningRequest) (string, error) {
+			seen = request
+			return approve(ctx, request)
+		}, AcceptUSDCSolanaDevnet())
+		require.NoError(t, err)
+		signer.WithRPCURL(rpcServer.URL)
+
+		payment, err := signer.SignPayment(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, wallet.PublicKey().String(), seen.Address)
+		assert.Equal(t, req.PayTo, seen.Requirement.PayTo)
+
+		tx, err := solana.TransactionFromBase64(payment.Payload.(map[string]any)["transaction"].(string))
+		require.NoError(t, err)
+		message, err := tx.Message.MarshalBinary()
+		require.NoError(t, err)
+		require.Len(t, tx.Signatures, 2)
+		assert.True(t, tx.Signatures[0].IsZero(), "fee payer signs on settlement")
+		assert.True(t, tx.Signatures[1].Verify(wallet.PublicKey(), message))
+	})
+
+	t.Run("Declined", func(t *testing.T) {
+		signer, err := NewSolanaInteractiveSigner(wallet.PublicKey().String(), func(context.Context, SolanaSigningRequest) (string, error) {
+			return "", assert.AnError
+		}, AcceptUSDCSolanaDevnet())
+		require.NoError(t, err)
+		signer.WithRPCURL(rpcServer.URL)
+
+		_, err = signer.SignPayment(context.Background(), req)
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+
+	t.Run("WrongWallet", func(t *testing.T) {
+		signer, err := NewSolanaInteractiveSigner(wallet.PublicKey().String(), sign(solana.NewWallet().PrivateKey), AcceptUSDCSolanaDevnet())
+		require.NoError(t, err)
+		signer.WithRPCURL(rpcServer.URL)
+
+		_, err = signer.SignPayment(context.Background(), req)
+		assert.ErrorContains(t, err, "not signed by wallet")
+	})
+
+	t.Run("TamperedTransaction", func(t *testing.T) {
+		other := req
+		other.PayTo = solana.NewWallet().PublicKey().String()
+		signer, err := NewSolanaInteractiveSigner(wallet.PublicKey().String(), func(ctx context.Context, request SolanaSigningRequest) (string, error) {
+			// Sign a payment to another recipient instead
+			tx, err := buildSolanaPayment(ctx, rpc.New(rpcServer.URL), wallet.PublicKey(), other, &recentMessages{})
+			if err != nil {
+				return "", err
+			}
+			unsigned, err := tx.ToBase64()
+			if err != nil {
+				return "", err
+			}
+			return sign(wallet.PrivateKey)(ctx, SolanaSigningRequest{Transaction: unsigned})
+		}, AcceptUSDCSolanaDevnet())
+		require.NoError(t, err)
+		signer.WithRPCURL(rpcServer.URL)
+
+		_, err = signer.SignPayment(context.Background(), req)
+		assert.ErrorContains(t, err, "different transaction")
+	})
+
+	t.Run("RemembersPaymentsByClock", func(t *testing.T) {
+		clock := NewFakeClock(time.Now())
+		signer, err := NewSolanaInteractiveSigner(wallet.PublicKey().String(), sign(wallet.PrivateKey), AcceptUSDCSolanaDevnet())
+		require.NoError(t, err)
+		signer.WithRPCURL(rpcServer.URL).WithClock(clock)
+
+		_, err = signer.SignPayment(context.Background(), req)
+		require.NoError(t, err)
+
+		// The node serves one blockhash, so an identical payment waits for another
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err = signer.SignPayment(ctx, req)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		clock.Advance(recentMessageTTL + time.Second)
+		_, err = signer.SignPayment(context.Background(), req)
+		assert.NoError(t, err)
+	})
+}
+
+func TestSmartAccountSigner(t *testing.T) {
+	const ownerKey = "0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef"
+	const account = "0x00000000000000000000000000000000000a11ce"
+	const payTo = "0x742d35Cc6634C0532925a3b844Bc9e7595f0bEb6"
+
+	var sponsored UserOperation
+	var methods []string
+	bundler := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     any               `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		methods = append(methods, req.Method)
+
+		var result any
+		switch req.Method {
+		case "eth_call":
+			// getNonce returns the key's sequence (7) under the key
+			var call struct {
+				Data string `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(req.Params[0], &call))
+			data, err := hexutil.Decode(call.Data)
+			require.NoError(t, err)
+			key := new(big.Int).SetBytes(data[len(data)-32:])
+			result = hexutil.EncodeBig(new(big.Int).Add(new(big.Int).Lsh(key, 64), big.NewInt(7)))
+		case "eth_gasPrice":
+			result = "0x3b9aca00"
+		case "pm_sponsorUserOperation":
+			var op UserOperation
+			require.NoError(t, json.Unmarshal(req.Params[0], &op))
+			sponsored = op
+			result = map[string]string{
+				"paymasterAndData":     "0x" + strings.Repeat("ab", 20),
+				"callGasLimit":         "0x186a0",
+				"verificationGasLimit": "0x30d40",
+				"preVerificationGas":   "0xc350",
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	defer bundler.Close()
+
+	signer, err := NewSmartAccountSigner(ownerKey, SmartAccountConfig{
+		Account:    account,
+		BundlerURL: bundler.URL,
+	}, AcceptUSDCBase())
+	require.NoError(t, err)
+	assert.Equal(t, common.HexToAddress(account).Hex(), signer.GetAddress())
+	assert.Equal(t, SchemeERC4337, signer.GetPaymentOption("base", USDCAddressBase).Scheme)
+	assert.Equal(t, SchemeExact, AcceptUSDCBase().Scheme)
+
+	// Exact requirements expect an EIP-3009 authorization the signer can't give
+	_, err = signer.SignPayment(context.Background(), PaymentRequirement{
+		Scheme:            SchemeExact,
+		Network:           "base",
+		Asset:             USDCAddressBase,
+		PayTo:             payTo,
+		MaxAmountRequired: "10000",
+	})
+	assert.Error(t, err)
+	assert.Empty(t, methods)
+
+	payment, err := signer.SignPayment(context.Background(), PaymentRequirement{
+		Scheme:            SchemeERC4337,
+		Network:           "base",
+		Asset:             USDCAddressBase,
+		PayTo:             payTo,
+		MaxAmountRequired: "10000",
+		MaxTimeoutSeconds: 60,
+	})
+	require.NoError(t, err)
+
+	// The operation is left for the facilitator to submit
+	assert.Equal(t, []string{"eth_call", "eth_gasPrice", "pm_sponsorUserOperation"}, methods)
+
+	payload, ok := payment.Payload.(UserOperationPayload)
+	require.True(t, ok)
+	assert.Equal(t, EntryPointV06Address, payload.EntryPoint)
+	submitted := payload.UserOperation
+
+	// The nonce is sequence 7 of a key committing to the payment nonce
+	nonce, err := hexutil.DecodeBig(submitted.Nonce)
+	require.NoError(t, err)
+	assert.Equal(t, sponsored.Nonce, submitted.Nonce)
+	assert.Equal(t, int64(7), new(big.Int).And(nonce, new(big.Int).SetUint64(^uint64(0))).Int64())
+	assert.Positive(t, new(big.Int).Rsh(nonce, 64).Sign())
+
+	// Servers read the payment nonce from the decoded payload through the scheme
+	decode := func(payment *PaymentPayload) map[string]any {
+		raw, err := json.Marshal(payment.Payload)
+		require.NoError(t, err)
+		var decoded map[string]any
+		require.NoError(t, json.Unmarshal(raw, &decoded))
+		return decoded
+	}
+	scheme, ok := LookupScheme(SchemeERC4337)
+	require.True(t, ok)
+	decoded := decode(payment)
+	paymentNonce, err := scheme.Nonce(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, payload.Nonce, paymentNonce)
+	decoded["nonce"] = "0x" + strings.Repeat("22", 32)
+	_, err = scheme.Nonce(decoded)
+	assert.Error(t, err, "a payment nonce the operation doesn't commit to must be refused")
+
+	// The signed operation is sponsored and calls execute(USDC, 0, transfer(payTo, 10000))
+	assert.Equal(t, "0x"+strings.Repeat("ab", 20), submitted.PaymasterAndData)
+	assert.Equal(t, "0x186a0", submitted.CallGasLimit)
+
+	addressType, _ := abi.NewType("address", "", nil)
+	uintType, _ := abi.NewType("uint256", "", nil)
+	bytesType, _ := abi.NewType("bytes", "", nil)
+	transfer, err := abi.Arguments{{Type: addressType}, {Type: uintType}}.Pack(common.HexToAddress(payTo), big.NewInt(10000))
+	require.NoError(t, err)
+	execute, err := abi.Arguments{{Type: addressType}, {Type: uintType}, {Type: bytesType}}.Pack(
+		common.HexToAddress(USDCAddressBase), big.NewInt(0), append(crypto.Keccak256([]byte("transfer(address,uint256)"))[:4], transfer...))
+	require.NoError(t, err)
+	assert.Equal(t, hexutil.Encode(append(crypto.Keccak256([]byte("execute(address,uint256,bytes)"))[:4], execute...)), submitted.CallData)
+
+	// The owner signed the operation hash
+	hash, err := submitted.Hash(EntryPointV06Address, big.NewInt(8453))
+	require.NoError(t, err)
+	assert.Equal(t, hexutil.Encode(hash), payload.UserOpHash)
+	signature, err := hexutil.Decode(submitted.Signature)
+	require.NoError(t, err)
+	signature[64] -= 27
+	pubKey, err := crypto.SigToPub(accounts.TextHash(hash), signature)
+	require.NoError(t, err)
+	owner, _ := crypto.HexToECDSA(strings.TrimPrefix(ownerKey, "0x"))
+	assert.Equal(t, crypto.PubkeyToAddress(owner.PublicKey), crypto.PubkeyToAddress(*pubKey))
+
+	// Another payment uses another nonce key
+	again, err := signer.SignPayment(context.Background(), PaymentRequirement{
+		Scheme:            SchemeERC4337,
+		Network:           "base",
+		Asset:             USDCAddressBase,
+		PayTo:             payTo,
+		MaxAmountRequired: "10000",
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, submitted.Nonce, again.Payload.(UserOperationPayload).UserOperation.Nonce)
+
+	// A binding nonce becomes the payment nonce
+	bindingNonce := "0x" + strings.Repeat("33", 32)
+	bound, err := signer.SignPayment(WithBindingNonce(context.Background(), bindingNonce), PaymentRequirement{
+		Scheme:            SchemeERC4337,
+		Network:           "base",
+		Asset:             USDCAddressBase,
+		PayTo:             payTo,
+		MaxAmountRequired: "10000",
+	})
+	require.NoError(t, err)
+	paymentNonce, err = scheme.Nonce(decode(bound))
+	require.NoError(t, err)
+	assert.Equal(t, bindingNonce, paymentNonce)
+
+	t.Run("SponsorshipDeclined", func(t *testing.T) {
+		paymaster := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32500,"message":"policy exhausted"}}`))
+		}))
+		defer paymaster.Close()
+
+		signer, err := NewSmartAccountSigner(ownerKey, SmartAccountConfig{
+			Account:      account,
+			BundlerURL:   bundler.URL,
+			PaymasterURL: paymaster.URL,
+		}, AcceptUSDCBase())
+		require.NoError(t, err)
+
+		_, err = signer.SignPayment(context.Background(), PaymentRequirement{
+			Scheme:            SchemeERC4337,
+			Network:           "base",
+			Asset:             USDCAddressBase,
+			PayTo:             payTo,
+			MaxAmountRequired: "10000",
+		})
+		assert.ErrorIs(t, err, ErrSponsorshipFailed)
+		assert.Contains(t, err.Error(), "policy exhausted")
+	})
+}
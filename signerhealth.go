@@ -0,0 +1,234 @@
+package x402
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// Defaults for SignerHealthConfig
+	defaultSignerHealthInterval = time.Minute
+	defaultSignerHealthTimeout  = 10 * time.Second
+
+	// healthCheckMessage is signed by signers checking their key
+	healthCheckMessage = "x402 signer health check"
+)
+
+// SignerHealthChecker is implemented by signers that can check their key is
+// usable without signing a payment, e.g. PrivateKeySigner and SolanaPrivateKeySigner
+type SignerHealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// PaymentOptionLister is implemented by signers that can list their payment
+// options, which SignerHealth checks balances of
+type PaymentOptionLister interface {
+	PaymentOptions() []ClientPaymentOption
+}
+
+// SignerHealthConfig configures periodic health checks of the transport's
+// signers. A failing signer is skipped when paying until a later check passes.
+type SignerHealthConfig struct {
+	// Interval between checks (1 minute when zero)
+	Interval time.Duration
+
+	// Timeout bounds each signer's check (10s when zero)
+	Timeout time.Duration
+
+	// Balance, if set, looks up the balance of each payment option of signers
+	// implementing PaymentOptionLister. A signer fails when no option's balance
+	// can be read (its RPC is unreachable) and covers the option's MinBalance.
+	Balance BalanceFunc
+
+	// Checks are additional checks run for every signer
+	Checks []func(ctx context.Context, signer PaymentSigner) error
+
+	// OnChange, if set, receives PaymentEventSignerDisabled and
+	// PaymentEventSignerEnabled events, with the failure in Error
+	OnChange func(PaymentEvent)
+
+	// Logger receives disablement notices (the standard logger when nil)
+	Logger Logger
+}
+
+// SignerHealth tracks which signers passed their last health check.
+// It is safe for concurrent use.
+type SignerHealth struct {
+	config  SignerHealthConfig
+	signers []PaymentSigner
+	logger  Logger
+
+	mu      sync.RWMutex
+	failing map[PaymentSigner]error
+}
+
+// NewSignerHealth creates a tracker for signers; all are healthy until checked
+func NewSignerHealth(signers []PaymentSigner, config SignerHealthConfig) *SignerHealth {
+	if config.Interval <= 0 {
+		config.Interval = defaultSignerHealthInterval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSignerHealthTimeout
+	}
+	return &SignerHealth{
+		config:  config,
+		signers: signers,
+		logger:  defaultLogger(config.Logger),
+		failing: make(map[PaymentSigner]error),
+	}
+}
+
+// Run checks the signers every Interval until ctx is done
+func (h *SignerHealth) Run(ctx context.Context) {
+	h.run(ctx.Done())
+}
+
+// run checks the signers every Interval until done is closed
+func (h *SignerHealth) run(done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-done
+		cancel()
+	}()
+
+	ticker := time.NewTicker(h.config.Interval)
+	defer ticker.Stop()
+	for {
+		h.CheckNow(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// CheckNow checks every signer once, disabling those failing and re-enabling
+// those recovered
+func (h *SignerHealth) CheckNow(ctx context.Context) {
+	for idx, signer := range h.signers {
+		checkCtx, cancel := context.WithTimeout(ctx, h.config.Timeout)
+		err := h.check(checkCtx, signer)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		h.update(idx, signer, err)
+	}
+}
+
+// Failure returns the failure a signer is disabled for, or nil when it is enabled
+func (h *SignerHealth) Failure(signer PaymentSigner) error {
+	if h == nil {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.failing[signer]
+}
+
+// active returns the signers not disabled, keeping their order. When every
+// signer is disabled all are returned, so that payments are still attempted.
+func (h *SignerHealth) active(signers []PaymentSigner) []PaymentSigner {
+	if h == nil {
+		return signers
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.failing) == 0 {
+		return signers
+	}
+
+	var healthy []PaymentSigner
+	for _, signer := range signers {
+		if _, failing := h.failing[signer]; !failing {
+			healthy = append(healthy, signer)
+		}
+	}
+	if len(healthy) == 0 {
+		return signers
+	}
+	return healthy
+}
+
+// check runs the health checks of one signer
+func (h *SignerHealth) check(ctx context.Context, signer PaymentSigner) error {
+	if checker, ok := signer.(SignerHealthChecker); ok {
+		if err := checker.CheckHealth(ctx); err != nil {
+			return err
+		}
+	}
+	if h.config.Balance != nil {
+		if lister, ok := signer.(PaymentOptionLister); ok {
+			if err := h.checkBalances(ctx, signer.GetAddress(), lister.PaymentOptions()); err != nil {
+				return err
+			}
+		}
+	}
+	for _, check := range h.config.Checks {
+		if err := check(ctx, signer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBalances checks that at least one option's balance can be read and
+// covers its MinBalance
+func (h *SignerHealth) checkBalances(ctx context.Context, address string, options []ClientPaymentOption) error {
+	var errs []error
+	for _, option := range options {
+		balance, err := h.config.Balance(ctx, option.Network, option.Asset, address)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("balance of %s on %s: %w", option.Asset, option.Network, err))
+			continue
+		}
+		if minBalance, ok := new(big.Int).SetString(option.MinBalance, 10); ok && balance.Cmp(minBalance) < 0 {
+			errs = append(errs, fmt.Errorf("balance of %s on %s is %s, below the minimum %s", option.Asset, option.Network, balance, minBalance))
+			continue
+		}
+		return nil
+	}
+	return errors.Join(errs...)
+}
+
+// update records the outcome of a signer's check, emitting an event when the
+// signer is disabled or re-enabled
+func (h *SignerHealth) update(idx int, signer PaymentSigner, err error) {
+	h.mu.Lock()
+	_, wasFailing := h.failing[signer]
+	if err != nil {
+		h.failing[signer] = err
+	} else {
+		delete(h.failing, signer)
+	}
+	h.mu.Unlock()
+
+	var eventType PaymentEventType
+	switch {
+	case err != nil && !wasFailing:
+		eventType = PaymentEventSignerDisabled
+		h.logger.Printf("[X402] Disabling signer %s: %v", signer.GetAddress(), err)
+	case err == nil && wasFailing:
+		eventType = PaymentEventSignerEnabled
+		h.logger.Printf("[X402] Re-enabling signer %s", signer.GetAddress())
+	default:
+		return
+	}
+
+	if h.config.OnChange != nil {
+		h.config.OnChange(PaymentEvent{
+			Type:           eventType,
+			SignerIndex:    idx,
+			SignerPriority: signer.GetPriority(),
+			SignerAddress:  signer.GetAddress(),
+			Error:          err,
+			Timestamp:      time.Now().Unix(),
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package x402
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SSEEventReason tells why the transport did not handle an SSE event
+type SSEEventReason string
+
+const (
+	// SSEEventInvalidMessage is a message event whose data is not a JSON-RPC message
+	SSEEventInvalidMessage SSEEventReason = "invalid message"
+
+	// SSEEventUnknownType is an event of a type other than "message" whose data
+	// is not a JSON-RPC message
+	SSEEventUnknownType SSEEventReason = "unknown event type"
+
+	// SSEEventInvalidNotification is a message without an ID that is not a
+	// valid JSON-RPC notification
+	SSEEventInvalidNotification SSEEventReason = "invalid notification"
+)
+
+// UnhandledSSEEvent is an SSE event the transport received but dropped
+type UnhandledSSEEvent struct {
+	Event  string // SSE event type, "message" when the server named none
+	Data   string
+	Reason SSEEventReason
+	Err    error // Decoding error
+}
+
+// SSEEventStats counts the SSE events the transport received
+type SSEEventStats struct {
+	Handled   uint64
+	Unhandled map[SSEEventReason]uint64
+}
+
+// sseEventCounter counts handled and dropped SSE events
+type sseEventCounter struct {
+	handled atomic.Uint64
+
+	mu        sync.Mutex
+	unhandled map[SSEEventReason]uint64
+}
+
+func newSSEEventCounter() *sseEventCounter {
+	return &sseEventCounter{unhandled: make(map[SSEEventReason]uint64)}
+}
+
+// drop counts an unhandled event
+func (c *sseEventCounter) drop(reason SSEEventReason) {
+	c.mu.Lock()
+	c.unhandled[reason]++
+	c.mu.Unlock()
+}
+
+// stats returns a snapshot of the counts
+func (c *sseEventCounter) stats() SSEEventStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	unhandled := make(map[SSEEventReason]uint64, len(c.unhandled))
+	for reason, count := range c.unhandled {
+		unhandled[reason] = count
+	}
+	return SSEEventStats{Handled: c.handled.Load(), Unhandled: unhandled}
+}
+
+// SSEEventStats returns how many SSE events the transport and its sessions
+// handled and dropped, so operators can spot servers sending events it doesn't
+// understand, such as payment notices in a newer format
+func (t *X402Transport) SSEEventStats() SSEEventStats {
+	return t.sseEvents.stats()
+}
+
+// dropSSEEvent counts an event the transport could not handle, logs it when
+// verbose and passes it to OnUnhandledSSEEvent
+func (t *X402Transport) dropSSEEvent(event, data string, reason SSEEventReason, err error) {
+	t.sseEvents.drop(reason)
+	if t.verbose {
+		t.logger.Printf("[X402] Dropped SSE %s event: %s: %v", event, reason, err)
+	}
+	if t.onUnhandledSSEEvent != nil {
+		t.onUnhandledSSEEvent(UnhandledSSEEvent{Event: event, Data: data, Reason: reason, Err: err})
+	}
+}
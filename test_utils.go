@@ -124,3 +124,28 @@ func (r *PaymentRecorder) TotalAmount() string {
 	}
 	return total.String()
 }
+
+// TotalAmountBy returns the total of successful payments grouped by an attribution
+// dimension (see WithAttribution). Untagged payments are grouped under "".
+func (r *PaymentRecorder) TotalAmountBy(dimension string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	totals := make(map[string]*big.Int)
+	for _, event := range r.events {
+		if event.Type != PaymentEventSuccess || event.Amount == nil {
+			continue
+		}
+		key := event.Attribution[dimension]
+		if totals[key] == nil {
+			totals[key] = big.NewInt(0)
+		}
+		totals[key].Add(totals[key], event.Amount)
+	}
+
+	out := make(map[string]string, len(totals))
+	for key, total := range totals {
+		out[key] = total.String()
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package x402
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenKey identifies the tokens a server granted to a wallet
+type TokenKey struct {
+	Origin string // Server origin, e.g. https://mcp.example.com
+	Wallet string // Payer address
+}
+
+// newTokenKey builds a key, normalizing EVM addresses to lowercase
+func newTokenKey(origin, wallet string) TokenKey {
+	if strings.HasPrefix(wallet, "0x") {
+		wallet = strings.ToLower(wallet)
+	}
+	return TokenKey{Origin: origin, Wallet: wallet}
+}
+
+// StoredTokens are the credits a server granted to a wallet
+type StoredTokens struct {
+	AccessPass string    `json:"accessPass,omitempty"`
+	Expires    time.Time `json:"expires"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// TokenStore persists server-granted access passes across process restarts.
+// Implementations may keep them in a file (FileTokenStore) or an OS keyring.
+type TokenStore interface {
+	// Load returns the tokens stored for key, or nil if there are none
+	Load(key TokenKey) (*StoredTokens, error)
+
+	// Save stores tokens for key
+	Save(key TokenKey, tokens StoredTokens) error
+}
+
+// FileTokenStore keeps tokens in a JSON file readable only by its owner
+type FileTokenStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileTokenStore creates a store backed by the file at path; the file is
+// created on the first save
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{path: path}
+}
+
+// Load implements TokenStore
+func (s *FileTokenStore) Load(key TokenKey) (*StoredTokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	tokens, ok := all[fileTokenKey(key)]
+	if !ok {
+		return nil, nil
+	}
+	return &tokens, nil
+}
+
+// Save implements TokenStore
+func (s *FileTokenStore) Save(key TokenKey, tokens StoredTokens) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.read()
+	if err != nil {
+		return err
+	}
+	all[fileTokenKey(key)] = tokens
+
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode tokens: %w", err)
+	}
+
+	// Replace the file atomically so a crash never leaves it truncated
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// read loads all stored tokens; a missing file holds none
+func (s *FileTokenStore) read() (map[string]StoredTokens, error) {
+	all := make(map[string]StoredTokens)
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token store: %w", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse token store: %w", err)
+	}
+	return all, nil
+}
+
+func fileTokenKey(key TokenKey) string {
+	return key.Origin + " " + key.Wallet
+}
+
+// serverOrigin returns the scheme and host of the server URL
+func (t *X402Transport) serverOrigin() string {
+	return t.serverURL.Scheme + "://" + t.serverURL.Host
+}
+
+// currentAccessPass returns the unexpired access pass for the server, restoring
+// it from the token store on first use
+func (t *X402Transport) currentAccessPass() string {
+	t.accessPassOnce.Do(func() {
+		if t.tokenStore == nil {
+			return
+		}
+		for _, signer := range t.handler.signers {
+			tokens, err := t.tokenStore.Load(newTokenKey(t.serverOrigin(), signer.GetAddress()))
+			if err != nil || tokens == nil || tokens.AccessPass == "" || t.clock.Now().After(tokens.Expires) {
+				continue
+			}
+			t.accessPass.Store(tokens)
+			return
+		}
+	})
+
+	tokens, _ := t.accessPass.Load().(*StoredTokens)
+	if tokens == nil || t.clock.Now().After(tokens.Expires) {
+		return ""
+	}
+	return tokens.AccessPass
+}
+
+// captureAccessPass keeps (and persists) an access pass issued in resp
+func (t *X402Transport) captureAccessPass(resp *http.Response) {
+	pass := resp.Header.Get(HeaderAccessPass)
+	if pass == "" {
+		return
+	}
+	claims, err := ParseAccessPassClaims(pass)
+	if err != nil {
+		return
+	}
+
+	tokens := &StoredTokens{AccessPass: pass, Expires: claims.ExpiresAt(), UpdatedAt: t.clock.Now()}
+	t.accessPass.Store(tokens)
+	if t.tokenStore != nil {
+		_ = t.tokenStore.Save(newTokenKey(t.serverOrigin(), claims.Payer), *tokens)
+	}
+}
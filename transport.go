@@ -13,6 +13,7 @@ import (
 	"mime"
 	"net/http"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -30,6 +31,10 @@ const (
 	requestHandlingTimeout = 30 * time.Second
 )
 
+// MetaKeyPaymentRequired is the result _meta key soft-402 servers use to deliver
+// payment requirements in a successful result
+const MetaKeyPaymentRequired = "x402/payment-required"
+
 // X402Transport implements transport.Interface with x402 payment support
 // It is based on StreamableHTTP with added x402 payment handling
 type X402Transport struct {
@@ -56,9 +61,123 @@ type X402Transport struct {
 	onPaymentSuccess func(PaymentEvent)
 	onPaymentFailure func(PaymentEvent, error)
 
+	// Bind payment nonces to the request they pay for, and to the server
+	// origin and resource
+	bindPayments bool
+	bindResource bool
+
+	// Per-network settlement latency and failure tracking
+	health *NetworkHealth
+
+	// Payment caps, rejected-payment backoff and server quarantine
+	guard *paymentGuard
+
+	// Additional JSON-RPC error codes meaning payment required
+	paymentRequiredCodes []int
+
+	// Treat results carrying _meta["x402/payment-required"] as 402s
+	detectSoftPaymentRequired bool
+
+	// _meta keys of payments and settlement responses
+	metaNamespace MetaNamespace
+
+	// Check paid results came from the paid request
+	verifyIntegrity       bool
+	resultIntegritySigner string
+
+	// Validation of paid results against their outputSchema
+	outputSchemaAction      OutputSchemaAction
+	onOutputSchemaViolation func(OutputSchemaViolation)
+	refundRequester         RefundRequester
+
+	// Time budget for the sign-and-retry detour
+	maxPaymentOverhead   time.Duration
+	maxDeadlineExtension time.Duration
+
+	// Requirements last quoted per tool, paid without a probe request
+	requirementsCache *requirementsCache
+
+	// Free trials servers reported per tool, during which cached requirements aren't paid
+	trials *trialTracker
+
+	// Token of the prepaid deposit tool calls draw from
+	deposit *depositToken
+
+	// Tool prices advertised in tools/list, compared with live 402s
+	catalogPrices      *catalogPrices
+	catalogPriceChange PriceChangeAction
+
+	// Re-signing of Solana payments that failed to settle
+	priorityFeeEscalation *PriorityFeeEscalation
+
+	// On-chain finality of settlements
+	finality *FinalityConfig
+
+	// Settlements the server reported pending, followed until they complete
+	onSettlementFinalized  func(SettlementStatus)
+	settlementPollInterval time.Duration
+	pendingSettlements     *pendingSettlements
+
+	// Audit of payments declined by policy
+	attester          Attester
+	declineLedger     DeclineLedger
+	onPaymentDeclined func(DeclinedPayment)
+
+	// Access pass for the server's session access fee, persisted in tokenStore
+	tokenStore     TokenStore
+	accessPass     atomic.Value // *StoredTokens
+	accessPassOnce sync.Once
+
+	// Clock expiries go by
+	clock Clock
+
+	// Accept header of paid retries
+	paidAcceptMode AcceptMode
+
+	// Headers added to every request, without the reserved ones
+	extraHeaders map[string]string
+
+	// x402 capability advertised by the server in its initialize result
+	capability atomic.Pointer[Capability]
+
+	// Payer identity attached to every request
+	identity   IdentityProvider
+	clientInfo atomic.Value // mcp.Implementation from initialize
+
+	// Manual payment mode: 402s are surfaced to the caller
+	manualPayments   bool
+	manualPaymentTTL time.Duration
+	pending          map[string]pendingPayment
+	pendingMu        sync.Mutex
+
+	// Payment events forwarded to the host as log notifications, with the
+	// session's spend so far
+	logToHost bool
+	spend     *SpendTally
+
+	// SSE events dropped or handled, shared by sessions
+	onUnhandledSSEEvent func(UnhandledSSEEvent)
+	sseEvents           *sseEventCounter
+
+	// Successful payments by attribution, shared by sessions
+	metrics *paymentMetrics
+
 	// State
-	closed chan struct{}
-	wg     sync.WaitGroup
+	state    atomic.Int32 // TransportState
+	closed   chan struct{}
+	wg       sync.WaitGroup
+	payments *paymentTracker
+
+	// Logging
+	logger       Logger
+	verbose      bool
+	logSampler   *LogSampler
+	logRedaction RedactionLevel
+
+	// Spend threshold and failure notifications to event sinks
+	monitor *walletMonitor
+
+	paymentCodec Codec
 
 	// Testing support
 	paymentRecorder *PaymentRecorder
@@ -75,25 +194,245 @@ type Config struct {
 	OnPaymentSuccess func(PaymentEvent)
 	OnPaymentFailure func(PaymentEvent, error)
 	OnSignerAttempt  func(PaymentEvent) // Per-signer attempt callback
+
+	// SelectionStrategy chooses which signer pays which requirement.
+	// Defaults to trying signers in priority order (see PriorityFirst).
+	SelectionStrategy SelectionStrategy
+
+	// SignerHealth, if set, periodically checks the signers (key usable, RPC
+	// reachable, balance above MinBalance) and skips failing ones in the fallback
+	// order until they recover. Checks stop when the transport is closed.
+	SignerHealth *SignerHealthConfig
+
+	// NetworkHealth receives per-network settlement latency and failures observed
+	// by the transport. Share it with HealthAware to steer away from congested chains.
+	// A private tracker is created when nil.
+	NetworkHealth *NetworkHealth
+
+	// BindPaymentToRequest makes EVM payment nonces commit to a hash of the
+	// request method and params, so a payment can't be replayed against a
+	// different request with the same price. Solana payments are not bound.
+	BindPaymentToRequest bool
+
+	// BindPaymentToResource makes EVM payment nonces commit to the server's
+	// origin and the resource paid for, so a leaked authorization can't be
+	// redeemed by another server or resource. Payments to servers requiring it
+	// (requirement Extra "resourceBinding": "required") are always bound.
+	BindPaymentToResource bool
+
+	// ManualPaymentMode disables automatic payment. SendRequest returns a
+	// *PaymentRequiredError (matching ErrPaymentRequired) carrying the parsed
+	// requirements; complete the request with RetryWithPayment after approval.
+	ManualPaymentMode bool
+
+	// ManualPaymentTTL is how long a request waits for RetryWithPayment in
+	// manual payment mode (1h when zero). Requests left longer are forgotten.
+	ManualPaymentTTL time.Duration
+
+	// Identity, if set, attaches a signed payer identity claim to every request
+	// in params._meta["x402/identity"], letting servers recognize returning payers.
+	Identity IdentityProvider
+
+	// TrustedRecipients, if set, maps networks to the payTo addresses the transport
+	// may pay. Payments to any other recipient are refused with ErrUntrustedRecipient,
+	// protecting against servers that swap PayTo or impersonate a trusted URL.
+	TrustedRecipients map[string][]string
+
+	// ForceNetwork and ForceAsset, if set, restrict every payment to this
+	// network and asset (e.g. USDC on Base for a treasury policy). Servers not
+	// accepting them fail with ErrForcedPaymentOption.
+	ForceNetwork string
+	ForceAsset   string
+
+	// TokenStore, if set, persists access passes servers grant for a paid session
+	// access fee, keyed by server origin and wallet, so a restarted client doesn't
+	// pay the fee again while its pass is valid
+	TokenStore TokenStore
+
+	// Bridging, if set, opts into bridging funds from other networks when the wallet
+	// lacks the required amount on the network being paid (see BridgingConfig)
+	Bridging *BridgingConfig
+
+	// MaxPaymentsPerRequest caps the payments made while serving one request,
+	// including a session access fee (default 2). A server answering every
+	// payment with another 402 can't loop the transport into paying again.
+	MaxPaymentsPerRequest int
+
+	// FailureBackoff is how long a resource isn't paid for after the server
+	// rejects a payment for it with another 402 (default 1s). It doubles with
+	// each consecutive rejection up to MaxFailureBackoff (default 5m).
+	FailureBackoff    time.Duration
+	MaxFailureBackoff time.Duration
+
+	// QuarantineAfter consecutive rejected payments stop all payments to the
+	// server for QuarantineDuration (defaults 5 and 1h; negative disables).
+	QuarantineAfter    int
+	QuarantineDuration time.Duration
+
+	// DeclineLedger persists an audit record of every payment refused by the
+	// client's policy (payment callback, trusted recipients, payment caps,
+	// backoff and quarantine); OnPaymentDeclined is notified of each record.
+	// With an Attester (e.g. an EVMIdentity) records are signed.
+	DeclineLedger     DeclineLedger
+	OnPaymentDeclined func(DeclinedPayment)
+	Attester          Attester
+
+	// PaymentRequiredCodes are JSON-RPC error codes treated as payment required
+	// in addition to 402 (e.g. -32402 or -32000) when their data carries payment
+	// requirements, so a busy server sharing the code isn't taken for one
+	// rejecting the payment. Errors with other codes are still recognized when
+	// their data carries x402 payment requirements.
+	PaymentRequiredCodes []int
+
+	// DetectSoftPaymentRequired makes the transport pay and retry when a server
+	// answers with a successful result carrying requirements in
+	// result._meta["x402/payment-required"] instead of a 402 error.
+	DetectSoftPaymentRequired bool
+
+	// MetaNamespace names the _meta keys payments are sent and settlement
+	// responses read under (MetaNamespaceX402 when unset). Settlement responses
+	// are also read under the keys of KnownMetaNamespaces.
+	MetaNamespace MetaNamespace
+
+	// VerifyResultIntegrity if true, fails paid calls with ErrResultIntegrity
+	// when the settlement lacks an integrity digest matching the request ID and
+	// result, as added by servers with ResultIntegrity enabled. With
+	// ResultIntegritySigner set, the digest must be signed by that address.
+	VerifyResultIntegrity bool
+	ResultIntegritySigner string
+
+	// OutputSchemaAction validates paid tool results against the outputSchema of
+	// the requirement paid: their structured content, else their first JSON text
+	// content. Violations are logged and passed to OnOutputSchemaViolation; with
+	// OutputSchemaRequestRefund they are also passed to RefundRequester.
+	OutputSchemaAction      OutputSchemaAction
+	OnOutputSchemaViolation func(OutputSchemaViolation)
+	RefundRequester         RefundRequester
+
+	// MaxPaymentOverhead bounds how long paying may add to a request: signing
+	// the payment and retrying with it run under a sub-deadline of this duration
+	// (within the caller's own deadline). Zero leaves only the caller's deadline.
+	MaxPaymentOverhead time.Duration
+
+	// MaxDeadlineExtension, if set, lets servers push back the MaxPaymentOverhead
+	// sub-deadline of a payment by up to this much in total, when their 402
+	// announces a slow settlement (settlementSeconds) or a progress notification
+	// reports one pending (_meta["x402/settlement-pending"]). The caller's own
+	// deadline is never extended.
+	MaxDeadlineExtension time.Duration
+
+	// RequirementsCacheTTL, if set, remembers each tool's payment requirements
+	// for this long after its 402, so later calls carry the payment on their
+	// first request instead of probing for the 402 again. A payment the server
+	// rejects drops the tool's entry and its new requirements are paid instead.
+	RequirementsCacheTTL time.Duration
+
+	// CatalogPriceChange decides what happens when a tool's 402 asks more than
+	// the price its tools/list entry advertised (x402/cost-hint): pay anyway
+	// (the default), log a warning, or refuse the options whose price rose.
+	CatalogPriceChange PriceChangeAction
+
+	// PriorityFeeEscalation, if set, re-signs Solana payments the server failed
+	// to settle with a fresh blockhash and a higher priority fee, within the
+	// retry window the server reports
+	PriorityFeeEscalation *PriorityFeeEscalation
+
+	// Finality, if set, follows payment settlements on chain in the background
+	// and reports when each is final, e.g. after N confirmations on Polygon or
+	// at the finalized commitment on Solana
+	Finality *FinalityConfig
+
+	// OnSettlementFinalized, if set, is called when a settlement the server
+	// reported as pending (SettlementResponse.Status) completes, with its final
+	// status. The transport polls the server's status resource every
+	// SettlementPollInterval (5s when zero) for up to 10 minutes, and stops
+	// early on the server's notifications/x402/settlement notification.
+	OnSettlementFinalized  func(SettlementStatus)
+	SettlementPollInterval time.Duration
+
+	// CheckDeadline if true, refuses to pay requirements whose MaxTimeoutSeconds
+	// exceeds the time left before the request's deadline (including
+	// MaxPaymentOverhead), failing with ErrDeadlineTooShort when none fits
+	CheckDeadline bool
+
+	// FeeEstimator, if set, estimates the network fee paid on top of the amount for
+	// schemes where the payer bears gas. MaxAmount and PaymentCallback checks then
+	// apply to the total cost, and payment events report NetworkFee and TotalCost.
+	FeeEstimator FeeEstimator
+
+	// Budget, if set, caps spending per network and asset (and optionally in a
+	// reference currency) over a rolling window, failing with ErrBudgetExceeded
+	Budget *BudgetManager
+
+	// Clock, if set, is the clock access pass expiry, free trials, cached
+	// requirements, CheckDeadline, rejected-payment backoff and quarantine, and
+	// event timestamps go by (the system clock when nil). Budgets and signers
+	// take their own, see BudgetLimits.Clock and PrivateKeySigner.WithClock.
+	Clock Clock
+
+	// PaidAccept chooses the Accept header of paid retries: both JSON and SSE
+	// (the default), JSON or SSE only, or the unpaid request's (AcceptCaller),
+	// for servers that only stream when SSE is accepted and proxies mishandling
+	// mixed Accept values. WithPaidAccept overrides it per request.
+	PaidAccept AcceptMode
+
+	// ExtraHeaders are added to every request to the server, including paid
+	// retries, e.g. headers corporate gateways require for auditing.
+	// WithHeaders adds or overrides headers per request. Headers the transport
+	// sets itself (Accept, Mcp-Session-Id, X-PAYMENT...) can't be overridden.
+	ExtraHeaders map[string]string
+
+	// Logger receives the transport's log output (the standard logger when nil)
+	Logger Logger
+
+	// Verbose if true, logs each payment made. Only one in LogSampleRate
+	// payments is logged (all when 0 or 1); failed payments are always logged.
+	Verbose       bool
+	LogSampleRate int
+
+	// LogRedaction masks recipients and transaction hashes in log lines
+	LogRedaction RedactionLevel
+
+	// EventSinks are notified when the transport's spend of an asset crosses
+	// one of SpendThresholds (in base units), when FailureAlertAfter payments
+	// in a row fail (3 when 0), and when payments succeed again
+	EventSinks        []EventSink
+	SpendThresholds   map[AssetKey][]*big.Int
+	FailureAlertAfter int
+
+	// LogPaymentsToHost if true, delivers each successful and failed payment to
+	// the MCP client's notification handler as a notifications/message with
+	// logger "x402" (see PaymentLog), alongside the log messages servers send.
+	// Hosts showing server logs then show the transport's payments too.
+	LogPaymentsToHost bool
+
+	// OnUnhandledSSEEvent, if set, receives each SSE event the transport drops
+	// because it can't decode it, e.g. an event type or message format it
+	// doesn't know. SSEEventStats counts them either way.
+	OnUnhandledSSEEvent func(UnhandledSSEEvent)
+
+	// PaymentCodec encodes payments sent in the X-PAYMENT header (JSON when nil).
+	// Non-JSON encodings are named in the X-PAYMENT-ENCODING header; the server
+	// must be configured with the same codec.
+	PaymentCodec Codec
+
+	// StrictConfig if true, makes New fail on the warnings of Validate (ignored
+	// or contradictory settings) instead of logging them
+	StrictConfig bool
 }
 
 // New creates a new X402Transport
 func New(config Config) (*X402Transport, error) {
+	if err := checkConfig(config, defaultLogger(config.Logger)); err != nil {
+		return nil, err
+	}
 	parsedURL, err := url.Parse(config.ServerURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid server URL: %w", err)
 	}
 
 	// Handle backward compatibility
-	signers := config.Signers
-	if len(signers) == 0 && config.Signer != nil {
-		// Legacy single-signer configuration
-		signers = []PaymentSigner{config.Signer}
-	}
-
-	if len(signers) == 0 {
-		return nil, ErrNoSignerConfigured
-	}
+	signers := config.signers()
 
 	// Assign implicit priorities based on array order if not set
 	for i, signer := range signers {
@@ -117,8 +456,25 @@ func New(config Config) (*X402Transport, error) {
 	})
 
 	handlerConfig := &HandlerConfig{
-		PaymentCallback: config.PaymentCallback,
-		OnSignerAttempt: config.OnSignerAttempt,
+		PaymentCallback:   config.PaymentCallback,
+		OnSignerAttempt:   config.OnSignerAttempt,
+		Strategy:          config.SelectionStrategy,
+		TrustedRecipients: config.TrustedRecipients,
+		ForceNetwork:      config.ForceNetwork,
+		ForceAsset:        config.ForceAsset,
+		Bridging:          config.Bridging,
+		CheckDeadline:     config.CheckDeadline,
+		FeeEstimator:      config.FeeEstimator,
+		Budget:            config.Budget,
+		Logger:            config.Logger,
+		Clock:             config.Clock,
+	}
+	if config.SignerHealth != nil {
+		healthConfig := *config.SignerHealth
+		if healthConfig.Logger == nil {
+			healthConfig.Logger = config.Logger
+		}
+		handlerConfig.SignerHealth = NewSignerHealth(signers, healthConfig)
 	}
 
 	handler, err := NewPaymentHandlerMulti(signers, handlerConfig)
@@ -133,39 +489,179 @@ func New(config Config) (*X402Transport, error) {
 		}
 	}
 
+	health := config.NetworkHealth
+	if health == nil {
+		health = NewNetworkHealth()
+	}
+
 	t := &X402Transport{
-		serverURL:        parsedURL,
-		httpClient:       httpClient,
-		handler:          handler,
-		closed:           make(chan struct{}),
-		initialized:      make(chan struct{}),
-		onPaymentAttempt: config.OnPaymentAttempt,
-		onPaymentSuccess: config.OnPaymentSuccess,
-		onPaymentFailure: config.OnPaymentFailure,
+		serverURL:                 parsedURL,
+		httpClient:                httpClient,
+		handler:                   handler,
+		onPaymentAttempt:          config.OnPaymentAttempt,
+		onPaymentSuccess:          config.OnPaymentSuccess,
+		onPaymentFailure:          config.OnPaymentFailure,
+		bindPayments:              config.BindPaymentToRequest,
+		bindResource:              config.BindPaymentToResource,
+		tokenStore:                config.TokenStore,
+		clock:                     clockOrSystem(config.Clock),
+		paidAcceptMode:            config.PaidAccept,
+		extraHeaders:              unreservedHeaders(config.ExtraHeaders),
+		health:                    health,
+		guard:                     newPaymentGuard(config),
+		attester:                  config.Attester,
+		declineLedger:             config.DeclineLedger,
+		onPaymentDeclined:         config.OnPaymentDeclined,
+		paymentRequiredCodes:      config.PaymentRequiredCodes,
+		detectSoftPaymentRequired: config.DetectSoftPaymentRequired,
+		metaNamespace:             config.MetaNamespace.OrDefault(),
+		verifyIntegrity:           config.VerifyResultIntegrity,
+		resultIntegritySigner:     config.ResultIntegritySigner,
+		outputSchemaAction:        config.OutputSchemaAction,
+		onOutputSchemaViolation:   config.OnOutputSchemaViolation,
+		refundRequester:           config.RefundRequester,
+		maxPaymentOverhead:        config.MaxPaymentOverhead,
+		maxDeadlineExtension:      config.MaxDeadlineExtension,
+		requirementsCache:         newRequirementsCache(config.RequirementsCacheTTL, clockOrSystem(config.Clock)),
+		trials:                    newTrialTracker(clockOrSystem(config.Clock)),
+		deposit:                   &depositToken{},
+		catalogPrices:             newCatalogPrices(),
+		catalogPriceChange:        config.CatalogPriceChange,
+		priorityFeeEscalation:     config.PriorityFeeEscalation,
+		finality:                  config.Finality,
+		onSettlementFinalized:     config.OnSettlementFinalized,
+		settlementPollInterval:    config.SettlementPollInterval,
+		pendingSettlements:        newPendingSettlements(),
+		identity:                  config.Identity,
+		manualPayments:            config.ManualPaymentMode,
+		manualPaymentTTL:          config.ManualPaymentTTL,
+		logger:                    defaultLogger(config.Logger),
+		verbose:                   config.Verbose,
+		logSampler:                NewLogSampler(config.LogSampleRate),
+		logRedaction:              config.LogRedaction,
+		monitor:                   newWalletMonitor(config),
+		paymentCodec:              config.PaymentCodec,
+		logToHost:                 config.LogPaymentsToHost,
+		onUnhandledSSEEvent:       config.OnUnhandledSSEEvent,
+		sseEvents:                 newSSEEventCounter(),
+		metrics:                   newPaymentMetrics(),
+	}
+
+	t.initSession()
+	if health := handlerConfig.SignerHealth; health != nil {
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			health.run(t.closed)
+		}()
 	}
+	return t, nil
+}
 
+// initSession resets the state of the transport's MCP session
+func (t *X402Transport) initSession() {
+	t.state.Store(int32(TransportNotStarted))
+	t.spend = &SpendTally{}
+	t.closed = make(chan struct{})
+	t.initialized = make(chan struct{})
+	t.pending = make(map[string]pendingPayment)
+	t.payments = newPaymentTracker()
 	t.sessionID.Store("")
 	t.protocolVersion.Store("")
+	t.clientInfo.Store(mcp.Implementation{})
+}
 
-	return t, nil
+// NewSession returns a transport for another MCP session with the same server.
+// It shares this transport's HTTP client, signers, budget, network health,
+// payment safeguards, callbacks and token store, so multi-tenant hosts can
+// cheaply open one session per tenant. Each session is started, initialized and
+// closed on its own; closing one leaves the others open.
+func (t *X402Transport) NewSession() *X402Transport {
+	session := &X402Transport{
+		serverURL:                 t.serverURL,
+		httpClient:                t.httpClient,
+		handler:                   t.handler,
+		onPaymentAttempt:          t.onPaymentAttempt,
+		onPaymentSuccess:          t.onPaymentSuccess,
+		onPaymentFailure:          t.onPaymentFailure,
+		bindPayments:              t.bindPayments,
+		bindResource:              t.bindResource,
+		tokenStore:                t.tokenStore,
+		clock:                     t.clock,
+		paidAcceptMode:            t.paidAcceptMode,
+		extraHeaders:              t.extraHeaders,
+		health:                    t.health,
+		guard:                     t.guard,
+		attester:                  t.attester,
+		declineLedger:             t.declineLedger,
+		onPaymentDeclined:         t.onPaymentDeclined,
+		paymentRequiredCodes:      t.paymentRequiredCodes,
+		detectSoftPaymentRequired: t.detectSoftPaymentRequired,
+		metaNamespace:             t.metaNamespace,
+		verifyIntegrity:           t.verifyIntegrity,
+		resultIntegritySigner:     t.resultIntegritySigner,
+		outputSchemaAction:        t.outputSchemaAction,
+		onOutputSchemaViolation:   t.onOutputSchemaViolation,
+		refundRequester:           t.refundRequester,
+		maxPaymentOverhead:        t.maxPaymentOverhead,
+		maxDeadlineExtension:      t.maxDeadlineExtension,
+		requirementsCache:         t.requirementsCache,
+		trials:                    t.trials,
+		deposit:                   t.deposit,
+		catalogPrices:             t.catalogPrices,
+		catalogPriceChange:        t.catalogPriceChange,
+		priorityFeeEscalation:     t.priorityFeeEscalation,
+		finality:                  t.finality,
+		onSettlementFinalized:     t.onSettlementFinalized,
+		settlementPollInterval:    t.settlementPollInterval,
+		pendingSettlements:        t.pendingSettlements,
+		identity:                  t.identity,
+		manualPayments:            t.manualPayments,
+		manualPaymentTTL:          t.manualPaymentTTL,
+		logger:                    t.logger,
+		verbose:                   t.verbose,
+		logSampler:                t.logSampler,
+		logRedaction:              t.logRedaction,
+		monitor:                   t.monitor,
+		paymentCodec:              t.paymentCodec,
+		paymentRecorder:           t.paymentRecorder,
+		logToHost:                 t.logToHost,
+		onUnhandledSSEEvent:       t.onUnhandledSSEEvent,
+		sseEvents:                 t.sseEvents,
+		metrics:                   t.metrics,
+	}
+	session.initSession()
+	return session
 }
 
-// Start implements transport.Interface
+// Start implements transport.Interface. Like StreamableHTTP it opens no
+// persistent connection; it only moves the transport to TransportStarted.
+// Starting a started transport does nothing, and starting a closed one returns
+// ErrTransportClosed. Requests sent before Start start the transport.
 func (t *X402Transport) Start(ctx context.Context) error {
-	// Similar to StreamableHTTP, we don't need persistent connection
-	return nil
+	return t.ensureStarted()
 }
 
-// Close implements transport.Interface
+// Close implements transport.Interface. It first flushes payment records (see
+// Flush), waiting up to 5s for payments in progress, and returns any flush error.
+// New requests fail with ErrTransportClosed as soon as Close is called; closing
+// again does nothing.
 func (t *X402Transport) Close() error {
-	select {
-	case <-t.closed:
+	if !t.markClosed() {
 		return nil
-	default:
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), closeFlushTimeout)
+	flushErr := t.Flush(ctx)
+	cancel()
+
 	close(t.closed)
 
+	// Drop payments awaiting approval
+	t.pendingMu.Lock()
+	t.pending = make(map[string]pendingPayment)
+	t.pendingMu.Unlock()
+
 	// Send session close if we have a session
 	if sessionIDVal := t.sessionID.Load(); sessionIDVal != nil {
 		if sessionID, ok := sessionIDVal.(string); ok && sessionID != "" {
@@ -181,6 +677,7 @@ func (t *X402Transport) Close() error {
 					return
 				}
 
+				t.setExtraHeaders(ctx, req)
 				req.Header.Set(transport.HeaderKeySessionID, sessionID)
 				if versionVal := t.protocolVersion.Load(); versionVal != nil {
 					if version, ok := versionVal.(string); ok && version != "" {
@@ -197,7 +694,40 @@ func (t *X402Transport) Close() error {
 	}
 
 	t.wg.Wait()
-	return nil
+	return flushErr
+}
+
+// ServerCapability returns the x402 capability the server advertised in its
+// initialize result, if any
+func (t *X402Transport) ServerCapability() (*Capability, bool) {
+	capability := t.capability.Load()
+	return capability, capability != nil
+}
+
+// useHeaderFlow reports whether to pay in the X-PAYMENT header. The flow the server
+// advertised wins over the one detected from how its 402 was delivered.
+func (t *X402Transport) useHeaderFlow(detected bool) bool {
+	capability := t.capability.Load()
+	if capability == nil {
+		return detected
+	}
+	if detected && !capability.SupportsFlow(FlowHeader) && capability.SupportsFlow(FlowMeta) {
+		return false
+	}
+	if !detected && !capability.SupportsFlow(FlowMeta) && capability.SupportsFlow(FlowHeader) {
+		return true
+	}
+	return detected
+}
+
+// SignerHealth returns the signer health tracker, nil unless Config.SignerHealth is set
+func (t *X402Transport) SignerHealth() *SignerHealth {
+	return t.handler.config.SignerHealth
+}
+
+// NetworkHealth returns the per-network settlement statistics tracked by the transport
+func (t *X402Transport) NetworkHealth() *NetworkHealth {
+	return t.health
 }
 
 // SetProtocolVersion implements transport.Interface
@@ -210,6 +740,29 @@ var ErrSessionTerminated = errors.New("session terminated (404). need to re-init
 
 // SendRequest implements transport.Interface with x402 payment handling
 func (t *X402Transport) SendRequest(ctx context.Context, request transport.JSONRPCRequest) (*transport.JSONRPCResponse, error) {
+	if err := t.ensureStarted(); err != nil {
+		return nil, err
+	}
+
+	// Attach the payer identity claim, if configured
+	if t.identity != nil {
+		var err error
+		if request, err = t.attachIdentity(ctx, request); err != nil {
+			return nil, err
+		}
+	}
+
+	// Draw tool calls from the deposit, if one was paid
+	request, err := t.attachDeposit(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// Name the end user an organization wallet pays for, if any
+	if request, err = attachOnBehalfOf(ctx, request); err != nil {
+		return nil, err
+	}
+
 	// Marshal request
 	requestBody, err := json.Marshal(request)
 	if err != nil {
@@ -219,8 +772,13 @@ func (t *X402Transport) SendRequest(ctx context.Context, request transport.JSONR
 	ctx, cancel := t.contextAwareOfClientClose(ctx)
 	defer cancel()
 
+	// Pay tools whose requirements are cached without probing for the 402
+	if resp, ok, err := t.sendWithCachedRequirements(ctx, request); ok {
+		return resp, err
+	}
+
 	// Try request without payment first
-	resp, err := t.sendHTTP(ctx, http.MethodPost, bytes.NewReader(requestBody), "application/json, text/event-stream")
+	resp, err := t.sendHTTP(ctx, http.MethodPost, bytes.NewReader(requestBody), requestAccept(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
@@ -232,11 +790,31 @@ func (t *X402Transport) SendRequest(ctx context.Context, request transport.JSONR
 		return nil, err
 	}
 
+	if request.Method == string(mcp.MethodInitialize) && jsonrpcResp.Error == nil {
+		if capability, ok := ParseCapability(jsonrpcResp.Result); ok {
+			t.capability.Store(capability)
+		}
+	}
+	if request.Method == string(mcp.MethodToolsList) && jsonrpcResp.Error == nil {
+		t.catalogPrices.record(jsonrpcResp.Result)
+	}
+	t.trials.observe(request, jsonrpcResp)
+
+	t.normalizeSoftPaymentRequired(jsonrpcResp)
+
 	// Check for JSON-RPC 402 error (payment required)
-	if jsonrpcResp.Error != nil && jsonrpcResp.Error.Code == 402 {
-		paymentResp, err := t.handlePaymentRequired(ctx, jsonrpcResp.Error, request, useHTTPHeaders)
+	if t.isPaymentRequired(jsonrpcResp.Error) {
+		// The paid retry is a fresh POST. End the unpaid response first: a 402
+		// sent as an SSE event leaves its stream, and the goroutine reading it,
+		// open until the server closes it.
+		resp.Body.Close()
+
+		paymentCtx, cancel := t.paymentContext(ctx)
+		defer cancel()
+
+		paymentResp, err := t.handlePaymentRequired(paymentCtx, jsonrpcResp.Error, request, t.useHeaderFlow(useHTTPHeaders))
 		if err != nil {
-			return nil, err
+			return nil, paymentOverheadError(ctx, paymentCtx, err)
 		}
 		return paymentResp, nil
 	}
@@ -244,31 +822,142 @@ func (t *X402Transport) SendRequest(ctx context.Context, request transport.JSONR
 	return jsonrpcResp, nil
 }
 
+// paymentContext returns the context for paying and retrying a request,
+// bounded by MaxPaymentOverhead if set, extended by server hints up to
+// MaxDeadlineExtension
+func (t *X402Transport) paymentContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t.maxPaymentOverhead <= 0 {
+		return ctx, func() {}
+	}
+	if t.maxDeadlineExtension <= 0 {
+		return context.WithTimeout(ctx, t.maxPaymentOverhead)
+	}
+	return newPaymentDeadline(ctx, t.maxPaymentOverhead, t.maxDeadlineExtension)
+}
+
+// paymentOverheadError marks err as ErrPaymentOverheadExceeded when the payment
+// sub-deadline, rather than the caller's context, ended the payment
+func paymentOverheadError(ctx, paymentCtx context.Context, err error) error {
+	if ctx.Err() == nil && errors.Is(context.Cause(paymentCtx), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %w", ErrPaymentOverheadExceeded, err)
+	}
+	return err
+}
+
 // handlePaymentRequired handles 402 errors by creating payment and retrying
 // If useHTTPHeaders is true, sends payment in X-PAYMENT header (HTTP 402 transport)
 // If useHTTPHeaders is false, sends payment in params._meta (JSON-RPC 402 transport)
 func (t *X402Transport) handlePaymentRequired(ctx context.Context, rpcError *mcp.JSONRPCErrorDetails, originalRequest transport.JSONRPCRequest, useHTTPHeaders bool) (*transport.JSONRPCResponse, error) {
 	// Parse payment requirements from error.data
-	requirementsData, err := json.Marshal(rpcError.Data)
+	requirements, err := parsePaymentRequirements(rpcError)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payment requirements: %w", err)
+		return nil, err
 	}
-
-	var requirements PaymentRequirementsResponse
-	if err := json.Unmarshal(requirementsData, &requirements); err != nil {
-		return nil, fmt.Errorf("failed to parse payment requirements: %w", err)
+	requirements, err = t.validRequirements(requirements)
+	if err != nil {
+		return nil, err
 	}
+	t.requirementsCache.put(cacheableTool(originalRequest), requirements, useHTTPHeaders)
+
+	return t.payRequirements(ctx, requirements, originalRequest, useHTTPHeaders)
+}
+
+// payRequirements pays one of requirements and retries the original request with the payment
+func (t *X402Transport) payRequirements(ctx context.Context, requirements PaymentRequirementsResponse, originalRequest transport.JSONRPCRequest, useHTTPHeaders bool) (*transport.JSONRPCResponse, error) {
+	t.payments.begin()
+	defer t.payments.end()
+
+	var err error
+
+	// Wait longer for servers announcing a slow settlement
+	t.extendPaymentDeadline(ctx, requirements.SettlementSeconds, "slow settlement announced")
 
 	// Record payment attempt
-	t.recordPaymentEvent(PaymentEventAttempt, originalRequest.Method, requirements)
+	ctx = t.withLogSample(ctx)
+	ctx = WithRequestMethod(ctx, originalRequest.Method)
+	if tool := cacheableTool(originalRequest); tool != "" {
+		ctx = WithRequestTool(ctx, tool)
+	}
+	t.recordPaymentEvent(ctx, PaymentEventAttempt, originalRequest.Method, requirements)
 
-	// Create and sign payment
+	// Never pay more than the quote attached to the request
+	if quote := requestQuote(originalRequest); quote != nil {
+		accepts, err := quote.capAccepts(requirements.Accepts)
+		if err != nil {
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+			return nil, err
+		}
+		requirements.Accepts = accepts
+	}
+
+	// Compare the price with the one advertised in the tool catalog
+	accepts, err := t.checkCatalogPrices(originalRequest, requirements.Accepts)
+	if err != nil {
+		t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+		t.recordDecline(ctx, originalRequest.Method, requirements, err)
+		return nil, err
+	}
+	requirements.Accepts = accepts
+
+	// Commit the payment nonce to this request, and to the server origin and
+	// resource, if binding is enabled or the server requires it
+	var bindingSalt, bindingNonce string
+	var requestDigest, resourceDigest []byte
+	if t.bindPayments {
+		requestDigest, err = RequestBindingDigest(originalRequest.Method, originalRequest.Params)
+		if err != nil {
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+			return nil, fmt.Errorf("failed to bind payment to request: %w", err)
+		}
+	}
+	if t.bindResource || requiresResourceBinding(requirements.Accepts) {
+		resourceDigest = ResourceBindingDigest(serverOrigin(t.serverURL), paidResource(requirements))
+	}
+	if requestDigest != nil || resourceDigest != nil {
+		bindingSalt, bindingNonce, err = newPaymentBinding(PaymentBindingDigest(requestDigest, resourceDigest))
+		if err != nil {
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+			return nil, fmt.Errorf("failed to bind payment: %w", err)
+		}
+		ctx = WithBindingNonce(ctx, bindingNonce)
+	}
+
+	// Refuse to pay servers and resources that rejected earlier payments
+	ctx = withPaymentCount(ctx)
+	ctx = withFeeEstimate(ctx)
+	if err := t.guard.admit(ctx, paidResource(requirements)); err != nil {
+		t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+		t.recordDecline(ctx, originalRequest.Method, requirements, err)
+		return nil, err
+	}
+
+	// Hand the decision to the caller in manual payment mode
+	if t.manualPayments {
+		return nil, t.deferPayment(originalRequest, requirements, bindingSalt, bindingNonce, useHTTPHeaders)
+	}
+
+	// Create and sign payment, holding its budget until the server takes it
+	// and recording the option it pays for logs and costs
+	ctx = withBudgetReservation(ctx)
+	ctx = withSelection(ctx)
 	payment, err := t.handler.CreatePayment(ctx, requirements)
+	if t.logSampled(ctx) {
+		// Explained from the payment made, as asking the strategy again could advance it
+		t.logger.Printf("[X402] Selected payment for %s:\n%s", originalRequest.Method, t.handler.explainPaid(ctx, requirements.Accepts).redacted(t.logRedaction))
+	}
 	if err != nil {
-		t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements, err)
+		t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
+		t.recordDecline(ctx, originalRequest.Method, requirements, err)
 		return nil, fmt.Errorf("failed to create payment: %w", err)
 	}
 
+	return t.sendEscalatingPriorityFee(ctx, originalRequest, requirements, payment, bindingSalt, useHTTPHeaders)
+}
+
+// sendWithPayment retries the original request carrying a signed payment and processes the settlement
+func (t *X402Transport) sendWithPayment(ctx context.Context, originalRequest transport.JSONRPCRequest, requirements PaymentRequirementsResponse, payment *PaymentPayload, bindingSalt string, useHTTPHeaders bool) (*transport.JSONRPCResponse, error) {
+	start := time.Now()
+
 	var resp *http.Response
 	if useHTTPHeaders {
 		// HTTP 402 transport: send payment in X-PAYMENT header
@@ -277,77 +966,266 @@ func (t *X402Transport) handlePaymentRequired(ctx context.Context, rpcError *mcp
 			return nil, fmt.Errorf("failed to marshal request: %w", err)
 		}
 
-		// Marshal payment to JSON and encode as base64
-		paymentJSON, err := json.Marshal(payment)
+		// Encode payment with the codec (JSON by default) as base64
+		paymentHeader, err := payment.EncodeWith(t.paymentCodec)
 		if err != nil {
-			t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements, err)
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
 			return nil, fmt.Errorf("failed to marshal payment: %w", err)
 		}
-		paymentHeader := base64.StdEncoding.EncodeToString(paymentJSON)
 
 		headers := map[string]string{
 			"X-PAYMENT": paymentHeader,
 		}
+		if !IsJSONCodec(t.paymentCodec) {
+			headers[HeaderPaymentEncoding] = t.paymentCodec.ContentType()
+		}
+		if bindingSalt != "" {
+			headers[HeaderPaymentBinding] = bindingSalt
+		}
+		if reference := PaymentReferenceFromContext(ctx); reference != "" {
+			headers[HeaderPaymentReference] = reference
+		}
 
-		resp, err = t.sendHTTPWithHeaders(ctx, http.MethodPost, bytes.NewReader(requestBody), "application/json, text/event-stream", headers)
+		resp, err = t.sendHTTPWithHeaders(ctx, http.MethodPost, bytes.NewReader(requestBody), t.paidAccept(ctx), headers)
 		if err != nil {
-			t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements, err)
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
 			return nil, fmt.Errorf("failed to send payment request: %w", err)
 		}
 	} else {
 		// JSON-RPC 402 transport: inject payment into request params._meta
-		modifiedRequest, err := t.injectPaymentIntoRequest(originalRequest, payment)
+		modifiedRequest, err := t.injectPaymentIntoRequest(originalRequest, payment, bindingSalt)
 		if err != nil {
-			t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements, err)
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
 			return nil, fmt.Errorf("failed to inject payment: %w", err)
 		}
+		if reference := PaymentReferenceFromContext(ctx); reference != "" {
+			if modifiedRequest, err = setRequestMeta(modifiedRequest, map[string]any{MetaKeyReference: reference}); err != nil {
+				return nil, fmt.Errorf("failed to inject payment reference: %w", err)
+			}
+		}
 
 		requestBody, err := json.Marshal(modifiedRequest)
 		if err != nil {
 			return nil, fmt.Errorf("failed to marshal request with payment: %w", err)
 		}
 
-		resp, err = t.sendHTTP(ctx, http.MethodPost, bytes.NewReader(requestBody), "application/json, text/event-stream")
+		resp, err = t.sendHTTP(ctx, http.MethodPost, bytes.NewReader(requestBody), t.paidAccept(ctx))
 		if err != nil {
-			t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements, err)
+			t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
 			return nil, fmt.Errorf("failed to send payment request: %w", err)
 		}
 	}
 	defer resp.Body.Close()
 
 	// Process response
-	jsonrpcResp, _, err := t.processResponse(ctx, resp, originalRequest)
+	jsonrpcResp, paidWithHTTPHeaders, err := t.processResponse(ctx, resp, originalRequest)
 	if err != nil {
-		t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements, err)
+		t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements, err)
 		return nil, err
 	}
 
+	t.normalizeSoftPaymentRequired(jsonrpcResp)
+
+	// A server rejecting a payment for cached requirements may answer with a new
+	// 402 or find the payment doesn't match its requirements
+	if isProactivePayment(ctx) && jsonrpcResp.Error != nil && !isAccessFee(requirements) {
+		if t.isPaymentRequired(jsonrpcResp.Error) {
+			return nil, &staleRequirementsError{rpcError: jsonrpcResp.Error, useHTTPHeaders: paidWithHTTPHeaders}
+		}
+		if jsonrpcResp.Error.Code == mcp.INVALID_PARAMS {
+			return nil, &staleRequirementsError{}
+		}
+	}
+
 	// Check if payment was accepted
-	if jsonrpcResp.Error != nil && jsonrpcResp.Error.Code == 402 {
-		t.recordPaymentError(PaymentEventFailure, originalRequest.Method, requirements,
+	if t.isPaymentRequired(jsonrpcResp.Error) {
+		resp.Body.Close()
+
+		// Paying the session access fee may uncover the request's own price
+		if isAccessFee(requirements) {
+			if next, err := parsePaymentRequirements(jsonrpcResp.Error); err == nil && !isAccessFee(next) {
+				t.recordPaymentEvent(ctx, PaymentEventSuccess, originalRequest.Method, paidRequirements(ctx, requirements, payment))
+				recordCost(ctx, requirements, payment)
+				t.health.RecordSuccess(payment.Network, time.Since(start))
+				t.guard.accepted(paidResource(requirements))
+				settleBudgetReservation(ctx, true)
+				return t.handlePaymentRequired(ctx, jsonrpcResp.Error, originalRequest, useHTTPHeaders)
+			}
+		}
+		t.guard.rejected(paidResource(requirements))
+		t.recordPaymentError(ctx, PaymentEventFailure, originalRequest.Method, requirements,
 			fmt.Errorf("payment rejected: server returned 402 after payment"))
-		return nil, fmt.Errorf("payment rejected by server")
+		return nil, ErrPaymentRejected
+	}
+
+	// Track settlement health for the paid network. Errors other than failed
+	// settlements, such as the tool's own, say nothing about the network.
+	if jsonrpcResp.Error == nil {
+		recordCost(ctx, requirements, payment)
+		t.health.RecordSuccess(payment.Network, time.Since(start))
+		t.guard.accepted(paidResource(requirements))
+	} else if failure, ok := parseSettlementFailure(jsonrpcResp.Error); ok && (failure.Network == "" || failure.Network == payment.Network) {
+		t.health.RecordFailure(payment.Network)
 	}
 
 	// Extract settlement response from result._meta or X-PAYMENT-RESPONSE header
 	if jsonrpcResp.Error == nil {
+		paid := paidRequirements(ctx, requirements, payment)
 		if useHTTPHeaders {
 			// For HTTP transport, check X-PAYMENT-RESPONSE header
 			if paymentRespHeader := resp.Header.Get("X-PAYMENT-RESPONSE"); paymentRespHeader != "" {
-				t.extractAndRecordHTTPSettlement(paymentRespHeader, originalRequest.Method, requirements)
+				t.extractAndRecordHTTPSettlement(ctx, paymentRespHeader, originalRequest.Method, paid)
 			}
 		} else {
 			// For JSON-RPC transport, check result._meta
-			t.extractAndRecordSettlement(jsonrpcResp, originalRequest.Method, requirements)
+			t.extractAndRecordSettlement(ctx, jsonrpcResp, originalRequest.Method, paid)
+		}
+		if t.verifyIntegrity {
+			if err := t.verifyResultIntegrity(jsonrpcResp); err != nil {
+				return nil, err
+			}
+		}
+		if err := t.checkOutputSchema(ctx, originalRequest, jsonrpcResp, requirements, payment); err != nil {
+			return nil, err
 		}
 	}
 
 	return jsonrpcResp, nil
 }
 
-// injectPaymentIntoRequest adds payment data to request params._meta
-func (t *X402Transport) injectPaymentIntoRequest(request transport.JSONRPCRequest, payment *PaymentPayload) (transport.JSONRPCRequest, error) {
-	// We need to add _meta["x402/payment"] to the params
+// paymentTaken reports whether the server may have settled the payment a
+// request carried, judging by the request's outcome. Payments it rejected,
+// found not to match its requirements or failed to settle were not taken.
+func paymentTaken(response *transport.JSONRPCResponse, err error) bool {
+	var stale *staleRequirementsError
+	if errors.As(err, &stale) || errors.Is(err, ErrPaymentRejected) {
+		return false
+	}
+	if err == nil && response != nil && response.Error != nil {
+		if _, failed := parseSettlementFailure(response.Error); failed {
+			return false
+		}
+		return response.Error.Code != mcp.INVALID_PARAMS
+	}
+	return true
+}
+
+// isPaymentRequired reports whether a JSON-RPC error asks for payment: its code is
+// 402, or its data carries x402 requirements and either its code is the server's
+// advertised code or one of PaymentRequiredCodes, or it has an x402Version or a
+// "payment required" message. Other codes may be shared with errors such as a
+// busy server, so they don't count without requirements.
+func (t *X402Transport) isPaymentRequired(rpcError *mcp.JSONRPCErrorDetails) bool {
+	if rpcError == nil {
+		return false
+	}
+	if rpcError.Code == 402 {
+		return true
+	}
+
+	if rpcError.Data == nil {
+		return false
+	}
+	requirements, err := parsePaymentRequirements(rpcError)
+	if err != nil || len(requirements.Accepts) == 0 {
+		return false
+	}
+	if t.isPaymentRequiredCode(rpcError.Code) {
+		return true
+	}
+	return requirements.X402Version > 0 || strings.Contains(strings.ToLower(rpcError.Message), "payment required")
+}
+
+// isPaymentRequiredCode reports whether code is the server's advertised payment
+// required code or one of PaymentRequiredCodes
+func (t *X402Transport) isPaymentRequiredCode(code int) bool {
+	if capability := t.capability.Load(); capability != nil && capability.PaymentRequiredCode != 0 && code == capability.PaymentRequiredCode {
+		return true
+	}
+	return slices.Contains(t.paymentRequiredCodes, code)
+}
+
+// normalizeSoftPaymentRequired turns a successful result carrying requirements in
+// _meta["x402/payment-required"] into a 402 error, if detection is enabled
+func (t *X402Transport) normalizeSoftPaymentRequired(response *transport.JSONRPCResponse) {
+	if !t.detectSoftPaymentRequired || response.Error != nil || len(response.Result) == 0 {
+		return
+	}
+
+	var result struct {
+		Meta map[string]json.RawMessage `json:"_meta"`
+	}
+	if err := json.Unmarshal(response.Result, &result); err != nil {
+		return
+	}
+	raw, ok := result.Meta[MetaKeyPaymentRequired]
+	if !ok {
+		return
+	}
+
+	var requirements PaymentRequirementsResponse
+	if err := json.Unmarshal(raw, &requirements); err != nil || len(requirements.Accepts) == 0 {
+		return
+	}
+	response.Error = &mcp.JSONRPCErrorDetails{
+		Code:    402,
+		Message: "Payment required",
+		Data:    requirements,
+	}
+	response.Result = nil
+}
+
+// parsePaymentRequirements decodes payment requirements from a 402 error's data
+func parsePaymentRequirements(rpcError *mcp.JSONRPCErrorDetails) (PaymentRequirementsResponse, error) {
+	var requirements PaymentRequirementsResponse
+
+	requirementsData, err := json.Marshal(rpcError.Data)
+	if err != nil {
+		return requirements, fmt.Errorf("failed to marshal payment requirements: %w", err)
+	}
+
+	if err := json.Unmarshal(requirementsData, &requirements); err != nil {
+		return requirements, fmt.Errorf("failed to parse payment requirements: %w", err)
+	}
+	return requirements, nil
+}
+
+// isAccessFee reports whether the requirements are for the server's session access fee
+func isAccessFee(reqs PaymentRequirementsResponse) bool {
+	return len(reqs.Accepts) > 0 && reqs.Accepts[0].Resource == AccessResource
+}
+
+// injectPaymentIntoRequest adds payment data (and the binding salt, if any) to request params._meta
+func (t *X402Transport) injectPaymentIntoRequest(request transport.JSONRPCRequest, payment *PaymentPayload, bindingSalt string) (transport.JSONRPCRequest, error) {
+	fields := map[string]any{t.metaNamespace.Payment: payment}
+	if bindingSalt != "" {
+		fields[MetaKeyBinding] = bindingSalt
+	}
+	return setRequestMeta(request, fields)
+}
+
+// attachIdentity adds the payer identity claim to request params._meta.
+// The MCP client info is captured from initialize and included in the claim.
+func (t *X402Transport) attachIdentity(ctx context.Context, request transport.JSONRPCRequest) (transport.JSONRPCRequest, error) {
+	if request.Method == string(mcp.MethodInitialize) {
+		paramsBytes, err := json.Marshal(request.Params)
+		if err == nil {
+			var params mcp.InitializeParams
+			if json.Unmarshal(paramsBytes, &params) == nil {
+				t.clientInfo.Store(params.ClientInfo)
+			}
+		}
+	}
+
+	claim, err := t.identity.IdentityClaim(ctx, t.serverURL.String(), t.clientInfo.Load().(mcp.Implementation))
+	if err != nil {
+		return request, fmt.Errorf("failed to create identity claim: %w", err)
+	}
+	return setRequestMeta(request, map[string]any{MetaKeyIdentity: claim})
+}
+
+// setRequestMeta merges fields into request params._meta
+func setRequestMeta(request transport.JSONRPCRequest, fields map[string]any) (transport.JSONRPCRequest, error) {
 	// The params could be any type, so we need to handle it carefully
 
 	// Marshal params to JSON
@@ -361,6 +1239,9 @@ func (t *X402Transport) injectPaymentIntoRequest(request transport.JSONRPCReques
 	if err := json.Unmarshal(paramsBytes, &paramsMap); err != nil {
 		return request, fmt.Errorf("failed to unmarshal params: %w", err)
 	}
+	if paramsMap == nil {
+		paramsMap = make(map[string]any)
+	}
 
 	// Get or create _meta field
 	var meta map[string]any
@@ -371,8 +1252,9 @@ func (t *X402Transport) injectPaymentIntoRequest(request transport.JSONRPCReques
 		meta = make(map[string]any)
 	}
 
-	// Add payment to _meta
-	meta["x402/payment"] = payment
+	for key, value := range fields {
+		meta[key] = value
+	}
 	paramsMap["_meta"] = meta
 
 	// Update request
@@ -381,7 +1263,7 @@ func (t *X402Transport) injectPaymentIntoRequest(request transport.JSONRPCReques
 }
 
 // extractAndRecordSettlement extracts settlement response from result._meta and records success
-func (t *X402Transport) extractAndRecordSettlement(response *transport.JSONRPCResponse, method string, reqs PaymentRequirementsResponse) {
+func (t *X402Transport) extractAndRecordSettlement(ctx context.Context, response *transport.JSONRPCResponse, method string, reqs PaymentRequirementsResponse) {
 	// Parse result to extract _meta
 	var resultMap map[string]any
 	if err := json.Unmarshal(response.Result, &resultMap); err != nil {
@@ -399,8 +1281,8 @@ func (t *X402Transport) extractAndRecordSettlement(response *transport.JSONRPCRe
 		return
 	}
 
-	// Extract x402/payment-response
-	paymentRespField, exists := meta["x402/payment-response"]
+	// Extract the settlement response
+	paymentRespField, exists := t.metaNamespace.LookupPaymentResponse(meta)
 	if !exists {
 		return
 	}
@@ -418,12 +1300,14 @@ func (t *X402Transport) extractAndRecordSettlement(response *transport.JSONRPCRe
 
 	// Record success if settlement was successful
 	if settlementResp.Success {
-		t.recordPaymentEvent(PaymentEventSuccess, method, reqs)
+		t.recordPaymentEvent(ctx, PaymentEventSuccess, method, reqs)
+		t.watchFinality(ctx, method, reqs, settlementResp)
+		t.watchSettlement(ctx, method, reqs, settlementResp)
 	}
 }
 
 // extractAndRecordHTTPSettlement extracts settlement response from X-PAYMENT-RESPONSE header and records success
-func (t *X402Transport) extractAndRecordHTTPSettlement(paymentRespHeader string, method string, reqs PaymentRequirementsResponse) {
+func (t *X402Transport) extractAndRecordHTTPSettlement(ctx context.Context, paymentRespHeader string, method string, reqs PaymentRequirementsResponse) {
 	// Decode base64 header
 	paymentRespBytes, err := base64.StdEncoding.DecodeString(paymentRespHeader)
 	if err != nil {
@@ -438,7 +1322,9 @@ func (t *X402Transport) extractAndRecordHTTPSettlement(paymentRespHeader string,
 
 	// Record success if settlement was successful
 	if settlementResp.Success {
-		t.recordPaymentEvent(PaymentEventSuccess, method, reqs)
+		t.recordPaymentEvent(ctx, PaymentEventSuccess, method, reqs)
+		t.watchFinality(ctx, method, reqs, settlementResp)
+		t.watchSettlement(ctx, method, reqs, settlementResp)
 	}
 }
 
@@ -502,18 +1388,8 @@ func (t *X402Transport) processResponse(ctx context.Context, resp *http.Response
 		return nil, false, fmt.Errorf("request failed with status %d: %s", resp.StatusCode, body)
 	}
 
-	if request.Method == string(mcp.MethodInitialize) {
-		// Save the received session ID in the response
-		if sessionID := resp.Header.Get(transport.HeaderKeySessionID); sessionID != "" {
-			t.sessionID.Store(sessionID)
-		}
-
-		t.initializedOnce.Do(func() {
-			close(t.initialized)
-		})
-	}
-
 	// Handle different response types
+	var jsonrpcResp *transport.JSONRPCResponse
 	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	switch mediaType {
 	case "application/json":
@@ -527,16 +1403,34 @@ func (t *X402Transport) processResponse(ctx context.Context, resp *http.Response
 		if response.ID.IsNil() {
 			return nil, false, fmt.Errorf("response should contain RPC id: %v", response)
 		}
-
-		return &response, false, nil
+		jsonrpcResp = &response
 
 	case "text/event-stream":
 		// Server is using SSE for streaming responses
-		return t.handleSSEResponse(ctx, resp.Body, false)
+		response, _, err := t.handleSSEResponse(ctx, resp.Body, false)
+		if err != nil {
+			return nil, false, err
+		}
+		jsonrpcResp = response
 
 	default:
 		return nil, false, fmt.Errorf("unexpected content type: %s", resp.Header.Get("Content-Type"))
 	}
+
+	// A 402 for initialize opens no session, whether sent as a JSON body or an
+	// SSE event, so the paid retry initializes without the unpaid attempt's ID
+	if request.Method == string(mcp.MethodInitialize) && !t.isPaymentRequired(jsonrpcResp.Error) {
+		// Save the received session ID in the response
+		if sessionID := resp.Header.Get(transport.HeaderKeySessionID); sessionID != "" {
+			t.sessionID.Store(sessionID)
+		}
+
+		t.initializedOnce.Do(func() {
+			close(t.initialized)
+		})
+	}
+
+	return jsonrpcResp, false, nil
 }
 
 // sendHTTP sends an HTTP request with standard headers (similar to StreamableHTTP)
@@ -557,6 +1451,9 @@ func (t *X402Transport) sendHTTPWithHeaders(ctx context.Context, method string,
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
+	// Audit headers first, so the transport's own headers take precedence
+	t.setExtraHeaders(ctx, req)
+
 	// Set standard headers (thread-safe, each request gets its own headers)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", acceptType)
@@ -574,6 +1471,10 @@ func (t *X402Transport) sendHTTPWithHeaders(ctx context.Context, method string,
 		}
 	}
 
+	if pass := t.currentAccessPass(); pass != "" {
+		req.Header.Set(HeaderAccessPass, pass)
+	}
+
 	// Add extra headers
 	for k, v := range extraHeaders {
 		req.Header.Set(k, v)
@@ -584,6 +1485,7 @@ func (t *X402Transport) sendHTTPWithHeaders(ctx context.Context, method string,
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	t.captureAccessPass(resp)
 
 	// Universal handling for session terminated
 	if resp.StatusCode == http.StatusNotFound {
@@ -618,7 +1520,11 @@ func (t *X402Transport) handleSSEResponse(ctx context.Context, reader io.ReadClo
 			// Try to unmarshal as a response first
 			var message transport.JSONRPCResponse
 			if err := json.Unmarshal([]byte(data), &message); err != nil {
-				// Silently ignore unmarshal errors for SSE events
+				reason := SSEEventInvalidMessage
+				if event != "message" {
+					reason = SSEEventUnknownType
+				}
+				t.dropSSEEvent(event, data, reason, err)
 				return
 			}
 
@@ -626,8 +1532,16 @@ func (t *X402Transport) handleSSEResponse(ctx context.Context, reader io.ReadClo
 			if message.ID.IsNil() {
 				var notification mcp.JSONRPCNotification
 				if err := json.Unmarshal([]byte(data), &notification); err != nil {
+					t.dropSSEEvent(event, data, SSEEventInvalidNotification, err)
 					return
 				}
+				t.sseEvents.handled.Add(1)
+				if seconds, ok := settlementPendingHint(notification); ok {
+					t.extendPaymentDeadline(ctx, seconds, "settlement pending")
+				}
+				if status, ok := settlementStatusNotification(notification); ok {
+					t.pendingSettlements.resolve(status)
+				}
 				t.notifyMu.RLock()
 				if t.notificationHandler != nil {
 					t.notificationHandler(notification)
@@ -643,12 +1557,14 @@ func (t *X402Transport) handleSSEResponse(ctx context.Context, reader io.ReadClo
 					var request transport.JSONRPCRequest
 					if err := json.Unmarshal([]byte(data), &request); err == nil {
 						// This is a request from the server
+						t.sseEvents.handled.Add(1)
 						t.handleIncomingRequest(ctx, request)
 						return
 					}
 				}
 			}
 
+			t.sseEvents.handled.Add(1)
 			if !ignoreResponse {
 				responseChan <- &message
 			}
@@ -734,6 +1650,10 @@ func (t *X402Transport) readSSE(ctx context.Context, reader io.ReadCloser, handl
 
 // SendNotification implements transport.Interface
 func (t *X402Transport) SendNotification(ctx context.Context, notification mcp.JSONRPCNotification) error {
+	if err := t.ensureStarted(); err != nil {
+		return err
+	}
+
 	notificationBody, err := json.Marshal(notification)
 	if err != nil {
 		return fmt.Errorf("failed to marshal notification: %w", err)
@@ -787,7 +1707,7 @@ func (t *X402Transport) GetSessionId() string {
 // Helper methods for event recording
 
 // recordPaymentEvent records a payment event for callbacks and recording
-func (t *X402Transport) recordPaymentEvent(eventType PaymentEventType, method string, reqs PaymentRequirementsResponse) {
+func (t *X402Transport) recordPaymentEvent(ctx context.Context, eventType PaymentEventType, method string, reqs PaymentRequirementsResponse) {
 	if len(reqs.Accepts) == 0 {
 		return
 	}
@@ -800,15 +1720,19 @@ func (t *X402Transport) recordPaymentEvent(eventType PaymentEventType, method st
 	}
 
 	event := PaymentEvent{
-		Type:      eventType,
-		Resource:  req.Resource,
-		Method:    method,
-		Amount:    amount,
-		Network:   req.Network,
-		Asset:     req.Asset,
-		Recipient: req.PayTo,
-		Timestamp: time.Now().Unix(),
-	}
+		Type:         eventType,
+		Resource:     req.Resource,
+		Method:       method,
+		Amount:       amount,
+		Network:      req.Network,
+		Asset:        req.Asset,
+		Recipient:    req.PayTo,
+		Timestamp:    t.clock.Now().Unix(),
+		Attribution:  AttributionFromContext(ctx),
+		OnBehalfOf:   OnBehalfOfFromContext(ctx),
+		FiatEstimate: req.Extra[ExtraKeyFiatEstimate],
+	}
+	addFeeEstimate(ctx, &event)
 
 	switch eventType {
 	case PaymentEventAttempt:
@@ -816,9 +1740,20 @@ func (t *X402Transport) recordPaymentEvent(eventType PaymentEventType, method st
 			t.onPaymentAttempt(event)
 		}
 	case PaymentEventSuccess:
+		t.metrics.record(event)
+		if t.logSampled(ctx) {
+			t.logger.Printf("[X402] Paid %s %s on %s to %s for %s",
+				event.Amount, event.Asset, event.Network, t.logRedaction.Redact(event.Recipient), method)
+		}
 		if t.onPaymentSuccess != nil {
 			t.onPaymentSuccess(event)
 		}
+		if t.logToHost {
+			t.logPaymentToHost(event)
+		}
+		if t.monitor != nil {
+			t.notifySinks(t.monitor.paid(event))
+		}
 	}
 
 	if t.paymentRecorder != nil {
@@ -827,7 +1762,7 @@ func (t *X402Transport) recordPaymentEvent(eventType PaymentEventType, method st
 }
 
 // recordPaymentError records a payment error event for callbacks and recording
-func (t *X402Transport) recordPaymentError(eventType PaymentEventType, method string, reqs PaymentRequirementsResponse, err error) {
+func (t *X402Transport) recordPaymentError(ctx context.Context, eventType PaymentEventType, method string, reqs PaymentRequirementsResponse, err error) {
 	if len(reqs.Accepts) == 0 {
 		return
 	}
@@ -840,20 +1775,34 @@ func (t *X402Transport) recordPaymentError(eventType PaymentEventType, method st
 	}
 
 	event := PaymentEvent{
-		Type:      eventType,
-		Resource:  req.Resource,
-		Method:    method,
-		Amount:    amount,
-		Network:   req.Network,
-		Asset:     req.Asset,
-		Recipient: req.PayTo,
-		Error:     err,
-		Timestamp: time.Now().Unix(),
+		Type:         eventType,
+		Resource:     req.Resource,
+		Method:       method,
+		Amount:       amount,
+		Network:      req.Network,
+		Asset:        req.Asset,
+		Recipient:    req.PayTo,
+		Error:        err,
+		Timestamp:    t.clock.Now().Unix(),
+		Attribution:  AttributionFromContext(ctx),
+		OnBehalfOf:   OnBehalfOfFromContext(ctx),
+		FiatEstimate: req.Extra[ExtraKeyFiatEstimate],
+	}
+	addFeeEstimate(ctx, &event)
+
+	if t.verbose {
+		t.logger.Printf("[X402] Payment of %s on %s to %s for %s failed: %v",
+			event.Amount, event.Network, t.logRedaction.Redact(event.Recipient), method, err)
 	}
-
 	if t.onPaymentFailure != nil {
 		t.onPaymentFailure(event, err)
 	}
+	if t.logToHost && eventType == PaymentEventFailure {
+		t.logPaymentToHost(event)
+	}
+	if t.monitor != nil {
+		t.notifySinks(t.monitor.failed(err))
+	}
 
 	if t.paymentRecorder != nil {
 		t.paymentRecorder.Record(event)
@@ -8,12 +8,14 @@ import (
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/stretchr/testify/assert"
@@ -391,6 +393,57 @@ func TestX402Transport_MultipleRequests(t *testing.T) {
 	}
 }
 
+func TestX402Transport_Lifecycle(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{ServerURL: server.URL, Signer: NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())})
+	require.NoError(t, err)
+	assert.Equal(t, TransportNotStarted, trans.State())
+
+	// Handlers can be registered before Start, and the first requests start the transport
+	trans.SetNotificationHandler(func(mcp.JSONRPCNotification) {})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{ID: mcp.NewRequestId(1), Method: "ping"})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, TransportStarted, trans.State())
+	assert.Equal(t, int32(10), requests.Load())
+	require.NoError(t, trans.Start(context.Background()), "Start is idempotent")
+
+	// Concurrent closes close once; later calls fail clearly
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, trans.Close())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, TransportClosed, trans.State())
+	assert.Equal(t, "closed", trans.State().String())
+
+	_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{ID: mcp.NewRequestId(2), Method: "ping"})
+	assert.ErrorIs(t, err, ErrTransportClosed)
+	assert.ErrorIs(t, trans.SendNotification(context.Background(), mcp.JSONRPCNotification{}), ErrTransportClosed)
+	assert.ErrorIs(t, trans.Start(context.Background()), ErrTransportClosed)
+	assert.Equal(t, int32(10), requests.Load())
+
+	// A new session starts over
+	assert.Equal(t, TransportNotStarted, trans.NewSession().State())
+}
+
 func TestX402Transport_SendRequestWithTimeout(t *testing.T) {
 	// Server that delays response
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -909,3 +962,2942 @@ func TestSolanaPaymentFlow(t *testing.T) {
 		t.Fatal("Payload should be a map[string]any")
 	}
 }
+
+func TestX402Transport_BindPaymentToRequest(t *testing.T) {
+	var requestCount int
+	var paidRequest transport.JSONRPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if requestCount == 1 {
+			response := create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Error:       "Payment required",
+				Accepts: []PaymentRequirement{
+					{
+						Scheme:            "exact",
+						Network:           "base-sepolia",
+						MaxAmountRequired: "1000",
+						Asset:             USDCAddressBaseSepolia,
+						PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+						Resource:          "mcp://tools/search",
+						MaxTimeoutSeconds: 60,
+					},
+				},
+			})
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(response)
+			return
+		}
+
+		paidRequest = req
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL:            server.URL,
+		Signers:              []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		BindPaymentToRequest: true,
+	})
+	require.NoError(t, err)
+
+	request := transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search", "arguments": map[string]any{"query": "x402"}},
+	}
+
+	_, err = trans.SendRequest(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, 2, requestCount)
+
+	paramsBytes, _ := json.Marshal(paidRequest.Params)
+	var params struct {
+		Meta map[string]json.RawMessage `json:"_meta"`
+	}
+	require.NoError(t, json.Unmarshal(paramsBytes, &params))
+
+	var salt string
+	require.NoError(t, json.Unmarshal(params.Meta[MetaKeyBinding], &salt))
+
+	var payment struct {
+		Payload PaymentPayloadData `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(params.Meta["x402/payment"], &payment))
+	nonce := payment.Payload.Authorization.Nonce
+
+	// The nonce must verify against the request as sent (including the injected _meta)
+	assert.NoError(t, VerifyRequestBinding(paidRequest.Method, paidRequest.Params, salt, nonce))
+
+	// ...and must not verify against a different request
+	tampered := map[string]any{"name": "search", "arguments": map[string]any{"query": "other"}}
+	assert.Error(t, VerifyRequestBinding(paidRequest.Method, tampered, salt, nonce))
+}
+
+func TestX402Transport_ResourceBinding(t *testing.T) {
+	var paidRequest transport.JSONRPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if !strings.Contains(fmt.Sprint(req.Params), "x402/payment") {
+			// The server requires resource binding in the requirement's extra
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					Resource:          "mcp://tools/search",
+					MaxTimeoutSeconds: 60,
+					Extra:             map[string]string{ExtraResourceBinding: "required"},
+				}},
+			}))
+			return
+		}
+		paidRequest = req
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+	})
+	require.NoError(t, err)
+
+	_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	})
+	require.NoError(t, err)
+
+	paramsBytes, _ := json.Marshal(paidRequest.Params)
+	var params struct {
+		Meta struct {
+			Salt    string `json:"x402/binding"`
+			Payment struct {
+				Payload PaymentPayloadData `json:"payload"`
+			} `json:"x402/payment"`
+		} `json:"_meta"`
+	}
+	require.NoError(t, json.Unmarshal(paramsBytes, &params))
+	salt, nonce := params.Meta.Salt, params.Meta.Payment.Payload.Authorization.Nonce
+
+	assert.NoError(t, VerifyResourceBinding(server.URL, "mcp://tools/search", salt, nonce))
+	assert.Error(t, VerifyResourceBinding("https://other.example.com", "mcp://tools/search", salt, nonce), "another origin")
+	assert.Error(t, VerifyResourceBinding(server.URL, "mcp://tools/fetch", salt, nonce), "another resource")
+}
+
+func TestX402Transport_ManualPaymentMode(t *testing.T) {
+	var requestCount atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		var params map[string]any
+		paramsBytes, _ := json.Marshal(req.Params)
+		_ = json.Unmarshal(paramsBytes, &params)
+		meta, _ := params["_meta"].(map[string]any)
+
+		w.Header().Set("Content-Type", "application/json")
+		if meta == nil || meta["x402/payment"] == nil {
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Error:       "Payment required",
+				Accepts: []PaymentRequirement{
+					{
+						Scheme:            "exact",
+						Network:           "base-sepolia",
+						MaxAmountRequired: "1000",
+						Asset:             USDCAddressBaseSepolia,
+						PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+						Resource:          "mcp://tools/search",
+						MaxTimeoutSeconds: 60,
+					},
+				},
+			}))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	recorder := NewPaymentRecorder()
+	clock := NewFakeClock(time.Now())
+	trans, err := New(Config{
+		ServerURL:         server.URL,
+		Signers:           []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		ManualPaymentMode: true,
+		ManualPaymentTTL:  time.Minute,
+		Clock:             clock,
+	})
+	require.NoError(t, err)
+	trans.paymentRecorder = recorder
+
+	ctx := context.Background()
+	request := transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(7),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	}
+
+	_, err = trans.SendRequest(ctx, request)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrPaymentRequired))
+
+	var paymentErr *PaymentRequiredError
+	require.True(t, errors.As(err, &paymentErr))
+	assert.Equal(t, "1000", paymentErr.Requirements.Accepts[0].MaxAmountRequired)
+	assert.Equal(t, int32(1), requestCount.Load(), "No payment should be sent automatically")
+
+	payment, err := trans.CreatePayment(ctx, paymentErr.Requirements)
+	require.NoError(t, err)
+
+	resp, err := trans.RetryWithPayment(ctx, paymentErr.RequestID, payment)
+	require.NoError(t, err)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, int32(2), requestCount.Load())
+	assert.Len(t, recorder.SuccessfulPayments(), 1)
+
+	// The pending payment is consumed
+	_, err = trans.RetryWithPayment(ctx, paymentErr.RequestID, payment)
+	assert.Error(t, err)
+
+	// Abandoned requests expire, and are dropped as others are deferred
+	abandon := func(id int64) {
+		request.ID = mcp.NewRequestId(id)
+		_, err := trans.SendRequest(ctx, request)
+		require.ErrorIs(t, err, ErrPaymentRequired)
+	}
+	abandon(8)
+	abandon(9)
+	clock.Advance(2 * time.Minute)
+	_, err = trans.RetryWithPayment(ctx, mcp.NewRequestId(8), payment)
+	assert.Error(t, err, "Expired requests can't be paid")
+	abandon(10)
+	trans.pendingMu.Lock()
+	assert.Len(t, trans.pending, 1)
+	trans.pendingMu.Unlock()
+}
+
+func TestX402Transport_Identity(t *testing.T) {
+	var received []transport.JSONRPCRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		received = append(received, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, false))
+	}))
+	defer server.Close()
+
+	signer, err := NewPrivateKeySigner("0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80", AcceptUSDCBaseSepolia())
+	require.NoError(t, err)
+
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signers:   []PaymentSigner{signer},
+		Identity:  signer.Identity(),
+	})
+	require.NoError(t, err)
+
+	_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: string(mcp.MethodInitialize),
+		Params: mcp.InitializeParams{ClientInfo: mcp.Implementation{Name: "agent", Version: "1.0.0"}},
+	})
+	require.NoError(t, err)
+
+	_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(2),
+		Method: "tools/list",
+	})
+	require.NoError(t, err)
+	require.Len(t, received, 2)
+
+	for _, req := range received {
+		paramsBytes, _ := json.Marshal(req.Params)
+		var params struct {
+			Meta map[string]json.RawMessage `json:"_meta"`
+		}
+		require.NoError(t, json.Unmarshal(paramsBytes, &params))
+
+		var claim IdentityClaim
+		require.NoError(t, json.Unmarshal(params.Meta[MetaKeyIdentity], &claim))
+		assert.Equal(t, "agent", claim.ClientName)
+		assert.Equal(t, server.URL, claim.Audience)
+
+		address, err := VerifyIdentityClaim(&claim, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, signer.GetAddress(), address)
+	}
+
+	t.Run("RejectsTamperedAndExpiredClaims", func(t *testing.T) {
+		claim, err := signer.Identity().IdentityClaim(context.Background(), "https://a.example", mcp.Implementation{})
+		require.NoError(t, err)
+
+		tampered := *claim
+		tampered.Audience = "https://b.example"
+		_, err = VerifyIdentityClaim(&tampered, time.Now())
+		assert.ErrorIs(t, err, ErrInvalidIdentity)
+
+		_, err = VerifyIdentityClaim(claim, time.Now().Add(DefaultIdentityTTL+time.Second))
+		assert.ErrorIs(t, err, ErrInvalidIdentity)
+	})
+}
+
+func TestX402Transport_TrustedRecipients(t *testing.T) {
+	newServer := func(payTo string) *httptest.Server {
+		var paid atomic.Bool
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req transport.JSONRPCRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+
+			if paid.Swap(true) {
+				_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Error:       "Payment required",
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             payTo,
+					Resource:          "mcp://tools/search",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+		}))
+	}
+
+	trusted := map[string][]string{
+		"base-sepolia": {"0x209693Bc6afc0C5328bA36FaF03C514EF312287C"},
+	}
+
+	send := func(serverURL string) error {
+		trans, err := New(Config{
+			ServerURL:         serverURL,
+			Signers:           []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			TrustedRecipients: trusted,
+		})
+		require.NoError(t, err)
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		return err
+	}
+
+	t.Run("TrustedRecipientIsPaid", func(t *testing.T) {
+		server := newServer("0x209693bc6afc0c5328ba36faf03c514ef312287c")
+		defer server.Close()
+		assert.NoError(t, send(server.URL))
+	})
+
+	t.Run("UnknownRecipientIsRefused", func(t *testing.T) {
+		server := newServer("0x000000000000000000000000000000000000dEaD")
+		defer server.Close()
+		assert.ErrorIs(t, send(server.URL), ErrUntrustedRecipient)
+	})
+}
+
+func TestX402Transport_ForceNetworkAndAsset(t *testing.T) {
+	// The server accepts Polygon Amoy and Base Sepolia
+	var paidNetwork atomic.Value
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if payment, ok := req.Params.Meta["x402/payment"].(map[string]any); ok {
+			paidNetwork.Store(payment["network"])
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+		requirement := func(network, asset string) PaymentRequirement {
+			return PaymentRequirement{
+				Scheme:            "exact",
+				Network:           network,
+				MaxAmountRequired: "1000",
+				Asset:             asset,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				MaxTimeoutSeconds: 60,
+			}
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{
+				requirement("polygon-amoy", USDCAddressPolygonAmoy),
+				requirement("base-sepolia", USDCAddressBaseSepolia),
+			},
+		}))
+	}))
+	defer server.Close()
+
+	send := func(network, asset string) error {
+		trans, err := New(Config{
+			ServerURL: server.URL,
+			Signers: []PaymentSigner{
+				NewMockSigner("0xTestWallet", AcceptUSDCPolygonAmoy(), AcceptUSDCBaseSepolia(), AcceptUSDCBase()),
+			},
+			ForceNetwork: network,
+			ForceAsset:   asset,
+		})
+		require.NoError(t, err)
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		return err
+	}
+
+	require.NoError(t, send("polygon-amoy", ""))
+	assert.Equal(t, "polygon-amoy", paidNetwork.Load())
+
+	require.NoError(t, send("", strings.ToLower(USDCAddressBaseSepolia)))
+	assert.Equal(t, "base-sepolia", paidNetwork.Load())
+
+	err := send("base", USDCAddressBase)
+	assert.ErrorIs(t, err, ErrForcedPaymentOption)
+	assert.Contains(t, err.Error(), "on polygon-amoy")
+}
+
+func TestX402Transport_PaidAccept(t *testing.T) {
+	// send pays for a call and returns the Accept headers of the unpaid and paid requests
+	send := func(ctx context.Context, mode AcceptMode) (unpaid, paid string) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ID     mcp.RequestId `json:"id"`
+				Params struct {
+					Meta map[string]any `json:"_meta"`
+				} `json:"params"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+			if _, ok := req.Params.Meta["x402/payment"]; ok {
+				paid = r.Header.Get("Accept")
+				_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+				return
+			}
+			unpaid = r.Header.Get("Accept")
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+		}))
+		defer server.Close()
+
+		trans, err := New(Config{ServerURL: server.URL, Signer: NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia()), PaidAccept: mode})
+		require.NoError(t, err)
+		_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+		return unpaid, paid
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		mode       AcceptMode
+		wantUnpaid string
+		wantPaid   string
+	}{
+		{"default", ctx, AcceptBoth, "application/json, text/event-stream", "application/json, text/event-stream"},
+		{"json", ctx, AcceptJSON, "application/json, text/event-stream", "application/json"},
+		{"sse", ctx, AcceptSSE, "application/json, text/event-stream", "text/event-stream"},
+		{"caller", WithAcceptHeader(ctx, "application/json"), AcceptCaller, "application/json", "application/json"},
+		{"caller without header", ctx, AcceptCaller, "application/json, text/event-stream", "application/json, text/event-stream"},
+		{"per request", WithPaidAccept(ctx, AcceptSSE), AcceptJSON, "application/json, text/event-stream", "text/event-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unpaid, paid := send(tt.ctx, tt.mode)
+			assert.Equal(t, tt.wantUnpaid, unpaid)
+			assert.Equal(t, tt.wantPaid, paid)
+		})
+	}
+
+	_, err := New(Config{ServerURL: "http://localhost", Signer: NewMockSigner("0xTestWallet"), PaidAccept: AcceptMode(9)})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestX402Transport_ExtraHeaders(t *testing.T) {
+	var mu sync.Mutex
+	var seen []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		seen = append(seen, r.Header.Clone())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if _, ok := req.Params.Meta["x402/payment"]; ok {
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL:    server.URL,
+		Signer:       NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia()),
+		ExtraHeaders: map[string]string{"X-Team": "research", "X-Request-ID": "default", "Accept": "text/plain"},
+	})
+	require.NoError(t, err)
+
+	ctx := WithHeaders(context.Background(), map[string]string{"X-Request-ID": "req-7", "traceparent": "00-abc-def-01"})
+	ctx = WithHeaders(ctx, map[string]string{"X-PAYMENT": "forged"})
+	_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, seen, 2, "unpaid request and paid retry")
+	for i, header := range seen {
+		assert.Equal(t, "research", header.Get("X-Team"), "request %d", i)
+		assert.Equal(t, "req-7", header.Get("X-Request-ID"), "request %d", i)
+		assert.Equal(t, "00-abc-def-01", header.Get("Traceparent"), "request %d", i)
+		assert.Equal(t, "application/json, text/event-stream", header.Get("Accept"), "request %d", i)
+	}
+	assert.Empty(t, seen[0].Get("X-PAYMENT"), "reserved headers can't be set")
+
+	var warned bool
+	for _, issue := range (Config{ServerURL: server.URL, Signer: NewMockSigner("0xTestWallet"), ExtraHeaders: map[string]string{"x-payment": "x"}}).Validate() {
+		warned = warned || (issue.Field == "ExtraHeaders" && issue.Severity == ConfigWarning)
+	}
+	assert.True(t, warned, "reserved extra headers are reported")
+}
+
+func TestX402Transport_InvalidRequirements(t *testing.T) {
+	valid := PaymentRequirement{
+		Scheme:            "exact",
+		Network:           "base-sepolia",
+		MaxAmountRequired: "1000",
+		Asset:             USDCAddressBaseSepolia,
+		PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+		MaxTimeoutSeconds: 60,
+	}
+	send := func(accepts ...PaymentRequirement) (int, error) {
+		var paid atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ID     mcp.RequestId `json:"id"`
+				Params struct {
+					Meta map[string]any `json:"_meta"`
+				} `json:"params"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+			if _, ok := req.Params.Meta["x402/payment"]; ok {
+				paid.Add(1)
+				_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts:     accepts,
+			}))
+		}))
+		defer server.Close()
+
+		trans, err := New(Config{ServerURL: server.URL, Signer: NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())})
+		require.NoError(t, err)
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		return int(paid.Load()), err
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*PaymentRequirement)
+		want   string
+	}{
+		{"missing payTo", func(r *PaymentRequirement) { r.PayTo = "" }, "accepts[0].payTo: missing"},
+		{"non-numeric amount", func(r *PaymentRequirement) { r.MaxAmountRequired = "0.001" }, `accepts[0].maxAmountRequired: "0.001" is not an integer amount`},
+		{"zero amount", func(r *PaymentRequirement) { r.MaxAmountRequired = "0" }, "accepts[0].maxAmountRequired: 0 is not positive"},
+		{"unknown scheme", func(r *PaymentRequirement) { r.Scheme = "stream" }, `accepts[0].scheme: unknown scheme "stream"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invalid := valid
+			tt.modify(&invalid)
+
+			paid, err := send(invalid)
+			assert.ErrorIs(t, err, ErrInvalidPaymentReqs)
+			assert.Contains(t, err.Error(), tt.want)
+			assert.Zero(t, paid, "nothing should be paid")
+
+			// A valid option alongside is paid instead
+			paid, err = send(invalid, valid)
+			require.NoError(t, err)
+			assert.Equal(t, 1, paid)
+		})
+	}
+
+	_, err := send()
+	var validationErr *RequirementsValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, []RequirementsIssue{{Field: "accepts", Message: "no payment options"}}, validationErr.Issues)
+}
+
+func TestX402Transport_PriceChecks(t *testing.T) {
+	// The catalog advertises 1000 for search; its 402 asks livePrice
+	var livePrice atomic.Value
+	var paid atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Method string        `json:"method"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case req.Method == "tools/list":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"jsonrpc": "2.0",
+				"id":      req.ID,
+				"result": map[string]any{"tools": []map[string]any{{
+					"name":        "search",
+					"inputSchema": map[string]any{"type": "object"},
+					"_meta": map[string]any{
+						MetaKeyPaid:     true,
+						MetaKeyCostHint: []CostHint{{Scheme: "exact", Network: "base-sepolia", Asset: USDCAddressBaseSepolia, Amount: "1000"}},
+					},
+				}}},
+			})
+		case req.Params.Meta["x402/payment"] != nil:
+			paid.Add(1)
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+		default:
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: livePrice.Load().(string),
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+		}
+	}))
+	defer server.Close()
+
+	call := func(ctx context.Context, action PriceChangeAction, price string) error {
+		livePrice.Store(price)
+		trans, err := New(Config{
+			ServerURL:          server.URL,
+			Signers:            []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			CatalogPriceChange: action,
+		})
+		require.NoError(t, err)
+		_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(1), Method: "tools/list"})
+		require.NoError(t, err)
+		_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(2),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		return err
+	}
+	ctx := context.Background()
+
+	t.Run("RefusesRaisedPrice", func(t *testing.T) {
+		err := call(ctx, PriceChangeRefuse, "2000")
+		assert.ErrorIs(t, err, ErrCatalogPriceChanged)
+		assert.Equal(t, DeclineRuleCatalogPrice, declineRule(err))
+		assert.NoError(t, call(ctx, PriceChangeRefuse, "1000"))
+	})
+
+	t.Run("WarnsAndPays", func(t *testing.T) {
+		before := paid.Load()
+		require.NoError(t, call(ctx, PriceChangeWarn, "2000"))
+		assert.Equal(t, before+1, paid.Load())
+	})
+
+	t.Run("MaxPaymentPerRequest", func(t *testing.T) {
+		err := call(WithMaxPayment(ctx, big.NewInt(1500)), PriceChangeIgnore, "2000")
+		assert.ErrorIs(t, err, ErrPaymentDeclined)
+		assert.NoError(t, call(WithMaxPayment(ctx, big.NewInt(1500)), PriceChangeIgnore, "1000"))
+	})
+
+	t.Run("SignedAmountMismatch", func(t *testing.T) {
+		req := PaymentRequirement{MaxAmountRequired: "1000"}
+		payload := &PaymentPayload{Payload: PaymentPayloadData{Authorization: PaymentAuthorization{Value: "1001"}}}
+		assert.ErrorIs(t, checkSignedAmount(payload, req), ErrSigningFailed)
+
+		payload.Payload = PaymentPayloadData{Authorization: PaymentAuthorization{Value: "1000"}}
+		assert.NoError(t, checkSignedAmount(payload, req))
+	})
+}
+
+func TestX402Transport_PaymentReference(t *testing.T) {
+	var paidRequest transport.JSONRPCRequest
+	var requestCount int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if requestCount == 1 {
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					Resource:          "mcp://tools/search",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+			return
+		}
+		paidRequest = req
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+	})
+	require.NoError(t, err)
+
+	ctx := WithPaymentReference(context.Background(), "run-123")
+	_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	})
+	require.NoError(t, err)
+
+	params, ok := paidRequest.Params.(map[string]any)
+	require.True(t, ok)
+	meta, ok := params["_meta"].(map[string]any)
+	require.True(t, ok)
+	assert.Equal(t, "run-123", meta[MetaKeyReference])
+}
+
+func TestX402Transport_Attribution(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Meta["x402/payment"] != nil {
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	var attempts []PaymentEvent
+	trans, err := New(Config{
+		ServerURL:        server.URL,
+		Signers:          []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		OnPaymentAttempt: func(e PaymentEvent) { attempts = append(attempts, e) },
+	})
+	require.NoError(t, err)
+	recorder := NewPaymentRecorder()
+	trans.paymentRecorder = recorder
+
+	call := func(ctx context.Context, id int64) {
+		_, err := trans.SendRequest(ctx, transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(id),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+	}
+
+	planner := WithAttribution(context.Background(), map[string]string{"agent": "planner", "run": "1"})
+	call(planner, 1)
+	call(WithAttribution(planner, map[string]string{"run": "2"}), 2)
+	call(WithAttribution(context.Background(), map[string]string{"agent": "critic"}), 3)
+	call(context.Background(), 4)
+
+	require.Len(t, attempts, 4)
+	assert.Equal(t, map[string]string{"agent": "planner", "run": "1"}, attempts[0].Attribution)
+	assert.Equal(t, map[string]string{"agent": "planner", "run": "2"}, attempts[1].Attribution)
+	assert.Nil(t, attempts[3].Attribution)
+
+	assert.Equal(t, map[string]string{"planner": "2000", "critic": "1000", "": "1000"}, recorder.TotalAmountBy("agent"))
+
+	// Sessions add to the same metrics
+	session := trans.NewSession()
+	_, err = session.SendRequest(WithAttribution(context.Background(), map[string]string{"agent": "critic"}), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(int64(5)),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	})
+	require.NoError(t, err)
+
+	usdc := AssetKey{Network: "base-sepolia", Asset: strings.ToLower(USDCAddressBaseSepolia)}
+	total := func(totals AttributedTotals) string {
+		return fmt.Sprintf("%d:%s", totals.Payments, totals.Amounts[usdc])
+	}
+	metrics := trans.GetMetrics()
+	assert.Equal(t, "5:5000", total(metrics.AttributedTotals))
+	agents := make(map[string]string)
+	for agent, totals := range metrics.ByDimension["agent"] {
+		agents[agent] = total(totals)
+	}
+	assert.Equal(t, map[string]string{"planner": "2:2000", "critic": "2:2000", "": "1:1000"}, agents)
+	runs := make(map[string]string)
+	for run, totals := range metrics.ByDimension["run"] {
+		runs[run] = total(totals)
+	}
+	assert.Equal(t, map[string]string{"1": "1:1000", "2": "1:1000", "": "3:3000"}, runs)
+}
+
+func TestX402Transport_MetricsReportPaidOption(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Meta["x402/payment"] != nil {
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBase,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}, {
+				Scheme:            "exact",
+				Network:           "polygon",
+				MaxAmountRequired: "500",
+				Asset:             USDCAddressPolygon,
+				PayTo:             "0x742d35Cc6634C0532925a3b844Bc9e7595f0bEb6",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	var successes []PaymentEvent
+	trans, err := New(Config{
+		ServerURL:        server.URL,
+		Signers:          []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCPolygon())},
+		OnPaymentSuccess: func(e PaymentEvent) { successes = append(successes, e) },
+	})
+	require.NoError(t, err)
+
+	_, err = trans.SendRequest(WithAttribution(context.Background(), map[string]string{"agent": "planner"}), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, successes, 1)
+	assert.Equal(t, "polygon", successes[0].Network)
+	assert.Equal(t, USDCAddressPolygon, successes[0].Asset)
+	assert.Equal(t, "0x742d35Cc6634C0532925a3b844Bc9e7595f0bEb6", successes[0].Recipient)
+	assert.Equal(t, "500", successes[0].Amount.String())
+
+	polygon := AssetKey{Network: "polygon", Asset: strings.ToLower(USDCAddressPolygon)}
+	metrics := trans.GetMetrics()
+	assert.Equal(t, map[AssetKey]string{polygon: "500"}, amountStrings(metrics.Amounts))
+	assert.Equal(t, map[AssetKey]string{polygon: "500"}, amountStrings(metrics.ByDimension["agent"]["planner"].Amounts))
+}
+
+// amountStrings formats big.Int amounts for comparison
+func amountStrings(amounts map[AssetKey]*big.Int) map[AssetKey]string {
+	formatted := make(map[AssetKey]string, len(amounts))
+	for key, amount := range amounts {
+		formatted[key] = amount.String()
+	}
+	return formatted
+}
+
+func TestCostAwareClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Name string         `json:"name"`
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Name == "free" || req.Params.Meta["x402/payment"] != nil {
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, req.Params.Name != "free"))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				// Another asset on the same network, which the signer can't pay
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "9999",
+				Asset:             "0x0000000000000000000000000000000000000bad",
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}, {
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "2500",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+	})
+	require.NoError(t, err)
+
+	c := NewCostAwareClient(client.NewClient(trans, client.WithSession()))
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	_, cost, err := c.CallToolWithCost(context.Background(), request)
+	require.NoError(t, err)
+	require.True(t, cost.Paid())
+	require.Len(t, cost.Payments, 1)
+	assert.Equal(t, "2500", cost.Total(USDCAddressBaseSepolia).String())
+	assert.Equal(t, "mcp://tools/search", cost.Payments[0].Resource)
+	assert.Equal(t, "0x123", cost.Transaction)
+
+	request.Params.Name = "free"
+	result, err := c.CallTool(context.Background(), request)
+	require.NoError(t, err)
+	free, ok := result.Meta.AdditionalFields[MetaKeyCost].(*ToolCost)
+	require.True(t, ok)
+	assert.False(t, free.Paid())
+}
+
+func TestX402Transport_NetworkHealthIgnoresToolErrors(t *testing.T) {
+	var settlementFails atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Meta["x402/payment"] == nil {
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					Resource:          "mcp://tools/search",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+			return
+		}
+		rpcError := map[string]any{"code": mcp.INTERNAL_ERROR, "message": "search backend unavailable"}
+		if settlementFails.Load() {
+			rpcError = map[string]any{"code": mcp.INTERNAL_ERROR, "message": "Payment settlement failed",
+				"data": map[string]any{"settlementFailed": true, "network": "base-sepolia"}}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": rpcError})
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+	})
+	require.NoError(t, err)
+	call := func(id int64) {
+		_, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(id),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+	}
+
+	call(1)
+	_, recorded := trans.NetworkHealth().Stats("base-sepolia")
+	assert.False(t, recorded, "a tool error is not a network failure")
+
+	settlementFails.Store(true)
+	call(2)
+	stats, recorded := trans.NetworkHealth().Stats("base-sepolia")
+	require.True(t, recorded)
+	assert.Positive(t, stats.FailureRate)
+}
+
+func TestRecordCost_WithoutSelection(t *testing.T) {
+	// Payments made outside CreatePayment, as in manual payment mode, are matched
+	// on their recipient and recorded at the amount they authorize
+	reqs := PaymentRequirementsResponse{Accepts: []PaymentRequirement{
+		{Scheme: "exact", Network: "base", MaxAmountRequired: "5000", Asset: USDCAddressBase, PayTo: "0xAlice"},
+		{Scheme: "exact", Network: "base", MaxAmountRequired: "3000", Asset: "0xOtherToken", PayTo: "0xBob"},
+	}}
+	payment := &PaymentPayload{Scheme: "exact", Network: "base", Payload: PaymentPayloadData{
+		Authorization: PaymentAuthorization{To: "0xbob", Value: "2000"},
+	}}
+
+	collector := &costCollector{}
+	recordCost(context.WithValue(context.Background(), costCollectorKey{}, collector), reqs, payment)
+	require.Len(t, collector.payments, 1)
+	assert.Equal(t, PaymentCost{Amount: "2000", Asset: "0xOtherToken", Network: "base"}, collector.payments[0])
+}
+
+func TestX402Transport_RejectedPaymentSafeguards(t *testing.T) {
+	requirement := func(resource string) PaymentRequirementsResponse {
+		return PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          resource,
+				MaxTimeoutSeconds: 60,
+			}},
+		}
+	}
+
+	var payments atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		// Hostile server: every payment is answered with another 402
+		if req.Params.Meta["x402/payment"] != nil {
+			payments.Add(1)
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, requirement("mcp://tools/search")))
+	}))
+	defer server.Close()
+
+	clock := NewFakeClock(time.Now())
+	var attempts []PaymentEvent
+	trans, err := New(Config{
+		ServerURL:          server.URL,
+		Signers:            []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		FailureBackoff:     20 * time.Millisecond,
+		QuarantineAfter:    2,
+		QuarantineDuration: time.Hour,
+		Clock:              clock,
+		OnPaymentAttempt:   func(e PaymentEvent) { attempts = append(attempts, e) },
+	})
+	require.NoError(t, err)
+
+	call := func() error {
+		_, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		return err
+	}
+
+	require.Error(t, call())
+	assert.ErrorIs(t, call(), ErrPaymentBackoff)
+
+	// Backoff and quarantine go by the configured clock
+	clock.Advance(30 * time.Millisecond)
+	require.Error(t, call())
+	assert.ErrorIs(t, call(), ErrServerQuarantined)
+	assert.Equal(t, int32(2), payments.Load())
+	require.NotEmpty(t, attempts)
+	assert.Equal(t, clock.Now().Unix(), attempts[len(attempts)-1].Timestamp)
+
+	quarantined, until := trans.Quarantined()
+	assert.True(t, quarantined)
+	assert.Equal(t, clock.Now().Add(time.Hour), until)
+	trans.ResetQuarantine()
+	quarantined, _ = trans.Quarantined()
+	assert.False(t, quarantined)
+
+	t.Run("payments per request", func(t *testing.T) {
+		// Paying the access fee uncovers the tool price, which a cap of one refuses
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ID     mcp.RequestId `json:"id"`
+				Params struct {
+					Meta map[string]any `json:"_meta"`
+				} `json:"params"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+
+			resource := AccessResource
+			if req.Params.Meta["x402/payment"] != nil {
+				resource = "mcp://tools/search"
+			}
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, requirement(resource)))
+		}))
+		defer server.Close()
+
+		trans, err := New(Config{
+			ServerURL:             server.URL,
+			Signers:               []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			MaxPaymentsPerRequest: 1,
+		})
+		require.NoError(t, err)
+
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		assert.ErrorIs(t, err, ErrPaymentLimitExceeded)
+	})
+}
+
+func TestX402Transport_DeclinedPaymentAudit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/expensive",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	identity, err := NewEVMIdentity("0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	require.NoError(t, err)
+
+	path := t.TempDir() + "/declines.jsonl"
+	ledger, err := NewFileDeclineLedger(path)
+	require.NoError(t, err)
+	defer ledger.Close()
+
+	var declined []DeclinedPayment
+	trans, err := New(Config{
+		ServerURL:         server.URL,
+		Signers:           []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		PaymentCallback:   func(amount *big.Int, resource string) bool { return false },
+		DeclineLedger:     ledger,
+		OnPaymentDeclined: func(d DeclinedPayment) { declined = append(declined, d) },
+		Attester:          identity,
+	})
+	require.NoError(t, err)
+
+	ctx := WithAttribution(context.Background(), map[string]string{"agent": "planner"})
+	_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "expensive"},
+	})
+	require.ErrorIs(t, err, ErrPaymentDeclined)
+
+	require.Len(t, declined, 1)
+	record := declined[0]
+	assert.Equal(t, DeclineRulePaymentCallback, record.Rule)
+	assert.Equal(t, "tools/call", record.Method)
+	assert.Equal(t, []string{"0xTestWallet"}, record.Payers)
+	assert.Equal(t, "mcp://tools/expensive", record.Requirements[0].Resource)
+	assert.Equal(t, map[string]string{"agent": "planner"}, record.Attribution)
+	assert.Equal(t, identity.Subject(), record.Attester)
+	require.NoError(t, VerifyDeclinedPayment(record))
+
+	tampered := record
+	tampered.Rule = DeclineRuleQuarantine
+	assert.Error(t, VerifyDeclinedPayment(tampered))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var persisted DeclinedPayment
+	require.NoError(t, json.Unmarshal(data, &persisted))
+	require.NoError(t, VerifyDeclinedPayment(persisted))
+}
+
+func TestX402Transport_MultiSignerErrorChain(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signers: []PaymentSigner{
+			NewMockSigner("0xSolanaWallet", AcceptUSDCSolanaDevnet()),
+			NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia()),
+		},
+		PaymentCallback: func(amount *big.Int, resource string) bool { return false },
+	})
+	require.NoError(t, err)
+
+	request := mcp.CallToolRequest{}
+	request.Params.Name = "search"
+	_, err = client.NewClient(trans, client.WithSession()).CallTool(context.Background(), request)
+	require.Error(t, err)
+
+	var multi *MultiSignerError
+	require.ErrorAs(t, err, &multi)
+	require.Len(t, multi.SignerFailures, 2)
+	assert.Equal(t, "0xSolanaWallet", multi.SignerFailures[0].SignerAddress)
+	assert.Equal(t, "0xTestWallet", multi.SignerFailures[1].SignerAddress)
+
+	assert.ErrorIs(t, err, ErrNoViablePaymentOption)
+	assert.ErrorIs(t, err, ErrPaymentDeclined)
+	assert.Len(t, SignerFailures(err), 2)
+	assert.Nil(t, SignerFailures(ErrPaymentDeclined))
+}
+
+func TestX402Transport_PaymentRequiredCodes(t *testing.T) {
+	requirements := PaymentRequirementsResponse{
+		X402Version: 1,
+		Accepts: []PaymentRequirement{{
+			Scheme:            "exact",
+			Network:           "base-sepolia",
+			MaxAmountRequired: "1000",
+			Asset:             USDCAddressBaseSepolia,
+			PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+			Resource:          "mcp://tools/search",
+			MaxTimeoutSeconds: 60,
+		}},
+	}
+	unversioned := requirements
+	unversioned.X402Version = 0
+
+	tests := []struct {
+		name    string
+		code    int
+		message string
+		data    any
+		codes   []int
+		pays    bool
+	}{
+		{name: "configured code", code: -32402, message: "Pay up", data: map[string]any{"accepts": unversioned.Accepts}, codes: []int{-32402}, pays: true},
+		{name: "x402 data", code: -32000, message: "Server error", data: requirements, pays: true},
+		{name: "payment required message", code: -32000, message: "Payment Required", data: unversioned, pays: true},
+		{name: "unrelated error", code: -32000, message: "Server error", data: unversioned, pays: false},
+		{name: "no requirements", code: -32402, message: "Payment required", data: nil, pays: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req struct {
+					ID     mcp.RequestId `json:"id"`
+					Params struct {
+						Meta map[string]any `json:"_meta"`
+					} `json:"params"`
+				}
+				_ = json.NewDecoder(r.Body).Decode(&req)
+				w.Header().Set("Content-Type", "application/json")
+
+				if req.Params.Meta["x402/payment"] != nil {
+					_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+					return
+				}
+				_ = json.NewEncoder(w).Encode(transport.JSONRPCResponse{
+					JSONRPC: "2.0",
+					ID:      req.ID,
+					Error:   &mcp.JSONRPCErrorDetails{Code: tt.code, Message: tt.message, Data: tt.data},
+				})
+			}))
+			defer server.Close()
+
+			trans, err := New(Config{
+				ServerURL:            server.URL,
+				Signers:              []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+				PaymentRequiredCodes: tt.codes,
+			})
+			require.NoError(t, err)
+
+			resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+				ID:     mcp.NewRequestId(1),
+				Method: "tools/call",
+				Params: map[string]any{"name": "search"},
+			})
+			if tt.pays {
+				require.NoError(t, err)
+				assert.Nil(t, resp.Error)
+				return
+			}
+			if err == nil {
+				require.NotNil(t, resp.Error)
+				assert.Equal(t, tt.code, resp.Error.Code)
+			}
+		})
+	}
+}
+
+func TestX402Transport_PaymentRequiredCodeSharedWithBusy(t *testing.T) {
+	// The server uses -32000 for both payment required and busy errors
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		rpcError := &mcp.JSONRPCErrorDetails{Code: -32000, Message: "Server busy processing payments, retry later", Data: map[string]any{"retryable": true}}
+		if req.Params.Meta["x402/payment"] == nil {
+			rpcError = &mcp.JSONRPCErrorDetails{Code: -32000, Message: "Pay up", Data: map[string]any{"accepts": []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}}}}
+		}
+		_ = json.NewEncoder(w).Encode(transport.JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcError})
+	}))
+	defer server.Close()
+
+	trans, err := New(Config{
+		ServerURL:            server.URL,
+		Signers:              []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		PaymentRequiredCodes: []int{-32000},
+		QuarantineAfter:      1,
+	})
+	require.NoError(t, err)
+
+	resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	})
+	require.NoError(t, err, "a busy server must not be taken for one rejecting the payment")
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, -32000, resp.Error.Code)
+	assert.Contains(t, resp.Error.Message, "busy")
+
+	quarantined, _ := trans.Quarantined()
+	assert.False(t, quarantined, "a busy server must not be quarantined")
+}
+
+func TestX402Transport_SoftPaymentRequired(t *testing.T) {
+	var paid atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Meta["x402/payment"] != nil {
+			paid.Add(1)
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+
+		// Soft 402: a successful result asking for payment
+		result, _ := json.Marshal(map[string]any{
+			"content": []map[string]any{{"type": "text", "text": "Payment required"}},
+			"_meta": map[string]any{
+				MetaKeyPaymentRequired: PaymentRequirementsResponse{
+					X402Version: 1,
+					Accepts: []PaymentRequirement{{
+						Scheme:            "exact",
+						Network:           "base-sepolia",
+						MaxAmountRequired: "1000",
+						Asset:             USDCAddressBaseSepolia,
+						PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+						Resource:          "mcp://tools/search",
+						MaxTimeoutSeconds: 60,
+					}},
+				},
+			},
+		})
+		_ = json.NewEncoder(w).Encode(transport.JSONRPCResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+	}))
+	defer server.Close()
+
+	send := func(detect bool) *transport.JSONRPCResponse {
+		trans, err := New(Config{
+			ServerURL:                 server.URL,
+			Signers:                   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			DetectSoftPaymentRequired: detect,
+		})
+		require.NoError(t, err)
+
+		resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Without detection the soft 402 result is returned as is
+	resp := send(false)
+	assert.Contains(t, string(resp.Result), MetaKeyPaymentRequired)
+	assert.Equal(t, int32(0), paid.Load())
+
+	resp = send(true)
+	assert.Nil(t, resp.Error)
+	assert.Contains(t, string(resp.Result), "x402/payment-response")
+	assert.Equal(t, int32(1), paid.Load())
+}
+
+func TestX402Transport_MaxPaymentOverhead(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Meta["x402/payment"] != nil {
+			// Slow settlement
+			select {
+			case <-time.After(200 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	send := func(overhead time.Duration) error {
+		trans, err := New(Config{
+			ServerURL:          server.URL,
+			Signers:            []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			MaxPaymentOverhead: overhead,
+		})
+		require.NoError(t, err)
+
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		return err
+	}
+
+	err := send(50 * time.Millisecond)
+	assert.ErrorIs(t, err, ErrPaymentOverheadExceeded)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, send(0))
+	assert.NoError(t, send(5*time.Second))
+}
+
+func TestX402Transport_PaymentRequiredOverSSE(t *testing.T) {
+	type paidRetry struct {
+		sessionID   string
+		streamEnded bool
+	}
+	streamEnded := make(chan struct{}, 1)
+	retries := make(chan paidRetry, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId  `json:"id"`
+			Method string         `json:"method"`
+			Params map[string]any `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if meta, _ := req.Params["_meta"].(map[string]any); meta["x402/payment"] == nil {
+			// Stream the 402 as an SSE event and keep the stream open, as a
+			// server with more to send on it would
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set(transport.HeaderKeySessionID, "unpaid-session")
+			data, _ := json.Marshal(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					Resource:          "mcp://tools/search",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			streamEnded <- struct{}{}
+			return
+		}
+
+		retry := paidRetry{sessionID: r.Header.Get(transport.HeaderKeySessionID)}
+		select {
+		case <-streamEnded:
+			retry.streamEnded = true
+		case <-time.After(time.Second):
+		}
+		retries <- retry
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		if req.Method == string(mcp.MethodInitialize) {
+			w.Header().Set(transport.HeaderKeySessionID, "paid-session")
+		}
+		data, _ := json.Marshal(createSuccessResponse(req.ID, true))
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+	}))
+	defer server.Close()
+
+	t.Run("tool call", func(t *testing.T) {
+		trans, err := New(Config{ServerURL: server.URL, Signer: NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())})
+		require.NoError(t, err)
+
+		resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+		assert.Nil(t, resp.Error)
+		assert.True(t, (<-retries).streamEnded, "the 402 stream should be closed before the paid retry")
+	})
+
+	t.Run("initialize", func(t *testing.T) {
+		trans, err := New(Config{ServerURL: server.URL, Signer: NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())})
+		require.NoError(t, err)
+
+		resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: string(mcp.MethodInitialize),
+			Params: map[string]any{},
+		})
+		require.NoError(t, err)
+		assert.Nil(t, resp.Error)
+
+		retry := <-retries
+		assert.True(t, retry.streamEnded, "the 402 stream should be closed before the paid retry")
+		assert.Empty(t, retry.sessionID, "the unpaid initialize should not open a session")
+		assert.Equal(t, "paid-session", trans.GetSessionId())
+	})
+}
+
+func TestX402Transport_UnhandledSSEEvents(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID mcp.RequestId `json:"id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: payment-notice\ndata: settled 0.001 USDC\n\n")
+		fmt.Fprint(w, "data: not json\n\n")
+		fmt.Fprint(w, "data: {\"jsonrpc\":\"2.0\",\"method\":{}}\n\n")
+		fmt.Fprint(w, "data: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n")
+		data, _ := json.Marshal(createSuccessResponse(req.ID, false))
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+	}))
+	defer server.Close()
+
+	var mu sync.Mutex
+	var dropped []UnhandledSSEEvent
+	trans, err := New(Config{
+		ServerURL: server.URL,
+		Signer:    NewMockSigner("0xTestWallet"),
+		OnUnhandledSSEEvent: func(event UnhandledSSEEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped = append(dropped, event)
+		},
+	})
+	require.NoError(t, err)
+
+	resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId(1),
+		Method: "tools/call",
+		Params: map[string]any{"name": "echo"},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, resp.Error)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, dropped, 3)
+	assert.Equal(t, "payment-notice", dropped[0].Event)
+	assert.Equal(t, SSEEventUnknownType, dropped[0].Reason)
+	assert.Equal(t, "settled 0.001 USDC", dropped[0].Data)
+	assert.Equal(t, SSEEventInvalidMessage, dropped[1].Reason)
+	assert.Equal(t, SSEEventInvalidNotification, dropped[2].Reason)
+	assert.Error(t, dropped[2].Err)
+
+	stats := trans.NewSession().SSEEventStats()
+	assert.Equal(t, uint64(2), stats.Handled)
+	assert.Equal(t, map[SSEEventReason]uint64{
+		SSEEventUnknownType:         1,
+		SSEEventInvalidMessage:      1,
+		SSEEventInvalidNotification: 1,
+	}, stats.Unhandled)
+}
+
+func TestX402Transport_DeadlineExtension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Name string         `json:"name"`
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		if req.Params.Meta["x402/payment"] == nil {
+			requirements := PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base-sepolia",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBaseSepolia,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					Resource:          "mcp://tools/" + req.Params.Name,
+					MaxTimeoutSeconds: 60,
+				}},
+			}
+			if req.Params.Name == "announced" {
+				requirements.SettlementSeconds = 1
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, requirements))
+			return
+		}
+
+		// Slow settlement, announced by a progress notification on "pending"
+		w.Header().Set("Content-Type", "text/event-stream")
+		if req.Params.Name == "pending" {
+			notification := mcp.JSONRPCNotification{
+				JSONRPC: mcp.JSONRPC_VERSION,
+				Notification: mcp.Notification{
+					Method: "notifications/progress",
+					Params: mcp.NotificationParams{Meta: map[string]any{MetaKeySettlementPending: SettlementPending{ExtendSeconds: 1}}},
+				},
+			}
+			data, _ := json.Marshal(notification)
+			fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+		}
+		w.(http.Flusher).Flush()
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+		data, _ := json.Marshal(createSuccessResponse(req.ID, true))
+		fmt.Fprintf(w, "event: message\ndata: %s\n\n", data)
+	}))
+	defer server.Close()
+
+	send := func(tool string, maxExtension time.Duration) error {
+		trans, err := New(Config{
+			ServerURL:            server.URL,
+			Signers:              []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			MaxPaymentOverhead:   50 * time.Millisecond,
+			MaxDeadlineExtension: maxExtension,
+		})
+		require.NoError(t, err)
+
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": tool},
+		})
+		return err
+	}
+
+	assert.NoError(t, send("announced", time.Second), "the 402 hint extends the deadline")
+	assert.NoError(t, send("pending", time.Second), "the settlement-pending notification extends the deadline")
+	assert.ErrorIs(t, send("silent", time.Second), ErrPaymentOverheadExceeded)
+	assert.ErrorIs(t, send("announced", 0), ErrPaymentOverheadExceeded, "hints are ignored without MaxDeadlineExtension")
+	assert.ErrorIs(t, send("announced", 10*time.Millisecond), ErrPaymentOverheadExceeded, "extensions are bounded")
+}
+
+func TestPaymentDeadline_Extend(t *testing.T) {
+	d, cancel := newPaymentDeadline(context.Background(), time.Minute, 90*time.Second)
+	defer cancel()
+	before, _ := d.Deadline()
+
+	assert.Equal(t, time.Minute, d.extend(time.Minute))
+	assert.Equal(t, 30*time.Second, d.extend(time.Minute), "capped by the remaining budget")
+	assert.Zero(t, d.extend(time.Minute))
+
+	after, _ := d.Deadline()
+	assert.Equal(t, 90*time.Second, after.Sub(before))
+
+	// The parent's deadline still applies
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Second)
+	defer cancelParent()
+	d, cancel = newPaymentDeadline(parent, time.Minute, time.Minute)
+	defer cancel()
+	deadline, _ := d.Deadline()
+	parentDeadline, _ := parent.Deadline()
+	assert.Equal(t, parentDeadline, deadline)
+}
+
+// fakeBridge moves balances between networks of an in-memory ledger
+type fakeBridge struct {
+	fee      *big.Int
+	balances map[string]*big.Int
+	bridged  []BridgeRequest
+}
+
+func (b *fakeBridge) Quote(ctx context.Context, req BridgeRequest) (*BridgeQuote, error) {
+	return &BridgeQuote{Fee: b.fee}, nil
+}
+
+func (b *fakeBridge) Bridge(ctx context.Context, req BridgeRequest) (*BridgeReceipt, error) {
+	b.bridged = append(b.bridged, req)
+	b.balances[req.FromNetwork].Sub(b.balances[req.FromNetwork], new(big.Int).Add(req.Amount, b.fee))
+	b.balances[req.ToNetwork].Add(b.balances[req.ToNetwork], req.Amount)
+	return &BridgeReceipt{Transaction: "0xbridge", Delivered: req.Amount}, nil
+}
+
+func (b *fakeBridge) balance(ctx context.Context, network, asset, address string) (*big.Int, error) {
+	return b.balances[network], nil
+}
+
+func TestPaymentHandler_Bridging(t *testing.T) {
+	polygonOnly := PaymentRequirementsResponse{
+		X402Version: 1,
+		Accepts: []PaymentRequirement{{
+			Scheme:            "exact",
+			Network:           "polygon",
+			MaxAmountRequired: "10000",
+			Asset:             USDCAddressPolygon,
+			PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+			Resource:          "mcp://tools/search",
+			MaxTimeoutSeconds: 60,
+		}},
+	}
+
+	newHandler := func(bridge *fakeBridge, budget *big.Int) *PaymentHandler {
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase(), AcceptUSDCPolygon()), &HandlerConfig{
+			Bridging: &BridgingConfig{
+				Provider: bridge,
+				Balance:  bridge.balance,
+				Sources:  []ClientPaymentOption{AcceptUSDCBase()},
+				MaxFee:   big.NewInt(500),
+				Budget:   budget,
+			},
+		})
+		require.NoError(t, err)
+		return handler
+	}
+
+	t.Run("BridgesShortfall", func(t *testing.T) {
+		bridge := &fakeBridge{fee: big.NewInt(100), balances: map[string]*big.Int{
+			"base":    big.NewInt(50000),
+			"polygon": big.NewInt(4000),
+		}}
+
+		payment, err := newHandler(bridge, nil).CreatePayment(context.Background(), polygonOnly)
+		require.NoError(t, err)
+		assert.Equal(t, "polygon", payment.Network)
+
+		require.Len(t, bridge.bridged, 1)
+		assert.Equal(t, "base", bridge.bridged[0].FromNetwork)
+		assert.Equal(t, big.NewInt(6000), bridge.bridged[0].Amount)
+		assert.Equal(t, big.NewInt(10000), bridge.balances["polygon"])
+		assert.Equal(t, big.NewInt(43900), bridge.balances["base"])
+	})
+
+	t.Run("FundedWalletDoesNotBridge", func(t *testing.T) {
+		bridge := &fakeBridge{fee: big.NewInt(100), balances: map[string]*big.Int{
+			"base":    big.NewInt(50000),
+			"polygon": big.NewInt(10000),
+		}}
+
+		_, err := newHandler(bridge, nil).CreatePayment(context.Background(), polygonOnly)
+		require.NoError(t, err)
+		assert.Empty(t, bridge.bridged)
+	})
+
+	t.Run("BudgetExceeded", func(t *testing.T) {
+		bridge := &fakeBridge{fee: big.NewInt(100), balances: map[string]*big.Int{
+			"base":    big.NewInt(50000),
+			"polygon": big.NewInt(0),
+		}}
+
+		handler := newHandler(bridge, big.NewInt(15000))
+		_, err := handler.CreatePayment(context.Background(), polygonOnly)
+		require.NoError(t, err)
+
+		// Spending the funds bridged by the first payment, the second needs 10100 more
+		bridge.balances["polygon"].SetInt64(0)
+		_, err = handler.CreatePayment(context.Background(), polygonOnly)
+		assert.ErrorIs(t, err, ErrInsufficientFunds)
+		assert.ErrorIs(t, err, ErrBridgeBudgetExceeded)
+		assert.Len(t, bridge.bridged, 1)
+	})
+
+	t.Run("FeeCap", func(t *testing.T) {
+		bridge := &fakeBridge{fee: big.NewInt(1000), balances: map[string]*big.Int{
+			"base":    big.NewInt(50000),
+			"polygon": big.NewInt(0),
+		}}
+
+		_, err := newHandler(bridge, nil).CreatePayment(context.Background(), polygonOnly)
+		assert.ErrorIs(t, err, ErrBridgeBudgetExceeded)
+		assert.Empty(t, bridge.bridged)
+	})
+}
+
+func TestX402Transport_CapabilityFlow(t *testing.T) {
+	trans, err := New(Config{
+		ServerURL: "http://localhost",
+		Signers:   []PaymentSigner{NewMockSigner("0xTestWallet")},
+	})
+	require.NoError(t, err)
+
+	// Without a capability, the flow follows how the 402 arrived
+	assert.True(t, trans.useHeaderFlow(true))
+	assert.False(t, trans.useHeaderFlow(false))
+
+	capability, ok := ParseCapability(json.RawMessage(`{"capabilities":{"experimental":{"x402":{"versions":[1],"flows":["header"]}}}}`))
+	require.True(t, ok)
+	trans.capability.Store(capability)
+	assert.True(t, trans.useHeaderFlow(false), "header-only server must be paid in X-PAYMENT")
+
+	capability, ok = ParseCapability(json.RawMessage(`{"capabilities":{"experimental":{"x402":{"versions":[1],"flows":["meta"]}}}}`))
+	require.True(t, ok)
+	trans.capability.Store(capability)
+	assert.False(t, trans.useHeaderFlow(true), "meta-only server must be paid in _meta")
+
+	_, ok = ParseCapability(json.RawMessage(`{"capabilities":{"tools":{}}}`))
+	assert.False(t, ok)
+}
+
+func TestX402Transport_OfflineSigning(t *testing.T) {
+	var paid PaymentPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+
+		var params map[string]any
+		paramsBytes, _ := json.Marshal(req.Params)
+		_ = json.Unmarshal(paramsBytes, &params)
+		meta, _ := params["_meta"].(map[string]any)
+
+		w.Header().Set("Content-Type", "application/json")
+		if meta == nil || meta["x402/payment"] == nil {
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Error:       "Payment required",
+				Accepts: []PaymentRequirement{
+					{
+						Scheme:            "exact",
+						Network:           "polygon-amoy",
+						MaxAmountRequired: "500",
+						Asset:             USDCAddressPolygonAmoy,
+						PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+						Resource:          "mcp://tools/search",
+						MaxTimeoutSeconds: 60,
+						Extra:             map[string]string{"name": "USDC", "version": "2"},
+					},
+					{
+						Scheme:            "exact",
+						Network:           "base-sepolia",
+						MaxAmountRequired: "1000",
+						Asset:             USDCAddressBaseSepolia,
+						PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+						Resource:          "mcp://tools/search",
+						MaxTimeoutSeconds: 60,
+						Extra:             map[string]string{"name": "USDC", "version": "2"},
+					},
+				},
+			}))
+			return
+		}
+		paymentBytes, _ := json.Marshal(meta["x402/payment"])
+		_ = json.Unmarshal(paymentBytes, &paid)
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	// The online machine holds no keys that can pay
+	trans, err := New(Config{
+		ServerURL:            server.URL,
+		Signers:              []PaymentSigner{NewMockSigner("0xWatchOnly", AcceptUSDCSolanaDevnet())},
+		ManualPaymentMode:    true,
+		BindPaymentToRequest: true,
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	request := transport.JSONRPCRequest{
+		ID:     mcp.NewRequestId("offline-1"),
+		Method: "tools/call",
+		Params: map[string]any{"name": "search"},
+	}
+	_, err = trans.SendRequest(ctx, request)
+	var paymentErr *PaymentRequiredError
+	require.ErrorAs(t, err, &paymentErr)
+
+	_, err = trans.ExportPaymentRequest(paymentErr.RequestID, 2)
+	assert.Error(t, err, "option out of range")
+
+	exported, err := trans.ExportPaymentRequest(paymentErr.RequestID, 1)
+	require.NoError(t, err)
+
+	var offline OfflinePaymentRequest
+	require.NoError(t, json.Unmarshal(exported, &offline))
+	assert.Equal(t, "base-sepolia", offline.Requirement.Network)
+	assert.Equal(t, "tools/call", offline.Method)
+	assert.Len(t, offline.Requirements.Accepts, 2)
+	assert.NotEmpty(t, offline.BindingNonce)
+	assert.Equal(t, paymentErr.BindingNonce, offline.BindingNonce)
+
+	// On the air-gapped machine
+	keyHolder, err := NewPrivateKeySigner(
+		"0x1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+		AcceptUSDCBaseSepolia(),
+	)
+	require.NoError(t, err)
+
+	_, err = SignOfflinePaymentRequest(ctx, NewMockSigner("0xPolygonOnly", AcceptUSDCPolygonAmoy()), exported)
+	assert.ErrorIs(t, err, ErrNoAcceptablePayment)
+
+	signed, err := SignOfflinePaymentRequest(ctx, keyHolder, exported)
+	require.NoError(t, err)
+
+	// Back online
+	resp, err := trans.ImportSignedPayment(ctx, signed)
+	require.NoError(t, err)
+	assert.Nil(t, resp.Error)
+
+	assert.Equal(t, "base-sepolia", paid.Network)
+	payload, _ := paid.Payload.(map[string]any)
+	authorization, _ := payload["authorization"].(map[string]any)
+	assert.Equal(t, keyHolder.GetAddress(), authorization["from"])
+	assert.Equal(t, offline.BindingNonce, authorization["nonce"], "payment must be bound to the request")
+}
+
+func TestPaymentHandler_CheckDeadline(t *testing.T) {
+	reqs := PaymentRequirementsResponse{
+		X402Version: 1,
+		Accepts: []PaymentRequirement{
+			{
+				Scheme:            "exact",
+				Network:           "base",
+				MaxAmountRequired: "10000",
+				Asset:             USDCAddressBase,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/render",
+				MaxTimeoutSeconds: 600,
+			},
+			{
+				Scheme:            "exact",
+				Network:           "polygon",
+				MaxAmountRequired: "10000",
+				Asset:             USDCAddressPolygon,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/render",
+				MaxTimeoutSeconds: 60,
+			},
+		},
+	}
+
+	newHandler := func(check bool) *PaymentHandler {
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase(), AcceptUSDCPolygon()), &HandlerConfig{CheckDeadline: check})
+		require.NoError(t, err)
+		return handler
+	}
+	withDeadline := func(d time.Duration) context.Context {
+		ctx, cancel := context.WithTimeout(context.Background(), d)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	t.Run("SkipsOptionsOutlastingDeadline", func(t *testing.T) {
+		payment, err := newHandler(true).CreatePayment(withDeadline(2*time.Minute), reqs)
+		require.NoError(t, err)
+		assert.Equal(t, "polygon", payment.Network)
+	})
+
+	t.Run("NoOptionFits", func(t *testing.T) {
+		_, err := newHandler(true).CreatePayment(withDeadline(30*time.Second), reqs)
+		assert.ErrorIs(t, err, ErrDeadlineTooShort)
+	})
+
+	t.Run("NoDeadline", func(t *testing.T) {
+		_, err := newHandler(true).CreatePayment(context.Background(), reqs)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		_, err := newHandler(false).CreatePayment(withDeadline(30*time.Second), reqs)
+		assert.NoError(t, err)
+	})
+
+	t.Run("UsesClock", func(t *testing.T) {
+		// Two minutes of deadline, of which the clock says 90s have passed
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase(), AcceptUSDCPolygon()), &HandlerConfig{
+			CheckDeadline: true,
+			Clock:         NewFakeClock(time.Now().Add(90 * time.Second)),
+		})
+		require.NoError(t, err)
+		_, err = handler.CreatePayment(withDeadline(2*time.Minute), reqs)
+		assert.ErrorIs(t, err, ErrDeadlineTooShort)
+	})
+}
+
+func TestQuote_CapAccepts(t *testing.T) {
+	option := func(network, amount string) PaymentRequirement {
+		return PaymentRequirement{
+			Scheme:            "exact",
+			Network:           network,
+			MaxAmountRequired: amount,
+			Asset:             USDCAddressBase,
+			PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+		}
+	}
+	quote := &Quote{ID: "q1", Tool: "search", Accepts: []PaymentRequirement{option("base", "1000"), option("polygon", "500")}}
+
+	capped, err := quote.capAccepts([]PaymentRequirement{option("base", "1000"), option("polygon", "800"), option("avalanche", "1")})
+	require.NoError(t, err)
+	require.Len(t, capped, 1)
+	assert.Equal(t, "base", capped[0].Network)
+
+	_, err = quote.capAccepts([]PaymentRequirement{option("base", "1001")})
+	assert.ErrorIs(t, err, ErrQuoteExceeded)
+
+	parsed, err := ParseQuote("not-a-quote")
+	assert.Error(t, err)
+	assert.Nil(t, parsed)
+}
+
+func TestPaymentHandler_FeeEstimator(t *testing.T) {
+	reqs := PaymentRequirementsResponse{
+		X402Version: 1,
+		Accepts: []PaymentRequirement{{
+			Scheme:            "exact",
+			Network:           "base",
+			MaxAmountRequired: "10000",
+			Asset:             USDCAddressBase,
+			PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+			Resource:          "mcp://tools/search",
+			MaxTimeoutSeconds: 60,
+		}},
+	}
+	fee := func(amount int64) FeeEstimator {
+		return FeeEstimatorFunc(func(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*big.Int, error) {
+			return big.NewInt(amount), nil
+		})
+	}
+
+	t.Run("CallbackSeesTotalCost", func(t *testing.T) {
+		var approved *big.Int
+		var events []PaymentEvent
+		handler, err := NewPaymentHandlerMulti([]PaymentSigner{
+			NewMockSigner("0xFirst", AcceptUSDCPolygon()),
+			NewMockSigner("0xSecond", AcceptUSDCBase()),
+		}, &HandlerConfig{
+			FeeEstimator:    fee(300),
+			PaymentCallback: func(amount *big.Int, resource string) bool { approved = amount; return true },
+			OnSignerAttempt: func(e PaymentEvent) { events = append(events, e) },
+		})
+		require.NoError(t, err)
+
+		_, err = handler.CreatePayment(withFeeEstimate(context.Background()), reqs)
+		require.NoError(t, err)
+		assert.Equal(t, "10300", approved.String())
+
+		success := events[len(events)-1]
+		require.Equal(t, PaymentEventSignerSuccess, success.Type)
+		assert.Equal(t, "300", success.NetworkFee.String())
+		assert.Equal(t, "10300", success.TotalCost.String())
+	})
+
+	t.Run("MaxAmountCoversFees", func(t *testing.T) {
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase().WithMaxAmount("10500")), &HandlerConfig{FeeEstimator: fee(1000)})
+		require.NoError(t, err)
+		_, err = handler.CreatePayment(context.Background(), reqs)
+		assert.ErrorIs(t, err, ErrPaymentDeclined)
+
+		handler, err = NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase().WithMaxAmount("10500")), &HandlerConfig{FeeEstimator: fee(500)})
+		require.NoError(t, err)
+		_, err = handler.CreatePayment(context.Background(), reqs)
+		assert.NoError(t, err)
+	})
+
+	t.Run("EstimationFailureBlocksPayment", func(t *testing.T) {
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase()), &HandlerConfig{
+			FeeEstimator: FeeEstimatorFunc(func(ctx context.Context, signer PaymentSigner, req PaymentRequirement) (*big.Int, error) {
+				return nil, errors.New("rpc down")
+			}),
+		})
+		require.NoError(t, err)
+		_, err = handler.CreatePayment(context.Background(), reqs)
+		assert.ErrorContains(t, err, "estimating network fee")
+	})
+}
+
+func TestBudgetManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("TracksAssetsSeparately", func(t *testing.T) {
+		budget, err := NewBudgetManager(BudgetLimits{PerAsset: map[AssetKey]*big.Int{
+			{Network: "base", Asset: USDCAddressBase}:  big.NewInt(10000),
+			{Network: "solana", Asset: USDCMintSolana}: big.NewInt(5000),
+		}})
+		require.NoError(t, err)
+
+		require.NoError(t, budget.RecordSpend(ctx, "base", strings.ToLower(USDCAddressBase), big.NewInt(8000)))
+		require.NoError(t, budget.CanSpend(ctx, "solana", USDCMintSolana, big.NewInt(5000)))
+		assert.ErrorIs(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(3000)), ErrBudgetExceeded)
+		assert.Equal(t, "8000", budget.Spent("base", USDCAddressBase).String())
+		assert.Equal(t, "0", budget.Spent("solana", USDCMintSolana).String())
+	})
+
+	t.Run("ReferenceCurrency", func(t *testing.T) {
+		// Values USDC (6 decimals) and a hypothetical 18-decimal stablecoin in cents
+		oracle := PriceOracleFunc(func(ctx context.Context, network, asset string, amount *big.Int) (*big.Int, error) {
+			if network == "ethereum" {
+				return new(big.Int).Div(amount, big.NewInt(1e16)), nil
+			}
+			return new(big.Int).Div(amount, big.NewInt(1e4)), nil
+		})
+		budget, err := NewBudgetManager(BudgetLimits{Reference: big.NewInt(300), Oracle: oracle})
+		require.NoError(t, err)
+
+		require.NoError(t, budget.RecordSpend(ctx, "base", USDCAddressBase, big.NewInt(1_000_000)))                             // $1
+		require.NoError(t, budget.RecordSpend(ctx, "ethereum", "0xstable", new(big.Int).Mul(big.NewInt(15), big.NewInt(1e17)))) // $1.50
+		assert.Equal(t, "250", budget.SpentValue().String())
+		assert.NoError(t, budget.CanSpend(ctx, "solana", USDCMintSolana, big.NewInt(500_000)))
+		assert.ErrorIs(t, budget.CanSpend(ctx, "solana", USDCMintSolana, big.NewInt(600_000)), ErrBudgetExceeded)
+
+		_, err = NewBudgetManager(BudgetLimits{Reference: big.NewInt(1)})
+		assert.Error(t, err, "reference limits need an oracle")
+	})
+
+	t.Run("RollingWindow", func(t *testing.T) {
+		budget, err := NewBudgetManager(BudgetLimits{PerAsset: map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(100)}})
+		require.NoError(t, err)
+		now := time.Now()
+		budget.now = func() time.Time { return now }
+
+		require.NoError(t, budget.RecordSpend(ctx, "base", USDCAddressBase, big.NewInt(100)))
+		assert.ErrorIs(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(1)), ErrBudgetExceeded)
+
+		now = now.Add(time.Hour + time.Second)
+		assert.NoError(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(100)))
+	})
+
+	t.Run("CalendarPeriods", func(t *testing.T) {
+		tokyo := time.FixedZone("JST", 9*60*60)
+		reports := make(chan BudgetReport, 1)
+		budget, err := NewBudgetManager(BudgetLimits{
+			PerAsset:    map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(100)},
+			Period:      BudgetDaily,
+			Location:    tokyo,
+			OnPeriodEnd: func(report BudgetReport) { reports <- report },
+		})
+		require.NoError(t, err)
+		now := time.Date(2025, 3, 10, 23, 0, 0, 0, tokyo)
+		budget.now = func() time.Time { return now }
+
+		toolCtx := WithRequestTool(WithRequestMethod(ctx, "tools/call"), "search")
+		require.NoError(t, budget.RecordSpend(toolCtx, "base", USDCAddressBase, big.NewInt(60)))
+		require.NoError(t, budget.RecordSpend(toolCtx, "base", USDCAddressBase, big.NewInt(30)))
+		require.NoError(t, budget.RecordSpend(ctx, "base", USDCAddressBase, big.NewInt(10)))
+		assert.ErrorIs(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(1)), ErrBudgetExceeded)
+
+		// Midnight in Tokyo resets the budget, well within an hour of the spends
+		now = time.Date(2025, 3, 11, 0, 0, 1, 0, tokyo)
+		assert.NoError(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(100)))
+		assert.Equal(t, int64(0), budget.Spent("base", USDCAddressBase).Int64())
+
+		select {
+		case report := <-reports:
+			assert.Equal(t, BudgetDaily, report.Period)
+			assert.True(t, report.Start.Equal(time.Date(2025, 3, 10, 0, 0, 0, 0, tokyo)))
+			assert.True(t, report.End.Equal(time.Date(2025, 3, 11, 0, 0, 0, 0, tokyo)))
+			require.Len(t, report.Lines, 2)
+			assert.Equal(t, "", report.Lines[0].Tool)
+			assert.Equal(t, int64(10), report.Lines[0].Amount.Int64())
+			assert.Equal(t, "search", report.Lines[1].Tool)
+			assert.Equal(t, "base", report.Lines[1].Network)
+			assert.Equal(t, int64(90), report.Lines[1].Amount.Int64())
+			assert.Equal(t, 2, report.Lines[1].Payments)
+		case <-time.After(time.Second):
+			t.Fatal("no report at the end of the period")
+		}
+	})
+
+	t.Run("FakeClock", func(t *testing.T) {
+		clock := NewFakeClock(time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC))
+		budget, err := NewBudgetManager(BudgetLimits{
+			PerAsset: map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(100)},
+			Period:   BudgetMonthly,
+			Clock:    clock,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, budget.RecordSpend(ctx, "base", USDCAddressBase, big.NewInt(100)))
+		assert.ErrorIs(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(1)), ErrBudgetExceeded)
+
+		// The leap day's last second still belongs to February
+		clock.Advance(999 * time.Millisecond)
+		assert.ErrorIs(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(1)), ErrBudgetExceeded)
+
+		clock.Advance(time.Millisecond)
+		assert.NoError(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(100)))
+	})
+
+	t.Run("PeriodStarts", func(t *testing.T) {
+		at := time.Date(2025, 3, 13, 15, 4, 5, 0, time.UTC) // A Thursday
+		assert.Equal(t, time.Date(2025, 3, 13, 0, 0, 0, 0, time.UTC), BudgetDaily.start(at, time.UTC))
+		assert.Equal(t, time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), BudgetWeekly.start(at, time.UTC))
+		assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), BudgetMonthly.start(at, time.UTC))
+		assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), BudgetMonthly.next(BudgetMonthly.start(at, time.UTC)))
+
+		_, err := NewBudgetManager(BudgetLimits{Period: BudgetPeriod(42)})
+		assert.Error(t, err)
+	})
+
+	t.Run("EnforcedByHandler", func(t *testing.T) {
+		budget, err := NewBudgetManager(BudgetLimits{PerAsset: map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(15000)}})
+		require.NoError(t, err)
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase()), &HandlerConfig{Budget: budget})
+		require.NoError(t, err)
+
+		reqs := PaymentRequirementsResponse{X402Version: 1, Accepts: []PaymentRequirement{{
+			Scheme:            "exact",
+			Network:           "base",
+			MaxAmountRequired: "10000",
+			Asset:             USDCAddressBase,
+			PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+			MaxTimeoutSeconds: 60,
+		}}}
+		_, err = handler.CreatePayment(ctx, reqs)
+		require.NoError(t, err)
+		_, err = handler.CreatePayment(ctx, reqs)
+		assert.ErrorIs(t, err, ErrBudgetExceeded)
+	})
+
+	t.Run("Reservations", func(t *testing.T) {
+		budget, err := NewBudgetManager(BudgetLimits{PerAsset: map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(1000)}})
+		require.NoError(t, err)
+
+		// Concurrent reservations can't jointly exceed the limit
+		var wg sync.WaitGroup
+		var reserved atomic.Int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := budget.Reserve(ctx, "base", USDCAddressBase, big.NewInt(100)); err == nil {
+					reserved.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(10), reserved.Load())
+		assert.Equal(t, "1000", budget.Reserved("base", USDCAddressBase).String())
+		assert.ErrorIs(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(1)), ErrBudgetExceeded)
+
+		budget, err = NewBudgetManager(BudgetLimits{PerAsset: map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(1000)}})
+		require.NoError(t, err)
+		committed, err := budget.Reserve(ctx, "base", USDCAddressBase, big.NewInt(600))
+		require.NoError(t, err)
+		released, err := budget.Reserve(ctx, "base", USDCAddressBase, big.NewInt(400))
+		require.NoError(t, err)
+
+		committed.Commit()
+		released.Release()
+		released.Commit() // No effect once released
+		assert.Equal(t, "600", budget.Spent("base", USDCAddressBase).String())
+		assert.Equal(t, "0", budget.Reserved("base", USDCAddressBase).String())
+		assert.NoError(t, budget.CanSpend(ctx, "base", USDCAddressBase, big.NewInt(400)))
+	})
+
+	t.Run("PerMethod", func(t *testing.T) {
+		base := AssetKey{Network: "base", Asset: USDCAddressBase}
+		budget, err := NewBudgetManager(BudgetLimits{PerMethod: map[string]MethodLimits{
+			"tools/call": {
+				PerRequest: map[AssetKey]*big.Int{base: big.NewInt(5000)},
+				PerAsset:   map[AssetKey]*big.Int{base: big.NewInt(8000)},
+			},
+		}})
+		require.NoError(t, err)
+		handler, err := NewPaymentHandler(NewMockSigner("0xTestWallet", AcceptUSDCBase()), &HandlerConfig{Budget: budget})
+		require.NoError(t, err)
+
+		reqs := func(amount string) PaymentRequirementsResponse {
+			return PaymentRequirementsResponse{X402Version: 1, Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base",
+				MaxAmountRequired: amount,
+				Asset:             USDCAddressBase,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				MaxTimeoutSeconds: 60,
+			}}}
+		}
+		toolCtx := WithRequestMethod(ctx, "tools/call")
+		readCtx := WithRequestMethod(ctx, "resources/read")
+
+		_, err = handler.CreatePayment(toolCtx, reqs("6000"))
+		assert.ErrorIs(t, err, ErrBudgetExceeded, "over the per-request cap")
+		_, err = handler.CreatePayment(toolCtx, reqs("5000"))
+		require.NoError(t, err)
+		_, err = handler.CreatePayment(toolCtx, reqs("5000"))
+		assert.ErrorIs(t, err, ErrBudgetExceeded, "over the method's total")
+
+		// Other methods aren't capped
+		_, err = handler.CreatePayment(readCtx, reqs("20000"))
+		assert.NoError(t, err)
+		assert.Equal(t, "25000", budget.Spent("base", USDCAddressBase).String())
+	})
+
+	t.Run("ReleasedWhenRejected", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ID mcp.RequestId `json:"id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			w.Header().Set("Content-Type", "application/json")
+			// Every payment is answered with another 402
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "base",
+					MaxAmountRequired: "1000",
+					Asset:             USDCAddressBase,
+					PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+					MaxTimeoutSeconds: 60,
+				}},
+			}))
+		}))
+		defer server.Close()
+
+		budget, err := NewBudgetManager(BudgetLimits{PerAsset: map[AssetKey]*big.Int{{Network: "base", Asset: USDCAddressBase}: big.NewInt(1000)}})
+		require.NoError(t, err)
+		trans, err := New(Config{
+			ServerURL: server.URL,
+			Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBase())},
+			Budget:    budget,
+		})
+		require.NoError(t, err)
+
+		_, err = trans.SendRequest(ctx, transport.JSONRPCRequest{ID: mcp.NewRequestId(1), Method: "tools/call", Params: map[string]any{"name": "search"}})
+		assert.ErrorIs(t, err, ErrPaymentRejected)
+		assert.Equal(t, "0", budget.Spent("base", USDCAddressBase).String())
+		assert.Equal(t, "0", budget.Reserved("base", USDCAddressBase).String())
+	})
+}
+
+func TestLogRedactionAndSampling(t *testing.T) {
+	address := "0x1234567890abcdef1234567890abcdef12345678"
+	assert.Equal(t, address, RedactNone.Redact(address))
+	assert.Equal(t, "0x1234…345678", RedactPartial.Redact(address))
+	assert.Equal(t, "[redacted]", RedactPartial.Redact("0xshort"))
+	assert.Equal(t, "[redacted]", RedactFull.Redact(address))
+	assert.Equal(t, "", RedactFull.Redact(""))
+
+	sampler := NewLogSampler(3)
+	var sampled []bool
+	for range 6 {
+		sampled = append(sampled, sampler.Sample())
+	}
+	assert.Equal(t, []bool{true, false, false, true, false, false}, sampled)
+	assert.True(t, NewLogSampler(0).Sample())
+}
+
+func TestChaosTransport(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":{}}`))
+	}))
+	defer server.Close()
+
+	post := func(config ChaosConfig) (*http.Response, error) {
+		client := &http.Client{Transport: NewChaosTransport(nil, config)}
+		return client.Post(server.URL, "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"ping"}`))
+	}
+
+	resp, err := post(ChaosConfig{DuplicateRate: 1})
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, int32(2), requests.Load())
+
+	_, err = post(ChaosConfig{DropRate: 1})
+	assert.ErrorIs(t, err, ErrChaosDropped)
+	assert.Equal(t, int32(3), requests.Load(), "dropped requests still reach the server")
+
+	resp, err = post(ChaosConfig{MalformedRate: 1})
+	require.NoError(t, err)
+	var body map[string]any
+	assert.Error(t, json.NewDecoder(resp.Body).Decode(&body))
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	_, err = (&http.Client{Transport: NewChaosTransport(nil, ChaosConfig{Delay: time.Minute})}).Do(req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestX402Transport_Flush(t *testing.T) {
+	paid := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req transport.JSONRPCRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		params, _ := req.Params.(map[string]any)
+		if meta, _ := params["_meta"].(map[string]any); meta["x402/payment"] == nil {
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme: "exact", Network: "base-sepolia", MaxAmountRequired: "1000",
+					Asset: USDCAddressBaseSepolia, PayTo: "0xrecipient", MaxTimeoutSeconds: 60,
+				}},
+			}))
+			return
+		}
+		close(paid)
+		<-release
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	var succeeded atomic.Bool
+	trans, err := New(Config{
+		ServerURL:        server.URL,
+		Signers:          []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		OnPaymentSuccess: func(PaymentEvent) { succeeded.Store(true) },
+	})
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			JSONRPC: "2.0", ID: mcp.NewRequestId(1), Method: "tools/call", Params: map[string]any{"name": "search"},
+		})
+	}()
+	<-paid
+
+	// The payment is still in progress
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, trans.Flush(ctx), context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, trans.Flush(context.Background()))
+	assert.True(t, succeeded.Load(), "Flush returned before the success callback")
+	require.NoError(t, trans.Close())
+}
+
+func TestEventSinks(t *testing.T) {
+	var posted sync.Map
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Type, Text string }
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		posted.Store(r.URL.Path+" "+body.Type+body.Text, r.Header.Get("Authorization"))
+	}))
+	defer hook.Close()
+
+	channel := NewChannelSink(10)
+	trans, err := New(Config{
+		ServerURL: "http://example.com/mcp",
+		Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+		EventSinks: []EventSink{
+			channel,
+			NewWebhookSink(hook.URL+"/webhook", map[string]string{"Authorization": "Bearer secret"}),
+			NewSlackSink(hook.URL + "/slack"),
+		},
+		SpendThresholds: map[AssetKey][]*big.Int{
+			{Network: "base-sepolia", Asset: USDCAddressBaseSepolia}: {big.NewInt(2500), big.NewInt(1500)},
+		},
+		FailureAlertAfter: 2,
+	})
+	require.NoError(t, err)
+
+	reqs := PaymentRequirementsResponse{Accepts: []PaymentRequirement{{
+		Scheme: "exact", Network: "base-sepolia", MaxAmountRequired: "1000", Asset: USDCAddressBaseSepolia, PayTo: "0xrecipient",
+	}}}
+	ctx := context.Background()
+	for range 3 {
+		trans.recordPaymentEvent(ctx, PaymentEventSuccess, "tools/call", reqs)
+	}
+	for range 3 {
+		trans.recordPaymentError(ctx, PaymentEventFailure, "tools/call", reqs, errors.New("settlement failed"))
+	}
+	trans.recordPaymentEvent(ctx, PaymentEventSuccess, "tools/call", reqs)
+	require.NoError(t, trans.Flush(ctx))
+
+	var events []WalletEvent
+	for len(channel.Events()) > 0 {
+		events = append(events, <-channel.Events())
+	}
+	require.Len(t, events, 4)
+	assert.Equal(t, WalletEventSpendThreshold, events[0].Type)
+	assert.Equal(t, "1500", events[0].Threshold)
+	assert.Equal(t, "2000", events[0].Spent)
+	assert.Equal(t, "2500", events[1].Threshold)
+	assert.Equal(t, WalletEventPaymentsFailing, events[2].Type)
+	assert.Equal(t, 2, events[2].Failures)
+	assert.Equal(t, "settlement failed", events[2].Error)
+	assert.Equal(t, WalletEventPaymentsRecovered, events[3].Type)
+	assert.Equal(t, "http://example.com/mcp", events[3].Server)
+	assert.Equal(t, []string{"0xTestWallet"}, events[3].Payers)
+
+	auth, ok := posted.Load("/webhook payments_failing")
+	assert.True(t, ok, "webhook not notified")
+	assert.Equal(t, "Bearer secret", auth)
+	_, ok = posted.Load("/slack x402: payments to http://example.com/mcp are succeeding again")
+	assert.True(t, ok, "Slack not notified")
+
+	// Events are dropped rather than blocking when the channel is full
+	full := NewChannelSink(0)
+	assert.Error(t, full.Notify(ctx, WalletEvent{Type: WalletEventPaymentsRecovered}))
+}
+
+func TestValidateOutputSchema(t *testing.T) {
+	var schema any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["results", "total"],
+		"additionalProperties": false,
+		"properties": {
+			"results": {"type": "array", "maxItems": 2, "items": {"type": "string", "minLength": 1}},
+			"total": {"type": "integer", "minimum": 0},
+			"status": {"enum": ["ok", "partial"]}
+		}
+	}`), &schema))
+
+	decode := func(s string) any {
+		var v any
+		require.NoError(t, json.Unmarshal([]byte(s), &v))
+		return v
+	}
+	assert.Empty(t, ValidateOutputSchema(schema, decode(`{"results": ["a"], "total": 1, "status": "ok"}`)))
+	assert.Equal(t, []string{
+		"$: missing required property \"total\"",
+		"$: unexpected property \"extra\"",
+		"$.results: expected at most 2 items, got 3",
+		"$.results[1]: expected at least 1 characters",
+		"$.status: value not in enum",
+	}, ValidateOutputSchema(schema, decode(`{"results": ["a", "", "c"], "status": "failed", "extra": 1}`)))
+	assert.Equal(t, []string{"$.total: expected integer, got number"},
+		ValidateOutputSchema(schema, decode(`{"results": [], "total": 1.5}`)))
+	assert.Equal(t, []string{"$: expected object, got string"}, ValidateOutputSchema(schema, "results"))
+}
+
+// priceRecordingSigner records the compute unit price of each payment it signs
+type priceRecordingSigner struct {
+	*MockSolanaSigner
+	mu     sync.Mutex
+	prices []uint64
+}
+
+func (s *priceRecordingSigner) SignPayment(ctx context.Context, req PaymentRequirement) (*PaymentPayload, error) {
+	s.mu.Lock()
+	s.prices = append(s.prices, ComputeUnitPriceFromContext(ctx))
+	s.mu.Unlock()
+	return s.MockSolanaSigner.SignPayment(ctx, req)
+}
+
+func TestX402Transport_PriorityFeeEscalation(t *testing.T) {
+	var failures atomic.Int32
+	failures.Store(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+
+		if req.Params.Meta["x402/payment"] == nil {
+			_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+				X402Version: 1,
+				Accepts: []PaymentRequirement{{
+					Scheme:            "exact",
+					Network:           "solana-devnet",
+					MaxAmountRequired: "1000",
+					Asset:             USDCMintSolanaDevnet,
+					PayTo:             "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU",
+					MaxTimeoutSeconds: 60,
+					Extra:             map[string]string{"feePayer": "EwWqGE4ZFKLofuestmU4LDdK7XM1N4ALgdZccwYugwGd"},
+				}},
+			}))
+			return
+		}
+		if failures.Add(-1) >= 0 {
+			_ = json.NewEncoder(w).Encode(transport.JSONRPCResponse{
+				JSONRPC: "2.0",
+				ID:      req.ID,
+				Error: &mcp.JSONRPCErrorDetails{
+					Code:    mcp.INTERNAL_ERROR,
+					Message: "transaction expired",
+					Data: map[string]any{
+						"settlementFailed":   true,
+						"network":            "solana-devnet",
+						"reason":             "transaction expired",
+						"retryWithinSeconds": 60,
+					},
+				},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+	}))
+	defer server.Close()
+
+	send := func(escalation *PriorityFeeEscalation, maxPayments int) (*priceRecordingSigner, *transport.JSONRPCResponse) {
+		failures.Store(2)
+		signer := &priceRecordingSigner{MockSolanaSigner: NewMockSolanaSigner("DYw8jCTfwHNRJhhmFcbXvVDTqWMEVFBX6ZKUmG5CNSKK", AcceptUSDCSolanaDevnet())}
+		trans, err := New(Config{
+			ServerURL:             server.URL,
+			Signers:               []PaymentSigner{signer},
+			PriorityFeeEscalation: escalation,
+			MaxPaymentsPerRequest: maxPayments,
+		})
+		require.NoError(t, err)
+		resp, err := trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+		return signer, resp
+	}
+
+	// Without escalation the settlement failure is returned
+	signer, resp := send(nil, 0)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, []uint64{DefaultComputeUnitPrice}, signer.prices)
+
+	// Each retry re-signs with a higher price, up to the cap
+	signer, resp = send(&PriorityFeeEscalation{Multiplier: 4, MaxComputeUnitPrice: 100_000}, 3)
+	assert.Nil(t, resp.Error)
+	assert.Equal(t, []uint64{DefaultComputeUnitPrice, 40_000, 100_000}, signer.prices)
+
+	// Retries stop after MaxRetries
+	signer, resp = send(&PriorityFeeEscalation{MaxRetries: 1}, 3)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, []uint64{DefaultComputeUnitPrice, 20_000}, signer.prices)
+
+	// Re-signed payments count against MaxPaymentsPerRequest
+	signer, resp = send(&PriorityFeeEscalation{}, 2)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, []uint64{DefaultComputeUnitPrice, 20_000}, signer.prices)
+}
+
+func TestSettlementFinality(t *testing.T) {
+	// An EVM node whose chain grows by a block per eth_blockNumber call
+	var head atomic.Uint64
+	var receipts, dropAfter atomic.Int32
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		var result any
+		switch req.Method {
+		case "eth_getTransactionReceipt":
+			if n := receipts.Add(1); dropAfter.Load() == 0 || n <= dropAfter.Load() {
+				result = map[string]string{"blockNumber": "0x10", "status": "0x1"}
+			}
+		case "eth_blockNumber":
+			result = fmt.Sprintf("0x%x", head.Add(1))
+		case "eth_getBlockByNumber":
+			result = map[string]string{"number": "0x0"}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	defer node.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     mcp.RequestId `json:"id"`
+			Params struct {
+				Meta map[string]any `json:"_meta"`
+			} `json:"params"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "application/json")
+		if req.Params.Meta["x402/payment"] != nil {
+			_ = json.NewEncoder(w).Encode(createSuccessResponse(req.ID, true))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(create402JSONRPCResponse(req.ID, PaymentRequirementsResponse{
+			X402Version: 1,
+			Accepts: []PaymentRequirement{{
+				Scheme:            "exact",
+				Network:           "base-sepolia",
+				MaxAmountRequired: "1000",
+				Asset:             USDCAddressBaseSepolia,
+				PayTo:             "0x209693Bc6afc0C5328bA36FaF03C514EF312287C",
+				Resource:          "mcp://tools/search",
+				MaxTimeoutSeconds: 60,
+			}},
+		}))
+	}))
+	defer server.Close()
+
+	type outcome struct {
+		event PaymentEvent
+		err   error
+	}
+	pay := func() outcome {
+		outcomes := make(chan outcome, 1)
+		trans, err := New(Config{
+			ServerURL: server.URL,
+			Signers:   []PaymentSigner{NewMockSigner("0xTestWallet", AcceptUSDCBaseSepolia())},
+			Finality: &FinalityConfig{
+				Verifier:     NewEVMSettlementVerifier(map[string]string{"base-sepolia": node.URL}),
+				Requirements: map[string]FinalityRequirement{"base-sepolia": {Confirmations: 3}},
+				PollInterval: 10 * time.Millisecond,
+				OnFinalized:  func(event PaymentEvent) { outcomes <- outcome{event: event} },
+				OnFailure:    func(event PaymentEvent, err error) { outcomes <- outcome{event: event, err: err} },
+			},
+		})
+		require.NoError(t, err)
+		defer trans.Close()
+
+		_, err = trans.SendRequest(context.Background(), transport.JSONRPCRequest{
+			ID:     mcp.NewRequestId(1),
+			Method: "tools/call",
+			Params: map[string]any{"name": "search"},
+		})
+		require.NoError(t, err)
+		select {
+		case o := <-outcomes:
+			return o
+		case <-time.After(5 * time.Second):
+			t.Fatal("Settlement finality was not reported")
+			return outcome{}
+		}
+	}
+
+	// The settlement is final once the chain is 3 blocks past it
+	head.Store(0x0f)
+	o := pay()
+	require.NoError(t, o.err)
+	assert.Equal(t, PaymentEventFinalized, o.event.Type)
+	assert.Equal(t, "0x123", o.event.Transaction)
+	assert.Equal(t, "base-sepolia", o.event.Network)
+	assert.Equal(t, "1000", o.event.Amount.String())
+	assert.GreaterOrEqual(t, head.Load(), uint64(0x12))
+
+	// A settlement leaving the chain before it is final is a reorg
+	head.Store(0x0f)
+	receipts.Store(0)
+	dropAfter.Store(1)
+	o = pay()
+	assert.ErrorIs(t, o.err, ErrSettlementReorged)
+	assert.Equal(t, PaymentEventFinalityFailure, o.event.Type)
+}
+
+func TestSettlementStatusNotification(t *testing.T) {
+	notification := func(method string, fields map[string]any) mcp.JSONRPCNotification {
+		return mcp.JSONRPCNotification{
+			JSONRPC:      mcp.JSONRPC_VERSION,
+			Notification: mcp.Notification{Method: method, Params: mcp.NotificationParams{AdditionalFields: fields}},
+		}
+	}
+
+	status, ok := settlementStatusNotification(notification(MethodSettlementStatus, map[string]any{
+		"paymentId": "p1", "status": SettlementStatusSettled, "transaction": "0xabc", "network": "base",
+	}))
+	require.True(t, ok)
+	assert.Equal(t, "p1", status.PaymentID)
+	assert.Equal(t, "0xabc", status.Transaction)
+
+	_, ok = settlementStatusNotification(notification(MethodSettlementStatus, map[string]any{"paymentId": "p1", "status": SettlementStatusPending}))
+	assert.False(t, ok, "still pending")
+	_, ok = settlementStatusNotification(notification("notifications/progress", map[string]any{"paymentId": "p1", "status": SettlementStatusSettled}))
+	assert.False(t, ok, "other method")
+
+	// Notifications reach the goroutine following the payment
+	pending := newPendingSettlements()
+	ch := pending.watch("p1")
+	pending.resolve(SettlementStatus{PaymentID: "p2", Status: SettlementStatusFailed})
+	pending.resolve(status)
+	assert.Equal(t, status, <-ch)
+}
+
+func TestTrialTracker(t *testing.T) {
+	trials := newTrialTracker(SystemClock)
+	request := transport.JSONRPCRequest{
+		Method: string(mcp.MethodToolsCall),
+		Params: map[string]any{"name": "search"},
+	}
+
+	// A free call reports the trial in its result
+	trials.observe(request, &transport.JSONRPCResponse{
+		Result: json.RawMessage(`{"content":[],"_meta":{"x402/trial":{"tool":"search","freeCalls":2,"callsRemaining":1,"active":true}}}`),
+	})
+	assert.True(t, trials.covers("search"))
+	assert.False(t, trials.covers("other"))
+
+	// The last free call leaves nothing for the next one
+	trials.observe(request, &transport.JSONRPCResponse{
+		Result: json.RawMessage(`{"content":[],"_meta":{"x402/trial":{"tool":"search","freeCalls":2,"callsRemaining":0,"active":true}}}`),
+	})
+	assert.False(t, trials.covers("search"))
+
+	// A 402 reports the ended trial
+	trials.record("search", TrialStatus{Tool: "search", Active: true, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	assert.True(t, trials.covers("search"))
+	trials.observe(request, &transport.JSONRPCResponse{Error: &mcp.JSONRPCErrorDetails{
+		Code: 402,
+		Data: map[string]any{"x402Version": 1, "accepts": []any{}, "trial": map[string]any{"tool": "search", "active": false}},
+	}})
+	assert.False(t, trials.covers("search"))
+
+	assert.False(t, TrialStatus{Active: true, ExpiresAt: 100}.CoversNextCall(time.Unix(100, 0)))
+}
@@ -0,0 +1,112 @@
+package x402
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+)
+
+// MetaKeyTrial is the _meta key servers report a payer's free trial of a tool
+// under: in the result of calls the trial covered, and in 402 data once it ended
+const MetaKeyTrial = "x402/trial"
+
+// TrialStatus is a payer's free trial of a tool
+type TrialStatus struct {
+	Tool string `json:"tool"`
+
+	// FreeCalls is the number of free calls (unlimited within the period when zero)
+	FreeCalls int `json:"freeCalls,omitempty"`
+
+	// CallsRemaining is the number of free calls left after this one
+	CallsRemaining int `json:"callsRemaining"`
+
+	// ExpiresAt is the unix time the trial ends (never when zero)
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+
+	// Active is true while calls are free
+	Active bool `json:"active"`
+}
+
+// CoversNextCall reports whether the next call at now is still free
+func (s TrialStatus) CoversNextCall(now time.Time) bool {
+	if !s.Active {
+		return false
+	}
+	if s.FreeCalls > 0 && s.CallsRemaining <= 0 {
+		return false
+	}
+	return s.ExpiresAt == 0 || now.Unix() < s.ExpiresAt
+}
+
+// ParseTrialStatus returns the trial status in a tool call result's _meta
+func ParseTrialStatus(result json.RawMessage) (TrialStatus, bool) {
+	var decoded struct {
+		Meta struct {
+			Trial *TrialStatus `json:"x402/trial"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(result, &decoded) != nil || decoded.Meta.Trial == nil {
+		return TrialStatus{}, false
+	}
+	return *decoded.Meta.Trial, true
+}
+
+// trialTracker remembers the trials servers reported per tool, so cached
+// requirements aren't paid for calls a trial still covers
+type trialTracker struct {
+	clock Clock
+
+	mu     sync.Mutex
+	trials map[string]TrialStatus
+}
+
+func newTrialTracker(clock Clock) *trialTracker {
+	return &trialTracker{clock: clock, trials: make(map[string]TrialStatus)}
+}
+
+// record remembers status for tool
+func (t *trialTracker) record(tool string, status TrialStatus) {
+	if tool == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trials[tool] = status
+}
+
+// covers reports whether the trial of tool covers its next call
+func (t *trialTracker) covers(tool string) bool {
+	if tool == "" {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	status, ok := t.trials[tool]
+	return ok && status.CoversNextCall(t.clock.Now())
+}
+
+// observe records the trial status in the response to a tool call, from the
+// result of a free call or from the data of a 402
+func (t *trialTracker) observe(request transport.JSONRPCRequest, resp *transport.JSONRPCResponse) {
+	tool := cacheableTool(request)
+	if tool == "" || resp == nil {
+		return
+	}
+	if resp.Error == nil {
+		if status, ok := ParseTrialStatus(resp.Result); ok {
+			t.record(tool, status)
+		}
+		return
+	}
+	if resp.Error.Data == nil {
+		return
+	}
+	if data, err := json.Marshal(resp.Error.Data); err == nil {
+		var requirements PaymentRequirementsResponse
+		if json.Unmarshal(data, &requirements) == nil && requirements.Trial != nil {
+			t.record(tool, *requirements.Trial)
+		}
+	}
+}
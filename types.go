@@ -6,6 +6,20 @@ import (
 	"math/big"
 )
 
+// AccessResource is the resource URI servers use for a per-session access fee
+const AccessResource = "mcp://server/access"
+
+// BatchResource is the resource URI servers use for a payment covering a batch of tool calls
+const BatchResource = "mcp://batch"
+
+// Payment schemes. Under SchemeUpto the client authorizes MaxAmountRequired as
+// an upper bound and the server settles what the call actually cost, e.g. per
+// item returned; signers with an "exact" option sign it like an exact payment.
+const (
+	SchemeExact = "exact"
+	SchemeUpto  = "upto"
+)
+
 // PaymentRequirement represents a payment method from the server
 type PaymentRequirement struct {
 	Scheme            string            `json:"scheme"`
@@ -26,6 +40,13 @@ type PaymentRequirementsResponse struct {
 	X402Version int                  `json:"x402Version"`
 	Error       string               `json:"error"`
 	Accepts     []PaymentRequirement `json:"accepts"`
+
+	// Trial is the payer's ended free trial of the tool, if it had one
+	Trial *TrialStatus `json:"trial,omitempty"`
+
+	// SettlementSeconds is how long the server expects settlement to take; see
+	// Config.MaxDeadlineExtension
+	SettlementSeconds int `json:"settlementSeconds,omitempty"`
 }
 
 // PaymentPayload is the signed payment sent in X-PAYMENT header
@@ -72,6 +93,25 @@ type SettlementResponse struct {
 	Network     string `json:"network"`
 	Payer       string `json:"payer"`
 	ErrorReason string `json:"errorReason,omitempty"`
+	Reference   string `json:"reference,omitempty"`  // Echo of the client's payment reference
+	OnBehalfOf  string `json:"onBehalfOf,omitempty"` // Echo of the end user the payment was made for
+
+	// Status is SettlementStatusPending when the server served the request
+	// before the facilitator finished settling the payment. Transaction is then
+	// empty and the outcome is reported under PaymentID, see SettlementStatus,
+	// unless the transaction was broadcast but not yet mined.
+	Status    string `json:"status,omitempty"`
+	PaymentID string `json:"paymentId,omitempty"`
+
+	// Integrity is the ResultIntegrityDigest of the paid result, optionally
+	// signed in IntegritySignature, for servers with result integrity enabled
+	Integrity          string `json:"integrity,omitempty"`
+	IntegritySignature string `json:"integritySignature,omitempty"`
+
+	// Items and Amount report what an "upto" payment was charged: the number of
+	// items the tool returned and the amount settled, at most the amount signed
+	Items  int    `json:"items,omitempty"`
+	Amount string `json:"amount,omitempty"`
 }
 
 // PaymentEvent represents a payment lifecycle event
@@ -90,8 +130,27 @@ type PaymentEvent struct {
 	SignerPriority int    // Signer's priority value
 	SignerAddress  string // Signer's address
 	AttemptNumber  int    // Sequential attempt count
+
+	// NetworkFee and TotalCost (Amount plus NetworkFee) are set once a payment
+	// option was approved, estimated by the configured FeeEstimator
+	NetworkFee *big.Int
+	TotalCost  *big.Int
+
+	// Attribution holds caller dimensions set with WithAttribution
+	Attribution map[string]string
+
+	// OnBehalfOf is the end user the payment was made for, set with WithOnBehalfOf
+	OnBehalfOf string
+
+	// FiatEstimate is the server's approximate fiat value of the payment for
+	// display (e.g. "~$0.01"), if it advertised one
+	FiatEstimate string
 }
 
+// ExtraKeyFiatEstimate is the requirement Extra key servers advertise an
+// approximate fiat value of the payment under
+const ExtraKeyFiatEstimate = "fiatEstimate"
+
 // PaymentEventType represents types of payment events
 type PaymentEventType string
 
@@ -102,6 +161,14 @@ const (
 	PaymentEventSignerAttempt PaymentEventType = "signer_attempt"
 	PaymentEventSignerSuccess PaymentEventType = "signer_success"
 	PaymentEventSignerFailure PaymentEventType = "signer_failure"
+
+	// Finality of a settlement followed with FinalityConfig
+	PaymentEventFinalized       PaymentEventType = "finalized"
+	PaymentEventFinalityFailure PaymentEventType = "finality_failure"
+
+	// Signers disabled and re-enabled by SignerHealth checks
+	PaymentEventSignerDisabled PaymentEventType = "signer_disabled"
+	PaymentEventSignerEnabled  PaymentEventType = "signer_enabled"
 )
 
 // ClientPaymentOption represents a payment method the client accepts
@@ -114,4 +181,7 @@ type ClientPaymentOption struct {
 	MinBalance string   `json:"-"` // Don't use if balance falls below this
 	ChainID    *big.Int `json:"-"` // Chain ID for signing (EVM networks)
 	NetworkID  string   `json:"-"` // Network ID for non-EVM networks (e.g., "mainnet-beta", "devnet")
+
+	// AmountPolicy, if set, rejects requirements whose amounts it deems invalid
+	AmountPolicy *AmountPolicy `json:"-"`
 }
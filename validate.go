@@ -0,0 +1,212 @@
+package x402
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidConfig is matched by the error New returns for a Config that fails Validate
+var ErrInvalidConfig = errors.New("invalid transport configuration")
+
+// ConfigSeverity tells whether a ConfigIssue stops New
+type ConfigSeverity int
+
+const (
+	ConfigWarning ConfigSeverity = iota // Logged by New; fatal with StrictConfig
+	ConfigError                         // New fails
+)
+
+// String returns "warning" or "error"
+func (s ConfigSeverity) String() string {
+	if s == ConfigError {
+		return "error"
+	}
+	return "warning"
+}
+
+// ConfigIssue is a setting Validate found to be invalid, ignored or contradictory
+type ConfigIssue struct {
+	Severity ConfigSeverity
+	Field    string
+	Message  string
+
+	// Err is a sentinel the issue also matches, e.g. ErrNoSignerConfigured
+	Err error
+}
+
+// String returns the issue as "severity: Field: message"
+func (i ConfigIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Field, i.Message)
+}
+
+// ConfigValidationError is returned by New for a Config with errors, or with
+// warnings when StrictConfig is set. It matches ErrInvalidConfig and the Err of
+// its issues with errors.Is.
+type ConfigValidationError struct {
+	Issues []ConfigIssue
+}
+
+// Error lists the issues
+func (e *ConfigValidationError) Error() string {
+	messages := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		messages[i] = issue.String()
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidConfig, strings.Join(messages, "; "))
+}
+
+// Unwrap returns ErrInvalidConfig and the sentinels of the issues
+func (e *ConfigValidationError) Unwrap() []error {
+	errs := []error{ErrInvalidConfig}
+	for _, issue := range e.Issues {
+		if issue.Err != nil {
+			errs = append(errs, issue.Err)
+		}
+	}
+	return errs
+}
+
+// Validate checks the configuration without creating a transport, returning
+// errors for settings New can't work with and warnings for settings that are
+// ignored or contradict each other. New runs it, failing on errors and logging
+// warnings (failing on them too with StrictConfig).
+func (c Config) Validate() []ConfigIssue {
+	var issues []ConfigIssue
+	fail := func(field, format string, args ...any) {
+		issues = append(issues, ConfigIssue{Severity: ConfigError, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(field, format string, args ...any) {
+		issues = append(issues, ConfigIssue{Severity: ConfigWarning, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if serverURL, err := url.Parse(c.ServerURL); err != nil {
+		fail("ServerURL", "invalid server URL: %v", err)
+	} else if c.ServerURL != "" && serverURL.Scheme != "http" && serverURL.Scheme != "https" {
+		fail("ServerURL", "scheme %q is not http or https", serverURL.Scheme)
+	}
+
+	// Signers
+	switch {
+	case len(c.Signers) == 0 && c.Signer == nil:
+		issues = append(issues, ConfigIssue{Severity: ConfigError, Field: "Signers", Message: "no signer configured", Err: ErrNoSignerConfigured})
+	case len(c.Signers) > 0 && c.Signer != nil:
+		warn("Signer", "ignored because Signers is set")
+	}
+	seen := make(map[PaymentSigner]bool, len(c.Signers))
+	for i, signer := range c.Signers {
+		if signer == nil {
+			fail("Signers", "signer %d is nil", i)
+			continue
+		}
+		if seen[signer] {
+			warn("Signers", "signer %d (%s) is listed more than once", i, signer.GetAddress())
+		}
+		seen[signer] = true
+	}
+
+	// Negative limits
+	if c.MaxPaymentsPerRequest < 0 {
+		fail("MaxPaymentsPerRequest", "must not be negative")
+	}
+	if c.FailureBackoff < 0 || c.MaxFailureBackoff < 0 {
+		fail("FailureBackoff", "backoffs must not be negative")
+	} else if c.FailureBackoff > 0 && c.MaxFailureBackoff > 0 && c.MaxFailureBackoff < c.FailureBackoff {
+		warn("MaxFailureBackoff", "%s is shorter than FailureBackoff %s", c.MaxFailureBackoff, c.FailureBackoff)
+	}
+	if c.MaxPaymentOverhead < 0 {
+		fail("MaxPaymentOverhead", "must not be negative")
+	}
+	if c.MaxDeadlineExtension < 0 {
+		fail("MaxDeadlineExtension", "must not be negative")
+	} else if c.MaxDeadlineExtension > 0 && c.MaxPaymentOverhead == 0 {
+		warn("MaxDeadlineExtension", "ignored because MaxPaymentOverhead is not set")
+	}
+	if c.RequirementsCacheTTL < 0 {
+		fail("RequirementsCacheTTL", "must not be negative")
+	}
+	if c.LogSampleRate < 0 {
+		fail("LogSampleRate", "must not be negative")
+	}
+	if c.FailureAlertAfter < 0 {
+		fail("FailureAlertAfter", "must not be negative")
+	}
+	for name := range c.ExtraHeaders {
+		if isReservedHeader(name) {
+			warn("ExtraHeaders", "%s is set by the transport and ignored", name)
+		}
+	}
+	if !c.PaidAccept.valid() {
+		fail("PaidAccept", "unknown accept mode %d", int(c.PaidAccept))
+	}
+
+	// Settings that depend on others
+	if c.ResultIntegritySigner != "" && !c.VerifyResultIntegrity {
+		warn("ResultIntegritySigner", "ignored because VerifyResultIntegrity is false")
+	}
+	if c.OutputSchemaAction == OutputSchemaIgnore && c.OnOutputSchemaViolation != nil {
+		warn("OnOutputSchemaViolation", "never called because OutputSchemaAction is OutputSchemaIgnore")
+	}
+	if c.OutputSchemaAction == OutputSchemaRequestRefund && c.RefundRequester == nil {
+		warn("RefundRequester", "not set, so OutputSchemaRequestRefund can't request refunds")
+	}
+	if len(c.SpendThresholds) > 0 && len(c.EventSinks) == 0 {
+		warn("SpendThresholds", "ignored because no EventSinks are set")
+	}
+	for network, recipients := range c.TrustedRecipients {
+		if len(recipients) == 0 {
+			warn("TrustedRecipients", "no recipient is trusted on %s, so no payment there is made", network)
+		}
+	}
+	if c.ForceNetwork != "" && !signersSupportNetwork(c.signers(), c.ForceNetwork) {
+		warn("ForceNetwork", "no signer has a payment option on %s", c.ForceNetwork)
+	}
+
+	return issues
+}
+
+// signers returns the configured signers, falling back to the legacy Signer
+func (c Config) signers() []PaymentSigner {
+	if len(c.Signers) == 0 && c.Signer != nil {
+		return []PaymentSigner{c.Signer}
+	}
+	return c.Signers
+}
+
+// signersSupportNetwork reports whether a signer has a payment option on network.
+// Signers that can't list their options are assumed to support it.
+func signersSupportNetwork(signers []PaymentSigner, network string) bool {
+	for _, signer := range signers {
+		if signer == nil {
+			continue
+		}
+		lister, ok := signer.(PaymentOptionLister)
+		if !ok {
+			return true
+		}
+		for _, option := range lister.PaymentOptions() {
+			if option.Network == network {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkConfig validates config for New, logging warnings to logger. It returns a
+// *ConfigValidationError with the issues that stop New.
+func checkConfig(config Config, logger Logger) error {
+	var fatal []ConfigIssue
+	for _, issue := range config.Validate() {
+		if issue.Severity == ConfigError || config.StrictConfig {
+			fatal = append(fatal, issue)
+			continue
+		}
+		logger.Printf("[X402] Config %s", issue)
+	}
+	if len(fatal) > 0 {
+		return &ConfigValidationError{Issues: fatal}
+	}
+	return nil
+}